@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -13,22 +14,94 @@ import (
 	"gamifykit/core"
 )
 
+// Durability selects when a Store writes its state to disk. Every mode rewrites the whole file
+// through a temporary file and a rename, so a crash never leaves a half-written file behind; the
+// modes differ in how many acknowledged writes a crash can lose.
+type Durability string
+
+const (
+	// DurabilitySync writes and fsyncs the file before each write returns. A crash loses nothing
+	// that was acknowledged, but every write pays for rewriting and syncing the whole file. This
+	// is the default.
+	DurabilitySync Durability = "sync"
+	// DurabilityInterval writes changes every flush interval and on Close. A crash loses at most
+	// the writes of the last interval.
+	DurabilityInterval Durability = "interval"
+	// DurabilityOnShutdown writes only on Close. It is the fastest, but a crash loses every write
+	// since the store was opened.
+	DurabilityOnShutdown Durability = "on_shutdown"
+)
+
+// DefaultFlushInterval is how often DurabilityInterval writes changes unless WithFlushInterval says otherwise.
+const DefaultFlushInterval = time.Second
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithDurability sets when the store writes to disk (DurabilitySync when empty).
+func WithDurability(d Durability) Option {
+	return func(s *Store) {
+		if d != "" {
+			s.durability = d
+		}
+	}
+}
+
+// WithFlushInterval sets how often DurabilityInterval writes changes (DefaultFlushInterval when
+// not positive).
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *Store) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// ValidDurability reports whether d names a durability mode; empty means the default.
+func ValidDurability(d Durability) bool {
+	switch d {
+	case "", DurabilitySync, DurabilityInterval, DurabilityOnShutdown:
+		return true
+	}
+	return false
+}
+
 // Store persists entire state to a single JSON file.
-// Suitable for demos and small deployments.
+// Suitable for demos and small deployments. Call Close on shutdown: unless the durability is
+// DurabilitySync, it writes the changes not yet on disk. Operations whose context is done fail
+// with its error without touching the state.
 type Store struct {
-	path string
-	mu   sync.Mutex
+	path       string
+	durability Durability
+	interval   time.Duration
+	mu         sync.Mutex
 	// in-memory cache for speed
 	data map[core.UserID]core.UserState
+	// dirty is set while data holds changes not yet written
+	dirty  bool
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
 }
 
-func New(path string) (*Store, error) {
-	s := &Store{path: path, data: map[core.UserID]core.UserState{}}
+// New opens the store at path, loading the file if it exists.
+func New(path string, opts ...Option) (*Store, error) {
+	s := &Store{path: path, durability: DurabilitySync, interval: DefaultFlushInterval, data: map[core.UserID]core.UserState{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !ValidDurability(s.durability) {
+		return nil, fmt.Errorf("unknown durability %q", s.durability)
+	}
 	if err := s.load(); err != nil {
 		if !errors.Is(err, fs.ErrNotExist) {
 			return nil, err
 		}
 	}
+	if s.durability == DurabilityInterval {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.flushLoop()
+	}
 	return s, nil
 }
 
@@ -42,11 +115,23 @@ func (s *Store) load() error {
 		return err
 	}
 	for k, v := range raw {
+		v.Updated = core.Timestamp(v.Updated)
 		s.data[core.UserID(k)] = v
 	}
 	return nil
 }
 
+// commit makes a write durable as the durability mode requires. Callers hold mu.
+func (s *Store) commit() error {
+	if s.durability != DurabilitySync {
+		s.dirty = true
+		return nil
+	}
+	return s.persist()
+}
+
+// persist writes the whole state to a temporary file, syncs it and renames it over the store's
+// file. Callers hold mu.
 func (s *Store) persist() error {
 	tmp := s.path + ".tmp"
 	raw := make(map[string]core.UserState, len(s.data))
@@ -57,25 +142,102 @@ func (s *Store) persist() error {
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(s.path), 0o750); err != nil {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) // #nosec G304 - path comes from operator configuration
+	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+	if _, err := f.Write(b); err != nil {
+		f.Close()
 		return err
 	}
-	return os.Rename(tmp, s.path)
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	// sync the directory so the rename itself survives a crash
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	s.dirty = false
+	return nil
+}
+
+// Flush writes changes not yet on disk now.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// flush writes the state if it has unwritten changes. Callers hold mu.
+func (s *Store) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.persist(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// flushLoop writes changes every interval until Close
+func (s *Store) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			_ = s.flush() // retried on the next tick; Close reports a lasting failure
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close stops background flushing and writes the changes not yet on disk. The store must not be
+// written to afterwards.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return s.Flush()
 }
 
 func (s *Store) get(user core.UserID) core.UserState {
 	if st, ok := s.data[user]; ok {
 		return st
 	}
-	st := core.UserState{UserID: user, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{}, Updated: time.Now().UTC()}
+	st := core.UserState{UserID: user, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{}, Updated: core.Now()}
 	s.data[user] = st
 	return st
 }
 
-func (s *Store) AddPoints(_ context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+func (s *Store) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	st := s.get(user)
@@ -84,37 +246,235 @@ func (s *Store) AddPoints(_ context.Context, user core.UserID, metric core.Metri
 		return 0, err
 	}
 	st.Points[metric] = next
-	st.Updated = time.Now().UTC()
+	st.Updated = core.Now()
 	s.data[user] = st
-	if err := s.persist(); err != nil {
+	if err := s.commit(); err != nil {
 		return 0, err
 	}
 	return next, nil
 }
 
-func (s *Store) AwardBadge(_ context.Context, user core.UserID, badge core.Badge) error {
+// UpdatePoints sets the user's total of metric to fn(current) under the store's lock, so no other
+// write slips in between; see engine.PointsUpdater.
+func (s *Store) UpdatePoints(ctx context.Context, user core.UserID, metric core.Metric, fn func(int64) (int64, error)) (int64, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	current := st.Points[metric]
+	next, err := fn(current)
+	if err != nil || next == current {
+		return current, current, err
+	}
+	st.Points[metric] = next
+	st.Updated = core.Now()
+	s.data[user] = st
+	if err := s.commit(); err != nil {
+		return 0, 0, err
+	}
+	return current, next, nil
+}
+
+func (s *Store) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+	_, err := s.TryAwardBadge(ctx, user, badge)
+	return err
+}
+
+// TryAwardBadge awards badge and reports whether user did not hold it yet.
+func (s *Store) TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	st := s.get(user)
+	if _, ok := st.Badges[badge]; ok {
+		return false, nil
+	}
 	st.Badges[badge] = struct{}{}
-	st.Updated = time.Now().UTC()
+	st.Updated = core.Now()
 	s.data[user] = st
-	return s.persist()
+	if err := s.commit(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (s *Store) GetState(_ context.Context, user core.UserID) (core.UserState, error) {
+// RemoveBadge takes badge away from user and reports whether they held it.
+func (s *Store) RemoveBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	if _, ok := st.Badges[badge]; !ok {
+		return false, nil
+	}
+	delete(st.Badges, badge)
+	st.Updated = core.Now()
+	s.data[user] = st
+	return true, s.commit()
+}
+
+func (s *Store) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+	if err := ctx.Err(); err != nil {
+		return core.UserState{}, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	st := s.get(user)
 	return st.Clone(), nil
 }
 
-func (s *Store) SetLevel(_ context.Context, user core.UserID, metric core.Metric, level int64) error {
+func (s *Store) SetLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	st := s.get(user)
 	st.Levels[metric] = level
-	st.Updated = time.Now().UTC()
+	st.Updated = core.Now()
 	s.data[user] = st
-	return s.persist()
+	return s.commit()
+}
+
+// RaiseLevel stores level if it is above the user's current level of metric; see engine.LevelRaiser.
+func (s *Store) RaiseLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	if st.Levels[metric] >= level {
+		return false, nil
+	}
+	st.Levels[metric] = level
+	st.Updated = core.Now()
+	s.data[user] = st
+	return true, s.commit()
+}
+
+// TransferPoints moves amount points of metric from one user to another in a single write of the file.
+// Nothing is written if the sender would drop below floor or the receiver rise above ceiling.
+func (s *Store) TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (int64, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+	if from == to {
+		return 0, 0, core.ErrSelfTransfer
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, dst := s.get(from), s.get(to)
+	have, had := src.Points[metric], dst.Points[metric]
+	if have < floor || have-floor < amount {
+		return 0, 0, fmt.Errorf("%w: %s has %d %s, cannot send %d", core.ErrInsufficientPoints, from, have, metric, amount)
+	}
+	received, err := core.AddSafe(had, amount)
+	if err != nil || received > ceiling {
+		return 0, 0, fmt.Errorf("%w: %s cannot receive %d %s", core.ErrReceiverLimit, to, amount, metric)
+	}
+	now := core.Now()
+	src.Points[metric], src.Updated = have-amount, now
+	dst.Points[metric], dst.Updated = received, now
+	s.data[from], s.data[to] = src, dst
+	if err := s.commit(); err != nil {
+		src.Points[metric], dst.Points[metric] = have, had
+		return 0, 0, err
+	}
+	return have - amount, received, nil
+}
+
+// EachUser calls fn for every stored user. fn runs on a snapshot, so it may call back into the store.
+func (s *Store) EachUser(ctx context.Context, fn func(core.UserID) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	users := make([]core.UserID, 0, len(s.data))
+	for u := range s.data {
+		users = append(users, u)
+	}
+	s.mu.Unlock()
+	for _, u := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exists reports whether the user has any points, badges or levels.
+func (s *Store) Exists(ctx context.Context, user core.UserID) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.data[user]
+	return ok && (len(st.Points) > 0 || len(st.Badges) > 0 || len(st.Levels) > 0), nil
+}
+
+// ReplaceState overwrites the user's points, badges and levels with state.
+func (s *Store) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := state.Clone()
+	next.UserID = user
+	next.Updated = core.Now()
+	s.data[user] = next
+	return s.commit()
+}
+
+// MergeUsers writes merge(source, target) as the target's state and deletes the source in a single
+// write of the file. If the write fails both users are left as they were.
+func (s *Store) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (core.UserState, core.UserState, error) {
+	if err := ctx.Err(); err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	if source == target {
+		return core.UserState{}, core.UserState{}, core.ErrSelfMerge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, hadSource := s.data[source]
+	dst, hadTarget := s.data[target]
+	if !hadSource {
+		src = core.UserState{UserID: source}
+	}
+	if !hadTarget {
+		dst = core.UserState{UserID: target, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{}}
+	}
+	next, err := merge(src.Clone(), dst.Clone())
+	if err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	next = next.Clone()
+	next.UserID = target
+	next.Updated = core.Now()
+	s.data[target] = next
+	delete(s.data, source)
+	if err := s.commit(); err != nil {
+		if hadSource {
+			s.data[source] = src
+		}
+		if hadTarget {
+			s.data[target] = dst
+		} else {
+			delete(s.data, target)
+		}
+		return core.UserState{}, core.UserState{}, err
+	}
+	return dst.Clone(), next.Clone(), nil
 }
@@ -0,0 +1,91 @@
+package jsonfile
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gamifykit/adapters/storagetest"
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) engine.Storage {
+		s, err := New(filepath.Join(t.TempDir(), "state.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPoints(context.Background(), "u", core.MetricXP, 7); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := reopened.GetState(context.Background(), "u"); st.Points[core.MetricXP] != 7 {
+		t.Fatalf("state not persisted: %v", st.Points)
+	}
+}
+
+func TestDurability(t *testing.T) {
+	ctx := context.Background()
+	points := func(path string) int64 {
+		reopened, err := New(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, _ := reopened.GetState(ctx, "u")
+		return st.Points[core.MetricXP]
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := New(path, WithDurability(DurabilityOnShutdown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPoints(ctx, "u", core.MetricXP, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := points(path); got != 0 {
+		t.Fatalf("on_shutdown wrote before Close: %d", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := points(path); got != 3 {
+		t.Fatalf("Close did not flush: %d", got)
+	}
+
+	path = filepath.Join(t.TempDir(), "state.json")
+	s, err = New(path, WithDurability(DurabilityInterval), WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.AddPoints(ctx, "u", core.MetricXP, 5); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for points(path) != 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("interval mode never flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := New(path, WithDurability("eventually")); err == nil {
+		t.Fatal("unknown durability accepted")
+	}
+}
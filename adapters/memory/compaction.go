@@ -0,0 +1,69 @@
+package memory
+
+import (
+    "context"
+    "time"
+)
+
+// DefaultCompactionInterval is how often RunCompaction compacts when no interval is given.
+const DefaultCompactionInterval = 10 * time.Minute
+
+// SetHistoryLimit caps the point increments kept per user and metric for PointsInWindow; every
+// write drops the oldest beyond the cap, and Compact trims histories written before the cap was
+// set. Windowed sums of users who hit the cap only cover their most recent n increments. Zero
+// (the default) keeps everything within the retention.
+func (s *Store) SetHistoryLimit(n int) { s.maxHistory = n }
+
+// SetClock overrides the clock used to timestamp and expire point increments (core.CurrentTime
+// by default; useful for tests).
+func (s *Store) SetClock(now func() time.Time) { s.now = now }
+
+// Compact drops point increments that are older than the retention or beyond the history limit
+// and returns how many it dropped. Users are compacted one at a time, each only locked while its
+// own history is trimmed, so writes proceed concurrently. Writes only prune the metric they touch,
+// so without compaction the history of idle users and metrics is never released.
+func (s *Store) Compact(ctx context.Context) (int, error) {
+    pruned := 0
+    var err error
+    s.users.Range(func(_, v any) bool {
+        if err = ctx.Err(); err != nil { return false }
+        pruned += s.compactUser(v.(*userRecord))
+        return true
+    })
+    return pruned, err
+}
+
+func (s *Store) compactUser(rec *userRecord) int {
+    rec.mu.Lock(); defer rec.mu.Unlock()
+    now, limit := s.now(), s.maxHistory
+    pruned := 0
+    for metric, hist := range rec.history {
+        kept := s.prune(hist, now)
+        if limit > 0 && len(kept) > limit { kept = append(kept[:0:0], kept[len(kept)-limit:]...) }
+        pruned += len(hist) - len(kept)
+        if len(kept) == 0 {
+            delete(rec.history, metric)
+        } else {
+            rec.history[metric] = kept
+        }
+    }
+    return pruned
+}
+
+// RunCompaction calls Compact every interval (DefaultCompactionInterval when zero) until ctx is
+// done, reporting the increments dropped by each pass to onPruned (if not nil), e.g. to update a
+// counter.
+func (s *Store) RunCompaction(ctx context.Context, interval time.Duration, onPruned func(n int)) {
+    if interval <= 0 { interval = DefaultCompactionInterval }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            n, _ := s.Compact(ctx)
+            if onPruned != nil { onPruned(n) }
+        }
+    }
+}
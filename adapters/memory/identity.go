@@ -0,0 +1,48 @@
+package memory
+
+import (
+    "context"
+    "sort"
+
+    "gamifykit/core"
+)
+
+// LinkIdentity links the external ID to user unless it is already linked, and returns the user
+// it is linked to.
+func (s *Store) LinkIdentity(ctx context.Context, identity string, user core.UserID) (core.UserID, error) {
+    if err := ctx.Err(); err != nil { return "", err }
+    s.idMu.Lock(); defer s.idMu.Unlock()
+    if holder, ok := s.identities[identity]; ok { return holder, nil }
+    if s.identities == nil { s.identities = map[string]core.UserID{} }
+    s.identities[identity] = user
+    return user, nil
+}
+
+// UnlinkIdentity removes the external ID's link and reports whether there was one.
+func (s *Store) UnlinkIdentity(ctx context.Context, identity string) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    s.idMu.Lock(); defer s.idMu.Unlock()
+    _, ok := s.identities[identity]
+    delete(s.identities, identity)
+    return ok, nil
+}
+
+// ResolveIdentity returns the user the external ID is linked to.
+func (s *Store) ResolveIdentity(ctx context.Context, identity string) (core.UserID, bool, error) {
+    if err := ctx.Err(); err != nil { return "", false, err }
+    s.idMu.Lock(); defer s.idMu.Unlock()
+    user, ok := s.identities[identity]
+    return user, ok, nil
+}
+
+// Identities returns the external IDs linked to user, sorted. It scans every link.
+func (s *Store) Identities(ctx context.Context, user core.UserID) ([]string, error) {
+    if err := ctx.Err(); err != nil { return nil, err }
+    s.idMu.Lock(); defer s.idMu.Unlock()
+    identities := []string{}
+    for identity, holder := range s.identities {
+        if holder == user { identities = append(identities, identity) }
+    }
+    sort.Strings(identities)
+    return identities, nil
+}
@@ -0,0 +1,56 @@
+package memory
+
+import (
+    "context"
+    "time"
+
+    "gamifykit/core"
+)
+
+// MergeUsers writes merge(source, target) as the target's state and deletes the source, with both
+// users locked (always in ID order, like TransferPoints), so EachUser no longer lists it. Badges keep their earliest record: the
+// target's award time and repeat count when it held the badge, the source's otherwise. The
+// target's point history and quest progress are kept and the source's dropped; the source's
+// identity links move to the target.
+func (s *Store) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (core.UserState, core.UserState, error) {
+    if err := ctx.Err(); err != nil { return core.UserState{}, core.UserState{}, err }
+    if source == target { return core.UserState{}, core.UserState{}, core.ErrSelfMerge }
+    _, existed := s.users.Load(source)
+    src, dst, unlock := s.lockPair(source, target)
+    defer unlock()
+
+    before := dst.state.Clone()
+    next, err := merge(src.state.Clone(), dst.state.Clone())
+    if err != nil {
+        if !existed { s.remove(source, src) }
+        return core.UserState{}, core.UserState{}, err
+    }
+    next = next.Clone()
+    next.UserID = target
+    next.Updated = core.Now()
+    awarded, now := make(map[core.Badge]time.Time, len(next.Badges)), core.Timestamp(s.now())
+    for badge := range next.Badges {
+        if at, ok := dst.awarded[badge]; ok {
+            awarded[badge] = at
+        } else if at, ok := src.awarded[badge]; ok {
+            awarded[badge] = at
+        } else {
+            awarded[badge] = now
+        }
+    }
+    for badge, rec := range src.awards {
+        if _, ok := dst.awards[badge]; ok { continue }
+        if dst.awards == nil { dst.awards = map[core.Badge]core.BadgeRecord{} }
+        dst.awards[badge] = rec
+    }
+    dst.state, dst.awarded = next, awarded
+    src.state, src.history, src.awards, src.awarded, src.quests = emptyState(source), nil, nil, nil, nil
+    s.remove(source, src)
+
+    s.idMu.Lock()
+    for identity, holder := range s.identities {
+        if holder == source { s.identities[identity] = target }
+    }
+    s.idMu.Unlock()
+    return before, next.Clone(), nil
+}
@@ -0,0 +1,44 @@
+package memory
+
+import (
+    "context"
+    "sort"
+
+    "gamifykit/core"
+)
+
+// AdvanceQuest adds delta to the user's progress on quest in period, capped at target, starting
+// afresh when the stored progress is of another period.
+func (s *Store) AdvanceQuest(ctx context.Context, user core.UserID, quest, period string, delta, target int64) (core.QuestProgress, int64, error) {
+    if err := ctx.Err(); err != nil { return core.QuestProgress{}, 0, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    if rec.quests == nil { rec.quests = map[string]core.QuestProgress{} }
+    p, ok := rec.quests[quest]
+    if !ok || p.Period != period { p = core.QuestProgress{Quest: quest, Period: period} }
+    before := p.Progress
+    if before < target {
+        p.Progress = min(before+delta, target)
+        if p.Progress == target {
+            at := core.Timestamp(s.now())
+            p.CompletedAt = &at
+        }
+    }
+    p.Target, p.Completed = target, p.Progress >= target
+    rec.quests[quest] = p
+    return p, before, nil
+}
+
+// QuestProgress returns the user's progress records, sorted by quest.
+func (s *Store) QuestProgress(ctx context.Context, user core.UserID) ([]core.QuestProgress, error) {
+    if err := ctx.Err(); err != nil { return nil, err }
+    progress := []core.QuestProgress{}
+    v, ok := s.users.Load(user)
+    if !ok { return progress, nil }
+    rec := v.(*userRecord)
+    rec.mu.Lock()
+    for _, p := range rec.quests { progress = append(progress, p) }
+    rec.mu.Unlock()
+    sort.Slice(progress, func(i, j int) bool { return progress[i].Quest < progress[j].Quest })
+    return progress, nil
+}
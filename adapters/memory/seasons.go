@@ -0,0 +1,20 @@
+package memory
+
+import "context"
+
+// ActiveSeason returns the season stored by SwitchSeason, "" if none was.
+func (s *Store) ActiveSeason(ctx context.Context) (string, error) {
+    if err := ctx.Err(); err != nil { return "", err }
+    s.seasonMu.Lock(); defer s.seasonMu.Unlock()
+    return s.season, nil
+}
+
+// SwitchSeason stores next as the active season if previous still is, so services sharing the
+// store agree on one season.
+func (s *Store) SwitchSeason(ctx context.Context, previous, next string) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    s.seasonMu.Lock(); defer s.seasonMu.Unlock()
+    if s.season != previous { return false, nil }
+    s.season = next
+    return true, nil
+}
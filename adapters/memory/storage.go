@@ -2,73 +2,386 @@ package memory
 
 import (
     "context"
+    "fmt"
+    "sort"
     "sync"
     "time"
 
     "gamifykit/core"
 )
 
-// Store is a concurrent in-memory Storage implementation.
+// Store is a concurrent in-memory Storage implementation. Operations never block on I/O, so they
+// only check the context when they start: one whose context is done fails with its error.
 type Store struct {
-    users sync.Map // map[core.UserID]*userRecord
+    users      sync.Map // map[core.UserID]*userRecord
+    retention  time.Duration
+    maxHistory int
+    now        func() time.Time
+    idMu       sync.Mutex
+    identities map[string]core.UserID // external ID -> user, see LinkIdentity
+    seasonMu   sync.Mutex
+    season     string // see SwitchSeason
 }
 
 type userRecord struct {
-    mu     sync.Mutex
-    state  core.UserState
+    mu      sync.Mutex
+    removed bool // dropped from the store, e.g. the source of a merge; writers retry on a fresh record
+    state   core.UserState
+    history map[core.Metric][]increment // oldest first, for windowed queries
+    awards  map[core.Badge]core.BadgeRecord // repeatable badges
+    awarded map[core.Badge]time.Time // when each held badge was (last) awarded
+    quests  map[string]core.QuestProgress // latest period's progress per quest
 }
 
-func New() *Store { return &Store{} }
+// increment is one timestamped AddPoints delta
+type increment struct {
+    at    time.Time
+    delta int64
+}
+
+func New() *Store { return &Store{retention: core.DefaultPointsRetention, now: core.CurrentTime} }
+
+// SetPointsRetention sets how long point increments are kept for PointsInWindow.
+func (s *Store) SetPointsRetention(d time.Duration) { s.retention = d }
 
+// getOrCreate returns the user's record, creating it on first write. The record's maps are fully
+// built before it is published, and LoadOrStore makes racing creators agree on one record, so a
+// concurrent reader never sees a partially initialized user.
 func (s *Store) getOrCreate(user core.UserID) *userRecord {
     if v, ok := s.users.Load(user); ok {
         return v.(*userRecord)
     }
-    rec := &userRecord{state: core.UserState{
+    actual, _ := s.users.LoadOrStore(user, &userRecord{state: emptyState(user)})
+    return actual.(*userRecord)
+}
+
+// lock returns the user's record locked for writing, creating it on first write. A record removed
+// while the caller waited for its lock is skipped for the one now in the store.
+func (s *Store) lock(user core.UserID) *userRecord {
+    for {
+        rec := s.getOrCreate(user)
+        rec.mu.Lock()
+        if !rec.removed { return rec }
+        rec.mu.Unlock()
+    }
+}
+
+// lockPair locks the records of two distinct users, always in ID order so that opposite calls
+// cannot deadlock, and returns them with the function unlocking both.
+func (s *Store) lockPair(a, b core.UserID) (*userRecord, *userRecord, func()) {
+    for {
+        ra, rb := s.getOrCreate(a), s.getOrCreate(b)
+        first, second := ra, rb
+        if b < a { first, second = rb, ra }
+        first.mu.Lock()
+        second.mu.Lock()
+        if !ra.removed && !rb.removed { return ra, rb, func(){ second.mu.Unlock(); first.mu.Unlock() } }
+        second.mu.Unlock()
+        first.mu.Unlock()
+    }
+}
+
+// remove drops user's record from the store; rec must be locked by the caller
+func (s *Store) remove(user core.UserID, rec *userRecord) {
+    rec.removed = true
+    s.users.CompareAndDelete(user, rec)
+}
+
+func emptyState(user core.UserID) core.UserState {
+    return core.UserState{
         UserID: user,
         Points: map[core.Metric]int64{},
         Badges: map[core.Badge]struct{}{},
         Levels: map[core.Metric]int64{},
-        Updated: time.Now().UTC(),
-    }}
-    actual, _ := s.users.LoadOrStore(user, rec)
-    return actual.(*userRecord)
+        Updated: core.Now(),
+    }
 }
 
-func (s *Store) AddPoints(_ context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
-    rec := s.getOrCreate(user)
-    rec.mu.Lock()
+func (s *Store) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    if err := ctx.Err(); err != nil { return 0, err }
+    rec := s.lock(user)
     defer rec.mu.Unlock()
     current := rec.state.Points[metric]
     next, err := core.AddSafe(current, delta)
     if err != nil { return 0, err }
     rec.state.Points[metric] = next
-    rec.state.Updated = time.Now().UTC()
+    now := s.now()
+    rec.state.Updated = core.Timestamp(now)
+    s.record(rec, metric, now, delta)
     return next, nil
 }
 
-func (s *Store) AwardBadge(_ context.Context, user core.UserID, badge core.Badge) error {
-    rec := s.getOrCreate(user)
+// UpdatePoints sets the user's total of metric to fn(current) with the user's record locked, so
+// no other write slips in between; see engine.PointsUpdater.
+func (s *Store) UpdatePoints(ctx context.Context, user core.UserID, metric core.Metric, fn func(int64) (int64, error)) (int64, int64, error) {
+    if err := ctx.Err(); err != nil { return 0, 0, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    current := rec.state.Points[metric]
+    next, err := fn(current)
+    if err != nil || next == current { return current, current, err }
+    delta, err := core.SubSafe(next, current)
+    if err != nil { return 0, 0, err }
+    rec.state.Points[metric] = next
+    now := s.now()
+    rec.state.Updated = core.Timestamp(now)
+    s.record(rec, metric, now, delta)
+    return current, next, nil
+}
+
+// TransferPoints moves amount points of metric from one user to another with both users locked
+// (always in ID order, so opposite transfers cannot deadlock). Nothing is written if the sender
+// would drop below floor or the receiver rise above ceiling.
+func (s *Store) TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (int64, int64, error) {
+    if err := ctx.Err(); err != nil { return 0, 0, err }
+    if from == to { return 0, 0, core.ErrSelfTransfer }
+    src, dst, unlock := s.lockPair(from, to)
+    defer unlock()
+
+    have := src.state.Points[metric]
+    if have < floor || have-floor < amount {
+        return 0, 0, fmt.Errorf("%w: %s has %d %s, cannot send %d", core.ErrInsufficientPoints, from, have, metric, amount)
+    }
+    received, err := core.AddSafe(dst.state.Points[metric], amount)
+    if err != nil || received > ceiling {
+        return 0, 0, fmt.Errorf("%w: %s cannot receive %d %s", core.ErrReceiverLimit, to, amount, metric)
+    }
+
+    now := s.now()
+    for _, side := range []struct{ rec *userRecord; total, delta int64 }{{src, have - amount, -amount}, {dst, received, amount}} {
+        side.rec.state.Points[metric] = side.total
+        side.rec.state.Updated = core.Timestamp(now)
+        s.record(side.rec, metric, now, side.delta)
+    }
+    return have - amount, received, nil
+}
+
+// PointsInWindow sums the user's increments of metric within the last window, pruning expired ones.
+func (s *Store) PointsInWindow(ctx context.Context, user core.UserID, metric core.Metric, window time.Duration) (int64, error) {
+    if err := ctx.Err(); err != nil { return 0, err }
+    if window > s.retention { return 0, core.ErrWindowTooLong }
+    v, ok := s.users.Load(user)
+    if !ok { return 0, nil }
+    rec := v.(*userRecord)
     rec.mu.Lock(); defer rec.mu.Unlock()
+    now := s.now()
+    kept := s.prune(rec.history[metric], now)
+    if rec.history != nil { rec.history[metric] = kept }
+    from := now.Add(-window)
+    var sum int64
+    for i := len(kept) - 1; i >= 0 && !kept[i].at.Before(from); i-- {
+        sum += kept[i].delta
+    }
+    return sum, nil
+}
+
+// PointsPerPeriod sums the user's increments of metric in each of the last n periods, most recent first.
+func (s *Store) PointsPerPeriod(ctx context.Context, user core.UserID, metric core.Metric, period time.Duration, n int) ([]int64, error) {
+    if err := ctx.Err(); err != nil { return nil, err }
+    if period <= 0 || n <= 0 { return nil, fmt.Errorf("period and count must be positive, got %s and %d", period, n) }
+    if period*time.Duration(n) > s.retention { return nil, core.ErrWindowTooLong }
+    sums := make([]int64, n)
+    v, ok := s.users.Load(user)
+    if !ok { return sums, nil }
+    rec := v.(*userRecord)
+    rec.mu.Lock(); defer rec.mu.Unlock()
+    now := s.now()
+    for i := len(rec.history[metric]) - 1; i >= 0; i-- {
+        inc := rec.history[metric][i]
+        age := now.Sub(inc.at)
+        if age < 0 { age = 0 }
+        bucket := int(age / period)
+        if bucket >= n { break }
+        sums[bucket] += inc.delta
+    }
+    return sums, nil
+}
+
+// record appends an increment to the user's history of metric, dropping those older than the
+// retention or beyond the history limit; the caller holds rec.mu
+func (s *Store) record(rec *userRecord, metric core.Metric, now time.Time, delta int64) {
+    if rec.history == nil { rec.history = map[core.Metric][]increment{} }
+    hist := append(s.prune(rec.history[metric], now), increment{at: now, delta: delta})
+    // reslicing drops the oldest in place; the next reallocation copies only the kept ones
+    if limit := s.maxHistory; limit > 0 && len(hist) > limit { hist = hist[len(hist)-limit:] }
+    rec.history[metric] = hist
+}
+
+// prune drops increments older than the retention
+func (s *Store) prune(hist []increment, now time.Time) []increment {
+    cutoff := now.Add(-s.retention)
+    i := 0
+    for i < len(hist) && hist[i].at.Before(cutoff) { i++ }
+    if i == 0 { return hist }
+    return append(hist[:0:0], hist[i:]...)
+}
+
+func (s *Store) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+    _, err := s.TryAwardBadge(ctx, user, badge)
+    return err
+}
+
+// TryAwardBadge awards badge and reports whether user did not hold it yet.
+func (s *Store) TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    if _, ok := rec.state.Badges[badge]; ok { return false, nil }
     rec.state.Badges[badge] = struct{}{}
-    rec.state.Updated = time.Now().UTC()
-    return nil
+    rec.markAwarded(badge, s.now())
+    rec.state.Updated = core.Now()
+    return true, nil
 }
 
-func (s *Store) GetState(_ context.Context, user core.UserID) (core.UserState, error) {
-    rec := s.getOrCreate(user)
+// RepeatBadge awards badge and counts the award unless the last one lies within cooldown.
+func (s *Store) RepeatBadge(ctx context.Context, user core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (core.BadgeRecord, bool, error) {
+    if err := ctx.Err(); err != nil { return core.BadgeRecord{}, false, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    award := rec.awards[badge]
+    if award.Count > 0 && now.Before(award.LastAwarded.Add(cooldown)) { return award, false, nil }
+    award.Count++
+    award.LastAwarded = now
+    if rec.awards == nil { rec.awards = map[core.Badge]core.BadgeRecord{} }
+    rec.awards[badge] = award
+    rec.state.Badges[badge] = struct{}{}
+    rec.markAwarded(badge, now)
+    rec.state.Updated = core.Now()
+    return award, true, nil
+}
+
+// BadgeRecord returns the award count and last award time of a repeatable badge.
+func (s *Store) BadgeRecord(ctx context.Context, user core.UserID, badge core.Badge) (core.BadgeRecord, error) {
+    if err := ctx.Err(); err != nil { return core.BadgeRecord{}, err }
+    v, ok := s.users.Load(user)
+    if !ok { return core.BadgeRecord{}, nil }
+    rec := v.(*userRecord)
     rec.mu.Lock(); defer rec.mu.Unlock()
-    return rec.state.Clone(), nil
+    return rec.awards[badge], nil
 }
 
-func (s *Store) SetLevel(_ context.Context, user core.UserID, metric core.Metric, level int64) error {
-    rec := s.getOrCreate(user)
+// RemoveBadge takes badge away from user and reports whether they held it.
+func (s *Store) RemoveBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    if _, ok := rec.state.Badges[badge]; !ok { return false, nil }
+    delete(rec.state.Badges, badge)
+    delete(rec.awarded, badge)
+    rec.state.Updated = core.Now()
+    return true, nil
+}
+
+// GetState returns a deep copy of the user's state, taken under the user's lock, so callers may
+// keep or modify it while other goroutines write. Reading an unknown user does not create it.
+func (s *Store) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+    if err := ctx.Err(); err != nil { return core.UserState{}, err }
+    v, ok := s.users.Load(user)
+    if !ok { return emptyState(user), nil }
+    rec := v.(*userRecord)
     rec.mu.Lock(); defer rec.mu.Unlock()
+    return rec.state.Clone(), nil
+}
+
+func (s *Store) SetLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) error {
+    if err := ctx.Err(); err != nil { return err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
     rec.state.Levels[metric] = level
-    rec.state.Updated = time.Now().UTC()
+    rec.state.Updated = core.Now()
     return nil
 }
 
+// RaiseLevel stores level if it is above the user's current level of metric; see engine.LevelRaiser.
+func (s *Store) RaiseLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    if rec.state.Levels[metric] >= level { return false, nil }
+    rec.state.Levels[metric] = level
+    rec.state.Updated = core.Now()
+    return true, nil
+}
+
+// ReplaceState overwrites the user's points, badges and levels with state.
+func (s *Store) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error {
+    if err := ctx.Err(); err != nil { return err }
+    rec := s.lock(user)
+    defer rec.mu.Unlock()
+    next := state.Clone()
+    next.UserID = user
+    next.Updated = core.Now()
+    // badges the user keeps keep their award time; new ones are awarded now
+    awarded, now := make(map[core.Badge]time.Time, len(next.Badges)), core.Timestamp(s.now())
+    for badge := range next.Badges {
+        if at, ok := rec.awarded[badge]; ok { awarded[badge] = at } else { awarded[badge] = now }
+    }
+    rec.state, rec.awarded = next, awarded
+    return nil
+}
+
+// ListBadges returns the badges user holds with their award times, newest first.
+func (s *Store) ListBadges(ctx context.Context, user core.UserID, filter core.BadgeFilter) ([]core.BadgeAward, error) {
+    if err := ctx.Err(); err != nil { return nil, err }
+    v, ok := s.users.Load(user)
+    if !ok { return []core.BadgeAward{}, nil }
+    rec := v.(*userRecord)
+    rec.mu.Lock()
+    awards := make([]core.BadgeAward, 0, len(rec.state.Badges))
+    for badge := range rec.state.Badges {
+        awards = append(awards, core.BadgeAward{Badge: badge, AwardedAt: rec.awarded[badge]})
+    }
+    rec.mu.Unlock()
+    return filter.Apply(awards), nil
+}
+
+// markAwarded records when badge was awarded; the caller holds rec.mu
+func (rec *userRecord) markAwarded(badge core.Badge, at time.Time) {
+    if rec.awarded == nil { rec.awarded = map[core.Badge]time.Time{} }
+    rec.awarded[badge] = core.Timestamp(at)
+}
+
+// Exists reports whether the user has any points, badges or levels. Records left empty, e.g. by
+// removing a badge the user never held, do not count.
+func (s *Store) Exists(ctx context.Context, user core.UserID) (bool, error) {
+    if err := ctx.Err(); err != nil { return false, err }
+    v, ok := s.users.Load(user)
+    if !ok { return false, nil }
+    rec := v.(*userRecord)
+    rec.mu.Lock(); defer rec.mu.Unlock()
+    return len(rec.state.Points) > 0 || len(rec.state.Badges) > 0 || len(rec.state.Levels) > 0, nil
+}
+
+// QueryUsers scans every user held in memory for the ones matching filter, in user ID order.
+func (s *Store) QueryUsers(ctx context.Context, filter core.UserFilter) ([]core.UserID, error) {
+    if err := ctx.Err(); err != nil { return nil, err }
+    var users []core.UserID
+    s.users.Range(func(k, v any) bool {
+        user := k.(core.UserID)
+        if filter.After != "" && user <= filter.After { return true }
+        rec := v.(*userRecord)
+        rec.mu.Lock()
+        match := filter.Matches(rec.state)
+        rec.mu.Unlock()
+        if match { users = append(users, user) }
+        return true
+    })
+    sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+    if filter.Limit > 0 && len(users) > filter.Limit { users = users[:filter.Limit] }
+    return users, nil
+}
+
+// EachUser calls fn for every user held in memory.
+func (s *Store) EachUser(ctx context.Context, fn func(core.UserID) error) error {
+    if err := ctx.Err(); err != nil { return err }
+    var err error
+    s.users.Range(func(k, _ any) bool {
+        err = fn(k.(core.UserID))
+        return err == nil
+    })
+    return err
+}
+
 var _ interface{ AddPoints(context.Context, core.UserID, core.Metric, int64) (int64, error); AwardBadge(context.Context, core.UserID, core.Badge) error; GetState(context.Context, core.UserID) (core.UserState, error); SetLevel(context.Context, core.UserID, core.Metric, int64) error } = (*Store)(nil)
 
 
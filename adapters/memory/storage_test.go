@@ -2,8 +2,12 @@ package memory
 
 import (
     "context"
+    "sync"
     "testing"
+    "time"
+    "gamifykit/adapters/storagetest"
     "gamifykit/core"
+    "gamifykit/engine"
 )
 
 func TestMemoryStore(t *testing.T) {
@@ -16,3 +20,135 @@ func TestMemoryStore(t *testing.T) {
 }
 
 
+
+// TestConcurrentReadWriteSameUser is meant for go test -race: readers of a brand-new user race
+// its first writes, and mutate the states they get back, without touching the store's maps.
+func TestConcurrentReadWriteSameUser(t *testing.T) {
+    ctx := context.Background()
+    for round := 0; round < 20; round++ {
+        s := New()
+        user := core.UserID("fresh")
+        var wg sync.WaitGroup
+        for w := 0; w < 4; w++ {
+            wg.Add(2)
+            go func() {
+                defer wg.Done()
+                for i := 0; i < 50; i++ {
+                    _, _ = s.AddPoints(ctx, user, core.MetricXP, 1)
+                    _ = s.AwardBadge(ctx, user, "starter")
+                    _ = s.SetLevel(ctx, user, core.MetricXP, int64(i))
+                }
+            }()
+            go func() {
+                defer wg.Done()
+                for i := 0; i < 50; i++ {
+                    st, err := s.GetState(ctx, user)
+                    if err != nil { t.Error(err); return }
+                    if st.Points == nil || st.Badges == nil || st.Levels == nil { t.Error("partially initialized state"); return }
+                    st.Points[core.MetricXP] = -1
+                    st.Badges["forged"] = struct{}{}
+                    delete(st.Levels, core.MetricXP)
+                }
+            }()
+        }
+        wg.Wait()
+        st, _ := s.GetState(ctx, user)
+        if st.Points[core.MetricXP] != 200 { t.Fatalf("want 200 points, got %d", st.Points[core.MetricXP]) }
+        if _, forged := st.Badges["forged"]; forged { t.Fatal("a reader's copy leaked into the store") }
+    }
+}
+
+func TestConformance(t *testing.T) {
+    storagetest.RunConformance(t, func(*testing.T) engine.Storage { return New() })
+}
+
+func TestPointsInWindow(t *testing.T) {
+    s := New()
+    s.SetPointsRetention(48 * time.Hour)
+    now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+    s.now = func() time.Time { return now }
+    ctx := context.Background()
+
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 100) // expires from the retention
+    now = now.Add(30 * time.Hour)
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 20)
+    now = now.Add(20 * time.Hour)
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 5)
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, -2)
+
+    if got, _ := s.PointsInWindow(ctx, "u", core.MetricXP, time.Hour); got != 3 { t.Fatalf("last hour: want 3, got %d", got) }
+    if got, _ := s.PointsInWindow(ctx, "u", core.MetricXP, 24*time.Hour); got != 23 { t.Fatalf("last day: want 23, got %d", got) }
+    if got, _ := s.PointsInWindow(ctx, "u", core.MetricXP, 48*time.Hour); got != 23 { t.Fatalf("pruned increments must not count, got %d", got) }
+    if n := len(s.getOrCreate("u").history[core.MetricXP]); n != 3 { t.Fatalf("expected expired increment to be pruned, %d left", n) }
+    if _, err := s.PointsInWindow(ctx, "u", core.MetricXP, 72*time.Hour); err != core.ErrWindowTooLong { t.Fatalf("want ErrWindowTooLong, got %v", err) }
+    if got, _ := s.PointsInWindow(ctx, "nobody", core.MetricXP, time.Hour); got != 0 { t.Fatalf("unknown user: got %d", got) }
+}
+
+func TestPointsPerPeriod(t *testing.T) {
+    s := New()
+    s.SetPointsRetention(72 * time.Hour)
+    now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+    s.now = func() time.Time { return now }
+    ctx := context.Background()
+
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 7)
+    now = now.Add(25 * time.Hour)
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 3)
+    _, _ = s.AddPoints(ctx, "u", core.MetricXP, 4)
+
+    sums, err := s.PointsPerPeriod(ctx, "u", core.MetricXP, 24*time.Hour, 3)
+    if err != nil || len(sums) != 3 || sums[0] != 7 || sums[1] != 7 || sums[2] != 0 { t.Fatalf("sums = %v, %v; want [7 7 0]", sums, err) }
+    if _, err := s.PointsPerPeriod(ctx, "u", core.MetricXP, 24*time.Hour, 4); err != core.ErrWindowTooLong { t.Fatalf("want ErrWindowTooLong, got %v", err) }
+}
+
+func TestCompact(t *testing.T) {
+    s := New()
+    s.SetPointsRetention(24 * time.Hour)
+    s.SetHistoryLimit(2)
+    now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+    s.SetClock(func() time.Time { return now })
+    ctx := context.Background()
+
+    _, _ = s.AddPoints(ctx, "idle", "coins", 1) // expires, and is never written again
+    for i := 0; i < 4; i++ { _, _ = s.AddPoints(ctx, "busy", core.MetricXP, 10) }
+    if n := len(s.getOrCreate("busy").history[core.MetricXP]); n != 2 { t.Fatalf("writes should keep the 2 most recent increments, %d kept", n) }
+    now = now.Add(25 * time.Hour)
+
+    pruned, err := s.Compact(ctx)
+    if err != nil || pruned != 3 { t.Fatalf("want the 3 increments left pruned, got %d %v", pruned, err) }
+    if _, ok := s.getOrCreate("idle").history["coins"]; ok { t.Fatal("expired history of idle users should be released") }
+    if n := len(s.getOrCreate("busy").history[core.MetricXP]); n != 0 { t.Fatalf("expired increments should be pruned, %d left", n) }
+    if st, _ := s.GetState(ctx, "busy"); st.Points[core.MetricXP] != 40 { t.Fatalf("totals must not change, got %d", st.Points[core.MetricXP]) }
+
+    for i := 0; i < 5; i++ { _, _ = s.AddPoints(ctx, "busy", core.MetricXP, 1) }
+    if got, _ := s.PointsInWindow(ctx, "busy", core.MetricXP, time.Hour); got != 2 { t.Fatalf("want 2 in window after capping, got %d", got) }
+
+    // a limit set later is applied by the next write or compaction
+    s.SetHistoryLimit(1)
+    if pruned, _ := s.Compact(ctx); pruned != 1 { t.Fatalf("compaction should trim to the new limit, pruned %d", pruned) }
+
+    cancelled, cancel := context.WithCancel(ctx)
+    cancel()
+    if _, err := s.Compact(cancelled); err == nil { t.Fatal("Compact should stop when the context is done") }
+}
+
+func TestMergeUsersDeletesSource(t *testing.T) {
+    s := New()
+    ctx := context.Background()
+    if _, err := s.AddPoints(ctx, "guest", core.MetricXP, 40); err != nil { t.Fatal(err) }
+    if _, err := s.AddPoints(ctx, "alice", core.MetricXP, 30); err != nil { t.Fatal(err) }
+    sum := func(src, dst core.UserState) (core.UserState, error) {
+        dst.Points[core.MetricXP] += src.Points[core.MetricXP]
+        return dst, nil
+    }
+    if _, _, err := s.MergeUsers(ctx, "guest", "alice", sum); err != nil { t.Fatal(err) }
+    if _, _, err := s.MergeUsers(ctx, "nobody", "alice", sum); err != nil { t.Fatal(err) }
+
+    var users []core.UserID
+    if err := s.EachUser(ctx, func(u core.UserID) error { users = append(users, u); return nil }); err != nil { t.Fatal(err) }
+    if len(users) != 1 || users[0] != "alice" { t.Fatalf("EachUser after merge = %v, want only the target", users) }
+    if st, _ := s.GetState(ctx, "alice"); st.Points[core.MetricXP] != 70 { t.Fatalf("target xp = %d", st.Points[core.MetricXP]) }
+
+    // the source ID can be used again, on a fresh record
+    if total, err := s.AddPoints(ctx, "guest", core.MetricXP, 5); err != nil || total != 5 { t.Fatalf("write after merge = %d, %v", total, err) }
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultBackplaneChannel is the pub/sub channel a Backplane uses when none is given.
+const DefaultBackplaneChannel = "gamifykit:events"
+
+// Backplane shares realtime events between server instances over Redis pub/sub; it implements
+// realtime.Backplane. Pub/sub delivers to the instances connected at the time of publishing, so
+// events published while an instance reconnects are not replayed to it.
+type Backplane struct {
+	client  redis.UniversalClient
+	channel string
+	// OnReconnect, if set, is called with the error that dropped the subscription before every
+	// reconnect attempt.
+	OnReconnect func(err error)
+}
+
+// NewBackplane creates a backplane on channel (DefaultBackplaneChannel when empty). With
+// Config.KeyPrefix set, pass the prefixed channel so environments sharing a Redis stay apart.
+func NewBackplane(client redis.UniversalClient, channel string) *Backplane {
+	if channel == "" {
+		channel = DefaultBackplaneChannel
+	}
+	return &Backplane{client: client, channel: channel}
+}
+
+// Publish sends msg to every subscribed instance.
+func (b *Backplane) Publish(ctx context.Context, msg []byte) error {
+	if err := b.client.Publish(ctx, b.channel, msg).Err(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", b.channel, err)
+	}
+	return nil
+}
+
+// Subscribe calls fn with every message on the channel until ctx is done. A dropped connection is
+// re-established with exponential backoff (100ms doubling up to 10s), so a Redis restart or
+// failover interrupts delivery without ending it.
+func (b *Backplane) Subscribe(ctx context.Context, fn func(msg []byte)) error {
+	backoff := 100 * time.Millisecond
+	for {
+		err := b.receive(ctx, fn, func() { backoff = 100 * time.Millisecond })
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if b.OnReconnect != nil {
+			b.OnReconnect(err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// receive subscribes and delivers messages until the subscription fails; subscribed is called
+// once the subscription is confirmed
+func (b *Backplane) receive(ctx context.Context, fn func([]byte), subscribed func()) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", b.channel, err)
+	}
+	subscribed()
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to receive from %s: %w", b.channel, err)
+		}
+		fn([]byte(msg.Payload))
+	}
+}
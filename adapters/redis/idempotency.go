@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyStore records idempotency keys in Redis, so a retried request is recognized whichever
+// server instance it lands on; it implements httpapi.IdempotencyStore. A key is claimed with
+// SET NX, which only one instance can win, and holds an empty value until its response is stored.
+type IdempotencyStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewIdempotencyStore keeps keys under prefix+"idempotency:" (pass Config.KeyPrefix).
+func NewIdempotencyStore(client redis.UniversalClient, prefix string) *IdempotencyStore {
+	return &IdempotencyStore{client: client, prefix: prefix + "idempotency:"}
+}
+
+// Reserve claims key for ttl. If the key is already claimed it returns false with the stored
+// response, which is nil while the request holding the key is still running.
+func (s *IdempotencyStore) Reserve(ctx context.Context, key string, ttl time.Duration) (bool, []byte, error) {
+	ok, err := s.client.SetNX(ctx, s.prefix+key, "", ttl).Result()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if ok {
+		return true, nil, nil
+	}
+	stored, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		// released or expired in between: the caller may retry
+		return false, nil, nil
+	case err != nil:
+		return false, nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	case len(stored) == 0:
+		return false, nil, nil
+	}
+	return false, stored, nil
+}
+
+// Complete stores the response of the request holding key, kept for ttl.
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, response []byte, ttl time.Duration) error {
+	if err := s.client.Set(ctx, s.prefix+key, response, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release drops key, e.g. after a failed request, so the client can retry it.
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
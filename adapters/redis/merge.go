@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gamifykit/core"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrClusterMerge is returned by MergeUsers in cluster mode, where the two users' keys live in
+// different hash slots and cannot be changed in one transaction.
+var ErrClusterMerge = errors.New("redis: merging users is not supported in cluster mode")
+
+// MergeUsers writes merge(source, target) as the target's state and deletes the source's keys in
+// one MULTI/EXEC transaction; see engine.UserMerger. Both users' index, badge and award keys and
+// the points and levels keys they list are watched, and the merge is retried when a concurrent
+// write changes one. Repeatable badge records the target lacks are taken from the source; the
+// target's rolling-window increments are kept and the source's dropped. Redis keeps no identity
+// links, so there are none to move. It fails with ErrClusterMerge in cluster mode.
+func (s *Store) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (_ core.UserState, _ core.UserState, err error) {
+	ctx, span := s.span(ctx, "MergeUsers", target)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	if source == target {
+		return core.UserState{}, core.UserState{}, core.ErrSelfMerge
+	}
+	if s.cluster {
+		return core.UserState{}, core.UserState{}, ErrClusterMerge
+	}
+
+	var before, after core.UserState
+	var rejected error
+	update := func(tx *redis.Tx) error {
+		src, srcKeys, err := s.watchState(ctx, tx, source)
+		if err != nil {
+			return err
+		}
+		dst, dstKeys, err := s.watchState(ctx, tx, target)
+		if err != nil {
+			return err
+		}
+		next, err := merge(src.Clone(), dst.Clone())
+		if err != nil {
+			rejected = err
+			return err
+		}
+		next = next.Clone()
+		next.UserID = target
+		awards, err := tx.HGetAll(ctx, s.awardsKey(source)).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read badge records: %w", err)
+		}
+		for metric := range src.Points {
+			srcKeys = append(srcKeys, s.recentKey(source, metric))
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, append(srcKeys, s.awardsKey(source), s.updatedKey(source))...)
+			pipe.Del(ctx, dstKeys...)
+			var index []any
+			for metric, points := range next.Points {
+				pipe.Set(ctx, s.pointsKey(target, metric), points, 0)
+				index = append(index, indexPoints(metric))
+			}
+			if len(next.Badges) > 0 {
+				badges := make([]any, 0, len(next.Badges))
+				for b := range next.Badges {
+					badges = append(badges, string(b))
+				}
+				pipe.SAdd(ctx, s.badgesKey(target), badges...)
+			}
+			for metric, level := range next.Levels {
+				pipe.Set(ctx, s.levelsKey(target, metric), level, 0)
+				index = append(index, indexLevels(metric))
+			}
+			if len(index) > 0 {
+				pipe.SAdd(ctx, s.indexKey(target), index...)
+			}
+			for badge, rec := range awards {
+				pipe.HSetNX(ctx, s.awardsKey(target), badge, rec)
+			}
+			next.Updated = core.Now()
+			pipe.Set(ctx, s.updatedKey(target), next.Updated.UnixNano(), 0)
+			return nil
+		})
+		before, after = dst, next
+		return err
+	}
+	watched := []string{
+		s.indexKey(source), s.badgesKey(source), s.awardsKey(source),
+		s.indexKey(target), s.badgesKey(target), s.awardsKey(target),
+	}
+	for attempt := 0; attempt < maxWatchRetries; attempt++ {
+		if err = s.client.Watch(ctx, update, watched...); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if rejected != nil {
+		return core.UserState{}, core.UserState{}, rejected
+	}
+	if err != nil {
+		return core.UserState{}, core.UserState{}, fmt.Errorf("failed to merge users: %w", err)
+	}
+	return before, after.Clone(), nil
+}
+
+// watchState reads the user's state inside tx after watching the points and levels keys listed in
+// the user's index, and returns it with the keys a rewrite of the user deletes
+func (s *Store) watchState(ctx context.Context, tx *redis.Tx, userID core.UserID) (core.UserState, []string, error) {
+	indexed, err := tx.SMembers(ctx, s.indexKey(userID)).Result()
+	if err != nil {
+		return core.UserState{}, nil, fmt.Errorf("failed to read user index: %w", err)
+	}
+	keys := []string{s.badgesKey(userID), s.stateKey(userID), s.indexKey(userID)}
+	var values []string
+	for _, member := range indexed {
+		kind, metric, _ := strings.Cut(member, ":")
+		switch kind {
+		case "points":
+			values = append(values, s.pointsKey(userID, core.Metric(metric)))
+		case "levels":
+			values = append(values, s.levelsKey(userID, core.Metric(metric)))
+		}
+	}
+	if len(values) > 0 {
+		if err := tx.Watch(ctx, values...).Err(); err != nil {
+			return core.UserState{}, nil, err
+		}
+	}
+	state, err := s.readIndexed(ctx, tx, userID, indexed)
+	if err != nil {
+		return core.UserState{}, nil, err
+	}
+	return state, append(keys, values...), nil
+}
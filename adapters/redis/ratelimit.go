@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills and takes from a token bucket in one step, so concurrent requests on
+// different instances cannot both take the last token. Time comes from the Redis server, so the
+// instances' clocks do not need to agree. It returns {allowed, milliseconds until the next token}.
+var tokenBucketScript = redis.NewScript(`
+local perMs = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+  tokens = burst
+  ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * perMs)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / perMs)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / perMs) + 1000)
+return {allowed, wait}
+`)
+
+// RateLimitStore keeps per-client token buckets in Redis, so server instances behind a load
+// balancer enforce one limit per client instead of one per instance; it implements
+// httpapi.RateLimitStore. Each bucket is a hash updated atomically by a Lua script and expires
+// once it would be full again.
+type RateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRateLimitStore keeps buckets under prefix+"ratelimit:" (pass Config.KeyPrefix).
+func NewRateLimitStore(client redis.UniversalClient, prefix string) *RateLimitStore {
+	return &RateLimitStore{client: client, prefix: prefix + "ratelimit:"}
+}
+
+// Take consumes a token from key's bucket, which refills at perSecond tokens per second up to
+// burst. When it returns false, retryAfter says when a token will be available.
+func (s *RateLimitStore) Take(ctx context.Context, key string, perSecond float64, burst int) (bool, time.Duration, error) {
+	res, err := tokenBucketScript.Run(ctx, s.client, []string{s.prefix + key}, perSecond/1000, burst).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take a rate limit token: %w", err)
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("failed to take a rate limit token: unexpected reply %v", res)
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// activeSeasonKey holds the season stored by SwitchSeason
+const activeSeasonKey = "season:active"
+
+// switchSeasonScript sets KEYS[1] to ARGV[2] only while it holds ARGV[1], a missing key counting as ""
+var switchSeasonScript = redis.NewScript(`
+	local current = redis.call('GET', KEYS[1]) or ''
+	if current ~= ARGV[1] then
+		return 0
+	end
+	redis.call('SET', KEYS[1], ARGV[2])
+	return 1
+`)
+
+// ActiveSeason returns the season stored by SwitchSeason, "" if none was.
+func (s *Store) ActiveSeason(ctx context.Context) (_ string, err error) {
+	ctx, span := s.span(ctx, "ActiveSeason", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	season, err := s.client.Get(ctx, s.prefix+activeSeasonKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read active season: %w", err)
+	}
+	return season, nil
+}
+
+// SwitchSeason stores next as the active season if previous still is, compared and set in one
+// script so of two instances switching at once only one succeeds.
+func (s *Store) SwitchSeason(ctx context.Context, previous, next string) (_ bool, err error) {
+	ctx, span := s.span(ctx, "SwitchSeason", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	switched, err := switchSeasonScript.Run(ctx, s.client, []string{s.prefix + activeSeasonKey}, previous, next).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to switch season: %w", err)
+	}
+	return switched == 1, nil
+}
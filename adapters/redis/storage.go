@@ -2,31 +2,61 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"gamifykit/core"
+	"gamifykit/secret"
+	"gamifykit/tracing"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Deployment modes for Config.Mode.
+const (
+	ModeStandalone = "standalone"
+	ModeCluster    = "cluster"
+	ModeSentinel   = "sentinel"
+)
+
 // Config holds Redis connection configuration
 type Config struct {
-	Addr         string
-	Password     string
-	DB           int
-	PoolSize     int
-	MinIdleConns int
-	DialTimeout  time.Duration
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	// Mode selects a single server (standalone, the default), Redis Cluster or Sentinel failover
+	Mode string
+	// Addr is the server address in standalone mode
+	Addr string
+	// Addrs are the cluster seed nodes in cluster mode, or the sentinel addresses in sentinel mode
+	Addrs []string
+	// MasterName is the name of the master monitored by the sentinels (sentinel mode)
+	MasterName string
+	// SentinelPassword authenticates against the sentinels when it differs from Password
+	SentinelPassword secret.SecureString
+	Password         secret.SecureString
+	DB               int
+	PoolSize         int
+	MinIdleConns     int
+	DialTimeout      time.Duration
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	// PointsRetention is how long point increments are kept for PointsInWindow
+	// (core.DefaultPointsRetention when zero)
+	PointsRetention time.Duration
+	// KeyPrefix is prepended to every key, e.g. "gamifykit:prod:", so several environments or
+	// apps can share one Redis. Pass it to leaderboard.WithKeyPrefix for boards as well.
+	KeyPrefix string
 }
 
 // DefaultConfig returns sensible defaults for Redis configuration
 func DefaultConfig() Config {
 	return Config{
+		Mode:         ModeStandalone,
 		Addr:         "localhost:6379",
 		Password:     "",
 		DB:           0,
@@ -38,43 +68,131 @@ func DefaultConfig() Config {
 	}
 }
 
+// Validate checks that the fields required by the selected mode are set.
+func (c Config) Validate() error {
+	switch c.Mode {
+	case "", ModeStandalone:
+		if c.Addr == "" {
+			return errors.New("addr is required in standalone mode")
+		}
+	case ModeCluster:
+		if len(c.Addrs) == 0 {
+			return errors.New("addrs (cluster nodes) are required in cluster mode")
+		}
+		if c.DB != 0 {
+			return errors.New("db must be 0 in cluster mode")
+		}
+	case ModeSentinel:
+		if len(c.Addrs) == 0 {
+			return errors.New("addrs (sentinels) are required in sentinel mode")
+		}
+		if c.MasterName == "" {
+			return errors.New("master name is required in sentinel mode")
+		}
+	default:
+		return fmt.Errorf("mode must be one of: %s, %s, %s", ModeStandalone, ModeCluster, ModeSentinel)
+	}
+	// the prefix is part of SCAN patterns and must not move keys between cluster slots
+	if strings.ContainsAny(c.KeyPrefix, "*?[]\\{} \t\r\n") {
+		return errors.New("key prefix must not contain glob characters, braces or whitespace")
+	}
+	return nil
+}
+
+// NewClient creates the Redis client for the configured mode, e.g. to share it with leaderboards.
+func NewClient(config Config) (redis.UniversalClient, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Redis config: %w", err)
+	}
+	switch config.Mode {
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        config.Addrs,
+			Password:     config.Password.Reveal(),
+			PoolSize:     config.PoolSize,
+			MinIdleConns: config.MinIdleConns,
+			DialTimeout:  config.DialTimeout,
+			ReadTimeout:  config.ReadTimeout,
+			WriteTimeout: config.WriteTimeout,
+		}), nil
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.MasterName,
+			SentinelAddrs:    config.Addrs,
+			SentinelPassword: config.SentinelPassword.Reveal(),
+			Password:         config.Password.Reveal(),
+			DB:               config.DB,
+			PoolSize:         config.PoolSize,
+			MinIdleConns:     config.MinIdleConns,
+			DialTimeout:      config.DialTimeout,
+			ReadTimeout:      config.ReadTimeout,
+			WriteTimeout:     config.WriteTimeout,
+		}), nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:         config.Addr,
+		Password:     config.Password.Reveal(),
+		DB:           config.DB,
+		PoolSize:     config.PoolSize,
+		MinIdleConns: config.MinIdleConns,
+		DialTimeout:  config.DialTimeout,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+	}), nil
+}
+
 // Store implements the engine.Storage interface using Redis as the backend.
 // Data structure:
 // - user:{user_id}:points:{metric} -> int64 (points total)
 // - user:{user_id}:badges -> set of badge strings
 // - user:{user_id}:levels:{metric} -> int64 (level)
 // - user:{user_id}:state -> JSON blob of UserState for quick retrieval
+// - user:{user_id}:recent:{metric} -> sorted set of "{delta}:{id}" increments scored by Unix milliseconds
+//
+// On Redis Cluster the user ID is wrapped in a hash tag, e.g. "user:{alice}:points:xp", so all of
+// a user's keys share one slot and multi-key scripts and transactions stay valid.
+// Every key starts with Config.KeyPrefix, e.g. "gamifykit:prod:user:alice:badges".
 type Store struct {
-	client *redis.Client
+	client    redis.UniversalClient
+	retention time.Duration
+	cluster   bool
+	prefix    string
 }
 
 // New creates a new Redis-backed storage with the provided configuration
 func New(config Config) (*Store, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:         config.Addr,
-		Password:     config.Password,
-		DB:           config.DB,
-		PoolSize:     config.PoolSize,
-		MinIdleConns: config.MinIdleConns,
-		DialTimeout:  config.DialTimeout,
-		ReadTimeout:  config.ReadTimeout,
-		WriteTimeout: config.WriteTimeout,
-	})
+	client, err := NewClient(config)
+	if err != nil {
+		return nil, err
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
-	return &Store{client: client}, nil
+	store := NewWithClient(client)
+	store.prefix = config.KeyPrefix
+	if config.PointsRetention > 0 {
+		store.retention = config.PointsRetention
+	}
+	return store, nil
+}
+
+// NewWithClient creates a Store using an existing Redis client (useful for testing).
+// Cluster clients get hash-tagged keys.
+func NewWithClient(client redis.UniversalClient) *Store {
+	_, cluster := client.(*redis.ClusterClient)
+	return &Store{client: client, retention: core.DefaultPointsRetention, cluster: cluster}
 }
 
-// NewWithClient creates a Store using an existing Redis client (useful for testing)
-func NewWithClient(client *redis.Client) *Store {
-	return &Store{client: client}
+// KeyPrefix returns the prefix of all of the store's keys (Config.KeyPrefix).
+func (s *Store) KeyPrefix() string {
+	return s.prefix
 }
 
 // Close closes the Redis connection
@@ -82,6 +200,55 @@ func (s *Store) Close() error {
 	return s.client.Close()
 }
 
+// user returns the user ID as it appears in keys, hash-tagged on Redis Cluster
+func (s *Store) user(userID core.UserID) core.UserID {
+	if s.cluster {
+		return "{" + userID + "}"
+	}
+	return userID
+}
+
+// keyUser extracts the user ID from the user segment of a key
+func (s *Store) keyUser(segment string) core.UserID {
+	if s.cluster {
+		segment = strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+	}
+	return core.UserID(segment)
+}
+
+// errStopScan ends a scan early without reporting an error
+var errStopScan = errors.New("stop scan")
+
+// scan calls fn for every key matching pattern, walking all masters on Redis Cluster.
+// fn is never called concurrently; returning errStopScan ends the scan.
+func (s *Store) scan(ctx context.Context, pattern string, count int64, fn func(key string) error) error {
+	walk := func(ctx context.Context, c redis.UniversalClient, mu *sync.Mutex) error {
+		iter := c.Scan(ctx, 0, pattern, count).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+	var mu sync.Mutex
+	var err error
+	if cc, ok := s.client.(*redis.ClusterClient); ok {
+		err = cc.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return walk(ctx, node, &mu)
+		})
+	} else {
+		err = walk(ctx, s.client, &mu)
+	}
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
 // userPointsKey generates the Redis key for user points
 func userPointsKey(userID core.UserID, metric core.Metric) string {
 	return fmt.Sprintf("user:%s:points:%s", userID, metric)
@@ -92,42 +259,128 @@ func userBadgesKey(userID core.UserID) string {
 	return fmt.Sprintf("user:%s:badges", userID)
 }
 
+// userAwardsKey generates the Redis key for a user's repeatable badge records
+func userAwardsKey(userID core.UserID) string {
+	return fmt.Sprintf("user:%s:awards", userID)
+}
+
 // userLevelsKey generates the Redis key for user levels
 func userLevelsKey(userID core.UserID, metric core.Metric) string {
 	return fmt.Sprintf("user:%s:levels:%s", userID, metric)
 }
 
+// userRecentKey generates the Redis key for a user's timestamped increments of a metric
+func userRecentKey(userID core.UserID, metric core.Metric) string {
+	return fmt.Sprintf("user:%s:recent:%s", userID, metric)
+}
+
+// userIndexKey generates the Redis key of the set naming a user's points and levels keys, as
+// "points:<metric>" and "levels:<metric>", so they can be found without scanning the keyspace
+func userIndexKey(userID core.UserID) string {
+	return fmt.Sprintf("user:%s:keys", userID)
+}
+
+// indexPoints and indexLevels are the members of a user's index set naming a metric's keys
+func indexPoints(metric core.Metric) string { return "points:" + string(metric) }
+func indexLevels(metric core.Metric) string { return "levels:" + string(metric) }
+
+// globEscape escapes the glob metacharacters of s for use in a SCAN pattern
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // userStateKey generates the Redis key for cached user state
 func userStateKey(userID core.UserID) string {
 	return fmt.Sprintf("user:%s:state", userID)
 }
 
+// userUpdatedKey generates the Redis key holding the time of a user's last write, in unix nanoseconds
+func userUpdatedKey(userID core.UserID) string {
+	return fmt.Sprintf("user:%s:updated", userID)
+}
+
+// span starts a tracing span for the storage operation op on userID
+func (s *Store) span(ctx context.Context, op string, userID core.UserID) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "redis."+op)
+	span.SetAttr("db.system", "redis")
+	span.SetAttr("db.operation", op)
+	span.SetUser(userID)
+	return ctx, span
+}
+
+func (s *Store) pointsKey(userID core.UserID, metric core.Metric) string {
+	return s.prefix + userPointsKey(s.user(userID), metric)
+}
+
+func (s *Store) badgesKey(userID core.UserID) string {
+	return s.prefix + userBadgesKey(s.user(userID))
+}
+
+func (s *Store) awardsKey(userID core.UserID) string {
+	return s.prefix + userAwardsKey(s.user(userID))
+}
+
+func (s *Store) levelsKey(userID core.UserID, metric core.Metric) string {
+	return s.prefix + userLevelsKey(s.user(userID), metric)
+}
+
+func (s *Store) recentKey(userID core.UserID, metric core.Metric) string {
+	return s.prefix + userRecentKey(s.user(userID), metric)
+}
+
+func (s *Store) stateKey(userID core.UserID) string {
+	return s.prefix + userStateKey(s.user(userID))
+}
+
+func (s *Store) updatedKey(userID core.UserID) string {
+	return s.prefix + userUpdatedKey(s.user(userID))
+}
+
+func (s *Store) indexKey(userID core.UserID) string {
+	return s.prefix + userIndexKey(s.user(userID))
+}
+
+// keyParts splits one of the store's keys into its colon-separated parts after the prefix
+func (s *Store) keyParts(key string) []string {
+	return redisKeyParts(strings.TrimPrefix(key, s.prefix))
+}
+
 // Lua script for atomic point addition with overflow protection
 var addPointsScript = redis.NewScript(`
-	local key = KEYS[1]
-	local delta = tonumber(ARGV[1])
-	local current = tonumber(redis.call('GET', key) or '0')
-	local next_val = current + delta
-
-	-- Check for overflow (simplified check for large numbers)
-	if next_val > 9223372036854775807 or next_val < -9223372036854775808 then
-		return redis.error_reply('integer overflow')
-	end
+	-- INCRBY works on exact 64-bit integers and fails without writing on overflow
+	local next_val = redis.call('INCRBY', KEYS[1], ARGV[1])
 
-	redis.call('SET', key, next_val)
+	-- record the increment for rolling-window queries
+	redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1] .. ':' .. ARGV[3])
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+
+	-- list the points key in the user's index
+	redis.call('SADD', KEYS[3], ARGV[5])
 	return next_val
 `)
 
 // AddPoints atomically adds points to a user's metric with overflow protection
-func (s *Store) AddPoints(ctx context.Context, userID core.UserID, metric core.Metric, delta int64) (int64, error) {
+func (s *Store) AddPoints(ctx context.Context, userID core.UserID, metric core.Metric, delta int64) (_ int64, err error) {
+	ctx, span := s.span(ctx, "AddPoints", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
 	if delta == 0 {
 		return 0, errors.New("delta cannot be zero")
 	}
 
-	key := userPointsKey(userID, metric)
-	result, err := addPointsScript.Run(ctx, s.client, []string{key}, delta).Result()
+	keys := []string{s.pointsKey(userID, metric), s.recentKey(userID, metric), s.indexKey(userID)}
+	now := time.Now()
+	result, err := addPointsScript.Run(ctx, s.client, keys, delta, now.UnixMilli(), incrementID(now), s.retention.Milliseconds(), indexPoints(metric)).Result()
 	if err != nil {
-		return 0, fmt.Errorf("failed to add points: %w", err)
+		return 0, fmt.Errorf("failed to add points: %w", classify(ctx, err))
 	}
 
 	total, ok := result.(int64)
@@ -141,24 +394,169 @@ func (s *Store) AddPoints(ctx context.Context, userID core.UserID, metric core.M
 	return total, nil
 }
 
+// maxWatchRetries bounds how often an optimistic WATCH transaction is retried after concurrent writes
+const maxWatchRetries = 16
+
+// UpdatePoints sets the user's total of metric to fn(current) atomically: the key is watched while
+// fn runs, and the update is retried when another writer changed it in between (see
+// engine.PointsUpdater). The increment is recorded for rolling windows like AddPoints does.
+func (s *Store) UpdatePoints(ctx context.Context, userID core.UserID, metric core.Metric, fn func(int64) (int64, error)) (_ int64, _ int64, err error) {
+	ctx, span := s.span(ctx, "UpdatePoints", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+
+	key := s.pointsKey(userID, metric)
+	var previous, total int64
+	var rejected error
+	update := func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, key).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		next, err := fn(current)
+		if err != nil {
+			rejected = err
+			return err
+		}
+		previous, total = current, next
+		if next == current {
+			return nil
+		}
+		delta, err := core.SubSafe(next, current)
+		if err != nil {
+			return err
+		}
+		keys := []string{key, s.recentKey(userID, metric), s.indexKey(userID)}
+		now := time.Now()
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return addPointsScript.Eval(ctx, pipe, keys, delta, now.UnixMilli(), incrementID(now), s.retention.Milliseconds(), indexPoints(metric)).Err()
+		})
+		return err
+	}
+	for attempt := 0; attempt < maxWatchRetries; attempt++ {
+		if err = s.client.Watch(ctx, update, key); !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if rejected != nil {
+		return 0, 0, rejected
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update points: %w", err)
+	}
+	if total != previous {
+		s.invalidateStateCache(ctx, userID)
+	}
+	return previous, total, nil
+}
+
 // AwardBadge adds a badge to the user's badge set
 func (s *Store) AwardBadge(ctx context.Context, userID core.UserID, badge core.Badge) error {
-	key := userBadgesKey(userID)
-	err := s.client.SAdd(ctx, key, string(badge)).Err()
+	_, err := s.TryAwardBadge(ctx, userID, badge)
+	return err
+}
+
+// TryAwardBadge adds a badge to the user's badge set and reports whether it was not there yet
+func (s *Store) TryAwardBadge(ctx context.Context, userID core.UserID, badge core.Badge) (_ bool, err error) {
+	ctx, span := s.span(ctx, "TryAwardBadge", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	n, err := s.client.SAdd(ctx, s.badgesKey(userID), string(badge)).Result()
 	if err != nil {
-		return fmt.Errorf("failed to award badge: %w", err)
+		return false, fmt.Errorf("failed to award badge: %w", classify(ctx, err))
 	}
 
 	// Invalidate cached state since it changed
+	if n > 0 {
+		s.invalidateStateCache(ctx, userID)
+	}
+
+	return n > 0, nil
+}
+
+// RemoveBadge removes a badge from the user's badge set and reports whether it was there
+func (s *Store) RemoveBadge(ctx context.Context, userID core.UserID, badge core.Badge) (_ bool, err error) {
+	defer func() { err = classify(ctx, err) }()
+	n, err := s.client.SRem(ctx, s.badgesKey(userID), string(badge)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove badge: %w", err)
+	}
+	if n > 0 {
+		s.invalidateStateCache(ctx, userID)
+	}
+	return n > 0, nil
+}
+
+// Lua script awarding a repeatable badge; records are "count:last award in unix ms" hash fields
+var repeatBadgeScript = redis.NewScript(`
+	local count, last = 0, 0
+	local rec = redis.call('HGET', KEYS[1], ARGV[1])
+	if rec then
+		local c, l = string.match(rec, '^(%d+):(%d+)$')
+		count, last = tonumber(c), tonumber(l)
+	end
+	if count > 0 and tonumber(ARGV[2]) < last + tonumber(ARGV[3]) then
+		return {count, last, 0}
+	end
+
+	count = count + 1
+	redis.call('HSET', KEYS[1], ARGV[1], count .. ':' .. ARGV[2])
+	redis.call('SADD', KEYS[2], ARGV[1])
+	return {count, tonumber(ARGV[2]), 1}
+`)
+
+// RepeatBadge atomically awards a repeatable badge and counts the award unless the last one lies
+// within cooldown. Award times are kept with millisecond precision.
+func (s *Store) RepeatBadge(ctx context.Context, userID core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (_ core.BadgeRecord, _ bool, err error) {
+	ctx, span := s.span(ctx, "RepeatBadge", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	keys := []string{s.awardsKey(userID), s.badgesKey(userID)}
+	res, err := repeatBadgeScript.Run(ctx, s.client, keys, string(badge), now.UnixMilli(), cooldown.Milliseconds()).Int64Slice()
+	if err != nil {
+		return core.BadgeRecord{}, false, fmt.Errorf("failed to award badge: %w", classify(ctx, err))
+	}
+	if len(res) != 3 {
+		return core.BadgeRecord{}, false, errors.New("unexpected result from Redis script")
+	}
+	rec := core.BadgeRecord{Count: res[0], LastAwarded: time.UnixMilli(res[1]).UTC()}
+	if res[2] == 0 {
+		return rec, false, nil
+	}
 	s.invalidateStateCache(ctx, userID)
+	return rec, true, nil
+}
 
-	return nil
+// BadgeRecord returns the award count and last award time of a repeatable badge
+func (s *Store) BadgeRecord(ctx context.Context, userID core.UserID, badge core.Badge) (_ core.BadgeRecord, err error) {
+	defer func() { err = classify(ctx, err) }()
+	rec, err := s.client.HGet(ctx, s.awardsKey(userID), string(badge)).Result()
+	if errors.Is(err, redis.Nil) {
+		return core.BadgeRecord{}, nil
+	}
+	if err != nil {
+		return core.BadgeRecord{}, fmt.Errorf("failed to get badge record: %w", err)
+	}
+	count, last, _ := strings.Cut(rec, ":")
+	n, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		return core.BadgeRecord{}, fmt.Errorf("invalid badge record %q", rec)
+	}
+	ms, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return core.BadgeRecord{}, fmt.Errorf("invalid badge record %q", rec)
+	}
+	return core.BadgeRecord{Count: n, LastAwarded: time.UnixMilli(ms).UTC()}, nil
 }
 
 // GetState retrieves the complete user state, using cache when possible
-func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserState, error) {
+func (s *Store) GetState(ctx context.Context, userID core.UserID) (_ core.UserState, err error) {
+	ctx, span := s.span(ctx, "GetState", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
 	// Try to get from cache first
 	cached, err := s.getCachedState(ctx, userID)
+	span.SetAttr("cache.hit", err == nil)
 	if err == nil {
 		return cached, nil
 	}
@@ -183,11 +581,17 @@ func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserStat
 }
 
 // SetLevel sets the user's level for a specific metric
-func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) error {
-	key := userLevelsKey(userID, metric)
-	err := s.client.Set(ctx, key, level, 0).Err()
+func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) (err error) {
+	ctx, span := s.span(ctx, "SetLevel", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.levelsKey(userID, metric), level, 0)
+		pipe.SAdd(ctx, s.indexKey(userID), indexLevels(metric))
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to set level: %w", err)
+		return fmt.Errorf("failed to set level: %w", classify(ctx, err))
 	}
 
 	// Invalidate cached state since it changed
@@ -196,96 +600,369 @@ func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Me
 	return nil
 }
 
-// getCachedState attempts to retrieve the cached user state
-func (s *Store) getCachedState(ctx context.Context, userID core.UserID) (core.UserState, error) {
-	key := userStateKey(userID)
-	data, err := s.client.Get(ctx, key).Bytes()
+// raiseLevelScript sets KEYS[1] to ARGV[1] only while it holds less, a missing key counting as 0,
+// and lists the key in the user's index KEYS[2]
+var raiseLevelScript = redis.NewScript(`
+	local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+	if current >= tonumber(ARGV[1]) then
+		return 0
+	end
+	redis.call('SET', KEYS[1], ARGV[1])
+	redis.call('SADD', KEYS[2], ARGV[2])
+	return 1
+`)
+
+// RaiseLevel stores level if it is above the user's current level of metric, compared and set in
+// one script so concurrent writers cannot lower it; see engine.LevelRaiser.
+func (s *Store) RaiseLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) (_ bool, err error) {
+	ctx, span := s.span(ctx, "RaiseLevel", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	keys := []string{s.levelsKey(userID, metric), s.indexKey(userID)}
+	raised, err := raiseLevelScript.Run(ctx, s.client, keys, level, indexLevels(metric)).Int()
 	if err != nil {
-		return core.UserState{}, err
+		return false, fmt.Errorf("failed to raise level: %w", err)
+	}
+	if raised == 1 {
+		s.invalidateStateCache(ctx, userID)
 	}
+	return raised == 1, nil
+}
+
+// stateCacheVersion tags the encoding of cached states. Bump it whenever core.UserState or the
+// envelope changes: entries of any other version, including unversioned ones written before
+// versioning, are treated as cache misses and rebuilt from the source keys instead of misread.
+const stateCacheVersion = 1
+
+// cachedState is the versioned envelope stored at the state key
+type cachedState struct {
+	Version int            `json:"v"`
+	State   core.UserState `json:"state"`
+}
+
+// errStaleCache reports a cached state written with another encoding version
+var errStaleCache = errors.New("cached state has an unknown encoding version")
+
+// classify wraps errors after which the command did not run with core.ErrBackendBusy, so the
+// service may retry them (see core.IsTransient): pool timeouts and servers that are loading,
+// busy running a script or failing over. Errors after ctx ended are wrapped with its error (see
+// core.ContextError), as a command timing out reads as a network error.
+func classify(ctx context.Context, err error) error {
+	err = core.ContextError(ctx, err)
+	if errors.Is(err, core.ErrBackendBusy) {
+		return err
+	}
+	if errors.Is(err, redis.ErrPoolTimeout) {
+		return fmt.Errorf("%w: %w", core.ErrBackendBusy, err)
+	}
+	for _, prefix := range []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN"} {
+		if redis.HasErrorPrefix(err, prefix) {
+			return fmt.Errorf("%w: %w", core.ErrBackendBusy, err)
+		}
+	}
+	return err
+}
+
+// encodeState serializes state for the cache
+func encodeState(state core.UserState) ([]byte, error) {
+	return json.Marshal(cachedState{Version: stateCacheVersion, State: state})
+}
 
-	var state core.UserState
-	if err := json.Unmarshal(data, &state); err != nil {
+// decodeState parses a cached state, failing with errStaleCache for other encoding versions
+func decodeState(data []byte) (core.UserState, error) {
+	var cached cachedState
+	if err := json.Unmarshal(data, &cached); err != nil {
 		return core.UserState{}, err
 	}
+	if cached.Version != stateCacheVersion {
+		return core.UserState{}, errStaleCache
+	}
+	return cached.State, nil
+}
 
-	return state, nil
+// getCachedState attempts to retrieve the cached user state
+func (s *Store) getCachedState(ctx context.Context, userID core.UserID) (core.UserState, error) {
+	data, err := s.client.Get(ctx, s.stateKey(userID)).Bytes()
+	if err != nil {
+		return core.UserState{}, err
+	}
+	return decodeState(data)
 }
 
 // updateStateCache stores the user state in cache with a TTL
 func (s *Store) updateStateCache(ctx context.Context, userID core.UserID, state core.UserState) error {
-	key := userStateKey(userID)
-	data, err := json.Marshal(state)
+	data, err := encodeState(state)
 	if err != nil {
 		return err
 	}
 
 	// Cache for 5 minutes
-	return s.client.Set(ctx, key, data, 5*time.Minute).Err()
+	return s.client.Set(ctx, s.stateKey(userID), data, 5*time.Minute).Err()
 }
 
-// invalidateStateCache removes the cached state
+// invalidateStateCache removes the cached state after a write and records the write time GetState
+// reports as Updated
 func (s *Store) invalidateStateCache(ctx context.Context, userID core.UserID) {
-	s.client.Del(ctx, userStateKey(userID))
+	pipe := s.client.Pipeline()
+	pipe.Del(ctx, s.stateKey(userID))
+	pipe.Set(ctx, s.updatedKey(userID), core.Now().UnixNano(), 0)
+	_, _ = pipe.Exec(ctx)
 }
 
-// buildStateFromKeys reconstructs the user state from individual Redis keys
+// buildStateFromKeys reconstructs the user state from the keys listed in the user's index. Updated
+// is the time of the last write, or the time of the read for users never written, or written
+// by versions that did not record it.
 func (s *Store) buildStateFromKeys(ctx context.Context, userID core.UserID) (core.UserState, error) {
+	indexed, err := s.client.SMembers(ctx, s.indexKey(userID)).Result()
+	if err != nil {
+		return core.UserState{}, fmt.Errorf("failed to read user index: %w", err)
+	}
+	return s.readIndexed(ctx, s.client, userID, indexed)
+}
+
+// readIndexed reads the user's state from the keys named by the index members indexed, through c
+// (the client, or a transaction watching the keys)
+func (s *Store) readIndexed(ctx context.Context, c redis.Cmdable, userID core.UserID, indexed []string) (core.UserState, error) {
 	state := core.UserState{
 		UserID:  userID,
 		Points:  make(map[core.Metric]int64),
 		Badges:  make(map[core.Badge]struct{}),
 		Levels:  make(map[core.Metric]int64),
-		Updated: time.Now().UTC(),
+		Updated: core.Now(),
+	}
+	type value struct {
+		into   map[core.Metric]int64
+		metric core.Metric
+		cmd    *redis.StringCmd
 	}
+	values := make([]value, 0, len(indexed))
+	pipe := c.Pipeline()
+	for _, member := range indexed {
+		kind, metric, _ := strings.Cut(member, ":")
+		switch kind {
+		case "points":
+			values = append(values, value{state.Points, core.Metric(metric), pipe.Get(ctx, s.pointsKey(userID, core.Metric(metric)))})
+		case "levels":
+			values = append(values, value{state.Levels, core.Metric(metric), pipe.Get(ctx, s.levelsKey(userID, core.Metric(metric)))})
+		}
+	}
+	badges := pipe.SMembers(ctx, s.badgesKey(userID))
+	updated := pipe.Get(ctx, s.updatedKey(userID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return core.UserState{}, fmt.Errorf("failed to read user state: %w", err)
+	}
+	if ns, err := updated.Int64(); err == nil {
+		state.Updated = core.Timestamp(time.Unix(0, ns))
+	}
+	for _, v := range values {
+		n, err := v.cmd.Int64()
+		if err != nil {
+			continue // missing or invalid entries
+		}
+		v.into[v.metric] = n
+	}
+	for _, badge := range badges.Val() {
+		state.Badges[core.Badge(badge)] = struct{}{}
+	}
+	return state, nil
+}
 
-	// Get all points
-	pattern := fmt.Sprintf("user:%s:points:*", userID)
-	keys, err := s.client.Keys(ctx, pattern).Result()
+// BuildIndex lists the points and levels keys of every user in the user's index set, which
+// GetState, Exists and ReplaceState read instead of scanning the keyspace. Writes keep the index
+// up to date; run this once over data written by versions that did not, e.g. after upgrading. It
+// walks the keyspace with SCAN and returns how many keys it indexed.
+func (s *Store) BuildIndex(ctx context.Context) (_ int, err error) {
+	defer func() { err = classify(ctx, err) }()
+	n := 0
+	err = s.scan(ctx, globEscape(s.prefix)+"user:*", 500, func(key string) error {
+		parts := s.keyParts(key)
+		if len(parts) < 4 || (parts[2] != "points" && parts[2] != "levels") {
+			return nil
+		}
+		user, metric := s.keyUser(parts[1]), core.Metric(strings.Join(parts[3:], ":"))
+		member := indexPoints(metric)
+		if parts[2] == "levels" {
+			member = indexLevels(metric)
+		}
+		if err := s.client.SAdd(ctx, s.indexKey(user), member).Err(); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
 	if err != nil {
-		return core.UserState{}, fmt.Errorf("failed to get points keys: %w", err)
+		return n, fmt.Errorf("failed to index user keys: %w", err)
 	}
+	return n, nil
+}
 
-	for _, key := range keys {
-		// Extract metric from key: user:{user_id}:points:{metric}
-		parts := redisKeyParts(key)
-		if len(parts) >= 4 && parts[2] == "points" {
-			metric := core.Metric(parts[3])
-			val, err := s.client.Get(ctx, key).Int64()
-			if err != nil {
-				continue // Skip invalid entries
-			}
-			state.Points[metric] = val
+// PointsInWindow sums the user's increments of metric recorded within the last window.
+// Increments older than the retention are removed first; longer windows fail with core.ErrWindowTooLong.
+func (s *Store) PointsInWindow(ctx context.Context, userID core.UserID, metric core.Metric, window time.Duration) (_ int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	if window > s.retention {
+		return 0, core.ErrWindowTooLong
+	}
+	key := s.recentKey(userID, metric)
+	now := time.Now()
+	pipe := s.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", now.Add(-s.retention).UnixMilli()))
+	members := pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: strconv.FormatInt(now.Add(-window).UnixMilli(), 10), Max: "+inf"})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to read recent points: %w", err)
+	}
+	var sum int64
+	for _, m := range members.Val() {
+		delta, _, _ := strings.Cut(m, ":")
+		d, err := strconv.ParseInt(delta, 10, 64)
+		if err != nil {
+			continue // skip foreign members
 		}
+		sum += d
 	}
+	return sum, nil
+}
 
-	// Get all badges
-	badgesKey := userBadgesKey(userID)
-	badges, err := s.client.SMembers(ctx, badgesKey).Result()
-	if err == nil {
-		for _, badge := range badges {
-			state.Badges[core.Badge(badge)] = struct{}{}
+// PointsPerPeriod sums the user's increments of metric in each of the last n periods, most recent
+// first, reading them in one command.
+func (s *Store) PointsPerPeriod(ctx context.Context, userID core.UserID, metric core.Metric, period time.Duration, n int) (_ []int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	if period <= 0 || n <= 0 {
+		return nil, fmt.Errorf("period and count must be positive, got %s and %d", period, n)
+	}
+	if period*time.Duration(n) > s.retention {
+		return nil, core.ErrWindowTooLong
+	}
+	now := time.Now()
+	from := now.Add(-period * time.Duration(n)).UnixMilli()
+	members, err := s.client.ZRangeByScoreWithScores(ctx, s.recentKey(userID, metric), &redis.ZRangeBy{Min: strconv.FormatInt(from, 10), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recent points: %w", err)
+	}
+	sums := make([]int64, n)
+	for _, m := range members {
+		member, _ := m.Member.(string)
+		delta, _, _ := strings.Cut(member, ":")
+		d, err := strconv.ParseInt(delta, 10, 64)
+		if err != nil {
+			continue // skip foreign members
+		}
+		age := max(now.Sub(time.UnixMilli(int64(m.Score))), 0)
+		if bucket := int(age / period); bucket < n {
+			sums[bucket] += d
 		}
 	}
+	return sums, nil
+}
 
-	// Get all levels
-	levelPattern := fmt.Sprintf("user:%s:levels:*", userID)
-	levelKeys, err := s.client.Keys(ctx, levelPattern).Result()
-	if err == nil {
-		for _, key := range levelKeys {
-			parts := redisKeyParts(key)
-			if len(parts) >= 4 && parts[2] == "levels" {
-				metric := core.Metric(parts[3])
-				val, err := s.client.Get(ctx, key).Int64()
-				if err != nil {
-					continue
+// incrementID makes sorted-set members unique when equal deltas land in the same millisecond
+func incrementID(now time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return strconv.FormatInt(now.UnixNano(), 36) + hex.EncodeToString(b)
+}
+
+// EachUser calls fn for every user with stored points, badges or levels.
+// Keys are walked with SCAN so large keyspaces do not block Redis.
+func (s *Store) EachUser(ctx context.Context, fn func(core.UserID) error) (err error) {
+	defer func() { err = classify(ctx, err) }()
+	seen := make(map[core.UserID]struct{})
+	var fnErr error
+	err = s.scan(ctx, globEscape(s.prefix)+"user:*", 500, func(key string) error {
+		parts := s.keyParts(key)
+		if len(parts) < 3 || (parts[2] != "points" && parts[2] != "badges" && parts[2] != "levels") {
+			return nil
+		}
+		user := s.keyUser(parts[1])
+		if _, ok := seen[user]; ok {
+			return nil
+		}
+		seen[user] = struct{}{}
+		if err := fn(user); err != nil {
+			fnErr = err
+			return errStopScan
+		}
+		return nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan user keys: %w", err)
+	}
+	return nil
+}
+
+// ReplaceState overwrites the user's points, badges and levels with state. The old keys are read
+// from the user's index, then deleted and rewritten in one MULTI/EXEC transaction. The index and
+// badge set are watched while doing so, and the transaction is retried when a concurrent write adds
+// a key the deletion would miss. Rolling-window increments are kept.
+func (s *Store) ReplaceState(ctx context.Context, userID core.UserID, state core.UserState) (err error) {
+	ctx, span := s.span(ctx, "ReplaceState", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+
+	indexKey, badgesKey := s.indexKey(userID), s.badgesKey(userID)
+	replace := func(tx *redis.Tx) error {
+		indexed, err := tx.SMembers(ctx, indexKey).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read user index: %w", err)
+		}
+		old := []string{badgesKey, s.stateKey(userID), indexKey}
+		for _, member := range indexed {
+			kind, metric, _ := strings.Cut(member, ":")
+			switch kind {
+			case "points":
+				old = append(old, s.pointsKey(userID, core.Metric(metric)))
+			case "levels":
+				old = append(old, s.levelsKey(userID, core.Metric(metric)))
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, old...)
+			var index []any
+			for metric, points := range state.Points {
+				pipe.Set(ctx, s.pointsKey(userID, metric), points, 0)
+				index = append(index, indexPoints(metric))
+			}
+			if len(state.Badges) > 0 {
+				badges := make([]any, 0, len(state.Badges))
+				for b := range state.Badges {
+					badges = append(badges, string(b))
 				}
-				state.Levels[metric] = val
+				pipe.SAdd(ctx, badgesKey, badges...)
+			}
+			for metric, level := range state.Levels {
+				pipe.Set(ctx, s.levelsKey(userID, metric), level, 0)
+				index = append(index, indexLevels(metric))
+			}
+			if len(index) > 0 {
+				pipe.SAdd(ctx, indexKey, index...)
 			}
+			pipe.Set(ctx, s.updatedKey(userID), core.Now().UnixNano(), 0)
+			return nil
+		})
+		return err
+	}
+	for attempt := 0; attempt < maxWatchRetries; attempt++ {
+		if err = s.client.Watch(ctx, replace, indexKey, badgesKey); !errors.Is(err, redis.TxFailedErr) {
+			break
 		}
 	}
+	if err != nil {
+		return fmt.Errorf("failed to replace state: %w", err)
+	}
+	return nil
+}
 
-	return state, nil
+// Exists reports whether the user has any points, badges or levels, from their badge set and index.
+func (s *Store) Exists(ctx context.Context, userID core.UserID) (_ bool, err error) {
+	defer func() { err = classify(ctx, err) }()
+	n, err := s.client.Exists(ctx, s.badgesKey(userID), s.indexKey(userID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check user: %w", err)
+	}
+	return n > 0, nil
 }
 
 // redisKeyParts splits a Redis key by colon separator
@@ -2,11 +2,14 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 	"time"
 
+	"gamifykit/adapters/storagetest"
 	"gamifykit/core"
+	"gamifykit/engine"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -50,7 +53,7 @@ func skipIfNoRedis(t *testing.T) *redis.Client {
 // cleanupTestData removes test data from Redis
 func cleanupTestData(t *testing.T, client *redis.Client, userID core.UserID) {
 	ctx := context.Background()
-	pattern := "user:" + string(userID) + ":*"
+	pattern := "user:" + globEscape(string(userID)) + ":*"
 	keys, err := client.Keys(ctx, pattern).Result()
 	if err == nil && len(keys) > 0 {
 		client.Del(ctx, keys...)
@@ -89,6 +92,69 @@ func TestStore_AddPoints(t *testing.T) {
 	assert.Equal(t, int64(45), total)
 }
 
+func TestStore_Conformance(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	cleanup := func() {
+		for _, user := range storagetest.Users() {
+			cleanupTestData(t, client, user)
+		}
+	}
+	cleanup()
+	defer cleanup()
+
+	store := NewWithClient(client)
+	storagetest.RunConformance(t, func(*testing.T) engine.Storage { return store })
+}
+
+func TestGlobEscape(t *testing.T) {
+	assert.Equal(t, `a\*b\?\[c\]\\d`, globEscape(`a*b?[c]\d`))
+	assert.Equal(t, "alice", globEscape("alice"))
+}
+
+func TestStore_UserIndex(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+	store := NewWithClient(client)
+	ctx := context.Background()
+	for _, user := range []core.UserID{"glob*", "globber", "legacy-user"} {
+		defer cleanupTestData(t, client, user)
+	}
+
+	// a user whose ID is a glob pattern does not see or touch the users it would match
+	_, err := store.AddPoints(ctx, "globber", core.MetricXP, 5)
+	require.NoError(t, err)
+	state, err := store.GetState(ctx, "glob*")
+	require.NoError(t, err)
+	assert.Empty(t, state.Points)
+	exists, err := store.Exists(ctx, "glob*")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	require.NoError(t, store.ReplaceState(ctx, "glob*", core.UserState{Points: map[core.Metric]int64{core.MetricXP: 1}}))
+	state, err = store.GetState(ctx, "globber")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), state.Points[core.MetricXP])
+
+	// keys written without an index are found once BuildIndex ran
+	require.NoError(t, client.Set(ctx, userPointsKey("legacy-user", core.MetricXP), 9, 0).Err())
+	exists, err = store.Exists(ctx, "legacy-user")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	n, err := store.BuildIndex(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, n)
+	state, err = store.buildStateFromKeys(ctx, "legacy-user")
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), state.Points[core.MetricXP])
+}
+
 func TestStore_AddPoints_ZeroDelta(t *testing.T) {
 	// This test doesn't need Redis connection
 	store := &Store{}
@@ -176,6 +242,32 @@ func TestStore_GetState(t *testing.T) {
 	assert.True(t, time.Since(state.Updated) < time.Second)
 }
 
+func TestStore_GetState_UpdatedIsLastWrite(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	store := NewWithClient(client)
+	ctx := context.Background()
+	userID := core.UserID("test-user-updated")
+	cleanupTestData(t, client, userID)
+	defer cleanupTestData(t, client, userID)
+
+	before := core.Now()
+	_, err := store.AddPoints(ctx, userID, core.MetricXP, 10)
+	require.NoError(t, err)
+	written := core.Now()
+	time.Sleep(50 * time.Millisecond)
+
+	// rebuilt from the keys rather than served from the cache
+	state, err := store.buildStateFromKeys(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, state.Updated.Before(before))
+	assert.False(t, state.Updated.After(written))
+}
+
 func TestStore_GetState_Cache(t *testing.T) {
 	client := skipIfNoRedis(t)
 	if client == nil {
@@ -286,6 +378,83 @@ func TestStore_EmptyUser(t *testing.T) {
 	assert.True(t, time.Since(state.Updated) < time.Second)
 }
 
+func TestStore_PointsInWindow(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	store := NewWithClient(client)
+	ctx := context.Background()
+	userID := core.UserID("test-window-user")
+	defer cleanupTestData(t, client, userID)
+
+	_, err := store.AddPoints(ctx, userID, core.MetricXP, 40)
+	require.NoError(t, err)
+	_, err = store.AddPoints(ctx, userID, core.MetricXP, 40) // same delta, same millisecond: both must count
+	require.NoError(t, err)
+
+	// an increment older than the retention is pruned and never counted
+	old := time.Now().Add(-store.retention - time.Hour).UnixMilli()
+	require.NoError(t, client.ZAdd(ctx, userRecentKey(userID, core.MetricXP), redis.Z{Score: float64(old), Member: "1000:old"}).Err())
+
+	sum, err := store.PointsInWindow(ctx, userID, core.MetricXP, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), sum)
+	assert.Equal(t, int64(2), client.ZCard(ctx, userRecentKey(userID, core.MetricXP)).Val())
+
+	_, err = store.PointsInWindow(ctx, userID, core.MetricXP, store.retention+time.Hour)
+	assert.ErrorIs(t, err, core.ErrWindowTooLong)
+}
+
+func TestStore_TransferPoints(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	store := NewWithClient(client)
+	ctx := context.Background()
+	from, to := core.UserID("test-transfer-from"), core.UserID("test-transfer-to")
+	defer cleanupTestData(t, client, from)
+	defer cleanupTestData(t, client, to)
+	var _ engine.PointsTransferer = store
+
+	_, err := store.AddPoints(ctx, from, "coins", 100)
+	require.NoError(t, err)
+	_, err = store.AddPoints(ctx, to, "coins", 10)
+	require.NoError(t, err)
+
+	fromTotal, toTotal, err := store.TransferPoints(ctx, from, to, "coins", 60, 0, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), fromTotal)
+	assert.Equal(t, int64(70), toTotal)
+
+	// the floor and the ceiling are checked in the script and leave both totals unchanged
+	_, _, err = store.TransferPoints(ctx, from, to, "coins", 41, 0, 1000)
+	assert.ErrorIs(t, err, core.ErrInsufficientPoints)
+	_, _, err = store.TransferPoints(ctx, from, to, "coins", 30, 20, 1000)
+	assert.ErrorIs(t, err, core.ErrInsufficientPoints)
+	_, _, err = store.TransferPoints(ctx, from, to, "coins", 40, 0, 100)
+	assert.ErrorIs(t, err, core.ErrReceiverLimit)
+	state, err := store.GetState(ctx, from)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), state.Points["coins"])
+	state, err = store.GetState(ctx, to)
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), state.Points["coins"])
+
+	// both sides are recorded for rolling windows
+	sum, err := store.PointsInWindow(ctx, to, "coins", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), sum)
+	sum, err = store.PointsInWindow(ctx, from, "coins", time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), sum)
+}
+
 func TestRedisKeyParts(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -310,7 +479,7 @@ func TestConfig_DefaultConfig(t *testing.T) {
 	config := DefaultConfig()
 
 	assert.Equal(t, "localhost:6379", config.Addr)
-	assert.Equal(t, "", config.Password)
+	assert.Empty(t, config.Password)
 	assert.Equal(t, 0, config.DB)
 	assert.Equal(t, 10, config.PoolSize)
 	assert.Equal(t, 2, config.MinIdleConns)
@@ -318,3 +487,239 @@ func TestConfig_DefaultConfig(t *testing.T) {
 	assert.Equal(t, 3*time.Second, config.ReadTimeout)
 	assert.Equal(t, 3*time.Second, config.WriteTimeout)
 }
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, DefaultConfig().Validate())
+	assert.NoError(t, Config{Addr: "localhost:6379"}.Validate(), "empty mode is standalone")
+	assert.Error(t, Config{Mode: ModeStandalone}.Validate())
+
+	assert.NoError(t, Config{Mode: ModeCluster, Addrs: []string{"a:7000", "b:7000"}}.Validate())
+	assert.Error(t, Config{Mode: ModeCluster}.Validate())
+	assert.Error(t, Config{Mode: ModeCluster, Addrs: []string{"a:7000"}, DB: 1}.Validate())
+
+	assert.NoError(t, Config{Mode: ModeSentinel, Addrs: []string{"s:26379"}, MasterName: "mymaster"}.Validate())
+	assert.Error(t, Config{Mode: ModeSentinel, Addrs: []string{"s:26379"}}.Validate())
+	assert.Error(t, Config{Mode: ModeSentinel, MasterName: "mymaster"}.Validate())
+
+	assert.Error(t, Config{Mode: "replica", Addr: "localhost:6379"}.Validate())
+
+	assert.NoError(t, Config{Addr: "localhost:6379", KeyPrefix: "gamifykit:prod:"}.Validate())
+	assert.Error(t, Config{Addr: "localhost:6379", KeyPrefix: "app*:"}.Validate(), "glob characters would widen scans")
+	assert.Error(t, Config{Addr: "localhost:6379", KeyPrefix: "{app}:"}.Validate(), "braces would change cluster slots")
+}
+
+func TestNewClient_Modes(t *testing.T) {
+	cluster, err := NewClient(Config{Mode: ModeCluster, Addrs: []string{"localhost:7000"}})
+	require.NoError(t, err)
+	defer cluster.Close()
+	assert.IsType(t, &redis.ClusterClient{}, cluster)
+
+	failover, err := NewClient(Config{Mode: ModeSentinel, Addrs: []string{"localhost:26379"}, MasterName: "mymaster"})
+	require.NoError(t, err)
+	defer failover.Close()
+	assert.IsType(t, &redis.Client{}, failover)
+
+	_, err = NewClient(Config{Mode: ModeCluster})
+	assert.Error(t, err)
+}
+
+func TestStore_ClusterKeysShareSlot(t *testing.T) {
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	defer client.Close()
+	store := NewWithClient(client)
+
+	user := store.user("alice")
+	assert.Equal(t, "user:{alice}:points:xp", userPointsKey(user, core.MetricXP))
+	assert.Equal(t, "user:{alice}:recent:xp", userRecentKey(user, core.MetricXP))
+	assert.Equal(t, core.UserID("alice"), store.keyUser(redisKeyParts(userBadgesKey(user))[1]))
+
+	// standalone keys are unchanged
+	plain := NewWithClient(redisClient())
+	assert.Equal(t, "user:alice:points:xp", userPointsKey(plain.user("alice"), core.MetricXP))
+
+	// the prefix goes in front of the hash tag, which keeps deciding the slot
+	store.prefix = "gamifykit:prod:"
+	assert.Equal(t, "gamifykit:prod:user:{alice}:points:xp", store.pointsKey("alice", core.MetricXP))
+	assert.Equal(t, core.UserID("alice"), store.keyUser(store.keyParts(store.badgesKey("alice"))[1]))
+}
+
+func TestClassify(t *testing.T) {
+	assert.ErrorIs(t, classify(context.Background(), redis.ErrPoolTimeout), core.ErrBackendBusy)
+	assert.ErrorIs(t, classify(context.Background(), redisError("LOADING Redis is loading the dataset in memory")), core.ErrBackendBusy)
+	assert.False(t, core.IsTransient(classify(context.Background(), redisError("WRONGTYPE Operation against a key holding the wrong kind of value"))))
+}
+
+// redisError is a server error reply
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+func (e redisError) RedisError()   {}
+
+func TestStateCacheEncoding(t *testing.T) {
+	state := core.UserState{UserID: "alice", Points: map[core.Metric]int64{core.MetricXP: 42}}
+	data, err := encodeState(state)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"v":1`)
+	decoded, err := decodeState(data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), decoded.Points[core.MetricXP])
+
+	// a bare state from before versioning is not misread as an empty one
+	_, err = decodeState([]byte(`{"user_id":"alice","points":{"xp":42}}`))
+	assert.ErrorIs(t, err, errStaleCache)
+	_, err = decodeState([]byte(`{"v":2,"state":{}}`))
+	assert.ErrorIs(t, err, errStaleCache)
+}
+
+func TestStore_KeyPrefix(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	userID := core.UserID("test-user-prefix")
+	prod := NewWithClient(client)
+	prod.prefix = "test-prod:"
+	staging := NewWithClient(client)
+	staging.prefix = "test-staging:"
+	defer func() {
+		keys, _ := client.Keys(ctx, "test-prod:*").Result()
+		keys2, _ := client.Keys(ctx, "test-staging:*").Result()
+		if keys = append(keys, keys2...); len(keys) > 0 {
+			client.Del(ctx, keys...)
+		}
+	}()
+
+	_, err := prod.AddPoints(ctx, userID, core.MetricXP, 10)
+	require.NoError(t, err)
+	require.NoError(t, prod.AwardBadge(ctx, userID, "prod-only"))
+	n, err := client.Exists(ctx, "test-prod:"+userPointsKey(userID, core.MetricXP)).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	st, err := staging.GetState(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, st.Points, "environments sharing Redis do not see each other's users")
+	exists, err := staging.Exists(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	var users []core.UserID
+	require.NoError(t, prod.EachUser(ctx, func(u core.UserID) error { users = append(users, u); return nil }))
+	assert.Equal(t, []core.UserID{userID}, users)
+
+	// a cache entry in an older encoding is rebuilt rather than misread
+	require.NoError(t, client.Set(ctx, prod.stateKey(userID), `{"user_id":"test-user-prefix"}`, time.Minute).Err())
+	st, err = prod.GetState(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), st.Points[core.MetricXP])
+}
+
+func TestRateLimitStore_Take(t *testing.T) {
+	client := skipIfNoRedis(t)
+	defer client.Close()
+	ctx := context.Background()
+	store := NewRateLimitStore(client, "test:")
+	defer client.Del(ctx, "test:ratelimit:client-a")
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := store.Take(ctx, "client-a", 1, 2)
+		require.NoError(t, err)
+		assert.True(t, ok, "burst token %d", i+1)
+	}
+	ok, retryAfter, err := store.Take(ctx, "client-a", 1, 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter, time.Duration(0))
+	assert.LessOrEqual(t, retryAfter, time.Second)
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	client := skipIfNoRedis(t)
+	defer client.Close()
+	ctx := context.Background()
+	store := NewIdempotencyStore(client, "test:")
+	defer client.Del(ctx, "test:idempotency:k")
+
+	ok, stored, err := store.Reserve(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, stored)
+
+	ok, stored, err = store.Reserve(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, stored, "in progress")
+
+	require.NoError(t, store.Complete(ctx, "k", []byte(`{"status":200}`), time.Minute))
+	ok, stored, err = store.Reserve(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, `{"status":200}`, string(stored))
+
+	require.NoError(t, store.Release(ctx, "k"))
+	ok, _, err = store.Reserve(ctx, "k", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+var _ engine.UserMerger = (*Store)(nil)
+
+func TestStore_MergeUsers(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	store := NewWithClient(client)
+	ctx := context.Background()
+	source, target := core.UserID("test-merge-guest"), core.UserID("test-merge-alice")
+	cleanupTestData(t, client, source)
+	cleanupTestData(t, client, target)
+	defer cleanupTestData(t, client, source)
+	defer cleanupTestData(t, client, target)
+
+	_, err := store.AddPoints(ctx, source, core.MetricXP, 40)
+	require.NoError(t, err)
+	require.NoError(t, store.AwardBadge(ctx, source, "explorer"))
+	_, _, err = store.RepeatBadge(ctx, source, "streak", time.Now(), time.Hour)
+	require.NoError(t, err)
+	_, err = store.AddPoints(ctx, target, core.MetricXP, 30)
+	require.NoError(t, err)
+
+	sum := func(src, dst core.UserState) (core.UserState, error) {
+		dst.Points[core.MetricXP] += src.Points[core.MetricXP]
+		for b := range src.Badges {
+			dst.Badges[b] = struct{}{}
+		}
+		return dst, nil
+	}
+	before, after, err := store.MergeUsers(ctx, source, target, sum)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), before.Points[core.MetricXP])
+	assert.Equal(t, int64(70), after.Points[core.MetricXP])
+
+	state, err := store.GetState(ctx, target)
+	require.NoError(t, err)
+	assert.Equal(t, int64(70), state.Points[core.MetricXP])
+	assert.Contains(t, state.Badges, core.Badge("explorer"))
+	rec, err := store.BadgeRecord(ctx, target, "streak")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rec.Count)
+
+	exists, err := store.Exists(ctx, source)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	keys, err := client.Keys(ctx, "user:"+globEscape(string(source))+":*").Result()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	failed := errors.New("merge refused")
+	_, _, err = store.MergeUsers(ctx, target, source, func(core.UserState, core.UserState) (core.UserState, error) { return core.UserState{}, failed })
+	assert.ErrorIs(t, err, failed)
+	_, _, err = store.MergeUsers(ctx, target, target, sum)
+	assert.ErrorIs(t, err, core.ErrSelfMerge)
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gamifykit/core"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrClusterTransfer is returned by TransferPoints in cluster mode, where the two users' keys live
+// in different hash slots and cannot be changed by one script.
+var ErrClusterTransfer = errors.New("redis: transfers between users are not supported in cluster mode")
+
+// transferScript moves ARGV[1] points from the user of KEYS[1..3] to the user of KEYS[4..6] when the
+// sender holds at least ARGV[2] and the receiver at most ARGV[3]. Totals are compared as decimal
+// strings, since Lua numbers cannot hold every int64. It returns {code, sender total, receiver
+// total}, code 1 meaning the sender is short and 2 that the receiver is at its limit.
+var transferScript = redis.NewScript(`
+	local function cmp(a, b)
+		local na, nb = a:sub(1, 1) == '-', b:sub(1, 1) == '-'
+		if na ~= nb then
+			return na and -1 or 1
+		end
+		if na then
+			a, b = b:sub(2), a:sub(2)
+		end
+		if #a ~= #b then
+			return #a < #b and -1 or 1
+		end
+		if a == b then
+			return 0
+		end
+		return a < b and -1 or 1
+	end
+
+	local have = redis.call('GET', KEYS[1]) or '0'
+	local held = redis.call('GET', KEYS[4]) or '0'
+	if cmp(have, ARGV[2]) < 0 then
+		return {1, have, held}
+	end
+	if cmp(held, ARGV[3]) > 0 then
+		return {2, have, held}
+	end
+
+	redis.call('DECRBY', KEYS[1], ARGV[1])
+	redis.call('INCRBY', KEYS[4], ARGV[1])
+	redis.call('ZADD', KEYS[2], ARGV[4], '-' .. ARGV[1] .. ':' .. ARGV[5])
+	redis.call('ZADD', KEYS[5], ARGV[4], ARGV[1] .. ':' .. ARGV[6])
+	redis.call('PEXPIRE', KEYS[2], ARGV[7])
+	redis.call('PEXPIRE', KEYS[5], ARGV[7])
+	redis.call('SADD', KEYS[3], ARGV[8])
+	redis.call('SADD', KEYS[6], ARGV[8])
+	return {0, redis.call('GET', KEYS[1]), redis.call('GET', KEYS[4])}
+`)
+
+// TransferPoints moves amount points of metric from one user to another in one script, checking
+// the sender's floor and the receiver's ceiling inside it, so a failure cannot debit one side
+// without crediting the other (see engine.PointsTransferer). Both increments are recorded for
+// rolling windows like AddPoints does. It fails with ErrClusterTransfer in cluster mode.
+func (s *Store) TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (_ int64, _ int64, err error) {
+	ctx, span := s.span(ctx, "TransferPoints", from)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	if s.cluster {
+		return 0, 0, ErrClusterTransfer
+	}
+
+	// the sender needs floor+amount and the receiver may hold ceiling-amount; bounds that overflow
+	// cannot be met
+	need, err := core.AddSafe(floor, amount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s cannot send %d %s", core.ErrInsufficientPoints, from, amount, metric)
+	}
+	limit, err := core.SubSafe(ceiling, amount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s cannot receive %d %s", core.ErrReceiverLimit, to, amount, metric)
+	}
+
+	keys := []string{
+		s.pointsKey(from, metric), s.recentKey(from, metric), s.indexKey(from),
+		s.pointsKey(to, metric), s.recentKey(to, metric), s.indexKey(to),
+	}
+	now := time.Now()
+	res, err := transferScript.Run(ctx, s.client, keys, amount, need, limit, now.UnixMilli(), incrementID(now), incrementID(now), s.retention.Milliseconds(), indexPoints(metric)).Slice()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to transfer points: %w", err)
+	}
+	if len(res) != 3 {
+		return 0, 0, errors.New("unexpected result from Redis transfer script")
+	}
+	fromTotal, err := scriptInt(res[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	toTotal, err := scriptInt(res[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	switch res[0] {
+	case int64(1):
+		return 0, 0, fmt.Errorf("%w: %s has %d %s, cannot send %d", core.ErrInsufficientPoints, from, fromTotal, metric, amount)
+	case int64(2):
+		return 0, 0, fmt.Errorf("%w: %s cannot receive %d %s", core.ErrReceiverLimit, to, amount, metric)
+	}
+
+	s.invalidateStateCache(ctx, from)
+	s.invalidateStateCache(ctx, to)
+	return fromTotal, toTotal, nil
+}
+
+// scriptInt parses a total a script returned as a string
+func scriptInt(v interface{}) (int64, error) {
+	str, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected total %v from Redis script", v)
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
@@ -0,0 +1,98 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/leaderboard"
+)
+
+// ArchiveStore implements leaderboard.ArchiveStore on the leaderboard_archive table.
+type ArchiveStore struct {
+	store *Store
+}
+
+// LeaderboardArchive returns a leaderboard archive backed by the same database
+func (s *Store) LeaderboardArchive() *ArchiveStore {
+	return &ArchiveStore{store: s}
+}
+
+// emptyPeriodPosition marks a period archived with no standings, so it loads as an empty list
+const emptyPeriodPosition = 0
+
+// SaveArchive stores the standings unless the period is already archived
+func (a *ArchiveStore) SaveArchive(ctx context.Context, board, period string, standings []leaderboard.Standing) error {
+	insert := `INSERT INTO leaderboard_archive (board, period, position, user_id, score, archived_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`
+	if a.store.driver == DriverMySQL {
+		insert = `INSERT IGNORE INTO leaderboard_archive (board, period, position, user_id, score, archived_at) VALUES (?, ?, ?, ?, ?, ?)`
+	}
+	insert = a.store.db.Rebind(insert)
+
+	tx, err := a.store.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var archived int
+	err = tx.QueryRowxContext(ctx, a.store.db.Rebind(`SELECT COUNT(*) FROM leaderboard_archive WHERE board = ? AND period = ?`), board, period).Scan(&archived)
+	if err != nil {
+		return fmt.Errorf("failed to check archive: %w", err)
+	}
+	if archived > 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	if len(standings) == 0 {
+		if _, err := tx.ExecContext(ctx, insert, board, period, emptyPeriodPosition, "", 0, now); err != nil {
+			return fmt.Errorf("failed to archive empty period: %w", err)
+		}
+	}
+	for _, st := range standings {
+		if _, err := tx.ExecContext(ctx, insert, board, period, st.Rank, st.User, st.Score, now); err != nil {
+			return fmt.Errorf("failed to archive standing: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit archive: %w", err)
+	}
+	return nil
+}
+
+// LoadArchive returns the archived standings best first, an empty list for a period archived
+// with no standings, or leaderboard.ErrArchiveNotFound
+func (a *ArchiveStore) LoadArchive(ctx context.Context, board, period string) ([]leaderboard.Standing, error) {
+	query := a.store.db.Rebind(`SELECT position, user_id, score FROM leaderboard_archive WHERE board = ? AND period = ? ORDER BY position`)
+	rows, err := a.store.db.QueryContext(ctx, query, board, period)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archive: %w", err)
+	}
+	defer rows.Close()
+
+	out := []leaderboard.Standing{}
+	found := false
+	for rows.Next() {
+		var st leaderboard.Standing
+		var user string
+		if err := rows.Scan(&st.Rank, &user, &st.Score); err != nil {
+			return nil, fmt.Errorf("failed to scan standing: %w", err)
+		}
+		found = true
+		if st.Rank == emptyPeriodPosition {
+			continue
+		}
+		st.User = core.UserID(user)
+		out = append(out, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, leaderboard.ErrArchiveNotFound
+	}
+	return out, nil
+}
+
+var _ leaderboard.ArchiveStore = (*ArchiveStore)(nil)
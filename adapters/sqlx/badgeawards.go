@@ -0,0 +1,88 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gamifykit/core"
+)
+
+// RepeatBadge awards a repeatable badge and counts the award unless the last one lies within
+// cooldown. The user's badge_awards row is created first and then read FOR UPDATE, so concurrent
+// awards are serialized and at most one of them succeeds within the cooldown. A soft-deleted row
+// starts again from zero.
+func (s *Store) RepeatBadge(ctx context.Context, userID core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (rec core.BadgeRecord, awarded bool, err error) {
+	ctx, span := s.span(ctx, "RepeatBadge", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return rec, false, err
+	}
+	defer s.rollback(tx)
+
+	insertQuery := `INSERT INTO badge_awards (user_id, badge, award_count) VALUES ($1, $2, 0) ON CONFLICT (user_id, badge) DO NOTHING`
+	if s.driver == DriverMySQL {
+		insertQuery = `INSERT INTO badge_awards (user_id, badge, award_count) VALUES (?, ?, 0) ON DUPLICATE KEY UPDATE badge = badge`
+	}
+	if _, err := tx.ExecContext(ctx, insertQuery, userID, badge); err != nil {
+		return rec, false, fmt.Errorf("failed to create badge record: %w", err)
+	}
+
+	var last sql.NullTime
+	var deleted bool
+	query := tx.Rebind(`SELECT award_count, last_awarded_at, deleted_at IS NOT NULL FROM badge_awards WHERE user_id = ? AND badge = ? FOR UPDATE`)
+	if err := tx.QueryRowContext(ctx, query, userID, badge).Scan(&rec.Count, &last, &deleted); err != nil {
+		return rec, false, fmt.Errorf("failed to read badge record: %w", err)
+	}
+	if deleted {
+		rec, last = core.BadgeRecord{}, sql.NullTime{}
+	}
+	if last.Valid {
+		rec.LastAwarded = last.Time.UTC()
+	}
+	if rec.Count > 0 && now.Before(rec.LastAwarded.Add(cooldown)) {
+		return rec, false, nil
+	}
+
+	rec = core.BadgeRecord{Count: rec.Count + 1, LastAwarded: now.UTC()}
+	update := tx.Rebind(`UPDATE badge_awards SET award_count = ?, last_awarded_at = ?, deleted_at = NULL WHERE user_id = ? AND badge = ?`)
+	if _, err := tx.ExecContext(ctx, update, rec.Count, rec.LastAwarded, userID, badge); err != nil {
+		return rec, false, fmt.Errorf("failed to update badge record: %w", err)
+	}
+	if _, err := s.awardBadge(ctx, tx, userID, badge, rec.LastAwarded); err != nil {
+		return rec, false, err
+	}
+	ev := core.NewBadgeAwarded(userID, badge)
+	ev.Metadata = map[string]any{"count": rec.Count}
+	if err := s.stage(ctx, tx, ev); err != nil {
+		return rec, false, err
+	}
+
+	if err := s.commit(tx); err != nil {
+		return rec, false, err
+	}
+	return rec, true, nil
+}
+
+// BadgeRecord returns the award count and last award time of a repeatable badge; the zero record
+// if the user never earned it or the record is soft-deleted.
+func (s *Store) BadgeRecord(ctx context.Context, userID core.UserID, badge core.Badge) (_ core.BadgeRecord, err error) {
+	defer func() { err = classify(ctx, err) }()
+	var rec core.BadgeRecord
+	var last sql.NullTime
+	query := s.db.Rebind(`SELECT award_count, last_awarded_at FROM badge_awards WHERE user_id = ? AND badge = ? AND deleted_at IS NULL`)
+	err = s.queryer().QueryRowxContext(ctx, query, userID, badge).Scan(&rec.Count, &last)
+	if err == sql.ErrNoRows {
+		return core.BadgeRecord{}, nil
+	}
+	if err != nil {
+		return core.BadgeRecord{}, fmt.Errorf("failed to get badge record: %w", err)
+	}
+	if last.Valid {
+		rec.LastAwarded = last.Time.UTC()
+	}
+	return rec, nil
+}
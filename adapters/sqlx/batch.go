@@ -0,0 +1,114 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"gamifykit/core"
+)
+
+// stateBatchSize bounds the user IDs of one IN list in GetStateMany
+const stateBatchSize = 500
+
+// GetStateMany loads the states of users with three queries per 500 users instead of three per
+// user. Failures are reported per user (see engine.StateBatchGetter): a row with a NULL value
+// fails only its user, and a query that fails, e.g. on a dropped connection, fails only the users
+// of its chunk. Users without rows get an empty state, as with GetState, and Updated is the latest
+// write time of a user's rows.
+func (s *Store) GetStateMany(ctx context.Context, users []core.UserID) (_ map[core.UserID]core.UserState, err error) {
+	defer func() { err = classify(ctx, err) }()
+	states := make(map[core.UserID]core.UserState, len(users))
+	failed := map[core.UserID]error{}
+	for start := 0; start < len(users); start += stateBatchSize {
+		chunk := users[start:min(start+stateBatchSize, len(users))]
+		for _, u := range chunk {
+			states[u] = core.UserState{UserID: u, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{}}
+		}
+		if err := s.loadChunk(ctx, chunk, states, failed); err != nil {
+			for _, u := range chunk {
+				if _, ok := failed[u]; !ok {
+					failed[u] = err
+				}
+			}
+		}
+	}
+	for u := range failed {
+		delete(states, u)
+	}
+	return states, core.NewBatchError(failed)
+}
+
+// loadChunk fills the states of chunk, recording users with invalid rows in failed. An error
+// means a query failed and the chunk's states are incomplete.
+func (s *Store) loadChunk(ctx context.Context, chunk []core.UserID, states map[core.UserID]core.UserState, failed map[core.UserID]error) error {
+	tables := []struct {
+		what  string
+		query string
+		apply func(st core.UserState, key sql.NullString, value sql.NullInt64) error
+	}{
+		{"points", `SELECT user_id, metric, points, updated_at FROM user_points WHERE user_id IN (?) AND deleted_at IS NULL`,
+			func(st core.UserState, metric sql.NullString, points sql.NullInt64) error {
+				if !metric.Valid || !points.Valid {
+					return fmt.Errorf("invalid points row for metric %q", metric.String)
+				}
+				st.Points[core.Metric(metric.String)] = points.Int64
+				return nil
+			}},
+		{"badges", `SELECT user_id, badge, 0, awarded_at FROM user_badges WHERE user_id IN (?) AND deleted_at IS NULL`,
+			func(st core.UserState, badge sql.NullString, _ sql.NullInt64) error {
+				if !badge.Valid {
+					return fmt.Errorf("invalid badge row")
+				}
+				st.Badges[core.Badge(badge.String)] = struct{}{}
+				return nil
+			}},
+		{"levels", `SELECT user_id, metric, level, updated_at FROM user_levels WHERE user_id IN (?) AND deleted_at IS NULL`,
+			func(st core.UserState, metric sql.NullString, level sql.NullInt64) error {
+				if !metric.Valid || !level.Valid {
+					return fmt.Errorf("invalid level row for metric %q", metric.String)
+				}
+				st.Levels[core.Metric(metric.String)] = level.Int64
+				return nil
+			}},
+	}
+	for _, t := range tables {
+		query, args, err := sqlx.In(t.query, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to build %s query: %w", t.what, err)
+		}
+		rows, err := s.queryer().QueryxContext(ctx, s.db.Rebind(query), args...)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", t.what, err)
+		}
+		for rows.Next() {
+			var user string
+			var key sql.NullString
+			var value sql.NullInt64
+			var at sql.NullTime
+			if err := rows.Scan(&user, &key, &value, &at); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s: %w", t.what, err)
+			}
+			st, ok := states[core.UserID(user)]
+			if !ok {
+				continue // e.g. an ID matched by a case-insensitive collation
+			}
+			if err := t.apply(st, key, value); err != nil {
+				failed[core.UserID(user)] = err
+			}
+			if at.Valid && at.Time.After(st.Updated) {
+				st.Updated = core.Timestamp(at.Time)
+				states[core.UserID(user)] = st
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read %s: %w", t.what, err)
+		}
+		rows.Close()
+	}
+	return nil
+}
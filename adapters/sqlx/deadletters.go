@@ -0,0 +1,129 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gamifykit/analytics"
+)
+
+// DeadLetterStore implements analytics.DeadLetterStore on the dead_letters table,
+// letting several server instances share one dead letter queue.
+type DeadLetterStore struct {
+	store *Store
+}
+
+// DeadLetters returns a dead letter store backed by the same database
+func (s *Store) DeadLetters() *DeadLetterStore {
+	return &DeadLetterStore{store: s}
+}
+
+// Add inserts a new dead letter
+func (d *DeadLetterStore) Add(ctx context.Context, dl analytics.DeadLetter) error {
+	query := `
+		INSERT INTO dead_letters (id, target, payload, attempts, last_error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if d.store.driver == DriverMySQL {
+		query = `
+			INSERT INTO dead_letters (id, target, payload, attempts, last_error, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`
+	}
+
+	_, err := d.store.db.ExecContext(ctx, query, dl.ID, dl.Target, string(dl.Payload), dl.Attempts, dl.LastError, dl.CreatedAt.UTC(), dl.UpdatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to add dead letter: %w", err)
+	}
+	return nil
+}
+
+// List returns dead letters matching filter, oldest first
+func (d *DeadLetterStore) List(ctx context.Context, filter analytics.DeadLetterFilter) ([]analytics.DeadLetter, error) {
+	query := `SELECT id, target, payload, attempts, last_error, created_at, updated_at FROM dead_letters WHERE 1 = 1`
+	var args []any
+	if filter.ID != "" {
+		args = append(args, filter.ID)
+		query += " AND id = ?"
+	}
+	if filter.Target != "" {
+		args = append(args, filter.Target)
+		query += " AND target = ?"
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		query += " AND created_at >= ?"
+	}
+	query += " ORDER BY created_at, id"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	// Built with ? placeholders; Rebind converts them for PostgreSQL
+	rows, err := d.store.db.QueryContext(ctx, d.store.db.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var out []analytics.DeadLetter
+	for rows.Next() {
+		var dl analytics.DeadLetter
+		var payload string
+		var created, updated time.Time
+		if err := rows.Scan(&dl.ID, &dl.Target, &payload, &dl.Attempts, &dl.LastError, &created, &updated); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		dl.Payload = []byte(payload)
+		dl.CreatedAt = created.UTC()
+		dl.UpdatedAt = updated.UTC()
+		out = append(out, dl)
+	}
+	return out, rows.Err()
+}
+
+// Update records a failed replay attempt
+func (d *DeadLetterStore) Update(ctx context.Context, dl analytics.DeadLetter) error {
+	query := `
+		UPDATE dead_letters
+		SET attempts = $1, last_error = $2, updated_at = $3
+		WHERE id = $4
+	`
+	if d.store.driver == DriverMySQL {
+		query = `
+			UPDATE dead_letters
+			SET attempts = ?, last_error = ?, updated_at = ?
+			WHERE id = ?
+		`
+	}
+
+	if _, err := d.store.db.ExecContext(ctx, query, dl.Attempts, dl.LastError, dl.UpdatedAt.UTC(), dl.ID); err != nil {
+		return fmt.Errorf("failed to update dead letter: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a dead letter once it has been delivered
+func (d *DeadLetterStore) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM dead_letters WHERE id = $1`
+	if d.store.driver == DriverMySQL {
+		query = `DELETE FROM dead_letters WHERE id = ?`
+	}
+
+	if _, err := d.store.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to delete dead letter: %w", err)
+	}
+	return nil
+}
+
+// Count returns the number of stored dead letters
+func (d *DeadLetterStore) Count(ctx context.Context) (int, error) {
+	var n int
+	if err := d.store.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM dead_letters`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	return n, nil
+}
+
+var _ analytics.DeadLetterStore = (*DeadLetterStore)(nil)
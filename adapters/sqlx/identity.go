@@ -0,0 +1,95 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"gamifykit/core"
+)
+
+// LinkIdentity links the external ID to userID unless it is already linked, and returns the user
+// it is linked to. The alias is the user_aliases primary key, so concurrent links of one alias
+// cannot both succeed.
+func (s *Store) LinkIdentity(ctx context.Context, identity string, userID core.UserID) (_ core.UserID, err error) {
+	ctx, span := s.span(ctx, "LinkIdentity", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer s.rollback(tx)
+
+	insertQuery := `INSERT INTO user_aliases (alias, user_id, created_at) VALUES (?, ?, ?) ON CONFLICT (alias) DO NOTHING`
+	if s.driver == DriverMySQL {
+		insertQuery = `INSERT INTO user_aliases (alias, user_id, created_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE alias = alias`
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(insertQuery), identity, userID, time.Now().UTC()); err != nil {
+		return "", fmt.Errorf("failed to link identity: %w", err)
+	}
+	var holder core.UserID
+	if err := tx.GetContext(ctx, &holder, tx.Rebind(`SELECT user_id FROM user_aliases WHERE alias = ?`), identity); err != nil {
+		return "", fmt.Errorf("failed to read identity link: %w", err)
+	}
+	if err := s.commit(tx); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return holder, nil
+}
+
+// UnlinkIdentity removes the external ID's link and reports whether there was one.
+func (s *Store) UnlinkIdentity(ctx context.Context, identity string) (_ bool, err error) {
+	ctx, span := s.span(ctx, "UnlinkIdentity", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer s.rollback(tx)
+	res, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM user_aliases WHERE alias = ?`), identity)
+	if err != nil {
+		return false, fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count unlinked identities: %w", err)
+	}
+	if err := s.commit(tx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ResolveIdentity returns the user the external ID is linked to.
+func (s *Store) ResolveIdentity(ctx context.Context, identity string) (_ core.UserID, _ bool, err error) {
+	ctx, span := s.span(ctx, "ResolveIdentity", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	var user core.UserID
+	err = sqlx.GetContext(ctx, s.queryer(), &user, s.db.Rebind(`SELECT user_id FROM user_aliases WHERE alias = ?`), identity)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "", false, nil
+	case err != nil:
+		return "", false, fmt.Errorf("failed to resolve identity: %w", err)
+	}
+	return user, true, nil
+}
+
+// Identities returns the external IDs linked to userID, sorted.
+func (s *Store) Identities(ctx context.Context, userID core.UserID) (_ []string, err error) {
+	ctx, span := s.span(ctx, "Identities", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	identities := []string{}
+	if err := sqlx.SelectContext(ctx, s.queryer(), &identities, s.db.Rebind(`SELECT alias FROM user_aliases WHERE user_id = ? ORDER BY alias`), userID); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
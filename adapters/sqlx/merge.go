@@ -0,0 +1,64 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// MergeUsers writes merge(source, target) as the target's state and deletes the source in one
+// transaction, with both users' rows locked in ID order. The target's state is written like
+// ReplaceState and the source deleted like DeleteUser, so Config.SoftDelete applies to it, but the
+// source's identity links are moved to the target rather than deleted.
+func (s *Store) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (before, after core.UserState, err error) {
+	ctx, span := s.span(ctx, "MergeUsers", target)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	if source == target {
+		return core.UserState{}, core.UserState{}, core.ErrSelfMerge
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	defer s.rollback(tx)
+	bound := s.BindTx(tx)
+
+	first, second := source, target
+	if second < first {
+		first, second = second, first
+	}
+	for _, user := range []core.UserID{first, second} {
+		if err := bound.LockUser(ctx, user); err != nil {
+			return core.UserState{}, core.UserState{}, err
+		}
+	}
+	src, err := bound.GetState(ctx, source)
+	if err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	if before, err = bound.GetState(ctx, target); err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	if after, err = merge(src, before.Clone()); err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	after.UserID = target
+	if err := bound.ReplaceState(ctx, target, after); err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(`UPDATE user_aliases SET user_id = ? WHERE user_id = ?`), target, source); err != nil {
+		return core.UserState{}, core.UserState{}, fmt.Errorf("failed to move aliases: %w", err)
+	}
+	if err := bound.DeleteUser(ctx, source); err != nil {
+		return core.UserState{}, core.UserState{}, err
+	}
+	if err := s.commit(tx); err != nil {
+		return core.UserState{}, core.UserState{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return before, after, nil
+}
+
+var _ engine.UserMerger = (*Store)(nil)
@@ -0,0 +1,226 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MigrationsTable records the migrations applied to a database, one row per version.
+const MigrationsTable = "schema_migrations"
+
+// ErrMigrationsUntracked is returned by MigrationStatus and Pending for a database without
+// MigrationsTable, i.e. one Migrate never ran on; Migrate creates the table.
+var ErrMigrationsUntracked = errors.New(MigrationsTable + " does not exist, the database is untracked")
+
+// Migration is the state of one embedded migration in a database.
+type Migration struct {
+	// Version is the migration's file name without extension, e.g. "004_point_events".
+	Version string
+	// Applied reports whether the database has the migration; AppliedAt is when it was recorded.
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrationFile is an embedded migration and its SQL
+type migrationFile struct {
+	version string
+	sql     string
+}
+
+// Migrate applies the embedded migrations the database does not have yet, in version order, and
+// returns the versions it applied. Each migration is recorded in MigrationsTable in the same
+// transaction as its statements, so on PostgreSQL a failed migration leaves nothing behind and
+// two concurrent runs cannot both apply it. New calls Migrate unless Config.SkipMigrations is set,
+// then VerifySchema unless Config.SkipSchemaCheck is set.
+func (s *Store) Migrate(ctx context.Context) ([]string, error) {
+	files, err := readMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := s.trackMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []string
+	for _, f := range files {
+		if _, ok := applied[f.version]; ok {
+			continue
+		}
+		if err := s.applyMigration(ctx, f); err != nil {
+			return ran, err
+		}
+		ran = append(ran, f.version)
+	}
+	return ran, nil
+}
+
+// MigrationStatus lists every embedded migration in version order with whether the database has
+// it. It only reads: a database without MigrationsTable fails with ErrMigrationsUntracked rather
+// than getting the table.
+func (s *Store) MigrationStatus(ctx context.Context) ([]Migration, error) {
+	files, err := readMigrations()
+	if err != nil {
+		return nil, err
+	}
+	tracked, err := s.hasTable(ctx, MigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	if !tracked {
+		return nil, ErrMigrationsUntracked
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Migration, 0, len(files))
+	for _, f := range files {
+		at, ok := applied[f.version]
+		out = append(out, Migration{Version: f.version, Applied: ok, AppliedAt: at})
+	}
+	return out, nil
+}
+
+// Pending returns the versions Migrate would apply, without changing the database; see MigrationStatus.
+func (s *Store) Pending(ctx context.Context) ([]string, error) {
+	status, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, m := range status {
+		if !m.Applied {
+			pending = append(pending, m.Version)
+		}
+	}
+	return pending, nil
+}
+
+// readMigrations returns the embedded migrations sorted by version
+func readMigrations() ([]migrationFile, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+	var files []migrationFile
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		content, err := migrationsFS.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		files = append(files, migrationFile{version: strings.TrimSuffix(entry.Name(), ".sql"), sql: string(content)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].version < files[j].version })
+	return files, nil
+}
+
+// trackMigrations creates the tracking table if needed and returns the recorded versions.
+// Databases migrated before versions were tracked already have the initial schema; it is
+// recorded as applied so only the later, idempotent migrations run again.
+func (s *Store) trackMigrations(ctx context.Context) (map[string]time.Time, error) {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+MigrationsTable+` (
+		version VARCHAR(255) PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", MigrationsTable, err)
+	}
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(applied) == 0 {
+		legacy, err := s.hasTable(ctx, "user_points")
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			files, err := readMigrations()
+			if err != nil {
+				return nil, err
+			}
+			now := time.Now().UTC()
+			if err := s.recordMigration(ctx, s.db, files[0].version, now); err != nil {
+				return nil, err
+			}
+			applied[files[0].version] = now
+		}
+	}
+	return applied, nil
+}
+
+// appliedMigrations reads the versions recorded in the tracking table
+func (s *Store) appliedMigrations(ctx context.Context) (map[string]time.Time, error) {
+	var rows []struct {
+		Version   string    `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := sqlx.SelectContext(ctx, s.db, &rows, `SELECT version, applied_at FROM `+MigrationsTable); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	applied := make(map[string]time.Time, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// applyMigration runs one migration and records it in the same transaction
+func (s *Store) applyMigration(ctx context.Context, f migrationFile) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, f.sql); err != nil {
+		return fmt.Errorf("failed to execute migration %s: %w", f.version, err)
+	}
+	if err := s.recordMigration(ctx, tx, f.version, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %s: %w", f.version, err)
+	}
+	return nil
+}
+
+func (s *Store) recordMigration(ctx context.Context, db sqlx.ExtContext, version string, at time.Time) error {
+	query := db.Rebind(`INSERT INTO ` + MigrationsTable + ` (version, applied_at) VALUES (?, ?)`)
+	if _, err := db.ExecContext(ctx, query, version, at); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", version, err)
+	}
+	return nil
+}
+
+// hasTable reports whether the current schema has a table
+func (s *Store) hasTable(ctx context.Context, name string) (bool, error) {
+	query := s.db.Rebind(`SELECT 1 FROM information_schema.tables WHERE table_name = ? AND table_schema = ` + currentSchema(s.driver))
+	var one int
+	err := s.db.QueryRowxContext(ctx, query, name).Scan(&one)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to look up table %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func currentSchema(driver Driver) string {
+	if driver == DriverMySQL {
+		return "DATABASE()"
+	}
+	return "current_schema()"
+}
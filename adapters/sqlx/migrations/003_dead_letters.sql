@@ -0,0 +1,12 @@
+-- Dead letters for external deliveries that failed after exhausting retries
+-- Written by DeadLetterStore and drained by analytics.ReplayDeadLetters
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id VARCHAR(64) PRIMARY KEY,
+    target VARCHAR(1024) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
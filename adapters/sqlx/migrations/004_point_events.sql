@@ -0,0 +1,12 @@
+-- Timestamped point increments for rolling-window queries (Store.PointsInWindow)
+-- Rows older than the configured retention are pruned as windows are queried
+
+CREATE TABLE IF NOT EXISTS point_events (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    metric VARCHAR(255) NOT NULL,
+    delta BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_point_events_user_metric_time ON point_events(user_id, metric, created_at);
@@ -0,0 +1,12 @@
+-- Final standings of ended leaderboard periods (leaderboard.WindowedBoard archives)
+-- Written once per board and period by ArchiveStore.SaveArchive
+
+CREATE TABLE IF NOT EXISTS leaderboard_archive (
+    board VARCHAR(255) NOT NULL,
+    period VARCHAR(64) NOT NULL,
+    position INTEGER NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    score BIGINT NOT NULL,
+    archived_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (board, period, position)
+);
@@ -0,0 +1,12 @@
+-- Tombstones for soft-deleted rows (Config.SoftDelete)
+-- Rows with deleted_at set are hidden from reads until Store.PurgeDeleted removes them
+
+ALTER TABLE user_points ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP NULL;
+ALTER TABLE user_badges ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP NULL;
+ALTER TABLE user_levels ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP NULL;
+ALTER TABLE point_events ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP NULL;
+
+CREATE INDEX IF NOT EXISTS idx_user_points_deleted_at ON user_points(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_badges_deleted_at ON user_badges(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_user_levels_deleted_at ON user_levels(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_point_events_deleted_at ON point_events(deleted_at);
@@ -0,0 +1,15 @@
+-- Transactional outbox (Config.Outbox)
+-- Events are written in the same transaction as the state change they report and published by an OutboxRelay
+
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id SERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    sent_at TIMESTAMP NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_outbox_unsent ON event_outbox(sent_at, id);
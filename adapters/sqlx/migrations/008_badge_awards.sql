@@ -0,0 +1,13 @@
+-- Award counts of repeatable badges (Store.RepeatBadge)
+-- One row per user and badge; the badge itself is held in user_badges as usual
+
+CREATE TABLE IF NOT EXISTS badge_awards (
+    user_id VARCHAR(255) NOT NULL,
+    badge VARCHAR(255) NOT NULL,
+    award_count BIGINT NOT NULL DEFAULT 0,
+    last_awarded_at TIMESTAMP NULL,
+    deleted_at TIMESTAMP NULL,
+    PRIMARY KEY (user_id, badge)
+);
+
+CREATE INDEX IF NOT EXISTS idx_badge_awards_deleted_at ON badge_awards(deleted_at);
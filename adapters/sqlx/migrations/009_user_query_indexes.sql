@@ -0,0 +1,6 @@
+-- Indexes for Store.QueryUsers
+-- Badge holders, point ranges and levels are looked up by value rather than by user
+
+CREATE INDEX IF NOT EXISTS idx_user_badges_badge_user ON user_badges(badge, user_id);
+CREATE INDEX IF NOT EXISTS idx_user_points_metric_points ON user_points(metric, points);
+CREATE INDEX IF NOT EXISTS idx_user_levels_metric_level ON user_levels(metric, level);
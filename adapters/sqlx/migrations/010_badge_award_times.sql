@@ -0,0 +1,4 @@
+-- Index for Store.ListBadges
+-- A user's badges are listed newest award first
+
+CREATE INDEX IF NOT EXISTS idx_user_badges_user_awarded ON user_badges(user_id, awarded_at, badge);
@@ -0,0 +1,10 @@
+-- External IDs of users (Store.LinkIdentity), e.g. email:alice@example.com
+-- Each alias names one user; a user may have any number of aliases
+
+CREATE TABLE IF NOT EXISTS user_aliases (
+    alias VARCHAR(255) NOT NULL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_aliases_user_id ON user_aliases(user_id);
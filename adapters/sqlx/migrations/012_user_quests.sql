@@ -0,0 +1,15 @@
+-- Quest progress (Store.AdvanceQuest)
+-- One row per user and quest holding the progress of the latest period, e.g. 2024-W10 for weekly quests
+
+CREATE TABLE IF NOT EXISTS user_quests (
+    user_id VARCHAR(255) NOT NULL,
+    quest VARCHAR(255) NOT NULL,
+    period VARCHAR(32) NOT NULL DEFAULT '',
+    progress BIGINT NOT NULL DEFAULT 0,
+    completed_at TIMESTAMP NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    PRIMARY KEY (user_id, quest)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_quests_deleted_at ON user_quests(deleted_at);
@@ -0,0 +1,8 @@
+-- The active season of the engine's season ledgers (Store.SwitchSeason)
+-- A single row with id 1, written when the first season is stored
+
+CREATE TABLE IF NOT EXISTS active_season (
+    id INTEGER NOT NULL PRIMARY KEY,
+    season VARCHAR(64) NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
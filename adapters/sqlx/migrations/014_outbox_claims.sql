@@ -0,0 +1,4 @@
+-- Outbox claims (OutboxRelay)
+-- A relay reserves the events it is about to publish until claimed_until and commits the claim before publishing
+
+ALTER TABLE event_outbox ADD COLUMN claimed_until TIMESTAMP NULL;
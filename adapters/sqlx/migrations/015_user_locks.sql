@@ -0,0 +1,6 @@
+-- Per-user lock rows (Store.LockUser)
+-- A row is created the first time a user is locked and kept, so even users without data can be locked
+
+CREATE TABLE IF NOT EXISTS user_locks (
+    user_id VARCHAR(255) NOT NULL PRIMARY KEY
+);
@@ -0,0 +1,276 @@
+package sqlx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gamifykit/core"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// OutboxIDKey is the Metadata key under which relayed events carry their outbox row ID. Delivery is
+// at-least-once, so consumers that must not apply an event twice deduplicate on it or on the
+// event's own ID, which the outbox preserves.
+const OutboxIDKey = "outbox_id"
+
+// stage writes events to the outbox in tx, the transaction of the change they report. It does
+// nothing unless Config.Outbox is set.
+func (s *Store) stage(ctx context.Context, tx *sqlx.Tx, events ...core.Event) error {
+	if !s.outbox {
+		return nil
+	}
+	query := tx.Rebind(`INSERT INTO event_outbox (user_id, event_type, payload, created_at) VALUES (?, ?, ?, ?)`)
+	for _, ev := range events {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("failed to encode outbox event: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, query, ev.UserID, ev.Type, string(payload), ev.Time); err != nil {
+			return fmt.Errorf("failed to write outbox event: %w", err)
+		}
+	}
+	return nil
+}
+
+// DefaultOutboxMaxAttempts is how often a relay tries an event before dead-lettering it unless
+// RelayConfig.MaxAttempts says otherwise.
+const DefaultOutboxMaxAttempts = 10
+
+// OutboxDeadLetterTarget is the dead_letters target of outbox events a relay gave up on.
+const OutboxDeadLetterTarget = "outbox"
+
+// RelayConfig tunes an OutboxRelay.
+type RelayConfig struct {
+	// BatchSize is the maximum number of events claimed per round (100 when zero)
+	BatchSize int
+	// Interval is how long Run waits after a round that found nothing to send (time.Second when zero)
+	Interval time.Duration
+	// MaxAttempts is how often an event is tried before it is moved to the dead_letters table, so a
+	// poison event stops holding back the events after it (DefaultOutboxMaxAttempts when zero)
+	MaxAttempts int
+	// ClaimTimeout is how long the events of a round stay reserved for the relay that claimed them;
+	// events claimed by a relay that crashed are claimed again after it (time.Minute when zero).
+	// It should exceed the time a round takes to publish.
+	ClaimTimeout time.Duration
+	// OnError is called with publish and database errors; Run keeps going after them
+	OnError func(error)
+}
+
+// OutboxRelay publishes the events that Config.Outbox stores write to the event_outbox table. Each
+// round claims a batch of events in a short transaction that reserves them until ClaimTimeout, then
+// publishes them without holding a transaction open, marking each sent once publish succeeds.
+// Events committed before a crash are published once a relay runs again; an event may then be
+// published twice, never lost (see OutboxIDKey).
+//
+// A single relay publishes events in outbox order: a failed event is retried in the next round and
+// holds back the events after it, so per-user order is preserved. After MaxAttempts failures the
+// event is moved to the dead_letters table (target OutboxDeadLetterTarget) and the events after it
+// go on. Several relays may run against the same database to share the load, since claims never
+// overlap, but they publish their batches concurrently and so give up any ordering.
+type OutboxRelay struct {
+	store   *Store
+	publish func(context.Context, core.Event) error
+	cfg     RelayConfig
+}
+
+// NewOutboxRelay returns a relay that hands the store's outbox events to publish, e.g. a webhook
+// sender or a message broker producer.
+func NewOutboxRelay(store *Store, publish func(context.Context, core.Event) error, cfg RelayConfig) *OutboxRelay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultOutboxMaxAttempts
+	}
+	if cfg.ClaimTimeout <= 0 {
+		cfg.ClaimTimeout = time.Minute
+	}
+	return &OutboxRelay{store: store, publish: publish, cfg: cfg}
+}
+
+// Run relays events until ctx is cancelled. Full batches are followed by the next round right away.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil && r.cfg.OnError != nil {
+			r.cfg.OnError(err)
+		}
+		if err == nil && n == r.cfg.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.cfg.Interval):
+		}
+	}
+}
+
+// outboxRow is an unsent event_outbox row
+type outboxRow struct {
+	ID       int64  `db:"id"`
+	Payload  string `db:"payload"`
+	Attempts int    `db:"attempts"`
+}
+
+// RelayOnce claims up to BatchSize unsent events, publishes them in order and marks the published
+// ones sent. It stops at the first publish error, records it on that event, releases the rest of the
+// batch and returns the error along with the number of events sent. An event that reached
+// MaxAttempts is dead-lettered instead, and its error is returned once the rest of the batch is sent.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	rows, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	db := r.store.db
+	sent := 0
+	var deadErr error
+	for i, row := range rows {
+		publishErr := r.publishRow(ctx, row)
+		if publishErr == nil {
+			mark := db.Rebind(`UPDATE event_outbox SET sent_at = ?, attempts = attempts + 1, claimed_until = NULL WHERE id = ? AND sent_at IS NULL`)
+			if _, err := db.ExecContext(ctx, mark, time.Now().UTC(), row.ID); err != nil {
+				r.release(ctx, rows[i:])
+				return sent, fmt.Errorf("failed to mark outbox event sent: %w", err)
+			}
+			sent++
+			continue
+		}
+		if row.Attempts+1 >= r.cfg.MaxAttempts {
+			if err := r.deadLetter(ctx, row, publishErr); err != nil {
+				r.release(ctx, rows[i:])
+				return sent, err
+			}
+			deadErr = fmt.Errorf("%w; moved to dead letters after %d attempts", publishErr, row.Attempts+1)
+			continue
+		}
+		failed := db.Rebind(`UPDATE event_outbox SET attempts = attempts + 1, last_error = ?, claimed_until = NULL WHERE id = ?`)
+		if _, err := db.ExecContext(ctx, failed, publishErr.Error(), row.ID); err != nil {
+			r.release(ctx, rows[i:])
+			return sent, fmt.Errorf("failed to record outbox error: %w", err)
+		}
+		r.release(ctx, rows[i+1:])
+		return sent, publishErr
+	}
+	return sent, deadErr
+}
+
+// claim reserves the oldest unclaimed unsent events until ClaimTimeout and commits the claim, so no
+// transaction stays open while they are published
+func (r *OutboxRelay) claim(ctx context.Context) ([]outboxRow, error) {
+	db := r.store.db
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UTC()
+	var rows []outboxRow
+	query := tx.Rebind(`SELECT id, payload, attempts FROM event_outbox WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?) ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED`)
+	if err := tx.SelectContext(ctx, &rows, query, now, r.cfg.BatchSize); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	update, args, err := sqlx.In(`UPDATE event_outbox SET claimed_until = ? WHERE id IN (?)`, now.Add(r.cfg.ClaimTimeout), rowIDs(rows))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build outbox claim: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(update), args...); err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit outbox claim: %w", err)
+	}
+	return rows, nil
+}
+
+// release hands claimed events back for the next round. A failure is left to ClaimTimeout.
+func (r *OutboxRelay) release(ctx context.Context, rows []outboxRow) {
+	if len(rows) == 0 {
+		return
+	}
+	update, args, err := sqlx.In(`UPDATE event_outbox SET claimed_until = NULL WHERE id IN (?) AND sent_at IS NULL`, rowIDs(rows))
+	if err != nil {
+		return
+	}
+	_, _ = r.store.db.ExecContext(ctx, r.store.db.Rebind(update), args...)
+}
+
+// publishRow decodes an outbox event and publishes it with its row ID
+func (r *OutboxRelay) publishRow(ctx context.Context, row outboxRow) error {
+	var ev core.Event
+	if err := json.Unmarshal([]byte(row.Payload), &ev); err != nil {
+		return fmt.Errorf("failed to decode outbox event %d: %w", row.ID, err)
+	}
+	if ev.Metadata == nil {
+		ev.Metadata = map[string]any{}
+	}
+	ev.Metadata[OutboxIDKey] = strconv.FormatInt(row.ID, 10)
+	if err := r.publish(ctx, ev); err != nil {
+		return fmt.Errorf("failed to publish outbox event %d: %w", row.ID, err)
+	}
+	return nil
+}
+
+// deadLetter moves an event that used up its attempts from the outbox to the dead_letters table
+func (r *OutboxRelay) deadLetter(ctx context.Context, row outboxRow, cause error) error {
+	db := r.store.db
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := time.Now().UTC()
+	insert := tx.Rebind(`INSERT INTO dead_letters (id, target, payload, attempts, last_error, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if _, err := tx.ExecContext(ctx, insert, "outbox-"+strconv.FormatInt(row.ID, 10), OutboxDeadLetterTarget, row.Payload, row.Attempts+1, cause.Error(), now, now); err != nil {
+		return fmt.Errorf("failed to dead-letter outbox event %d: %w", row.ID, err)
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM event_outbox WHERE id = ?`), row.ID); err != nil {
+		return fmt.Errorf("failed to remove dead-lettered outbox event %d: %w", row.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit dead letter: %w", err)
+	}
+	return nil
+}
+
+func rowIDs(rows []outboxRow) []int64 {
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids
+}
+
+// PendingOutbox returns the number of events not yet sent, e.g. for a relay lag metric.
+func (s *Store) PendingOutbox(ctx context.Context) (int64, error) {
+	var n int64
+	if err := sqlx.GetContext(ctx, s.queryer(), &n, `SELECT COUNT(*) FROM event_outbox WHERE sent_at IS NULL`); err != nil {
+		return 0, fmt.Errorf("failed to count outbox events: %w", err)
+	}
+	return n, nil
+}
+
+// PurgeOutbox deletes events sent before the given time and returns how many were removed.
+// Unsent events are never purged.
+func (s *Store) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(`DELETE FROM event_outbox WHERE sent_at IS NOT NULL AND sent_at < ?`), before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge outbox: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged outbox events: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,165 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// openDB opens the connection pool, wrapping the driver so pooled connections are pinged before
+// reuse when config.PrePing is set
+func openDB(config Config) (*sqlx.DB, error) {
+	db, err := sqlx.Open(string(config.Driver), config.DSN.Reveal())
+	if err != nil || !config.PrePing {
+		return db, err
+	}
+
+	var connector driver.Connector
+	if dc, ok := db.Driver().(driver.DriverContext); ok {
+		connector, err = dc.OpenConnector(config.DSN.Reveal())
+	} else {
+		connector = dsnConnector{driver: db.Driver(), dsn: config.DSN.Reveal()}
+	}
+	_ = db.Close()
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(sql.OpenDB(prePingConnector{connector}), string(config.Driver)), nil
+}
+
+// warmUp opens n connections and returns them to the pool, so the first requests after startup
+// do not pay for connection establishment
+func warmUp(ctx context.Context, db *sqlx.DB, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to open connection %d of %d: %w", i+1, n, err)
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return fmt.Errorf("failed to ping connection %d of %d: %w", i+1, n, err)
+		}
+	}
+	return nil
+}
+
+// dsnConnector adapts drivers without driver.DriverContext
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// prePingConnector hands out connections that are validated before database/sql reuses them
+type prePingConnector struct {
+	driver.Connector
+}
+
+func (c prePingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &prePingConn{Conn: conn}, nil
+}
+
+func (c prePingConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// prePingConn pings in ResetSession, which database/sql calls before it hands a previously used
+// connection to the next query. A failed ping reports driver.ErrBadConn, so the pool discards the
+// connection and retries with another one instead of failing the query on a connection the
+// server has already closed. The other methods forward to the driver's connection.
+type prePingConn struct {
+	driver.Conn
+}
+
+func (c *prePingConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		if err := r.ResetSession(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.Ping(ctx); err != nil {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *prePingConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *prePingConn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *prePingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Prepare(query)
+}
+
+func (c *prePingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != 0 || opts.ReadOnly {
+		return nil, errors.New("driver does not support transaction options")
+	}
+	return c.Begin() //nolint:staticcheck // fallback for drivers without ConnBeginTx
+}
+
+func (c *prePingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *prePingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *prePingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,138 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gamifykit/secret"
+)
+
+// fakeDriverName is registered with database/sql to test the pool without a database server
+const fakeDriverName = "gamifykit-fake"
+
+var fake = &fakeDriver{}
+
+func init() {
+	sql.Register(fakeDriverName, fake)
+}
+
+// fakeDriver keeps one fakeServer per DSN so tests do not share connection counts
+type fakeDriver struct {
+	servers sync.Map
+}
+
+type fakeServer struct {
+	mu     sync.Mutex
+	opened int
+	conns  []*fakeConn
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	v, _ := d.servers.LoadOrStore(dsn, &fakeServer{})
+	srv := v.(*fakeServer)
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	conn := &fakeConn{}
+	srv.opened++
+	srv.conns = append(srv.conns, conn)
+	return conn, nil
+}
+
+func fakeServerFor(dsn string) *fakeServer {
+	v, _ := fake.servers.LoadOrStore(dsn, &fakeServer{})
+	return v.(*fakeServer)
+}
+
+// restart simulates the server closing every open connection
+func (s *fakeServer) restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.dead.Store(true)
+	}
+}
+
+func (s *fakeServer) openedConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened
+}
+
+type fakeConn struct {
+	dead atomic.Bool
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *fakeConn) Ping(context.Context) error {
+	if c.dead.Load() {
+		return errors.New("connection reset by peer")
+	}
+	return nil
+}
+
+func (c *fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if c.dead.Load() {
+		return nil, errors.New("broken pipe")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func TestPool_PrePingReplacesDeadConnections(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeServerFor(t.Name())
+	db, err := openDB(Config{Driver: fakeDriverName, DSN: secret.SecureString(t.Name()), PrePing: true})
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(3)
+
+	require.NoError(t, warmUp(ctx, db, 3))
+	assert.Equal(t, 3, srv.openedConns())
+
+	srv.restart()
+	_, err = db.ExecContext(ctx, "UPDATE user_points SET points = points + 1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, srv.openedConns())
+}
+
+func TestPool_DeadConnectionsFailWithoutPrePing(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeServerFor(t.Name())
+	db, err := openDB(Config{Driver: fakeDriverName, DSN: secret.SecureString(t.Name())})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, warmUp(ctx, db, 1))
+	srv.restart()
+	_, err = db.ExecContext(ctx, "UPDATE user_points SET points = points + 1")
+	assert.Error(t, err)
+}
+
+func TestPool_WarmUpFillsIdleConnections(t *testing.T) {
+	db, err := openDB(Config{Driver: fakeDriverName, DSN: secret.SecureString(t.Name()), PrePing: true})
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxIdleConns(4)
+
+	require.NoError(t, warmUp(context.Background(), db, 4))
+	assert.Equal(t, 4, db.Stats().Idle)
+	assert.Equal(t, 4, fakeServerFor(t.Name()).openedConns())
+}
+
+func TestNew_RejectsInvalidMinConns(t *testing.T) {
+	_, err := New(Config{Driver: fakeDriverName, MinConns: -1})
+	assert.Error(t, err)
+
+	_, err = New(Config{Driver: fakeDriverName, MaxOpenConns: 2, MinConns: 3})
+	assert.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"gamifykit/core"
+)
+
+// QueryUsers returns the users matching filter in user ID order. The most selective condition
+// drives the query (a badge, then points, then the level) through the indexes of migration 009;
+// the others are checked with EXISTS subqueries. A filter without conditions lists every user.
+func (s *Store) QueryUsers(ctx context.Context, filter core.UserFilter) (_ []core.UserID, err error) {
+	defer func() { err = classify(ctx, err) }()
+	var conds []string
+	var args []any
+	points := func(alias string) {
+		conds = append(conds, alias+".metric = ?")
+		args = append(args, filter.Metric)
+		if filter.MinPoints != nil {
+			conds = append(conds, alias+".points >= ?")
+			args = append(args, *filter.MinPoints)
+		}
+		if filter.MaxPoints != nil {
+			conds = append(conds, alias+".points <= ?")
+			args = append(args, *filter.MaxPoints)
+		}
+	}
+	level := func(alias string) {
+		conds = append(conds, alias+".metric = ?", alias+".level = ?")
+		args = append(args, filter.Metric, *filter.Level)
+	}
+	exists := func(table, alias string, where func(alias string)) {
+		before := len(conds)
+		where(alias)
+		inner := strings.Join(conds[before:], " AND ")
+		conds = append(conds[:before], "EXISTS (SELECT 1 FROM "+table+" "+alias+" WHERE "+alias+".user_id = u.user_id AND "+alias+".deleted_at IS NULL AND "+inner+")")
+	}
+	hasPoints := filter.MinPoints != nil || filter.MaxPoints != nil
+
+	var from string
+	switch {
+	case filter.Badge != "":
+		from = "user_badges u"
+		conds = append(conds, "u.badge = ?")
+		args = append(args, filter.Badge)
+		if hasPoints {
+			exists("user_points", "p", points)
+		}
+		if filter.Level != nil {
+			exists("user_levels", "l", level)
+		}
+	case hasPoints:
+		from = "user_points u"
+		points("u")
+		if filter.Level != nil {
+			exists("user_levels", "l", level)
+		}
+	case filter.Level != nil:
+		from = "user_levels u"
+		level("u")
+	default:
+		from = `(SELECT user_id FROM user_points WHERE deleted_at IS NULL
+			UNION SELECT user_id FROM user_badges WHERE deleted_at IS NULL
+			UNION SELECT user_id FROM user_levels WHERE deleted_at IS NULL) u`
+	}
+	if len(conds) > 0 {
+		// the driving table's own rows must be live as well
+		conds = append(conds, "u.deleted_at IS NULL")
+	}
+	if filter.After != "" {
+		conds = append(conds, "u.user_id > ?")
+		args = append(args, filter.After)
+	}
+	query := "SELECT u.user_id FROM " + from
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY u.user_id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	var users []core.UserID
+	if err := sqlx.SelectContext(ctx, s.queryer(), &users, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	return users, nil
+}
+
+// ListBadges returns the user's badges with their award times, newest first, through the index of
+// migration 010. Pages continue after filter.After by award time and badge, so they stay stable
+// while new badges are awarded.
+func (s *Store) ListBadges(ctx context.Context, userID core.UserID, filter core.BadgeFilter) (_ []core.BadgeAward, err error) {
+	ctx, span := s.span(ctx, "ListBadges", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	conds := []string{"user_id = ?", "deleted_at IS NULL"}
+	args := []any{userID}
+	if !filter.Since.IsZero() {
+		conds = append(conds, "awarded_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if a := filter.After; a != nil {
+		conds = append(conds, "(awarded_at < ? OR (awarded_at = ? AND badge < ?))")
+		args = append(args, a.AwardedAt.UTC(), a.AwardedAt.UTC(), a.Badge)
+	}
+	query := "SELECT badge, awarded_at FROM user_badges WHERE " + strings.Join(conds, " AND ") + " ORDER BY awarded_at DESC, badge DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	var rows []struct {
+		Badge     core.Badge `db:"badge"`
+		AwardedAt time.Time  `db:"awarded_at"`
+	}
+	if err := sqlx.SelectContext(ctx, s.queryer(), &rows, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to list badges: %w", err)
+	}
+	awards := make([]core.BadgeAward, len(rows))
+	for i, r := range rows {
+		awards[i] = core.BadgeAward{Badge: r.Badge, AwardedAt: r.AwardedAt.UTC()}
+	}
+	return awards, nil
+}
@@ -0,0 +1,95 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gamifykit/core"
+)
+
+// AdvanceQuest adds delta to the user's progress on quest in period, capped at target. The
+// user_quests row is created first and then read FOR UPDATE, so concurrent advances are serialized
+// and exactly one of them completes the quest. Progress of another period, or a soft-deleted row,
+// starts again from zero.
+func (s *Store) AdvanceQuest(ctx context.Context, userID core.UserID, quest, period string, delta, target int64) (p core.QuestProgress, before int64, err error) {
+	ctx, span := s.span(ctx, "AdvanceQuest", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return p, 0, err
+	}
+	defer s.rollback(tx)
+
+	insertQuery := `INSERT INTO user_quests (user_id, quest, period, progress) VALUES (?, ?, ?, 0) ON CONFLICT (user_id, quest) DO NOTHING`
+	if s.driver == DriverMySQL {
+		insertQuery = `INSERT INTO user_quests (user_id, quest, period, progress) VALUES (?, ?, ?, 0) ON DUPLICATE KEY UPDATE quest = quest`
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(insertQuery), userID, quest, period); err != nil {
+		return p, 0, fmt.Errorf("failed to create quest progress: %w", err)
+	}
+
+	var stored string
+	var completed sql.NullTime
+	var deleted bool
+	query := tx.Rebind(`SELECT period, progress, completed_at, deleted_at IS NOT NULL FROM user_quests WHERE user_id = ? AND quest = ? FOR UPDATE`)
+	if err := tx.QueryRowContext(ctx, query, userID, quest).Scan(&stored, &before, &completed, &deleted); err != nil {
+		return p, 0, fmt.Errorf("failed to read quest progress: %w", err)
+	}
+	if deleted || stored != period {
+		before, completed = 0, sql.NullTime{}
+	}
+
+	p = core.QuestProgress{Quest: quest, Period: period, Progress: before, Target: target}
+	if before < target {
+		p.Progress = min(before+delta, target)
+		if p.Progress == target {
+			completed = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+		}
+	}
+	if completed.Valid {
+		at := completed.Time.UTC()
+		p.CompletedAt = &at
+	}
+	p.Completed = p.Progress >= target
+	update := tx.Rebind(`UPDATE user_quests SET period = ?, progress = ?, completed_at = ?, updated_at = ?, deleted_at = NULL WHERE user_id = ? AND quest = ?`)
+	if _, err := tx.ExecContext(ctx, update, period, p.Progress, completed, time.Now().UTC(), userID, quest); err != nil {
+		return p, 0, fmt.Errorf("failed to update quest progress: %w", err)
+	}
+	if err := s.commit(tx); err != nil {
+		return p, 0, err
+	}
+	return p, before, nil
+}
+
+// QuestProgress returns the user's quest progress records, sorted by quest.
+func (s *Store) QuestProgress(ctx context.Context, userID core.UserID) (_ []core.QuestProgress, err error) {
+	ctx, span := s.span(ctx, "QuestProgress", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	query := s.db.Rebind(`SELECT quest, period, progress, completed_at FROM user_quests WHERE user_id = ? AND deleted_at IS NULL ORDER BY quest`)
+	rows, err := s.queryer().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quest progress: %w", err)
+	}
+	defer rows.Close()
+	progress := []core.QuestProgress{}
+	for rows.Next() {
+		var p core.QuestProgress
+		var completed sql.NullTime
+		if err := rows.Scan(&p.Quest, &p.Period, &p.Progress, &completed); err != nil {
+			return nil, fmt.Errorf("failed to scan quest progress: %w", err)
+		}
+		if completed.Valid {
+			at := completed.Time.UTC()
+			p.CompletedAt = &at
+		}
+		progress = append(progress, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list quest progress: %w", err)
+	}
+	return progress, nil
+}
@@ -0,0 +1,55 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultRetentionInterval is how often RunPointsRetention prunes when no interval is given.
+const DefaultRetentionInterval = 10 * time.Minute
+
+// PrunePointEvents deletes the point increments (point_events) older than the points retention
+// and returns how many it deleted. Reads never prune, so without it the increments of every user
+// are kept forever. Tombstoned increments are left for PurgeDeleted.
+func (s *Store) PrunePointEvents(ctx context.Context) (_ int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	query := s.db.Rebind(`DELETE FROM point_events WHERE created_at < ? AND deleted_at IS NULL`)
+	var exec sqlx.ExecerContext = s.db
+	if s.tx != nil {
+		exec = s.tx
+	}
+	res, err := exec.ExecContext(ctx, query, time.Now().UTC().Add(-s.retention))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune point events: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pruned point events: %w", err)
+	}
+	return n, nil
+}
+
+// RunPointsRetention calls PrunePointEvents every interval (DefaultRetentionInterval when zero)
+// until ctx is done, reporting each pass to onPruned (if not nil), e.g. to update a counter or
+// log a failure.
+func (s *Store) RunPointsRetention(ctx context.Context, interval time.Duration, onPruned func(n int64, err error)) {
+	if interval <= 0 {
+		interval = DefaultRetentionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.PrunePointEvents(ctx)
+			if onPruned != nil {
+				onPruned(n, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package sqlx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrSchemaOutOfDate is returned by VerifySchema, and New, when the database lacks tables or
+// columns the store needs or has them with incompatible types.
+var ErrSchemaOutOfDate = errors.New("schema out of date, run migrations")
+
+// columnKind is the family of SQL types a column must have
+type columnKind int
+
+const (
+	kindText columnKind = iota
+	kindInt
+	kindBigInt
+	kindTime
+)
+
+func (k columnKind) String() string {
+	return [...]string{"text", "integer", "bigint", "timestamp"}[k]
+}
+
+// compatibleTypes lists the information_schema data types accepted for each kind, as PostgreSQL
+// ("character varying", "timestamp with time zone") and MySQL ("varchar", "datetime") name them
+var compatibleTypes = map[columnKind][]string{
+	kindText:   {"character varying", "varchar", "text", "character", "char", "tinytext", "mediumtext", "longtext"},
+	kindInt:    {"integer", "int", "bigint"},
+	kindBigInt: {"bigint"},
+	kindTime:   {"timestamp with time zone", "timestamp without time zone", "timestamp", "datetime"},
+}
+
+// expectedSchema is the schema the migrations build: the columns the store reads and writes, by table
+var expectedSchema = map[string]map[string]columnKind{
+	"user_points": {"user_id": kindText, "metric": kindText, "points": kindBigInt, "updated_at": kindTime, "deleted_at": kindTime},
+	"user_badges": {"user_id": kindText, "badge": kindText, "awarded_at": kindTime, "deleted_at": kindTime},
+	"user_levels": {"user_id": kindText, "metric": kindText, "level": kindBigInt, "updated_at": kindTime, "deleted_at": kindTime},
+	"point_events": {"id": kindText, "user_id": kindText, "metric": kindText, "delta": kindBigInt, "created_at": kindTime,
+		"deleted_at": kindTime},
+	"dead_letters": {"id": kindText, "target": kindText, "payload": kindText, "attempts": kindInt, "last_error": kindText,
+		"created_at": kindTime, "updated_at": kindTime},
+	"leaderboard_archive": {"board": kindText, "period": kindText, "position": kindInt, "user_id": kindText, "score": kindBigInt,
+		"archived_at": kindTime},
+	"event_outbox": {"id": kindInt, "user_id": kindText, "event_type": kindText, "payload": kindText, "created_at": kindTime,
+		"sent_at": kindTime, "attempts": kindInt, "last_error": kindText, "claimed_until": kindTime},
+	"badge_awards": {"user_id": kindText, "badge": kindText, "award_count": kindBigInt, "last_awarded_at": kindTime,
+		"deleted_at": kindTime},
+	"user_aliases": {"alias": kindText, "user_id": kindText, "created_at": kindTime},
+	"user_quests": {"user_id": kindText, "quest": kindText, "period": kindText, "progress": kindBigInt, "completed_at": kindTime,
+		"updated_at": kindTime, "deleted_at": kindTime},
+	"active_season": {"id": kindInt, "season": kindText, "updated_at": kindTime},
+	"user_locks":    {"user_id": kindText},
+}
+
+// VerifySchema introspects information_schema to check that every table and column the store uses
+// exists with a compatible type, so a database that missed migrations fails at startup with
+// ErrSchemaOutOfDate and a list of what is wrong rather than with a column error on some later
+// request. PostgreSQL is checked in the connection's current schema, MySQL in its current
+// database. New calls it after migrating unless Config.SkipSchemaCheck is set.
+func (s *Store) VerifySchema(ctx context.Context) error {
+	schema := "current_schema()"
+	if s.driver == DriverMySQL {
+		schema = "DATABASE()"
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT table_name, column_name, data_type FROM information_schema.columns WHERE table_schema = `+schema)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+	defer rows.Close()
+	actual := map[string]map[string]string{}
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+		// MySQL may report names in upper case and types with their length, e.g. "varchar(255)"
+		table, column = strings.ToLower(table), strings.ToLower(column)
+		dataType, _, _ = strings.Cut(strings.ToLower(dataType), "(")
+		if actual[table] == nil {
+			actual[table] = map[string]string{}
+		}
+		actual[table][column] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	if problems := schemaProblems(actual); len(problems) > 0 {
+		msg := strings.Join(problems, "; ")
+		if pending, err := s.Pending(ctx); err == nil && len(pending) > 0 {
+			msg += "; pending migrations: " + strings.Join(pending, ", ")
+		}
+		return fmt.Errorf("%w: %s", ErrSchemaOutOfDate, msg)
+	}
+	return nil
+}
+
+// schemaProblems compares the introspected column types, by table and column, with expectedSchema
+// and describes each difference, sorted
+func schemaProblems(actual map[string]map[string]string) []string {
+	var problems []string
+	for table, columns := range expectedSchema {
+		have, ok := actual[table]
+		if !ok {
+			problems = append(problems, "missing table "+table)
+			continue
+		}
+		for column, kind := range columns {
+			dataType, ok := have[column]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("missing column %s.%s", table, column))
+			case !kindAccepts(kind, dataType):
+				problems = append(problems, fmt.Sprintf("column %s.%s is %s, want %s", table, column, dataType, kind))
+			}
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func kindAccepts(kind columnKind, dataType string) bool {
+	for _, t := range compatibleTypes[kind] {
+		if dataType == t {
+			return true
+		}
+	}
+	return false
+}
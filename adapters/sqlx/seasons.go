@@ -0,0 +1,55 @@
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ActiveSeason returns the season stored by SwitchSeason, "" if none was.
+func (s *Store) ActiveSeason(ctx context.Context) (_ string, err error) {
+	ctx, span := s.span(ctx, "ActiveSeason", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	var season string
+	err = sqlx.GetContext(ctx, s.queryer(), &season, `SELECT season FROM active_season WHERE id = 1`)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed to read active season: %w", err)
+	}
+	return season, nil
+}
+
+// SwitchSeason stores next as the active season if previous still is. The first season is
+// inserted as the only active_season row and later ones replace it with a conditional UPDATE, so of
+// two instances switching at once only one succeeds.
+func (s *Store) SwitchSeason(ctx context.Context, previous, next string) (_ bool, err error) {
+	ctx, span := s.span(ctx, "SwitchSeason", "")
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	var res sql.Result
+	if previous == "" {
+		insert := `INSERT INTO active_season (id, season, updated_at) VALUES (1, ?, ?) ON CONFLICT (id) DO NOTHING`
+		if s.driver == DriverMySQL {
+			insert = `INSERT IGNORE INTO active_season (id, season, updated_at) VALUES (1, ?, ?)`
+		}
+		res, err = s.db.ExecContext(ctx, s.db.Rebind(insert), next, time.Now().UTC())
+	} else {
+		update := `UPDATE active_season SET season = ?, updated_at = ? WHERE id = 1 AND season = ?`
+		res, err = s.db.ExecContext(ctx, s.db.Rebind(update), next, time.Now().UTC(), previous)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to switch season: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count switched seasons: %w", err)
+	}
+	return n == 1, nil
+}
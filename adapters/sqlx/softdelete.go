@@ -0,0 +1,114 @@
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gamifykit/core"
+)
+
+// userTables are the tables holding a user's data, in the order they are deleted and purged
+var userTables = []string{"user_points", "user_badges", "user_levels", "point_events", "badge_awards", "user_quests"}
+
+// RemoveBadge takes a badge away from the user. With Config.SoftDelete the row is tombstoned
+// (deleted_at is set) and kept until PurgeDeleted; otherwise it is deleted. removed reports
+// whether the user held the badge; removing a badge the user does not have is not an error.
+func (s *Store) RemoveBadge(ctx context.Context, userID core.UserID, badge core.Badge) (removed bool, err error) {
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer s.rollback(tx)
+
+	query := `DELETE FROM user_badges WHERE user_id = ? AND badge = ?`
+	args := []any{userID, badge}
+	if s.softDelete {
+		query = `UPDATE user_badges SET deleted_at = ? WHERE user_id = ? AND badge = ? AND deleted_at IS NULL`
+		args = []any{time.Now().UTC(), userID, badge}
+	}
+	res, err := tx.ExecContext(ctx, tx.Rebind(query), args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove badge: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to count removed badges: %w", err)
+	}
+	if n > 0 {
+		if err := s.stage(ctx, tx, core.NewBadgeRevoked(userID, badge)); err != nil {
+			return false, err
+		}
+	}
+	if err := s.commit(tx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteUser removes all of the user's points, badges, levels, point increments and badge award
+// records in one transaction. With Config.SoftDelete the rows are tombstoned and kept until PurgeDeleted;
+// otherwise they are deleted. The user's identity links are always deleted.
+//
+// Writing to a soft-deleted user starts them afresh: a points, badge or level row that is
+// written again replaces its tombstone.
+func (s *Store) DeleteUser(ctx context.Context, userID core.UserID) (err error) {
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollback(tx)
+
+	now := time.Now().UTC()
+	for _, table := range userTables {
+		query := `DELETE FROM ` + table + ` WHERE user_id = ?`
+		args := []any{userID}
+		if s.softDelete {
+			query = `UPDATE ` + table + ` SET deleted_at = ? WHERE user_id = ? AND deleted_at IS NULL`
+			args = []any{now, userID}
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return fmt.Errorf("failed to delete from %s: %w", table, err)
+		}
+	}
+	// aliases are personal data and free for other users once their user is gone, so they are
+	// always deleted
+	if _, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM user_aliases WHERE user_id = ?`), userID); err != nil {
+		return fmt.Errorf("failed to delete from user_aliases: %w", err)
+	}
+	if err := s.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeleted hard-deletes rows tombstoned before the given time, e.g. once a retention period
+// has passed, and returns how many rows were removed.
+func (s *Store) PurgeDeleted(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer s.rollback(tx)
+
+	var purged int64
+	for _, table := range userTables {
+		query := tx.Rebind(`DELETE FROM ` + table + ` WHERE deleted_at IS NOT NULL AND deleted_at < ?`)
+		res, err := tx.ExecContext(ctx, query, before.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("failed to purge %s: %w", table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to count purged rows of %s: %w", table, err)
+		}
+		purged += n
+	}
+	if err := s.commit(tx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return purged, nil
+}
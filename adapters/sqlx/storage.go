@@ -2,18 +2,22 @@ package sqlx
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"gamifykit/core"
+	"gamifykit/engine"
+	"gamifykit/secret"
+	"gamifykit/tracing"
 
-	_ "github.com/go-sql-driver/mysql" // MySQL driver
+	"github.com/go-sql-driver/mysql" // MySQL driver
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
 )
 
 // Driver represents the database driver type
@@ -27,11 +31,33 @@ const (
 // Config holds SQL database configuration
 type Config struct {
 	Driver          Driver
-	DSN             string
+	DSN             secret.SecureString
 	MaxOpenConns    int
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
+	// PointsRetention is how long point increments are kept for PointsInWindow
+	// (core.DefaultPointsRetention when zero)
+	PointsRetention time.Duration
+	// SoftDelete makes RemoveBadge and DeleteUser tombstone rows (deleted_at) instead of deleting
+	// them, keeping an audit trail until PurgeDeleted removes them. Reads never return tombstones.
+	SoftDelete bool
+	// PrePing pings a pooled connection before reusing it and replaces it if the ping fails, so
+	// connections the server closed while they sat idle do not fail the next query.
+	PrePing bool
+	// MinConns connections are opened at startup so the pool is not cold. MaxIdleConns is raised
+	// to MinConns if lower; ConnMaxIdleTime still closes them after a long idle period.
+	MinConns int
+	// Outbox writes an event for every points change, new or removed badge, level increase and
+	// state replacement to the event_outbox table, in the same transaction as the change, for an
+	// OutboxRelay to publish. Events survive a crash right after the commit.
+	Outbox bool
+	// SkipMigrations makes New leave the schema alone, for deployments that migrate as a separate
+	// step (see Store.Migrate and `gamifykit-server migrate`).
+	SkipMigrations bool
+	// SkipSchemaCheck makes New skip Store.VerifySchema, for schemas managed outside the
+	// migrations that differ from them on purpose.
+	SkipSchemaCheck bool
 }
 
 // DefaultConfig returns sensible defaults for SQL configuration
@@ -56,9 +82,15 @@ func DefaultConfig(driver Driver) Config {
 
 // Store implements the engine.Storage interface using SQL database as the backend.
 // Uses optimistic locking and transactions for data consistency.
+// A Store bound to a transaction (see WithTx and BindTx) runs every operation inside
+// that transaction and leaves committing to its owner.
 type Store struct {
-	db     *sqlx.DB
-	driver Driver
+	db         *sqlx.DB
+	driver     Driver
+	tx         *sqlx.Tx
+	retention  time.Duration
+	softDelete bool
+	outbox     bool
 }
 
 //go:embed migrations/*.sql
@@ -66,14 +98,21 @@ var migrationsFS embed.FS
 
 // New creates a new SQL-backed storage with the provided configuration
 func New(config Config) (*Store, error) {
-	db, err := sqlx.Open(string(config.Driver), config.DSN)
+	if config.MinConns < 0 {
+		return nil, errors.New("min conns cannot be negative")
+	}
+	if config.MaxOpenConns > 0 && config.MinConns > config.MaxOpenConns {
+		return nil, fmt.Errorf("min conns (%d) cannot exceed max open conns (%d)", config.MinConns, config.MaxOpenConns)
+	}
+
+	db, err := openDB(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool
 	db.SetMaxOpenConns(config.MaxOpenConns)
-	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetMaxIdleConns(max(config.MaxIdleConns, config.MinConns))
 	db.SetConnMaxLifetime(config.ConnMaxLifetime)
 	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
 
@@ -89,15 +128,33 @@ func New(config Config) (*Store, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	store := &Store{db: db, driver: config.Driver}
+	if err := warmUp(ctx, db, config.MinConns); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to warm up connection pool: %w", err)
+	}
+
+	store := &Store{db: db, driver: config.Driver, retention: config.PointsRetention, softDelete: config.SoftDelete, outbox: config.Outbox}
+	if store.retention <= 0 {
+		store.retention = core.DefaultPointsRetention
+	}
 
 	// Run migrations
-	if err := store.runMigrations(ctx); err != nil {
-		if closeErr := db.Close(); closeErr != nil {
-			// Log close error but prioritize the migration error
-			// In error cleanup, we don't fail the operation for close errors
+	if !config.SkipMigrations {
+		if _, err := store.Migrate(ctx); err != nil {
+			if closeErr := db.Close(); closeErr != nil {
+				// Log close error but prioritize the migration error
+				// In error cleanup, we don't fail the operation for close errors
+			}
+			return nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+	}
+
+	// Fail now rather than on the first request if the schema is behind this binary
+	if !config.SkipSchemaCheck {
+		if err := store.VerifySchema(ctx); err != nil {
+			_ = db.Close()
+			return nil, err
 		}
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
 	return store, nil
@@ -105,7 +162,7 @@ func New(config Config) (*Store, error) {
 
 // NewWithDB creates a Store using an existing sqlx.DB (useful for testing)
 func NewWithDB(db *sqlx.DB, driver Driver) *Store {
-	return &Store{db: db, driver: driver}
+	return &Store{db: db, driver: driver, retention: core.DefaultPointsRetention}
 }
 
 // Close closes the database connection
@@ -113,190 +170,395 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
-// runMigrations executes database migrations
-func (s *Store) runMigrations(ctx context.Context) error {
-	// Read migration files
-	entries, err := migrationsFS.ReadDir("migrations")
+// WithTx runs fn against a store bound to a single transaction, committing when fn
+// returns nil and rolling back otherwise. If s is already bound to a transaction, fn
+// joins it and the outer owner decides the outcome.
+func (s *Store) WithTx(ctx context.Context, fn func(tx engine.Storage) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".sql") {
-			continue
-		}
+	if err := fn(s.BindTx(tx)); err != nil {
+		return err
+	}
 
-		content, err := migrationsFS.ReadFile("migrations/" + entry.Name())
-		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
-		}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
 
-		// Execute migration
-		if _, err := s.db.ExecContext(ctx, string(content)); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", entry.Name(), err)
-		}
+// BindTx returns a Store that runs all operations inside an existing transaction,
+// allowing gamification writes to share a transaction with application writes.
+// The caller remains responsible for committing or rolling back tx.
+func (s *Store) BindTx(tx *sqlx.Tx) *Store {
+	return &Store{db: s.db, driver: s.driver, tx: tx, retention: s.retention, softDelete: s.softDelete, outbox: s.outbox}
+}
+
+// Tx returns the transaction the store is bound to, or nil if it is not bound.
+func (s *Store) Tx() *sqlx.Tx {
+	return s.tx
+}
+
+// begin returns the bound transaction or starts a new one owned by the caller
+func (s *Store) begin(ctx context.Context) (*sqlx.Tx, error) {
+	if s.tx != nil {
+		return s.tx, nil
 	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return tx, nil
+}
 
-	return nil
+// rollback aborts tx unless it belongs to an outer WithTx/BindTx scope
+func (s *Store) rollback(tx *sqlx.Tx) {
+	if tx != s.tx {
+		_ = tx.Rollback()
+	}
 }
 
-// AddPoints atomically adds points to a user's metric with transaction safety
-func (s *Store) AddPoints(ctx context.Context, userID core.UserID, metric core.Metric, delta int64) (int64, error) {
+// commit commits tx unless it belongs to an outer WithTx/BindTx scope
+func (s *Store) commit(tx *sqlx.Tx) error {
+	if tx == s.tx {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// queryer returns the bound transaction or the pool for read-only queries
+// span starts a tracing span for the storage operation op on userID
+func (s *Store) span(ctx context.Context, op string, userID core.UserID) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "sqlx."+op)
+	span.SetAttr("db.system", string(s.driver))
+	span.SetAttr("db.operation", op)
+	span.SetUser(userID)
+	return ctx, span
+}
+
+func (s *Store) queryer() sqlx.QueryerContext {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// maxWriteAttempts bounds how often AddPoints retries after losing a race with a concurrent writer
+const maxWriteAttempts = 5
+
+// AddPoints atomically adds points to a user's metric with transaction safety. Concurrent first
+// writes for the same user and metric converge: the writer whose INSERT hits the unique key retries
+// as an UPDATE of the row the other one created.
+func (s *Store) AddPoints(ctx context.Context, userID core.UserID, metric core.Metric, delta int64) (_ int64, err error) {
+	ctx, span := s.span(ctx, "AddPoints", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
 	if delta == 0 {
 		return 0, errors.New("delta cannot be zero")
 	}
+	for attempt := 1; ; attempt++ {
+		total, err := s.addPoints(ctx, userID, metric, delta)
+		// a deadlock aborts the whole transaction, so only retry transactions we own
+		if err == nil || s.tx != nil || !isDeadlock(err) || attempt == maxWriteAttempts {
+			return total, err
+		}
+	}
+}
 
-	tx, err := s.db.BeginTxx(ctx, nil)
+func (s *Store) addPoints(ctx context.Context, userID core.UserID, metric core.Metric, delta int64) (int64, error) {
+	tx, err := s.begin(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, err
 	}
-	defer tx.Rollback()
+	defer s.rollback(tx)
 
-	// Get current points (or 0 if not exists)
-	var currentPoints sql.NullInt64
-	query := `
-		SELECT points FROM user_points
-		WHERE user_id = $1 AND metric = $2
+	var newPoints int64
+	for attempt := 1; ; attempt++ {
+		// Rows are read FOR UPDATE so concurrent increments cannot overwrite each other. MySQL
+		// takes gap locks when the row is missing, which deadlocks concurrent first inserts, so
+		// there the row is only locked once it is known to exist.
+		lock := s.driver != DriverMySQL || attempt > 1
+		currentPoints, deleted, err := s.readPoints(ctx, tx, userID, metric, lock)
+		if err != nil {
+			return 0, err
+		}
+		if currentPoints.Valid && !lock {
+			continue
+		}
+
+		// a tombstoned row is reused and starts again from zero
+		current := currentPoints.Int64
+		if deleted {
+			current = 0
+		}
+		newPoints, err = core.AddSafe(current, delta)
+		if err != nil {
+			return 0, err
+		}
+
+		if currentPoints.Valid {
+			updateQuery := `
+				UPDATE user_points
+				SET points = $1, updated_at = $2, deleted_at = NULL
+				WHERE user_id = $3 AND metric = $4
+			`
+			if s.driver == DriverMySQL {
+				updateQuery = `
+					UPDATE user_points
+					SET points = ?, updated_at = ?, deleted_at = NULL
+					WHERE user_id = ? AND metric = ?
+				`
+			}
+			if _, err := tx.ExecContext(ctx, updateQuery, newPoints, time.Now().UTC(), userID, metric); err != nil {
+				return 0, fmt.Errorf("failed to update points: %w", err)
+			}
+			break
+		}
+
+		err = s.insertPoints(ctx, tx, userID, metric, newPoints)
+		if err == nil {
+			break
+		}
+		if !isUniqueViolation(err) || attempt == maxWriteAttempts {
+			return 0, fmt.Errorf("failed to update points: %w", err)
+		}
+		// a concurrent first write created the row; read it again and update it instead
+	}
+
+	// Record the increment for rolling-window queries in the same transaction
+	eventQuery := `
+		INSERT INTO point_events (id, user_id, metric, delta, created_at)
+		VALUES ($1, $2, $3, $4, $5)
 	`
 	if s.driver == DriverMySQL {
-		query = `
-			SELECT points FROM user_points
-			WHERE user_id = ? AND metric = ?
+		eventQuery = `
+			INSERT INTO point_events (id, user_id, metric, delta, created_at)
+			VALUES (?, ?, ?, ?, ?)
 		`
 	}
+	if _, err := tx.ExecContext(ctx, eventQuery, newEventID(), userID, metric, delta, time.Now().UTC()); err != nil {
+		return 0, fmt.Errorf("failed to record point event: %w", err)
+	}
+	if err := s.stage(ctx, tx, core.NewPointsAdded(userID, metric, delta, newPoints)); err != nil {
+		return 0, err
+	}
 
-	err = tx.QueryRowContext(ctx, query, userID, metric).Scan(&currentPoints)
-	if err != nil && err != sql.ErrNoRows {
-		return 0, fmt.Errorf("failed to get current points: %w", err)
+	if err := s.commit(tx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	newPoints := currentPoints.Int64 + delta
+	return newPoints, nil
+}
 
-	// Check for overflow (basic check)
-	if (delta > 0 && newPoints < currentPoints.Int64) || (delta < 0 && newPoints > currentPoints.Int64) {
-		return 0, errors.New("integer overflow in AddPoints")
+// readPoints returns the user's points of metric (NULL if there is no row) and whether the row is
+// soft-deleted. With lock the row is read FOR UPDATE, which also sees rows committed after the
+// transaction started.
+func (s *Store) readPoints(ctx context.Context, tx *sqlx.Tx, userID core.UserID, metric core.Metric, lock bool) (sql.NullInt64, bool, error) {
+	query := `SELECT points, deleted_at IS NOT NULL FROM user_points WHERE user_id = $1 AND metric = $2`
+	if s.driver == DriverMySQL {
+		query = `SELECT points, deleted_at IS NOT NULL FROM user_points WHERE user_id = ? AND metric = ?`
+	}
+	if lock {
+		query += ` FOR UPDATE`
+	}
+	var points sql.NullInt64
+	var deleted bool
+	err := tx.QueryRowContext(ctx, query, userID, metric).Scan(&points, &deleted)
+	if err != nil && err != sql.ErrNoRows {
+		return points, false, fmt.Errorf("failed to get current points: %w", err)
 	}
+	return points, deleted, nil
+}
 
-	// Insert or update points
-	if currentPoints.Valid {
-		// Update existing
-		updateQuery := `
-			UPDATE user_points
-			SET points = $1, updated_at = $2
-			WHERE user_id = $3 AND metric = $4
-		`
-		if s.driver == DriverMySQL {
-			updateQuery = `
-				UPDATE user_points
-				SET points = ?, updated_at = ?
-				WHERE user_id = ? AND metric = ?
-			`
-		}
-		_, err = tx.ExecContext(ctx, updateQuery, newPoints, time.Now().UTC(), userID, metric)
-	} else {
-		// Insert new
-		insertQuery := `
+// insertPoints creates the user's points row. On Postgres a failed statement aborts the whole
+// transaction, so the insert runs under a savepoint that is rolled back if it fails.
+func (s *Store) insertPoints(ctx context.Context, tx *sqlx.Tx, userID core.UserID, metric core.Metric, points int64) error {
+	query := `
+		INSERT INTO user_points (user_id, metric, points, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if s.driver == DriverMySQL {
+		query = `
 			INSERT INTO user_points (user_id, metric, points, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5)
+			VALUES (?, ?, ?, ?, ?)
 		`
-		if s.driver == DriverMySQL {
-			insertQuery = `
-				INSERT INTO user_points (user_id, metric, points, created_at, updated_at)
-				VALUES (?, ?, ?, ?, ?)
-			`
+	}
+	savepoint := s.driver == DriverPostgres
+	if savepoint {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT insert_points`); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
 		}
-		_, err = tx.ExecContext(ctx, insertQuery, userID, metric, newPoints, time.Now().UTC(), time.Now().UTC())
 	}
-
+	now := time.Now().UTC()
+	_, err := tx.ExecContext(ctx, query, userID, metric, points, now, now)
+	if !savepoint {
+		return err
+	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to update points: %w", err)
+		if _, rerr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT insert_points`); rerr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rerr)
+		}
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT insert_points`); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
 	}
+	return nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+// isUniqueViolation reports whether err is a duplicate-key error (Postgres 23505, MySQL 1062)
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
 	}
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1062
+}
 
-	return newPoints, nil
+// isDeadlock reports whether err is a deadlock that aborted the transaction (Postgres 40P01, MySQL 1213)
+func isDeadlock(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40P01"
+	}
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == 1213
+}
+
+// isSerializationFailure reports whether err aborted a serializable transaction (Postgres 40001)
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// classify wraps errors that rolled the write back with core.ErrConflict, so the service may
+// retry them (see core.IsTransient), and errors after ctx ended with its error (see
+// core.ContextError), as drivers report cancelled statements in their own terms
+func classify(ctx context.Context, err error) error {
+	err = core.ContextError(ctx, err)
+	if err != nil && !errors.Is(err, core.ErrConflict) && (isDeadlock(err) || isSerializationFailure(err)) {
+		return fmt.Errorf("%w: %w", core.ErrConflict, err)
+	}
+	return err
 }
 
 // AwardBadge adds a badge to the user's badge collection
 func (s *Store) AwardBadge(ctx context.Context, userID core.UserID, badge core.Badge) error {
-	tx, err := s.db.BeginTxx(ctx, nil)
+	_, err := s.TryAwardBadge(ctx, userID, badge)
+	return err
+}
+
+// TryAwardBadge adds a badge to the user's badge collection and reports whether the user did not
+// hold it yet. The insert skips existing rows instead of failing on the unique key, so of several
+// concurrent awards exactly one reports true.
+func (s *Store) TryAwardBadge(ctx context.Context, userID core.UserID, badge core.Badge) (awarded bool, err error) {
+	ctx, span := s.span(ctx, "TryAwardBadge", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return false, err
 	}
-	defer tx.Rollback()
+	defer s.rollback(tx)
 
-	// Check if badge already exists
-	var exists bool
-	checkQuery := `
-		SELECT EXISTS(
-			SELECT 1 FROM user_badges
-			WHERE user_id = $1 AND badge = $2
-		)
-	`
-	if s.driver == DriverMySQL {
-		checkQuery = `
-			SELECT EXISTS(
-				SELECT 1 FROM user_badges
-				WHERE user_id = ? AND badge = ?
-			)
-		`
+	awarded, err = s.awardBadge(ctx, tx, userID, badge, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	if awarded {
+		if err := s.stage(ctx, tx, core.NewBadgeAwarded(userID, badge)); err != nil {
+			return false, err
+		}
 	}
 
-	err = tx.QueryRowContext(ctx, checkQuery, userID, badge).Scan(&exists)
-	if err != nil {
-		return fmt.Errorf("failed to check badge existence: %w", err)
+	if err := s.commit(tx); err != nil {
+		return false, err
 	}
+	return awarded, nil
+}
 
-	if exists {
-		// Badge already awarded, commit and return
-		return tx.Commit()
+// awardBadge adds badge to the user's collection within tx and reports whether the user did not
+// hold it yet
+func (s *Store) awardBadge(ctx context.Context, tx *sqlx.Tx, userID core.UserID, badge core.Badge, now time.Time) (awarded bool, err error) {
+	// Award a removed badge again by replacing its tombstone
+	reviveQuery := tx.Rebind(`UPDATE user_badges SET deleted_at = NULL, awarded_at = ? WHERE user_id = ? AND badge = ? AND deleted_at IS NOT NULL`)
+	res, err := tx.ExecContext(ctx, reviveQuery, now, userID, badge)
+	if err != nil {
+		return false, fmt.Errorf("failed to award badge: %w", err)
+	}
+	revived, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to award badge: %w", err)
 	}
 
-	// Insert new badge
-	insertQuery := `
-		INSERT INTO user_badges (user_id, badge, awarded_at)
-		VALUES ($1, $2, $3)
-	`
-	if s.driver == DriverMySQL {
-		insertQuery = `
+	awarded = revived > 0
+	if !awarded {
+		// Insert new badge; a row that already exists is left alone and affects no rows
+		insertQuery := `
 			INSERT INTO user_badges (user_id, badge, awarded_at)
-			VALUES (?, ?, ?)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, badge) DO NOTHING
 		`
-	}
+		if s.driver == DriverMySQL {
+			insertQuery = `
+				INSERT INTO user_badges (user_id, badge, awarded_at)
+				VALUES (?, ?, ?)
+				ON DUPLICATE KEY UPDATE badge = badge
+			`
+		}
 
-	_, err = tx.ExecContext(ctx, insertQuery, userID, badge, time.Now().UTC())
-	if err != nil {
-		return fmt.Errorf("failed to award badge: %w", err)
+		res, err := tx.ExecContext(ctx, insertQuery, userID, badge, now)
+		if err != nil {
+			return false, fmt.Errorf("failed to award badge: %w", err)
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return false, fmt.Errorf("failed to award badge: %w", err)
+		}
+		awarded = inserted > 0
 	}
-
-	return tx.Commit()
+	return awarded, nil
 }
 
-// GetState retrieves the complete user state from the database
-func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserState, error) {
+// GetState retrieves the complete user state from the database. Updated is the latest write time
+// of the user's points, badge and level rows, zero for a user without any.
+func (s *Store) GetState(ctx context.Context, userID core.UserID) (_ core.UserState, err error) {
+	ctx, span := s.span(ctx, "GetState", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
 	state := core.UserState{
-		UserID:  userID,
-		Points:  make(map[core.Metric]int64),
-		Badges:  make(map[core.Badge]struct{}),
-		Levels:  make(map[core.Metric]int64),
-		Updated: time.Now().UTC(),
+		UserID: userID,
+		Points: make(map[core.Metric]int64),
+		Badges: make(map[core.Badge]struct{}),
+		Levels: make(map[core.Metric]int64),
+	}
+	touch := func(at sql.NullTime) {
+		if at.Valid && at.Time.After(state.Updated) {
+			state.Updated = core.Timestamp(at.Time)
+		}
 	}
 
 	// Get points
 	pointsQuery := `
-		SELECT metric, points FROM user_points
-		WHERE user_id = $1
+		SELECT metric, points, updated_at FROM user_points
+		WHERE user_id = $1 AND deleted_at IS NULL
 	`
 	if s.driver == DriverMySQL {
 		pointsQuery = `
-			SELECT metric, points FROM user_points
-			WHERE user_id = ?
+			SELECT metric, points, updated_at FROM user_points
+			WHERE user_id = ? AND deleted_at IS NULL
 		`
 	}
 
-	pointsRows, err := s.db.QueryContext(ctx, pointsQuery, userID)
+	pointsRows, err := s.queryer().QueryContext(ctx, pointsQuery, userID)
 	if err != nil {
 		return core.UserState{}, fmt.Errorf("failed to get points: %w", err)
 	}
@@ -305,25 +567,27 @@ func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserStat
 	for pointsRows.Next() {
 		var metric core.Metric
 		var points int64
-		if err := pointsRows.Scan(&metric, &points); err != nil {
+		var at sql.NullTime
+		if err := pointsRows.Scan(&metric, &points, &at); err != nil {
 			return core.UserState{}, fmt.Errorf("failed to scan points: %w", err)
 		}
 		state.Points[metric] = points
+		touch(at)
 	}
 
 	// Get badges
 	badgesQuery := `
-		SELECT badge FROM user_badges
-		WHERE user_id = $1
+		SELECT badge, awarded_at FROM user_badges
+		WHERE user_id = $1 AND deleted_at IS NULL
 	`
 	if s.driver == DriverMySQL {
 		badgesQuery = `
-			SELECT badge FROM user_badges
-			WHERE user_id = ?
+			SELECT badge, awarded_at FROM user_badges
+			WHERE user_id = ? AND deleted_at IS NULL
 		`
 	}
 
-	badgesRows, err := s.db.QueryContext(ctx, badgesQuery, userID)
+	badgesRows, err := s.queryer().QueryContext(ctx, badgesQuery, userID)
 	if err != nil {
 		return core.UserState{}, fmt.Errorf("failed to get badges: %w", err)
 	}
@@ -331,25 +595,27 @@ func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserStat
 
 	for badgesRows.Next() {
 		var badge core.Badge
-		if err := badgesRows.Scan(&badge); err != nil {
+		var at sql.NullTime
+		if err := badgesRows.Scan(&badge, &at); err != nil {
 			return core.UserState{}, fmt.Errorf("failed to scan badge: %w", err)
 		}
 		state.Badges[badge] = struct{}{}
+		touch(at)
 	}
 
 	// Get levels
 	levelsQuery := `
-		SELECT metric, level FROM user_levels
-		WHERE user_id = $1
+		SELECT metric, level, updated_at FROM user_levels
+		WHERE user_id = $1 AND deleted_at IS NULL
 	`
 	if s.driver == DriverMySQL {
 		levelsQuery = `
-			SELECT metric, level FROM user_levels
-			WHERE user_id = ?
+			SELECT metric, level, updated_at FROM user_levels
+			WHERE user_id = ? AND deleted_at IS NULL
 		`
 	}
 
-	levelsRows, err := s.db.QueryContext(ctx, levelsQuery, userID)
+	levelsRows, err := s.queryer().QueryContext(ctx, levelsQuery, userID)
 	if err != nil {
 		return core.UserState{}, fmt.Errorf("failed to get levels: %w", err)
 	}
@@ -358,56 +624,56 @@ func (s *Store) GetState(ctx context.Context, userID core.UserID) (core.UserStat
 	for levelsRows.Next() {
 		var metric core.Metric
 		var level int64
-		if err := levelsRows.Scan(&metric, &level); err != nil {
+		var at sql.NullTime
+		if err := levelsRows.Scan(&metric, &level, &at); err != nil {
 			return core.UserState{}, fmt.Errorf("failed to scan level: %w", err)
 		}
 		state.Levels[metric] = level
+		touch(at)
 	}
 
 	return state, nil
 }
 
 // SetLevel sets the user's level for a specific metric
-func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) error {
-	tx, err := s.db.BeginTxx(ctx, nil)
+func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) (err error) {
+	ctx, span := s.span(ctx, "SetLevel", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
 	}
-	defer tx.Rollback()
+	defer s.rollback(tx)
 
-	// Check if level already exists
-	var exists bool
-	checkQuery := `
-		SELECT EXISTS(
-			SELECT 1 FROM user_levels
-			WHERE user_id = $1 AND metric = $2
-		)
-	`
-	if s.driver == DriverMySQL {
-		checkQuery = `
-			SELECT EXISTS(
-				SELECT 1 FROM user_levels
-				WHERE user_id = ? AND metric = ?
-			)
-		`
+	// Check if level already exists; a tombstoned level counts as 0
+	var (
+		current int64
+		deleted sql.NullTime
+	)
+	exists := true
+	err = tx.QueryRowContext(ctx, tx.Rebind(`SELECT level, deleted_at FROM user_levels WHERE user_id = ? AND metric = ?`), userID, metric).Scan(&current, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		exists, err = false, nil
 	}
-
-	err = tx.QueryRowContext(ctx, checkQuery, userID, metric).Scan(&exists)
 	if err != nil {
 		return fmt.Errorf("failed to check level existence: %w", err)
 	}
+	if !exists || deleted.Valid {
+		current = 0
+	}
 
 	if exists {
 		// Update existing
 		updateQuery := `
 			UPDATE user_levels
-			SET level = $1, updated_at = $2
+			SET level = $1, updated_at = $2, deleted_at = NULL
 			WHERE user_id = $3 AND metric = $4
 		`
 		if s.driver == DriverMySQL {
 			updateQuery = `
 				UPDATE user_levels
-				SET level = ?, updated_at = ?
+				SET level = ?, updated_at = ?, deleted_at = NULL
 				WHERE user_id = ? AND metric = ?
 			`
 		}
@@ -430,6 +696,288 @@ func (s *Store) SetLevel(ctx context.Context, userID core.UserID, metric core.Me
 	if err != nil {
 		return fmt.Errorf("failed to set level: %w", err)
 	}
+	if level > current {
+		if err := s.stage(ctx, tx, core.NewLevelUp(userID, metric, level)); err != nil {
+			return err
+		}
+	}
 
-	return tx.Commit()
+	return s.commit(tx)
+}
+
+// RaiseLevel stores level if it is above the user's current level of metric, a missing or
+// tombstoned level counting as 0 (see engine.LevelRaiser). The row is created if needed and then
+// raised with a single conditional UPDATE, so of concurrent raises only higher levels land.
+func (s *Store) RaiseLevel(ctx context.Context, userID core.UserID, metric core.Metric, level int64) (_ bool, err error) {
+	ctx, span := s.span(ctx, "RaiseLevel", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	if level <= 0 {
+		return false, nil
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer s.rollback(tx)
+
+	// the new row holds 0, below any level raised to, so the update always applies to it
+	now := time.Now().UTC()
+	insertQuery := `INSERT INTO user_levels (user_id, metric, level, created_at, updated_at)
+		VALUES (?, ?, 0, ?, ?) ON CONFLICT (user_id, metric) DO NOTHING`
+	if s.driver == DriverMySQL {
+		insertQuery = `INSERT INTO user_levels (user_id, metric, level, created_at, updated_at)
+			VALUES (?, ?, 0, ?, ?) ON DUPLICATE KEY UPDATE metric = metric`
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(insertQuery), userID, metric, now, now); err != nil {
+		return false, fmt.Errorf("failed to create level: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, tx.Rebind(`UPDATE user_levels SET level = ?, updated_at = ?, deleted_at = NULL
+		WHERE user_id = ? AND metric = ? AND (level < ? OR deleted_at IS NOT NULL)`), level, now, userID, metric, level)
+	if err != nil {
+		return false, fmt.Errorf("failed to raise level: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to raise level: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	if err := s.stage(ctx, tx, core.NewLevelUp(userID, metric, level)); err != nil {
+		return false, err
+	}
+	return true, s.commit(tx)
 }
+
+// EachUser calls fn for every user with stored (not soft-deleted) points, badges or levels.
+// User IDs are read up front so fn may call back into the store.
+func (s *Store) EachUser(ctx context.Context, fn func(core.UserID) error) (err error) {
+	defer func() { err = classify(ctx, err) }()
+	var users []string
+	query := `SELECT user_id FROM user_points WHERE deleted_at IS NULL
+		UNION SELECT user_id FROM user_badges WHERE deleted_at IS NULL
+		UNION SELECT user_id FROM user_levels WHERE deleted_at IS NULL
+		ORDER BY user_id`
+	if err := sqlx.SelectContext(ctx, s.queryer(), &users, query); err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	for _, u := range users {
+		if err := fn(core.UserID(u)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceState overwrites the user's points, badges and levels with state in one transaction.
+// Timestamped point increments (point_events) are kept. The user's current rows are deleted, or
+// with Config.SoftDelete tombstoned and kept until PurgeDeleted; rows of state replace tombstones
+// of the same metric or badge. Badges the user already holds keep their award time.
+func (s *Store) ReplaceState(ctx context.Context, userID core.UserID, state core.UserState) (err error) {
+	ctx, span := s.span(ctx, "ReplaceState", userID)
+	defer func() { span.End(err) }()
+	defer func() { err = classify(ctx, err) }()
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.rollback(tx)
+
+	// badges the user keeps keep their award time
+	awarded := map[core.Badge]time.Time{}
+	rows, err := tx.QueryxContext(ctx, tx.Rebind(`SELECT badge, awarded_at FROM user_badges WHERE user_id = ? AND deleted_at IS NULL`), userID)
+	if err != nil {
+		return fmt.Errorf("failed to read badges: %w", err)
+	}
+	for rows.Next() {
+		var badge string
+		var at time.Time
+		if err := rows.Scan(&badge, &at); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read badges: %w", err)
+		}
+		awarded[core.Badge(badge)] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read badges: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, table := range []string{"user_points", "user_badges", "user_levels"} {
+		query := `DELETE FROM ` + table + ` WHERE user_id = ? AND deleted_at IS NULL`
+		args := []any{userID}
+		if s.softDelete {
+			query = `UPDATE ` + table + ` SET deleted_at = ? WHERE user_id = ? AND deleted_at IS NULL`
+			args = []any{now, userID}
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+	pointsQuery := `INSERT INTO user_points (user_id, metric, points, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, metric) DO UPDATE SET points = EXCLUDED.points, updated_at = EXCLUDED.updated_at, deleted_at = NULL`
+	badgeQuery := `INSERT INTO user_badges (user_id, badge, awarded_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, badge) DO UPDATE SET awarded_at = EXCLUDED.awarded_at, deleted_at = NULL`
+	levelQuery := `INSERT INTO user_levels (user_id, metric, level, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, metric) DO UPDATE SET level = EXCLUDED.level, updated_at = EXCLUDED.updated_at, deleted_at = NULL`
+	if s.driver == DriverMySQL {
+		pointsQuery = `INSERT INTO user_points (user_id, metric, points, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE points = VALUES(points), updated_at = VALUES(updated_at), deleted_at = NULL`
+		badgeQuery = `INSERT INTO user_badges (user_id, badge, awarded_at) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE awarded_at = VALUES(awarded_at), deleted_at = NULL`
+		levelQuery = `INSERT INTO user_levels (user_id, metric, level, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE level = VALUES(level), updated_at = VALUES(updated_at), deleted_at = NULL`
+	}
+	for metric, points := range state.Points {
+		if _, err := tx.ExecContext(ctx, tx.Rebind(pointsQuery), userID, metric, points, now, now); err != nil {
+			return fmt.Errorf("failed to write points: %w", err)
+		}
+	}
+	for badge := range state.Badges {
+		at, kept := awarded[badge]
+		if !kept {
+			at = now
+		}
+		if _, err := tx.ExecContext(ctx, tx.Rebind(badgeQuery), userID, badge, at); err != nil {
+			return fmt.Errorf("failed to write badge: %w", err)
+		}
+	}
+	for metric, level := range state.Levels {
+		if _, err := tx.ExecContext(ctx, tx.Rebind(levelQuery), userID, metric, level, now, now); err != nil {
+			return fmt.Errorf("failed to write level: %w", err)
+		}
+	}
+	if err := s.stage(ctx, tx, core.NewStateReplaced(userID, state)); err != nil {
+		return err
+	}
+	if err := s.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Exists reports whether the user has any points, badges or levels, using a single query.
+func (s *Store) Exists(ctx context.Context, userID core.UserID) (_ bool, err error) {
+	defer func() { err = classify(ctx, err) }()
+	query := `SELECT EXISTS (
+		SELECT 1 FROM user_points WHERE user_id = $1 AND deleted_at IS NULL
+		UNION ALL SELECT 1 FROM user_badges WHERE user_id = $1 AND deleted_at IS NULL
+		UNION ALL SELECT 1 FROM user_levels WHERE user_id = $1 AND deleted_at IS NULL
+	)`
+	args := []any{userID}
+	if s.driver == DriverMySQL {
+		query = `SELECT EXISTS (
+			SELECT 1 FROM user_points WHERE user_id = ? AND deleted_at IS NULL
+			UNION ALL SELECT 1 FROM user_badges WHERE user_id = ? AND deleted_at IS NULL
+			UNION ALL SELECT 1 FROM user_levels WHERE user_id = ? AND deleted_at IS NULL
+		)`
+		args = []any{userID, userID, userID}
+	}
+	var exists bool
+	if err := s.queryer().QueryRowxContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check user: %w", err)
+	}
+	return exists, nil
+}
+
+// PointsInWindow sums the user's increments of metric recorded within the last window; windows
+// longer than the retention fail with core.ErrWindowTooLong. Expired increments are deleted by
+// PrunePointEvents, not by reads.
+func (s *Store) PointsInWindow(ctx context.Context, userID core.UserID, metric core.Metric, window time.Duration) (_ int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	if window > s.retention {
+		return 0, core.ErrWindowTooLong
+	}
+	query := s.db.Rebind(`SELECT COALESCE(SUM(delta), 0) FROM point_events WHERE user_id = ? AND metric = ? AND created_at >= ? AND deleted_at IS NULL`)
+	var sum int64
+	if err := s.queryer().QueryRowxContext(ctx, query, userID, metric, time.Now().UTC().Add(-window)).Scan(&sum); err != nil {
+		return 0, fmt.Errorf("failed to sum point events: %w", err)
+	}
+	return sum, nil
+}
+
+// PointsPerPeriod sums the user's increments of metric in each of the last n periods, most recent
+// first, reading them in one query.
+func (s *Store) PointsPerPeriod(ctx context.Context, userID core.UserID, metric core.Metric, period time.Duration, n int) (_ []int64, err error) {
+	defer func() { err = classify(ctx, err) }()
+	if period <= 0 || n <= 0 {
+		return nil, fmt.Errorf("period and count must be positive, got %s and %d", period, n)
+	}
+	if period*time.Duration(n) > s.retention {
+		return nil, core.ErrWindowTooLong
+	}
+	now := time.Now().UTC()
+	query := s.db.Rebind(`SELECT created_at, delta FROM point_events WHERE user_id = ? AND metric = ? AND created_at >= ? AND deleted_at IS NULL`)
+	rows, err := s.queryer().QueryxContext(ctx, query, userID, metric, now.Add(-period*time.Duration(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read point events: %w", err)
+	}
+	defer rows.Close()
+	sums := make([]int64, n)
+	for rows.Next() {
+		var at time.Time
+		var delta int64
+		if err := rows.Scan(&at, &delta); err != nil {
+			return nil, fmt.Errorf("failed to scan point event: %w", err)
+		}
+		if bucket := int(max(now.Sub(at), 0) / period); bucket < n {
+			sums[bucket] += delta
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read point events: %w", err)
+	}
+	return sums, nil
+}
+
+// newEventID returns a random 32-character hex ID for point_events rows
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// LockUser locks the user until the bound transaction ends, so concurrent LockUser calls for the
+// user, e.g. of AddPointsIf, Transfer or MergeUsers, wait for it. It locks a row of user_locks,
+// created on first use, so users without any data yet are locked too, and then the user's existing
+// points and level rows (SELECT ... FOR UPDATE), so plain AddPoints/SetLevel calls wait as well.
+// It must be called on a store bound to a transaction, e.g. inside WithTx.
+func (s *Store) LockUser(ctx context.Context, userID core.UserID) (err error) {
+	defer func() { err = classify(ctx, err) }()
+	if s.tx == nil {
+		return errors.New("LockUser requires a transaction")
+	}
+	// a concurrent insert of the same row waits for the first one's transaction, so the row exists
+	// either way before it is locked
+	insert := `INSERT INTO user_locks (user_id) VALUES (?) ON CONFLICT (user_id) DO NOTHING`
+	if s.driver == DriverMySQL {
+		insert = `INSERT INTO user_locks (user_id) VALUES (?) ON DUPLICATE KEY UPDATE user_id = user_id`
+	}
+	if _, err := s.tx.ExecContext(ctx, s.tx.Rebind(insert), userID); err != nil {
+		return fmt.Errorf("failed to create lock row: %w", err)
+	}
+	for _, table := range []string{"user_locks", "user_points", "user_levels"} {
+		query := s.tx.Rebind(`SELECT user_id FROM ` + table + ` WHERE user_id = ? FOR UPDATE`)
+		if _, err := s.tx.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("failed to lock %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+var _ engine.Txner = (*Store)(nil)
+var _ engine.UserLocker = (*Store)(nil)
+var _ engine.WindowedPoints = (*Store)(nil)
+var _ engine.UserLister = (*Store)(nil)
+var _ engine.BadgeRemover = (*Store)(nil)
+var _ engine.BadgeAwarder = (*Store)(nil)
+var _ engine.BadgeRepeater = (*Store)(nil)
+var _ engine.UserQuerier = (*Store)(nil)
+var _ engine.BadgeLister = (*Store)(nil)
+var _ engine.IdentityMapper = (*Store)(nil)
+var _ engine.QuestStore = (*Store)(nil)
+var _ engine.StateBatchGetter = (*Store)(nil)
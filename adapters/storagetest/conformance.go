@@ -0,0 +1,902 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// RunConformance checks the engine.Storage contract every adapter must honour, so they are
+// interchangeable behind the engine. newStore is called once per subtest; it may return a shared
+// store, since each subtest uses its own user IDs (prefixed "storagetest-"). Zero deltas are not
+// covered because the engine rejects them before storage is reached.
+func RunConformance(t *testing.T, newStore func(t *testing.T) engine.Storage) {
+	t.Helper()
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s engine.Storage, user core.UserID)
+	}{
+		{"EmptyUser", testEmptyUser},
+		{"AddPoints", testAddPoints},
+		{"Overflow", testOverflow},
+		{"IdempotentBadges", testIdempotentBadges},
+		{"SetLevelOverwrites", testSetLevelOverwrites},
+		{"UsersIsolated", testUsersIsolated},
+		{"StateIsACopy", testStateIsACopy},
+		{"ConcurrentAddPoints", testConcurrentAddPoints},
+		{"UpdatePoints", testUpdatePoints},
+		{"RaiseLevel", testRaiseLevel},
+		{"Exists", testExists},
+		{"ReplaceState", testReplaceState},
+		{"MergeUsers", testMergeUsers},
+		{"RemoveBadge", testRemoveBadge},
+		{"TryAwardBadge", testTryAwardBadge},
+		{"RepeatBadge", testRepeatBadge},
+		{"QueryUsers", testQueryUsers},
+		{"ListBadges", testListBadges},
+		{"Identities", testIdentities},
+		{"Quests", testQuests},
+		{"SeasonKeeper", testSeasonKeeper},
+		{"ContextDone", testContextDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t), core.UserID("storagetest-"+strings.ToLower(tt.name)))
+		})
+	}
+}
+
+// Users returns the IDs RunConformance writes to, for adapters that clean up between runs.
+func Users() []core.UserID {
+	names := []string{"emptyuser", "addpoints", "overflow", "idempotentbadges", "setleveloverwrites", "usersisolated", "usersisolated-other", "stateisacopy", "concurrentaddpoints", "updatepoints", "raiselevel", "exists", "replacestate", "mergeusers", "mergeusers-source", "removebadge", "tryawardbadge", "repeatbadge", "queryusers-a", "queryusers-b", "queryusers-c", "listbadges", "identities", "identities-other", "quests", "contextdone"}
+	users := make([]core.UserID, len(names))
+	for i, n := range names {
+		users[i] = core.UserID("storagetest-" + n)
+	}
+	return users
+}
+
+func mustState(t *testing.T, s engine.Storage, user core.UserID) core.UserState {
+	t.Helper()
+	st, err := s.GetState(context.Background(), user)
+	if err != nil {
+		t.Fatalf("GetState(%s): %v", user, err)
+	}
+	return st
+}
+
+func testEmptyUser(t *testing.T, s engine.Storage, user core.UserID) {
+	st := mustState(t, s, user)
+	if st.UserID != user {
+		t.Errorf("UserID = %q, want %q", st.UserID, user)
+	}
+	if len(st.Points) != 0 || len(st.Badges) != 0 || len(st.Levels) != 0 {
+		t.Errorf("unknown user should have empty state, got %+v", st)
+	}
+	if st.Points == nil || st.Badges == nil || st.Levels == nil {
+		t.Errorf("unknown user should have non-nil maps, got %+v", st)
+	}
+}
+
+func testAddPoints(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	for _, step := range []struct {
+		metric core.Metric
+		delta  int64
+		want   int64
+	}{
+		{core.MetricXP, 10, 10},
+		{core.MetricXP, 25, 35},
+		{core.MetricXP, -5, 30},
+		{core.MetricPoints, -7, -7},
+	} {
+		got, err := s.AddPoints(ctx, user, step.metric, step.delta)
+		if err != nil {
+			t.Fatalf("AddPoints(%s, %d): %v", step.metric, step.delta, err)
+		}
+		if got != step.want {
+			t.Fatalf("AddPoints(%s, %d) = %d, want %d", step.metric, step.delta, got, step.want)
+		}
+	}
+	st := mustState(t, s, user)
+	if st.Points[core.MetricXP] != 30 || st.Points[core.MetricPoints] != -7 {
+		t.Errorf("stored points = %v, want xp=30 points=-7", st.Points)
+	}
+}
+
+func testOverflow(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	if _, err := s.AddPoints(ctx, user, core.MetricXP, math.MaxInt64-5); err != nil {
+		t.Fatalf("AddPoints near the limit: %v", err)
+	}
+	if _, err := s.AddPoints(ctx, user, core.MetricXP, 10); err == nil {
+		t.Error("AddPoints past MaxInt64 should fail")
+	}
+	if _, err := s.AddPoints(ctx, user, core.MetricPoints, math.MinInt64+5); err != nil {
+		t.Fatalf("AddPoints near the lower limit: %v", err)
+	}
+	if _, err := s.AddPoints(ctx, user, core.MetricPoints, -10); err == nil {
+		t.Error("AddPoints past MinInt64 should fail")
+	}
+	st := mustState(t, s, user)
+	if st.Points[core.MetricXP] != math.MaxInt64-5 || st.Points[core.MetricPoints] != math.MinInt64+5 {
+		t.Errorf("a failed AddPoints must not change the total, got %v", st.Points)
+	}
+}
+
+func testIdempotentBadges(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := s.AwardBadge(ctx, user, "founder"); err != nil {
+			t.Fatalf("AwardBadge #%d: %v", i+1, err)
+		}
+	}
+	st := mustState(t, s, user)
+	if _, ok := st.Badges["founder"]; !ok || len(st.Badges) != 1 {
+		t.Errorf("badges = %v, want just founder", st.Badges)
+	}
+}
+
+func testSetLevelOverwrites(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	for _, level := range []int64{3, 1} {
+		if err := s.SetLevel(ctx, user, core.MetricXP, level); err != nil {
+			t.Fatalf("SetLevel(%d): %v", level, err)
+		}
+	}
+	if got := mustState(t, s, user).Levels[core.MetricXP]; got != 1 {
+		t.Errorf("level = %d, want the last value set (1)", got)
+	}
+}
+
+func testUsersIsolated(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	other := user + "-other"
+	if _, err := s.AddPoints(ctx, user, core.MetricXP, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AwardBadge(ctx, user, "solo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLevel(ctx, user, core.MetricXP, 2); err != nil {
+		t.Fatal(err)
+	}
+	st := mustState(t, s, other)
+	if len(st.Points) != 0 || len(st.Badges) != 0 || len(st.Levels) != 0 {
+		t.Errorf("writes to %s leaked into %s: %+v", user, other, st)
+	}
+}
+
+func testStateIsACopy(t *testing.T, s engine.Storage, user core.UserID) {
+	ctx := context.Background()
+	if _, err := s.AddPoints(ctx, user, core.MetricXP, 5); err != nil {
+		t.Fatal(err)
+	}
+	st := mustState(t, s, user)
+	st.Points[core.MetricXP] = 1000
+	st.Badges["forged"] = struct{}{}
+	st.Levels[core.MetricXP] = 99
+	again := mustState(t, s, user)
+	if again.Points[core.MetricXP] != 5 || len(again.Badges) != 0 || len(again.Levels) != 0 {
+		t.Errorf("mutating a returned state changed storage: %+v", again)
+	}
+}
+
+func testConcurrentAddPoints(t *testing.T, s engine.Storage, user core.UserID) {
+	const workers, perWorker = 10, 10
+	// seed the row first: racing first writes are adapter-specific and covered by the adapters' own tests
+	if _, err := s.AddPoints(context.Background(), user, core.MetricXP, 1); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, err := s.AddPoints(context.Background(), user, core.MetricXP, 1); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent AddPoints: %v", err)
+	}
+	if got := mustState(t, s, user).Points[core.MetricXP]; got != workers*perWorker+1 {
+		t.Errorf("total after concurrent adds = %d, want %d", got, workers*perWorker+1)
+	}
+}
+
+// testUpdatePoints applies to storages implementing engine.PointsUpdater: concurrent capped
+// updates must never push the total past the cap
+func testUpdatePoints(t *testing.T, s engine.Storage, user core.UserID) {
+	u, ok := s.(engine.PointsUpdater)
+	if !ok {
+		t.Skip("storage does not implement engine.PointsUpdater")
+	}
+	ctx := context.Background()
+	errFull := errors.New("full")
+	capped := func(current int64) (int64, error) {
+		if current >= 50 {
+			return 0, errFull
+		}
+		return current + 1, nil
+	}
+	const workers, perWorker = 10, 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, _, err := u.UpdatePoints(ctx, user, core.MetricXP, capped); err != nil && !errors.Is(err, errFull) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent UpdatePoints: %v", err)
+	}
+	if got := mustState(t, s, user).Points[core.MetricXP]; got != 50 {
+		t.Errorf("total after capped updates = %d, want 50", got)
+	}
+	previous, total, err := u.UpdatePoints(ctx, user, core.MetricXP, func(current int64) (int64, error) { return current, nil })
+	if err != nil || previous != 50 || total != 50 {
+		t.Errorf("no-op update = %d, %d, %v; want 50, 50", previous, total, err)
+	}
+	if _, _, err := u.UpdatePoints(ctx, user, core.MetricXP, capped); !errors.Is(err, errFull) {
+		t.Errorf("rejected update: got %v, want the function's error", err)
+	}
+}
+
+// testRaiseLevel applies to storages implementing engine.LevelRaiser: of concurrent raises the
+// highest level must win, and lower ones must never overwrite it
+func testRaiseLevel(t *testing.T, s engine.Storage, user core.UserID) {
+	r, ok := s.(engine.LevelRaiser)
+	if !ok {
+		t.Skip("storage does not implement engine.LevelRaiser")
+	}
+	ctx := context.Background()
+	const levels = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, levels)
+	for level := int64(1); level <= levels; level++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.RaiseLevel(ctx, user, core.MetricXP, level); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent RaiseLevel: %v", err)
+	}
+	if got := mustState(t, s, user).Levels[core.MetricXP]; got != levels {
+		t.Errorf("level after concurrent raises = %d, want %d", got, levels)
+	}
+	if raised, err := r.RaiseLevel(ctx, user, core.MetricXP, 3); err != nil || raised {
+		t.Errorf("lower raise = %v, %v; want false", raised, err)
+	}
+	if raised, err := r.RaiseLevel(ctx, user, core.MetricXP, levels+1); err != nil || !raised {
+		t.Errorf("higher raise = %v, %v; want true", raised, err)
+	}
+	if got := mustState(t, s, user).Levels[core.MetricXP]; got != levels+1 {
+		t.Errorf("level = %d, want %d", got, levels+1)
+	}
+}
+
+// testExists applies to storages implementing engine.UserExister
+func testExists(t *testing.T, s engine.Storage, user core.UserID) {
+	e, ok := s.(engine.UserExister)
+	if !ok {
+		t.Skip("storage does not implement engine.UserExister")
+	}
+	ctx := context.Background()
+	mustState(t, s, user) // reading must not create the user
+	if exists, err := e.Exists(ctx, user); err != nil || exists {
+		t.Fatalf("Exists(unknown) = %t, %v; want false", exists, err)
+	}
+	if err := s.AwardBadge(ctx, user, "first"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := e.Exists(ctx, user); err != nil || !exists {
+		t.Fatalf("Exists after a write = %t, %v; want true", exists, err)
+	}
+}
+
+// testRemoveBadge applies to storages implementing engine.BadgeRemover
+func testRemoveBadge(t *testing.T, s engine.Storage, user core.UserID) {
+	r, ok := s.(engine.BadgeRemover)
+	if !ok {
+		t.Skip("storage does not implement engine.BadgeRemover")
+	}
+	ctx := context.Background()
+	for _, b := range []core.Badge{"keep", "drop"} {
+		if err := s.AwardBadge(ctx, user, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := r.RemoveBadge(ctx, user, "drop"); err != nil || !removed {
+		t.Fatalf("RemoveBadge = %t, %v; want true", removed, err)
+	}
+	if removed, err := r.RemoveBadge(ctx, user, "drop"); err != nil || removed {
+		t.Fatalf("second RemoveBadge = %t, %v; want false", removed, err)
+	}
+	st := mustState(t, s, user)
+	if _, ok := st.Badges["keep"]; !ok || len(st.Badges) != 1 {
+		t.Errorf("badges = %v, want only keep", st.Badges)
+	}
+	if err := s.AwardBadge(ctx, user, "drop"); err != nil {
+		t.Fatal(err)
+	}
+	if st := mustState(t, s, user); len(st.Badges) != 2 {
+		t.Errorf("badges after awarding again = %v, want keep and drop", st.Badges)
+	}
+}
+
+// testTryAwardBadge applies to storages implementing engine.BadgeAwarder
+func testTryAwardBadge(t *testing.T, s engine.Storage, user core.UserID) {
+	a, ok := s.(engine.BadgeAwarder)
+	if !ok {
+		t.Skip("storage does not implement engine.BadgeAwarder")
+	}
+	ctx := context.Background()
+	const workers = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			awarded, err := a.TryAwardBadge(ctx, user, "race")
+			if err != nil {
+				t.Errorf("TryAwardBadge: %v", err)
+			}
+			results <- awarded
+		}()
+	}
+	wg.Wait()
+	close(results)
+	firsts := 0
+	for awarded := range results {
+		if awarded {
+			firsts++
+		}
+	}
+	if firsts != 1 {
+		t.Errorf("%d of %d concurrent awards reported a new badge, want 1", firsts, workers)
+	}
+	if st := mustState(t, s, user); len(st.Badges) != 1 {
+		t.Errorf("badges = %v, want only race", st.Badges)
+	}
+	if r, ok := s.(engine.BadgeRemover); ok {
+		if _, err := r.RemoveBadge(ctx, user, "race"); err != nil {
+			t.Fatal(err)
+		}
+		if awarded, err := a.TryAwardBadge(ctx, user, "race"); err != nil || !awarded {
+			t.Errorf("TryAwardBadge after removal = %t, %v; want true", awarded, err)
+		}
+	}
+}
+
+// testRepeatBadge applies to storages implementing engine.BadgeRepeater
+func testRepeatBadge(t *testing.T, s engine.Storage, user core.UserID) {
+	r, ok := s.(engine.BadgeRepeater)
+	if !ok {
+		t.Skip("storage does not implement engine.BadgeRepeater")
+	}
+	ctx := context.Background()
+	if rec, err := r.BadgeRecord(ctx, user, "streak"); err != nil || rec.Count != 0 {
+		t.Fatalf("BadgeRecord before any award = %+v, %v; want zero", rec, err)
+	}
+	const cooldown = time.Hour
+	start := time.Now().UTC().Truncate(time.Millisecond)
+
+	const workers = 10
+	var wg sync.WaitGroup
+	results := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, awarded, err := r.RepeatBadge(ctx, user, "streak", start, cooldown)
+			if err != nil {
+				t.Errorf("RepeatBadge: %v", err)
+			}
+			results <- awarded
+		}()
+	}
+	wg.Wait()
+	close(results)
+	firsts := 0
+	for awarded := range results {
+		if awarded {
+			firsts++
+		}
+	}
+	if firsts != 1 {
+		t.Errorf("%d of %d concurrent awards succeeded, want 1", firsts, workers)
+	}
+	if _, ok := mustState(t, s, user).Badges["streak"]; !ok {
+		t.Error("repeatable badge missing from state")
+	}
+
+	rec, awarded, err := r.RepeatBadge(ctx, user, "streak", start.Add(cooldown-time.Second), cooldown)
+	if err != nil || awarded || rec.Count != 1 || !rec.LastAwarded.Equal(start) {
+		t.Errorf("RepeatBadge within cooldown = %+v, %t, %v; want count 1 at %s, not awarded", rec, awarded, err, start)
+	}
+	later := start.Add(cooldown)
+	rec, awarded, err = r.RepeatBadge(ctx, user, "streak", later, cooldown)
+	if err != nil || !awarded || rec.Count != 2 || !rec.LastAwarded.Equal(later) {
+		t.Errorf("RepeatBadge after cooldown = %+v, %t, %v; want count 2 at %s, awarded", rec, awarded, err, later)
+	}
+	if got, err := r.BadgeRecord(ctx, user, "streak"); err != nil || got.Count != 2 || !got.LastAwarded.Equal(later) {
+		t.Errorf("BadgeRecord = %+v, %v; want count 2 at %s", got, err, later)
+	}
+}
+
+// testQueryUsers applies to storages implementing engine.UserQuerier. It writes to user and
+// two users derived from it, under a badge and metric no other subtest uses.
+func testQueryUsers(t *testing.T, s engine.Storage, user core.UserID) {
+	q, ok := s.(engine.UserQuerier)
+	if !ok {
+		t.Skip("storage does not implement engine.UserQuerier")
+	}
+	ctx := context.Background()
+	const metric, badge = core.Metric("storagetest_query"), core.Badge("storagetest-query")
+	users := []core.UserID{user + "-a", user + "-b", user + "-c"}
+	for i, u := range users {
+		if _, err := s.AddPoints(ctx, u, metric, int64(i+1)*100); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetLevel(ctx, u, metric, int64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, u := range users[1:] {
+		if err := s.AwardBadge(ctx, u, badge); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lo, hi, level := int64(200), int64(250), int64(3)
+	tests := []struct {
+		name   string
+		filter core.UserFilter
+		want   []core.UserID
+	}{
+		{"badge", core.UserFilter{Badge: badge}, users[1:]},
+		{"min points", core.UserFilter{Metric: metric, MinPoints: &lo}, users[1:]},
+		{"points range", core.UserFilter{Metric: metric, MinPoints: &lo, MaxPoints: &hi}, users[1:2]},
+		{"level", core.UserFilter{Metric: metric, Level: &level}, users[2:]},
+		{"badge and max points", core.UserFilter{Badge: badge, Metric: metric, MaxPoints: &hi}, users[1:2]},
+		{"page", core.UserFilter{Badge: badge, Limit: 1}, users[1:2]},
+		{"next page", core.UserFilter{Badge: badge, After: users[1], Limit: 1}, users[2:]},
+	}
+	for _, tt := range tests {
+		got, err := q.QueryUsers(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if strings.Join(userStrings(got), ",") != strings.Join(userStrings(tt.want), ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testListBadges applies to storages implementing engine.BadgeLister
+func testListBadges(t *testing.T, s engine.Storage, user core.UserID) {
+	l, ok := s.(engine.BadgeLister)
+	if !ok {
+		t.Skip("storage does not implement engine.BadgeLister")
+	}
+	ctx := context.Background()
+	start := time.Now().Add(-time.Second)
+	for _, b := range []core.Badge{"first", "second", "third"} {
+		if err := s.AwardBadge(ctx, user, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := l.ListBadges(ctx, user, core.BadgeFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListBadges = %v, %v; want 3 awards", all, err)
+	}
+	for i, a := range all {
+		if a.AwardedAt.Before(start) || a.AwardedAt.After(time.Now().Add(time.Second)) {
+			t.Errorf("%s awarded at %s, want about now", a.Badge, a.AwardedAt)
+		}
+		if i > 0 && !all[i-1].Precedes(a) {
+			t.Errorf("%v listed before %v", all[i-1], a)
+		}
+	}
+
+	// paging one at a time visits every award once, in the same order
+	var paged []core.BadgeAward
+	filter := core.BadgeFilter{Limit: 1}
+	for i := 0; i < 4; i++ {
+		page, err := l.ListBadges(ctx, user, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		paged = append(paged, page...)
+		filter.After = &page[0]
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("paged through %v, want %v", paged, all)
+	}
+	for i := range all {
+		if paged[i].Badge != all[i].Badge {
+			t.Errorf("page %d = %v, want %v", i, paged[i], all[i])
+		}
+	}
+
+	if later, err := l.ListBadges(ctx, user, core.BadgeFilter{Since: all[0].AwardedAt.Add(time.Hour)}); err != nil || len(later) != 0 {
+		t.Errorf("ListBadges since after the newest award = %v, %v; want none", later, err)
+	}
+	if r, ok := s.(engine.BadgeRemover); ok {
+		if _, err := r.RemoveBadge(ctx, user, "second"); err != nil {
+			t.Fatal(err)
+		}
+		if left, err := l.ListBadges(ctx, user, core.BadgeFilter{}); err != nil || len(left) != 2 {
+			t.Errorf("ListBadges after removal = %v, %v; want 2 awards", left, err)
+		}
+	}
+}
+
+func userStrings(users []core.UserID) []string {
+	out := make([]string, len(users))
+	for i, u := range users {
+		out[i] = string(u)
+	}
+	return out
+}
+
+// testReplaceState applies to storages implementing engine.StateReplacer
+func testReplaceState(t *testing.T, s engine.Storage, user core.UserID) {
+	r, ok := s.(engine.StateReplacer)
+	if !ok {
+		t.Skip("storage does not implement engine.StateReplacer")
+	}
+	ctx := context.Background()
+	if _, err := s.AddPoints(ctx, user, core.MetricXP, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPoints(ctx, user, core.MetricPoints, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AwardBadge(ctx, user, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLevel(ctx, user, core.MetricPoints, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	next := core.UserState{
+		Points: map[core.Metric]int64{core.MetricXP: 42},
+		Badges: map[core.Badge]struct{}{"restored": {}},
+		Levels: map[core.Metric]int64{core.MetricXP: 2},
+	}
+	if err := r.ReplaceState(ctx, user, next); err != nil {
+		t.Fatalf("ReplaceState: %v", err)
+	}
+	// the caller keeps ownership of the state it passed in
+	next.Points[core.MetricXP] = 1000
+	next.Badges["forged"] = struct{}{}
+	next.Levels[core.MetricXP] = 99
+	st := mustState(t, s, user)
+	if len(st.Points) != 1 || st.Points[core.MetricXP] != 42 {
+		t.Errorf("points = %v, want only xp=42", st.Points)
+	}
+	if _, ok := st.Badges["restored"]; !ok || len(st.Badges) != 1 {
+		t.Errorf("badges = %v, want only restored", st.Badges)
+	}
+	if len(st.Levels) != 1 || st.Levels[core.MetricXP] != 2 {
+		t.Errorf("levels = %v, want only xp=2", st.Levels)
+	}
+	if total, err := s.AddPoints(ctx, user, core.MetricXP, 1); err != nil || total != 43 {
+		t.Errorf("AddPoints after replace = %d, %v; want 43", total, err)
+	}
+}
+
+func testMergeUsers(t *testing.T, s engine.Storage, user core.UserID) {
+	m, ok := s.(engine.UserMerger)
+	if !ok {
+		t.Skip("storage does not implement engine.UserMerger")
+	}
+	ctx := context.Background()
+	source := user + "-source"
+	for _, w := range []struct {
+		user   core.UserID
+		metric core.Metric
+		delta  int64
+	}{{source, core.MetricXP, 10}, {source, core.MetricPoints, 5}, {user, core.MetricXP, 20}} {
+		if _, err := s.AddPoints(ctx, w.user, w.metric, w.delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, w := range []struct {
+		user  core.UserID
+		badge core.Badge
+	}{{source, "guest"}, {source, "shared"}, {user, "shared"}, {user, "member"}} {
+		if err := s.AwardBadge(ctx, w.user, w.badge); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetLevel(ctx, source, core.MetricXP, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetLevel(ctx, user, core.MetricXP, 1); err != nil {
+		t.Fatal(err)
+	}
+	guest := "guest:" + string(source)
+	im, mapsIdentities := s.(engine.IdentityMapper)
+	if mapsIdentities {
+		t.Cleanup(func() { _, _ = im.UnlinkIdentity(ctx, guest) })
+		if _, err := im.LinkIdentity(ctx, guest, source); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errMerge := errors.New("merge refused")
+	if _, _, err := m.MergeUsers(ctx, source, user, func(_, _ core.UserState) (core.UserState, error) { return core.UserState{}, errMerge }); !errors.Is(err, errMerge) {
+		t.Fatalf("failing merge = %v, want %v", err, errMerge)
+	}
+	if st := mustState(t, s, source); st.Points[core.MetricXP] != 10 || len(st.Badges) != 2 {
+		t.Fatalf("source after a failed merge = %+v, want it untouched", st)
+	}
+	if st := mustState(t, s, user); st.Points[core.MetricXP] != 20 || len(st.Badges) != 2 {
+		t.Fatalf("target after a failed merge = %+v, want it untouched", st)
+	}
+
+	before, after, err := m.MergeUsers(ctx, source, user, core.MergePolicy{}.Merge)
+	if err != nil {
+		t.Fatalf("MergeUsers: %v", err)
+	}
+	if before.Points[core.MetricXP] != 20 || after.Points[core.MetricXP] != 30 {
+		t.Errorf("MergeUsers returned xp %d -> %d, want 20 -> 30", before.Points[core.MetricXP], after.Points[core.MetricXP])
+	}
+	st := mustState(t, s, user)
+	if len(st.Points) != 2 || st.Points[core.MetricXP] != 30 || st.Points[core.MetricPoints] != 5 {
+		t.Errorf("target points = %v, want xp=30 points=5", st.Points)
+	}
+	if len(st.Badges) != 3 {
+		t.Errorf("target badges = %v, want guest, shared and member", st.Badges)
+	}
+	if st.Levels[core.MetricXP] != 3 {
+		t.Errorf("target xp level = %d, want the higher 3", st.Levels[core.MetricXP])
+	}
+	if st := mustState(t, s, source); len(st.Points) != 0 || len(st.Badges) != 0 || len(st.Levels) != 0 {
+		t.Errorf("source after merge = %+v, want empty", st)
+	}
+	if e, ok := s.(engine.UserExister); ok {
+		if exists, err := e.Exists(ctx, source); err != nil || exists {
+			t.Errorf("Exists(source) = %v, %v; want false", exists, err)
+		}
+	}
+	if mapsIdentities {
+		if holder, ok, err := im.ResolveIdentity(ctx, guest); err != nil || !ok || holder != user {
+			t.Errorf("ResolveIdentity(%s) = %q, %v, %v; want it moved to %q", guest, holder, ok, err, user)
+		}
+	}
+
+	// retrying finds nothing left to merge
+	before, after, err = m.MergeUsers(ctx, source, user, core.MergePolicy{}.Merge)
+	if err != nil || after.Points[core.MetricXP] != 30 || before.Points[core.MetricXP] != 30 || len(after.Badges) != 3 {
+		t.Errorf("retried MergeUsers = %v -> %v, %v; want nothing changed", before.Points, after.Points, err)
+	}
+	if _, _, err := m.MergeUsers(ctx, user, user, core.MergePolicy{}.Merge); !errors.Is(err, core.ErrSelfMerge) {
+		t.Errorf("self-merge = %v, want core.ErrSelfMerge", err)
+	}
+}
+
+func testIdentities(t *testing.T, s engine.Storage, user core.UserID) {
+	m, ok := s.(engine.IdentityMapper)
+	if !ok {
+		t.Skip("storage does not implement engine.IdentityMapper")
+	}
+	ctx := context.Background()
+	other := user + "-other"
+	email, auth := "email:"+string(user)+"@example.com", "auth:"+string(user)
+	t.Cleanup(func() {
+		_, _ = m.UnlinkIdentity(ctx, email)
+		_, _ = m.UnlinkIdentity(ctx, auth)
+	})
+	if _, ok, err := m.ResolveIdentity(ctx, email); err != nil || ok {
+		t.Fatalf("ResolveIdentity before linking = %v, %v; want not found", ok, err)
+	}
+	for _, id := range []string{email, auth, email} {
+		if holder, err := m.LinkIdentity(ctx, id, user); err != nil || holder != user {
+			t.Fatalf("LinkIdentity(%s) = %q, %v; want %q", id, holder, err, user)
+		}
+	}
+	if holder, err := m.LinkIdentity(ctx, email, other); err != nil || holder != user {
+		t.Fatalf("linking a taken identity = %q, %v; want it kept by %q", holder, err, user)
+	}
+	if got, ok, err := m.ResolveIdentity(ctx, auth); err != nil || !ok || got != user {
+		t.Fatalf("ResolveIdentity = %q, %v, %v; want %q", got, ok, err, user)
+	}
+	ids, err := m.Identities(ctx, user)
+	if err != nil || len(ids) != 2 || ids[0] != auth || ids[1] != email {
+		t.Fatalf("Identities = %v, %v; want [%s %s]", ids, err, auth, email)
+	}
+	if ids, err := m.Identities(ctx, other); err != nil || len(ids) != 0 {
+		t.Fatalf("Identities of a user without links = %v, %v", ids, err)
+	}
+
+	if removed, err := m.UnlinkIdentity(ctx, email); err != nil || !removed {
+		t.Fatalf("UnlinkIdentity = %v, %v; want true", removed, err)
+	}
+	if removed, err := m.UnlinkIdentity(ctx, email); err != nil || removed {
+		t.Fatalf("second UnlinkIdentity = %v, %v; want false", removed, err)
+	}
+	if holder, err := m.LinkIdentity(ctx, email, other); err != nil || holder != other {
+		t.Fatalf("relinking a freed identity = %q, %v; want %q", holder, err, other)
+	}
+	if _, err := m.UnlinkIdentity(ctx, email); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testQuests applies to storages implementing engine.QuestStore
+func testQuests(t *testing.T, s engine.Storage, user core.UserID) {
+	q, ok := s.(engine.QuestStore)
+	if !ok {
+		t.Skip("storage does not implement engine.QuestStore")
+	}
+	ctx := context.Background()
+	if got, err := q.QuestProgress(ctx, user); err != nil || len(got) != 0 {
+		t.Fatalf("QuestProgress before any progress = %v, %v; want none", got, err)
+	}
+	p, before, err := q.AdvanceQuest(ctx, user, "weekly", "2024-W10", 30, 100)
+	if err != nil || before != 0 || p.Progress != 30 || p.Completed || p.CompletedAt != nil {
+		t.Fatalf("AdvanceQuest = %+v, %d, %v; want progress 30 from 0", p, before, err)
+	}
+
+	const workers = 10
+	var wg sync.WaitGroup
+	completions := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p, before, err := q.AdvanceQuest(ctx, user, "weekly", "2024-W10", 10, 100)
+			if err != nil {
+				t.Errorf("AdvanceQuest: %v", err)
+			}
+			completions <- before < 100 && p.Progress == 100
+		}()
+	}
+	wg.Wait()
+	close(completions)
+	completed := 0
+	for c := range completions {
+		if c {
+			completed++
+		}
+	}
+	if completed != 1 {
+		t.Errorf("%d of %d concurrent advances completed the quest, want 1", completed, workers)
+	}
+	p, before, err = q.AdvanceQuest(ctx, user, "weekly", "2024-W10", 10, 100)
+	if err != nil || before != 100 || p.Progress != 100 || !p.Completed || p.CompletedAt == nil {
+		t.Errorf("AdvanceQuest past the target = %+v, %d, %v; want capped at 100, completed", p, before, err)
+	}
+
+	p, before, err = q.AdvanceQuest(ctx, user, "weekly", "2024-W11", 5, 100)
+	if err != nil || before != 0 || p.Progress != 5 || p.Completed || p.CompletedAt != nil {
+		t.Errorf("AdvanceQuest in a new period = %+v, %d, %v; want progress 5 from 0", p, before, err)
+	}
+	if _, _, err := q.AdvanceQuest(ctx, user, "once", "", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.QuestProgress(ctx, user)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("QuestProgress = %+v, %v; want 2 records", got, err)
+	}
+	if got[0].Quest != "once" || got[0].Progress != 1 || got[0].CompletedAt == nil || got[1].Quest != "weekly" || got[1].Period != "2024-W11" || got[1].Progress != 5 {
+		t.Errorf("QuestProgress = %+v; want once completed and weekly at 5 in 2024-W11", got)
+	}
+}
+
+// testSeasonKeeper checks that the active season is switched only from the season still stored,
+// by exactly one of concurrent switches. Stores may be shared, so it starts from whatever is stored.
+func testSeasonKeeper(t *testing.T, s engine.Storage, _ core.UserID) {
+	k, ok := s.(engine.SeasonKeeper)
+	if !ok {
+		t.Skip("storage does not implement engine.SeasonKeeper")
+	}
+	ctx := context.Background()
+	current, err := k.ActiveSeason(ctx)
+	if err != nil {
+		t.Fatalf("ActiveSeason: %v", err)
+	}
+	next := fmt.Sprintf("storagetest-%d", time.Now().UnixNano())
+	if switched, err := k.SwitchSeason(ctx, current+"-stale", next); err != nil || switched {
+		t.Fatalf("SwitchSeason from a season not stored = %v, %v; want no switch", switched, err)
+	}
+	if switched, err := k.SwitchSeason(ctx, current, next); err != nil || !switched {
+		t.Fatalf("SwitchSeason from %q = %v, %v; want a switch", current, switched, err)
+	}
+	if got, err := k.ActiveSeason(ctx); err != nil || got != next {
+		t.Fatalf("ActiveSeason = %q, %v; want %q", got, err, next)
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var winners []string
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			season := fmt.Sprintf("%s-%d", next, i)
+			switched, err := k.SwitchSeason(ctx, next, season)
+			if err != nil {
+				t.Errorf("SwitchSeason: %v", err)
+			}
+			if switched {
+				mu.Lock()
+				winners = append(winners, season)
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Fatalf("%d of %d concurrent switches succeeded, want 1", len(winners), workers)
+	}
+	if got, _ := k.ActiveSeason(ctx); got != winners[0] {
+		t.Fatalf("ActiveSeason = %q, want the winning switch %q", got, winners[0])
+	}
+}
+
+// testContextDone checks that operations on a cancelled or timed-out context fail with an error
+// wrapping the context's, whatever the backend reported, and write nothing.
+func testContextDone(t *testing.T, s engine.Storage, user core.UserID) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{{"Canceled", cancelled, context.Canceled}, {"DeadlineExceeded", expired, context.DeadlineExceeded}} {
+		if _, err := s.AddPoints(tc.ctx, user, core.MetricXP, 10); !errors.Is(err, tc.want) {
+			t.Errorf("%s: AddPoints error = %v, want %v", tc.name, err, tc.want)
+		}
+		if err := s.AwardBadge(tc.ctx, user, core.Badge("late")); !errors.Is(err, tc.want) {
+			t.Errorf("%s: AwardBadge error = %v, want %v", tc.name, err, tc.want)
+		}
+		if err := s.SetLevel(tc.ctx, user, core.MetricXP, 2); !errors.Is(err, tc.want) {
+			t.Errorf("%s: SetLevel error = %v, want %v", tc.name, err, tc.want)
+		}
+		if _, err := s.GetState(tc.ctx, user); !errors.Is(err, tc.want) {
+			t.Errorf("%s: GetState error = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if st := mustState(t, s, user); len(st.Points) != 0 || len(st.Badges) != 0 || len(st.Levels) != 0 {
+		t.Errorf("operations on a done context wrote %+v", st)
+	}
+}
@@ -0,0 +1,294 @@
+// Package storagetest provides test helpers for engine.Storage: an in-memory fake that can be
+// programmed to fail, and a conformance suite every adapter runs to prove it behaves like the others.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// ErrInjected is a ready-made error for faults.
+var ErrInjected = errors.New("storagetest: injected fault")
+
+// Op names a Storage method.
+type Op string
+
+const (
+	OpAddPoints  Op = "AddPoints"
+	OpAwardBadge Op = "AwardBadge"
+	OpGetState   Op = "GetState"
+	// OpGetStateMany counts batch loads; their users are checked against OpGetState faults one by
+	// one, so a fault for one user fails only that user of a batch.
+	OpGetStateMany Op = "GetStateMany"
+	OpSetLevel     Op = "SetLevel"
+)
+
+// Fault describes how calls to one operation misbehave.
+type Fault struct {
+	// Err is returned by the operation instead of running it.
+	Err error
+	// Partial applies the write before returning Err, like a write whose acknowledgement was lost.
+	Partial bool
+	// Latency delays the operation. If ctx is done first the operation returns ctx.Err() without running.
+	Latency time.Duration
+	// Cancel, if set, is called when the fault fires, e.g. to cancel the caller's context mid-sequence.
+	// The operation then fails with the context's error unless Err is set.
+	Cancel context.CancelFunc
+	// User, if set, restricts the fault to calls for that user.
+	User core.UserID
+	// Skip lets this many matching calls through before the fault fires.
+	Skip int
+	// Times limits how often the fault fires; 0 means every matching call after Skip.
+	Times int
+}
+
+type fault struct {
+	Fault
+	seen, fired int
+}
+
+// Store is an in-memory engine.Storage with fault injection. The zero value is not usable; call New.
+// Like the real adapters, every operation fails with ctx.Err() once ctx is done.
+type Store struct {
+	mu     sync.Mutex
+	users  map[core.UserID]core.UserState
+	faults map[Op][]*fault
+	calls  map[Op]int
+}
+
+// New returns an empty Store without faults.
+func New() *Store {
+	return &Store{users: map[core.UserID]core.UserState{}, faults: map[Op][]*fault{}, calls: map[Op]int{}}
+}
+
+// Inject adds a fault for op. Faults for the same op are checked in the order they were added;
+// the first one that fires wins.
+func (s *Store) Inject(op Op, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[op] = append(s.faults[op], &fault{Fault: f})
+}
+
+// Reset removes all faults and call counts, keeping stored state.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[Op][]*fault{}
+	s.calls = map[Op]int{}
+}
+
+// Calls reports how many times op was called, including calls that failed.
+func (s *Store) Calls(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[op]
+}
+
+// before counts the call and runs any fault that fires. It returns whether the operation should run
+// and the error to return afterwards.
+func (s *Store) before(ctx context.Context, op Op, user core.UserID) (run bool, err error) {
+	if err := ctx.Err(); err != nil {
+		s.mu.Lock()
+		s.calls[op]++
+		s.mu.Unlock()
+		return false, err
+	}
+	s.mu.Lock()
+	s.calls[op]++
+	var fired *fault
+	for _, f := range s.faults[op] {
+		if f.User != "" && f.User != user {
+			continue
+		}
+		f.seen++
+		if f.seen <= f.Skip || (f.Times > 0 && f.fired >= f.Times) {
+			continue
+		}
+		f.fired++
+		fired = f
+		break
+	}
+	s.mu.Unlock()
+	if fired == nil {
+		return true, nil
+	}
+
+	if fired.Latency > 0 {
+		timer := time.NewTimer(fired.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	err = fired.Err
+	if fired.Cancel != nil {
+		fired.Cancel()
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	if err == nil {
+		return true, nil
+	}
+	return fired.Partial, err
+}
+
+// get returns the stored state for user, creating it on first use; callers hold s.mu
+func (s *Store) get(user core.UserID) core.UserState {
+	if st, ok := s.users[user]; ok {
+		return st
+	}
+	st := core.UserState{UserID: user, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{}, Updated: core.Now()}
+	s.users[user] = st
+	return st
+}
+
+func (s *Store) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+	run, err := s.before(ctx, OpAddPoints, user)
+	if !run {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	next, addErr := core.AddSafe(st.Points[metric], delta)
+	if addErr != nil {
+		return 0, addErr
+	}
+	st.Points[metric] = next
+	st.Updated = core.Now()
+	s.users[user] = st
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (s *Store) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+	_, err := s.TryAwardBadge(ctx, user, badge)
+	return err
+}
+
+// TryAwardBadge awards badge and reports whether user did not hold it yet. Faults are injected
+// under OpAwardBadge.
+func (s *Store) TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+	run, err := s.before(ctx, OpAwardBadge, user)
+	if !run {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	if _, ok := st.Badges[badge]; ok {
+		return false, err
+	}
+	st.Badges[badge] = struct{}{}
+	st.Updated = core.Now()
+	s.users[user] = st
+	return err == nil, err
+}
+
+func (s *Store) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+	run, err := s.before(ctx, OpGetState, user)
+	if !run || err != nil {
+		return core.UserState{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(user).Clone(), nil
+}
+
+// GetStateMany loads several users, failing the ones an OpGetState fault fires for; see
+// engine.StateBatchGetter.
+func (s *Store) GetStateMany(ctx context.Context, users []core.UserID) (map[core.UserID]core.UserState, error) {
+	s.mu.Lock()
+	s.calls[OpGetStateMany]++
+	s.mu.Unlock()
+	states := make(map[core.UserID]core.UserState, len(users))
+	failed := map[core.UserID]error{}
+	for _, u := range users {
+		run, err := s.before(ctx, OpGetState, u)
+		if !run || err != nil {
+			failed[u] = err
+			continue
+		}
+		s.mu.Lock()
+		states[u] = s.get(u).Clone()
+		s.mu.Unlock()
+	}
+	return states, core.NewBatchError(failed)
+}
+
+func (s *Store) SetLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) error {
+	run, err := s.before(ctx, OpSetLevel, user)
+	if !run {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.get(user)
+	st.Levels[metric] = level
+	st.Updated = core.Now()
+	s.users[user] = st
+	return err
+}
+
+// ReplaceState overwrites the user's points, badges and levels with state. It has no Op of its own;
+// cancelled contexts still fail it.
+func (s *Store) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := state.Clone()
+	next.UserID = user
+	next.Updated = core.Now()
+	s.users[user] = next
+	return nil
+}
+
+// Exists reports whether the user has any points, badges or levels.
+func (s *Store) Exists(ctx context.Context, user core.UserID) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.users[user]
+	return ok && (len(st.Points) > 0 || len(st.Badges) > 0 || len(st.Levels) > 0), nil
+}
+
+// EachUser calls fn for every stored user.
+func (s *Store) EachUser(ctx context.Context, fn func(core.UserID) error) error {
+	s.mu.Lock()
+	users := make([]core.UserID, 0, len(s.users))
+	for u := range s.users {
+		users = append(users, u)
+	}
+	s.mu.Unlock()
+	for _, u := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	_ engine.Storage          = (*Store)(nil)
+	_ engine.UserLister       = (*Store)(nil)
+	_ engine.UserExister      = (*Store)(nil)
+	_ engine.StateReplacer    = (*Store)(nil)
+	_ engine.StateBatchGetter = (*Store)(nil)
+)
@@ -0,0 +1,117 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+func TestConformance(t *testing.T) {
+	RunConformance(t, func(*testing.T) engine.Storage { return New() })
+}
+
+func TestFaultSkipAndTimes(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+	s.Inject(OpAddPoints, Fault{Err: ErrInjected, Skip: 1, Times: 1})
+
+	if _, err := s.AddPoints(ctx, "u", core.MetricXP, 1); err != nil {
+		t.Fatalf("first call should be skipped: %v", err)
+	}
+	if _, err := s.AddPoints(ctx, "u", core.MetricXP, 1); !errors.Is(err, ErrInjected) {
+		t.Fatalf("second call should fail, got %v", err)
+	}
+	if total, err := s.AddPoints(ctx, "u", core.MetricXP, 1); err != nil || total != 2 {
+		t.Fatalf("fault should fire once: total=%d err=%v", total, err)
+	}
+	if n := s.Calls(OpAddPoints); n != 3 {
+		t.Fatalf("Calls = %d, want 3", n)
+	}
+}
+
+func TestFaultPartialAndUser(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+	s.Inject(OpAwardBadge, Fault{Err: ErrInjected, Partial: true, User: "bob"})
+
+	if err := s.AwardBadge(ctx, "alice", "b"); err != nil {
+		t.Fatalf("fault is scoped to bob: %v", err)
+	}
+	if err := s.AwardBadge(ctx, "bob", "b"); !errors.Is(err, ErrInjected) {
+		t.Fatalf("want injected error, got %v", err)
+	}
+	st, _ := s.GetState(ctx, "bob")
+	if _, ok := st.Badges["b"]; !ok {
+		t.Fatal("partial fault should still apply the write")
+	}
+}
+
+func TestFaultLatencyHonoursContext(t *testing.T) {
+	s := New()
+	s.Inject(OpGetState, Fault{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetState(ctx, "u"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+}
+
+func TestFaultCancelStopsService(t *testing.T) {
+	s := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Inject(OpAddPoints, Fault{Cancel: cancel})
+	svc := engine.NewGamifyService(s, engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+
+	if _, err := svc.AddPoints(ctx, "u", core.MetricXP, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if _, err := svc.AddPoints(ctx, "u", core.MetricXP, 10); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled context must fail every call, got %v", err)
+	}
+	s.Reset()
+	st, _ := s.GetState(context.Background(), "u")
+	if st.Points[core.MetricXP] != 0 {
+		t.Fatalf("no write should have happened, got %v", st.Points)
+	}
+}
+
+func TestGetStateManyPartialFailure(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+	svc := engine.NewGamifyService(s, engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	users := []core.UserID{"alice", "bob", "carol", "dave"}
+	for i, u := range users {
+		if _, err := svc.AddPoints(ctx, u, core.MetricXP, int64(i+1)*10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Inject(OpGetState, Fault{Err: ErrInjected, User: "bob"})
+	s.Inject(OpGetState, Fault{Err: core.ErrBackendBusy, User: "dave"})
+
+	states, err := svc.GetStateMany(ctx, append(users, "alice"))
+	var batch *core.BatchError
+	if !errors.As(err, &batch) {
+		t.Fatalf("want a *core.BatchError, got %v", err)
+	}
+	if failed := batch.Users(); len(failed) != 2 || failed[0] != "bob" || failed[1] != "dave" {
+		t.Fatalf("failed users = %v, want [bob dave]", failed)
+	}
+	if !errors.Is(err, ErrInjected) || !errors.Is(batch.Errors["dave"], core.ErrBackendBusy) {
+		t.Fatalf("per-user errors not preserved: %v", err)
+	}
+	if len(states) != 2 || states["alice"].Points[core.MetricXP] != 10 || states["carol"].Points[core.MetricXP] != 30 {
+		t.Fatalf("want the states of alice and carol, got %v", states)
+	}
+	if n := s.Calls(OpGetStateMany); n != 1 {
+		t.Fatalf("GetStateMany calls = %d, want one batch", n)
+	}
+
+	s.Reset()
+	if states, err := svc.GetStateMany(ctx, users); err != nil || len(states) != 4 {
+		t.Fatalf("without faults: %d states, %v", len(states), err)
+	}
+}
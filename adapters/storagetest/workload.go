@@ -0,0 +1,197 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// Workload describes a mixed read/write load for RunWorkload. Reads are GetState calls; writes
+// are AddPoints calls with every tenth write an AwardBadge instead.
+type Workload struct {
+	// Users is how many distinct users the operations are spread over (default 100).
+	Users int
+	// Concurrency is the number of parallel workers (default 8).
+	Concurrency int
+	// ReadRatio is the fraction of operations that are reads, from 0 to 1.
+	ReadRatio float64
+	// Ops is the total number of operations across all workers (default 10000).
+	Ops int
+	// Metric is the metric written to (default core.MetricXP).
+	Metric core.Metric
+	// Prefix starts every user ID (default "bench-"), so runs can be told apart and cleaned up.
+	Prefix string
+	// Seed makes the operation mix reproducible.
+	Seed int64
+}
+
+// LatencyStats summarizes the latencies of one kind of operation.
+type LatencyStats struct {
+	Count  int           `json:"count"`
+	Errors int           `json:"errors"`
+	P50    time.Duration `json:"p50_ns"`
+	P90    time.Duration `json:"p90_ns"`
+	P99    time.Duration `json:"p99_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+// WorkloadReport is the outcome of RunWorkload, shaped so reports of different adapters can be diffed.
+type WorkloadReport struct {
+	Users       int           `json:"users"`
+	Concurrency int           `json:"concurrency"`
+	ReadRatio   float64       `json:"read_ratio"`
+	Ops         int           `json:"ops"`
+	Errors      int           `json:"errors"`
+	Elapsed     time.Duration `json:"elapsed_ns"`
+	// Throughput is completed operations per second.
+	Throughput float64      `json:"throughput_ops"`
+	Reads      LatencyStats `json:"reads"`
+	Writes     LatencyStats `json:"writes"`
+	// FirstError is the first failure seen, to tell a misconfigured store from occasional errors.
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// RunWorkload runs w against s and reports throughput, latency percentiles and error counts.
+// Errors of individual operations are counted, not returned; an error is only returned for an
+// invalid workload or when ctx ends early.
+func RunWorkload(ctx context.Context, s engine.Storage, w Workload) (WorkloadReport, error) {
+	w = w.withDefaults()
+	if w.ReadRatio < 0 || w.ReadRatio > 1 {
+		return WorkloadReport{}, fmt.Errorf("read ratio %v not within [0, 1]", w.ReadRatio)
+	}
+
+	type sample struct {
+		took time.Duration
+		read bool
+		err  error
+	}
+	results := make([][]sample, w.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := 0; worker < w.Concurrency; worker++ {
+		ops := w.Ops / w.Concurrency
+		if worker < w.Ops%w.Concurrency {
+			ops++
+		}
+		wg.Add(1)
+		go func(worker, ops int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(w.Seed + int64(worker)))
+			out := make([]sample, 0, ops)
+			for i := 0; i < ops && ctx.Err() == nil; i++ {
+				user := core.UserID(fmt.Sprintf("%s%d", w.Prefix, rng.Intn(w.Users)))
+				read := rng.Float64() < w.ReadRatio
+				began := time.Now()
+				var err error
+				switch {
+				case read:
+					_, err = s.GetState(ctx, user)
+				case i%10 == 9:
+					err = s.AwardBadge(ctx, user, core.Badge(fmt.Sprintf("bench-%d", rng.Intn(20))))
+				default:
+					_, err = s.AddPoints(ctx, user, w.Metric, 1+rng.Int63n(10))
+				}
+				out = append(out, sample{took: time.Since(began), read: read, err: err})
+			}
+			results[worker] = out
+		}(worker, ops)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := WorkloadReport{Users: w.Users, Concurrency: w.Concurrency, ReadRatio: w.ReadRatio, Elapsed: elapsed}
+	var reads, writes []time.Duration
+	for _, worker := range results {
+		for _, r := range worker {
+			stats := &report.Writes
+			if r.read {
+				stats = &report.Reads
+				reads = append(reads, r.took)
+			} else {
+				writes = append(writes, r.took)
+			}
+			stats.Count++
+			report.Ops++
+			if r.err != nil {
+				stats.Errors++
+				report.Errors++
+				if report.FirstError == "" {
+					report.FirstError = r.err.Error()
+				}
+			}
+		}
+	}
+	report.Reads.setPercentiles(reads)
+	report.Writes.setPercentiles(writes)
+	if elapsed > 0 {
+		report.Throughput = float64(report.Ops) / elapsed.Seconds()
+	}
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// ErrNoWorkloadPrefix is returned by CleanupWorkload for a workload without a Prefix.
+var ErrNoWorkloadPrefix = errors.New("storagetest: cleaning up a workload needs an explicit user prefix")
+
+// CleanupWorkload removes the users RunWorkload wrote to. It needs a storage implementing
+// engine.StateReplacer (all built-in adapters do) and replaces each user with an empty state.
+// Since that wipes every user the workload names, w.Prefix must be set explicitly to one reserved
+// for the workload; the default prefix is refused with ErrNoWorkloadPrefix.
+func CleanupWorkload(ctx context.Context, s engine.Storage, w Workload) error {
+	if w.Prefix == "" {
+		return ErrNoWorkloadPrefix
+	}
+	w = w.withDefaults()
+	r, ok := s.(engine.StateReplacer)
+	if !ok {
+		return engine.ErrReplaceUnsupported
+	}
+	var errs []error
+	for i := 0; i < w.Users; i++ {
+		user := core.UserID(fmt.Sprintf("%s%d", w.Prefix, i))
+		if err := r.ReplaceState(ctx, user, core.UserState{UserID: user}); err != nil {
+			errs = append(errs, fmt.Errorf("user %s: %w", user, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w Workload) withDefaults() Workload {
+	if w.Users <= 0 {
+		w.Users = 100
+	}
+	if w.Concurrency <= 0 {
+		w.Concurrency = 8
+	}
+	if w.Ops <= 0 {
+		w.Ops = 10000
+	}
+	if w.Metric == "" {
+		w.Metric = core.MetricXP
+	}
+	if w.Prefix == "" {
+		w.Prefix = "bench-"
+	}
+	return w
+}
+
+// setPercentiles fills the latency percentiles from unsorted samples
+func (l *LatencyStats) setPercentiles(samples []time.Duration) {
+	if len(samples) == 0 {
+		return
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	l.P50, l.P90, l.P99, l.Max = at(0.50), at(0.90), at(0.99), samples[len(samples)-1]
+}
@@ -0,0 +1,51 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunWorkload(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	s.Inject(OpGetState, Fault{Err: ErrInjected, Times: 3})
+	w := Workload{Users: 10, Concurrency: 4, ReadRatio: 0.5, Ops: 1001, Seed: 1}
+
+	report, err := RunWorkload(ctx, s, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Ops != 1001 || report.Reads.Count+report.Writes.Count != 1001 {
+		t.Fatalf("want 1001 ops, got %+v", report)
+	}
+	if report.Reads.Count == 0 || report.Writes.Count == 0 {
+		t.Fatalf("want a mix of reads and writes, got %d/%d", report.Reads.Count, report.Writes.Count)
+	}
+	if report.Errors != 3 || report.Reads.Errors != 3 || report.FirstError == "" {
+		t.Fatalf("want 3 read errors, got %+v", report)
+	}
+	if report.Writes.P50 > report.Writes.P99 || report.Writes.P99 > report.Writes.Max || report.Throughput <= 0 {
+		t.Fatalf("inconsistent stats %+v", report.Writes)
+	}
+	if got := s.Calls(OpAddPoints) + s.Calls(OpAwardBadge); got != report.Writes.Count {
+		t.Fatalf("store saw %d writes, report says %d", got, report.Writes.Count)
+	}
+
+	if err := CleanupWorkload(ctx, s, w); !errors.Is(err, ErrNoWorkloadPrefix) {
+		t.Fatalf("cleanup without a prefix: got %v, want ErrNoWorkloadPrefix", err)
+	}
+	if ok, _ := s.Exists(ctx, "bench-0"); !ok {
+		t.Fatal("a refused cleanup must leave the users alone")
+	}
+	w.Prefix = "bench-"
+	if err := CleanupWorkload(ctx, s, w); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Exists(ctx, "bench-0"); ok {
+		t.Fatal("cleanup should empty the workload users")
+	}
+	if _, err := RunWorkload(ctx, s, Workload{ReadRatio: 2}); err == nil {
+		t.Fatal("want error for read ratio above 1")
+	}
+}
@@ -1,30 +1,314 @@
 package websocket
 
 import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
     "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
     "time"
 
     gorillaws "github.com/gorilla/websocket"
+    "gamifykit/core"
     "gamifykit/realtime"
 )
 
+// StateFunc loads a user's current state; it backs patch streams.
+type StateFunc func(ctx context.Context, user core.UserID) (core.UserState, error)
+
+// Options configures a WebSocket handler.
+type Options struct {
+    // State enables ?stream=patches. Without it only event streams are available.
+    State StateFunc
+    // AllowedOrigins lists cross-site origins allowed to connect: "*" for any, exact origins
+    // ("https://app.example.com") or hosts ("app.example.com"), and wildcard subdomains
+    // ("*.example.com"). Same-origin requests and clients sending no Origin header are always
+    // allowed; other upgrades are rejected with 403.
+    AllowedOrigins []string
+    // Origins, if set, is consulted on every handshake and takes precedence over AllowedOrigins,
+    // so the allowlist can be changed at runtime.
+    Origins func() []string
+    // MaxMessageSize caps client frames in bytes (DefaultMaxMessageSize when zero). A larger
+    // frame closes the connection with status 1009 (message too big).
+    MaxMessageSize int64
+    // MaxInvalidMessages is how many malformed client messages a connection may send before it is
+    // closed with status 1008 (policy violation); DefaultMaxInvalidMessages when zero.
+    MaxInvalidMessages int
+    // Compression negotiates permessage-deflate (RFC 7692) with clients that offer it during the
+    // handshake; other clients get uncompressed frames. Frames are compressed one at a time, so
+    // small events gain little: only frames of at least CompressionThreshold bytes
+    // (DefaultCompressionThreshold when zero) are compressed.
+    Compression bool
+    // CompressionLevel is the flate level from 1 (fastest) to 9 (smallest); flate's default when zero.
+    CompressionLevel     int
+    CompressionThreshold int
+}
+
+const (
+    // DefaultMaxMessageSize is the client frame limit used when Options.MaxMessageSize is zero.
+    DefaultMaxMessageSize = 4096
+    // DefaultMaxInvalidMessages is the limit used when Options.MaxInvalidMessages is zero.
+    DefaultMaxInvalidMessages = 5
+    // DefaultCompressionThreshold is the smallest frame compressed when Options.CompressionThreshold
+    // is zero. Single events rarely reach it; patch snapshots and events with metadata do.
+    DefaultCompressionThreshold = 256
+)
+
+// clientMessage is a control message from the client: a JSON text frame such as {"type":"ping"}.
+// "ping" is answered with a "pong" echoing ID. "ack" acknowledges the event whose ID it carries on
+// connections with acknowledged delivery and gets no reply. Other well-formed types are ignored.
+type clientMessage struct {
+    Type string `json:"type"`
+    ID   string `json:"id,omitempty"`
+}
+
+// controlMessage is a server reply to a client message, always sent as a JSON text frame.
+type controlMessage struct {
+    Type    string `json:"type"`
+    ID      string `json:"id,omitempty"`
+    Code    string `json:"code,omitempty"`
+    Message string `json:"message,omitempty"`
+}
+
+// parseClientMessage validates a client frame: a JSON object with a non-empty "type" of at most
+// 64 characters, an optional string "id" and no other fields.
+func parseClientMessage(opcode int, data []byte) (clientMessage, *controlMessage) {
+    if opcode != gorillaws.TextMessage {
+        return clientMessage{}, &controlMessage{Type: "error", Code: "unsupported_frame", Message: "control messages must be JSON text frames"}
+    }
+    var m clientMessage
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&m); err != nil || dec.More() {
+        return m, &controlMessage{Type: "error", ID: m.ID, Code: "invalid_message", Message: "message must be a JSON object with a \"type\" field"}
+    }
+    if m.Type == "" || len(m.Type) > 64 || len(m.ID) > 64 {
+        return m, &controlMessage{Type: "error", ID: m.ID, Code: "invalid_message", Message: "\"type\" must be 1-64 characters and \"id\" at most 64"}
+    }
+    if m.Type == "ack" && m.ID == "" {
+        return m, &controlMessage{Type: "error", Code: "invalid_message", Message: "\"ack\" requires the \"id\" of the event acknowledged"}
+    }
+    return m, nil
+}
+
+// stateMessage is a frame of a patch stream: one "snapshot" on connect, then a "patch" per change.
+type stateMessage struct {
+    Type  string           `json:"type"`
+    State *core.UserState  `json:"state,omitempty"`
+    Patch *core.StatePatch `json:"patch,omitempty"`
+}
+
 // Handler returns an http.Handler that upgrades to WebSocket and streams events from the hub.
-func Handler(hub *realtime.Hub) http.Handler {
-    upgrader := gorillaws.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+// It accepts connections from any origin; use NewHandler with Options.AllowedOrigins to restrict them.
+// Each connection picks its own codec: a negotiated subprotocol ("json", "compact", "msgpack",
+// "protobuf") wins, then the ?format= query parameter, then the hub's default codec.
+// A ?user= query parameter limits the stream to that user's events. A namespace in the request
+// context (see core.WithNamespace) limits it to the events published in that namespace.
+//
+// With ?acks=true the connection opts into acknowledged delivery: every event flagged with
+// core.RequireAck (its metadata has "ack": true) must be acknowledged with {"type":"ack","id":<event
+// id>}, or it is sent again with the same ID under the hub's realtime.AckPolicy. Clients should
+// therefore handle such events idempotently. Patch streams do not support acknowledgements.
+//
+// Client frames are limited to Options.MaxMessageSize and must be control messages (see
+// clientMessage); malformed ones are answered with an "error" frame, and a connection that keeps
+// sending them is closed with a policy violation.
+func Handler(hub *realtime.Hub) http.Handler { return NewHandler(hub, Options{AllowedOrigins: []string{"*"}}) }
+
+// NewHandler is Handler with options. With opts.State set, clients may connect with
+// ?user=<id>&stream=patches to receive a JSON snapshot of the user's state followed by
+// a core.StatePatch after every change instead of raw events.
+func NewHandler(hub *realtime.Hub, opts Options) http.Handler {
+    if opts.MaxMessageSize <= 0 { opts.MaxMessageSize = DefaultMaxMessageSize }
+    if opts.MaxInvalidMessages <= 0 { opts.MaxInvalidMessages = DefaultMaxInvalidMessages }
+    if opts.CompressionThreshold <= 0 { opts.CompressionThreshold = DefaultCompressionThreshold }
+    if opts.CompressionLevel < 0 || opts.CompressionLevel > 9 { panic("websocket: CompressionLevel must be between 1 and 9") }
+    upgrader := gorillaws.Upgrader{
+        CheckOrigin:       func(r *http.Request) bool {
+            if opts.Origins != nil { return originAllowed(opts.Origins(), r) }
+            return originAllowed(opts.AllowedOrigins, r)
+        },
+        Subprotocols:      realtime.CodecNames(),
+        EnableCompression: opts.Compression,
+    }
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        user := core.UserID(r.URL.Query().Get("user"))
+        patches := r.URL.Query().Get("stream") == "patches"
+        if patches && (opts.State == nil || user == "") {
+            http.Error(w, "patch streams require a state source and a ?user= parameter", http.StatusBadRequest)
+            return
+        }
+        var acks bool
+        if v := r.URL.Query().Get("acks"); v != "" {
+            var err error
+            if acks, err = strconv.ParseBool(v); err != nil {
+                http.Error(w, "invalid acks parameter", http.StatusBadRequest)
+                return
+            }
+        }
+        if acks && patches {
+            http.Error(w, "patch streams do not support acknowledgements", http.StatusBadRequest)
+            return
+        }
+        namespace, _ := core.NamespaceFromContext(r.Context())
+        codec := hub.Codec()
+        if format := r.URL.Query().Get("format"); format != "" {
+            c, ok := realtime.CodecByName(format)
+            if !ok {
+                http.Error(w, "unsupported format", http.StatusBadRequest)
+                return
+            }
+            codec = c
+        }
         conn, err := upgrader.Upgrade(w, r, nil)
         if err != nil { return }
         defer conn.Close()
-        id, ch := hub.Subscribe(256)
+        if opts.Compression && opts.CompressionLevel != 0 {
+            _ = conn.SetCompressionLevel(opts.CompressionLevel)
+        }
+        if c, ok := realtime.CodecByName(conn.Subprotocol()); ok {
+            codec = c
+        }
+        if patches {
+            // patch streams are always JSON text frames
+            codec = realtime.JSONCodec
+        }
+        id, ch := hub.SubscribeConn(256, realtime.ConnOptions{
+            User:       user,
+            Namespace:  namespace,
+            Codec:      codec.Name(),
+            RemoteAddr: r.RemoteAddr,
+            Acks:       acks,
+        })
         defer hub.Unsubscribe(id)
 
-        _ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-        for ev := range ch {
-            if err := conn.WriteMessage(gorillaws.TextMessage, realtime.MarshalJSON(ev)); err != nil {
+        // gorilla allows one concurrent writer; the read loop replies to control messages
+        var writeMu sync.Mutex
+        write := func(payload []byte, opcode int) bool {
+            writeMu.Lock()
+            defer writeMu.Unlock()
+            _ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+            // a no-op unless the client negotiated permessage-deflate
+            conn.EnableWriteCompression(len(payload) >= opts.CompressionThreshold)
+            return conn.WriteMessage(opcode, payload) == nil
+        }
+        reply := func(m controlMessage) bool {
+            b, _ := json.Marshal(m)
+            return write(b, gorillaws.TextMessage)
+        }
+
+        // read until the client goes away so disconnects deregister promptly, not on the next write
+        conn.SetReadLimit(opts.MaxMessageSize)
+        closed := make(chan struct{})
+        go func() {
+            defer close(closed)
+            invalid := 0
+            for {
+                opcode, data, err := conn.ReadMessage()
+                if err == gorillaws.ErrReadLimit {
+                    // gorilla has already sent the 1009 close frame
+                    slog.Warn("websocket client message too large", "remote", r.RemoteAddr, "limit", opts.MaxMessageSize)
+                    return
+                }
+                if err != nil { return }
+                msg, bad := parseClientMessage(opcode, data)
+                if bad != nil {
+                    invalid++
+                    if !reply(*bad) { return }
+                    if invalid >= opts.MaxInvalidMessages {
+                        slog.Warn("closing websocket after repeated invalid messages", "remote", r.RemoteAddr, "invalid", invalid)
+                        deadline := time.Now().Add(time.Second)
+                        _ = conn.WriteControl(gorillaws.CloseMessage, gorillaws.FormatCloseMessage(gorillaws.ClosePolicyViolation, "too many invalid messages"), deadline)
+                        return
+                    }
+                    continue
+                }
+                switch msg.Type {
+                case "ping":
+                    if !reply(controlMessage{Type: "pong", ID: msg.ID}) { return }
+                case "ack":
+                    // late or repeated acknowledgements are harmless
+                    hub.Ack(id, msg.ID)
+                default:
+                    slog.Warn("ignoring unknown websocket message type", "remote", r.RemoteAddr, "type", msg.Type)
+                }
+            }
+        }()
+
+        // subscribed before the snapshot is taken, so no change can fall between the two
+        var last core.UserState
+        if patches {
+            last, err = opts.State(r.Context(), user)
+            if err != nil { return }
+            if !write(marshalState(stateMessage{Type: "snapshot", State: &last}), gorillaws.TextMessage) { return }
+        }
+
+        // overdue acknowledgements are checked a few times per timeout
+        var redeliver <-chan time.Time
+        if acks {
+            ticker := time.NewTicker(max(hub.AckPolicy().Timeout/4, 10*time.Millisecond))
+            defer ticker.Stop()
+            redeliver = ticker.C
+        }
+
+        for {
+            select {
+            case <-closed:
                 return
+            case <-redeliver:
+                for _, ev := range hub.DueRedeliveries(id) {
+                    if !write(codec.Marshal(ev)) { return }
+                }
+            case ev, ok := <-ch:
+                if !ok { return }
+                if !patches {
+                    // awaited before the write so a quick acknowledgement cannot arrive first
+                    hub.AwaitAck(id, ev)
+                    if !write(codec.Marshal(ev)) { return }
+                    hub.MarkSent(id)
+                    continue
+                }
+                next, err := opts.State(r.Context(), user)
+                if err != nil { return }
+                patch := core.DiffState(last, next)
+                last = next
+                if patch.Empty() { continue }
+                if !write(marshalState(stateMessage{Type: "patch", Patch: &patch}), gorillaws.TextMessage) { return }
+                hub.MarkSent(id)
             }
         }
     })
 }
 
+// originAllowed reports whether r may upgrade under the allowlist; see Options.AllowedOrigins.
+func originAllowed(allowed []string, r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" { return true }
+    u, err := url.Parse(origin)
+    if err != nil || u.Host == "" { return false }
+    if strings.EqualFold(u.Host, r.Host) { return true }
+    host := strings.ToLower(u.Hostname())
+    for _, a := range allowed {
+        a = strings.ToLower(strings.TrimSuffix(a, "/"))
+        switch {
+        case a == "*":
+            return true
+        case strings.HasPrefix(a, "*."):
+            if strings.HasSuffix(host, a[1:]) { return true }
+        case strings.Contains(a, "://"):
+            if a == strings.ToLower(u.Scheme+"://"+u.Host) { return true }
+        case a == host || a == strings.ToLower(u.Host):
+            return true
+        }
+    }
+    return false
+}
 
+func marshalState(m stateMessage) []byte {
+    b, _ := json.Marshal(m)
+    return b
+}
@@ -0,0 +1,271 @@
+package websocket
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    gorillaws "github.com/gorilla/websocket"
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/realtime"
+)
+
+func TestHandlerPerConnectionCodec(t *testing.T) {
+    hub := realtime.NewHub()
+    srv := httptest.NewServer(Handler(hub))
+    defer srv.Close()
+    url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+    browser, _, err := gorillaws.DefaultDialer.Dial(url, nil)
+    if err != nil { t.Fatal(err) }
+    defer browser.Close()
+    native, _, err := (&gorillaws.Dialer{Subprotocols: []string{"msgpack"}}).Dial(url, nil)
+    if err != nil { t.Fatal(err) }
+    defer native.Close()
+    query, _, err := gorillaws.DefaultDialer.Dial(url+"?format=protobuf", nil)
+    if err != nil { t.Fatal(err) }
+    defer query.Close()
+
+    // give the server side a moment to subscribe all three connections
+    time.Sleep(50 * time.Millisecond)
+    ev := core.NewBadgeAwarded("alice", "starter")
+    hub.Broadcast(context.Background(), ev)
+
+    for name, tc := range map[string]struct {
+        conn *gorillaws.Conn
+        op   int
+    }{"json": {browser, gorillaws.TextMessage}, "msgpack": {native, gorillaws.BinaryMessage}, "protobuf": {query, gorillaws.BinaryMessage}} {
+        _ = tc.conn.SetReadDeadline(time.Now().Add(time.Second))
+        op, payload, err := tc.conn.ReadMessage()
+        if err != nil { t.Fatalf("%s: %v", name, err) }
+        if op != tc.op { t.Fatalf("%s: want opcode %d got %d", name, tc.op, op) }
+        c, _ := realtime.CodecByName(name)
+        if want, _ := c.Marshal(ev); string(payload) != string(want) { t.Fatalf("%s: payload mismatch", name) }
+    }
+}
+
+func TestHandlerCompression(t *testing.T) {
+    hub := realtime.NewHub()
+    srv := httptest.NewServer(NewHandler(hub, Options{AllowedOrigins: []string{"*"}, Compression: true, CompressionThreshold: 1}))
+    defer srv.Close()
+    url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+    deflate, resp, err := (&gorillaws.Dialer{EnableCompression: true, Subprotocols: []string{"compact"}}).Dial(url, nil)
+    if err != nil { t.Fatal(err) }
+    defer deflate.Close()
+    if ext := resp.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(ext, "permessage-deflate") { t.Fatalf("want permessage-deflate negotiated, got %q", ext) }
+    plain, resp, err := gorillaws.DefaultDialer.Dial(url, nil)
+    if err != nil { t.Fatal(err) }
+    defer plain.Close()
+    if ext := resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" { t.Fatalf("want no extension without an offer, got %q", ext) }
+
+    time.Sleep(50 * time.Millisecond)
+    ev := core.NewPointsAdded("alice", core.MetricXP, 5, 5)
+    hub.Broadcast(context.Background(), ev)
+    for name, conn := range map[string]*gorillaws.Conn{"compact": deflate, "json": plain} {
+        _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+        _, payload, err := conn.ReadMessage()
+        if err != nil { t.Fatalf("%s: %v", name, err) }
+        c, _ := realtime.CodecByName(name)
+        if want, _ := c.Marshal(ev); string(payload) != string(want) { t.Fatalf("%s: payload mismatch: %s", name, payload) }
+    }
+}
+
+func TestHandlerRejectsUnknownFormat(t *testing.T) {
+    srv := httptest.NewServer(Handler(realtime.NewHub()))
+    defer srv.Close()
+    _, resp, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?format=xml", nil)
+    if err == nil || resp == nil || resp.StatusCode != 400 { t.Fatalf("want 400, got %v %v", resp, err) }
+}
+
+func TestHandlerDeregistersOnDisconnect(t *testing.T) {
+    hub := realtime.NewHub()
+    srv := httptest.NewServer(Handler(hub))
+    defer srv.Close()
+
+    conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?user=alice", nil)
+    if err != nil { t.Fatal(err) }
+    waitFor(t, func() bool { return hub.ClientCount() == 1 })
+    if c := hub.Connections()[0]; c.User != "alice" || c.Codec != "json" { t.Fatalf("unexpected metadata %#v", c) }
+
+    // no events are sent, so only the read loop can notice the disconnect
+    conn.Close()
+    waitFor(t, func() bool { return hub.ClientCount() == 0 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for !cond() {
+        if time.Now().After(deadline) { t.Fatal("condition not met in time") }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestHandlerPatchStream(t *testing.T) {
+    hub := realtime.NewHub()
+    store := mem.New()
+    ctx := context.Background()
+    _, _ = store.AddPoints(ctx, "alice", core.MetricXP, 10)
+    srv := httptest.NewServer(NewHandler(hub, Options{State: store.GetState}))
+    defer srv.Close()
+    url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+    conn, _, err := gorillaws.DefaultDialer.Dial(url+"?user=alice&stream=patches", nil)
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+
+    var snapshot struct{ Type string; State core.UserState }
+    _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+    if err := conn.ReadJSON(&snapshot); err != nil { t.Fatal(err) }
+    if snapshot.Type != "snapshot" || snapshot.State.Points[core.MetricXP] != 10 { t.Fatalf("unexpected snapshot %#v", snapshot) }
+
+    total, _ := store.AddPoints(ctx, "alice", core.MetricXP, 5)
+    _ = store.AwardBadge(ctx, "alice", "first")
+    hub.Broadcast(ctx, core.NewPointsAdded("alice", core.MetricXP, 5, total))
+
+    var msg struct{ Type string; Patch core.StatePatch }
+    if err := conn.ReadJSON(&msg); err != nil { t.Fatal(err) }
+    if msg.Type != "patch" { t.Fatalf("want patch, got %q", msg.Type) }
+    got := core.ApplyPatch(snapshot.State, msg.Patch)
+    want, _ := store.GetState(ctx, "alice")
+    if got.Points[core.MetricXP] != want.Points[core.MetricXP] || len(got.Badges) != 1 { t.Fatalf("patched state %#v, want %#v", got, want) }
+    if len(msg.Patch.Levels) != 0 || len(msg.Patch.RemovedPoints) != 0 { t.Fatalf("patch not minimal: %#v", msg.Patch) }
+}
+
+func TestHandlerPatchStreamRequiresUser(t *testing.T) {
+    srv := httptest.NewServer(NewHandler(realtime.NewHub(), Options{State: mem.New().GetState}))
+    defer srv.Close()
+    _, resp, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?stream=patches", nil)
+    if err == nil || resp == nil || resp.StatusCode != 400 { t.Fatalf("want 400, got %v %v", resp, err) }
+}
+
+func TestHandlerOriginCheck(t *testing.T) {
+    srv := httptest.NewServer(NewHandler(realtime.NewHub(), Options{AllowedOrigins: []string{"https://app.example.com", "*.trusted.io"}}))
+    defer srv.Close()
+    url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+    cases := []struct {
+        origin string
+        ok     bool
+    }{
+        {"", true},                          // non-browser clients send no Origin
+        {srv.URL, true},                     // same origin
+        {"https://app.example.com", true},   // exact match
+        {"http://app.example.com", false},   // scheme must match too
+        {"https://eu.trusted.io", true},     // wildcard subdomain
+        {"https://trusted.io.evil.com", false},
+        {"https://evil.com", false},
+    }
+    for _, c := range cases {
+        h := http.Header{}
+        if c.origin != "" { h.Set("Origin", c.origin) }
+        conn, resp, err := gorillaws.DefaultDialer.Dial(url, h)
+        if c.ok {
+            if err != nil { t.Fatalf("origin %q: expected upgrade, got %v", c.origin, err) }
+            conn.Close()
+            continue
+        }
+        if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden { t.Fatalf("origin %q: expected 403, got %v %v", c.origin, resp, err) }
+    }
+}
+
+func TestHandlerAnyOrigin(t *testing.T) {
+    srv := httptest.NewServer(NewHandler(realtime.NewHub(), Options{AllowedOrigins: []string{"*"}}))
+    defer srv.Close()
+    conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Origin": {"https://anywhere.test"}})
+    if err != nil { t.Fatal(err) }
+    conn.Close()
+}
+
+func TestHandlerControlMessages(t *testing.T) {
+    srv := httptest.NewServer(NewHandler(realtime.NewHub(), Options{MaxInvalidMessages: 2}))
+    defer srv.Close()
+    conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+
+    roundTrip := func(opcode int, msg string) controlMessage {
+        t.Helper()
+        if err := conn.WriteMessage(opcode, []byte(msg)); err != nil { t.Fatal(err) }
+        var reply controlMessage
+        _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+        if err := conn.ReadJSON(&reply); err != nil { t.Fatal(err) }
+        return reply
+    }
+
+    if r := roundTrip(gorillaws.TextMessage, `{"type":"ping","id":"1"}`); r.Type != "pong" || r.ID != "1" { t.Fatalf("want pong, got %+v", r) }
+    // unknown types are ignored, so the next reply answers the ping after it
+    if err := conn.WriteMessage(gorillaws.TextMessage, []byte(`{"type":"subscribe"}`)); err != nil { t.Fatal(err) }
+    if r := roundTrip(gorillaws.TextMessage, `{"type":"ping","id":"2"}`); r.Type != "pong" || r.ID != "2" { t.Fatalf("want pong, got %+v", r) }
+
+    if r := roundTrip(gorillaws.TextMessage, `not json`); r.Type != "error" || r.Code != "invalid_message" { t.Fatalf("want error frame, got %+v", r) }
+    if r := roundTrip(gorillaws.BinaryMessage, `{"type":"ping"}`); r.Type != "error" || r.Code != "unsupported_frame" { t.Fatalf("want error frame, got %+v", r) }
+
+    // the second invalid message hit the limit, so the server closes the connection
+    _, _, err = conn.ReadMessage()
+    if !gorillaws.IsCloseError(err, gorillaws.ClosePolicyViolation) { t.Fatalf("want policy violation close, got %v", err) }
+}
+
+func TestHandlerReadLimit(t *testing.T) {
+    hub := realtime.NewHub()
+    srv := httptest.NewServer(NewHandler(hub, Options{MaxMessageSize: 64}))
+    defer srv.Close()
+    conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+
+    if err := conn.WriteMessage(gorillaws.TextMessage, []byte(`{"type":"ping","id":"`+strings.Repeat("x", 100)+`"}`)); err != nil { t.Fatal(err) }
+    _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+    _, _, err = conn.ReadMessage()
+    if !gorillaws.IsCloseError(err, gorillaws.CloseMessageTooBig) { t.Fatalf("want message too big close, got %v", err) }
+    waitFor(t, func() bool { return hub.ClientCount() == 0 })
+}
+
+func TestHandlerAcknowledgedDelivery(t *testing.T) {
+    hub := realtime.NewHub()
+    var mu sync.Mutex
+    var unacked []core.Event
+    hub.SetAckPolicy(realtime.AckPolicy{Timeout: 100 * time.Millisecond, MaxRedeliveries: 1,
+        OnUnacked: func(_ realtime.ConnInfo, ev core.Event){ mu.Lock(); unacked = append(unacked, ev); mu.Unlock() }})
+    srv := httptest.NewServer(Handler(hub))
+    defer srv.Close()
+    url := "ws" + strings.TrimPrefix(srv.URL, "http")
+    if resp, err := http.Get(srv.URL + "?acks=maybe"); err != nil || resp.StatusCode != http.StatusBadRequest { t.Fatalf("want 400 for an invalid acks parameter, got %v %v", resp, err) }
+
+    conn, _, err := gorillaws.DefaultDialer.Dial(url+"?acks=true", nil)
+    if err != nil { t.Fatal(err) }
+    defer conn.Close()
+    waitFor(t, func() bool { return hub.ClientCount() == 1 })
+    read := func() core.Event {
+        t.Helper()
+        var ev core.Event
+        _ = conn.SetReadDeadline(time.Now().Add(time.Second))
+        if err := conn.ReadJSON(&ev); err != nil { t.Fatal(err) }
+        return ev
+    }
+
+    // acknowledged at once: sent exactly once
+    prize := core.RequireAck(core.NewBadgeAwarded("alice", "grand-prize"))
+    hub.Broadcast(context.Background(), prize)
+    if ev := read(); ev.ID != prize.ID || !ev.AckRequired() { t.Fatalf("want the flagged event with its ID, got %+v", ev) }
+    if err := conn.WriteJSON(clientMessage{Type: "ack", ID: prize.ID}); err != nil { t.Fatal(err) }
+    waitFor(t, func() bool { return hub.Connections()[0].Acked == 1 })
+
+    // unflagged events need no acknowledgement; an unacknowledged one is sent again with the same ID, then given up on
+    hub.Broadcast(context.Background(), core.NewPointsAdded("alice", core.MetricXP, 5, 5))
+    rare := core.RequireAck(core.NewBadgeAwarded("alice", "rare"))
+    hub.Broadcast(context.Background(), rare)
+    if ev := read(); ev.Type != core.EventPointsAdded { t.Fatalf("want points event, got %+v", ev) }
+    if ev := read(); ev.ID != rare.ID { t.Fatalf("want rare badge, got %+v", ev) }
+    if ev := read(); ev.ID != rare.ID { t.Fatalf("want rare badge redelivered, got %+v", ev) }
+    waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return len(unacked) == 1 })
+    if unacked[0].ID != rare.ID { t.Fatalf("gave up on %+v", unacked[0]) }
+    c := hub.Connections()[0]
+    if !c.Acks || c.Unacked != 0 || c.Acked != 1 || c.Redelivered != 1 || c.AckFailed != 1 { t.Fatalf("unexpected counters %+v", c) }
+}
@@ -89,7 +89,7 @@ func (ae *AggregationEngine) AggregateNow() error {
     ae.mu.Lock()
     defer ae.mu.Unlock()
 
-    now := time.Now().UTC()
+    now := core.CurrentTime().UTC()
 
     // Aggregate daily data
     if err := ae.aggregateDaily(now); err != nil {
@@ -2,6 +2,12 @@ package analytics
 
 import (
     "context"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
     "testing"
     "time"
 
@@ -63,6 +69,28 @@ func TestComprehensiveMetrics_OnEvent(t *testing.T) {
     assert.Equal(t, int64(1), levels)
 }
 
+func TestDAU_Ranges(t *testing.T) {
+    dau := NewDAU()
+    day1 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    day2 := day1.AddDate(0, 0, 1)
+    day3 := day1.AddDate(0, 0, 2)
+
+    dau.OnEvent(core.Event{UserID: "alice", Time: day1})
+    dau.OnEvent(core.Event{UserID: "bob", Time: day1})
+    dau.OnEvent(core.Event{UserID: "alice", Time: day2})
+    dau.OnEvent(core.Event{UserID: "carol", Time: day3})
+
+    counts := dau.CountRange("2024-01-01", "2024-01-04")
+    assert.Equal(t, map[string]int{"2024-01-01": 2, "2024-01-02": 1, "2024-01-03": 1, "2024-01-04": 0}, counts)
+
+    // alice is active on two days but must only be counted once
+    assert.Equal(t, 2, dau.UniqueOver("2024-01-01", "2024-01-02"))
+    assert.Equal(t, 3, dau.UniqueOver("2024-01-01", "2024-01-03"))
+    assert.Equal(t, 0, dau.UniqueOver("2024-01-03", "2024-01-01"))
+    assert.Empty(t, dau.CountRange("bad", "2024-01-01"))
+    assert.Equal(t, 3, dau.Total())
+}
+
 func TestAggregationEngine(t *testing.T) {
     metrics := NewComprehensiveMetrics()
     aggregator := NewAggregationEngine(metrics, 1*time.Hour)
@@ -198,6 +226,85 @@ func TestDashboardManager(t *testing.T) {
 }
 
 
+func TestHTTPExporter_DeadLetterAndReplay(t *testing.T) {
+    var healthy atomic.Bool
+    var calls atomic.Int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        if !healthy.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer srv.Close()
+
+    ctx := context.Background()
+    store, err := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dead_letters.json"))
+    require.NoError(t, err)
+
+    exporter := NewHTTPExporter(srv.URL, "", 1)
+    exporter.SetRetry(3, time.Millisecond)
+    manager := NewExportManager(exporter)
+    manager.SetDeadLetterStore(store)
+
+    // Delivery fails three times, then lands in the dead letter store
+    err = manager.ExportData(ctx, []*AggregatedData{{Period: PeriodDaily, Key: "2024-01-01"}})
+    require.Error(t, err)
+    assert.Equal(t, int32(3), calls.Load())
+    count, err := manager.DeadLetterCount(ctx)
+    require.NoError(t, err)
+    assert.Equal(t, 1, count)
+
+    letters, err := store.List(ctx, DeadLetterFilter{Target: srv.URL})
+    require.NoError(t, err)
+    require.Len(t, letters, 1)
+    assert.Equal(t, 3, letters[0].Attempts)
+    assert.Contains(t, letters[0].LastError, "503")
+    assert.Contains(t, string(letters[0].Payload), "2024-01-01")
+
+    // Survives a reload from disk
+    reloaded, err := NewFileDeadLetterStore(store.path)
+    require.NoError(t, err)
+    n, _ := reloaded.Count(ctx)
+    assert.Equal(t, 1, n)
+
+    // Replay while the endpoint is still down keeps the letter and bumps its attempts
+    delivered, err := manager.ReplayDeadLetters(ctx, DeadLetterFilter{})
+    require.NoError(t, err)
+    assert.Equal(t, 0, delivered)
+    letters, _ = store.List(ctx, DeadLetterFilter{})
+    assert.Equal(t, 4, letters[0].Attempts)
+
+    // Replay after recovery delivers and removes it
+    healthy.Store(true)
+    delivered, err = manager.ReplayDeadLetters(ctx, DeadLetterFilter{})
+    require.NoError(t, err)
+    assert.Equal(t, 1, delivered)
+    count, _ = manager.DeadLetterCount(ctx)
+    assert.Equal(t, 0, count)
+}
+
+func TestDeadLetterFilter(t *testing.T) {
+    ctx := context.Background()
+    store, err := NewFileDeadLetterStore(filepath.Join(t.TempDir(), "dl.json"))
+    require.NoError(t, err)
+
+    old := NewDeadLetter("a", []byte(`{}`), 1, nil)
+    old.CreatedAt = time.Now().Add(-time.Hour)
+    require.NoError(t, store.Add(ctx, old))
+    require.NoError(t, store.Add(ctx, NewDeadLetter("a", []byte(`{}`), 1, nil)))
+    require.NoError(t, store.Add(ctx, NewDeadLetter("b", []byte(`{}`), 1, nil)))
+
+    all, _ := store.List(ctx, DeadLetterFilter{})
+    assert.Len(t, all, 3)
+    assert.Equal(t, old.ID, all[0].ID, "oldest first")
+    byTarget, _ := store.List(ctx, DeadLetterFilter{Target: "a"})
+    assert.Len(t, byTarget, 2)
+    recent, _ := store.List(ctx, DeadLetterFilter{Since: time.Now().Add(-time.Minute)})
+    assert.Len(t, recent, 2)
+    limited, _ := store.List(ctx, DeadLetterFilter{Limit: 1})
+    assert.Len(t, limited, 1)
+}
+
 func BenchmarkComprehensiveMetrics(b *testing.B) {
     metrics := NewComprehensiveMetrics()
 
@@ -237,3 +344,443 @@ func BenchmarkStreamPublisher(b *testing.B) {
         publisher.OnEvent(event)
     }
 }
+
+func TestRebuildAnalytics_FromFileEventLog(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "events.jsonl")
+    log, err := NewFileEventLog(path)
+    require.NoError(t, err)
+
+    day1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    day2 := day1.AddDate(0, 0, 1)
+    // appended out of time order, as async dispatch may do
+    log.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "bob", Time: day2, Metric: core.MetricXP, Delta: 5})
+    log.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: day1, Metric: core.MetricXP, Delta: 10})
+    log.OnEvent(core.Event{Type: core.EventBadgeAwarded, UserID: "alice", Time: day2, Badge: "b"})
+    require.NoError(t, log.Err())
+    require.NoError(t, log.Close())
+
+    replay, err := OpenFileEventLog(path)
+    require.NoError(t, err)
+    metrics := NewComprehensiveMetrics()
+    dau := NewDAU()
+    var reports []int
+    progress := NewProgressHook(2, func(n int, _ core.Event) { reports = append(reports, n) })
+
+    n, err := RebuildAnalytics(context.Background(), replay, metrics, dau, progress)
+    require.NoError(t, err)
+    assert.Equal(t, 3, n)
+    assert.Equal(t, []int{2}, reports)
+    assert.Equal(t, 1, dau.Count("2024-03-01"))
+    assert.Equal(t, 2, dau.Count("2024-03-02"))
+    assert.Equal(t, 2, metrics.GetMonthlyActiveUsers("2024-03"))
+    assert.Equal(t, int64(15), metrics.GetPointsAwardedByMetric(core.MetricXP))
+}
+
+func TestFileEventLog_RejectsDuplicateIDs(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "events.jsonl")
+    log, err := NewFileEventLog(path)
+    require.NoError(t, err)
+
+    ev := core.NewPointsAdded("alice", core.MetricXP, 5, 5)
+    require.NoError(t, log.Append(ev))
+    assert.ErrorIs(t, log.Append(ev), ErrDuplicateEvent)
+    assert.ErrorIs(t, log.Append(core.Event{ID: "has space", UserID: "alice"}), core.ErrInvalidEventID)
+    log.OnEvent(ev) // a redelivery is skipped, not reported
+    require.NoError(t, log.Err())
+    require.NoError(t, log.Close())
+
+    // IDs already in the file are remembered after a restart
+    reopened, err := NewFileEventLog(path)
+    require.NoError(t, err)
+    assert.ErrorIs(t, reopened.Append(ev), ErrDuplicateEvent)
+    require.NoError(t, reopened.Append(core.NewPointsAdded("alice", core.MetricXP, 5, 10)))
+    require.NoError(t, reopened.Close())
+
+    var ids []string
+    replay, err := OpenFileEventLog(path)
+    require.NoError(t, err)
+    require.NoError(t, replay.Replay(context.Background(), func(e core.Event) error { ids = append(ids, e.ID); return nil }))
+    require.Len(t, ids, 2)
+    assert.Equal(t, ev.ID, ids[0])
+}
+
+func TestFileEventLog_CompactRollsUpOldEvents(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "events.jsonl")
+    log, err := NewFileEventLog(path)
+    require.NoError(t, err)
+    defer log.Close()
+
+    base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    events := []core.Event{
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 100, Total: 100},
+        {Type: core.EventBadgeAwarded, UserID: "alice", Badge: "explorer"},
+        {Type: core.EventLevelUp, UserID: "alice", Metric: core.MetricXP, Level: 2},
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 20, Total: 120},
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 5, Total: 125},
+    }
+    for i, e := range events {
+        e.Time = base.Add(time.Duration(i) * time.Hour)
+        require.NoError(t, log.Append(e))
+    }
+    history := NewStateHistory(log)
+    cutoff := base.Add(4 * time.Hour)
+    before, err := history.GetStateAsOf(ctx, "alice", cutoff.Add(-time.Minute))
+    require.NoError(t, err)
+
+    res, err := log.Compact(ctx, cutoff, true)
+    require.NoError(t, err)
+    assert.Equal(t, CompactResult{Removed: 4, RolledUp: 3}, res)
+
+    after, err := history.GetStateAsOf(ctx, "alice", cutoff.Add(-time.Minute))
+    require.NoError(t, err)
+    assert.Equal(t, before, after)
+    now, err := history.GetStateAsOf(ctx, "alice", cutoff)
+    require.NoError(t, err)
+    assert.Equal(t, int64(125), now.Points[core.MetricXP])
+
+    // the log stays writable and opening balances stay out of timelines
+    require.NoError(t, log.Append(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: cutoff.Add(time.Hour), Metric: core.MetricXP, Delta: 1, Total: 126}))
+    timeline := NewTimeline(0)
+    byType := NewCounterHook(func(e core.Event) (string, bool) { return string(e.Type), true })
+    dau := NewDAU()
+    n, err := RebuildAnalytics(ctx, log, timeline, byType, dau)
+    require.NoError(t, err)
+    assert.Equal(t, 5, n)
+    page, err := timeline.Page(ctx, "alice", "", 10)
+    require.NoError(t, err)
+    assert.Len(t, page.Entries, 2)
+    // ...and out of the activity counters
+    assert.Equal(t, int64(2), byType.Count(string(core.EventPointsAdded)))
+    assert.Equal(t, int64(0), byType.Count(string(core.EventBadgeAwarded)))
+    assert.Equal(t, 1, dau.Count("2024-03-01"))
+
+    // without rollup old events are simply dropped
+    res, err = log.Compact(ctx, cutoff.Add(time.Minute), false)
+    require.NoError(t, err)
+    assert.Equal(t, CompactResult{Removed: 4}, res)
+    gone, err := history.GetStateAsOf(ctx, "alice", cutoff)
+    require.NoError(t, err)
+    assert.Empty(t, gone.Points)
+}
+
+func TestFileEventLog_CompactKeepsConcurrentAppends(t *testing.T) {
+    ctx := context.Background()
+    log, err := NewFileEventLog(filepath.Join(t.TempDir(), "events.jsonl"))
+    require.NoError(t, err)
+    defer log.Close()
+
+    old := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    for i := 0; i < 500; i++ {
+        require.NoError(t, log.Append(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: old, Metric: core.MetricXP, Delta: 1}))
+    }
+
+    // appends made while the log is rewritten are copied into the compacted file
+    done := make(chan error)
+    go func() {
+        for i := 0; i < 200; i++ {
+            if err := log.Append(core.Event{Type: core.EventPointsAdded, UserID: "bob", Time: old.Add(time.Hour), Metric: core.MetricXP, Delta: 1}); err != nil {
+                done <- err
+                return
+            }
+        }
+        done <- nil
+    }()
+    res, err := log.Compact(ctx, old.Add(time.Minute), false)
+    require.NoError(t, err)
+    require.NoError(t, <-done)
+    assert.Equal(t, 500, res.Removed)
+
+    var users []core.UserID
+    require.NoError(t, log.Replay(ctx, func(e core.Event) error { users = append(users, e.UserID); return nil }))
+    assert.Len(t, users, 200)
+    assert.NotContains(t, users, core.UserID("alice"))
+    bobs := 0
+    require.NoError(t, log.UserEvents(ctx, "bob", func(core.Event) error { bobs++; return nil }))
+    assert.Equal(t, 200, bobs)
+}
+
+func TestFileEventLog_ReplayStreamsInTimeOrder(t *testing.T) {
+    ctx := context.Background()
+    log, err := NewFileEventLog(filepath.Join(t.TempDir(), "events.jsonl"))
+    require.NoError(t, err)
+    defer log.Close()
+
+    // appended out of order; "z" and "a" share a time and keep their file order
+    base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    for _, ev := range []struct{ id string; offset int }{{"late", 2}, {"z", 1}, {"a", 1}, {"first", 0}} {
+        require.NoError(t, log.Append(core.Event{ID: ev.id, Type: core.EventPointsAdded, UserID: "alice",
+            Time: base.Add(time.Duration(ev.offset) * time.Hour), Metric: core.MetricXP, Delta: 1}))
+    }
+
+    var ids []string
+    require.NoError(t, log.Replay(ctx, func(e core.Event) error { ids = append(ids, e.ID); return nil }))
+    assert.Equal(t, []string{"first", "z", "a", "late"}, ids)
+}
+
+func TestExportEvents_PagesInTimeOrder(t *testing.T) {
+    ctx := context.Background()
+    log, err := NewFileEventLog(filepath.Join(t.TempDir(), "events.jsonl"))
+    require.NoError(t, err)
+    defer log.Close()
+
+    // appended out of order, as async dispatch can, and with two events sharing a time
+    base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+    for i, offset := range []int{3, 0, 5, 1, 1, 4, 2} {
+        require.NoError(t, log.Append(core.Event{ID: fmt.Sprintf("e%d", i), Type: core.EventPointsAdded, UserID: "alice",
+            Time: base.Add(time.Duration(offset) * time.Hour), Metric: core.MetricXP, Delta: 1}))
+    }
+
+    var times []int
+    n, err := ExportEvents(ctx, log, base.Add(time.Hour), base.Add(5*time.Hour), 2, func(e core.Event) error {
+        times = append(times, int(e.Time.Sub(base)/time.Hour))
+        return nil
+    })
+    require.NoError(t, err)
+    assert.Equal(t, 5, n)
+    assert.Equal(t, []int{1, 1, 2, 3, 4}, times)
+
+    // logs without StreamEvents are read page by page
+    times = nil
+    n, err = ExportEvents(ctx, struct{ EventPager }{log}, base.Add(time.Hour), base.Add(5*time.Hour), 2, func(e core.Event) error {
+        times = append(times, int(e.Time.Sub(base)/time.Hour))
+        return nil
+    })
+    require.NoError(t, err)
+    assert.Equal(t, 5, n)
+    assert.Equal(t, []int{1, 1, 2, 3, 4}, times)
+
+    // with a window of two, e6 (2h) arrives after e0 (3h) has left the window and comes out late
+    var ids []string
+    require.NoError(t, log.scanOrdered(ctx, 2, func(a, b positioned) bool { return eventBefore(a.e, b.e) }, func(p positioned) error {
+        ids = append(ids, p.e.ID)
+        return nil
+    }))
+    assert.Equal(t, []string{"e1", "e3", "e4", "e0", "e6", "e5", "e2"}, ids)
+
+    page, err := log.ReadEvents(ctx, EventQuery{AfterTime: base.Add(time.Hour), AfterID: "e3", Limit: 2})
+    require.NoError(t, err)
+    require.Len(t, page, 2)
+    assert.Equal(t, "e4", page[0].ID, "events at the cursor's time with a later ID follow it")
+    assert.Equal(t, "e6", page[1].ID)
+}
+
+type sliceLog []core.Event
+
+func (s sliceLog) Replay(_ context.Context, fn func(core.Event) error) error {
+    for _, e := range s {
+        if err := fn(e); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func TestRebuildAnalytics_RejectsOutOfOrderLog(t *testing.T) {
+    now := time.Now()
+    log := sliceLog{{UserID: "a", Time: now}, {UserID: "b", Time: now.Add(-time.Minute)}}
+    n, err := RebuildAnalytics(context.Background(), log, NewDAU())
+    assert.ErrorIs(t, err, ErrEventsOutOfOrder)
+    assert.Equal(t, 1, n)
+}
+
+func TestTimeline_PagesNewestFirst(t *testing.T) {
+    ctx := context.Background()
+    tl := NewTimeline(4)
+    base := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+    events := []core.Event{
+        {ID: "e1", Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 50, Total: 50},
+        {ID: "e2", Type: core.EventLevelUp, UserID: "alice", Metric: core.MetricXP, Level: 2},
+        {ID: "e3", Type: core.EventBadgeAwarded, UserID: "alice", Badge: "explorer"},
+        {ID: "e4", Type: core.EventAchievementUnlocked, UserID: "alice", Metadata: map[string]any{"achievement": "all-rounder"}},
+        {ID: "e5", Type: core.EventStateReplaced, UserID: "alice"},
+        {ID: "e6", Type: core.EventPointsAdded, UserID: "bob", Metric: core.MetricXP, Delta: 5, Total: 5},
+    }
+    for i := range events {
+        events[i].Time = base.Add(time.Duration(i) * time.Minute)
+    }
+    // out of order and duplicated delivery still yields one ordered feed
+    for _, i := range []int{1, 0, 2, 3, 4, 5, 2} {
+        tl.OnEvent(events[i])
+    }
+    tl.OnEvent(core.MarkSynthetic(core.Event{ID: "fake", Type: core.EventBadgeAwarded, UserID: "alice", Time: base.Add(time.Hour)}))
+
+    page, err := tl.Page(ctx, "alice", "", 3)
+    require.NoError(t, err)
+    require.Len(t, page.Entries, 3)
+    assert.Equal(t, []string{"e4", "e3", "e2"}, []string{page.Entries[0].ID, page.Entries[1].ID, page.Entries[2].ID})
+    assert.Equal(t, TimelineAchievement, page.Entries[0].Kind)
+    assert.Equal(t, "all-rounder", page.Entries[0].Achievement)
+    assert.Equal(t, TimelineLevel, page.Entries[2].Kind)
+    require.NotEmpty(t, page.NextCursor)
+
+    page, err = tl.Page(ctx, "alice", page.NextCursor, 3)
+    require.NoError(t, err)
+    require.Len(t, page.Entries, 1)
+    assert.Equal(t, "e1", page.Entries[0].ID)
+    assert.Equal(t, TimelinePoints, page.Entries[0].Kind)
+    assert.Empty(t, page.NextCursor)
+
+    _, err = tl.Page(ctx, "alice", "not a cursor", 3)
+    assert.ErrorIs(t, err, ErrInvalidCursor)
+
+    // only the newest depth entries are kept
+    tl.OnEvent(core.Event{ID: "e7", Type: core.EventPointsAdded, UserID: "alice", Time: base.Add(time.Hour), Delta: 1, Total: 51})
+    page, err = tl.Page(ctx, "alice", "", 10)
+    require.NoError(t, err)
+    require.Len(t, page.Entries, 4)
+    assert.Equal(t, "e7", page.Entries[0].ID)
+    assert.Equal(t, "e2", page.Entries[3].ID)
+
+    page, err = tl.Page(ctx, "ghost", "", 10)
+    require.NoError(t, err)
+    assert.Empty(t, page.Entries)
+}
+
+func TestCounterHook(t *testing.T) {
+    badges := NewCounterHook(ByBadge())
+    reasons := NewCounterHook(ForTypes(ByMetadata("reason"), core.EventPointsAdded))
+    types := NewCounterHook(ByEventType())
+    events := []core.Event{
+        core.NewBadgeAwarded("alice", "explorer"),
+        core.NewBadgeAwarded("bob", "explorer"),
+        core.NewBadgeAwarded("bob", "veteran"),
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 5, Metadata: map[string]any{"reason": "quiz"}},
+        {Type: core.EventPointsAdded, UserID: "bob", Metric: core.MetricXP, Delta: 5},
+        {Type: core.EventBadgeRevoked, UserID: "bob", Badge: "veteran", Metadata: map[string]any{"reason": "quiz"}},
+        core.MarkSynthetic(core.NewBadgeAwarded("carol", "explorer")),
+    }
+    var wg sync.WaitGroup
+    for _, e := range events {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for _, h := range []Hook{badges, reasons, types} {
+                h.OnEvent(e)
+            }
+        }()
+    }
+    wg.Wait()
+
+    assert.Equal(t, int64(2), badges.Count("explorer"))
+    assert.Equal(t, map[string]int64{"explorer": 2, "veteran": 1}, badges.Snapshot())
+    assert.Equal(t, map[string]int64{"quiz": 1}, reasons.Snapshot())
+    assert.Equal(t, int64(3), types.Count(string(core.EventBadgeAwarded)))
+    assert.Zero(t, types.Count("unknown"))
+
+    snap := badges.Snapshot()
+    snap["explorer"] = 100
+    assert.Equal(t, int64(2), badges.Count("explorer"), "snapshots are copies")
+}
+
+func TestStateHistory_GetStateAsOf(t *testing.T) {
+    ctx := context.Background()
+    base := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+    log := sliceLog{
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 100, Total: 100},
+        {Type: core.EventBadgeAwarded, UserID: "alice", Badge: "explorer"},
+        {Type: core.EventLevelUp, UserID: "alice", Metric: core.MetricXP, Level: 2},
+        {Type: core.EventPointsAdded, UserID: "bob", Metric: core.MetricXP, Delta: 7, Total: 7},
+        {Type: core.EventPointsTransferred, UserID: "alice", Metric: core.MetricXP, Delta: -40, Total: 60},
+        {Type: core.EventLevelDown, UserID: "alice", Metric: core.MetricXP, Level: 1},
+        {Type: core.EventBadgeRevoked, UserID: "alice", Badge: "explorer"},
+    }
+    for i := range log {
+        log[i].Time = base.Add(time.Duration(i) * time.Hour)
+    }
+    history := NewStateHistory(log)
+
+    st, err := history.GetStateAsOf(ctx, "alice", base.Add(-time.Second))
+    require.NoError(t, err)
+    assert.Equal(t, core.UserID("alice"), st.UserID)
+    assert.Empty(t, st.Points)
+    assert.Empty(t, st.Badges)
+    assert.True(t, st.Updated.IsZero())
+
+    st, err = history.GetStateAsOf(ctx, "alice", base.Add(3*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(100), st.Points[core.MetricXP])
+    assert.Contains(t, st.Badges, core.Badge("explorer"))
+    assert.Equal(t, int64(2), st.Levels[core.MetricXP])
+    assert.Equal(t, base.Add(2*time.Hour), st.Updated)
+
+    st, err = history.GetStateAsOf(ctx, "alice", base.Add(24*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(60), st.Points[core.MetricXP])
+    assert.Empty(t, st.Badges)
+    assert.Equal(t, int64(1), st.Levels[core.MetricXP])
+}
+
+func TestStateHistory_UserIndex(t *testing.T) {
+    ctx := context.Background()
+    path := filepath.Join(t.TempDir(), "events.jsonl")
+    log, err := NewFileEventLog(path)
+    require.NoError(t, err)
+    defer log.Close()
+
+    base := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+    replaced := core.NewStateReplaced("alice", core.UserState{Points: map[core.Metric]int64{core.MetricXP: 500}, Badges: map[core.Badge]struct{}{"vip": {}}})
+    events := []core.Event{
+        {Type: core.EventPointsAdded, UserID: "alice", Metric: core.MetricXP, Delta: 100, Total: 100},
+        {Type: core.EventPointsAdded, UserID: "guest", Metric: core.MetricXP, Delta: 30, Total: 30},
+        replaced,
+        {Type: core.EventPointsAdded, UserID: "bob", Metric: core.MetricXP, Delta: 7, Total: 7},
+        core.NewUsersMerged("alice", "guest"),
+    }
+    for i, e := range events {
+        e.Time = base.Add(time.Duration(i) * time.Hour)
+        require.NoError(t, log.Append(e))
+    }
+
+    history := NewStateHistory(log)
+    st, err := history.GetStateAsOf(ctx, "alice", base.Add(3*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, map[core.Metric]int64{core.MetricXP: 500}, st.Points, "replaced state is applied")
+    assert.Contains(t, st.Badges, core.Badge("vip"))
+    st, err = history.GetStateAsOf(ctx, "guest", base.Add(3*time.Hour))
+    require.NoError(t, err)
+    assert.Equal(t, int64(30), st.Points[core.MetricXP])
+    st, err = history.GetStateAsOf(ctx, "guest", base.Add(4*time.Hour))
+    require.NoError(t, err)
+    assert.Empty(t, st.Points, "merged-away user is empty after the merge")
+
+    var read []core.UserID
+    require.NoError(t, log.UserEvents(ctx, "alice", func(e core.Event) error { read = append(read, e.UserID); return nil }))
+    assert.Equal(t, []core.UserID{"alice", "alice", "alice"}, read, "only alice's lines are read")
+
+    // the index is rebuilt from the file on open, and lazily for read-only logs
+    for _, open := range []func(string) (*FileEventLog, error){NewFileEventLog, OpenFileEventLog} {
+        reopened, err := open(path)
+        require.NoError(t, err)
+        st, err := NewStateHistory(reopened).GetStateAsOf(ctx, "alice", base.Add(3*time.Hour))
+        require.NoError(t, err)
+        assert.Equal(t, int64(500), st.Points[core.MetricXP])
+        require.NoError(t, reopened.Close())
+    }
+}
+
+func TestSkewGuard(t *testing.T) {
+    now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+    dau := NewDAU()
+    var skewed []time.Duration
+    guard := NewSkewGuard(SkewOptions{MaxPast: 24 * time.Hour, Now: func() time.Time { return now },
+        OnSkew: func(_ core.Event, skew time.Duration) { skewed = append(skewed, skew) }}, dau)
+
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: now.AddDate(1, 0, 0)})
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "bob", Time: now.AddDate(0, 0, -3)})
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "carol", Time: now.Add(-time.Hour)})
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "dave"})
+
+    assert.Equal(t, 4, dau.Count("2026-05-01"))
+    assert.Equal(t, 0, dau.Count("2027-05-01"))
+    assert.Equal(t, 0, dau.Count("2026-04-28"))
+    require.Len(t, skewed, 2)
+    assert.Equal(t, 365*24*time.Hour, skewed[0])
+
+    rejecting := NewDAU()
+    guard = NewSkewGuard(SkewOptions{Reject: true, Now: func() time.Time { return now }}, rejecting)
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: now.Add(time.Hour)})
+    guard.OnEvent(core.Event{Type: core.EventPointsAdded, UserID: "bob", Time: now.AddDate(-1, 0, 0)})
+    assert.Equal(t, 0, rejecting.Count("2026-05-01"))
+    assert.Equal(t, 1, rejecting.Count("2025-05-01"), "past events are accepted without MaxPast")
+}
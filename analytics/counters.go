@@ -0,0 +1,105 @@
+package analytics
+
+import (
+    "fmt"
+    "slices"
+    "sync"
+
+    "gamifykit/core"
+)
+
+// KeyFunc extracts the key an event is counted under, or reports false for events that are not
+// counted.
+type KeyFunc func(e core.Event) (string, bool)
+
+// CounterHook counts events per key, e.g. badges awarded per badge or points added per reason,
+// without writing a hook for each question. Register one per breakdown; it is safe for concurrent
+// use, so it can be subscribed with any ordering mode. Synthetic events and the opening balances
+// of a compacted log (see IsRollup) are not counted.
+type CounterHook struct {
+    key    KeyFunc
+    mu     sync.Mutex
+    counts map[string]int64
+}
+
+// NewCounterHook counts events under the keys key extracts.
+func NewCounterHook(key KeyFunc) *CounterHook {
+    return &CounterHook{key: key, counts: map[string]int64{}}
+}
+
+// OnEvent counts e under its key.
+func (c *CounterHook) OnEvent(e core.Event) {
+    if !activity(e) {
+        return
+    }
+    k, ok := c.key(e)
+    if !ok {
+        return
+    }
+    c.mu.Lock()
+    c.counts[k]++
+    c.mu.Unlock()
+}
+
+// Count returns how many events were counted under key.
+func (c *CounterHook) Count(key string) int64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.counts[key]
+}
+
+// Snapshot returns a copy of all counts.
+func (c *CounterHook) Snapshot() map[string]int64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    out := make(map[string]int64, len(c.counts))
+    for k, n := range c.counts {
+        out[k] = n
+    }
+    return out
+}
+
+// ByEventType keys every event by its type.
+func ByEventType() KeyFunc {
+    return func(e core.Event) (string, bool) { return string(e.Type), true }
+}
+
+// ByBadge keys badge_awarded events by badge.
+func ByBadge() KeyFunc {
+    return func(e core.Event) (string, bool) {
+        return string(e.Badge), e.Type == core.EventBadgeAwarded && e.Badge != ""
+    }
+}
+
+// ByMetric keys points_added events by metric.
+func ByMetric() KeyFunc {
+    return func(e core.Event) (string, bool) {
+        return string(e.Metric), e.Type == core.EventPointsAdded && e.Metric != ""
+    }
+}
+
+// ByMetadata keys events by their Metadata[key] (formatted with fmt.Sprint when not a string),
+// e.g. ByMetadata("reason"). Events without the field are not counted.
+func ByMetadata(key string) KeyFunc {
+    return func(e core.Event) (string, bool) {
+        v, ok := e.Metadata[key]
+        if !ok || v == nil {
+            return "", false
+        }
+        if s, ok := v.(string); ok {
+            return s, true
+        }
+        return fmt.Sprint(v), true
+    }
+}
+
+// ForTypes restricts key to events of the given types, e.g.
+// ForTypes(ByMetadata("reason"), core.EventPointsAdded) for points added per reason.
+func ForTypes(key KeyFunc, types ...core.EventType) KeyFunc {
+    return func(e core.Event) (string, bool) {
+        if !slices.Contains(types, e.Type) {
+            return "", false
+        }
+        return key(e)
+    }
+}
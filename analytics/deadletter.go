@@ -0,0 +1,221 @@
+package analytics
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "gamifykit/core"
+)
+
+// DeadLetter records an external delivery that still failed after all retries.
+type DeadLetter struct {
+    ID        string          `json:"id"`
+    Target    string          `json:"target"`
+    Payload   json.RawMessage `json:"payload"`
+    Attempts  int             `json:"attempts"`
+    LastError string          `json:"last_error"`
+    CreatedAt time.Time       `json:"created_at"`
+    UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// DeadLetterFilter narrows dead letter listings and replays. Zero fields match everything.
+type DeadLetterFilter struct {
+    // ID selects a single dead letter, e.g. to replay one delivery.
+    ID     string
+    Target string
+    Since  time.Time
+    Limit  int
+}
+
+// Match reports whether the dead letter satisfies the filter (Limit is applied by the caller).
+func (f DeadLetterFilter) Match(d DeadLetter) bool {
+    if f.ID != "" && d.ID != f.ID {
+        return false
+    }
+    if f.Target != "" && d.Target != f.Target {
+        return false
+    }
+    if !f.Since.IsZero() && d.CreatedAt.Before(f.Since) {
+        return false
+    }
+    return true
+}
+
+// DeadLetterStore persists failed deliveries so they can be inspected and replayed.
+type DeadLetterStore interface {
+    Add(ctx context.Context, d DeadLetter) error
+    List(ctx context.Context, filter DeadLetterFilter) ([]DeadLetter, error)
+    Update(ctx context.Context, d DeadLetter) error
+    Delete(ctx context.Context, id string) error
+    Count(ctx context.Context) (int, error)
+}
+
+// Redeliverer is implemented by exporters that can re-send a dead-lettered payload.
+type Redeliverer interface {
+    Target() string
+    Redeliver(ctx context.Context, payload json.RawMessage) error
+}
+
+// NewDeadLetter builds a dead letter with a new time-ordered ID (a UUIDv7) and current timestamps.
+func NewDeadLetter(target string, payload []byte, attempts int, lastErr error) DeadLetter {
+    now := time.Now().UTC()
+    d := DeadLetter{ID: core.NewUUIDv7(), Target: target, Payload: payload, Attempts: attempts, CreatedAt: now, UpdatedAt: now}
+    if lastErr != nil {
+        d.LastError = lastErr.Error()
+    }
+    return d
+}
+
+// ReplayDeadLetters re-attempts every dead letter matching filter against the redeliverer for its target.
+// Successful deliveries are deleted; failures stay in the store with an updated attempt count and error.
+// It returns how many dead letters were delivered.
+func ReplayDeadLetters(ctx context.Context, store DeadLetterStore, filter DeadLetterFilter, targets ...Redeliverer) (int, error) {
+    byTarget := make(map[string]Redeliverer, len(targets))
+    for _, t := range targets {
+        byTarget[t.Target()] = t
+    }
+
+    letters, err := store.List(ctx, filter)
+    if err != nil {
+        return 0, fmt.Errorf("failed to list dead letters: %w", err)
+    }
+
+    delivered := 0
+    var errs []error
+    for _, d := range letters {
+        target, ok := byTarget[d.Target]
+        if !ok {
+            continue
+        }
+        if err := target.Redeliver(ctx, d.Payload); err != nil {
+            d.Attempts++
+            d.LastError = err.Error()
+            d.UpdatedAt = time.Now().UTC()
+            if err := store.Update(ctx, d); err != nil {
+                errs = append(errs, fmt.Errorf("failed to update dead letter %s: %w", d.ID, err))
+            }
+            continue
+        }
+        if err := store.Delete(ctx, d.ID); err != nil {
+            errs = append(errs, fmt.Errorf("failed to delete dead letter %s: %w", d.ID, err))
+            continue
+        }
+        delivered++
+    }
+    return delivered, errors.Join(errs...)
+}
+
+// FileDeadLetterStore keeps dead letters in a single JSON file.
+// Suitable for single-instance deployments; use the sqlx adapter's store when running several instances.
+type FileDeadLetterStore struct {
+    path    string
+    mu      sync.Mutex
+    letters map[string]DeadLetter
+}
+
+// NewFileDeadLetterStore opens (or creates on first write) the dead letter file at path.
+func NewFileDeadLetterStore(path string) (*FileDeadLetterStore, error) {
+    s := &FileDeadLetterStore{path: path, letters: map[string]DeadLetter{}}
+    b, err := os.ReadFile(path) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return s, nil
+        }
+        return nil, err
+    }
+    var list []DeadLetter
+    if err := json.Unmarshal(b, &list); err != nil {
+        return nil, fmt.Errorf("failed to parse dead letter file %s: %w", path, err)
+    }
+    for _, d := range list {
+        s.letters[d.ID] = d
+    }
+    return s, nil
+}
+
+func (s *FileDeadLetterStore) Add(_ context.Context, d DeadLetter) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.letters[d.ID] = d
+    return s.persist()
+}
+
+func (s *FileDeadLetterStore) List(_ context.Context, filter DeadLetterFilter) ([]DeadLetter, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    out := make([]DeadLetter, 0, len(s.letters))
+    for _, d := range s.sortedLocked() {
+        if !filter.Match(d) {
+            continue
+        }
+        out = append(out, d)
+        if filter.Limit > 0 && len(out) == filter.Limit {
+            break
+        }
+    }
+    return out, nil
+}
+
+func (s *FileDeadLetterStore) Update(_ context.Context, d DeadLetter) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.letters[d.ID]; !ok {
+        return fmt.Errorf("dead letter %s not found", d.ID)
+    }
+    s.letters[d.ID] = d
+    return s.persist()
+}
+
+func (s *FileDeadLetterStore) Delete(_ context.Context, id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.letters, id)
+    return s.persist()
+}
+
+func (s *FileDeadLetterStore) Count(_ context.Context) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return len(s.letters), nil
+}
+
+// sortedLocked returns dead letters oldest first.
+func (s *FileDeadLetterStore) sortedLocked() []DeadLetter {
+    list := make([]DeadLetter, 0, len(s.letters))
+    for _, d := range s.letters {
+        list = append(list, d)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+            return list[i].ID < list[j].ID
+        }
+        return list[i].CreatedAt.Before(list[j].CreatedAt)
+    })
+    return list
+}
+
+// persist atomically rewrites the file via a temp file and rename, like the jsonfile adapter.
+func (s *FileDeadLetterStore) persist() error {
+    b, err := json.MarshalIndent(s.sortedLocked(), "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(s.path), 0o750); err != nil {
+        return err
+    }
+    tmp := s.path + ".tmp"
+    if err := os.WriteFile(tmp, b, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, s.path)
+}
+
+var _ DeadLetterStore = (*FileDeadLetterStore)(nil)
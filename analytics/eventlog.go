@@ -0,0 +1,372 @@
+package analytics
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "slices"
+    "sync"
+
+    "gamifykit/core"
+)
+
+var (
+    // ErrEventsOutOfOrder is returned by RebuildAnalytics when a log replays an event older than its predecessor.
+    ErrEventsOutOfOrder = errors.New("event log is not in chronological order")
+    // ErrDuplicateEvent is returned by FileEventLog.Append for an event whose ID is already logged.
+    ErrDuplicateEvent = errors.New("duplicate event ID")
+)
+
+// EventLogDedupWindow is how many of the most recent event IDs a FileEventLog remembers to
+// reject duplicates, including IDs read from the file when it is opened.
+const EventLogDedupWindow = 100000
+
+// EventLog is a durable history of domain events that can be replayed. FileEventLog is the
+// implementation shipped; storage adapters keep balances, not events, and are no EventLog.
+type EventLog interface {
+    // Replay calls fn for every logged event, oldest first, stopping at the first error.
+    Replay(ctx context.Context, fn func(core.Event) error) error
+}
+
+// RebuildAnalytics streams the whole event log through hooks in chronological order, e.g. to
+// rebuild DAU/WAU/MAU after analytics state was lost or to backfill a newly added hook.
+// Hooks should start empty. Pass a ProgressHook to report progress. It returns the number of
+// events replayed; a log that goes back in time fails with ErrEventsOutOfOrder.
+func RebuildAnalytics(ctx context.Context, log EventLog, hooks ...Hook) (int, error) {
+    n := 0
+    var last core.Event
+    err := log.Replay(ctx, func(e core.Event) error {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if n > 0 && e.Time.Before(last.Time) {
+            return fmt.Errorf("%w: event %d at %s precedes %s", ErrEventsOutOfOrder, n+1, e.Time, last.Time)
+        }
+        for _, h := range hooks {
+            h.OnEvent(e)
+        }
+        last = e
+        n++
+        return nil
+    })
+    return n, err
+}
+
+// ProgressHook calls fn with the running event count after every `every` events.
+type ProgressHook struct {
+    every int
+    fn    func(n int, last core.Event)
+    n     int
+}
+
+// NewProgressHook reports progress to fn every `every` events (at least 1).
+func NewProgressHook(every int, fn func(n int, last core.Event)) *ProgressHook {
+    if every < 1 {
+        every = 1
+    }
+    return &ProgressHook{every: every, fn: fn}
+}
+
+func (p *ProgressHook) OnEvent(e core.Event) {
+    p.n++
+    if p.n%p.every == 0 {
+        p.fn(p.n, e)
+    }
+}
+
+// UserEventReader is implemented by event logs that index events by user, so one user's history
+// can be read without replaying the whole log.
+type UserEventReader interface {
+    // UserEvents calls fn for every event of user in log order, including the users_merged events
+    // that deleted user as a merge source, stopping at the first error.
+    UserEvents(ctx context.Context, user core.UserID, fn func(core.Event) error) error
+}
+
+// FileEventLog appends events to a JSON-lines file. It is a Hook, so it can record events
+// as they happen, and an EventLog for replaying them later.
+//
+// It keeps an in-memory index of where each user's events are in the file (a dozen bytes per
+// event), so UserEvents reads one user's history without scanning the rest.
+type FileEventLog struct {
+    path string
+    // compactMu serializes compactions, which hold mu only to copy the last appends and swap files
+    compactMu sync.Mutex
+    mu        sync.Mutex
+    f    *os.File
+    err  error
+    // seen holds the IDs of the last EventLogDedupWindow events, oldest first in ring
+    seen map[string]struct{}
+    ring []string
+    next int
+    // users locates each user's lines in the file, size bytes long; indexed is false until the
+    // file was read (read-only logs index it on first use)
+    users   map[core.UserID][]lineSpan
+    size    int64
+    indexed bool
+}
+
+// lineSpan is where one event's line is in the file, newline included
+type lineSpan struct {
+    off int64
+    n   int32
+}
+
+// NewFileEventLog opens (or creates) the event log at path for appending.
+func NewFileEventLog(path string) (*FileEventLog, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+        return nil, err
+    }
+    l := &FileEventLog{path: path, seen: map[string]struct{}{}}
+    if err := l.load(true); err != nil {
+        return nil, err
+    }
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        return nil, fmt.Errorf("failed to open event log %s: %w", path, err)
+    }
+    l.f = f
+    return l, nil
+}
+
+// load indexes the events already in the file by user and, with rememberIDs, remembers their IDs
+// so a restarted process still rejects duplicates of them. Callers hold mu or own l.
+func (l *FileEventLog) load(rememberIDs bool) error {
+    l.users, l.size, l.indexed = map[core.UserID][]lineSpan{}, 0, true
+    f, err := os.Open(l.path) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil
+        }
+        l.indexed = false
+        return fmt.Errorf("failed to open event log %s: %w", l.path, err)
+    }
+    defer f.Close()
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    sc.Split(scanRawLines)
+    for line := 1; sc.Scan(); line++ {
+        raw := sc.Bytes()
+        span := lineSpan{off: l.size, n: int32(len(raw))}
+        l.size += int64(len(raw))
+        raw = bytes.TrimSpace(raw)
+        if len(raw) == 0 {
+            continue
+        }
+        var e struct {
+            ID       string                     `json:"id"`
+            Type     core.EventType             `json:"type"`
+            UserID   core.UserID                `json:"user_id"`
+            Metadata map[string]json.RawMessage `json:"metadata"`
+        }
+        if err := json.Unmarshal(raw, &e); err != nil {
+            l.indexed = false
+            return fmt.Errorf("failed to parse event log %s line %d: %w", l.path, line, err)
+        }
+        var source core.UserID
+        if e.Type == core.EventUsersMerged {
+            _ = json.Unmarshal(e.Metadata["source"], &source)
+        }
+        l.index(span, e.UserID, source)
+        if rememberIDs && e.ID != "" {
+            l.remember(e.ID)
+        }
+    }
+    if err := sc.Err(); err != nil {
+        l.indexed = false
+        return err
+    }
+    return nil
+}
+
+// scanRawLines splits like bufio.ScanLines but keeps the line ending, so offsets add up
+func scanRawLines(data []byte, atEOF bool) (int, []byte, error) {
+    if i := bytes.IndexByte(data, '\n'); i >= 0 {
+        return i + 1, data[:i+1], nil
+    }
+    if atEOF && len(data) > 0 {
+        return len(data), data, nil
+    }
+    return 0, nil, nil
+}
+
+// index records the line of an event of user and, for a merge, of the source it deleted. Callers
+// hold mu.
+func (l *FileEventLog) index(span lineSpan, user, source core.UserID) {
+    if user != "" {
+        l.users[user] = append(l.users[user], span)
+    }
+    if source != "" && source != user {
+        l.users[source] = append(l.users[source], span)
+    }
+}
+
+// mergeSource is the user a users_merged event deleted, "" for other events
+func mergeSource(e core.Event) core.UserID {
+    if e.Type != core.EventUsersMerged {
+        return ""
+    }
+    source, _ := e.Metadata["source"].(string)
+    return core.UserID(source)
+}
+
+// remember records id, forgetting the oldest one once the window is full. Callers hold mu.
+func (l *FileEventLog) remember(id string) {
+    if len(l.ring) < EventLogDedupWindow {
+        l.ring = append(l.ring, id)
+    } else {
+        delete(l.seen, l.ring[l.next])
+        l.ring[l.next] = id
+        l.next = (l.next + 1) % EventLogDedupWindow
+    }
+    l.seen[id] = struct{}{}
+}
+
+// OpenFileEventLog opens an existing event log read-only, for replaying it offline.
+func OpenFileEventLog(path string) (*FileEventLog, error) {
+    if _, err := os.Stat(path); err != nil {
+        return nil, fmt.Errorf("failed to open event log %s: %w", path, err)
+    }
+    return &FileEventLog{path: path}, nil
+}
+
+// Append writes one event to the log. Events without an ID are given one (see core.NewEventID);
+// invalid IDs fail with core.ErrInvalidEventID and IDs among the last EventLogDedupWindow logged
+// with ErrDuplicateEvent, so a redelivered event is not recorded twice.
+func (l *FileEventLog) Append(e core.Event) error {
+    if e.ID == "" {
+        e.ID = core.NewEventID()
+    } else if err := core.ValidateEventID(e.ID); err != nil {
+        return fmt.Errorf("%w: %q", err, e.ID)
+    }
+    b, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.f == nil {
+        return errors.New("event log is read-only")
+    }
+    if _, dup := l.seen[e.ID]; dup {
+        return fmt.Errorf("%w: %s", ErrDuplicateEvent, e.ID)
+    }
+    n, err := l.f.Write(append(b, '\n'))
+    span := lineSpan{off: l.size, n: int32(n)}
+    l.size += int64(n)
+    if err != nil {
+        return err
+    }
+    l.remember(e.ID)
+    if l.indexed {
+        l.index(span, e.UserID, mergeSource(e))
+    }
+    return nil
+}
+
+// UserEvents calls fn for every event of user in log order, reading only that user's lines; see
+// UserEventReader.
+func (l *FileEventLog) UserEvents(ctx context.Context, user core.UserID, fn func(core.Event) error) error {
+    l.mu.Lock()
+    if !l.indexed {
+        if err := l.load(false); err != nil {
+            l.mu.Unlock()
+            return err
+        }
+    }
+    spans := slices.Clone(l.users[user])
+    // opened under mu, so the file is the one the spans point into even if Compact replaces it
+    f, err := os.Open(l.path) // #nosec G304 - path comes from operator configuration
+    l.mu.Unlock()
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil
+        }
+        return err
+    }
+    defer f.Close()
+    var buf []byte
+    for i, span := range spans {
+        if i%1024 == 1023 {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+        }
+        buf = slices.Grow(buf[:0], int(span.n))[:span.n]
+        if _, err := f.ReadAt(buf, span.off); err != nil {
+            return fmt.Errorf("failed to read event log %s at offset %d: %w", l.path, span.off, err)
+        }
+        var e core.Event
+        if err := json.Unmarshal(buf, &e); err != nil {
+            return fmt.Errorf("failed to parse event log %s at offset %d: %w", l.path, span.off, err)
+        }
+        if err := fn(e); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// OnEvent appends e, skipping duplicates and synthetic events (see core.MarkSynthetic); the first
+// failure is kept and reported by Err.
+func (l *FileEventLog) OnEvent(e core.Event) {
+    if e.Synthetic() {
+        return
+    }
+    if err := l.Append(e); err != nil && !errors.Is(err, ErrDuplicateEvent) {
+        l.mu.Lock()
+        if l.err == nil {
+            l.err = err
+        }
+        l.mu.Unlock()
+    }
+}
+
+// Err returns the first error OnEvent encountered, if any.
+func (l *FileEventLog) Err() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.err
+}
+
+// Replay reads the file once and calls fn for each event, oldest first. Events are appended in
+// publish order, which can differ slightly from event time under async dispatch, so they are put
+// back in time order (stably, events of the same time in file order) within DefaultReorderWindow
+// events; memory stays bounded by that window however long the log grows.
+func (l *FileEventLog) Replay(ctx context.Context, fn func(core.Event) error) error {
+    return l.scanOrdered(ctx, DefaultReorderWindow, func(a, b positioned) bool {
+        if !a.e.Time.Equal(b.e.Time) {
+            return a.e.Time.Before(b.e.Time)
+        }
+        return a.pos < b.pos
+    }, func(p positioned) error {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        return fn(p.e)
+    })
+}
+
+// Close closes the underlying file.
+func (l *FileEventLog) Close() error {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.f == nil {
+        return nil
+    }
+    err := l.f.Close()
+    l.f = nil
+    return err
+}
+
+var (
+    _ EventLog        = (*FileEventLog)(nil)
+    _ UserEventReader = (*FileEventLog)(nil)
+    _ EventStreamer   = (*FileEventLog)(nil)
+    _ Hook            = (*FileEventLog)(nil)
+)
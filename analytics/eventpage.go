@@ -0,0 +1,251 @@
+package analytics
+
+import (
+    "bufio"
+    "container/heap"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "sort"
+    "time"
+
+    "gamifykit/core"
+)
+
+// DefaultEventPageSize is how many events ReadEvents returns, and ExportEvents holds in memory,
+// per page unless told otherwise.
+const DefaultEventPageSize = 1000
+
+// DefaultReorderWindow is how many events a single-pass read of a FileEventLog holds back to put
+// events appended out of time order, as async dispatch and late publishes do, back in order.
+const DefaultReorderWindow = 10000
+
+// EventQuery selects one page of an event log in (Time, ID) order.
+type EventQuery struct {
+    // From and To bound event times: From inclusive, To exclusive. Zero bounds are open.
+    From time.Time
+    To   time.Time
+    // AfterTime and AfterID, when AfterID is set, continue after that event: pass the time and ID
+    // of the last event of a page to get the next one.
+    AfterTime time.Time
+    AfterID   string
+    // Limit caps the page (DefaultEventPageSize when not positive).
+    Limit int
+}
+
+// matches reports whether e falls in the query's range and after its cursor
+func (q EventQuery) matches(e core.Event) bool {
+    if !q.From.IsZero() && e.Time.Before(q.From) {
+        return false
+    }
+    if !q.To.IsZero() && !e.Time.Before(q.To) {
+        return false
+    }
+    return q.AfterID == "" || eventBefore(core.Event{Time: q.AfterTime, ID: q.AfterID}, e)
+}
+
+// eventBefore orders events by time, then ID
+func eventBefore(a, b core.Event) bool {
+    if !a.Time.Equal(b.Time) {
+        return a.Time.Before(b.Time)
+    }
+    return a.ID < b.ID
+}
+
+// EventPager is implemented by event logs that can read a page of events without loading the
+// whole log into memory.
+type EventPager interface {
+    ReadEvents(ctx context.Context, q EventQuery) ([]core.Event, error)
+}
+
+// EventStreamer is implemented by event logs that can pass a time range of events on in one pass,
+// which ExportEvents prefers to reading page by page.
+type EventStreamer interface {
+    StreamEvents(ctx context.Context, from, to time.Time, fn func(core.Event) error) error
+}
+
+// ReadEvents returns the page of events q selects, in (Time, ID) order. Each call makes one pass
+// over the file and holds at most q.Limit events, so a page can be read while events are still
+// being appended. Reading a whole range page by page rescans the file for every page; use
+// StreamEvents (or ExportEvents) for that.
+func (l *FileEventLog) ReadEvents(ctx context.Context, q EventQuery) ([]core.Event, error) {
+    if q.Limit <= 0 {
+        q.Limit = DefaultEventPageSize
+    }
+    // page is a max-heap of the earliest matching events seen so far
+    page := &eventHeap{}
+    err := l.scan(ctx, func(e core.Event) error {
+        if !q.matches(e) {
+            return nil
+        }
+        if page.Len() < q.Limit {
+            heap.Push(page, e)
+        } else if eventBefore(e, (*page)[0]) {
+            (*page)[0] = e
+            heap.Fix(page, 0)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    events := []core.Event(*page)
+    sort.Slice(events, func(i, j int) bool { return eventBefore(events[i], events[j]) })
+    return events, nil
+}
+
+// StreamEvents passes the events from from (inclusive) up to to (exclusive; zero bounds are open) to
+// fn in (Time, ID) order, reading the file once. Events appended out of time order are put back in
+// order within DefaultReorderWindow events; an event appended later than that comes out of order.
+func (l *FileEventLog) StreamEvents(ctx context.Context, from, to time.Time, fn func(core.Event) error) error {
+    q := EventQuery{From: from, To: to}
+    return l.scanOrdered(ctx, DefaultReorderWindow, func(a, b positioned) bool { return eventBefore(a.e, b.e) }, func(p positioned) error {
+        if !q.matches(p.e) {
+            return nil
+        }
+        return fn(p.e)
+    })
+}
+
+// positioned is an event with its place in the file, counted in events
+type positioned struct {
+    e   core.Event
+    pos int
+}
+
+// scanOrdered reads the file once and calls fn for each event in the order less gives, holding
+// at most window events: once the window is full the least held event is passed on
+func (l *FileEventLog) scanOrdered(ctx context.Context, window int, less func(a, b positioned) bool, fn func(positioned) error) error {
+    held := &reorderHeap{less: less}
+    pos := 0
+    err := l.scan(ctx, func(e core.Event) error {
+        heap.Push(held, positioned{e: e, pos: pos})
+        pos++
+        if held.Len() > window {
+            return fn(heap.Pop(held).(positioned))
+        }
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+    for held.Len() > 0 {
+        if err := fn(heap.Pop(held).(positioned)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// reorderHeap keeps the least event by less on top
+type reorderHeap struct {
+    items []positioned
+    less  func(a, b positioned) bool
+}
+
+func (h *reorderHeap) Len() int           { return len(h.items) }
+func (h *reorderHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *reorderHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *reorderHeap) Push(x any)         { h.items = append(h.items, x.(positioned)) }
+func (h *reorderHeap) Pop() any {
+    old := h.items
+    p := old[len(old)-1]
+    h.items = old[:len(old)-1]
+    return p
+}
+
+// scan parses the file line by line, calling fn for each event in file order
+func (l *FileEventLog) scan(ctx context.Context, fn func(core.Event) error) error {
+    f, err := os.Open(l.path) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil
+        }
+        return err
+    }
+    defer f.Close()
+    return l.parse(ctx, f, fn)
+}
+
+// parse reads events line by line from r, a part of the file, calling fn for each
+func (l *FileEventLog) parse(ctx context.Context, r io.Reader, fn func(core.Event) error) error {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for line := 1; sc.Scan(); line++ {
+        if len(sc.Bytes()) == 0 {
+            continue
+        }
+        if line%1024 == 0 {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+        }
+        var e core.Event
+        if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+            return fmt.Errorf("failed to parse event log %s line %d: %w", l.path, line, err)
+        }
+        if err := fn(e); err != nil {
+            return err
+        }
+    }
+    return sc.Err()
+}
+
+// ExportEvents streams the events of log from from (inclusive) up to to (exclusive; zero for no
+// bound) to fn in (Time, ID) order and returns how many it passed on. Logs implementing
+// EventStreamer, such as FileEventLog, are read in one pass (see StreamEvents); others pageSize
+// events at a time (DefaultEventPageSize when not positive), holding one page in memory. An export
+// that failed can be resumed from the time of the last event it delivered; events at exactly that
+// time are delivered again and can be told apart by ID.
+func ExportEvents(ctx context.Context, log EventPager, from, to time.Time, pageSize int, fn func(core.Event) error) (int, error) {
+    n := 0
+    if s, ok := log.(EventStreamer); ok {
+        err := s.StreamEvents(ctx, from, to, func(e core.Event) error {
+            if err := fn(e); err != nil {
+                return err
+            }
+            n++
+            return nil
+        })
+        return n, err
+    }
+    if pageSize <= 0 {
+        pageSize = DefaultEventPageSize
+    }
+    q := EventQuery{From: from, To: to, Limit: pageSize}
+    for {
+        page, err := log.ReadEvents(ctx, q)
+        if err != nil {
+            return n, err
+        }
+        for _, e := range page {
+            if err := fn(e); err != nil {
+                return n, err
+            }
+            n++
+        }
+        if len(page) < pageSize {
+            return n, nil
+        }
+        last := page[len(page)-1]
+        q.AfterTime, q.AfterID = last.Time, last.ID
+    }
+}
+
+// eventHeap keeps the latest event on top
+type eventHeap []core.Event
+
+func (h eventHeap) Len() int           { return len(h) }
+func (h eventHeap) Less(i, j int) bool { return eventBefore(h[j], h[i]) }
+func (h eventHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x any)        { *h = append(*h, x.(core.Event)) }
+func (h *eventHeap) Pop() any {
+    old := *h
+    e := old[len(old)-1]
+    *h = old[:len(old)-1]
+    return e
+}
@@ -4,10 +4,13 @@ import (
     "bytes"
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "net/http"
     "time"
+
+    "gamifykit/tracing"
 )
 
 // Exporter defines the interface for exporting analytics data
@@ -24,6 +27,11 @@ type HTTPExporter struct {
     httpClient *http.Client
     buffer     []*AggregatedData
     batchSize  int
+
+    // Delivery retries; a batch that still fails is moved to deadLetters when set
+    maxAttempts  int
+    retryBackoff time.Duration
+    deadLetters  DeadLetterStore
 }
 
 func NewHTTPExporter(endpoint, apiKey string, batchSize int) *HTTPExporter {
@@ -33,9 +41,36 @@ func NewHTTPExporter(endpoint, apiKey string, batchSize int) *HTTPExporter {
         httpClient: &http.Client{
             Timeout: 30 * time.Second,
         },
-        buffer:    make([]*AggregatedData, 0, batchSize),
-        batchSize: batchSize,
+        buffer:      make([]*AggregatedData, 0, batchSize),
+        batchSize:   batchSize,
+        maxAttempts: 1,
+    }
+}
+
+// SetRetry configures how many times a batch is sent before giving up, waiting backoff
+// (doubled after each failure) between attempts.
+func (e *HTTPExporter) SetRetry(maxAttempts int, backoff time.Duration) {
+    if maxAttempts < 1 {
+        maxAttempts = 1
     }
+    e.maxAttempts = maxAttempts
+    e.retryBackoff = backoff
+}
+
+// SetDeadLetterStore makes batches that exhaust their retries go to store instead of
+// staying buffered, so they can be replayed later with ReplayDeadLetters.
+func (e *HTTPExporter) SetDeadLetterStore(store DeadLetterStore) {
+    e.deadLetters = store
+}
+
+// Target identifies this exporter in dead letters.
+func (e *HTTPExporter) Target() string {
+    return e.endpoint
+}
+
+// Redeliver re-sends a dead-lettered batch payload once.
+func (e *HTTPExporter) Redeliver(ctx context.Context, payload json.RawMessage) error {
+    return e.send(ctx, payload)
 }
 
 func (e *HTTPExporter) Export(ctx context.Context, data *AggregatedData) error {
@@ -58,12 +93,48 @@ func (e *HTTPExporter) Flush(ctx context.Context) error {
         return fmt.Errorf("failed to marshal analytics data: %w", err)
     }
 
+    backoff := e.retryBackoff
+    for attempt := 1; ; attempt++ {
+        err = e.send(ctx, payload)
+        if err == nil {
+            break
+        }
+        if attempt >= e.maxAttempts {
+            if e.deadLetters == nil {
+                return err
+            }
+            if dlErr := e.deadLetters.Add(ctx, NewDeadLetter(e.Target(), payload, attempt, err)); dlErr != nil {
+                return fmt.Errorf("%w (dead letter write failed: %v)", err, dlErr)
+            }
+            e.buffer = e.buffer[:0]
+            return fmt.Errorf("delivery to %s failed after %d attempts, moved to dead letters: %w", e.endpoint, attempt, err)
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+    }
+
+    // Clear buffer on successful export
+    e.buffer = e.buffer[:0]
+    return nil
+}
+
+// send posts a JSON payload to the endpoint once, with the trace context of ctx so the receiver
+// can join the trace
+func (e *HTTPExporter) send(ctx context.Context, payload []byte) (err error) {
+    ctx, span := tracing.Start(ctx, "analytics.webhook")
+    span.SetAttr("http.url", e.endpoint)
+    defer func() { span.End(err) }()
     req, err := http.NewRequestWithContext(ctx, "POST", e.endpoint, bytes.NewReader(payload))
     if err != nil {
         return fmt.Errorf("failed to create request: %w", err)
     }
 
     req.Header.Set("Content-Type", "application/json")
+    tracing.Inject(ctx, req.Header)
     if e.apiKey != "" {
         req.Header.Set("Authorization", "Bearer "+e.apiKey)
     }
@@ -79,8 +150,6 @@ func (e *HTTPExporter) Flush(ctx context.Context) error {
         return fmt.Errorf("analytics export failed with status %d: %s", resp.StatusCode, string(body))
     }
 
-    // Clear buffer on successful export
-    e.buffer = e.buffer[:0]
     return nil
 }
 
@@ -192,6 +261,7 @@ func (e *SegmentExporter) sendEvent(ctx context.Context, event segmentEvent) err
 
     req.Header.Set("Content-Type", "application/json")
     req.SetBasicAuth(e.writeKey, "")
+    tracing.Inject(ctx, req.Header)
 
     resp, err := e.httpClient.Do(req)
     if err != nil {
@@ -283,13 +353,51 @@ func (e *MultiExporter) Close() error {
 
 // ExportManager manages multiple exporters and handles data distribution
 type ExportManager struct {
-    exporters []Exporter
+    exporters   []Exporter
+    deadLetters DeadLetterStore
 }
 
 func NewExportManager(exporters ...Exporter) *ExportManager {
     return &ExportManager{exporters: exporters}
 }
 
+// SetDeadLetterStore routes exhausted deliveries of every exporter that supports it to store.
+func (em *ExportManager) SetDeadLetterStore(store DeadLetterStore) {
+    em.deadLetters = store
+    for _, exporter := range em.exporters {
+        if dl, ok := exporter.(interface{ SetDeadLetterStore(DeadLetterStore) }); ok {
+            dl.SetDeadLetterStore(store)
+        }
+    }
+}
+
+// DeadLetters returns the configured dead letter store, or nil.
+func (em *ExportManager) DeadLetters() DeadLetterStore {
+    return em.deadLetters
+}
+
+// DeadLetterCount reports how many deliveries are waiting in the dead letter store.
+func (em *ExportManager) DeadLetterCount(ctx context.Context) (int, error) {
+    if em.deadLetters == nil {
+        return 0, nil
+    }
+    return em.deadLetters.Count(ctx)
+}
+
+// ReplayDeadLetters re-attempts dead-lettered deliveries matching filter using the managed exporters.
+func (em *ExportManager) ReplayDeadLetters(ctx context.Context, filter DeadLetterFilter) (int, error) {
+    if em.deadLetters == nil {
+        return 0, errors.New("no dead letter store configured")
+    }
+    var targets []Redeliverer
+    for _, exporter := range em.exporters {
+        if r, ok := exporter.(Redeliverer); ok {
+            targets = append(targets, r)
+        }
+    }
+    return ReplayDeadLetters(ctx, em.deadLetters, filter, targets...)
+}
+
 // ExportData distributes data to all configured exporters
 func (em *ExportManager) ExportData(ctx context.Context, data []*AggregatedData) error {
     for _, aggregatedData := range data {
@@ -0,0 +1,119 @@
+package analytics
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "time"
+
+    "gamifykit/core"
+)
+
+// errReplayDone stops a replay once it has passed the requested time.
+var errReplayDone = errors.New("replay done")
+
+// StateHistory reconstructs users' past states from an event log, e.g. to answer "what was
+// alice's XP on March 1st?" when a dispute comes in. It only reads the log.
+//
+// When the log is a UserEventReader, such as FileEventLog, a lookup reads only the user's own
+// events. Other logs are replayed from the start, which costs as much as a full rebuild.
+type StateHistory struct {
+    log EventLog
+}
+
+// NewStateHistory reconstructs states from log, normally the FileEventLog the server records to.
+func NewStateHistory(log EventLog) *StateHistory {
+    return &StateHistory{log: log}
+}
+
+// GetStateAsOf returns user's state as it was at t, with every event up to and including t
+// applied. Before the user's first event, the state is empty, like GetState's for unknown users.
+//
+// Point totals are taken from the events rather than summed, so a metric is exact again after its
+// next write even where history is incomplete. A state_replaced event sets the whole state it
+// carries (see core.Event.ReplacedState), and a merge empties the state of the user merged away.
+// Synthetic events are ignored.
+func (h *StateHistory) GetStateAsOf(ctx context.Context, user core.UserID, t time.Time) (core.UserState, error) {
+    state := emptyState(user)
+    reader, indexed := h.log.(UserEventReader)
+    if !indexed {
+        err := h.log.Replay(ctx, func(e core.Event) error {
+            if e.Time.After(t) {
+                return errReplayDone
+            }
+            applyUserEvent(&state, e)
+            return nil
+        })
+        if err != nil && !errors.Is(err, errReplayDone) {
+            return core.UserState{}, err
+        }
+        return state, nil
+    }
+    var events []core.Event
+    err := reader.UserEvents(ctx, user, func(e core.Event) error {
+        if !e.Time.After(t) {
+            events = append(events, e)
+        }
+        return nil
+    })
+    if err != nil {
+        return core.UserState{}, err
+    }
+    // the log is in publish order, which can differ slightly from event time
+    sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+    for _, e := range events {
+        applyUserEvent(&state, e)
+    }
+    return state, nil
+}
+
+func emptyState(user core.UserID) core.UserState {
+    return core.UserState{UserID: user, Points: map[core.Metric]int64{}, Badges: map[core.Badge]struct{}{},
+        Levels: map[core.Metric]int64{}}
+}
+
+// applyUserEvent applies e to the state of state.UserID: its own events, and the merge that
+// deleted it
+func applyUserEvent(state *core.UserState, e core.Event) {
+    if e.Synthetic() {
+        return
+    }
+    if e.UserID != state.UserID {
+        if mergeSource(e) == state.UserID {
+            *state = emptyState(state.UserID)
+            state.Updated = core.Timestamp(e.Time)
+        }
+        return
+    }
+    if applyEvent(state, e) {
+        state.Updated = core.Timestamp(e.Time)
+    }
+}
+
+// applyEvent applies e to state and reports whether it changed anything e records
+func applyEvent(state *core.UserState, e core.Event) bool {
+    switch e.Type {
+    case core.EventPointsAdded, core.EventPointsTransferred:
+        state.Points[e.Metric] = e.Total
+    case core.EventBadgeAwarded:
+        state.Badges[e.Badge] = struct{}{}
+    case core.EventAchievementUnlocked:
+        if e.Badge == "" {
+            return false
+        }
+        state.Badges[e.Badge] = struct{}{}
+    case core.EventBadgeRevoked:
+        delete(state.Badges, e.Badge)
+    case core.EventLevelUp, core.EventLevelDown:
+        state.Levels[e.Metric] = e.Level
+    case core.EventStateReplaced:
+        replaced, ok := e.ReplacedState()
+        if !ok {
+            return false
+        }
+        state.Points, state.Badges, state.Levels = replaced.Points, replaced.Badges, replaced.Levels
+    default:
+        return false
+    }
+    return true
+}
@@ -37,7 +37,8 @@ type AnalyticsEvent struct {
     Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// DAU tracks daily active users.
+// DAU tracks daily active users. Like ComprehensiveMetrics, it skips synthetic events and the
+// opening balances of a compacted log (see IsRollup).
 type DAU struct {
     mu   sync.Mutex
     days map[string]map[core.UserID]struct{}
@@ -46,6 +47,7 @@ type DAU struct {
 func NewDAU() *DAU { return &DAU{days: map[string]map[core.UserID]struct{}{}} }
 
 func (d *DAU) OnEvent(e core.Event) {
+    if !activity(e) { return }
     day := time.Unix(e.Time.Unix(), 0).UTC().Format("2006-01-02")
     d.mu.Lock(); defer d.mu.Unlock()
     m := d.days[day]
@@ -58,6 +60,58 @@ func (d *DAU) Count(day string) int {
     return len(d.days[day])
 }
 
+// CountRange returns per-day active user counts for the inclusive range [from, to].
+// Days are formatted as "2006-01-02"; days without activity are reported as zero.
+func (d *DAU) CountRange(from, to string) map[string]int {
+    days := dayRange(from, to)
+    out := make(map[string]int, len(days))
+    d.mu.Lock(); defer d.mu.Unlock()
+    for _, day := range days {
+        out[day] = len(d.days[day])
+    }
+    return out
+}
+
+// UniqueOver returns the number of distinct users active at any point in the inclusive range [from, to].
+// Users active on several days in the range are counted once.
+func (d *DAU) UniqueOver(from, to string) int {
+    days := dayRange(from, to)
+    d.mu.Lock(); defer d.mu.Unlock()
+    seen := make(map[core.UserID]struct{})
+    for _, day := range days {
+        for u := range d.days[day] {
+            seen[u] = struct{}{}
+        }
+    }
+    return len(seen)
+}
+
+// Total returns the number of distinct users ever recorded.
+func (d *DAU) Total() int {
+    d.mu.Lock(); defer d.mu.Unlock()
+    seen := make(map[core.UserID]struct{})
+    for _, users := range d.days {
+        for u := range users {
+            seen[u] = struct{}{}
+        }
+    }
+    return len(seen)
+}
+
+// dayRange expands an inclusive "2006-01-02" date range into its day keys.
+// It returns nil when either bound is malformed or from is after to.
+func dayRange(from, to string) []string {
+    start, err := time.Parse("2006-01-02", from)
+    if err != nil { return nil }
+    end, err := time.Parse("2006-01-02", to)
+    if err != nil || end.Before(start) { return nil }
+    var days []string
+    for t := start; !t.After(end); t = t.AddDate(0, 0, 1) {
+        days = append(days, t.Format("2006-01-02"))
+    }
+    return days
+}
+
 // ComprehensiveMetrics provides comprehensive analytics tracking
 type ComprehensiveMetrics struct {
     mu sync.RWMutex
@@ -124,6 +178,9 @@ func NewComprehensiveMetrics() *ComprehensiveMetrics {
 }
 
 func (cm *ComprehensiveMetrics) OnEvent(e core.Event) {
+    if !activity(e) {
+        return
+    }
     cm.mu.Lock()
     defer cm.mu.Unlock()
 
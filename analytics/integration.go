@@ -107,6 +107,26 @@ func (as *AnalyticsService) UnsubscribeFromRealtime(id string) {
     as.publisher.Unsubscribe(id)
 }
 
+// SetDeadLetterStore enables dead-lettering for exporters that exhaust their retries
+func (as *AnalyticsService) SetDeadLetterStore(store DeadLetterStore) {
+    as.exporter.SetDeadLetterStore(store)
+}
+
+// DeadLetters returns the dead letter store, or nil if none is configured
+func (as *AnalyticsService) DeadLetters() DeadLetterStore {
+    return as.exporter.DeadLetters()
+}
+
+// DeadLetterCount returns the number of deliveries waiting in the dead letter store
+func (as *AnalyticsService) DeadLetterCount(ctx context.Context) (int, error) {
+    return as.exporter.DeadLetterCount(ctx)
+}
+
+// ReplayDeadLetters re-attempts dead-lettered deliveries matching filter (admin operation)
+func (as *AnalyticsService) ReplayDeadLetters(ctx context.Context, filter DeadLetterFilter) (int, error) {
+    return as.exporter.ReplayDeadLetters(ctx, filter)
+}
+
 // Example integration with gamification engine
 func ExampleIntegration() {
     // Create analytics service
@@ -119,20 +139,20 @@ func ExampleIntegration() {
         /* rules */ nil,   // Add your rule engine
     )
 
-    // Subscribe analytics hook to all events
+    // Subscribe analytics hook to all events; it tolerates reordering, so use the worker pool
     analyticsHook := analytics.GetHook()
-    svc.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event) {
+    svc.SubscribeNamed("analytics", core.EventPointsAdded, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventBadgeAwarded, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventLevelUp, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventAchievementUnlocked, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventAchievementUnlocked, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
+    }, engine.OrderingBestEffort)
 
     // Start analytics in background
     ctx := context.Background()
@@ -179,20 +199,20 @@ func AdvancedIntegrationExample() {
     // The rest of the setup is the same as the basic example
     svc := engine.NewGamifyService(nil, nil, nil)
 
-    // Subscribe analytics hook to all events
+    // Subscribe analytics hook to all events; it tolerates reordering, so use the worker pool
     analyticsHook := analytics.GetHook()
-    svc.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event) {
+    svc.SubscribeNamed("analytics", core.EventPointsAdded, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventBadgeAwarded, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventLevelUp, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
-    svc.Subscribe(core.EventAchievementUnlocked, func(ctx context.Context, e core.Event) {
+    }, engine.OrderingBestEffort)
+    svc.SubscribeNamed("analytics", core.EventAchievementUnlocked, func(ctx context.Context, e core.Event) {
         analyticsHook.OnEvent(e)
-    })
+    }, engine.OrderingBestEffort)
 
     ctx := context.Background()
     analytics.Start(ctx)
@@ -226,6 +246,7 @@ type AnalyticsConfig struct {
     ExportInterval      time.Duration `json:"export_interval"`
     EnableStreaming     bool          `json:"enable_streaming"`
     Exporters           []ExporterConfig `json:"exporters"`
+    DeadLetterFile      string        `json:"dead_letter_file,omitempty"` // failed deliveries are kept here when set
 }
 
 // ExporterConfig holds configuration for individual exporters
@@ -235,6 +256,8 @@ type ExporterConfig struct {
     APIKey     string            `json:"api_key,omitempty"`
     BatchSize  int               `json:"batch_size,omitempty"`
     Properties map[string]string `json:"properties,omitempty"`
+    MaxAttempts  int           `json:"max_attempts,omitempty"`  // http only; defaults to a single attempt
+    RetryBackoff time.Duration `json:"retry_backoff,omitempty"` // http only; doubled after each failure
 }
 
 // NewAnalyticsServiceWithConfig creates analytics service with custom configuration
@@ -253,6 +276,7 @@ func NewAnalyticsServiceWithConfig(config *AnalyticsConfig) *AnalyticsService {
             if expConfig.BatchSize == 0 {
                 expConfig.BatchSize = 10 // default
             }
+            exporter.SetRetry(expConfig.MaxAttempts, expConfig.RetryBackoff)
             exporters = append(exporters, exporter)
         case "segment":
             if expConfig.APIKey != "" {
@@ -262,6 +286,15 @@ func NewAnalyticsServiceWithConfig(config *AnalyticsConfig) *AnalyticsService {
     }
 
     exporter := NewExportManager(exporters...)
+    if config.DeadLetterFile != "" {
+        store, err := NewFileDeadLetterStore(config.DeadLetterFile)
+        if err != nil {
+            // In production, use proper logging
+            fmt.Printf("Dead letter store unavailable: %v\n", err)
+        } else {
+            exporter.SetDeadLetterStore(store)
+        }
+    }
 
     return &AnalyticsService{
         metrics:    metrics,
@@ -8,6 +8,7 @@ type BridgeHook struct { hooks []Hook }
 func NewBridge(hooks ...Hook) *BridgeHook { return &BridgeHook{hooks: hooks} }
 
 func (b *BridgeHook) OnEvent(e core.Event) {
+    if e.Synthetic() { return }
     for _, h := range b.hooks { h.OnEvent(e) }
 }
 
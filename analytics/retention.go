@@ -0,0 +1,250 @@
+package analytics
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "time"
+
+    "gamifykit/core"
+)
+
+// MetaRollup is the Metadata key flagging the opening-balance events written by
+// FileEventLog.Compact in place of the events it drops.
+const MetaRollup = "rollup"
+
+// DefaultRetentionInterval is how often RunRetention compacts the log when no interval is given.
+const DefaultRetentionInterval = time.Hour
+
+// IsRollup reports whether e is an opening balance written by FileEventLog.Compact.
+func IsRollup(e core.Event) bool {
+    v, _ := e.Metadata[MetaRollup].(bool)
+    return v
+}
+
+// activity reports whether the hooks counting user activity should count e: synthetic events
+// (see core.MarkSynthetic) and opening balances replayed from a compacted log are not activity
+func activity(e core.Event) bool { return !e.Synthetic() && !IsRollup(e) }
+
+// RetentionPolicy says how long a FileEventLog keeps events.
+type RetentionPolicy struct {
+    // MaxAge is how old an event may get before it is dropped; zero keeps events forever
+    MaxAge time.Duration
+    // Rollup replaces the dropped events with one opening balance per user and metric, level and
+    // badge, so StateHistory still answers correctly for times after the cutoff
+    Rollup bool
+}
+
+// CompactResult reports what a compaction did.
+type CompactResult struct {
+    // Removed is how many events were dropped
+    Removed int
+    // RolledUp is how many opening-balance events were written in their place
+    RolledUp int
+}
+
+// Compact drops the events before cutoff from the log. With rollup, every user's state as of
+// their last dropped event is written back as opening balances flagged with MetaRollup: a
+// points_added event carrying the total (and no delta) per metric, a level_up per levelled metric
+// and a badge_awarded per badge held, each at the time of the user's last dropped event. Past
+// states after the cutoff are then unchanged; earlier ones collapse into that single moment.
+// Earlier opening balances are dropped and rolled up again like any event.
+//
+// The log as of the call is rewritten to a temporary file while appends go on. Appends only wait
+// for the events appended meanwhile to be copied over and for the file to be replaced in one
+// rename. Compact is not safe to run on the same file from several processes at once.
+func (l *FileEventLog) Compact(ctx context.Context, cutoff time.Time, rollup bool) (CompactResult, error) {
+    l.compactMu.Lock()
+    defer l.compactMu.Unlock()
+    l.mu.Lock()
+    readOnly, size := l.f == nil, l.size
+    l.mu.Unlock()
+    if readOnly {
+        return CompactResult{}, errors.New("event log is read-only")
+    }
+    // appends only add to the file after size, and only Compact replaces it
+    events, err := l.readPrefix(ctx, size)
+    if err != nil {
+        return CompactResult{}, err
+    }
+    sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+    split := sort.Search(len(events), func(i int) bool { return !events[i].Time.Before(cutoff) })
+    if split == 0 {
+        return CompactResult{}, nil
+    }
+    if err := ctx.Err(); err != nil {
+        return CompactResult{}, err
+    }
+    var kept []core.Event
+    if rollup {
+        kept = openingBalances(events[:split])
+    }
+    res := CompactResult{Removed: split, RolledUp: len(kept)}
+    kept = append(kept, events[split:]...)
+
+    tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".compact-*")
+    if err != nil {
+        return CompactResult{}, fmt.Errorf("failed to compact event log %s: %w", l.path, err)
+    }
+    defer os.Remove(tmp.Name())
+    defer tmp.Close()
+    w := bufio.NewWriter(tmp)
+    for _, e := range kept {
+        b, err := json.Marshal(e)
+        if err != nil {
+            return CompactResult{}, err
+        }
+        w.Write(append(b, '\n'))
+    }
+    if err := w.Flush(); err != nil {
+        return CompactResult{}, fmt.Errorf("failed to compact event log %s: %w", l.path, err)
+    }
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.f == nil {
+        return CompactResult{}, errors.New("event log is closed")
+    }
+    err = l.copyTail(tmp, size)
+    if err == nil {
+        err = tmp.Sync()
+    }
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), l.path)
+    }
+    if err != nil {
+        return CompactResult{}, fmt.Errorf("failed to compact event log %s: %w", l.path, err)
+    }
+    f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o600) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        return CompactResult{}, fmt.Errorf("failed to reopen event log %s: %w", l.path, err)
+    }
+    l.f.Close()
+    l.f = f
+    if err := l.load(false); err != nil {
+        return CompactResult{}, fmt.Errorf("failed to index compacted event log %s: %w", l.path, err)
+    }
+    for _, e := range kept[:res.RolledUp] {
+        l.remember(e.ID)
+    }
+    return res, nil
+}
+
+// readPrefix parses the events in the first size bytes of the file
+func (l *FileEventLog) readPrefix(ctx context.Context, size int64) ([]core.Event, error) {
+    f, err := os.Open(l.path) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var events []core.Event
+    err = l.parse(ctx, io.LimitReader(f, size), func(e core.Event) error {
+        events = append(events, e)
+        return nil
+    })
+    return events, err
+}
+
+// copyTail appends the file's bytes from offset from on, the events appended since, to w; the
+// caller holds mu
+func (l *FileEventLog) copyTail(w io.Writer, from int64) error {
+    f, err := os.Open(l.path) // #nosec G304 - path comes from operator configuration
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    _, err = io.Copy(w, io.NewSectionReader(f, from, l.size-from))
+    return err
+}
+
+// openingBalances folds events, oldest first, into one event per user and metric, level and badge
+func openingBalances(events []core.Event) []core.Event {
+    states := map[core.UserID]*core.UserState{}
+    last := map[core.UserID]time.Time{}
+    for _, e := range events {
+        if e.UserID == "" || e.Synthetic() {
+            continue
+        }
+        if source := mergeSource(e); source != "" && source != e.UserID {
+            delete(states, source)
+            delete(last, source)
+        }
+        state, ok := states[e.UserID]
+        if !ok {
+            empty := emptyState(e.UserID)
+            state = &empty
+            states[e.UserID] = state
+        }
+        applyEvent(state, e)
+        last[e.UserID] = e.Time
+    }
+    users := make([]core.UserID, 0, len(states))
+    for user := range states {
+        users = append(users, user)
+    }
+    slices.Sort(users)
+    var out []core.Event
+    for _, user := range users {
+        state, at := states[user], last[user]
+        balance := func(e core.Event) {
+            e.ID = core.NewEventID()
+            e.Time = at
+            e.Metadata = map[string]any{MetaRollup: true}
+            out = append(out, e)
+        }
+        for _, m := range sortedKeys(state.Points) {
+            balance(core.Event{Type: core.EventPointsAdded, UserID: user, Metric: m, Total: state.Points[m]})
+        }
+        for _, m := range sortedKeys(state.Levels) {
+            balance(core.Event{Type: core.EventLevelUp, UserID: user, Metric: m, Level: state.Levels[m]})
+        }
+        for _, b := range sortedKeys(state.Badges) {
+            balance(core.Event{Type: core.EventBadgeAwarded, UserID: user, Badge: b})
+        }
+    }
+    return out
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+    keys := make([]K, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    slices.Sort(keys)
+    return keys
+}
+
+// RunRetention compacts the log every interval (DefaultRetentionInterval when not positive)
+// according to policy until ctx is cancelled, passing each outcome to onResult if set. It does
+// nothing when policy.MaxAge is zero.
+func (l *FileEventLog) RunRetention(ctx context.Context, policy RetentionPolicy, interval time.Duration, onResult func(CompactResult, error)) {
+    if policy.MaxAge <= 0 {
+        return
+    }
+    if interval <= 0 {
+        interval = DefaultRetentionInterval
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            res, err := l.Compact(ctx, now.Add(-policy.MaxAge), policy.Rollup)
+            if onResult != nil {
+                onResult(res, err)
+            }
+        }
+    }
+}
@@ -0,0 +1,67 @@
+package analytics
+
+import (
+    "time"
+
+    "gamifykit/core"
+)
+
+// DefaultMaxFutureSkew is how far ahead of the server clock a SkewGuard lets event times be by default.
+const DefaultMaxFutureSkew = 5 * time.Minute
+
+// SkewOptions configures a SkewGuard.
+type SkewOptions struct {
+    // MaxFuture is how far ahead of the clock an event may be (DefaultMaxFutureSkew when zero).
+    MaxFuture time.Duration
+    // MaxPast is how far behind the clock an event may be. Zero allows any age, since events may
+    // legitimately arrive late, e.g. after outbox retries.
+    MaxPast time.Duration
+    // Reject drops events outside the window instead of giving them the current time.
+    Reject bool
+    // OnSkew, if set, is called for every event outside the window with how far it was off
+    // (positive for the future), e.g. to log it.
+    OnSkew func(e core.Event, skew time.Duration)
+    // Now is the server clock (time.Now when nil).
+    Now func() time.Time
+}
+
+// SkewGuard passes events on to hooks after checking their times against the server clock, so an
+// event with a wildly wrong timestamp, from clock skew or spoofing, is not counted in a far-off
+// bucket. Events with no time get the current time. Put it in front of hooks fed live from sources
+// other than the engine's event bus, which already checks times (see engine.EventBus.SetMaxClockSkew);
+// do not use it when replaying history with RebuildAnalytics.
+type SkewGuard struct {
+    opts  SkewOptions
+    hooks []Hook
+}
+
+// NewSkewGuard wraps hooks with a timestamp check.
+func NewSkewGuard(opts SkewOptions, hooks ...Hook) *SkewGuard {
+    if opts.MaxFuture <= 0 {
+        opts.MaxFuture = DefaultMaxFutureSkew
+    }
+    if opts.Now == nil {
+        opts.Now = core.CurrentTime
+    }
+    return &SkewGuard{opts: opts, hooks: hooks}
+}
+
+func (g *SkewGuard) OnEvent(e core.Event) {
+    now := g.opts.Now().UTC()
+    skew := e.Time.Sub(now)
+    switch {
+    case e.Time.IsZero():
+        e.Time = now
+    case skew > g.opts.MaxFuture || (g.opts.MaxPast > 0 && skew < -g.opts.MaxPast):
+        if g.opts.OnSkew != nil {
+            g.opts.OnSkew(e, skew)
+        }
+        if g.opts.Reject {
+            return
+        }
+        e.Time = now
+    }
+    for _, h := range g.hooks {
+        h.OnEvent(e)
+    }
+}
@@ -12,6 +12,8 @@ import (
 
 // StreamEvent represents a real-time analytics event for streaming
 type StreamEvent struct {
+    // ID is the ID of the core.Event the stream event was derived from
+    ID        string                 `json:"id,omitempty"`
     Type      string                 `json:"type"`
     UserID    core.UserID            `json:"user_id"`
     Metric    core.Metric            `json:"metric,omitempty"`
@@ -85,6 +87,9 @@ func (sp *StreamPublisher) PublishEvent(event *StreamEvent) {
 
 // OnEvent processes gamification events and publishes them as stream events
 func (sp *StreamPublisher) OnEvent(e core.Event) {
+    if !activity(e) {
+        return
+    }
     // First, let the metrics system process the event
     sp.metrics.OnEvent(e)
 
@@ -97,6 +102,7 @@ func (sp *StreamPublisher) OnEvent(e core.Event) {
 
 func (sp *StreamPublisher) convertToStreamEvent(e core.Event) *StreamEvent {
     event := &StreamEvent{
+        ID:        e.ID,
         Type:      string(e.Type),
         UserID:    e.UserID,
         Timestamp: e.Time,
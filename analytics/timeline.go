@@ -0,0 +1,179 @@
+package analytics
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "gamifykit/core"
+)
+
+// DefaultTimelineDepth is how many entries per user a Timeline keeps when none is configured.
+const DefaultTimelineDepth = 1000
+
+// ErrInvalidCursor is returned for timeline cursors that were not issued by Timeline.Page.
+var ErrInvalidCursor = errors.New("invalid timeline cursor")
+
+// Timeline entry kinds.
+const (
+    TimelinePoints      = "points"
+    TimelineBadge       = "badge"
+    TimelineLevel       = "level"
+    TimelineAchievement = "achievement"
+)
+
+// TimelineEntry is one item of a user's activity timeline.
+type TimelineEntry struct {
+    ID          string         `json:"id"`
+    // Kind groups event types: TimelinePoints, TimelineBadge, TimelineLevel or TimelineAchievement.
+    Kind        string         `json:"kind"`
+    Type        core.EventType `json:"type"`
+    Time        time.Time      `json:"time"`
+    Metric      core.Metric    `json:"metric,omitempty"`
+    Delta       int64          `json:"delta,omitempty"`
+    Total       int64          `json:"total,omitempty"`
+    Badge       core.Badge     `json:"badge,omitempty"`
+    Level       int64          `json:"level,omitempty"`
+    // Achievement is the name of an unlocked achievement.
+    Achievement string         `json:"achievement,omitempty"`
+}
+
+// TimelinePage is a page of a user's timeline, newest entry first.
+type TimelinePage struct {
+    Entries []TimelineEntry `json:"entries"`
+    // NextCursor fetches the following, older page; it is empty on the last page.
+    NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Timeline indexes point, badge, level and achievement events per user, so a user's activity can
+// be served as one reverse-chronological feed. It is a Hook: seed it from the event log at startup
+// with RebuildAnalytics and subscribe it to the same events the log records afterwards.
+//
+// Only the newest depth entries of each user are kept, so memory grows with the number of users,
+// not with history; older activity stays in the event log. Other event types, synthetic events and
+// the opening balances of a compacted log (see IsRollup) are ignored.
+//
+// The index only knows the events fed to it, normally those of one process. Several instances each
+// writing their own log hold different timelines.
+type Timeline struct {
+    depth int
+    mu    sync.RWMutex
+    users map[core.UserID][]TimelineEntry // oldest first
+}
+
+// NewTimeline creates a timeline keeping up to depth entries per user (DefaultTimelineDepth when
+// not positive).
+func NewTimeline(depth int) *Timeline {
+    if depth <= 0 {
+        depth = DefaultTimelineDepth
+    }
+    return &Timeline{depth: depth, users: map[core.UserID][]TimelineEntry{}}
+}
+
+// timelineKind maps an event type to its entry kind, "" for events the timeline ignores
+func timelineKind(typ core.EventType) string {
+    switch typ {
+    case core.EventPointsAdded, core.EventPointsTransferred:
+        return TimelinePoints
+    case core.EventBadgeAwarded, core.EventBadgeRevoked:
+        return TimelineBadge
+    case core.EventLevelUp, core.EventLevelDown:
+        return TimelineLevel
+    case core.EventAchievementUnlocked:
+        return TimelineAchievement
+    }
+    return ""
+}
+
+// OnEvent adds e to its user's timeline. Events arriving out of order are put in place and events
+// already indexed are skipped, so replaying the log over live events does no harm.
+func (t *Timeline) OnEvent(e core.Event) {
+    kind := timelineKind(e.Type)
+    if kind == "" || e.UserID == "" || !activity(e) {
+        return
+    }
+    entry := TimelineEntry{ID: e.ID, Kind: kind, Type: e.Type, Time: e.Time, Metric: e.Metric, Delta: e.Delta,
+        Total: e.Total, Badge: e.Badge, Level: e.Level}
+    if name, ok := e.Metadata["achievement"].(string); ok {
+        entry.Achievement = name
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    entries := t.users[e.UserID]
+    i := sort.Search(len(entries), func(i int) bool { return !entryBefore(entries[i], entry) })
+    if i < len(entries) && entries[i].ID == entry.ID && entries[i].Time.Equal(entry.Time) {
+        return
+    }
+    if i == 0 && len(entries) >= t.depth {
+        return // older than everything kept
+    }
+    entries = append(entries, TimelineEntry{})
+    copy(entries[i+1:], entries[i:])
+    entries[i] = entry
+    if len(entries) > t.depth {
+        copy(entries, entries[1:])
+        entries = entries[:t.depth]
+    }
+    t.users[e.UserID] = entries
+}
+
+// entryBefore orders entries by time, then ID
+func entryBefore(a, b TimelineEntry) bool {
+    if !a.Time.Equal(b.Time) {
+        return a.Time.Before(b.Time)
+    }
+    return a.ID < b.ID
+}
+
+// Page returns up to limit of user's entries, newest first, starting after cursor (the NextCursor
+// of the previous page, or "" for the newest entries). Malformed cursors fail with ErrInvalidCursor.
+func (t *Timeline) Page(_ context.Context, user core.UserID, cursor string, limit int) (TimelinePage, error) {
+    page := TimelinePage{Entries: []TimelineEntry{}}
+    if limit <= 0 {
+        return page, nil
+    }
+    t.mu.RLock()
+    defer t.mu.RUnlock()
+    entries := t.users[user]
+    end := len(entries)
+    if cursor != "" {
+        at, err := decodeCursor(cursor)
+        if err != nil {
+            return page, err
+        }
+        end = sort.Search(len(entries), func(i int) bool { return !entryBefore(entries[i], at) })
+    }
+    for i := end - 1; i >= 0 && len(page.Entries) < limit; i-- {
+        page.Entries = append(page.Entries, entries[i])
+    }
+    if n := len(page.Entries); n > 0 && end-n > 0 {
+        page.NextCursor = encodeCursor(page.Entries[n-1])
+    }
+    return page, nil
+}
+
+// encodeCursor makes an opaque cursor pointing just past e
+func encodeCursor(e TimelineEntry) string {
+    return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(e.Time.UnixNano(), 10) + "." + e.ID))
+}
+
+func decodeCursor(cursor string) (TimelineEntry, error) {
+    b, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return TimelineEntry{}, ErrInvalidCursor
+    }
+    nanos, id, ok := strings.Cut(string(b), ".")
+    if !ok {
+        return TimelineEntry{}, ErrInvalidCursor
+    }
+    n, err := strconv.ParseInt(nanos, 10, 64)
+    if err != nil {
+        return TimelineEntry{}, ErrInvalidCursor
+    }
+    return TimelineEntry{ID: id, Time: time.Unix(0, n)}, nil
+}
@@ -0,0 +1,166 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+const (
+	// maxActionOperations and maxActionConditions bound the size of an action document
+	maxActionOperations = 100
+	maxActionConditions = 20
+)
+
+// actionRequest is the body of POST /users/{id}/actions.
+type actionRequest struct {
+	Operations []actionOperation `json:"operations"`
+	Conditions []actionCondition `json:"conditions,omitempty"`
+}
+
+// actionOperation adds delta points of metric (the default metric when omitted) or awards badge.
+type actionOperation struct {
+	Metric core.Metric `json:"metric,omitempty"`
+	Delta  int64       `json:"delta,omitempty"`
+	Badge  core.Badge  `json:"badge,omitempty"`
+}
+
+// actionCondition is one guard of an action: the user must hold Badge, must not hold WithoutBadge,
+// or must have Metric's total and level within the given bounds (inclusive).
+type actionCondition struct {
+	Badge        core.Badge  `json:"badge,omitempty"`
+	WithoutBadge core.Badge  `json:"without_badge,omitempty"`
+	Metric       core.Metric `json:"metric,omitempty"`
+	Min          *int64      `json:"min,omitempty"`
+	Max          *int64      `json:"max,omitempty"`
+	MinLevel     *int64      `json:"min_level,omitempty"`
+	MaxLevel     *int64      `json:"max_level,omitempty"`
+}
+
+// actionOperationResult reports one operation of an applied action.
+type actionOperationResult struct {
+	actionOperation
+	Applied bool  `json:"applied"`
+	Total   int64 `json:"total,omitempty"`
+}
+
+// applyAction runs the action document in the body for the path user as one unit (see
+// engine.GamifyService.Apply) and answers with what applied and the resulting state. Malformed
+// documents are answered 400 and actions breaking a per-user limit or value policy 409.
+func applyAction(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService, metrics MetricPolicy) {
+	requestID := RequestIDFromContext(r.Context())
+	user, err := core.NormalizeUserID(core.UserID(r.PathValue("id")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	}
+	var req actionRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid action: "+err.Error(), requestID)
+		return
+	}
+	action, err := req.toAction(metrics)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid action: "+err.Error(), requestID)
+		return
+	}
+
+	res, err := svc.Apply(r.Context(), user, action)
+	switch {
+	case errors.Is(err, engine.ErrInvalidAction), errors.Is(err, engine.ErrUnknownMetric), errors.Is(err, engine.ErrDerivedMetric), errors.Is(err, engine.ErrUnknownBadge),
+		errors.Is(err, core.ErrReservedMetric):
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	case errors.Is(err, engine.ErrLimitExceeded), errors.Is(err, engine.ErrValueOutOfRange):
+		writeError(w, http.StatusConflict, err.Error(), requestID)
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), requestID)
+		return
+	}
+	ops := make([]actionOperationResult, len(res.Operations))
+	for i, op := range res.Operations {
+		ops[i] = actionOperationResult{actionOperation: actionOperation{Metric: op.Metric, Delta: op.Delta, Badge: op.Badge},
+			Applied: op.Applied, Total: op.Total}
+	}
+	writeJSON(w, map[string]any{
+		"applied":    res.Applied,
+		"operations": ops,
+		"state":      userResponse{UserState: res.State, Progress: svc.ProgressFor(res.State), Formatted: svc.FormatPoints(res.State)},
+	})
+}
+
+// toAction validates the document and translates it into an engine.Action
+func (req actionRequest) toAction(metrics MetricPolicy) (engine.Action, error) {
+	switch {
+	case len(req.Operations) == 0:
+		return engine.Action{}, errors.New("operations must not be empty")
+	case len(req.Operations) > maxActionOperations:
+		return engine.Action{}, fmt.Errorf("at most %d operations are allowed", maxActionOperations)
+	case len(req.Conditions) > maxActionConditions:
+		return engine.Action{}, fmt.Errorf("at most %d conditions are allowed", maxActionConditions)
+	}
+	var action engine.Action
+	for i, op := range req.Operations {
+		if op.Badge != "" {
+			if op.Metric != "" || op.Delta != 0 {
+				return engine.Action{}, fmt.Errorf("operation %d: badge cannot be combined with metric or delta", i)
+			}
+			action.Operations = append(action.Operations, engine.Operation{Badge: op.Badge})
+			continue
+		}
+		if op.Delta == 0 {
+			return engine.Action{}, fmt.Errorf("operation %d: needs a non-zero delta or a badge", i)
+		}
+		metric, err := metrics.Resolve(string(op.Metric))
+		if err != nil {
+			return engine.Action{}, fmt.Errorf("operation %d: %w", i, err)
+		}
+		action.Operations = append(action.Operations, engine.Operation{Metric: metric, Delta: op.Delta})
+	}
+	for i, c := range req.Conditions {
+		pred, err := c.predicate()
+		if err != nil {
+			return engine.Action{}, fmt.Errorf("condition %d: %w", i, err)
+		}
+		action.Conditions = append(action.Conditions, pred)
+	}
+	return action, nil
+}
+
+// predicate validates the condition and returns the check it describes
+func (c actionCondition) predicate() (func(core.UserState) bool, error) {
+	bounded := c.Min != nil || c.Max != nil || c.MinLevel != nil || c.MaxLevel != nil
+	set := 0
+	for _, ok := range []bool{c.Badge != "", c.WithoutBadge != "", c.Metric != ""} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		return nil, errors.New("set exactly one of badge, without_badge and metric")
+	case c.Badge != "" || c.WithoutBadge != "":
+		if bounded {
+			return nil, errors.New("bounds need a metric")
+		}
+		if c.Badge != "" {
+			return func(s core.UserState) bool { _, ok := s.Badges[c.Badge]; return ok }, nil
+		}
+		return func(s core.UserState) bool { _, ok := s.Badges[c.WithoutBadge]; return !ok }, nil
+	case !bounded:
+		return nil, errors.New("a metric condition needs min, max, min_level or max_level")
+	case c.Min != nil && c.Max != nil && *c.Min > *c.Max, c.MinLevel != nil && c.MaxLevel != nil && *c.MinLevel > *c.MaxLevel:
+		return nil, errors.New("minimum above maximum")
+	}
+	within := func(v int64, lo, hi *int64) bool { return (lo == nil || v >= *lo) && (hi == nil || v <= *hi) }
+	return func(s core.UserState) bool {
+		return within(s.Points[c.Metric], c.Min, c.Max) && within(s.Levels[c.Metric], c.MinLevel, c.MaxLevel)
+	}, nil
+}
@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// errInvalidBadgeCursor is answered 400 for cursors not issued by listBadges
+var errInvalidBadgeCursor = errors.New("invalid cursor")
+
+// listBadges answers GET /users/{id}/badges with a page of the user's badges and their award
+// times, newest first, optionally only those awarded since a time, and the cursor of the next page
+// when the page is full. Limits outside 1..engine.MaxBadgeListLimit are answered 400.
+func listBadges(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	requestID := RequestIDFromContext(r.Context())
+	user, err := core.NormalizeUserID(core.UserID(r.PathValue("id")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	}
+	q := r.URL.Query()
+	filter := core.BadgeFilter{Limit: engine.DefaultBadgeListLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > engine.MaxBadgeListLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", engine.MaxBadgeListLimit), requestID)
+			return
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be an RFC 3339 time such as 2024-03-01T00:00:00Z", requestID)
+			return
+		}
+		filter.Since = t
+	}
+	if v := q.Get("cursor"); v != "" {
+		after, err := decodeBadgeCursor(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error(), requestID)
+			return
+		}
+		filter.After = &after
+	}
+
+	awards, err := svc.ListBadges(r.Context(), user, filter)
+	switch {
+	case errors.Is(err, engine.ErrBadgeListingUnsupported):
+		writeError(w, http.StatusNotImplemented, err.Error(), requestID)
+		return
+	case errors.Is(err, core.ErrInvalidBadgeFilter):
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), requestID)
+		return
+	}
+	if awards == nil {
+		awards = []core.BadgeAward{}
+	}
+	resp := map[string]any{"badges": awards}
+	if len(awards) == filter.Limit {
+		resp["next_cursor"] = encodeBadgeCursor(awards[len(awards)-1])
+	}
+	writeJSON(w, resp)
+}
+
+// encodeBadgeCursor makes the opaque cursor continuing a listing after a
+func encodeBadgeCursor(a core.BadgeAward) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(a.AwardedAt.UnixNano(), 10) + "." + string(a.Badge)))
+}
+
+func decodeBadgeCursor(cursor string) (core.BadgeAward, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return core.BadgeAward{}, errInvalidBadgeCursor
+	}
+	nanos, badge, ok := strings.Cut(string(b), ".")
+	if !ok {
+		return core.BadgeAward{}, errInvalidBadgeCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return core.BadgeAward{}, errInvalidBadgeCursor
+	}
+	return core.BadgeAward{Badge: core.Badge(badge), AwardedAt: time.Unix(0, n).UTC()}, nil
+}
+
+// expandsBadges reports whether a GET /users/{id} request wants the badge set. Without ?expand the
+// full state is served as before; with it, badges are only included when listed (e.g.
+// ?expand=badges), and otherwise replaced by their count.
+func expandsBadges(r *http.Request) bool {
+	if !r.URL.Query().Has("expand") {
+		return true
+	}
+	var fields []string
+	for _, v := range r.URL.Query()["expand"] {
+		fields = append(fields, strings.Split(v, ",")...)
+	}
+	return slices.Contains(fields, "badges")
+}
+
+// withoutBadges re-encodes a user response with "badge_count" in place of "badges"
+func withoutBadges(resp userResponse) (json.RawMessage, error) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "badges")
+	fields["badge_count"] = json.RawMessage(strconv.Itoa(len(resp.Badges)))
+	return json.Marshal(fields)
+}
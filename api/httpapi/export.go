@@ -0,0 +1,174 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gamifykit/analytics"
+	"gamifykit/core"
+)
+
+// exportFlushEvery is how many exported events are written between flushes to the client
+const exportFlushEvery = 1000
+
+// eventCSVHeader names the columns of CSV exports
+var eventCSVHeader = []string{"id", "type", "time", "user_id", "metric", "delta", "total", "badge", "level", "seq", "metadata"}
+
+// exportEvents streams the event log between ?from and ?to (RFC 3339, from inclusive, to
+// exclusive, both optional) as newline-delimited JSON or, with ?format=csv, CSV, in time order and
+// gzipped for clients accepting it. The log is read once with bounded memory (see analytics.ExportEvents). A failure
+// after streaming started aborts the response, so a truncated export is never mistaken for a
+// complete one; it can be resumed with ?from set to the last exported event's time.
+func exportEvents(w http.ResponseWriter, r *http.Request, log analytics.EventPager) {
+	requestID := RequestIDFromContext(r.Context())
+	q := r.URL.Query()
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, name+" must be an RFC 3339 time such as 2024-03-01T00:00:00Z", requestID)
+				return
+			}
+			bounds[i] = t
+		}
+	}
+	from, to := bounds[0], bounds[1]
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to", requestID)
+		return
+	}
+	format := q.Get("format")
+	contentType := "application/x-ndjson"
+	switch format {
+	case "", "ndjson":
+		format = "ndjson"
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	default:
+		writeError(w, http.StatusBadRequest, "format must be ndjson or csv", requestID)
+		return
+	}
+
+	out := &exportWriter{w: w, gzip: acceptsGzip(r), contentType: contentType, filename: "events." + format}
+	var write func(core.Event) error
+	var flush func() error
+	written := 0
+	if format == "csv" {
+		cw := csv.NewWriter(out)
+		_ = cw.Write(eventCSVHeader)
+		write = func(e core.Event) error {
+			meta := ""
+			if len(e.Metadata) > 0 {
+				b, err := json.Marshal(e.Metadata)
+				if err != nil {
+					return err
+				}
+				meta = string(b)
+			}
+			return cw.Write([]string{e.ID, string(e.Type), e.Time.UTC().Format(time.RFC3339Nano), string(e.UserID), string(e.Metric),
+				strconv.FormatInt(e.Delta, 10), strconv.FormatInt(e.Total, 10), string(e.Badge), strconv.FormatInt(e.Level, 10),
+				strconv.FormatUint(e.Seq, 10), meta})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(out)
+		write = func(e core.Event) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+	}
+
+	n, err := analytics.ExportEvents(r.Context(), log, from, to, 0, func(e core.Event) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		if written++; written%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			return out.flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		if err = out.close(); err == nil {
+			return
+		}
+	}
+	slog.Error("event export failed", "error", err, "exported", n, "request_id", requestID)
+	if !out.started {
+		writeError(w, errorStatus(err), err.Error(), requestID)
+		return
+	}
+	// the status is sent; cut the response short so the client sees the export failed
+	panic(http.ErrAbortHandler)
+}
+
+// exportWriter sends the export headers, and starts compressing, with the first bytes written,
+// so failures before that can still be answered with an error status
+type exportWriter struct {
+	w           http.ResponseWriter
+	gzip        bool
+	contentType string
+	filename    string
+	started     bool
+	gz          *gzip.Writer
+}
+
+func (e *exportWriter) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	h := e.w.Header()
+	h.Set("Content-Type", e.contentType)
+	h.Set("Content-Disposition", `attachment; filename="`+e.filename+`"`)
+	h.Set("Cache-Control", "no-store")
+	if e.gzip && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		e.gz = gzip.NewWriter(e.w)
+	}
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	e.start()
+	if e.gz != nil {
+		return e.gz.Write(p)
+	}
+	return e.w.Write(p)
+}
+
+// flush sends what was written so far to the client
+func (e *exportWriter) flush() error {
+	if e.gz != nil {
+		if err := e.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := http.NewResponseController(e.w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// close ends the body, sending the headers of empty exports
+func (e *exportWriter) close() error {
+	e.start()
+	if e.gz != nil {
+		return e.gz.Close()
+	}
+	return nil
+}
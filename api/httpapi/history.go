@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// StateHistoryReader reconstructs past user states; see analytics.StateHistory.
+type StateHistoryReader interface {
+	GetStateAsOf(ctx context.Context, user core.UserID, t time.Time) (core.UserState, error)
+}
+
+// stateAsOf answers GET /users/{id}?as_of=... with the user's state at that time. Requests for a
+// time before the user existed get an empty state, not 404.
+func stateAsOf(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService, history StateHistoryReader) {
+	requestID := RequestIDFromContext(r.Context())
+	if history == nil {
+		writeError(w, http.StatusNotImplemented, "as_of requires an event log", requestID)
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("as_of"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "as_of must be an RFC 3339 time such as 2024-03-01T00:00:00Z", requestID)
+		return
+	}
+	st, err := history.GetStateAsOf(r.Context(), core.UserID(r.PathValue("id")), t)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error(), requestID)
+		return
+	}
+	writeJSON(w, userResponse{UserState: st, Progress: svc.ProgressFor(st), Formatted: svc.FormatPoints(st)})
+}
@@ -1,14 +1,25 @@
 package httpapi
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	wsadapter "gamifykit/adapters/websocket"
+	"gamifykit/analytics"
 	"gamifykit/core"
 	"gamifykit/engine"
+	"gamifykit/importer"
+	"gamifykit/leaderboard"
 	"gamifykit/realtime"
+	"gamifykit/tracing"
 )
 
 // Options configures the HTTP API surface.
@@ -17,75 +28,500 @@ type Options struct {
 	PathPrefix string
 	// AllowCORSOrigin, if non-empty, enables basic CORS with the given origin (use "*" for any).
 	AllowCORSOrigin string
+	// CORSOrigin, if set, is consulted on every request and takes precedence over AllowCORSOrigin,
+	// so the allowed origin can be changed at runtime. Returning "" disables CORS headers.
+	CORSOrigin func() string
+	// WSAllowedOrigins lists the cross-site origins allowed to open {prefix}/ws; see
+	// wsadapter.Options.AllowedOrigins. When nil, the CORS origin is used if set: CORSOrigin,
+	// read on every handshake so a runtime change applies to new WebSockets too, or else
+	// AllowCORSOrigin. Otherwise only same-origin clients and clients that send no Origin header
+	// may connect.
+	WSAllowedOrigins []string
+	// WSCompression negotiates permessage-deflate on {prefix}/ws; see wsadapter.Options.Compression.
+	WSCompression bool
+	// RateLimiter, if set, limits requests per client IP.
+	RateLimiter *RateLimiter
+	// LoadShedder, if set, caps in-flight requests and answers 503 once they are exhausted; see
+	// LoadShedder. It runs after Auth, so only authenticated and public requests take slots.
+	// {prefix}/healthz and {prefix}/readyz are never shed.
+	LoadShedder *LoadShedder
+	// DeadLetters, if set together with AdminToken, exposes failed external deliveries under
+	// {prefix}/admin/dead-letters.
+	DeadLetters analytics.DeadLetterStore
+	// Ready, if set, drives {prefix}/readyz: 200 while true, 503 while false (e.g. once shutdown starts).
+	// When nil the server always reports ready.
+	Ready *atomic.Bool
+	// Debug, together with AdminToken, enables {prefix}/admin/debug/emit, which broadcasts made-up
+	// events to realtime clients for frontend development. Leave it off in production.
+	Debug bool
+	// AdminToken, if set, is required as "Authorization: Bearer <token>" on all admin routes
+	// and enables {prefix}/admin/connections.
+	AdminToken string
+	// NotFoundOnEmptyUser makes user reads answer 404 for users with no stored points, badges or
+	// levels (see engine.GamifyService.UserExists). By default they return an empty state.
+	NotFoundOnEmptyUser bool
+	// LeaderboardArchive, if set, serves past standings at {prefix}/leaderboard/archive/{period};
+	// usually a *leaderboard.WindowedBoard.
+	LeaderboardArchive ArchiveReader
+	// Leaderboards, if set, serves the top entries of each board at {prefix}/leaderboards/{name}.
+	Leaderboards map[string]leaderboard.Board
+	// Timeline, if set, serves each user's activity feed at {prefix}/users/{id}/timeline; usually
+	// an *analytics.Timeline fed from the event log.
+	Timeline TimelineReader
+	// History, if set, answers {prefix}/users/{id}?as_of=<RFC 3339 time> with the user's state at
+	// that time, usually an *analytics.StateHistory over the event log. as_of requests take the
+	// admin bearer token and are answered 404 without an AdminToken; without History, they are
+	// answered 501.
+	History StateHistoryReader
+	// Events, if set together with AdminToken, streams the event log for bulk loads into a data
+	// warehouse at {prefix}/admin/events/export; usually the *analytics.FileEventLog the server
+	// records to.
+	Events analytics.EventPager
+	// MaxLeaderboardLimit is the largest limit a leaderboard request may ask for
+	// (DefaultMaxLeaderboardLimit when zero, never more than leaderboard.MaxPageSize).
+	MaxLeaderboardLimit int
+	// ImportStorage, if set together with AdminToken, enables {prefix}/admin/import, which
+	// upserts users into it (normally the storage backing svc); see package importer. With
+	// RequireNamespace it is wrapped in engine.NamespacedStorage, so rows land in the request's
+	// namespace.
+	ImportStorage engine.Storage
+	// ReloadRules, if set together with AdminToken, enables {prefix}/admin/rules/reload, which
+	// re-reads and installs the rule configuration (see engine.CompileRules). Errors wrapping
+	// engine.ErrInvalidRules are answered with 422.
+	ReloadRules func(ctx context.Context) error
+	// Logger receives panics recovered from handlers and, with LogRequests, one record per
+	// request. slog.Default() is used when nil.
+	Logger *slog.Logger
+	// LogRequests logs every request with its status, size and duration (see AccessLog).
+	LogRequests bool
+	// AccessLog configures the request log of LogRequests. When its SkipPaths is nil,
+	// {prefix}/healthz, {prefix}/readyz and {prefix}/metrics are not logged.
+	AccessLog AccessLogOptions
+	// Tracer, if set, records a server span per request (see Tracing).
+	Tracer *tracing.Tracer
+	// Auth, if set, guards every route except {prefix}/healthz, {prefix}/readyz and PublicRoutes,
+	// e.g. to check an API key. Admin routes additionally require AdminToken.
+	Auth Middleware
+	// PublicRoutes lists the routes anyone may read, as ServeMux patterns relative to PathPrefix
+	// such as "GET /catalog" or "GET /leaderboards/{name}"; each must name GET or HEAD, so no write
+	// is ever public. They skip Auth and answer CORS requests from any origin, so a widget on
+	// another site can read them, while every other route keeps Auth and the configured CORS
+	// origin. Admin routes still require AdminToken. NewMux panics on invalid patterns.
+	PublicRoutes []string
+	// RequireNamespace scopes every route except {prefix}/healthz and {prefix}/readyz to a
+	// namespace (tenant), taken from a {prefix}/games/{gameId}/... path or NamespaceResolver; see
+	// Namespaces. Pair it with a service built on engine.NamespacedStorage. WebSocket clients only
+	// receive events published in their namespace. Leaderboards, archives, timelines and state
+	// history are shared by all namespaces, so NewMux panics when they are combined with it.
+	RequireNamespace bool
+	// NamespaceResolver, with RequireNamespace, resolves the namespace of requests whose path does
+	// not name one, e.g. from the auth token.
+	NamespaceResolver NamespaceResolver
+	// NamespaceAuthorizer, with RequireNamespace, checks that the caller may act in the request's
+	// namespace. It is required for {prefix}/games/{gameId}/... paths, which are rejected with 403
+	// without it, since any client can put any game in a path.
+	NamespaceAuthorizer NamespaceAuthorizer
+	// DefaultMetric is the metric of point, window and transfer requests that name none
+	// (core.MetricXP when empty).
+	DefaultMetric core.Metric
+	// RequireMetric answers 400 to requests that name no metric instead of applying
+	// DefaultMetric. It is implied when svc has a strict catalog (engine.WithStrictCatalog).
+	RequireMetric bool
+	// Compress gzips responses for clients that accept it.
+	Compress bool
+	// Idempotency, if set, lets clients retry writes safely with an Idempotency-Key header; see
+	// Idempotency. Responses are kept for IdempotencyTTL (DefaultIdempotencyTTL when zero). Keys
+	// belong to the caller IdempotencyPrincipal names (CredentialPrincipal when nil), e.g. the
+	// principal Auth authenticated.
+	Idempotency          IdempotencyStore
+	IdempotencyTTL       time.Duration
+	IdempotencyPrincipal IdempotencyPrincipal
+	// Middleware runs innermost, in order, after the built-in middleware (see NewMux).
+	Middleware []Middleware
+}
+
+// ArchiveReader reads archived leaderboard standings; see leaderboard.WindowedBoard.
+type ArchiveReader interface {
+	GetArchive(ctx context.Context, period string) ([]leaderboard.Standing, error)
 }
 
 // NewMux builds an http.Handler exposing a minimal Gamify REST API and WebSocket stream.
+// Routes use Go 1.22 ServeMux patterns, so path parameters are decoded (an encoded slash
+// such as alice%2Fbob stays part of the user ID) and known paths answer 405 for other methods.
+// When svc accepts identity kinds (engine.WithIdentityKinds), {id} and a transfer's "to" may also
+// be an external ID such as email:alice@example.com, resolved to the user it is linked to (404
+// when it is linked to no one).
 // Routes:
-//   - POST {prefix}/users/{id}/points?metric=xp&delta=50
+//   - POST {prefix}/users/{id}/points?metric=xp&delta=50 (metric defaults per Options.DefaultMetric)
 //   - POST {prefix}/users/{id}/badges/{badge}
-//   - GET  {prefix}/users/{id}
-//   - GET  {prefix}/healthz
-//   - WS   {prefix}/ws
+//   - POST {prefix}/users/{id}/actions (body lists point and badge operations plus optional
+//     conditions, applied as one unit; answers which operations applied and the resulting state)
+//   - GET  {prefix}/users/{id} (state plus "progress" per metric with a level curve and
+//     "formatted" display values per the catalog; 404 for unknown users when
+//     Options.NotFoundOnEmptyUser is set; with ?as_of=2024-03-01T00:00:00Z and the admin token, the
+//     state at that time reconstructed by Options.History; with an ?expand= list not naming badges, "badge_count"
+//     replaces "badges")
+//   - GET  {prefix}/users/{id}/badges?limit=50&since=...&cursor=... (badges with award times, newest
+//     first; "next_cursor" fetches the next page; 501 when the storage keeps no award times)
+//   - GET  {prefix}/users/{id}/quests (progress on every quest in its current period, with
+//     "resets_at" for quests that reset; 501 when the storage keeps no quest progress)
+//   - GET  {prefix}/users/{id}/progress/{metric}
+//   - GET  {prefix}/users/{id}/points/recent?metric=xp&window=24h
+//   - GET  {prefix}/users/{id}/timeline?limit=50&cursor=... (when Options.Timeline is set; points,
+//     badges, levels and achievements newest first; "next_cursor" fetches the next page)
+//   - GET  {prefix}/catalog (metrics and badges the service knows; ETag for If-None-Match)
+//   - GET  {prefix}/leaderboards/{name}?limit=10 (when Options.Leaderboards is set; 400 for
+//     limits that are not between 1 and Options.MaxLeaderboardLimit; "cached" and "as_of" tell
+//     whether a leaderboard.CachedBoard answered from its snapshot)
+//   - GET  {prefix}/leaderboard/archive/{period} (when Options.LeaderboardArchive is set)
+//   - GET  {prefix}/healthz (liveness)
+//   - GET  {prefix}/readyz (readiness, see Options.Ready)
+//   - GET  {prefix}/admin/dead-letters?id=...&target=...&limit=50 (when Options.DeadLetters and
+//     AdminToken are set)
+//   - GET  {prefix}/admin/connections (when Options.AdminToken is set)
+//   - PUT  {prefix}/admin/users/{id}/state (when Options.AdminToken is set; body is a full user state)
+//   - POST {prefix}/admin/users/{id}/merge (when Options.AdminToken is set; body is
+//     {"source": "guest-1", "points": "sum|max", "metrics": {"best": "max"}}, see core.MergePolicy;
+//     merges the source into the user, deletes it and answers the merged state)
+//   - GET  {prefix}/admin/users/{id}/identities, PUT and DELETE {prefix}/admin/users/{id}/identities/{identity}
+//     (when Options.AdminToken is set and svc accepts identity kinds; list, link and unlink external
+//     IDs such as email:alice@example.com, answering the user's identities; 409 for an identity
+//     linked to another user)
+//   - GET  {prefix}/admin/users/query?badge=...&metric=xp&min=1000&max=...&level=...&after=...&limit=100
+//     (when Options.AdminToken is set; "next" is the cursor of the next page, passed as after)
+//   - POST {prefix}/admin/debug/emit (when Options.Debug and AdminToken are set and hub is not nil;
+//     body is a core.Event, broadcast to WebSocket clients flagged as synthetic)
+//   - POST {prefix}/admin/rules/reload (when Options.ReloadRules and AdminToken are set)
+//   - POST {prefix}/admin/import?format=csv|json&dry_run=true (when Options.ImportStorage and AdminToken are set)
+//   - GET  {prefix}/admin/events/export?from=...&to=...&format=ndjson|csv (when Options.Events and
+//     AdminToken are set; streams the event log in time order, gzipped for clients accepting it)
+//   - WS   {prefix}/ws (?user=<id>&stream=patches for a state snapshot followed by patches)
+//
+// Requests pass through the middleware in this order: request ID, tracing (with Options.Tracer),
+// request logging (with Options.LogRequests), panic recovery, CORS (any origin for
+// Options.PublicRoutes), Options.Auth (not for Options.PublicRoutes), load shedding (with
+// Options.LoadShedder), namespace resolution (with Options.RequireNamespace), rate limiting, stale
+// reads (see StaleReads; not for health checks), compression (with Options.Compress), idempotency
+// keys (with Options.Idempotency), then Options.Middleware. A panicking handler answers 500 with a
+// JSON {"error", "request_id"} body and the server keeps running.
 func NewMux(svc *engine.GamifyService, hub *realtime.Hub, opts Options) http.Handler {
 	mux := http.NewServeMux()
+	route := func(method, path string) string {
+		return method + " " + withPrefix(opts.PathPrefix, path)
+	}
+	if opts.RequireNamespace && (opts.Leaderboards != nil || opts.LeaderboardArchive != nil || opts.Timeline != nil || opts.History != nil) {
+		panic("httpapi: leaderboards, archives, timelines and history are not scoped to namespaces; they cannot be served with RequireNamespace")
+	}
+	metrics := MetricPolicy{Default: opts.DefaultMetric, Require: opts.RequireMetric || svc.StrictCatalog()}
+	// handleUser registers a route of one user, addressable by external ID too (see resolveUser)
+	handleUser := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, resolveUser(svc, h))
+	}
 
 	// health
-	mux.HandleFunc(withPrefix(opts.PathPrefix, "/healthz"), func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(route(http.MethodGet, "/healthz"), func(w http.ResponseWriter, r *http.Request) {
 		healthCheck(w, r, svc)
 	})
 
+	// readiness
+	mux.HandleFunc(route(http.MethodGet, "/readyz"), func(w http.ResponseWriter, r *http.Request) {
+		readyCheck(w, opts.Ready)
+	})
+
+	// catalog
+	mux.HandleFunc(route(http.MethodGet, "/catalog"), catalogHandler(svc))
+
 	// WebSocket events
 	if hub != nil {
-		mux.Handle(withPrefix(opts.PathPrefix, "/ws"), wsadapter.Handler(hub))
+		wsOpts := wsadapter.Options{State: svc.GetState, AllowedOrigins: opts.WSAllowedOrigins, Compression: opts.WSCompression}
+		switch {
+		case opts.WSAllowedOrigins != nil:
+		case opts.CORSOrigin != nil:
+			wsOpts.Origins = func() []string {
+				if origin := opts.CORSOrigin(); origin != "" {
+					return []string{origin}
+				}
+				return nil
+			}
+		case opts.AllowCORSOrigin != "":
+			wsOpts.AllowedOrigins = []string{opts.AllowCORSOrigin}
+		}
+		mux.Handle(route(http.MethodGet, "/ws"), wsadapter.NewHandler(hub, wsOpts))
+	}
+
+	// Admin
+	if opts.DeadLetters != nil && opts.AdminToken != "" {
+		mux.Handle(route(http.MethodGet, "/admin/dead-letters"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			listDeadLetters(w, r, opts.DeadLetters)
+		})))
+	}
+	if opts.AdminToken != "" {
+		mux.Handle(route(http.MethodPut, "/admin/users/{id}/state"), requireToken(opts.AdminToken, resolveUser(svc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			replaceState(w, r, svc)
+		}))))
+		mux.Handle(route(http.MethodPost, "/admin/users/{id}/merge"), requireToken(opts.AdminToken, resolveUser(svc, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mergeUsers(w, r, svc)
+		}))))
+	}
+	if opts.AdminToken != "" && len(svc.IdentityKinds()) > 0 {
+		mux.Handle(route(http.MethodGet, "/admin/users/{id}/identities"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			listIdentities(w, r, svc)
+		})))
+		mux.Handle(route(http.MethodPut, "/admin/users/{id}/identities/{identity}"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			linkIdentity(w, r, svc)
+		})))
+		mux.Handle(route(http.MethodDelete, "/admin/users/{id}/identities/{identity}"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			unlinkIdentity(w, r, svc)
+		})))
+	}
+	if opts.AdminToken != "" {
+		mux.Handle(route(http.MethodGet, "/admin/users/query"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queryUsers(w, r, svc)
+		})))
+	}
+	if hub != nil && opts.AdminToken != "" {
+		mux.Handle(route(http.MethodGet, "/admin/connections"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			listConnections(w, r, hub)
+		})))
+	}
+	if hub != nil && opts.Debug && opts.AdminToken != "" {
+		mux.Handle(route(http.MethodPost, "/admin/debug/emit"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			emitEvent(w, r, hub)
+		})))
+	}
+	if opts.ReloadRules != nil && opts.AdminToken != "" {
+		mux.Handle(route(http.MethodPost, "/admin/rules/reload"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reloadRules(w, r, opts.ReloadRules)
+		})))
+	}
+	if opts.Events != nil && opts.AdminToken != "" {
+		mux.Handle(route(http.MethodGet, "/admin/events/export"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			exportEvents(w, r, opts.Events)
+		})))
+	}
+	if opts.ImportStorage != nil && opts.AdminToken != "" {
+		importStorage := opts.ImportStorage
+		if opts.RequireNamespace {
+			importStorage = engine.NamespacedStorage(importStorage)
+		}
+		mux.Handle(route(http.MethodPost, "/admin/import"), requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			importUsers(w, r, importStorage)
+		})))
+	}
+
+	// Leaderboards
+	if opts.Leaderboards != nil {
+		mux.HandleFunc(route(http.MethodGet, "/leaderboards/{name}"), leaderboardHandler(opts.Leaderboards, opts.MaxLeaderboardLimit))
+	}
+
+	// Leaderboard archives
+	if opts.LeaderboardArchive != nil {
+		mux.HandleFunc(route(http.MethodGet, "/leaderboard/archive/{period}"), func(w http.ResponseWriter, r *http.Request) {
+			period := r.PathValue("period")
+			standings, err := opts.LeaderboardArchive.GetArchive(r.Context(), period)
+			switch {
+			case errors.Is(err, leaderboard.ErrArchiveNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), errorStatus(err))
+				return
+			}
+			writeJSON(w, map[string]any{"period": period, "standings": standings})
+		})
 	}
 
 	// Users API
-	mux.HandleFunc(withPrefix(opts.PathPrefix, "/users/"), func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodPost {
-			http.NotFound(w, r)
+	handleUser(route(http.MethodPost, "/users/{id}/points"), func(w http.ResponseWriter, r *http.Request) {
+		metric, err := metrics.Resolve(r.URL.Query().Get("metric"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		parts := split(r.URL.Path, '/')
-		if len(parts) < 2 {
-			http.NotFound(w, r)
+		delta, _ := strconv.ParseInt(r.URL.Query().Get("delta"), 10, 64)
+		total, err := svc.AddPoints(r.Context(), core.UserID(r.PathValue("id")), metric, delta)
+		writeResult(w, map[string]any{"total": total, "err": errString(err)}, err)
+	})
+	handleUser(route(http.MethodPost, "/users/{id}/transfer"), func(w http.ResponseWriter, r *http.Request) {
+		transfer(w, r, svc, metrics)
+	})
+	handleUser(route(http.MethodPost, "/users/{id}/actions"), func(w http.ResponseWriter, r *http.Request) {
+		applyAction(w, r, svc, metrics)
+	})
+	handleUser(route(http.MethodPost, "/users/{id}/badges/{badge}"), func(w http.ResponseWriter, r *http.Request) {
+		awarded, err := svc.AwardBadgeResult(r.Context(), core.UserID(r.PathValue("id")), core.Badge(r.PathValue("badge")))
+		writeResult(w, map[string]any{"ok": err == nil, "newly_awarded": awarded, "err": errString(err)}, err)
+	})
+	// Past states replay the event log, so they are an admin tool rather than a public read
+	asOf := requireToken(opts.AdminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stateAsOf(w, r, svc, opts.History)
+	}))
+	handleUser(route(http.MethodGet, "/users/{id}"), func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("as_of") {
+			asOf.ServeHTTP(w, r)
 			return
 		}
-		user := core.UserID(parts[1])
-		switch r.Method {
-		case http.MethodPost:
-			if len(parts) >= 3 && parts[2] == "points" {
-				metric := core.Metric(r.URL.Query().Get("metric"))
-				if metric == "" {
-					metric = core.MetricXP
-				}
-				delta, _ := strconv.ParseInt(r.URL.Query().Get("delta"), 10, 64)
-				total, err := svc.AddPoints(r.Context(), user, metric, delta)
-				writeJSON(w, map[string]any{"total": total, "err": errString(err)})
-				return
-			}
-			if len(parts) >= 4 && parts[2] == "badges" {
-				badge := core.Badge(parts[3])
-				err := svc.AwardBadge(r.Context(), user, badge)
-				writeJSON(w, map[string]any{"ok": err == nil, "err": errString(err)})
-				return
-			}
-		case http.MethodGet:
-			st, err := svc.GetState(r.Context(), user)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, st)
+		if opts.NotFoundOnEmptyUser && !userFound(w, r, svc) {
+			return
+		}
+		st, err := svc.GetState(r.Context(), core.UserID(r.PathValue("id")))
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
 			return
 		}
-		http.NotFound(w, r)
+		resp := userResponse{UserState: st, Progress: svc.ProgressFor(st), Formatted: svc.FormatPoints(st)}
+		if expandsBadges(r) {
+			writeJSON(w, resp)
+			return
+		}
+		body, err := withoutBadges(resp)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, body)
+	})
+	handleUser(route(http.MethodGet, "/users/{id}/badges"), func(w http.ResponseWriter, r *http.Request) {
+		listBadges(w, r, svc)
+	})
+	handleUser(route(http.MethodGet, "/users/{id}/quests"), func(w http.ResponseWriter, r *http.Request) {
+		listQuests(w, r, svc)
 	})
+	handleUser(route(http.MethodGet, "/users/{id}/points/recent"), func(w http.ResponseWriter, r *http.Request) {
+		metric, err := metrics.Resolve(r.URL.Query().Get("metric"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		window, err := time.ParseDuration(r.URL.Query().Get("window"))
+		if err != nil || window <= 0 {
+			http.Error(w, "window must be a positive duration such as 24h", http.StatusBadRequest)
+			return
+		}
+		points, err := svc.PointsInWindow(r.Context(), core.UserID(r.PathValue("id")), metric, window)
+		switch {
+		case errors.Is(err, engine.ErrWindowUnsupported):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		case errors.Is(err, core.ErrWindowTooLong):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		writeJSON(w, map[string]any{"metric": metric, "window": window.String(), "points": points})
+	})
+	if opts.Timeline != nil {
+		handleUser(route(http.MethodGet, "/users/{id}/timeline"), timelineHandler(opts.Timeline))
+	}
+	handleUser(route(http.MethodGet, "/users/{id}/progress/{metric}"), func(w http.ResponseWriter, r *http.Request) {
+		if opts.NotFoundOnEmptyUser && !userFound(w, r, svc) {
+			return
+		}
+		p, ok, err := svc.GetProgress(r.Context(), core.UserID(r.PathValue("id")), core.Metric(r.PathValue("metric")))
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		if !ok {
+			http.Error(w, "metric has no level curve", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, p)
+	})
+
+	chain := []Middleware{RequestID()}
+	if opts.Tracer != nil {
+		chain = append(chain, Tracing(opts.Tracer, TracingOptions{Routes: mux, RedactUser: opts.Tracer.UserRedactor()}))
+	}
+	health := []string{withPrefix(opts.PathPrefix, "/healthz"), withPrefix(opts.PathPrefix, "/readyz")}
+	if opts.LogRequests {
+		access := opts.AccessLog
+		if access.SkipPaths == nil {
+			access.SkipPaths = []string{health[0], health[1], withPrefix(opts.PathPrefix, "/metrics")}
+		}
+		chain = append(chain, AccessLog(opts.Logger, access))
+	}
+	chain = append(chain, Recover(opts.Logger))
+	var cors Middleware
+	switch {
+	case opts.CORSOrigin != nil:
+		cors = func(next http.Handler) http.Handler { return withCORS(next, opts.CORSOrigin) }
+	case opts.AllowCORSOrigin != "":
+		origin := opts.AllowCORSOrigin
+		cors = func(next http.Handler) http.Handler { return withCORS(next, func() string { return origin }) }
+	}
+	public := newPublicRoutes(opts.PathPrefix, opts.PublicRoutes)
+	switch {
+	case public != nil:
+		chain = append(chain, publicCORS(public, cors))
+	case cors != nil:
+		chain = append(chain, cors)
+	}
+	if opts.Auth != nil {
+		chain = append(chain, exceptPublic(exceptPaths(opts.Auth, health...), public))
+	}
+	// shedding after Auth keeps requests Auth rejects from taking slots of authenticated clients
+	if opts.LoadShedder != nil {
+		chain = append(chain, exceptPaths(opts.LoadShedder.Middleware, health...))
+	}
+	if opts.RequireNamespace {
+		chain = append(chain, exceptPaths(Namespaces(opts.PathPrefix, opts.NamespaceResolver, opts.NamespaceAuthorizer), health...))
+	}
+	if opts.RateLimiter != nil {
+		chain = append(chain, opts.RateLimiter.Middleware)
+	}
+	chain = append(chain, exceptPaths(StaleReads(), health...))
+	if opts.Compress {
+		chain = append(chain, Compress())
+	}
+	if opts.Idempotency != nil {
+		chain = append(chain, Idempotency(opts.Idempotency, opts.IdempotencyTTL, opts.IdempotencyPrincipal))
+	}
+	chain = append(chain, opts.Middleware...)
+	return Chain(mux, chain...)
+}
 
-	var handler http.Handler = mux
-	if opts.AllowCORSOrigin != "" {
-		handler = withCORS(handler, opts.AllowCORSOrigin)
+// exceptPaths applies mw to every request except those for the given paths
+func exceptPaths(mw Middleware, paths ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range paths {
+				if r.URL.Path == p {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			wrapped.ServeHTTP(w, r)
+		})
 	}
-	return handler
+}
+
+// userResponse is a user's state extended with level progress and display values; Progress is
+// omitted when no metric has a curve.
+type userResponse struct {
+	core.UserState
+	Progress  map[core.Metric]engine.Progress       `json:"progress,omitempty"`
+	Formatted map[core.Metric]engine.FormattedValue `json:"formatted,omitempty"`
+}
+
+// MarshalJSON adds the extensions to the state's own encoding, which the embedded
+// core.UserState.MarshalJSON would otherwise replace the whole response with.
+func (u userResponse) MarshalJSON() ([]byte, error) {
+	state, err := json.Marshal(u.UserState)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := json.Marshal(struct {
+		Progress  map[core.Metric]engine.Progress       `json:"progress,omitempty"`
+		Formatted map[core.Metric]engine.FormattedValue `json:"formatted,omitempty"`
+	}{u.Progress, u.Formatted})
+	if err != nil || len(ext) == 2 {
+		return state, err
+	}
+	return append(append(state[:len(state)-1], ','), ext[1:]...), nil
 }
 
 // Helpers
@@ -97,6 +533,10 @@ func healthCheck(w http.ResponseWriter, r *http.Request, svc *engine.GamifyServi
 	// Verify storage works by trying to fetch a dummy user
 	// This is a safe, lightweight check that doesn't affect real data
 	dummyUser := core.UserID("healthcheck_probe")
+	// Health checks carry no namespace; probe a reserved one so namespaced storage answers too
+	if _, ok := core.NamespaceFromContext(ctx); !ok {
+		ctx = core.WithNamespace(ctx, "healthcheck")
+	}
 	_, err := svc.GetState(ctx, dummyUser)
 
 	status := map[string]any{
@@ -117,37 +557,236 @@ func healthCheck(w http.ResponseWriter, r *http.Request, svc *engine.GamifyServi
 	writeJSON(w, status)
 }
 
-func withPrefix(prefix, path string) string {
-	if prefix == "" || prefix == "/" {
-		return path
+// readyCheck reports whether the server should receive new traffic
+func readyCheck(w http.ResponseWriter, ready *atomic.Bool) {
+	if ready != nil && !ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		writeJSON(w, map[string]any{"status": "draining"})
+		return
 	}
-	if prefix[len(prefix)-1] == '/' {
-		return prefix[:len(prefix)-1] + path
+	writeJSON(w, map[string]any{"status": "ready"})
+}
+
+// listDeadLetters returns the dead letter count and the entries matching the query filter
+func listDeadLetters(w http.ResponseWriter, r *http.Request, store analytics.DeadLetterStore) {
+	filter := analytics.DeadLetterFilter{ID: r.URL.Query().Get("id"), Target: r.URL.Query().Get("target"), Limit: 50}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
 	}
-	return prefix + path
+	count, err := store.Count(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	letters, err := store.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, map[string]any{"count": count, "dead_letters": letters})
+}
+
+// listConnections returns the realtime client count and per-connection metadata
+func listConnections(w http.ResponseWriter, r *http.Request, hub *realtime.Hub) {
+	conns := hub.Connections()
+	writeJSON(w, map[string]any{"count": len(conns), "connections": conns})
+}
+
+// userFound answers 404 (or 500 on failure) unless the user in the path has stored data.
+func userFound(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) bool {
+	exists, err := svc.UserExists(r.Context(), core.UserID(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return false
+	}
+	if !exists {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// replaceState overwrites a user's state with the JSON body and answers with the stored result.
+func replaceState(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	var st core.UserState
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		http.Error(w, "invalid state: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := core.UserID(r.PathValue("id"))
+	err := svc.ReplaceState(r.Context(), user, st)
+	switch {
+	case errors.Is(err, engine.ErrReplaceUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case errors.Is(err, engine.ErrInvalidState):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	stored, err := svc.GetState(r.Context(), user)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, stored)
+}
+
+// emitEvent broadcasts the event in the body straight to the hub's clients, without touching
+// storage or the event bus, so rules, event logs and analytics never see it. The event is flagged
+// with core.MarkSynthetic and gets an ID and time when it has none.
+func emitEvent(w http.ResponseWriter, r *http.Request, hub *realtime.Hub) {
+	var e core.Event
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if e.Type == "" || e.UserID == "" {
+		http.Error(w, "event needs a type and a user_id", http.StatusBadRequest)
+		return
+	}
+	if e.ID == "" {
+		e.ID = core.NewEventID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e = core.MarkSynthetic(e)
+	hub.Broadcast(r.Context(), e)
+	writeJSON(w, e)
+}
+
+// transferRequest is the body of POST /users/{id}/transfer.
+type transferRequest struct {
+	To     core.UserID `json:"to"`
+	Metric core.Metric `json:"metric"`
+	Amount int64       `json:"amount"`
 }
 
-func split(p string, sep rune) []string {
-	var parts []string
-	cur := make([]rune, 0, len(p))
-	// trim leading '/'
-	for len(p) > 0 && p[0] == '/' {
-		p = p[1:]
+// transfer moves points from the path user to the body's receiver and reports the sender's new balance.
+func transfer(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService, metrics MetricPolicy) {
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid transfer: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-	for _, r := range p {
-		if r == sep {
-			if len(cur) > 0 {
-				parts = append(parts, string(cur))
-				cur = cur[:0]
+	metric, err := metrics.Resolve(string(req.Metric))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Metric = metric
+	if strings.Contains(string(req.To), ":") {
+		if req.To, err = svc.ResolveUser(r.Context(), string(req.To)); err != nil {
+			http.Error(w, err.Error(), identityStatus(err))
+			return
+		}
+	}
+	from := core.UserID(r.PathValue("id"))
+	err = svc.Transfer(r.Context(), from, req.To, req.Metric, req.Amount)
+	switch {
+	case errors.Is(err, core.ErrInvalidAmount), errors.Is(err, core.ErrSelfTransfer), errors.Is(err, engine.ErrUnknownMetric), errors.Is(err, engine.ErrDerivedMetric),
+		errors.Is(err, core.ErrReservedMetric):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, core.ErrInsufficientPoints), errors.Is(err, core.ErrReceiverLimit), errors.Is(err, engine.ErrLimitExceeded):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	st, err := svc.GetState(r.Context(), from)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "balance": st.Points[req.Metric]})
+}
+
+// maxImportFailures caps the failed rows an import response lists; the summary counts them all
+const maxImportFailures = 100
+
+// importUsers streams the request body through the importer and reports the summary and failed rows.
+
+func importUsers(w http.ResponseWriter, r *http.Request, storage engine.Storage) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+			format = "csv"
+		}
+	}
+	var (
+		src importer.Source
+		err error
+	)
+	switch format {
+	case "csv":
+		src, err = importer.NewCSVSource(r.Body)
+	case "json":
+		src, err = importer.NewJSONSource(r.Body)
+	default:
+		err = errors.New("format must be csv or json")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(q.Get("dry_run"))
+	failures, omitted := []importer.Result{}, 0
+	sum, err := importer.Run(r.Context(), storage, src, importer.Options{
+		DryRun: dryRun,
+		Report: func(res importer.Result) {
+			switch {
+			case res.Err == "":
+			case len(failures) < maxImportFailures:
+				failures = append(failures, res)
+			default:
+				omitted++
 			}
-			continue
+		},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]any{"summary": sum, "failures": failures, "failures_omitted": omitted})
+}
+
+// requireToken rejects requests without the bearer token. It fails closed: with an empty token
+// the route answers 404, as if it were not registered.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
 		}
-		cur = append(cur, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withPrefix(prefix, path string) string {
+	if prefix == "" || prefix == "/" {
+		return path
 	}
-	if len(cur) > 0 {
-		parts = append(parts, string(cur))
+	if prefix[len(prefix)-1] == '/' {
+		return prefix[:len(prefix)-1] + path
 	}
-	return parts
+	return prefix + path
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
@@ -155,6 +794,18 @@ func writeJSON(w http.ResponseWriter, v any) {
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// writeResult sends v, a body that carries err itself, with 200 unless the request's deadline
+// passed or it was cancelled, which gets errorStatus so clients and proxies see the timeout
+func writeResult(w http.ResponseWriter, v any, err error) {
+	if err != nil && core.IsContextDone(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(errorStatus(err))
+		_ = json.NewEncoder(w).Encode(v)
+		return
+	}
+	writeJSON(w, v)
+}
+
 func errString(err error) any {
 	if err == nil {
 		return nil
@@ -163,8 +814,13 @@ func errString(err error) any {
 }
 
 // withCORS wraps a handler with a minimal CORS policy.
-func withCORS(next http.Handler, origin string) http.Handler {
+func withCORS(next http.Handler, originFn func() string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := originFn()
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Vary", "Origin")
 		if r.Method == http.MethodOptions {
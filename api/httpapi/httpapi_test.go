@@ -0,0 +1,1687 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	mem "gamifykit/adapters/memory"
+	"gamifykit/adapters/storagetest"
+	"gamifykit/analytics"
+	"gamifykit/core"
+	"gamifykit/engine"
+	"gamifykit/leaderboard"
+	"gamifykit/realtime"
+	"gamifykit/tracing"
+
+	gorillaws "github.com/gorilla/websocket"
+)
+
+func newTestService(opts ...engine.ServiceOption) *engine.GamifyService {
+	return engine.NewGamifyService(mem.New(), engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine(), opts...)
+}
+
+func TestReadyzFollowsLifecycle(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+	h := NewMux(newTestService(), nil, Options{PathPrefix: "/api", Ready: &ready})
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get("/api/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz before shutdown: got %d", code)
+	}
+
+	ready.Store(false)
+	if code := get("/api/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while draining: got %d", code)
+	}
+	if code := get("/api/healthz"); code != http.StatusOK {
+		t.Fatalf("healthz must stay 200 while draining: got %d", code)
+	}
+}
+
+func TestReadyzWithoutFlag(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewMux(newTestService(), nil, Options{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("readyz without a flag should be 200, got %d", rec.Code)
+	}
+}
+
+func TestUserProgress(t *testing.T) {
+	svc := newTestService(engine.WithDerivedLevels("coins", engine.MustLinearCurve(100)))
+	if _, err := svc.AddPoints(context.Background(), "alice", "coins", 150); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	var body struct {
+		Points   map[string]int64
+		Progress map[string]engine.Progress
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Points["coins"] != 150 || body.Progress["coins"].Fraction != 0.5 || body.Progress["coins"].NextLevelAt != 200 {
+		t.Fatalf("unexpected body %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice/progress/xp", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("metric without a curve: got %d", rec.Code)
+	}
+}
+
+func TestNotFoundOnEmptyUser(t *testing.T) {
+	svc := newTestService()
+	if _, err := svc.AddPoints(context.Background(), "alice", "xp", 5); err != nil {
+		t.Fatal(err)
+	}
+	get := func(h http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := get(NewMux(svc, nil, Options{}), "/users/ghost"); code != http.StatusOK {
+		t.Fatalf("default keeps empty state: got %d", code)
+	}
+	strict := NewMux(svc, nil, Options{NotFoundOnEmptyUser: true})
+	if code := get(strict, "/users/ghost"); code != http.StatusNotFound {
+		t.Fatalf("unknown user: got %d", code)
+	}
+	if code := get(strict, "/users/alice"); code != http.StatusOK {
+		t.Fatalf("stored user: got %d", code)
+	}
+}
+
+func TestLeaderboardArchive(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	board := leaderboard.NewWindowedBoard("xp", leaderboard.Daily, func(string) leaderboard.Board { return leaderboard.NewSkipList() },
+		leaderboard.WithArchive(leaderboard.NewMemoryArchive(), 10), leaderboard.WithPeriodClock(func() time.Time { return now }))
+	board.Update("alice", 7)
+	now = now.Add(24 * time.Hour)
+	board.Update("bob", 1)
+	// rollovers archive in the background; Rotate waits for them
+	if err := board.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(newTestService(), nil, Options{LeaderboardArchive: board})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboard/archive/2024-03-09", nil))
+	var body struct{ Standings []leaderboard.Standing }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Standings) != 1 || body.Standings[0].User != "alice" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboard/archive/2024-03-10", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("running period: got %d", rec.Code)
+	}
+
+	// 2024-03-11 ends with no entries and is served as empty standings, not 404
+	now = now.Add(24 * time.Hour)
+	_ = board.Rotate(context.Background())
+	now = now.Add(24 * time.Hour)
+	if err := board.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboard/archive/2024-03-11", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"standings":[]`) {
+		t.Fatalf("empty period: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestLeaderboardLimit(t *testing.T) {
+	board := leaderboard.NewSkipList()
+	for i := 0; i < 150; i++ {
+		board.Update(core.UserID(fmt.Sprintf("u%03d", i)), int64(i))
+	}
+	h := NewMux(newTestService(), nil, Options{Leaderboards: map[string]leaderboard.Board{"xp": board}})
+	get := func(query string) (int, int) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboards/xp"+query, nil))
+		var body struct{ Standings []leaderboard.Standing }
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, len(body.Standings)
+	}
+
+	for query, want := range map[string]int{"": DefaultLeaderboardLimit, "?limit=1": 1, "?limit=100": 100} {
+		if code, n := get(query); code != http.StatusOK || n != want {
+			t.Fatalf("%q: got %d with %d entries, want %d entries", query, code, n, want)
+		}
+	}
+	for _, query := range []string{"?limit=101", "?limit=1000000", "?limit=0", "?limit=-3", "?limit=ten"} {
+		if code, _ := get(query); code != http.StatusBadRequest {
+			t.Fatalf("%q: got %d, want 400", query, code)
+		}
+	}
+
+	h = NewMux(newTestService(), nil, Options{Leaderboards: map[string]leaderboard.Board{"xp": board}, MaxLeaderboardLimit: 120})
+	if code, n := get("?limit=120"); code != http.StatusOK || n != 120 {
+		t.Fatalf("configured max: got %d with %d entries", code, n)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboards/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown board: got %d", rec.Code)
+	}
+}
+
+func TestLeaderboardReportsSnapshot(t *testing.T) {
+	board := leaderboard.NewSkipList()
+	board.Update("alice", 10)
+	cached := leaderboard.NewCachedBoard(board, leaderboard.WithSnapshotInterval(time.Hour))
+	h := NewMux(newTestService(), nil, Options{Leaderboards: map[string]leaderboard.Board{"live": board, "cached": cached}})
+	get := func(name string) (bool, time.Time) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderboards/"+name, nil))
+		var body struct {
+			Cached bool      `json:"cached"`
+			AsOf   time.Time `json:"as_of"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Cached, body.AsOf
+	}
+
+	if live, _ := get("live"); live {
+		t.Fatal("plain board reported as cached")
+	}
+	isCached, first := get("cached")
+	board.Update("bob", 20) // bypasses the cache, so the snapshot stays as it is
+	if again, second := get("cached"); !isCached || !again || !second.Equal(first) {
+		t.Fatalf("cached board: cached=%v/%v as_of %v then %v", isCached, again, first, second)
+	}
+}
+
+func TestUserTimeline(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService()
+	timeline := analytics.NewTimeline(0)
+	svc.SubscribeAllNamed("timeline", func(_ context.Context, e core.Event) { timeline.OnEvent(e) })
+	if _, err := svc.AddPoints(ctx, "alice", "xp", 150); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.AwardBadge(ctx, "alice", "explorer"); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{Timeline: timeline})
+	get := func(query string) (*httptest.ResponseRecorder, analytics.TimelinePage) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice/timeline"+query, nil))
+		var page analytics.TimelinePage
+		_ = json.Unmarshal(rec.Body.Bytes(), &page)
+		return rec, page
+	}
+
+	rec, page := get("?limit=1")
+	if rec.Code != http.StatusOK || len(page.Entries) != 1 || page.Entries[0].Kind != analytics.TimelineBadge || page.NextCursor == "" {
+		t.Fatalf("first page: %d %s", rec.Code, rec.Body.String())
+	}
+	var kinds []string
+	for cursor := page.NextCursor; cursor != ""; cursor = page.NextCursor {
+		if rec, page = get("?limit=1&cursor=" + cursor); rec.Code != http.StatusOK {
+			t.Fatalf("next page: %d %s", rec.Code, rec.Body.String())
+		}
+		for _, e := range page.Entries {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	if want := []string{analytics.TimelineLevel, analytics.TimelinePoints}; fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Fatalf("older entries = %v, want %v", kinds, want)
+	}
+	for _, query := range []string{"?limit=0", "?limit=1000", "?cursor=%21%21"} {
+		if rec, _ := get(query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: got %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// eventSlice is an in-memory analytics.EventLog
+type eventSlice []core.Event
+
+func (s *eventSlice) Replay(_ context.Context, fn func(core.Event) error) error {
+	for _, e := range *s {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUserStateAsOf(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService()
+	var log eventSlice
+	svc.SubscribeAllNamed("log", func(_ context.Context, e core.Event) { log = append(log, e) })
+	if _, err := svc.AddPoints(ctx, "alice", "xp", 40); err != nil {
+		t.Fatal(err)
+	}
+	between := time.Now().UTC()
+	time.Sleep(time.Millisecond)
+	if _, err := svc.AddPoints(ctx, "alice", "xp", 60); err != nil {
+		t.Fatal(err)
+	}
+	get := func(h http.Handler, asOf string) (*httptest.ResponseRecorder, core.UserState) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/users/alice?as_of="+url.QueryEscape(asOf), nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		h.ServeHTTP(rec, req)
+		var st core.UserState
+		_ = json.Unmarshal(rec.Body.Bytes(), &st)
+		return rec, st
+	}
+
+	h := NewMux(svc, nil, Options{History: analytics.NewStateHistory(&log), NotFoundOnEmptyUser: true, AdminToken: "secret"})
+	if rec, st := get(h, between.Format(time.RFC3339Nano)); rec.Code != http.StatusOK || st.Points["xp"] != 40 {
+		t.Fatalf("as of between the writes: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, st := get(h, "2000-01-01T00:00:00Z"); rec.Code != http.StatusOK || len(st.Points) != 0 {
+		t.Fatalf("before the user existed: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := get(h, "yesterday"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("malformed as_of: got %d, want 400", rec.Code)
+	}
+	if rec, _ := get(NewMux(svc, nil, Options{AdminToken: "secret"}), between.Format(time.RFC3339)); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("without history: got %d, want 501", rec.Code)
+	}
+	if rec, _ := get(NewMux(svc, nil, Options{History: analytics.NewStateHistory(&log)}), between.Format(time.RFC3339)); rec.Code != http.StatusNotFound {
+		t.Fatalf("without an admin token: got %d, want 404", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice?as_of="+url.QueryEscape(between.Format(time.RFC3339Nano)), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("as_of without the token: got %d, want 401", rec.Code)
+	}
+}
+
+func TestWebSocketOriginFollowsCORSOrigin(t *testing.T) {
+	var origin atomic.Pointer[string]
+	first := "https://app.example.com"
+	origin.Store(&first)
+	srv := httptest.NewServer(NewMux(newTestService(), realtime.NewHub(), Options{CORSOrigin: func() string { return *origin.Load() }}))
+	defer srv.Close()
+	dial := func(from string) int {
+		conn, resp, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", http.Header{"Origin": {from}})
+		if err == nil {
+			conn.Close()
+			return http.StatusSwitchingProtocols
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := dial("https://app.example.com"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("CORS origin: got %d", code)
+	}
+	next := "https://new.example.com"
+	origin.Store(&next)
+	if code := dial("https://app.example.com"); code != http.StatusForbidden {
+		t.Fatalf("origin removed at runtime: got %d, want 403", code)
+	}
+	if code := dial("https://new.example.com"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("origin added at runtime: got %d", code)
+	}
+}
+
+func TestDebugEmit(t *testing.T) {
+	hub := realtime.NewHub()
+	_, events := hub.Subscribe(1)
+	post := func(opts Options, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/debug/emit", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		NewMux(newTestService(), hub, opts).ServeHTTP(rec, req)
+		return rec.Code
+	}
+	levelUp := `{"type": "level_up", "user_id": "alice", "metric": "xp", "level": 7}`
+	if code := post(Options{AdminToken: "secret"}, levelUp); code != http.StatusNotFound {
+		t.Fatalf("without debug: got %d, want 404", code)
+	}
+	if code := post(Options{AdminToken: "secret", Debug: true}, `{"user_id": "alice"}`); code != http.StatusBadRequest {
+		t.Fatalf("event without a type: got %d, want 400", code)
+	}
+	if code := post(Options{AdminToken: "secret", Debug: true}, levelUp); code != http.StatusOK {
+		t.Fatalf("debug emit: got %d", code)
+	}
+	select {
+	case e := <-events:
+		if e.Type != core.EventLevelUp || e.Level != 7 || e.ID == "" || !e.Synthetic() {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	default:
+		t.Fatal("event not broadcast")
+	}
+}
+
+func TestReplaceStateRoute(t *testing.T) {
+	svc := newTestService()
+	if _, err := svc.AddPoints(context.Background(), "alice", "xp", 5); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{AdminToken: "secret"})
+	put := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/admin/users/alice/state", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if code := put("wrong", `{}`).Code; code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d", code)
+	}
+	if code := put("secret", `{"badges": {"not valid": {}}}`).Code; code != http.StatusBadRequest {
+		t.Fatalf("invalid badge: got %d", code)
+	}
+	rec := put("secret", `{"points": {"coins": 7}, "badges": {"restored": {}}}`)
+	var st struct {
+		Points map[string]int64
+		Badges map[string]struct{}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || len(st.Points) != 1 || st.Points["coins"] != 7 || len(st.Badges) != 1 {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMergeUsersRoute(t *testing.T) {
+	svc := newTestService()
+	ctx := context.Background()
+	for user, points := range map[core.UserID]map[core.Metric]int64{"guest": {"xp": 40, "best": 90}, "alice": {"xp": 30, "best": 70}} {
+		for metric, v := range points {
+			if _, err := svc.AddPoints(ctx, user, metric, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	h := NewMux(svc, nil, Options{AdminToken: "secret"})
+	post := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/alice/merge", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if code := post("wrong", `{"source": "guest"}`).Code; code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d", code)
+	}
+	for _, body := range []string{`{}`, `{"source": "guest", "points": "avg"}`, `{"source": "alice"}`} {
+		if code := post("secret", body).Code; code != http.StatusBadRequest {
+			t.Fatalf("%s: got %d, want 400", body, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		rec := post("secret", `{"source": "guest", "metrics": {"best": "max"}}`)
+		var st struct{ Points map[string]int64 }
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || rec.Code != http.StatusOK || st.Points["xp"] != 70 || st.Points["best"] != 90 {
+			t.Fatalf("merge %d: unexpected response %d %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	if exists, _ := svc.UserExists(ctx, "guest"); exists {
+		t.Fatal("source still exists after the merge")
+	}
+}
+
+func TestQueryUsersRoute(t *testing.T) {
+	svc := newTestService()
+	ctx := context.Background()
+	for user, xp := range map[core.UserID]int64{"alice": 1500, "bob": 800, "carol": 2000} {
+		if _, err := svc.AddPoints(ctx, user, "xp", xp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svc.AwardBadge(ctx, "bob", "beta-tester"); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{AdminToken: "secret"})
+	get := func(query string) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodGet, "/admin/users/query?"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		var body map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+
+	if code, body := get("badge=beta-tester"); code != http.StatusOK || fmt.Sprint(body["users"]) != "[bob]" {
+		t.Fatalf("badge query: %d %v", code, body)
+	}
+	code, body := get("metric=xp&min=1000&limit=1")
+	if code != http.StatusOK || fmt.Sprint(body["users"]) != "[alice]" || body["next"] != "alice" {
+		t.Fatalf("first page: %d %v", code, body)
+	}
+	if code, body := get("metric=xp&min=1000&limit=1&after=alice"); code != http.StatusOK || fmt.Sprint(body["users"]) != "[carol]" {
+		t.Fatalf("second page: %d %v", code, body)
+	}
+	if code, _ := get("min=1000"); code != http.StatusBadRequest {
+		t.Fatalf("points condition without metric: got %d, want 400", code)
+	}
+}
+
+type spanRecorder struct{ spans []tracing.SpanData }
+
+func (r *spanRecorder) Export(_ context.Context, spans []tracing.SpanData) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	exp := &spanRecorder{}
+	tracer := tracing.NewTracer(tracing.Options{SampleRate: 0, Exporter: exp})
+	tracing.SetDefault(tracer)
+	defer tracing.SetDefault(nil)
+	h := NewMux(newTestService(), nil, Options{Tracer: tracer})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/alice/points?delta=5", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("add points: got %d", rec.Code)
+	}
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]tracing.SpanData{}
+	for _, s := range exp.spans {
+		byName[s.Name] = s
+		if s.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s left the incoming trace", s.Name)
+		}
+	}
+	server, ok := byName["HTTP POST"]
+	if !ok || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span missing or not a child of the caller: %+v", exp.spans)
+	}
+	if op, ok := byName["engine.AddPoints"]; !ok || op.Parent != server.Context.SpanID {
+		t.Fatalf("engine span missing or not a child of the server span: %+v", exp.spans)
+	}
+	attrs := map[string]any{}
+	for _, a := range server.Attrs {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["http.route"] != "/users/{id}/points" {
+		t.Errorf("http.route = %v, want the route pattern", attrs["http.route"])
+	}
+	if _, ok := attrs["http.target"]; ok {
+		t.Errorf("server span records the raw path %v without a RedactUser", attrs["http.target"])
+	}
+}
+
+func TestReloadRulesRoute(t *testing.T) {
+	rules := engine.NewSwappableRuleEngine(engine.DefaultRuleEngine())
+	svc := engine.NewGamifyService(mem.New(), engine.NewEventBus(engine.DispatchSync), rules)
+	config := `{"metrics": ["xp"], "badges": [{"metric": "xp", "tiers": [{"badge": "starter", "points": 10}]}]}`
+	h := NewMux(svc, nil, Options{AdminToken: "secret", ReloadRules: func(context.Context) error {
+		rs, err := engine.CompileRules([]byte(config))
+		if err != nil {
+			return err
+		}
+		rules.Swap(rs)
+		return nil
+	}})
+	reload := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/rules/reload", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := reload(); code != http.StatusOK {
+		t.Fatalf("reload: got %d, want 200", code)
+	}
+	if _, err := svc.AddPoints(context.Background(), "alice", "xp", 10); err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := svc.GetState(context.Background(), "alice"); len(st.Badges) != 1 {
+		t.Fatalf("reloaded rules did not award the tier badge: %+v", st.Badges)
+	}
+
+	config = `{"metrics": ["xp"], "badges": [{"metric": "gems", "tiers": [{"badge": "starter", "points": 10}]}]}`
+	if code := reload(); code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid rules: got %d, want 422", code)
+	}
+}
+
+func TestTransferRoute(t *testing.T) {
+	svc := newTestService()
+	if _, err := svc.AddPoints(context.Background(), "alice", "coins", 10); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{})
+	post := func(from, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users/"+from+"/transfer", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := post("alice", `{"to": "bob", "metric": "coins", "amount": 4}`)
+	var body struct{ Balance int64 }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK || body.Balance != 6 {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	for body, want := range map[string]int{
+		`{"to": "bob", "metric": "coins", "amount": 7}`:   http.StatusConflict,
+		`{"to": "bob", "metric": "coins", "amount": 0}`:   http.StatusBadRequest,
+		`{"to": "alice", "metric": "coins", "amount": 1}`: http.StatusBadRequest,
+		`not json`: http.StatusBadRequest,
+	} {
+		if code := post("alice", body).Code; code != want {
+			t.Errorf("%s: want %d got %d", body, want, code)
+		}
+	}
+}
+
+func TestRecentPoints(t *testing.T) {
+	svc := newTestService()
+	if _, err := svc.AddPoints(context.Background(), "alice", "xp", 25); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/users/alice/points/recent?metric=xp&window=24h")
+	var body struct{ Points int64 }
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Points != 25 {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if code := get("/users/alice/points/recent?window=soon").Code; code != http.StatusBadRequest {
+		t.Fatalf("invalid window: got %d", code)
+	}
+	if code := get("/users/alice/points/recent?window=9999h").Code; code != http.StatusBadRequest {
+		t.Fatalf("window beyond retention: got %d", code)
+	}
+}
+
+func TestAdminImport(t *testing.T) {
+	store := mem.New()
+	svc := engine.NewGamifyService(store, engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	h := NewMux(svc, nil, Options{AdminToken: "secret", ImportStorage: store})
+
+	post := func(query, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/import"+query, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	csv := "id,points.xp,badges\nalice,40,onboarded\nbob,oops,\n"
+	if code := post("?format=csv", "wrong", csv).Code; code != http.StatusUnauthorized {
+		t.Fatalf("bad token: got %d", code)
+	}
+	rec := post("?format=csv&dry_run=true", "secret", csv)
+	var body struct {
+		Summary  struct{ Rows, Succeeded, Failed, Changed int }
+		Failures []struct{ Row int }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	if body.Summary.Rows != 2 || body.Summary.Failed != 1 || len(body.Failures) != 1 || body.Failures[0].Row != 2 {
+		t.Fatalf("unexpected dry run result %s", rec.Body.String())
+	}
+	if st, _ := svc.GetState(context.Background(), "alice"); len(st.Points) != 0 {
+		t.Fatalf("dry run wrote %v", st.Points)
+	}
+
+	post("", "secret", `[{"id": "alice", "points": {"xp": 40}}]`)
+	if st, _ := svc.GetState(context.Background(), "alice"); st.Points["xp"] != 40 {
+		t.Fatalf("import not applied: %v", st.Points)
+	}
+	if code := post("?format=xml", "secret", "").Code; code != http.StatusBadRequest {
+		t.Fatalf("unknown format: got %d", code)
+	}
+
+	// the response lists a bounded number of failed rows and counts the rest
+	var bad strings.Builder
+	bad.WriteString("id,points.xp\n")
+	for i := 0; i < maxImportFailures+5; i++ {
+		fmt.Fprintf(&bad, "u%d,oops\n", i)
+	}
+	rec = post("?format=csv&dry_run=true", "secret", bad.String())
+	var capped struct {
+		Summary         struct{ Failed int }
+		Failures        []json.RawMessage
+		FailuresOmitted int `json:"failures_omitted"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &capped); err != nil {
+		t.Fatal(err)
+	}
+	if capped.Summary.Failed != maxImportFailures+5 || len(capped.Failures) != maxImportFailures || capped.FailuresOmitted != 5 {
+		t.Fatalf("failed %d, listed %d, omitted %d", capped.Summary.Failed, len(capped.Failures), capped.FailuresOmitted)
+	}
+}
+
+func TestAdminImportNamespaced(t *testing.T) {
+	store := mem.New()
+	svc := engine.NewGamifyService(engine.NamespacedStorage(store), engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	byHeader := func(r *http.Request) (core.Namespace, error) { return core.Namespace(r.Header.Get("X-Game")), nil }
+	h := NewMux(svc, nil, Options{AdminToken: "secret", ImportStorage: store, RequireNamespace: true, NamespaceResolver: byHeader})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(`[{"id": "alice", "points": {"xp": 40}}]`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Game", "g1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: %d %s", rec.Code, rec.Body)
+	}
+	if st, _ := store.GetState(context.Background(), "alice"); len(st.Points) != 0 {
+		t.Fatalf("import wrote the unscoped user: %v", st.Points)
+	}
+	if st, _ := store.GetState(context.Background(), "g1:alice"); st.Points[core.MetricXP] != 40 {
+		t.Fatalf("import did not reach the namespace: %v", st.Points)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	h := NewMux(newTestService(), nil, Options{PathPrefix: "/api"})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		code   int
+		body   string
+	}{
+		{"add points", http.MethodPost, "/api/users/alice/points?delta=5", http.StatusOK, `"total":5`},
+		{"encoded slash stays in id", http.MethodPost, "/api/users/alice%2Fbob/points?delta=7", http.StatusOK, `"total":7`},
+		{"award badge", http.MethodPost, "/api/users/alice/badges/first", http.StatusOK, `"newly_awarded":true`},
+		{"award badge again", http.MethodPost, "/api/users/alice/badges/first", http.StatusOK, `"newly_awarded":false`},
+		{"get user", http.MethodGet, "/api/users/alice%2Fbob", http.StatusOK, `"user_id":"alice/bob"`},
+		{"wrong method on user", http.MethodDelete, "/api/users/alice", http.StatusMethodNotAllowed, ""},
+		{"wrong method on points", http.MethodGet, "/api/users/alice/points", http.StatusMethodNotAllowed, ""},
+		{"trailing slash", http.MethodGet, "/api/users/alice/", http.StatusNotFound, ""},
+		{"unknown sub-resource", http.MethodPost, "/api/users/alice/unknown", http.StatusNotFound, ""},
+		{"missing prefix", http.MethodGet, "/users/alice", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+			if rec.Code != tc.code {
+				t.Fatalf("got %d, want %d (%s)", rec.Code, tc.code, rec.Body.String())
+			}
+			if tc.body != "" && !strings.Contains(rec.Body.String(), tc.body) {
+				t.Fatalf("body %q does not contain %q", rec.Body.String(), tc.body)
+			}
+		})
+	}
+}
+
+// FuzzUserIDRoundTrip checks that any user ID, once path-escaped, is extracted unchanged.
+func FuzzUserIDRoundTrip(f *testing.F) {
+	for _, seed := range []string{"alice", "alice/bob", "Ünïcödé", "a b", "100%", "?x=1", "#frag", "a//b"} {
+		f.Add(seed)
+	}
+	h := NewMux(newTestService(), nil, Options{PathPrefix: "/api"})
+
+	f.Fuzz(func(t *testing.T, id string) {
+		// dot segments are resolved by path cleaning, and ServeMux cannot route an ID made only of slashes
+		if id == "." || id == ".." || strings.Trim(id, "/") == "" || !utf8.ValidString(id) {
+			t.Skip()
+		}
+		req := &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/api/users/" + id, RawPath: "/api/users/" + url.PathEscape(id)}, Header: http.Header{}}
+		req = req.WithContext(context.Background())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %q: got %d", req.URL.EscapedPath(), rec.Code)
+		}
+		var st struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		if st.UserID != id {
+			t.Fatalf("extracted %q, want %q", st.UserID, id)
+		}
+	})
+}
+
+// FuzzArbitraryPaths feeds raw paths and methods to the router; it must never panic or answer 5xx.
+func FuzzArbitraryPaths(f *testing.F) {
+	for _, seed := range []string{"/api/users/x/points", "//api//users", "/api/users/%zz", "/api/../etc", "/api/users/a/badges/b/c", "/", ""} {
+		f.Add(http.MethodPost, seed)
+	}
+	h := NewMux(newTestService(), nil, Options{PathPrefix: "/api"})
+
+	f.Fuzz(func(t *testing.T, method, path string) {
+		u, err := url.Parse(path)
+		if err != nil || method == "" || strings.ContainsAny(method, " \t\r\n") {
+			t.Skip()
+		}
+		req := (&http.Request{Method: method, URL: u, Header: http.Header{}}).WithContext(context.Background())
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code >= 500 {
+			t.Fatalf("%s %q: got %d", method, path, rec.Code)
+		}
+	})
+}
+
+func TestRecoverFromPanickingRoute(t *testing.T) {
+	var logs strings.Builder
+	boom := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/boom" {
+				panic("deliberate")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	srv := httptest.NewServer(NewMux(newTestService(), nil, Options{
+		PathPrefix: "/api",
+		Logger:     slog.New(slog.NewTextHandler(&logs, nil)),
+		Middleware: []Middleware{boom},
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/boom", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || body["error"] == "" || body["request_id"] != "req-42" {
+		t.Fatalf("want 500 envelope, got %d %v", resp.StatusCode, body)
+	}
+	if !strings.Contains(logs.String(), "request_id=req-42") || !strings.Contains(logs.String(), "deliberate") {
+		t.Fatalf("panic not logged with request ID:\n%s", logs.String())
+	}
+
+	resp, err = http.Get(srv.URL + "/api/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("server should keep serving after a panic, got %d", resp.StatusCode)
+	}
+}
+
+func TestUserActions(t *testing.T) {
+	h := NewMux(newTestService(), nil, Options{})
+	post := func(body string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users/alice/actions", strings.NewReader(body)))
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+	quest := `{"operations":[{"metric":"xp","delta":100},{"metric":"coins","delta":50},{"badge":"quest-done"}],
+		"conditions":[{"without_badge":"quest-done"},{"metric":"xp","max":1000}]}`
+
+	rec, out := post(quest)
+	if rec.Code != http.StatusOK || out["applied"] != true {
+		t.Fatalf("first completion: %d %s", rec.Code, rec.Body)
+	}
+	ops := out["operations"].([]any)
+	if len(ops) != 3 || ops[0].(map[string]any)["total"] != 100.0 || ops[2].(map[string]any)["applied"] != true {
+		t.Fatalf("operations = %v", ops)
+	}
+	state := out["state"].(map[string]any)
+	if state["points"].(map[string]any)["coins"] != 50.0 {
+		t.Fatalf("state = %v", state)
+	}
+
+	rec, out = post(quest)
+	if rec.Code != http.StatusOK || out["applied"] != false {
+		t.Fatalf("repeat: %d %s", rec.Code, rec.Body)
+	}
+
+	for _, body := range []string{
+		`{`,
+		`{"operations":[]}`,
+		`{"operations":[{"metric":"xp"}]}`,
+		`{"operations":[{"badge":"b","delta":1}]}`,
+		`{"operations":[{"badge":"has space"}]}`,
+		`{"operations":[{"delta":1}],"conditions":[{"metric":"xp"}]}`,
+		`{"operations":[{"delta":1}],"conditions":[{"badge":"a","without_badge":"b"}]}`,
+		`{"operations":[{"delta":1}],"conditions":[{"metric":"xp","min":5,"max":1}]}`,
+		`{"operations":[{"delta":1}],"idempotency_key":"k"}`,
+	} {
+		rec, out := post(body)
+		if rec.Code != http.StatusBadRequest || out["error"] == nil {
+			t.Errorf("%s: want a 400 error envelope, got %d %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestEnrichEventTagsRequestID(t *testing.T) {
+	svc := newTestService(engine.WithEventEnricher(EnrichEvent))
+	var got []core.Event
+	svc.SubscribeAllNamed("test", func(_ context.Context, e core.Event) { got = append(got, e) })
+	h := NewMux(svc, nil, Options{})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/alice/points?delta=5", nil)
+	req.Header.Set(RequestIDHeader, "req-7")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	// points_added and level_up
+	if rec.Code != http.StatusOK || len(got) != 2 {
+		t.Fatalf("add points: got %d with events %+v", rec.Code, got)
+	}
+	for _, e := range got {
+		if e.Metadata["request_id"] != "req-7" || e.Metadata["namespace"] != nil {
+			t.Fatalf("%s metadata = %v", e.Type, e.Metadata)
+		}
+	}
+
+	// events published outside a request are left alone
+	svc.Publish(context.Background(), core.NewBadgeAwarded("alice", "b"))
+	if got[2].Metadata != nil {
+		t.Fatalf("metadata outside a request = %v", got[2].Metadata)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var logs strings.Builder
+	h := NewMux(newTestService(), nil, Options{
+		PathPrefix:  "/api",
+		Logger:      slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		LogRequests: true,
+		AccessLog: AccessLogOptions{
+			Level:      slog.LevelDebug,
+			RedactUser: func(u core.UserID) string { return "u-" + string(u[:1]) },
+		},
+	})
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/users/alice%2Fx/points?delta=5", nil),
+		httptest.NewRequest(http.MethodGet, "/api/users/bob/trophies", nil),
+		httptest.NewRequest(http.MethodGet, "/api/healthz", nil),
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 access log records (health check skipped), got:\n%s", logs.String())
+	}
+	for i, want := range []string{"path=/api/users/u-a/points status=200", "path=/api/users/u-b/trophies status=404"} {
+		if !strings.Contains(lines[i], "level=DEBUG") || !strings.Contains(lines[i], "method=") || !strings.Contains(lines[i], want) ||
+			!strings.Contains(lines[i], "bytes=") || !strings.Contains(lines[i], "duration=") {
+			t.Errorf("record %d = %q, want it to contain %q", i, lines[i], want)
+		}
+	}
+	if strings.Contains(logs.String(), "alice") || strings.Contains(logs.String(), "bob") {
+		t.Fatalf("user IDs leaked into the access log:\n%s", logs.String())
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	svc := newTestService()
+	h := NewMux(svc, nil, Options{Idempotency: NewMemoryIdempotencyStore()})
+	post := func(key, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := post("k1", "/users/alice/points?delta=10", "")
+	retry := post("k1", "/users/alice/points?delta=10", "")
+	if first.Code != http.StatusOK || retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry = %d %q, want the first response %d %q", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get(IdempotentReplayedHeader) != "true" || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatal("only the replay should be marked")
+	}
+	post("k2", "/users/alice/points?delta=10", "")
+	post("", "/users/alice/points?delta=10", "")
+	if st, _ := svc.GetState(context.Background(), "alice"); st.Points["xp"] != 30 {
+		t.Fatalf("xp = %d, want 30: the retry must not be applied again", st.Points["xp"])
+	}
+
+	// a reused key with a different payload is refused, as is another caller's response
+	if rec := post("k1", "/users/alice/points?delta=99", ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key with another delta: got %d, want 422", rec.Code)
+	}
+	if rec := post("k1", "/users/alice/points?delta=10", "Bearer other"); rec.Code != http.StatusOK || rec.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("another caller's key: got %d replayed=%q, want a fresh response", rec.Code, rec.Header().Get(IdempotentReplayedHeader))
+	}
+
+	// a request still holding its key turns repeats away
+	store := NewMemoryIdempotencyStore()
+	held := httptest.NewRequest(http.MethodPost, "/users/alice/points", nil)
+	held.RemoteAddr = "192.0.2.1:1234"
+	if ok, _, _ := store.Reserve(context.Background(), idempotencyKey(held, CredentialPrincipal(held), "k3"), time.Minute); !ok {
+		t.Fatal("reserve failed")
+	}
+	h = NewMux(svc, nil, Options{Idempotency: store})
+	if rec := post("k3", "/users/alice/points?delta=10", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("repeat in flight: got %d, want 409", rec.Code)
+	}
+}
+
+// failingRateStore is a RateLimitStore that is down
+type failingRateStore struct{ calls int }
+
+func (f *failingRateStore) Take(context.Context, string, float64, int) (bool, time.Duration, error) {
+	f.calls++
+	return false, 0, errors.New("connection refused")
+}
+
+func TestRateLimiterStoreFailsOpen(t *testing.T) {
+	store := &failingRateStore{}
+	var reported int
+	rl := NewRateLimiter(RateLimit{RequestsPerMinute: 1, Burst: 1}, 0, WithRateLimitStore(store, func(error) { reported++ }))
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.Allow("1.2.3.4"); !ok {
+			t.Fatal("requests must pass while the store is down")
+		}
+	}
+	if store.calls != 3 || reported != 3 {
+		t.Fatalf("store calls = %d, reported errors = %d; want 3 each", store.calls, reported)
+	}
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := NewRateLimiter(RateLimit{RequestsPerMinute: 1, Burst: 1}, time.Minute)
+	rl.now = func() time.Time { return now }
+	rl.Allow("a")
+	now = now.Add(30 * time.Second)
+	rl.Allow("b")
+	now = now.Add(10 * time.Second)
+	if ok, _ := rl.Allow("a"); ok {
+		t.Fatal("a used its only token")
+	}
+	// a was used last, so b is evicted first
+	now = now.Add(55 * time.Second)
+	rl.Allow("c")
+	if _, ok := rl.buckets["b"]; ok || len(rl.buckets) != 2 {
+		t.Fatalf("buckets = %v, want b evicted", rl.buckets)
+	}
+	now = now.Add(2 * time.Minute)
+	rl.Allow("d")
+	if len(rl.buckets) != 1 || rl.idle.Len() != 1 {
+		t.Fatalf("%d buckets, %d ordered; want only d", len(rl.buckets), rl.idle.Len())
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "auth")
+			if r.Header.Get("X-API-Key") != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := NewMux(newTestService(), nil, Options{Auth: auth, Compress: true, Middleware: []Middleware{mark("first"), mark("second")}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get(RequestIDHeader) == "" {
+		t.Fatalf("want 401 with a request ID, got %d %v", rec.Code, rec.Header())
+	}
+
+	order = nil
+	req := httptest.NewRequest(http.MethodGet, "/users/alice", nil)
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("want gzipped 200, got %d %v", rec.Code, rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st map[string]any
+	if err := json.NewDecoder(zr).Decode(&st); err != nil || st["user_id"] != "alice" {
+		t.Fatalf("bad body %v: %v", st, err)
+	}
+	if strings.Join(order, ",") != "auth,first,second" {
+		t.Fatalf("unexpected middleware order %v", order)
+	}
+
+	order = nil
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK || len(order) != 2 {
+		t.Fatalf("healthz should skip auth: %d %v", rec.Code, order)
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-API-Key") != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := NewMux(newTestService(), nil, Options{
+		PathPrefix:      "/api",
+		Auth:            auth,
+		AllowCORSOrigin: "https://app.example.com",
+		PublicRoutes:    []string{"GET /catalog", "GET /users/{id}/badges"},
+	})
+	do := func(method, path, preflight string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", "https://www.example.com")
+		if preflight != "" {
+			req.Header.Set("Access-Control-Request-Method", preflight)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		method, path, preflight string
+		code                    int
+		origin                  string
+	}{
+		{http.MethodGet, "/api/catalog", "", http.StatusOK, "*"},
+		{http.MethodGet, "/api/users/alice/badges", "", http.StatusOK, "*"},
+		{http.MethodOptions, "/api/catalog", http.MethodGet, http.StatusNoContent, "*"},
+		{http.MethodPost, "/api/catalog", "", http.StatusUnauthorized, "https://app.example.com"},
+		{http.MethodGet, "/api/users/alice", "", http.StatusUnauthorized, "https://app.example.com"},
+		{http.MethodPost, "/api/users/alice/points?delta=5", "", http.StatusUnauthorized, "https://app.example.com"},
+		{http.MethodOptions, "/api/users/alice/points", http.MethodPost, http.StatusNoContent, "https://app.example.com"},
+		{http.MethodGet, "/api/healthz", "", http.StatusOK, "https://app.example.com"},
+	} {
+		rec := do(tc.method, tc.path, tc.preflight)
+		if rec.Code != tc.code || rec.Header().Get("Access-Control-Allow-Origin") != tc.origin {
+			t.Errorf("%s %s: %d with origin %q, want %d with %q", tc.method, tc.path, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"), tc.code, tc.origin)
+		}
+	}
+
+	for _, route := range []string{"GET catalog", "/users/{id}/points", "POST /users/{id}/points"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewMux accepted the public route %q", route)
+				}
+			}()
+			NewMux(newTestService(), nil, Options{PublicRoutes: []string{route}})
+		}()
+	}
+}
+
+func TestNamespacedRoutes(t *testing.T) {
+	svc := engine.NewGamifyService(engine.NamespacedStorage(mem.New()), engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	byHeader := func(r *http.Request) (core.Namespace, error) { return core.Namespace(r.Header.Get("X-Game")), nil }
+	// the caller's token lists the games it may use
+	member := func(r *http.Request, ns core.Namespace) error {
+		for _, g := range strings.Split(r.Header.Get("X-Member-Of"), ",") {
+			if core.Namespace(g) == ns {
+				return nil
+			}
+		}
+		return fmt.Errorf("not a member of %s", ns)
+	}
+	h := NewMux(svc, nil, Options{PathPrefix: "/api", RequireNamespace: true, NamespaceResolver: byHeader, NamespaceAuthorizer: member})
+
+	do := func(method, path, game string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Member-Of", "g1,g2")
+		if game != "" {
+			req.Header.Set("X-Game", game)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct{ path, game string }{
+		{"/api/users/alice", ""},              // no namespace at all
+		{"/api/games/bad!id/users/alice", ""}, // invalid path namespace
+		{"/api/users/alice", "bad game"},      // invalid resolved namespace
+	} {
+		if rec := do(http.MethodGet, tc.path, tc.game); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s (game %q): want 400, got %d %s", tc.path, tc.game, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := do(http.MethodPost, "/api/games/g1/users/alice/points?metric=xp&delta=10", ""); rec.Code != http.StatusOK {
+		t.Fatalf("add points in g1: %d %s", rec.Code, rec.Body)
+	}
+	points := func(path, game string) int64 {
+		var st core.UserState
+		if err := json.NewDecoder(do(http.MethodGet, path, game).Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Points[core.MetricXP]
+	}
+	if got := points("/api/users/alice", "g1"); got != 10 {
+		t.Fatalf("alice in g1 via resolver: want 10 got %d", got)
+	}
+	if got := points("/api/games/g2/users/alice", ""); got != 0 {
+		t.Fatalf("alice in g2 must not see g1 points, got %d", got)
+	}
+	if rec := do(http.MethodGet, "/api/healthz", ""); rec.Code != http.StatusOK {
+		t.Fatalf("healthz needs no namespace, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/games/g3/users/alice", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("game the caller is not a member of: want 403, got %d", rec.Code)
+	}
+
+	// without an authorizer the path cannot pick the namespace
+	open := NewMux(svc, nil, Options{PathPrefix: "/api", RequireNamespace: true, NamespaceResolver: byHeader})
+	rec := httptest.NewRecorder()
+	open.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/games/g1/users/alice", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("path namespace without authorizer: want 403, got %d", rec.Code)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewMux served shared leaderboards to namespaces")
+		}
+	}()
+	NewMux(svc, nil, Options{RequireNamespace: true, Leaderboards: map[string]leaderboard.Board{}})
+}
+
+func TestLoadShedder(t *testing.T) {
+	shedder := NewLoadShedder(ConcurrencyLimit{MaxWrites: 1, MaxReads: 2, AcquireTimeout: 20 * time.Millisecond})
+	var peak atomic.Int64
+	shedder.OnInFlight(func(reads, writes int) {
+		if int64(writes) > peak.Load() {
+			peak.Store(int64(writes))
+		}
+	})
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := shedder.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			entered <- struct{}{}
+			<-release
+		}
+	}))
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users/alice/points", nil))
+		done <- rr.Code
+	}()
+	<-entered
+
+	// the only write slot is taken, so a second write is shed after the acquire timeout
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users/bob/points", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("want 503 with Retry-After, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	// reads have their own limit
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/bob", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("read should be served, got %d", rr.Code)
+	}
+	if reads, writes := shedder.InFlight(); reads != 0 || writes != 1 {
+		t.Fatalf("want 0 reads and 1 write in flight, got %d %d", reads, writes)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("first write should succeed, got %d", code)
+	}
+	if shedder.Shed() != 1 || peak.Load() != 1 {
+		t.Fatalf("want 1 shed request and a peak of 1 write, got %d %d", shedder.Shed(), peak.Load())
+	}
+
+	// health checks bypass the shedder
+	rr = httptest.NewRecorder()
+	h2 := NewMux(newTestService(), nil, Options{LoadShedder: NewLoadShedder(ConcurrencyLimit{MaxReads: 1})})
+	h2.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("healthz should not be shed, got %d", rr.Code)
+	}
+
+	// requests Auth rejects never take a slot
+	shedder = NewLoadShedder(ConcurrencyLimit{MaxReads: 1})
+	var acquired atomic.Int64
+	shedder.OnInFlight(func(reads, _ int) {
+		if reads > 0 {
+			acquired.Add(1)
+		}
+	})
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-API-Key") != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	h3 := NewMux(newTestService(), nil, Options{Auth: auth, LoadShedder: shedder})
+	rr = httptest.NewRecorder()
+	h3.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	if rr.Code != http.StatusUnauthorized || acquired.Load() != 0 {
+		t.Fatalf("unauthenticated request: got %d with %d slots taken, want 401 and none", rr.Code, acquired.Load())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/users/alice", nil)
+	req.Header.Set("X-API-Key", "secret")
+	rr = httptest.NewRecorder()
+	h3.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || acquired.Load() != 1 {
+		t.Fatalf("authenticated request: got %d with %d slots taken, want 200 and one", rr.Code, acquired.Load())
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	svc := newTestService(
+		engine.WithMetric(engine.MetricInfo{ID: core.MetricXP, Name: "Experience", Unit: "XP"}),
+		engine.WithBadge(engine.BadgeInfo{ID: "early_bird", Name: "Early Bird", Metadata: map[string]any{"tier": "gold"}}),
+		engine.WithStrictCatalog(),
+	)
+	h := NewMux(svc, nil, Options{})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/catalog", nil))
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("want 200 with an ETag, got %d %q", rr.Code, etag)
+	}
+	var got engine.Catalog
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Strict || len(got.Metrics) != 1 || got.Metrics[0].Unit != "XP" || len(got.Badges) != 1 || got.Badges[0].Metadata["tier"] != "gold" {
+		t.Fatalf("unexpected catalog %+v", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/catalog", nil)
+	req.Header.Set("If-None-Match", `W/"other", `+etag)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Fatalf("want 304 for a matching ETag, got %d", rr.Code)
+	}
+
+	// strict mode rejects transfers of metrics missing from the catalog
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users/alice/transfer", strings.NewReader(`{"to":"bob","metric":"coins","amount":1}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 for an unknown metric, got %d", rr.Code)
+	}
+}
+
+func TestDefaultMetric(t *testing.T) {
+	svc := newTestService()
+	h := NewMux(svc, nil, Options{DefaultMetric: "coins"})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users/alice/points?delta=5", nil))
+	st, _ := svc.GetState(context.Background(), "alice")
+	if rr.Code != http.StatusOK || st.Points["coins"] != 5 || st.Points[core.MetricXP] != 0 {
+		t.Fatalf("points without a metric should go to coins, got %d %v", rr.Code, st.Points)
+	}
+
+	h = NewMux(svc, nil, Options{RequireMetric: true})
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/users/alice/points?delta=5", nil),
+		httptest.NewRequest(http.MethodGet, "/users/alice/points/recent?window=1h", nil),
+		httptest.NewRequest(http.MethodPost, "/users/alice/transfer", strings.NewReader(`{"to":"bob","amount":1}`)),
+	} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), ErrMetricRequired.Error()) {
+			t.Fatalf("%s %s: want 400 for a missing metric, got %d %s", req.Method, req.URL, rr.Code, rr.Body)
+		}
+	}
+
+	// a strict catalog implies RequireMetric
+	h = NewMux(newTestService(engine.WithStrictCatalog()), nil, Options{})
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/users/alice/points?delta=5", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("strict catalog should require a metric, got %d", rr.Code)
+	}
+}
+
+func TestUserQuests(t *testing.T) {
+	svc := newTestService(engine.WithQuest(engine.Quest{ID: "daily-xp", Metric: core.MetricXP, Target: 100, Reset: core.QuestDaily}))
+	if _, err := svc.AddPoints(context.Background(), "alice", core.MetricXP, 40); err != nil {
+		t.Fatal(err)
+	}
+	h := NewMux(svc, nil, Options{})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice/quests", nil))
+	var out struct {
+		Quests []core.QuestProgress `json:"quests"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET quests: %d %s", rec.Code, rec.Body)
+	}
+	if len(out.Quests) != 1 || out.Quests[0].Quest != "daily-xp" || out.Quests[0].Progress != 40 || out.Quests[0].Completed || out.Quests[0].ResetsAt == nil {
+		t.Fatalf("quests = %+v, want daily-xp at 40 of 100", out.Quests)
+	}
+}
+
+func TestUserBadges(t *testing.T) {
+	svc := newTestService()
+	ctx := context.Background()
+	for _, b := range []core.Badge{"a", "b", "c"} {
+		if err := svc.AwardBadge(ctx, "alice", b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewMux(svc, nil, Options{})
+	get := func(path string) (*httptest.ResponseRecorder, map[string]any) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var out map[string]any
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+
+	seen := map[any]bool{}
+	path := "/users/alice/badges?limit=2"
+	for pages := 0; path != ""; pages++ {
+		rec, out := get(path)
+		if rec.Code != http.StatusOK || pages > 2 {
+			t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+		for _, b := range out["badges"].([]any) {
+			award := b.(map[string]any)
+			if award["awarded_at"] == nil || seen[award["badge"]] {
+				t.Fatalf("award %v repeated or without time", award)
+			}
+			seen[award["badge"]] = true
+		}
+		path = ""
+		if next, ok := out["next_cursor"].(string); ok {
+			path = "/users/alice/badges?limit=2&cursor=" + next
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("paged through %v, want a, b and c", seen)
+	}
+	if _, out := get("/users/alice/badges?since=2999-01-01T00:00:00Z"); len(out["badges"].([]any)) != 0 {
+		t.Fatalf("future since = %v, want no badges", out)
+	}
+	for _, q := range []string{"limit=0", "limit=501", "since=yesterday", "cursor=%21"} {
+		if rec, _ := get("/users/alice/badges?" + q); rec.Code != http.StatusBadRequest {
+			t.Errorf("?%s: %d, want 400", q, rec.Code)
+		}
+	}
+
+	_, out := get("/users/alice")
+	if len(out["badges"].(map[string]any)) != 3 || out["badge_count"] != nil {
+		t.Fatalf("default state = %v, want all badges", out)
+	}
+	_, out = get("/users/alice?expand=")
+	if _, ok := out["badges"]; ok || out["badge_count"] != 3.0 || out["user_id"] != "alice" {
+		t.Fatalf("state without badges = %v, want badge_count 3", out)
+	}
+	_, out = get("/users/alice?expand=progress,badges")
+	if len(out["badges"].(map[string]any)) != 3 {
+		t.Fatalf("expanded state = %v, want all badges", out)
+	}
+}
+
+// outageStorage fails state reads while down is set
+type outageStorage struct {
+	engine.Storage
+	down atomic.Bool
+}
+
+func (o *outageStorage) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+	if o.down.Load() {
+		return core.UserState{}, errors.New("connection refused")
+	}
+	return o.Storage.GetState(ctx, user)
+}
+
+func TestStaleReadsFlagged(t *testing.T) {
+	inner := &outageStorage{Storage: mem.New()}
+	svc := engine.NewGamifyService(engine.StaleOnErrorStorage(inner, engine.StaleReadOptions{}), engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	h := NewMux(svc, nil, Options{})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	do(http.MethodPost, "/users/alice/points?delta=10")
+	if rec := do(http.MethodGet, "/users/alice"); rec.Code != http.StatusOK || rec.Header().Get(StaleHeader) != "" {
+		t.Fatalf("live read: %d, stale header %q", rec.Code, rec.Header().Get(StaleHeader))
+	}
+
+	inner.down.Store(true)
+	rec := do(http.MethodGet, "/users/alice")
+	if rec.Code != http.StatusOK || rec.Header().Get(StaleHeader) == "" || !strings.Contains(rec.Body.String(), `"xp":10`) {
+		t.Fatalf("read during outage: %d %s, stale header %q", rec.Code, rec.Body, rec.Header().Get(StaleHeader))
+	}
+	if rec := do(http.MethodGet, "/healthz"); rec.Code == http.StatusOK {
+		t.Fatalf("health check during outage answered 200")
+	}
+}
+
+func TestExportEvents(t *testing.T) {
+	log, err := analytics.NewFileEventLog(t.TempDir() + "/events.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 2; i >= 0; i-- {
+		if err := log.Append(core.Event{Type: core.EventPointsAdded, UserID: "alice", Time: base.Add(time.Duration(i) * time.Hour),
+			Metric: core.MetricXP, Delta: int64(i + 1), Metadata: map[string]any{"source": "quest"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewMux(newTestService(), nil, Options{AdminToken: "secret", Events: log})
+	get := func(query string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/events/export"+query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("?from=" + base.Add(time.Hour).Format(time.RFC3339))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 2 {
+		t.Fatalf("ndjson export: %d %q\n%s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+	}
+	var first core.Event
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil || first.Delta != 2 || first.ID == "" {
+		t.Fatalf("first event = %+v, %v; want the one at 13:00", first, err)
+	}
+
+	rec = get("?format=csv&to="+base.Add(time.Hour).Format(time.RFC3339), "Accept-Encoding", "gzip")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("csv export: %d %v", rec.Code, rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(zr)
+	csvLines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(csvLines) != 2 || !strings.HasPrefix(csvLines[0], "id,type,time,user_id") || !strings.Contains(csvLines[1], `"{""source"":""quest""}"`) {
+		t.Fatalf("csv body:\n%s", body)
+	}
+
+	for _, q := range []string{"?from=yesterday", "?format=xml", "?from=2024-03-02T00:00:00Z&to=2024-03-01T00:00:00Z"} {
+		if rec := get(q); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", q, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/events/export", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("export without token: %d, want 401", rec.Code)
+	}
+}
+
+func TestExternalIdentities(t *testing.T) {
+	svc := newTestService(engine.WithIdentityKinds("email"))
+	h := NewMux(svc, nil, Options{AdminToken: "secret"})
+	do := func(method, path string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/users/email:alice@example.com/points?delta=5", false); rec.Code != http.StatusNotFound {
+		t.Fatalf("write to an unlinked identity: %d %s", rec.Code, rec.Body)
+	}
+	for _, id := range []string{"email:alice@example.com", "email:a.smith@example.com"} {
+		if rec := do(http.MethodPut, "/admin/users/u-1/identities/"+id, true); rec.Code != http.StatusOK {
+			t.Fatalf("link %s: %d %s", id, rec.Code, rec.Body)
+		}
+	}
+	if rec := do(http.MethodPut, "/admin/users/u-2/identities/email:alice@example.com", true); rec.Code != http.StatusConflict {
+		t.Fatalf("link a taken identity: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/admin/users/u-1/identities", true); !strings.Contains(rec.Body.String(), `"identities":["email:a.smith@example.com","email:alice@example.com"]`) {
+		t.Fatalf("identities: %d %s", rec.Code, rec.Body)
+	}
+
+	do(http.MethodPost, "/users/email:Alice@Example.com/points?delta=5", false)
+	do(http.MethodPost, "/users/u-1/points?delta=2", false)
+	if rec := do(http.MethodGet, "/users/email:a.smith@example.com", false); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"user_id":"u-1"`) || !strings.Contains(rec.Body.String(), `"xp":7`) {
+		t.Fatalf("read by identity: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(http.MethodDelete, "/admin/users/u-2/identities/email:alice@example.com", true); rec.Code != http.StatusNotFound {
+		t.Fatalf("unlink another user's identity: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodDelete, "/admin/users/u-1/identities/email:alice@example.com", true); rec.Code != http.StatusOK {
+		t.Fatalf("unlink: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/users/email:alice@example.com", false); rec.Code != http.StatusNotFound {
+		t.Fatalf("read by an unlinked identity: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestContextDoneIsGatewayTimeout(t *testing.T) {
+	h := NewMux(newTestService(), nil, Options{})
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for _, path := range []string{"/users/alice", "/users/alice/badges"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil).WithContext(expired))
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("GET %s with an expired deadline = %d %s, want 504", path, rec.Code, rec.Body)
+		}
+	}
+	for _, path := range []string{"/users/alice/points?delta=5", "/users/alice/badges/first"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil).WithContext(expired))
+		if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), `"err":`) {
+			t.Errorf("POST %s with an expired deadline = %d %s, want 504 with the error in the body", path, rec.Code, rec.Body)
+		}
+	}
+
+	// the deadline passes while the storage is working
+	store := storagetest.New()
+	store.Inject(storagetest.OpGetState, storagetest.Fault{Latency: time.Second})
+	svc := engine.NewGamifyService(store, engine.NewEventBus(engine.DispatchSync), engine.DefaultRuleEngine())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	NewMux(svc, nil, Options{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice", nil).WithContext(ctx))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("GET /users/alice timing out in storage = %d %s, want 504", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/alice", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /users/alice = %d, want 200", rec.Code)
+	}
+}
+
+func TestDeadLettersRequireAdminToken(t *testing.T) {
+	store, err := analytics.NewFileDeadLetterStore(t.TempDir() + "/dead.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(opts Options, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		NewMux(newTestService(), nil, opts).ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get(Options{DeadLetters: store}, ""); code != http.StatusNotFound {
+		t.Fatalf("without an admin token: got %d, want 404", code)
+	}
+	if code := get(Options{DeadLetters: store, AdminToken: "secret"}, ""); code != http.StatusUnauthorized {
+		t.Fatalf("without credentials: got %d, want 401", code)
+	}
+	if code := get(Options{DeadLetters: store, AdminToken: "secret"}, "secret"); code != http.StatusOK {
+		t.Fatalf("with the admin token: got %d, want 200", code)
+	}
+	rec := httptest.NewRecorder()
+	requireToken("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("requireToken with no token must fail closed, got %d", rec.Code)
+	}
+}
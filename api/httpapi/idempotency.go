@@ -0,0 +1,255 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+)
+
+const (
+	// IdempotencyKeyHeader lets a client retry a write safely: requests repeating a key get the
+	// first request's response instead of being applied again.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for a repeated key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// DefaultIdempotencyTTL is how long responses are kept for replay when no TTL is configured.
+	DefaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL bounds how long a key stays claimed by a request that never finishes,
+	// e.g. because its instance crashed
+	idempotencyLockTTL = time.Minute
+	// maxIdempotentBody is the largest response stored for replay, and the largest request body
+	// hashed; larger responses release the key, larger requests are refused
+	maxIdempotentBody = 1 << 20
+)
+
+// IdempotencyPrincipal names the caller whose idempotency keys a request uses, so two callers
+// picking the same key never get each other's responses.
+type IdempotencyPrincipal func(r *http.Request) string
+
+// CredentialPrincipal is the default IdempotencyPrincipal: a digest of the request's Authorization
+// header, or the client's address when it sends none.
+func CredentialPrincipal(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:])
+	}
+	return "addr:" + clientIP(r)
+}
+
+// IdempotencyStore records idempotency keys and the responses they produced. MemoryIdempotencyStore
+// serves a single instance; redis.IdempotencyStore shares keys between instances.
+type IdempotencyStore interface {
+	// Reserve atomically claims key for ttl. If the key is already claimed it returns false with
+	// the stored response, which is nil while the request holding the key is still running.
+	Reserve(ctx context.Context, key string, ttl time.Duration) (reserved bool, response []byte, err error)
+	// Complete stores the response of the request holding key, kept for ttl.
+	Complete(ctx context.Context, key string, response []byte, ttl time.Duration) error
+	// Release drops key so the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// storedResponse is a response as kept for replay
+type storedResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	// RequestHash is the digest of the query and body of the request that produced the response
+	RequestHash string `json:"request_hash,omitempty"`
+}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests carrying an Idempotency-Key header safe
+// to retry. The first request with a key runs and its response is kept for ttl
+// (DefaultIdempotencyTTL when not positive); repeats of the same method, path and key by the same
+// caller (principal, CredentialPrincipal when nil) in the same namespace get that response again,
+// marked with Idempotent-Replayed: true, without running the handler. A repeat whose query or body
+// differs from the first request's is answered 422, and one arriving while the first request still
+// runs is answered 409. Responses with a 5xx status are not kept, so a failed request can be
+// retried. Keys should be unique per client, e.g. UUIDs.
+//
+// If the store fails, the request is answered 503 rather than risking a duplicate write.
+func Idempotency(store IdempotencyStore, ttl time.Duration, principal IdempotencyPrincipal) Middleware {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	if principal == nil {
+		principal = CredentialPrincipal
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(IdempotencyKeyHeader)
+			switch {
+			case header == "":
+				next.ServeHTTP(w, r)
+				return
+			case r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch && r.Method != http.MethodDelete:
+				next.ServeHTTP(w, r)
+				return
+			}
+			requestID := RequestIDFromContext(r.Context())
+			if len(header) > 255 {
+				writeError(w, http.StatusBadRequest, IdempotencyKeyHeader+" must be at most 255 characters", requestID)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "failed to read request body", requestID)
+				return
+			}
+			if len(body) > maxIdempotentBody {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large for an "+IdempotencyKeyHeader, requestID)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			requestHash := hashRequest(r.URL.RawQuery, body)
+			key := idempotencyKey(r, principal(r), header)
+			reserved, stored, err := store.Reserve(r.Context(), key, idempotencyLockTTL)
+			switch {
+			case err != nil:
+				writeError(w, http.StatusServiceUnavailable, "idempotency keys are unavailable", requestID)
+				return
+			case !reserved && stored == nil:
+				writeError(w, http.StatusConflict, "a request with this "+IdempotencyKeyHeader+" is still in progress", requestID)
+				return
+			case !reserved:
+				var resp storedResponse
+				if err := json.Unmarshal(stored, &resp); err != nil {
+					writeError(w, http.StatusInternalServerError, "stored response is unreadable", requestID)
+					return
+				}
+				if resp.RequestHash != "" && resp.RequestHash != requestHash {
+					writeError(w, http.StatusUnprocessableEntity, IdempotencyKeyHeader+" was already used for a different request", requestID)
+					return
+				}
+				if resp.ContentType != "" {
+					w.Header().Set("Content-Type", resp.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(resp.Status)
+				_, _ = w.Write(resp.Body)
+				return
+			}
+
+			rec := &recordingWriter{statusWriter: statusWriter{ResponseWriter: w}}
+			defer func() {
+				// a panic or a failure leaves the key free for a retry
+				ctx := context.WithoutCancel(r.Context())
+				status := rec.Status()
+				if v := recover(); v != nil {
+					_ = store.Release(ctx, key)
+					panic(v)
+				}
+				if status >= 500 || rec.overflow {
+					_ = store.Release(ctx, key)
+					return
+				}
+				resp, _ := json.Marshal(storedResponse{Status: status, ContentType: w.Header().Get("Content-Type"), Body: rec.body.Bytes(), RequestHash: requestHash})
+				if err := store.Complete(ctx, key, resp, ttl); err != nil {
+					_ = store.Release(ctx, key)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// idempotencyKey scopes a client's key to the request's method, path, namespace and caller
+func idempotencyKey(r *http.Request, principal, header string) string {
+	ns, _ := core.NamespaceFromContext(r.Context())
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.Path, string(ns), principal, header} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return "idem:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// hashRequest digests what a repeated request must match: its query and body
+func hashRequest(query string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(query))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body for replay
+type recordingWriter struct {
+	statusWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.statusWriter.Write(p)
+	if !rw.overflow {
+		if rw.body.Len()+n > maxIdempotentBody {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+// MemoryIdempotencyStore keeps idempotency keys in memory, for a single server instance.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	keys      map[string]idempotencyEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type idempotencyEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+// NewMemoryIdempotencyStore creates an empty store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{keys: map[string]idempotencyEntry{}, now: time.Now}
+}
+
+// Reserve claims key for ttl; see IdempotencyStore.
+func (m *MemoryIdempotencyStore) Reserve(_ context.Context, key string, ttl time.Duration) (bool, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.lastSweep) >= idempotencyLockTTL {
+		for k, e := range m.keys {
+			if !now.Before(e.expires) {
+				delete(m.keys, k)
+			}
+		}
+		m.lastSweep = now
+	}
+	if e, ok := m.keys[key]; ok && now.Before(e.expires) {
+		return false, e.response, nil
+	}
+	m.keys[key] = idempotencyEntry{expires: now.Add(ttl)}
+	return true, nil, nil
+}
+
+// Complete stores the response for key; see IdempotencyStore.
+func (m *MemoryIdempotencyStore) Complete(_ context.Context, key string, response []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key] = idempotencyEntry{response: response, expires: m.now().Add(ttl)}
+	return nil
+}
+
+// Release drops key; see IdempotencyStore.
+func (m *MemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
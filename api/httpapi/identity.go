@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// resolveUser lets next, a route with an {id} path value, be addressed by external ID as well, e.g.
+// /users/email:alice@example.com/points: IDs with a colon are resolved with svc.ResolveUser and
+// next sees the canonical ID. Unlinked identities are answered 404. Routes are left as they are
+// when svc accepts no identity kinds (see engine.WithIdentityKinds).
+func resolveUser(svc *engine.GamifyService, next http.Handler) http.Handler {
+	if len(svc.IdentityKinds()) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !strings.Contains(id, ":") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		user, err := svc.ResolveUser(r.Context(), id)
+		if err != nil {
+			writeError(w, identityStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+			return
+		}
+		r.SetPathValue("id", string(user))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// identityStatus is the status answering an identity error
+func identityStatus(err error) int {
+	switch {
+	case errors.Is(err, engine.ErrUnknownIdentity):
+		return http.StatusNotFound
+	case errors.Is(err, engine.ErrInvalidIdentity):
+		return http.StatusBadRequest
+	case errors.Is(err, engine.ErrIdentityTaken):
+		return http.StatusConflict
+	case errors.Is(err, engine.ErrIdentityUnsupported):
+		return http.StatusNotImplemented
+	}
+	return errorStatus(err)
+}
+
+// listIdentities answers the external IDs linked to the path user.
+func listIdentities(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	user := core.UserID(r.PathValue("id"))
+	identities, err := svc.Identities(r.Context(), user)
+	if err != nil {
+		writeError(w, identityStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	writeJSON(w, map[string]any{"user_id": user, "identities": identities})
+}
+
+// linkIdentity links the path identity to the path user; relinking is a no-op and an identity
+// held by another user is answered 409.
+func linkIdentity(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	user, identity := core.UserID(r.PathValue("id")), r.PathValue("identity")
+	if err := svc.LinkIdentity(r.Context(), user, identity); err != nil {
+		writeError(w, identityStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	listIdentities(w, r, svc)
+}
+
+// unlinkIdentity removes the path identity from the path user, answering 404 when the user does
+// not hold it.
+func unlinkIdentity(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	requestID := RequestIDFromContext(r.Context())
+	user, err := core.NormalizeUserID(core.UserID(r.PathValue("id")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	}
+	identity := r.PathValue("identity")
+	holder, err := svc.ResolveUser(r.Context(), identity)
+	if err == nil && holder != user {
+		err = engine.ErrUnknownIdentity
+	}
+	if err == nil {
+		_, err = svc.UnlinkIdentity(r.Context(), identity)
+	}
+	if err != nil {
+		writeError(w, identityStatus(err), err.Error(), requestID)
+		return
+	}
+	listIdentities(w, r, svc)
+}
@@ -0,0 +1,65 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gamifykit/leaderboard"
+)
+
+const (
+	// DefaultLeaderboardLimit is the number of entries served when a request sets no limit.
+	DefaultLeaderboardLimit = 10
+	// DefaultMaxLeaderboardLimit is the largest limit accepted when Options.MaxLeaderboardLimit is zero.
+	DefaultMaxLeaderboardLimit = 100
+)
+
+// leaderboardHandler serves the top entries of the boards in Options.Leaderboards. The limit
+// query parameter must be a whole number between 1 and max; anything else is answered 400
+// rather than clamped, so clients notice they asked for more than the server pages out.
+// Responses report when the standings were read ("as_of") and whether they came from the snapshot
+// of a leaderboard.CachedBoard ("cached"); other boards are always read live.
+func leaderboardHandler(boards map[string]leaderboard.Board, max int) http.HandlerFunc {
+	if max <= 0 {
+		max = DefaultMaxLeaderboardLimit
+	}
+	max = min(max, leaderboard.MaxPageSize)
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := RequestIDFromContext(r.Context())
+		name := r.PathValue("name")
+		board, ok := boards[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown leaderboard", requestID)
+			return
+		}
+		limit := DefaultLeaderboardLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > max {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", max), requestID)
+				return
+			}
+			limit = n
+		}
+		snap := leaderboard.Snapshot{AsOf: time.Now()}
+		if c, ok := board.(snapshotter); ok {
+			snap = c.TopNSnapshot(limit)
+		} else {
+			snap.Entries = board.TopN(limit)
+		}
+		entries := snap.Entries
+		standings := make([]leaderboard.Standing, len(entries))
+		for i, e := range entries {
+			standings[i] = leaderboard.Standing{Rank: i + 1, User: e.User, Score: e.Score}
+		}
+		writeJSON(w, map[string]any{"name": name, "limit": limit, "standings": standings,
+			"as_of": snap.AsOf.UTC(), "cached": snap.Cached})
+	}
+}
+
+// snapshotter is implemented by boards serving the top from a cache, such as leaderboard.CachedBoard
+type snapshotter interface {
+	TopNSnapshot(n int) leaderboard.Snapshot
+}
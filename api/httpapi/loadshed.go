@@ -0,0 +1,142 @@
+package httpapi
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAcquireTimeout is how long a request waits for a slot when ConcurrencyLimit.AcquireTimeout is zero.
+const DefaultAcquireTimeout = 100 * time.Millisecond
+
+// ConcurrencyLimit caps the requests a server works on at once. A zero maximum leaves that kind
+// of request unlimited.
+type ConcurrencyLimit struct {
+	// MaxWrites caps in-flight POST, PUT, PATCH and DELETE requests.
+	MaxWrites int
+	// MaxReads caps in-flight requests of other methods; it is usually higher than MaxWrites.
+	MaxReads int
+	// AcquireTimeout is how long a request waits for a free slot before it is shed, so a brief
+	// spike queues while a sustained overload is rejected (DefaultAcquireTimeout when zero).
+	AcquireTimeout time.Duration
+	// RetryAfter is advertised to shed clients in the Retry-After header (one second when zero).
+	RetryAfter time.Duration
+}
+
+// LoadShedder limits in-flight requests with one semaphore for writes and one for reads. Requests
+// that find no free slot within the acquire timeout are answered 503 with a Retry-After header
+// instead of queuing behind an overloaded backend. WebSocket upgrades are not limited, as they
+// hold their connection for as long as the client stays.
+type LoadShedder struct {
+	limit         ConcurrencyLimit
+	writes, reads chan struct{}
+	inWrites      atomic.Int64
+	inReads       atomic.Int64
+	shed          atomic.Int64
+	onInFlight    atomic.Pointer[func(reads, writes int)]
+}
+
+// NewLoadShedder creates a shedder for limit.
+func NewLoadShedder(limit ConcurrencyLimit) *LoadShedder {
+	if limit.AcquireTimeout <= 0 {
+		limit.AcquireTimeout = DefaultAcquireTimeout
+	}
+	if limit.RetryAfter <= 0 {
+		limit.RetryAfter = time.Second
+	}
+	l := &LoadShedder{limit: limit}
+	if limit.MaxWrites > 0 {
+		l.writes = make(chan struct{}, limit.MaxWrites)
+	}
+	if limit.MaxReads > 0 {
+		l.reads = make(chan struct{}, limit.MaxReads)
+	}
+	return l
+}
+
+// InFlight returns the number of reads and writes being served.
+func (l *LoadShedder) InFlight() (reads, writes int) {
+	return int(l.inReads.Load()), int(l.inWrites.Load())
+}
+
+// Shed returns how many requests have been rejected so far.
+func (l *LoadShedder) Shed() int64 {
+	return l.shed.Load()
+}
+
+// OnInFlight registers fn to be called with the in-flight counts whenever they change, e.g. to
+// update a gauge.
+func (l *LoadShedder) OnInFlight(fn func(reads, writes int)) {
+	l.onInFlight.Store(&fn)
+}
+
+// Middleware applies the limits; see LoadShedder.
+func (l *LoadShedder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sem, counter := l.reads, &l.inReads
+		if isWrite(r.Method) {
+			sem, counter = l.writes, &l.inWrites
+		}
+		if sem != nil && !l.acquire(r, sem) {
+			l.shed.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(l.limit.RetryAfter.Seconds()))))
+			writeError(w, http.StatusServiceUnavailable, "server overloaded", RequestIDFromContext(r.Context()))
+			return
+		}
+		counter.Add(1)
+		l.notify()
+		defer func() {
+			counter.Add(-1)
+			if sem != nil {
+				<-sem
+			}
+			l.notify()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting up to the acquire timeout or until the client gives up
+func (l *LoadShedder) acquire(r *http.Request, sem chan struct{}) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(l.limit.AcquireTimeout)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (l *LoadShedder) notify() {
+	if fn := l.onInFlight.Load(); fn != nil {
+		reads, writes := l.InFlight()
+		(*fn)(reads, writes)
+	}
+}
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
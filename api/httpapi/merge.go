@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// mergeRequest is the body of POST /admin/users/{id}/merge: the user merged into the one in the
+// path and the core.MergePolicy to merge with.
+type mergeRequest struct {
+	Source core.UserID `json:"source"`
+	core.MergePolicy
+}
+
+// mergeUsers answers POST /admin/users/{id}/merge by merging the source user in the body into the
+// user in the path, answering the target's merged state.
+func mergeUsers(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid merge: "+err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	if req.Source == "" {
+		writeError(w, http.StatusBadRequest, "source is required", RequestIDFromContext(r.Context()))
+		return
+	}
+	if err := req.MergePolicy.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	target := core.UserID(r.PathValue("id"))
+	err := svc.MergeUsers(r.Context(), req.Source, target, req.MergePolicy)
+	switch {
+	case errors.Is(err, engine.ErrMergeUnsupported):
+		writeError(w, http.StatusNotImplemented, err.Error(), RequestIDFromContext(r.Context()))
+		return
+	case errors.Is(err, core.ErrSelfMerge), errors.Is(err, engine.ErrValueOutOfRange):
+		writeError(w, http.StatusBadRequest, err.Error(), RequestIDFromContext(r.Context()))
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	merged, err := svc.GetState(r.Context(), target)
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	writeJSON(w, merged)
+}
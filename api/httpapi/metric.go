@@ -0,0 +1,33 @@
+package httpapi
+
+import (
+	"errors"
+
+	"gamifykit/core"
+)
+
+// ErrMetricRequired is returned by MetricPolicy.Resolve for requests that name no metric while
+// MetricPolicy.Require is set.
+var ErrMetricRequired = errors.New("metric is required")
+
+// MetricPolicy decides which metric a request that names none refers to. NewMux builds one from
+// Options.DefaultMetric and Options.RequireMetric; other handlers can share it.
+type MetricPolicy struct {
+	// Default is used for requests that name no metric (core.MetricXP when empty).
+	Default core.Metric
+	// Require rejects requests that name no metric instead of applying Default.
+	Require bool
+}
+
+// Resolve returns the metric a request refers to, given its (possibly empty) metric parameter.
+func (p MetricPolicy) Resolve(metric string) (core.Metric, error) {
+	switch {
+	case metric != "":
+		return core.Metric(metric), nil
+	case p.Require:
+		return "", ErrMetricRequired
+	case p.Default != "":
+		return p.Default, nil
+	}
+	return core.MetricXP, nil
+}
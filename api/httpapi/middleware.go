@@ -0,0 +1,491 @@
+package httpapi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+	"gamifykit/tracing"
+)
+
+// Middleware wraps an http.Handler, e.g. to add logging or authentication.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in mws so that the first middleware is the outermost: it sees the request first
+// and the response last.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID assigned by the RequestID middleware, or "" outside of it.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID tags each request with an ID, taken from an incoming X-Request-ID header when it is
+// printable and at most 128 bytes long and generated otherwise. The ID is echoed in the response
+// header and available to later handlers through RequestIDFromContext.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// EnrichEvent is an event enricher (see engine.WithEventEnricher) that copies the request ID of
+// the request causing an event into its Metadata under "request_id", and its namespace, if any,
+// under "namespace", so webhooks and the event log can be correlated with access logs. Events
+// published outside of a request are left alone.
+func EnrichEvent(ctx context.Context, e *core.Event) {
+	id := RequestIDFromContext(ctx)
+	ns, hasNS := core.NamespaceFromContext(ctx)
+	if id == "" && !hasNS {
+		return
+	}
+	if e.Metadata == nil {
+		e.Metadata = map[string]any{}
+	}
+	if id != "" {
+		e.Metadata["request_id"] = id
+	}
+	if hasNS {
+		e.Metadata["namespace"] = string(ns)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strings.ReplaceAll(time.Now().UTC().Format("20060102150405.000000000"), ".", "")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recover turns a panicking handler into a 500 response instead of a crashed server. The panic is
+// logged with the request ID and stack trace. If the handler already started its response, the
+// response is left as is. http.ErrAbortHandler is passed on, as net/http expects.
+func Recover(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(v)
+				}
+				id := RequestIDFromContext(r.Context())
+				logger.Error("panic serving request", "panic", v, "method", r.Method, "path", r.URL.Path,
+					"request_id", id, "stack", string(debug.Stack()))
+				if !sw.wroteHeader {
+					writeError(sw, http.StatusInternalServerError, "internal server error", id)
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// AccessLogOptions configures AccessLog.
+type AccessLogOptions struct {
+	// Level is the level requests are logged at (slog.LevelInfo when zero).
+	Level slog.Level
+	// SkipPaths are request paths that are not logged, e.g. health checks and metric scrapes.
+	SkipPaths []string
+	// RedactUser, if set, replaces the user IDs in logged paths (the segment following "users"),
+	// e.g. with logging.RedactOptions.Redact so the access log follows the PII setting.
+	RedactUser func(core.UserID) string
+}
+
+// AccessLog logs every request, except those for opts.SkipPaths, with its method, path, status,
+// response size and duration.
+func AccessLog(logger *slog.Logger, opts AccessLogOptions) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(opts.SkipPaths, r.URL.Path) || !logger.Enabled(r.Context(), opts.Level) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			logger.Log(r.Context(), opts.Level, "request", "method", r.Method, "path", accessLogPath(r, opts.RedactUser),
+				"status", sw.Status(), "bytes", sw.bytes, "duration", time.Since(start),
+				"request_id", RequestIDFromContext(r.Context()))
+		})
+	}
+}
+
+// LogRequests logs every request at info level with its status, response size and duration; see
+// AccessLog.
+func LogRequests(logger *slog.Logger) Middleware {
+	return AccessLog(logger, AccessLogOptions{})
+}
+
+// accessLogPath returns the request path with each user ID segment replaced by redact. The
+// literal segment of {prefix}/admin/users/query is kept.
+func accessLogPath(r *http.Request, redact func(core.UserID) string) string {
+	if redact == nil {
+		return r.URL.Path
+	}
+	segs := strings.Split(r.URL.EscapedPath(), "/")
+	for i := 1; i < len(segs); i++ {
+		if segs[i-1] != "users" || segs[i] == "" || (segs[i] == "query" && i >= 2 && segs[i-2] == "admin") {
+			continue
+		}
+		id, err := url.PathUnescape(segs[i])
+		if err != nil {
+			id = segs[i]
+		}
+		segs[i] = url.PathEscape(redact(core.UserID(id)))
+	}
+	return strings.Join(segs, "/")
+}
+
+// TracingOptions configures Tracing.
+type TracingOptions struct {
+	// Routes, if set, resolves the route pattern a request matches, e.g. "/users/{id}/points",
+	// which spans carry as http.route; usually the *http.ServeMux serving the requests
+	Routes interface {
+		Handler(r *http.Request) (http.Handler, string)
+	}
+	// RedactUser, if set, adds the request path to spans as http.target with the user IDs replaced
+	// like AccessLogOptions.RedactUser does, e.g. with the tracer's tracing.Options.RedactUser. Without it spans carry no raw path, since user IDs
+	// may be personal data.
+	RedactUser func(core.UserID) string
+}
+
+// Tracing starts a server span for every request with t, continuing the caller's trace when the
+// request carries a W3C traceparent header. Handlers reach the span through the request context,
+// so engine and storage spans become its children. Responses with a 5xx status mark it failed.
+func Tracing(t *tracing.Tracer, opts TracingOptions) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := t.Start(tracing.Extract(r.Context(), r.Header), "HTTP "+r.Method, tracing.KindServer)
+			span.SetAttr("http.method", r.Method)
+			if route := routePattern(opts.Routes, r); route != "" {
+				span.SetAttr("http.route", route)
+			}
+			if opts.RedactUser != nil {
+				span.SetAttr("http.target", accessLogPath(r, opts.RedactUser))
+			}
+			span.SetAttr("http.request_id", RequestIDFromContext(ctx))
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+			span.SetAttr("http.status_code", sw.Status())
+			var err error
+			if sw.Status() >= 500 {
+				err = errors.New(http.StatusText(sw.Status()))
+			}
+			span.End(err)
+		})
+	}
+}
+
+// routePattern returns the path of the pattern routes matches r with, or "" if none does
+func routePattern(routes interface {
+	Handler(r *http.Request) (http.Handler, string)
+}, r *http.Request) string {
+	if routes == nil {
+		return ""
+	}
+	_, pattern := routes.Handler(r)
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+// StaleHeader flags responses built from user states a stale-on-error storage served from its
+// cache because the storage failed (see engine.StaleOnErrorStorage). Its value is when the oldest
+// of those states was read from storage.
+const StaleHeader = "X-Stale-As-Of"
+
+// StaleReads lets GET requests be answered from the cache of an engine.StaleOnErrorStorage when
+// the storage fails, flagging such responses with StaleHeader. Other methods and WebSocket
+// upgrades always see storage errors.
+func StaleReads() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var mu sync.Mutex
+			var oldest time.Time
+			ctx := engine.AllowStaleReads(r.Context(), func(asOf time.Time) {
+				mu.Lock()
+				defer mu.Unlock()
+				if oldest.IsZero() || asOf.Before(oldest) {
+					oldest = asOf
+					w.Header().Set(StaleHeader, core.FormatTimestamp(asOf))
+				}
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Compress gzips responses for clients that accept it. WebSocket upgrades pass through untouched.
+func Compress() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "" || !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+			gw := &gzipWriter{ResponseWriter: w}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter compresses the body once the handler writes one; bodiless responses stay plain
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	if code != http.StatusNoContent && code != http.StatusNotModified && g.Header().Get("Content-Encoding") == "" {
+		g.Header().Set("Content-Encoding", "gzip")
+		g.Header().Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.gz.Write(p)
+}
+
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		_ = g.gz.Close()
+	}
+}
+
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// statusWriter records the status code and body size written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.wroteHeader = true
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Status is the status code sent, 200 if the handler wrote nothing.
+func (s *statusWriter) Status() int {
+	if !s.wroteHeader {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through; a hijacked connection counts as 101 Switching Protocols
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && !s.wroteHeader {
+		s.wroteHeader = true
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// NamespaceResolver finds the namespace of a request that does not name one in its path, e.g.
+// from its auth token.
+type NamespaceResolver func(r *http.Request) (core.Namespace, error)
+
+// NamespaceAuthorizer checks that the caller of r, as authenticated by Options.Auth, may act in
+// namespace ns, e.g. by looking the game up in the claims of its token. A non-nil error rejects
+// the request with 403.
+type NamespaceAuthorizer func(r *http.Request, ns core.Namespace) error
+
+// Namespaces resolves each request's namespace and stores it in the request context (see
+// core.WithNamespace), so storage wrapped with engine.NamespacedStorage is scoped to it. A path
+// {prefix}/games/{gameId}/... names the namespace and is served as {prefix}/...; other requests
+// ask resolve, if set. Requests without a valid namespace are rejected with 400.
+//
+// A namespace named by the path is chosen by the client, so it is only accepted once authorize
+// lets the caller into it; without authorize such requests are rejected with 403. Resolved
+// namespaces are trusted as resolve derives them from the credential, and are checked with
+// authorize too when it is set.
+func Namespaces(prefix string, resolve NamespaceResolver, authorize NamespaceAuthorizer) Middleware {
+	games := withPrefix(prefix, "/games/")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ns core.Namespace
+			var err error
+			fromPath := false
+			if rest, ok := strings.CutPrefix(r.URL.Path, games); ok {
+				fromPath = true
+				id, tail, _ := strings.Cut(rest, "/")
+				ns = core.Namespace(id)
+				r = r.Clone(r.Context())
+				r.URL.Path = withPrefix(prefix, "/"+tail)
+				r.URL.RawPath = ""
+			} else if resolve != nil {
+				ns, err = resolve(r)
+			}
+			if err == nil && ns == "" {
+				err = core.ErrNoNamespace
+			}
+			if err == nil {
+				err = core.ValidateNamespace(ns)
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error(), RequestIDFromContext(r.Context()))
+				return
+			}
+			switch {
+			case authorize != nil:
+				err = authorize(r, ns)
+			case fromPath:
+				err = errors.New("namespaces named in the path require a namespace authorizer")
+			}
+			if err != nil {
+				writeError(w, http.StatusForbidden, err.Error(), RequestIDFromContext(r.Context()))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(core.WithNamespace(r.Context(), ns)))
+		})
+	}
+}
+
+// errorStatus is the status answering an unexpected error: 504 when the request's context ended
+// before the storage answered, whatever the adapter, 500 otherwise
+func errorStatus(err error) int {
+	if core.IsContextDone(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError sends a JSON error envelope: {"error": msg, "request_id": id}
+func writeError(w http.ResponseWriter, status int, msg, requestID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	body := map[string]string{"error": msg}
+	if requestID != "" {
+		body["request_id"] = requestID
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
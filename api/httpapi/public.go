@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// publicRoutes matches requests against Options.PublicRoutes; the nil *publicRoutes matches none
+type publicRoutes struct {
+	mux *http.ServeMux
+}
+
+// newPublicRoutes compiles patterns, relative to prefix, with the rules of http.ServeMux. Only GET
+// and HEAD routes may be public, so a pattern without a method, which would match writes too, is
+// refused. It panics on such patterns and on invalid or conflicting ones, like ServeMux.Handle.
+func newPublicRoutes(prefix string, patterns []string) *publicRoutes {
+	if len(patterns) == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		method, path, ok := strings.Cut(p, " ")
+		if !ok || (method != http.MethodGet && method != http.MethodHead) {
+			panic(fmt.Sprintf("httpapi: public route %q: must start with GET or HEAD", p))
+		}
+		if path = strings.TrimSpace(path); !strings.HasPrefix(path, "/") {
+			panic(fmt.Sprintf("httpapi: public route %q: path must start with /", p))
+		}
+		mux.Handle(method+" "+withPrefix(prefix, path), http.NotFoundHandler())
+	}
+	return &publicRoutes{mux: mux}
+}
+
+// match reports whether r is for a public route. CORS preflights match by the method they ask
+// about, so a public GET route can be preflighted without credentials.
+func (p *publicRoutes) match(r *http.Request) bool {
+	if p == nil {
+		return false
+	}
+	if m := r.Header.Get("Access-Control-Request-Method"); r.Method == http.MethodOptions && m != "" {
+		preflight := *r
+		preflight.Method = m
+		r = &preflight
+	}
+	_, pattern := p.mux.Handler(r)
+	return pattern != ""
+}
+
+// publicCORS answers CORS requests for public routes from any origin and passes other requests to
+// protected, which applies the configured policy
+func publicCORS(public *publicRoutes, protected Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		open := withCORS(next, func() string { return "*" })
+		guarded := next
+		if protected != nil {
+			guarded = protected(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public.match(r) {
+				open.ServeHTTP(w, r)
+				return
+			}
+			guarded.ServeHTTP(w, r)
+		})
+	}
+}
+
+// exceptPublic applies mw to every request except those for public routes
+func exceptPublic(mw Middleware, public *publicRoutes) Middleware {
+	if public == nil {
+		return mw
+	}
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public.match(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
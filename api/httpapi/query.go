@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// queryUsers answers GET /admin/users/query with the users matching the filter in the query
+// string and, when the page is full, the cursor of the next page.
+func queryUsers(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	q := r.URL.Query()
+	filter := core.UserFilter{Badge: core.Badge(q.Get("badge")), Metric: core.Metric(q.Get("metric")), After: core.UserID(q.Get("after"))}
+	var err error
+	for _, p := range []struct {
+		name string
+		dst  **int64
+	}{{"min", &filter.MinPoints}, {"max", &filter.MaxPoints}, {"level", &filter.Level}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil {
+			writeError(w, http.StatusBadRequest, "invalid "+p.name, RequestIDFromContext(r.Context()))
+			return
+		}
+		*p.dst = &n
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit", RequestIDFromContext(r.Context()))
+			return
+		}
+	}
+	users, err := svc.QueryUsers(r.Context(), filter)
+	switch {
+	case errors.Is(err, core.ErrInvalidFilter):
+		writeError(w, http.StatusBadRequest, err.Error(), RequestIDFromContext(r.Context()))
+		return
+	case errors.Is(err, engine.ErrQueryUnsupported):
+		writeError(w, http.StatusNotImplemented, err.Error(), RequestIDFromContext(r.Context()))
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+		return
+	}
+	if users == nil {
+		users = []core.UserID{}
+	}
+	resp := map[string]any{"users": users}
+	limit := filter.Limit
+	if limit == 0 {
+		limit = engine.DefaultQueryLimit
+	}
+	if len(users) == limit {
+		resp["next"] = users[len(users)-1]
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// listQuests answers GET /users/{id}/quests with the user's progress on every quest in its current
+// period, 501 when the storage keeps no quest progress.
+func listQuests(w http.ResponseWriter, r *http.Request, svc *engine.GamifyService) {
+	requestID := RequestIDFromContext(r.Context())
+	user, err := core.NormalizeUserID(core.UserID(r.PathValue("id")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), requestID)
+		return
+	}
+	quests, err := svc.GetQuests(r.Context(), user)
+	switch {
+	case errors.Is(err, engine.ErrQuestsUnsupported):
+		writeError(w, http.StatusNotImplemented, err.Error(), requestID)
+		return
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), requestID)
+		return
+	}
+	writeJSON(w, map[string]any{"quests": quests})
+}
@@ -0,0 +1,164 @@
+package httpapi
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimit describes a per-client token bucket.
+type RateLimit struct {
+	RequestsPerMinute int
+	Burst             int
+}
+
+// RateLimitStore keeps token buckets outside the process, so server instances behind a load
+// balancer share one limit per client; see redis.RateLimitStore.
+type RateLimitStore interface {
+	// Take atomically consumes a token from key's bucket, which refills at perSecond tokens per
+	// second up to burst. When it returns false, retryAfter says when a token will be available.
+	Take(ctx context.Context, key string, perSecond float64, burst int) (ok bool, retryAfter time.Duration, err error)
+}
+
+// RateLimiterOption configures a RateLimiter.
+type RateLimiterOption func(*RateLimiter)
+
+// WithRateLimitStore keeps the buckets in store instead of in memory. Requests are let through
+// while the store fails, after reporting the error to onError (if set), so an outage of the store
+// does not take the API down with it.
+func WithRateLimitStore(store RateLimitStore, onError func(error)) RateLimiterOption {
+	return func(rl *RateLimiter) { rl.store, rl.onStoreError = store, onError }
+}
+
+// RateLimiter is a per-client-IP token bucket limiter, in memory unless WithRateLimitStore shares
+// the buckets between instances. Its limits can be replaced at runtime with SetLimit without
+// dropping existing buckets.
+type RateLimiter struct {
+	limit   atomic.Pointer[RateLimit]
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// idle orders the buckets by last use, least recent first, so eviction only looks at the
+	// buckets it drops
+	idle    *list.List
+	idleTTL time.Duration
+	now     func() time.Time
+
+	store        RateLimitStore
+	onStoreError func(error)
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter; in-memory buckets idle for longer than idleTTL are dropped
+// (0 keeps them).
+func NewRateLimiter(limit RateLimit, idleTTL time.Duration, opts ...RateLimiterOption) *RateLimiter {
+	rl := &RateLimiter{buckets: map[string]*list.Element{}, idle: list.New(), idleTTL: idleTTL, now: time.Now}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	rl.SetLimit(limit)
+	return rl
+}
+
+// SetLimit atomically swaps the limits used for subsequent requests.
+func (rl *RateLimiter) SetLimit(limit RateLimit) {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	rl.limit.Store(&limit)
+}
+
+// Limit returns the limits currently in effect.
+func (rl *RateLimiter) Limit() RateLimit {
+	return *rl.limit.Load()
+}
+
+// Allow consumes a token for key. When it returns false, retryAfter says when a token will be available.
+func (rl *RateLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	return rl.allow(context.Background(), key)
+}
+
+func (rl *RateLimiter) allow(ctx context.Context, key string) (bool, time.Duration) {
+	limit := rl.Limit()
+	if limit.RequestsPerMinute <= 0 {
+		return true, 0
+	}
+	perSecond := float64(limit.RequestsPerMinute) / 60
+	if rl.store != nil {
+		ok, retryAfter, err := rl.store.Take(ctx, key, perSecond, limit.Burst)
+		if err != nil {
+			if rl.onStoreError != nil {
+				rl.onStoreError(err)
+			}
+			return true, 0
+		}
+		return ok, retryAfter
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	rl.evictLocked(now)
+
+	var b *bucket
+	if e, exists := rl.buckets[key]; exists {
+		b = e.Value.(*bucket)
+		rl.idle.MoveToBack(e)
+	} else {
+		b = &bucket{key: key, tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = rl.idle.PushBack(b)
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+}
+
+// evictLocked drops buckets that have been idle longer than idleTTL, starting from the least
+// recently used
+func (rl *RateLimiter) evictLocked(now time.Time) {
+	if rl.idleTTL <= 0 {
+		return
+	}
+	for e := rl.idle.Front(); e != nil; e = rl.idle.Front() {
+		b := e.Value.(*bucket)
+		if now.Sub(b.last) <= rl.idleTTL {
+			return
+		}
+		rl.idle.Remove(e)
+		delete(rl.buckets, b.key)
+	}
+}
+
+// Middleware rejects requests over the limit with 429 and a Retry-After header.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := rl.allow(r.Context(), clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,23 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"gamifykit/engine"
+)
+
+// reloadRules answers POST /admin/rules/reload. A configuration that fails validation is answered
+// with 422 and leaves the running rules in place.
+func reloadRules(w http.ResponseWriter, r *http.Request, reload func(context.Context) error) {
+	err := reload(r.Context())
+	switch {
+	case errors.Is(err, engine.ErrInvalidRules):
+		writeError(w, http.StatusUnprocessableEntity, err.Error(), RequestIDFromContext(r.Context()))
+	case err != nil:
+		writeError(w, errorStatus(err), err.Error(), RequestIDFromContext(r.Context()))
+	default:
+		writeJSON(w, map[string]string{"status": "reloaded"})
+	}
+}
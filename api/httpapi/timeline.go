@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gamifykit/analytics"
+	"gamifykit/core"
+)
+
+const (
+	// DefaultTimelineLimit is the number of timeline entries served when a request sets no limit.
+	DefaultTimelineLimit = 50
+	// MaxTimelineLimit is the largest timeline limit a request may ask for.
+	MaxTimelineLimit = 200
+)
+
+// TimelineReader pages through a user's activity; see analytics.Timeline.
+type TimelineReader interface {
+	Page(ctx context.Context, user core.UserID, cursor string, limit int) (analytics.TimelinePage, error)
+}
+
+// timelineHandler serves a user's points, badges, levels and achievements newest first. Like
+// leaderboard limits, limits outside 1..MaxTimelineLimit are answered 400 rather than clamped.
+func timelineHandler(timeline TimelineReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := RequestIDFromContext(r.Context())
+		limit := DefaultTimelineLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > MaxTimelineLimit {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be a number between 1 and %d", MaxTimelineLimit), requestID)
+				return
+			}
+			limit = n
+		}
+		page, err := timeline.Page(r.Context(), core.UserID(r.PathValue("id")), r.URL.Query().Get("cursor"), limit)
+		switch {
+		case errors.Is(err, analytics.ErrInvalidCursor):
+			writeError(w, http.StatusBadRequest, err.Error(), requestID)
+			return
+		case err != nil:
+			writeError(w, errorStatus(err), err.Error(), requestID)
+			return
+		}
+		writeJSON(w, page)
+	}
+}
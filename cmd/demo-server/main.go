@@ -11,6 +11,7 @@ import (
 
 	mem "gamifykit/adapters/memory"
 	ws "gamifykit/adapters/websocket"
+	"gamifykit/api/httpapi"
 	"gamifykit/core"
 	"gamifykit/engine"
 	"gamifykit/realtime"
@@ -28,11 +29,10 @@ func main() {
 	bus := engine.NewEventBus(engine.DispatchAsync)
 	svc := engine.NewGamifyService(store, bus, engine.DefaultRuleEngine())
 	hub := realtime.NewHub()
+	metrics := httpapi.MetricPolicy{Default: core.MetricXP}
 
 	// Forward gamification events to WebSocket clients
-	bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event) { hub.Broadcast(ctx, e) })
-	bus.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event) { hub.Broadcast(ctx, e) })
-	bus.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event) { hub.Broadcast(ctx, e) })
+	bus.SubscribeAllNamed("realtime", func(ctx context.Context, e core.Event) { hub.Broadcast(ctx, e) }, engine.OrderingFIFO)
 
 	http.Handle("/ws", ws.Handler(hub))
 	http.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
@@ -46,9 +46,10 @@ func main() {
 		switch r.Method {
 		case http.MethodPost:
 			if len(parts) >= 3 && parts[2] == "points" {
-				metric := core.Metric(r.URL.Query().Get("metric"))
-				if metric == "" {
-					metric = core.MetricXP
+				metric, err := metrics.Resolve(r.URL.Query().Get("metric"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
 				}
 				delta, _ := strconv.ParseInt(r.URL.Query().Get("delta"), 10, 64)
 				total, err := svc.AddPoints(ctx, user, metric, delta)
@@ -57,8 +58,8 @@ func main() {
 			}
 			if len(parts) >= 4 && parts[2] == "badges" {
 				badge := core.Badge(parts[3])
-				err := svc.AwardBadge(ctx, user, badge)
-				writeJSON(w, map[string]any{"ok": err == nil, "err": errString(err)})
+				awarded, err := svc.AwardBadgeResult(ctx, user, badge)
+				writeJSON(w, map[string]any{"ok": err == nil, "newly_awarded": awarded, "err": errString(err)})
 				return
 			}
 		case http.MethodGet:
@@ -0,0 +1,146 @@
+// Command gamifykit-bench runs the same mixed read/write workload against a storage adapter and
+// prints throughput, latency percentiles and error counts, so adapters can be compared on numbers
+// from your own workload. The adapter connects through the standard configuration (environment or
+// GAMIFYKIT_CONFIG_FILE), e.g.
+//
+//	GAMIFYKIT_STORAGE_ADAPTER=redis gamifykit-bench -prefix bench- -users 5000 -concurrency 32 -read-ratio 0.8 -json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gamifykit/adapters/jsonfile"
+	mem "gamifykit/adapters/memory"
+	redisAdapter "gamifykit/adapters/redis"
+	sqlxAdapter "gamifykit/adapters/sqlx"
+	"gamifykit/adapters/storagetest"
+	"gamifykit/config"
+	"gamifykit/engine"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// report is the printed result; the adapter name keeps JSON reports of several runs apart
+type report struct {
+	Adapter string `json:"adapter"`
+	storagetest.WorkloadReport
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gamifykit-bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	adapter := fs.String("adapter", "", "memory, redis, sql or file (defaults to the configured adapter)")
+	users := fs.Int("users", 100, "distinct users the operations are spread over")
+	concurrency := fs.Int("concurrency", 8, "parallel workers")
+	readRatio := fs.Float64("read-ratio", 0.8, "fraction of operations that are reads (0-1)")
+	ops := fs.Int("ops", 10000, "total operations")
+	seed := fs.Int64("seed", 1, "seed for the operation mix")
+	prefix := fs.String("prefix", "", "user ID prefix no real user has, e.g. bench-; required, since the users are cleared afterwards")
+	keep := fs.Bool("keep", false, "keep the benchmark users instead of clearing them afterwards")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// the benchmark writes to and clears every user with the prefix, so it must be chosen for it
+	if *prefix == "" {
+		fmt.Fprintln(stderr, "-prefix is required: pick a user ID prefix reserved for benchmark users")
+		return 2
+	}
+
+	cfg, err := config.LoadForCommand(ctx, config.Flags{Storage: *adapter})
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	storage, closeStorage, err := setupStorage(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to set up %s storage: %v\n", cfg.Storage.Adapter, err)
+		return 1
+	}
+	defer closeStorage()
+
+	w := storagetest.Workload{Users: *users, Concurrency: *concurrency, ReadRatio: *readRatio, Ops: *ops, Seed: *seed, Prefix: *prefix}
+	res, err := storagetest.RunWorkload(ctx, storage, w)
+	if err != nil && res.Ops == 0 {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "benchmark interrupted after %d operations: %v\n", res.Ops, err)
+	}
+	if !*keep {
+		if cerr := storagetest.CleanupWorkload(context.WithoutCancel(ctx), storage, w); cerr != nil {
+			fmt.Fprintf(stderr, "Failed to clean up benchmark users: %v\n", cerr)
+		}
+	}
+
+	out := report{Adapter: cfg.Storage.Adapter, WorkloadReport: res}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+	} else {
+		printReport(stdout, out)
+	}
+	if err != nil || res.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printReport(w io.Writer, r report) {
+	fmt.Fprintf(w, "adapter      %s\n", r.Adapter)
+	fmt.Fprintf(w, "workload     %d users, %d workers, %.0f%% reads\n", r.Users, r.Concurrency, r.ReadRatio*100)
+	fmt.Fprintf(w, "operations   %d in %s (%.0f ops/s), %d errors\n", r.Ops, r.Elapsed.Round(time.Millisecond), r.Throughput, r.Errors)
+	if r.FirstError != "" {
+		fmt.Fprintf(w, "first error  %s\n", r.FirstError)
+	}
+	fmt.Fprintf(w, "\n%-8s %8s %7s %10s %10s %10s %10s\n", "", "count", "errors", "p50", "p90", "p99", "max")
+	for _, row := range []struct {
+		name string
+		s    storagetest.LatencyStats
+	}{{"reads", r.Reads}, {"writes", r.Writes}} {
+		fmt.Fprintf(w, "%-8s %8d %7d %10s %10s %10s %10s\n", row.name, row.s.Count, row.s.Errors,
+			row.s.P50, row.s.P90, row.s.P99, row.s.Max)
+	}
+}
+
+// setupStorage connects the configured adapter and returns a func that releases it
+func setupStorage(cfg *config.Config) (engine.Storage, func(), error) {
+	noop := func() {}
+	switch cfg.Storage.Adapter {
+	case "memory":
+		return mem.New(), noop, nil
+	case "redis":
+		s, err := redisAdapter.New(cfg.Storage.Redis)
+		if err != nil {
+			return nil, noop, err
+		}
+		return s, func() { _ = s.Close() }, nil
+	case "sql":
+		s, err := sqlxAdapter.New(cfg.Storage.SQL)
+		if err != nil {
+			return nil, noop, err
+		}
+		return s, func() { _ = s.Close() }, nil
+	case "file":
+		s, err := jsonfile.New(cfg.Storage.File.Path)
+		return s, noop, err
+	case "mongo":
+		return nil, noop, fmt.Errorf("there is no MongoDB adapter yet")
+	default:
+		return nil, noop, fmt.Errorf("unknown storage adapter: %s", cfg.Storage.Adapter)
+	}
+}
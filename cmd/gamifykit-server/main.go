@@ -2,68 +2,359 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"gamifykit/adapters/jsonfile"
 	mem "gamifykit/adapters/memory"
 	redisAdapter "gamifykit/adapters/redis"
 	sqlxAdapter "gamifykit/adapters/sqlx"
+	"gamifykit/analytics"
 	"gamifykit/api/httpapi"
 	"gamifykit/config"
+	"gamifykit/core"
 	"gamifykit/engine"
 	"gamifykit/gamify"
+	"gamifykit/logging"
+	"gamifykit/metrics"
 	"gamifykit/realtime"
+	"gamifykit/tracing"
 )
 
 func main() {
+	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-analytics" {
+		os.Exit(rebuildAnalytics(ctx, os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importUsers(ctx, os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(ctx, os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Command-line flags take precedence over the config file and environment
+	var flags config.Flags
+	flags.Register(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s import|migrate|rebuild-analytics [flags]\n\nFlags:\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadForCommand(ctx, flags)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Setup logging based on configuration
-	setupLogging(cfg)
+	logLevel := setupLogging(cfg)
 
-	// Load secrets if in production
-	ctx := context.Background()
-	if cfg.Environment == config.EnvProduction {
-		if err := cfg.LoadSecretsFromEnv(ctx); err != nil {
-			slog.Error("Failed to load secrets", "error", err)
-			os.Exit(1)
-		}
+	// Setup tracing; spans are only recorded when it is enabled
+	tracer, err := setupTracing(cfg)
+	if err != nil {
+		slog.Error("Failed to setup tracing", "error", err)
+		os.Exit(1)
 	}
+	tracing.SetDefault(tracer)
 
 	slog.Info("starting gamifykit server",
 		"environment", cfg.Environment,
 		"profile", cfg.Profile,
 		"address", cfg.Server.Address,
 		"storage_adapter", cfg.Storage.Adapter)
+	if cfg.Debug {
+		slog.Warn("debug mode is enabled; POST /admin/debug/emit broadcasts synthetic events")
+	}
 
 	// Setup storage adapter
+	core.SetTimestampPrecision(cfg.Storage.TimestampPrecision)
 	storage, err := setupStorage(ctx, cfg)
 	if err != nil {
 		slog.Error("Failed to setup storage", "error", err)
 		os.Exit(1)
 	}
 
+	// Release expired point history of the memory adapter in the background
+	if store, ok := storage.(*mem.Store); ok {
+		store.SetHistoryLimit(cfg.Storage.HistoryLimit)
+		pruned := metrics.Default.Counter("gamifykit_history_pruned_total", "Point history entries released by compaction")
+		compactCtx, stopCompaction := context.WithCancel(ctx)
+		defer stopCompaction()
+		go store.RunCompaction(compactCtx, cfg.Storage.CompactionInterval, func(n int) { pruned.Add(uint64(n)) })
+	}
+	// Delete point increments of the sql adapter that are older than the retention
+	if store, ok := storage.(*sqlxAdapter.Store); ok {
+		pruned := metrics.Default.Counter("gamifykit_history_pruned_total", "Point history entries released by compaction")
+		retentionCtx, stopRetention := context.WithCancel(ctx)
+		defer stopRetention()
+		go store.RunPointsRetention(retentionCtx, cfg.Storage.CompactionInterval, func(n int64, err error) {
+			if err != nil {
+				slog.Warn("Failed to prune point history", "error", err)
+				return
+			}
+			pruned.Add(uint64(n))
+		})
+	}
+
+	// Components below are swapped atomically on SIGHUP
+	initialRules, err := buildRules(cfg)
+	if err != nil {
+		slog.Error("Failed to load rules", "error", err)
+		os.Exit(1)
+	}
+	rules := engine.NewSwappableRuleEngine(initialRules)
+	var rulesCfg atomic.Pointer[config.Config]
+	rulesCfg.Store(cfg)
+	reloadRules := func(context.Context) error {
+		next, err := buildRules(rulesCfg.Load())
+		if err != nil {
+			return err
+		}
+		rules.Swap(next)
+		return nil
+	}
+	// Rate limits and idempotency keys are per instance unless shared through Redis
+	var limiterOpts []httpapi.RateLimiterOption
+	var idempotency httpapi.IdempotencyStore = httpapi.NewMemoryIdempotencyStore()
+	if cfg.Security.SharedState == "redis" {
+		client, err := redisAdapter.NewClient(cfg.Security.Redis)
+		if err != nil {
+			slog.Error("Failed to connect the shared security state", "error", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		limiterOpts = append(limiterOpts, httpapi.WithRateLimitStore(
+			redisAdapter.NewRateLimitStore(client, cfg.Security.Redis.KeyPrefix),
+			func(err error) { slog.Warn("Rate limit store unavailable, letting requests through", "error", err) }))
+		idempotency = redisAdapter.NewIdempotencyStore(client, cfg.Security.Redis.KeyPrefix)
+	}
+	if cfg.Security.IdempotencyTTL == 0 {
+		idempotency = nil
+	}
+	limiter := httpapi.NewRateLimiter(rateLimit(cfg), cfg.Security.RateLimit.CleanupInterval, limiterOpts...)
+	var corsOrigin atomic.Pointer[string]
+	corsOrigin.Store(&cfg.Server.CORSOrigin)
+
 	// Build service
 	hub := realtime.NewHub()
-	svc := gamify.New(
-		gamify.WithRealtime(hub),
-		gamify.WithStorage(storage),
+	clients := metrics.Default.Gauge("gamifykit_realtime_clients", "Connected realtime (WebSocket) clients")
+	hub.OnClientCount(func(n int) { clients.Set(float64(n)) })
+	if codec, ok := realtime.CodecByName(cfg.Server.WSCodec); ok {
+		hub.SetCodec(codec)
+	}
+	unacked := metrics.Default.Counter("gamifykit_realtime_unacked_events_total", "Events WebSocket clients never acknowledged, given up on after all redeliveries")
+	hub.SetAckPolicy(realtime.AckPolicy{
+		Timeout:         cfg.Realtime.AckTimeout,
+		MaxRedeliveries: cfg.Realtime.AckRedeliveries,
+		OnUnacked: func(conn realtime.ConnInfo, e core.Event) {
+			unacked.Inc()
+			slog.Warn("WebSocket client did not acknowledge event", "remote", conn.RemoteAddr, "type", e.Type, "user", e.UserID, "id", e.ID)
+		},
+	})
+	dispatch := metrics.Default.HistogramVec("gamifykit_event_dispatch_seconds", "Time event subscribers take to handle an event", []string{"subscriber", "event"}, nil)
+	retries := metrics.Default.Counter("gamifykit_storage_retries_total", "Storage writes retried after transient errors")
+	limitRejections := metrics.Default.Counter("gamifykit_user_limit_rejections_total", "Writes rejected for exceeding the per-user metric or badge cap")
+	skewed := metrics.Default.Counter("gamifykit_clock_skew_events_total", "Events whose time was replaced for being too far off the server clock")
+	maxSkew := cfg.Server.MaxClockSkew
+	if maxSkew == 0 {
+		maxSkew = engine.DefaultMaxClockSkew
+	}
+	// Keep serving reads, flagged as stale, through short storage outages when enabled
+	serviceStorage := storage
+	if cfg.Storage.ServeStale {
+		staleReads := metrics.Default.Counter("gamifykit_stale_reads_total", "Reads answered from cached state because the storage failed")
+		serviceStorage = engine.StaleOnErrorStorage(storage, engine.StaleReadOptions{
+			MaxStaleness: cfg.Storage.MaxStaleness,
+			OnStale: func(user core.UserID, age time.Duration, err error) {
+				staleReads.Inc()
+				slog.Warn("Serving stale state after a storage read failed", "age", age, "error", err)
+			},
+		})
+	}
+	svcOpts := []gamify.Option{
+		gamify.WithRetry(engine.RetryPolicy{
+			MaxAttempts: cfg.Storage.RetryAttempts,
+			Backoff:     cfg.Storage.RetryBackoff,
+			OnRetry: func(op string, attempt int, err error) {
+				retries.Inc()
+				slog.Warn("Retrying storage write", "op", op, "attempt", attempt, "error", err)
+			},
+		}),
+		gamify.WithDispatchObserver(func(subscriber string, typ core.EventType, took time.Duration) {
+			dispatch.Observe(took.Seconds(), subscriber, string(typ))
+		}),
+		gamify.WithSlowSubscriberThreshold(cfg.Metrics.SlowSubscriberThreshold),
+		gamify.WithClockSkew(maxSkew, func(e core.Event, skew time.Duration) {
+			skewed.Inc()
+			slog.Warn("Replaced event time off the server clock", "type", e.Type, "user", e.UserID, "time", e.Time, "skew", skew)
+		}),
+		gamify.WithUserLimits(engine.UserLimits{
+			MaxMetrics: cfg.Security.MaxMetricsPerUser,
+			MaxBadges:  cfg.Security.MaxBadgesPerUser,
+			OnExceeded: func(string, core.UserID) { limitRejections.Inc() },
+		}),
+		// Tag events with the request ID and namespace of the API call that caused them
+		gamify.WithEventEnricher(httpapi.EnrichEvent),
+		gamify.WithStorage(serviceStorage),
+		gamify.WithRuleEngine(rules),
 		gamify.WithDispatchMode(engine.DispatchAsync),
-	)
+	}
+	if cfg.Storage.SerializeUsers {
+		svcOpts = append(svcOpts, gamify.WithUserSerialization())
+	}
+	// Flag the configured event types for acknowledged realtime delivery
+	if len(cfg.Realtime.AckEvents) > 0 {
+		ackTypes := make(map[core.EventType]bool, len(cfg.Realtime.AckEvents))
+		for _, typ := range cfg.Realtime.AckEvents {
+			ackTypes[core.EventType(strings.TrimSpace(typ))] = true
+		}
+		svcOpts = append(svcOpts, gamify.WithEventEnricher(func(_ context.Context, e *core.Event) {
+			if ackTypes[e.Type] {
+				*e = core.RequireAck(*e)
+			}
+		}))
+	}
+	if cfg.Realtime.MaxEventBytes > 0 {
+		oversized := metrics.Default.Counter("gamifykit_realtime_oversized_events_total", "Events over the realtime size limit, truncated or dropped before broadcast")
+		action := engine.OversizeTruncate
+		if cfg.Realtime.OversizeAction == "drop" {
+			action = engine.OversizeDrop
+		}
+		svcOpts = append(svcOpts, gamify.WithRealtimeEventLimit(engine.EventSizeLimit{
+			MaxBytes: cfg.Realtime.MaxEventBytes,
+			Action:   action,
+			OnOversize: func(e core.Event, size int, dropped bool) {
+				oversized.Inc()
+				slog.Warn("Oversized event limited before realtime broadcast", "type", e.Type, "user", e.UserID, "id", e.ID, "size", size, "dropped", dropped)
+			},
+		}))
+	}
+
+	// Share realtime events with the other instances when a backplane is configured
+	if cfg.Realtime.Backplane == "redis" {
+		client, err := redisAdapter.NewClient(cfg.Realtime.Redis)
+		if err != nil {
+			slog.Error("Failed to connect the realtime backplane", "error", err)
+			os.Exit(1)
+		}
+		defer client.Close()
+		backplane := redisAdapter.NewBackplane(client, cfg.Realtime.Channel)
+		backplane.OnReconnect = func(err error) { slog.Warn("Reconnecting to the realtime backplane", "error", err) }
+		relay := realtime.NewRelay(hub, backplane, realtime.RelayOptions{
+			OnError: func(err error) { slog.Warn("Realtime backplane error", "error", err) },
+		})
+		relayCtx, stopRelay := context.WithCancel(ctx)
+		defer stopRelay()
+		go func() { _ = relay.Run(relayCtx) }()
+		svcOpts = append(svcOpts, gamify.WithRealtimeRelay(relay))
+	} else {
+		svcOpts = append(svcOpts, gamify.WithRealtime(hub))
+	}
+	svc := gamify.New(append(svcOpts, catalogOptions(cfg)...)...)
+
+	// Record events for offline analytics rebuilds and serve user timelines, past states and exports from them
+	var timeline httpapi.TimelineReader
+	var history httpapi.StateHistoryReader
+	var events analytics.EventPager
+	if cfg.Storage.EventLog != "" {
+		eventLog, err := analytics.NewFileEventLog(cfg.Storage.EventLog)
+		if err != nil {
+			slog.Error("Failed to open event log", "error", err)
+			os.Exit(1)
+		}
+		index := analytics.NewTimeline(0)
+		if _, err := analytics.RebuildAnalytics(ctx, eventLog, index); err != nil {
+			slog.Error("Failed to load user timelines from the event log", "error", err)
+			os.Exit(1)
+		}
+		timeline = index
+		history = analytics.NewStateHistory(eventLog)
+		events = eventLog
+		recordEvents(svc.SubscribeNamed, eventLog, index)
+		compacted := metrics.Default.Counter("gamifykit_event_log_compacted_total", "Events dropped from the event log by retention")
+		rolledUp := metrics.Default.Counter("gamifykit_event_log_rolled_up_total", "Opening-balance events written in place of dropped ones")
+		compactFailures := metrics.Default.Counter("gamifykit_event_log_compaction_failures_total", "Event log compactions that failed")
+		retentionCtx, stopRetention := context.WithCancel(ctx)
+		defer stopRetention()
+		policy := analytics.RetentionPolicy{MaxAge: cfg.Storage.EventLogRetention, Rollup: cfg.Storage.EventLogRollup}
+		go eventLog.RunRetention(retentionCtx, policy, 0, func(res analytics.CompactResult, err error) {
+			if err != nil {
+				compactFailures.Inc()
+				slog.Error("Failed to compact the event log", "error", err)
+				return
+			}
+			compacted.Add(uint64(res.Removed))
+			rolledUp.Add(uint64(res.RolledUp))
+		})
+		svc.OnShutdown("event-log", func(context.Context) error { return eventLog.Close() })
+	}
+
+	// Write the file store's buffered changes once the last events are handled
+	if store, ok := storage.(*jsonfile.Store); ok {
+		svc.OnShutdown("storage", func(context.Context) error { return store.Close() })
+	}
+
+	// Export the spans still queued once the last events are delivered
+	if tracer != nil {
+		svc.OnShutdown("tracing", tracer.Shutdown)
+	}
+
+	// Readiness flips to false as soon as shutdown is requested
+	var ready atomic.Bool
+	ready.Store(true)
+
+	// Shed load once the in-flight caps are reached
+	var shedder *httpapi.LoadShedder
+	if cfg.Server.MaxInFlightWrites > 0 || cfg.Server.MaxInFlightReads > 0 {
+		shedder = httpapi.NewLoadShedder(httpapi.ConcurrencyLimit{
+			MaxWrites:      cfg.Server.MaxInFlightWrites,
+			MaxReads:       cfg.Server.MaxInFlightReads,
+			AcquireTimeout: cfg.Server.LoadShedWait,
+		})
+		inFlightReads := metrics.Default.Gauge("gamifykit_http_in_flight_reads", "HTTP read requests being served")
+		inFlightWrites := metrics.Default.Gauge("gamifykit_http_in_flight_writes", "HTTP mutating requests being served")
+		shedder.OnInFlight(func(reads, writes int) {
+			inFlightReads.Set(float64(reads))
+			inFlightWrites.Set(float64(writes))
+		})
+	}
 
 	// Setup HTTP API
 	handler := httpapi.NewMux(svc, hub, httpapi.Options{
-		PathPrefix:      cfg.Server.PathPrefix,
-		AllowCORSOrigin: cfg.Server.CORSOrigin,
+		Ready:               &ready,
+		PathPrefix:          cfg.Server.PathPrefix,
+		CORSOrigin:          func() string { return *corsOrigin.Load() },
+		PublicRoutes:        cfg.Server.PublicRoutes,
+		WSAllowedOrigins:    wsOrigins(cfg),
+		WSCompression:       cfg.Server.WSCompression,
+		ReloadRules:         reloadRules,
+		RateLimiter:         limiter,
+		Idempotency:         idempotency,
+		IdempotencyTTL:      cfg.Security.IdempotencyTTL,
+		LoadShedder:         shedder,
+		AdminToken:          cfg.Security.AdminToken.Reveal(),
+		ImportStorage:       storage,
+		NotFoundOnEmptyUser: cfg.Server.NotFoundOnEmptyUser,
+		DefaultMetric:       core.Metric(cfg.Server.DefaultMetric),
+		RequireMetric:       cfg.Server.RequireMetric,
+		Tracer:              tracer,
+		LogRequests:         cfg.Logging.AccessLog,
+		AccessLog:           accessLogOptions(cfg),
+		Timeline:            timeline,
+		History:             history,
+		Events:              events,
+		Debug:               cfg.Debug,
 	})
 
 	// Create HTTP server
@@ -85,30 +376,169 @@ func main() {
 		}
 	}()
 
-	// Setup graceful shutdown
+	// Serve metrics on their own listener so scrapes bypass the API middleware
+	if cfg.Metrics.Enabled {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(cfg.Metrics.Path, metrics.Default.Handler())
+		metricsSrv := &http.Server{Addr: cfg.Metrics.Address, Handler: metricsMux, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout}
+		go func() {
+			slog.Info("metrics listening", "address", cfg.Metrics.Address, "path", cfg.Metrics.Path)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("metrics server failed", "error", err)
+			}
+		}()
+		defer metricsSrv.Close()
+	}
+
+	// SIGHUP reloads configuration; SIGINT/SIGTERM shut down gracefully
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+
+	for waiting := true; waiting; {
+		select {
+		case <-reload:
+			next, err := config.LoadForCommand(ctx, flags)
+			if err != nil {
+				slog.Error("config reload failed, keeping current configuration", "error", err)
+				continue
+			}
+			if changed := cfg.RestartRequired(next); len(changed) > 0 {
+				slog.Warn("config changes require restart and were ignored", "settings", changed)
+			}
+			cfg = cfg.WithReloadable(next)
+			logLevel.Set(parseLogLevel(cfg.Logging.Level))
+			corsOrigin.Store(&cfg.Server.CORSOrigin)
+			limiter.SetLimit(rateLimit(cfg))
+			rulesCfg.Store(cfg)
+			if err := reloadRules(ctx); err != nil {
+				slog.Error("rules reload failed, keeping current rules", "error", err)
+			}
+			slog.Info("configuration reloaded",
+				"log_level", cfg.Logging.Level,
+				"cors_origin", cfg.Server.CORSOrigin,
+				"rate_limit_enabled", cfg.Security.EnableRateLimit,
+				"level_metrics", cfg.Rules.LevelMetrics,
+				"rules_file", cfg.Rules.File)
+		case <-quit:
+			waiting = false
+		}
+	}
+
+	// Fail readiness first and give load balancers time to stop routing new traffic
+	ready.Store(false)
+	if cfg.Server.DrainDelay > 0 {
+		slog.Info("draining before shutdown", "delay", cfg.Server.DrainDelay)
+		time.Sleep(cfg.Server.DrainDelay)
+	}
 
 	slog.Info("shutting down server", "timeout", cfg.Server.ShutdownTimeout)
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
+	// Stop taking requests first, then deliver the events they produced and flush their consumers
+	failed := false
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("error during server shutdown", "error", err)
+		failed = true
+	}
+	if err := svc.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error during service shutdown", "error", err)
+		failed = true
+	}
+	if failed {
 		os.Exit(1)
 	}
 
 	slog.Info("server stopped")
 }
 
-// setupLogging configures the logger based on configuration
-func setupLogging(cfg *config.Config) {
+// buildRules compiles the configured rules file, or builds the level rules when there is none
+func buildRules(cfg *config.Config) (engine.RuleEngine, error) {
+	if cfg.Rules.File == "" {
+		return levelRules(cfg), nil
+	}
+	data, err := os.ReadFile(cfg.Rules.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	rs, err := engine.CompileRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", cfg.Rules.File, err)
+	}
+	return rs, nil
+}
+
+// levelRules builds the rule engine for the configured level metrics
+func levelRules(cfg *config.Config) engine.RuleEngine {
+	metrics := make([]core.Metric, 0, len(cfg.Rules.LevelMetrics))
+	for _, m := range cfg.Rules.LevelMetrics {
+		metrics = append(metrics, core.Metric(m))
+	}
+	return engine.LevelUpRuleEngine(metrics...)
+}
+
+// catalogOptions registers the configured catalog and the level metrics with the service, and
+// the external ID kinds users can be addressed by
+func catalogOptions(cfg *config.Config) []gamify.Option {
+	var opts []gamify.Option
+	declared := map[string]bool{}
+	for _, m := range cfg.Catalog.Metrics {
+		declared[m.ID] = true
+		opts = append(opts, gamify.WithMetric(engine.MetricInfo{ID: core.Metric(m.ID), Name: m.Name, Unit: m.Unit, Description: m.Description,
+			DisplayUnit: m.DisplayUnit, Scale: m.Scale, Decimals: m.Decimals, Rounding: engine.Rounding(m.Rounding)}))
+	}
+	for _, m := range cfg.Rules.LevelMetrics {
+		if !declared[m] {
+			opts = append(opts, gamify.WithMetric(engine.MetricInfo{ID: core.Metric(m)}))
+		}
+	}
+	for _, b := range cfg.Catalog.Badges {
+		opts = append(opts, gamify.WithBadge(engine.BadgeInfo{ID: core.Badge(b.ID), Name: b.Name, Description: b.Description, Icon: b.Icon, Metadata: b.Metadata}))
+		if b.Repeatable {
+			opts = append(opts, gamify.WithRepeatableBadge(core.Badge(b.ID), b.Cooldown))
+		}
+	}
+	if cfg.Catalog.Strict {
+		opts = append(opts, gamify.WithStrictCatalog())
+	}
+	if len(cfg.Storage.IdentityKinds) > 0 {
+		opts = append(opts, gamify.WithIdentityKinds(cfg.Storage.IdentityKinds...))
+	}
+	return opts
+}
+
+// rateLimit converts the security config into limiter settings; a zero rate disables limiting
+func rateLimit(cfg *config.Config) httpapi.RateLimit {
+	if !cfg.Security.EnableRateLimit {
+		return httpapi.RateLimit{}
+	}
+	return httpapi.RateLimit{
+		RequestsPerMinute: cfg.Security.RateLimit.RequestsPerMinute,
+		Burst:             cfg.Security.RateLimit.BurstSize,
+	}
+}
+
+// wsOrigins returns the configured WebSocket origins, or nil so the handler falls back to the
+// live CORS origin on every handshake and follows config reloads
+func wsOrigins(cfg *config.Config) []string {
+	if len(cfg.Server.WSAllowedOrigins) > 0 {
+		return cfg.Server.WSAllowedOrigins
+	}
+	return nil
+}
+
+// setupLogging configures the logger based on configuration.
+// The returned level can be changed at runtime.
+func setupLogging(cfg *config.Config) *slog.LevelVar {
 	var handler slog.Handler
 
+	level := new(slog.LevelVar)
+	level.Set(parseLogLevel(cfg.Logging.Level))
 	opts := &slog.HandlerOptions{
-		Level: parseLogLevel(cfg.Logging.Level),
+		Level: level,
 	}
 
 	switch cfg.Logging.Format {
@@ -125,7 +555,44 @@ func setupLogging(cfg *config.Config) {
 		handler = handler.WithAttrs(convertAttributes(cfg.Logging.Attributes))
 	}
 
+	if cfg.Logging.RedactUserIDs {
+		handler = logging.NewRedactingHandler(handler, logging.RedactOptions{Key: []byte(cfg.Logging.RedactKey.Reveal())})
+	}
+
 	slog.SetDefault(slog.New(handler))
+	return level
+}
+
+// accessLogOptions configures the request log; user IDs in paths are redacted like in the other
+// log records.
+func accessLogOptions(cfg *config.Config) httpapi.AccessLogOptions {
+	opts := httpapi.AccessLogOptions{Level: parseLogLevel(cfg.Logging.AccessLogLevel)}
+	if cfg.Logging.RedactUserIDs {
+		opts.RedactUser = logging.RedactOptions{Key: []byte(cfg.Logging.RedactKey.Reveal())}.Redact
+	}
+	return opts
+}
+
+// setupTracing builds the tracer exporting to the configured OpenTelemetry collector, or returns
+// nil when tracing is disabled. User IDs on spans are redacted like in the logs.
+func setupTracing(cfg *config.Config) (*tracing.Tracer, error) {
+	if !cfg.Tracing.Enabled {
+		return nil, nil
+	}
+	exporter, err := tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	opts := tracing.Options{
+		SampleRate: cfg.Tracing.SampleRate,
+		Exporter:   exporter,
+		OnError:    func(err error) { slog.Warn("failed to export spans", "error", err) },
+	}
+	if cfg.Logging.RedactUserIDs {
+		opts.RedactUser = logging.RedactOptions{Key: []byte(cfg.Logging.RedactKey.Reveal())}.Redact
+	}
+	slog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint, "sample_rate", cfg.Tracing.SampleRate)
+	return tracing.NewTracer(opts), nil
 }
 
 // parseLogLevel converts string log level to slog.Level
@@ -166,7 +633,9 @@ func setupStorage(ctx context.Context, cfg *config.Config) (engine.Storage, erro
 		return sqlxAdapter.New(cfg.Storage.SQL)
 
 	case "file":
-		return mem.New(), fmt.Errorf("file storage not yet implemented, using memory fallback")
+		return jsonfile.New(cfg.Storage.File.Path,
+			jsonfile.WithDurability(jsonfile.Durability(cfg.Storage.File.Durability)),
+			jsonfile.WithFlushInterval(cfg.Storage.File.FlushInterval))
 
 	default:
 		return mem.New(), fmt.Errorf("unknown storage adapter: %s", cfg.Storage.Adapter)
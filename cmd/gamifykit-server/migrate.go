@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	redisAdapter "gamifykit/adapters/redis"
+	sqlxAdapter "gamifykit/adapters/sqlx"
+	"gamifykit/config"
+)
+
+// migrate implements `gamifykit-server migrate`: it applies pending schema migrations to the
+// configured SQL database and exits, so migrations can run as their own deploy step (e.g. an init
+// container) with sql.SkipMigrations set on the servers. With -status it only lists applied and
+// pending migrations; with -check it also exits 1 if any are pending. Both only read: a database
+// without the migrations table is reported as untracked, and -check fails for it. After migrating
+// it verifies the schema (see sqlx.Store.VerifySchema) unless sql.SkipSchemaCheck is set. With the
+// redis adapter it builds the per-user key index instead (see redis.Store.BuildIndex), which data
+// written before the index existed needs once.
+func migrate(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	status := fs.Bool("status", false, "print applied and pending migrations without applying them")
+	check := fs.Bool("check", false, "like -status, but exit 1 if migrations are pending")
+	var flags config.Flags
+	flags.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadForCommand(ctx, flags)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if cfg.Storage.Adapter == "redis" && !*status && !*check {
+		return indexRedis(ctx, cfg.Storage.Redis, stdout, stderr)
+	}
+	if cfg.Storage.Adapter != "sql" {
+		fmt.Fprintf(stderr, "migrations need the sql storage adapter, configured: %s\n", cfg.Storage.Adapter)
+		return 2
+	}
+	sqlCfg := cfg.Storage.SQL
+	sqlCfg.SkipMigrations = true
+	sqlCfg.SkipSchemaCheck = true
+	store, err := sqlxAdapter.New(sqlCfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	if *status || *check {
+		migrations, err := store.MigrationStatus(ctx)
+		if errors.Is(err, sqlxAdapter.ErrMigrationsUntracked) {
+			fmt.Fprintf(stdout, "untracked  %s does not exist; run migrate to create it\n", sqlxAdapter.MigrationsTable)
+			if *check {
+				return 1
+			}
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		pending := 0
+		for _, m := range migrations {
+			if m.Applied {
+				fmt.Fprintf(stdout, "applied  %s  %s\n", m.Version, m.AppliedAt.UTC().Format(time.RFC3339))
+			} else {
+				pending++
+				fmt.Fprintf(stdout, "pending  %s\n", m.Version)
+			}
+		}
+		if *check && pending > 0 {
+			fmt.Fprintf(stderr, "%d migrations pending\n", pending)
+			return 1
+		}
+		return 0
+	}
+
+	ran, err := store.Migrate(ctx)
+	for _, v := range ran {
+		fmt.Fprintf(stdout, "applied  %s\n", v)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if !cfg.Storage.SQL.SkipSchemaCheck {
+		if err := store.VerifySchema(ctx); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(stdout, "%d migrations applied, schema is up to date\n", len(ran))
+	return 0
+}
+
+// indexRedis implements `gamifykit-server migrate` for the redis adapter.
+func indexRedis(ctx context.Context, cfg redisAdapter.Config, stdout, stderr io.Writer) int {
+	store, err := redisAdapter.New(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to connect to Redis: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	n, err := store.BuildIndex(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "indexed %d keys\n", n)
+	return 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"gamifykit/analytics"
+	"gamifykit/config"
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// dayReport is one day of rebuilt analytics.
+type dayReport struct {
+	Day           string `json:"day"`
+	ActiveUsers   int    `json:"active_users"`
+	WeeklyActive  int    `json:"weekly_active_users"`
+	MonthlyActive int    `json:"monthly_active_users"`
+	PointsAwarded int64  `json:"points_awarded"`
+	BadgesAwarded int64  `json:"badges_awarded"`
+}
+
+// rebuildAnalytics implements `gamifykit-server rebuild-analytics`: it replays the event log offline
+// and writes per-day DAU/WAU/MAU and totals as JSON to stdout, with progress on stderr.
+//
+// The event log file is the only source: the storage adapter keeps balances, not history (SQL
+// point_events are pruned after the rolling-window retention), so there is nothing to replay from
+// a database. The configuration is read only to find storage.event_log, which is why the command
+// takes -config and -profile but none of the storage flags.
+func rebuildAnalytics(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("rebuild-analytics", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	path := fs.String("event-log", "", "event log file to replay (defaults to storage.event_log from the configuration)")
+	every := fs.Int("progress", 10000, "report progress every N events")
+	var flags config.Flags
+	fs.StringVar(&flags.ConfigFile, "config", "", "JSON configuration file to read storage.event_log from (overrides GAMIFYKIT_CONFIG_FILE)")
+	fs.StringVar(&flags.Profile, "profile", "", "configuration profile to start from")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		cfg, err := config.LoadForCommand(ctx, flags)
+		if err != nil {
+			fmt.Fprintf(stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		*path = cfg.Storage.EventLog
+	}
+	if *path == "" {
+		fmt.Fprintln(stderr, "no event log: pass -event-log or set storage.event_log (analytics are rebuilt from the event log file, not from the storage database)")
+		return 2
+	}
+
+	log, err := analytics.OpenFileEventLog(*path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	metrics := analytics.NewComprehensiveMetrics()
+	var first, last time.Time
+	span := hookFunc(func(e core.Event) {
+		if first.IsZero() {
+			first = e.Time
+		}
+		last = e.Time
+	})
+	progress := analytics.NewProgressHook(*every, func(n int, e core.Event) {
+		fmt.Fprintf(stderr, "replayed %d events (up to %s)\n", n, e.Time.UTC().Format(time.RFC3339))
+	})
+	n, err := analytics.RebuildAnalytics(ctx, log, metrics, span, progress)
+	if err != nil {
+		fmt.Fprintf(stderr, "rebuild failed after %d events: %v\n", n, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "replayed %d events\n", n)
+
+	days := []dayReport{}
+	if n > 0 {
+		for d := first.UTC().Truncate(24 * time.Hour); !d.After(last.UTC()); d = d.AddDate(0, 0, 1) {
+			day := d.Format("2006-01-02")
+			year, week := d.ISOWeek()
+			days = append(days, dayReport{
+				Day:           day,
+				ActiveUsers:   metrics.GetDailyActiveUsers(day),
+				WeeklyActive:  metrics.GetWeeklyActiveUsers(fmt.Sprintf("%d-W%02d", year, week)),
+				MonthlyActive: metrics.GetMonthlyActiveUsers(d.Format("2006-01")),
+				PointsAwarded: metrics.GetPointsAwardedByDay(day),
+				BadgesAwarded: metrics.GetBadgesAwardedByDay(day),
+			})
+		}
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{"events": n, "days": days, "totals": metrics.GetTopMetrics(10)}); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// hookFunc adapts a function to analytics.Hook.
+type hookFunc func(core.Event)
+
+func (f hookFunc) OnEvent(e core.Event) { f(e) }
+
+// recordedEvents are the event types the server logs: every type that changes a user's state, so
+// timelines and past states (see analytics.StateHistory) see transfers, revocations, overwrites and
+// merges as well as awards.
+var recordedEvents = []core.EventType{
+	core.EventPointsAdded, core.EventPointsTransferred, core.EventBadgeAwarded, core.EventBadgeRevoked,
+	core.EventAchievementUnlocked, core.EventLevelUp, core.EventLevelDown, core.EventStateReplaced, core.EventUsersMerged,
+}
+
+// recordEvents appends the recordedEvents to the configured event log so analytics can be rebuilt
+// later, and feeds the same events to hooks such as the user timeline.
+func recordEvents(subscribe func(string, core.EventType, func(context.Context, core.Event), ...engine.Ordering) func(), log *analytics.FileEventLog, hooks ...analytics.Hook) {
+	for _, typ := range recordedEvents {
+		subscribe("event-log", typ, func(_ context.Context, e core.Event) {
+			log.OnEvent(e)
+			for _, h := range hooks {
+				h.OnEvent(e)
+			}
+		})
+	}
+}
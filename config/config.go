@@ -12,6 +12,7 @@ import (
 
 	"gamifykit/adapters/redis"
 	"gamifykit/adapters/sqlx"
+	"gamifykit/secret"
 )
 
 // Environment represents the deployment environment
@@ -30,6 +31,10 @@ type Config struct {
 	Environment Environment `json:"environment" env:"GAMIFYKIT_ENV"`
 	Profile     string      `json:"profile" env:"GAMIFYKIT_PROFILE"`
 
+	// Debug enables development-only features such as the admin event emitter; it is refused in
+	// production
+	Debug bool `json:"debug,omitempty" env:"GAMIFYKIT_DEBUG"`
+
 	// Server configuration
 	Server ServerConfig `json:"server"`
 
@@ -44,31 +49,106 @@ type Config struct {
 
 	// Security configuration
 	Security SecurityConfig `json:"security"`
+
+	// Rule configuration
+	Rules RulesConfig `json:"rules"`
+
+	// Metric and badge catalog
+	Catalog CatalogConfig `json:"catalog"`
+
+	// Realtime delivery across server instances
+	Realtime RealtimeConfig `json:"realtime"`
+
+	// Distributed tracing
+	Tracing TracingConfig `json:"tracing"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Address           string        `json:"address" env:"GAMIFYKIT_SERVER_ADDR"`
-	PathPrefix        string        `json:"path_prefix" env:"GAMIFYKIT_SERVER_PATH_PREFIX"`
-	CORSOrigin        string        `json:"cors_origin" env:"GAMIFYKIT_SERVER_CORS_ORIGIN"`
+	Address    string `json:"address" env:"GAMIFYKIT_SERVER_ADDR"`
+	PathPrefix string `json:"path_prefix" env:"GAMIFYKIT_SERVER_PATH_PREFIX"`
+	CORSOrigin string `json:"cors_origin" env:"GAMIFYKIT_SERVER_CORS_ORIGIN"`
+	// WSAllowedOrigins lists cross-site origins allowed to open WebSockets ("*", exact origins or hosts,
+	// "*.example.com"). When empty, the current cors_origin is used if set, following reloads;
+	// same-origin clients are always allowed.
+	WSAllowedOrigins []string `json:"ws_allowed_origins" env:"GAMIFYKIT_SERVER_WS_ALLOWED_ORIGINS"`
+	// PublicRoutes lists GET or HEAD routes, relative to path_prefix, that answer CORS requests from
+	// any origin, e.g. "GET /catalog" or "GET /leaderboards/{name}" for a public widget (see
+	// httpapi.Options.PublicRoutes)
+	PublicRoutes []string `json:"public_routes,omitempty" env:"GAMIFYKIT_SERVER_PUBLIC_ROUTES"`
+	// WSCodec is the event encoding of WebSocket clients that negotiate none ("json" when empty,
+	// "compact", "msgpack" or "protobuf")
+	WSCodec string `json:"ws_codec" env:"GAMIFYKIT_SERVER_WS_CODEC"`
+	// WSCompression negotiates permessage-deflate with WebSocket clients that offer it
+	WSCompression     bool          `json:"ws_compression" env:"GAMIFYKIT_SERVER_WS_COMPRESSION"`
 	ReadTimeout       time.Duration `json:"read_timeout" env:"GAMIFYKIT_SERVER_READ_TIMEOUT"`
 	WriteTimeout      time.Duration `json:"write_timeout" env:"GAMIFYKIT_SERVER_WRITE_TIMEOUT"`
 	IdleTimeout       time.Duration `json:"idle_timeout" env:"GAMIFYKIT_SERVER_IDLE_TIMEOUT"`
 	ReadHeaderTimeout time.Duration `json:"read_header_timeout" env:"GAMIFYKIT_SERVER_READ_HEADER_TIMEOUT"`
 	ShutdownTimeout   time.Duration `json:"shutdown_timeout" env:"GAMIFYKIT_SERVER_SHUTDOWN_TIMEOUT"`
+	// DrainDelay is how long /readyz reports 503 before shutdown begins, so load balancers can deregister
+	DrainDelay time.Duration `json:"drain_delay" env:"GAMIFYKIT_SERVER_DRAIN_DELAY"`
+	// NotFoundOnEmptyUser makes GET /users/{id} answer 404 for users with no stored data
+	NotFoundOnEmptyUser bool `json:"not_found_on_empty_user" env:"GAMIFYKIT_SERVER_NOT_FOUND_ON_EMPTY_USER"`
+	// MaxInFlightWrites and MaxInFlightReads cap concurrent mutating and other requests; requests over
+	// the cap wait up to LoadShedWait and are then answered 503. Zero leaves them unlimited.
+	MaxInFlightWrites int           `json:"max_in_flight_writes" env:"GAMIFYKIT_SERVER_MAX_IN_FLIGHT_WRITES"`
+	MaxInFlightReads  int           `json:"max_in_flight_reads" env:"GAMIFYKIT_SERVER_MAX_IN_FLIGHT_READS"`
+	LoadShedWait      time.Duration `json:"load_shed_wait" env:"GAMIFYKIT_SERVER_LOAD_SHED_WAIT"`
+	// DefaultMetric is the metric of requests that name none ("xp" when empty); RequireMetric
+	// rejects such requests instead, as does a strict catalog
+	DefaultMetric string `json:"default_metric,omitempty" env:"GAMIFYKIT_SERVER_DEFAULT_METRIC"`
+	RequireMetric bool   `json:"require_metric,omitempty" env:"GAMIFYKIT_SERVER_REQUIRE_METRIC"`
+	// MaxClockSkew is how far ahead of the server clock an event's time may be before it is
+	// replaced with the server time (engine.DefaultMaxClockSkew when zero). Late events keep their time.
+	MaxClockSkew time.Duration `json:"max_clock_skew,omitempty" env:"GAMIFYKIT_SERVER_MAX_CLOCK_SKEW"`
 }
 
 // StorageConfig holds storage adapter configuration
 type StorageConfig struct {
-	Adapter string         `json:"adapter" env:"GAMIFYKIT_STORAGE_ADAPTER"`
-	Redis   redis.Config   `json:"redis,omitempty"`
-	SQL     sqlx.Config    `json:"sql,omitempty"`
-	File    FileConfig     `json:"file,omitempty"`
+	Adapter string       `json:"adapter" env:"GAMIFYKIT_STORAGE_ADAPTER"`
+	Redis   redis.Config `json:"redis,omitempty"`
+	SQL     sqlx.Config  `json:"sql,omitempty"`
+	File    FileConfig   `json:"file,omitempty"`
+	// EventLog, if set, is a JSON-lines file every event is appended to, for `gamifykit-server rebuild-analytics`
+	EventLog string `json:"event_log,omitempty" env:"GAMIFYKIT_STORAGE_EVENT_LOG"`
+	// EventLogRetention, if positive, is how long the event log keeps events; older ones are
+	// compacted away hourly, replaced with per-user opening balances when EventLogRollup is set
+	// so past states after the cutoff stay correct
+	EventLogRetention time.Duration `json:"event_log_retention,omitempty" env:"GAMIFYKIT_STORAGE_EVENT_LOG_RETENTION"`
+	EventLogRollup    bool          `json:"event_log_rollup" env:"GAMIFYKIT_STORAGE_EVENT_LOG_ROLLUP"`
+	// RetryAttempts is how often writes failing with transient storage errors are attempted in
+	// total (0 or 1 disables retries); RetryBackoff is the first wait, doubling per retry
+	RetryAttempts int           `json:"retry_attempts,omitempty" env:"GAMIFYKIT_STORAGE_RETRY_ATTEMPTS"`
+	RetryBackoff  time.Duration `json:"retry_backoff,omitempty" env:"GAMIFYKIT_STORAGE_RETRY_BACKOFF"`
+	// ServeStale answers API reads from the last state read for a user, at most MaxStaleness old
+	// (5m when zero), when the storage fails them, flagging the responses; writes still fail
+	ServeStale   bool          `json:"serve_stale" env:"GAMIFYKIT_STORAGE_SERVE_STALE"`
+	MaxStaleness time.Duration `json:"max_staleness,omitempty" env:"GAMIFYKIT_STORAGE_MAX_STALENESS"`
+	// SerializeUsers runs the writes for one user one at a time within the instance, turning
+	// contention and retries of concurrent same-user requests into brief queuing
+	SerializeUsers bool `json:"serialize_users" env:"GAMIFYKIT_STORAGE_SERIALIZE_USERS"`
+	// IdentityKinds lists the external ID kinds, e.g. email, users can also be addressed by
+	// (email:alice@example.com) once linked to them; the links are kept by the memory and sql
+	// adapters (see engine.WithIdentityKinds)
+	IdentityKinds []string `json:"identity_kinds,omitempty" env:"GAMIFYKIT_STORAGE_IDENTITY_KINDS"`
+	// HistoryLimit caps the point increments the memory adapter keeps per user and metric for
+	// windowed queries (0 = unlimited within the retention); CompactionInterval is how often
+	// expired and excess increments are released by the memory and sql adapters
+	HistoryLimit       int           `json:"history_limit,omitempty" env:"GAMIFYKIT_STORAGE_HISTORY_LIMIT"`
+	CompactionInterval time.Duration `json:"compaction_interval,omitempty" env:"GAMIFYKIT_STORAGE_COMPACTION_INTERVAL"`
+	// TimestampPrecision is what state timestamps are truncated to in every adapter (see
+	// core.SetTimestampPrecision; milliseconds when zero)
+	TimestampPrecision time.Duration `json:"timestamp_precision,omitempty" env:"GAMIFYKIT_STORAGE_TIMESTAMP_PRECISION"`
 }
 
 // FileConfig holds JSON file storage configuration
 type FileConfig struct {
 	Path string `json:"path" env:"GAMIFYKIT_STORAGE_FILE_PATH"`
+	// Durability is when changes reach the disk: "sync" (every write, the default), "interval"
+	// (every FlushInterval and on shutdown) or "on_shutdown"; see jsonfile.Durability
+	Durability    string        `json:"durability,omitempty" env:"GAMIFYKIT_STORAGE_FILE_DURABILITY"`
+	FlushInterval time.Duration `json:"flush_interval,omitempty" env:"GAMIFYKIT_STORAGE_FILE_FLUSH_INTERVAL"`
 }
 
 // LoggingConfig holds logging configuration
@@ -77,6 +157,14 @@ type LoggingConfig struct {
 	Format     string            `json:"format" env:"GAMIFYKIT_LOG_FORMAT"`
 	Output     string            `json:"output" env:"GAMIFYKIT_LOG_OUTPUT"`
 	Attributes map[string]string `json:"attributes,omitempty"`
+	// RedactUserIDs replaces user IDs in log records with an HMAC of RedactKey, or truncates them
+	// when no key is set
+	RedactUserIDs bool                `json:"redact_user_ids" env:"GAMIFYKIT_LOG_REDACT_USER_IDS"`
+	RedactKey     secret.SecureString `json:"redact_key,omitempty" env:"GAMIFYKIT_LOG_REDACT_KEY"`
+	// AccessLog logs every API request (except health checks) with its status and duration at
+	// AccessLogLevel (info when empty); user IDs in paths follow RedactUserIDs
+	AccessLog      bool   `json:"access_log" env:"GAMIFYKIT_LOG_ACCESS"`
+	AccessLogLevel string `json:"access_log_level,omitempty" env:"GAMIFYKIT_LOG_ACCESS_LEVEL"`
 }
 
 // MetricsConfig holds metrics and monitoring configuration
@@ -85,12 +173,28 @@ type MetricsConfig struct {
 	Address       string `json:"address" env:"GAMIFYKIT_METRICS_ADDR"`
 	Path          string `json:"path" env:"GAMIFYKIT_METRICS_PATH"`
 	CollectSystem bool   `json:"collect_system" env:"GAMIFYKIT_METRICS_COLLECT_SYSTEM"`
+	// SlowSubscriberThreshold logs a warning when an event subscriber takes longer; zero disables it
+	SlowSubscriberThreshold time.Duration `json:"slow_subscriber_threshold" env:"GAMIFYKIT_METRICS_SLOW_SUBSCRIBER_THRESHOLD"`
 }
 
 // SecurityConfig holds security-related configuration
 type SecurityConfig struct {
-	EnableRateLimit bool           `json:"enable_rate_limit" env:"GAMIFYKIT_SECURITY_RATE_LIMIT_ENABLED"`
+	EnableRateLimit bool            `json:"enable_rate_limit" env:"GAMIFYKIT_SECURITY_RATE_LIMIT_ENABLED"`
 	RateLimit       RateLimitConfig `json:"rate_limit,omitempty"`
+	// AdminToken protects the admin endpoints; they are disabled when empty
+	AdminToken secret.SecureString `json:"admin_token,omitempty" env:"GAMIFYKIT_SECURITY_ADMIN_TOKEN"`
+	// MaxMetricsPerUser and MaxBadgesPerUser cap the distinct metrics and badges one user can
+	// accumulate; zero means unlimited
+	MaxMetricsPerUser int `json:"max_metrics_per_user" env:"GAMIFYKIT_SECURITY_MAX_METRICS_PER_USER"`
+	MaxBadgesPerUser  int `json:"max_badges_per_user" env:"GAMIFYKIT_SECURITY_MAX_BADGES_PER_USER"`
+	// SharedState is where rate limit buckets and idempotency keys live: "memory" (or empty) for a
+	// single instance, "redis" to share them between instances behind a load balancer
+	SharedState string `json:"shared_state" env:"GAMIFYKIT_SECURITY_SHARED_STATE"`
+	// Redis is the server the redis shared state is kept in
+	Redis redis.Config `json:"redis,omitempty"`
+	// IdempotencyTTL is how long responses to requests with an Idempotency-Key header are kept
+	// for replay; zero disables idempotency keys
+	IdempotencyTTL time.Duration `json:"idempotency_ttl" env:"GAMIFYKIT_SECURITY_IDEMPOTENCY_TTL"`
 }
 
 // RateLimitConfig holds rate limiting configuration
@@ -100,6 +204,128 @@ type RateLimitConfig struct {
 	CleanupInterval   time.Duration `json:"cleanup_interval" env:"GAMIFYKIT_SECURITY_RATE_LIMIT_CLEANUP"`
 }
 
+// RulesConfig holds built-in rule configuration
+type RulesConfig struct {
+	// LevelMetrics lists the metrics whose totals drive levels
+	LevelMetrics []string `json:"level_metrics" env:"GAMIFYKIT_RULES_LEVEL_METRICS"`
+	// File, if set, is a JSON rules configuration (see engine.CompileRules) used instead of the
+	// level metrics' default rules; it is re-read on reload
+	File string `json:"file" env:"GAMIFYKIT_RULES_FILE"`
+}
+
+// CatalogConfig declares the metrics and badges served at /catalog. The level metrics are
+// added automatically.
+type CatalogConfig struct {
+	// Strict rejects points and badges for metrics and badges missing from the catalog
+	Strict  bool                 `json:"strict" env:"GAMIFYKIT_CATALOG_STRICT"`
+	Metrics []CatalogMetricEntry `json:"metrics,omitempty"`
+	Badges  []CatalogBadgeEntry  `json:"badges,omitempty"`
+}
+
+// RealtimeConfig holds realtime (WebSocket) delivery settings
+type RealtimeConfig struct {
+	// Backplane shares events between server instances behind a load balancer: "" for a single
+	// instance or "redis" for Redis pub/sub
+	Backplane string `json:"backplane" env:"GAMIFYKIT_REALTIME_BACKPLANE"`
+	// Channel is the pub/sub channel events are exchanged on
+	Channel string `json:"channel" env:"GAMIFYKIT_REALTIME_CHANNEL"`
+	// Redis is the server the redis backplane connects to
+	Redis redis.Config `json:"redis,omitempty"`
+	// MaxEventBytes caps the JSON size of events broadcast to WebSocket clients and the backplane;
+	// zero leaves them unlimited. The event log still records oversized events whole.
+	MaxEventBytes int `json:"max_event_bytes,omitempty" env:"GAMIFYKIT_REALTIME_MAX_EVENT_BYTES"`
+	// OversizeAction is what happens to events over MaxEventBytes: "truncate" (or empty) strips
+	// their metadata, "drop" keeps them from realtime clients
+	OversizeAction string `json:"oversize_action,omitempty" env:"GAMIFYKIT_REALTIME_OVERSIZE_ACTION"`
+	// AckEvents lists event types, e.g. badge_awarded, that WebSocket clients connected with
+	// ?acks=true must acknowledge; unacknowledged ones are sent again
+	AckEvents []string `json:"ack_events,omitempty" env:"GAMIFYKIT_REALTIME_ACK_EVENTS"`
+	// AckTimeout is how long a client has to acknowledge an event before it is sent again
+	// (realtime.DefaultAckTimeout when zero)
+	AckTimeout time.Duration `json:"ack_timeout,omitempty" env:"GAMIFYKIT_REALTIME_ACK_TIMEOUT"`
+	// AckRedeliveries is how often an event is sent again before it is given up on
+	// (realtime.DefaultAckRedeliveries when zero)
+	AckRedeliveries int `json:"ack_redeliveries,omitempty" env:"GAMIFYKIT_REALTIME_ACK_REDELIVERIES"`
+}
+
+// TracingConfig holds distributed tracing configuration
+type TracingConfig struct {
+	Enabled bool `json:"enabled" env:"GAMIFYKIT_TRACING_ENABLED"`
+	// Endpoint is the OTLP/HTTP endpoint of an OpenTelemetry collector, e.g. http://otel-collector:4318
+	Endpoint string `json:"endpoint" env:"GAMIFYKIT_TRACING_ENDPOINT"`
+	// SampleRate is the fraction of new traces recorded, from 0 to 1; incoming sampled traces are
+	// always continued
+	SampleRate  float64 `json:"sample_rate" env:"GAMIFYKIT_TRACING_SAMPLE_RATE"`
+	ServiceName string  `json:"service_name" env:"GAMIFYKIT_TRACING_SERVICE_NAME"`
+}
+
+// CatalogMetricEntry describes a metric in the catalog
+type CatalogMetricEntry struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Description string `json:"description,omitempty"`
+	// DisplayUnit, Scale, Decimals and Rounding tell clients how to present stored values, e.g.
+	// meters (Unit "m") as kilometres with DisplayUnit "km", Scale 1000 and Decimals 1
+	DisplayUnit string `json:"display_unit,omitempty"`
+	Scale       int64  `json:"scale,omitempty"`
+	Decimals    int    `json:"decimals,omitempty"`
+	// Rounding is "nearest" (default), "down" or "up"
+	Rounding string `json:"rounding,omitempty"`
+}
+
+// CatalogBadgeEntry describes a badge in the catalog
+type CatalogBadgeEntry struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Icon        string         `json:"icon,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	// Repeatable lets the badge be awarded again once Cooldown has passed since the last award;
+	// in JSON the cooldown is a duration string such as "24h"
+	Repeatable bool          `json:"repeatable,omitempty"`
+	Cooldown   time.Duration `json:"cooldown,omitempty"`
+}
+
+// catalogBadgeJSON is CatalogBadgeEntry without its JSON methods
+type catalogBadgeJSON CatalogBadgeEntry
+
+// UnmarshalJSON reads the cooldown as a duration string, as rules files do; a bare number would
+// be nanoseconds and is rejected.
+func (b *CatalogBadgeEntry) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*catalogBadgeJSON
+		Cooldown *string `json:"cooldown,omitempty"`
+	}{catalogBadgeJSON: (*catalogBadgeJSON)(b)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field == "cooldown" {
+			return fmt.Errorf("badge cooldown must be a string like \"24h\": %w", err)
+		}
+		return err
+	}
+	if aux.Cooldown != nil {
+		d, err := time.ParseDuration(*aux.Cooldown)
+		if err != nil {
+			return fmt.Errorf("badge %q cooldown: %w", b.ID, err)
+		}
+		b.Cooldown = d
+	}
+	return nil
+}
+
+// MarshalJSON writes the cooldown as a duration string, so the output reads back with UnmarshalJSON.
+func (b CatalogBadgeEntry) MarshalJSON() ([]byte, error) {
+	aux := struct {
+		catalogBadgeJSON
+		Cooldown string `json:"cooldown,omitempty"`
+	}{catalogBadgeJSON: catalogBadgeJSON(b)}
+	if b.Cooldown != 0 {
+		aux.Cooldown = b.Cooldown.String()
+	}
+	return json.Marshal(aux)
+}
+
 // Load loads configuration from environment variables and validates it
 func Load() (*Config, error) {
 	cfg := DefaultConfig()
@@ -146,39 +372,47 @@ func validateConfigPath(path string) error {
 
 // LoadFromFile loads configuration from a JSON file
 func LoadFromFile(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if err := readFile(cfg, path); err != nil {
+		return nil, err
+	}
+
+	// Environment variables override file values
+	if err := loadFromEnv(cfg); err != nil {
+		return nil, fmt.Errorf("failed to load config from environment: %w", err)
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// readFile decodes the JSON file at path onto cfg, keeping values the file does not set
+func readFile(cfg *Config, path string) error {
 	// Validate the path for security
 	if err := validateConfigPath(path); err != nil {
-		return nil, fmt.Errorf("invalid config file path: %w", err)
+		return fmt.Errorf("invalid config file path: %w", err)
 	}
 
 	// Open the file safely after validation
 	file, err := os.Open(path) // #nosec G304 - Path validated above
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file %s: %w", path, err)
+		return fmt.Errorf("failed to open config file %s: %w", path, err)
 	}
 	defer file.Close()
 
 	data, err := io.ReadAll(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
-	cfg := DefaultConfig()
 	if err := json.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
-	}
-
-	// Environment variables override file values
-	if err := loadFromEnv(cfg); err != nil {
-		return nil, fmt.Errorf("failed to load config from environment: %w", err)
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
-
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
-	}
-
-	return cfg, nil
+	return nil
 }
 
 // DefaultConfig returns a configuration with sensible defaults for development
@@ -195,6 +429,7 @@ func DefaultConfig() *Config {
 			IdleTimeout:       60 * time.Second,
 			ReadHeaderTimeout: 5 * time.Second,
 			ShutdownTimeout:   30 * time.Second,
+			DrainDelay:        5 * time.Second,
 		},
 		Storage: StorageConfig{
 			Adapter: "memory",
@@ -203,6 +438,7 @@ func DefaultConfig() *Config {
 			File: FileConfig{
 				Path: "./data/gamifykit.json",
 			},
+			EventLogRollup: true,
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
@@ -222,6 +458,20 @@ func DefaultConfig() *Config {
 				BurstSize:         10,
 				CleanupInterval:   5 * time.Minute,
 			},
+			SharedState:    "memory",
+			Redis:          redis.DefaultConfig(),
+			IdempotencyTTL: 24 * time.Hour,
+		},
+		Rules: RulesConfig{
+			LevelMetrics: []string{"xp"},
+		},
+		Realtime: RealtimeConfig{
+			Channel: redis.DefaultBackplaneChannel,
+			Redis:   redis.DefaultConfig(),
+		},
+		Tracing: TracingConfig{
+			SampleRate:  1,
+			ServiceName: "gamifykit",
 		},
 	}
 }
@@ -234,6 +484,9 @@ func (c *Config) Validate() error {
 	if c.Environment == "" {
 		errs = append(errs, "environment cannot be empty")
 	}
+	if c.Debug && c.Environment == EnvProduction {
+		errs = append(errs, "debug cannot be enabled in production")
+	}
 
 	// Validate server config
 	if err := c.Server.Validate(); err != nil {
@@ -255,6 +508,28 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Sprintf("metrics config: %v", err))
 	}
 
+	// Validate security config
+	if err := c.Security.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("security config: %v", err))
+	}
+
+	// Validate rules config
+	if err := c.Rules.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("rules config: %v", err))
+	}
+
+	if err := c.Catalog.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("catalog config: %v", err))
+	}
+
+	if err := c.Realtime.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("realtime config: %v", err))
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("tracing config: %v", err))
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, "; "))
 	}
@@ -274,6 +549,15 @@ func (c *Config) String() string {
 	if cfg.Storage.Redis.Password != "" {
 		cfg.Storage.Redis.Password = "[REDACTED]"
 	}
+	if cfg.Security.Redis.Password != "" {
+		cfg.Security.Redis.Password = "[REDACTED]"
+	}
+	if cfg.Security.AdminToken != "" {
+		cfg.Security.AdminToken = "[REDACTED]"
+	}
+	if cfg.Logging.RedactKey != "" {
+		cfg.Logging.RedactKey = "[REDACTED]"
+	}
 
 	data, _ := json.MarshalIndent(cfg, "", "  ")
 	return string(data)
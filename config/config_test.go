@@ -2,7 +2,11 @@ package config
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -55,6 +59,44 @@ func TestLoadFromFile(t *testing.T) {
 	assert.Equal(t, "memory", cfg.Storage.Adapter)
 }
 
+func TestLoadWithFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"environment": "testing", "server": {"address": ":9090"}, "logging": {"level": "warn", "format": "text"}}`), 0o600))
+	t.Setenv("GAMIFYKIT_ENV", "staging")
+	t.Setenv("GAMIFYKIT_PROFILE", "from-env")
+
+	var flags Flags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Register(fs)
+	require.NoError(t, fs.Parse([]string{"-config", path, "-addr", ":7070", "-storage", "file", "-log-level", "debug"}))
+
+	cfg, err := LoadWithFlags(flags, "")
+	require.NoError(t, err)
+	assert.Equal(t, ":7070", cfg.Server.Address, "flag beats file")
+	assert.Equal(t, "debug", cfg.Logging.Level, "flag beats file")
+	assert.Equal(t, "file", cfg.Storage.Adapter, "flag beats default")
+	assert.Equal(t, "text", cfg.Logging.Format, "file beats default")
+	assert.Equal(t, EnvStaging, cfg.Environment, "env beats file")
+	assert.Equal(t, "from-env", cfg.Profile)
+
+	cfg, err = LoadWithFlags(Flags{Profile: "production"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "production", cfg.Profile, "flag beats env")
+	assert.Equal(t, "redis", cfg.Storage.Adapter, "profile replaces defaults")
+
+	_, err = LoadWithFlags(Flags{Storage: "floppy"}, "")
+	assert.ErrorContains(t, err, "adapter must be one of")
+	_, err = LoadWithFlags(Flags{Profile: "nope"}, "")
+	assert.Error(t, err)
+
+	// commands fall back to GAMIFYKIT_CONFIG_FILE
+	t.Setenv("GAMIFYKIT_CONFIG_FILE", path)
+	cfg, err = LoadForCommand(context.Background(), Flags{Storage: "file"})
+	require.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.Server.Address)
+	assert.Equal(t, "file", cfg.Storage.Adapter)
+}
+
 func TestConfig_Validate(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -130,6 +172,382 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "unknown ws codec",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+					WSCodec:           "xml",
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "negative user limits",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+				Security: SecurityConfig{
+					MaxBadgesPerUser: -1,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "tracing without endpoint",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+				Tracing: TracingConfig{
+					Enabled:     true,
+					SampleRate:  0.1,
+					ServiceName: "gamifykit",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "debug in production",
+			config: &Config{
+				Environment: EnvProduction,
+				Debug:       true,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "unknown shared state",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+				Security: SecurityConfig{
+					SharedState: "memcached",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid access log level",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:          "info",
+					Format:         "json",
+					Output:         "stdout",
+					AccessLog:      true,
+					AccessLogLevel: "verbose",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "timestamp precision above a second",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter:            "memory",
+					TimestampPrecision: time.Minute,
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "negative max staleness",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter:      "memory",
+					ServeStale:   true,
+					MaxStaleness: -time.Minute,
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid realtime oversize action",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+				Realtime: RealtimeConfig{
+					MaxEventBytes:  65536,
+					OversizeAction: "shrink",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "negative realtime ack timeout",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+				Realtime: RealtimeConfig{
+					AckEvents:  []string{"badge_awarded"},
+					AckTimeout: -time.Second,
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid public route",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+					PublicRoutes:      []string{"GET /catalog", "GET leaderboards"},
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "public route without a method",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+					PublicRoutes:      []string{"GET /catalog", "/users/{id}/points"},
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "public write route",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+					PublicRoutes:      []string{"POST /users/{id}/points"},
+				},
+				Storage: StorageConfig{
+					Adapter: "memory",
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "invalid identity kind",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter:       "memory",
+					IdentityKinds: []string{"email", "E-Mail"},
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
+		{
+			name: "negative event log retention",
+			config: &Config{
+				Environment: EnvDevelopment,
+				Server: ServerConfig{
+					Address:           ":8080",
+					ReadTimeout:       time.Second,
+					WriteTimeout:      time.Second,
+					IdleTimeout:       time.Second,
+					ReadHeaderTimeout: time.Second,
+					ShutdownTimeout:   time.Second,
+				},
+				Storage: StorageConfig{
+					Adapter:           "memory",
+					EventLogRetention: -time.Hour,
+				},
+				Logging: LoggingConfig{
+					Level:  "info",
+					Format: "json",
+					Output: "stdout",
+				},
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -144,6 +562,41 @@ func TestConfig_Validate(t *testing.T) {
 	}
 }
 
+func TestCatalogConfig_Validate(t *testing.T) {
+	valid := CatalogConfig{
+		Metrics: []CatalogMetricEntry{{ID: "xp", Name: "Experience"}},
+		Badges:  []CatalogBadgeEntry{{ID: "early_bird", Metadata: map[string]any{"tier": 1}}},
+	}
+	assert.NoError(t, valid.Validate())
+
+	dup := CatalogConfig{Metrics: []CatalogMetricEntry{{ID: "xp"}, {ID: "xp"}}}
+	assert.ErrorContains(t, dup.Validate(), "duplicate metric")
+
+	bad := CatalogConfig{Badges: []CatalogBadgeEntry{{ID: "has space"}}}
+	assert.ErrorContains(t, bad.Validate(), "invalid badge id")
+
+	display := CatalogConfig{Metrics: []CatalogMetricEntry{{ID: "distance", Unit: "m", DisplayUnit: "km", Scale: 1000, Decimals: 1, Rounding: "down"}}}
+	assert.NoError(t, display.Validate())
+	display.Metrics[0].Rounding = "sideways"
+	assert.ErrorContains(t, display.Validate(), "rounding")
+
+	repeat := CatalogConfig{Badges: []CatalogBadgeEntry{{ID: "daily", Repeatable: true, Cooldown: 24 * time.Hour}}}
+	assert.NoError(t, repeat.Validate())
+	repeat.Badges[0].Repeatable = false
+	assert.ErrorContains(t, repeat.Validate(), "cooldown requires repeatable")
+
+	// cooldowns in JSON are duration strings and written back as such
+	var parsed CatalogConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"badges": [{"id": "daily", "repeatable": true, "cooldown": "24h"}]}`), &parsed))
+	assert.Equal(t, 24*time.Hour, parsed.Badges[0].Cooldown)
+	assert.Equal(t, "daily", parsed.Badges[0].ID)
+	data, err := json.Marshal(parsed.Badges[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id": "daily", "repeatable": true, "cooldown": "24h0m0s"}`, string(data))
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"badges": [{"id": "daily", "cooldown": 86400}]}`), &parsed), "string like")
+	assert.ErrorContains(t, json.Unmarshal([]byte(`{"badges": [{"id": "daily", "cooldown": "a day"}]}`), &parsed), "cooldown")
+}
+
 func TestProfiles(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -199,6 +652,79 @@ func TestSecrets(t *testing.T) {
 	assert.Equal(t, testValue, value)
 }
 
+// countingSecretStore serves fixed secrets and counts lookups
+type countingSecretStore struct {
+	values map[string]string
+	calls  int
+}
+
+func (s *countingSecretStore) Get(_ context.Context, key string) (string, error) {
+	s.calls++
+	if v, ok := s.values[key]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("secret %s not found", key)
+}
+
+func (s *countingSecretStore) GetWithDefault(ctx context.Context, key, defaultValue string) string {
+	if v, err := s.Get(ctx, key); err == nil {
+		return v
+	}
+	return defaultValue
+}
+
+func TestCachedSecretStore(t *testing.T) {
+	ctx := context.Background()
+	remote := &countingSecretStore{values: map[string]string{"GAMIFYKIT_DATABASE_DSN": "postgres://app:hunter2@db/app"}}
+	store := NewCachedSecretStore(remote, time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		value, err := store.Get(ctx, "GAMIFYKIT_DATABASE_DSN")
+		require.NoError(t, err)
+		assert.Equal(t, "postgres://app:hunter2@db/app", value)
+	}
+	assert.Equal(t, 1, remote.calls, "cached values are not fetched again")
+	assert.NotContains(t, fmt.Sprintf("%+v", store.cache), "hunter2", "cached values are sealed")
+
+	now = now.Add(time.Minute)
+	_, err := store.Get(ctx, "GAMIFYKIT_DATABASE_DSN")
+	require.NoError(t, err)
+	assert.Equal(t, 2, remote.calls, "expired values are fetched again")
+
+	store.Forget("GAMIFYKIT_DATABASE_DSN")
+	_, err = store.Get(ctx, "GAMIFYKIT_DATABASE_DSN")
+	require.NoError(t, err)
+	assert.Equal(t, 3, remote.calls)
+
+	assert.Equal(t, "fallback", store.GetWithDefault(ctx, "MISSING", "fallback"))
+	assert.Equal(t, "fallback", store.GetWithDefault(ctx, "MISSING", "fallback"))
+	assert.Equal(t, 5, remote.calls, "failed lookups are not cached")
+
+	cfg := DefaultConfig()
+	cfg.Storage.Adapter = "sql"
+	require.NoError(t, cfg.LoadSecrets(ctx, store))
+	assert.Equal(t, "postgres://app:hunter2@db/app", cfg.Storage.SQL.DSN.Reveal())
+}
+
+func TestConfigHidesSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage.SQL.DSN = "postgres://app:hunter2@db/app"
+	cfg.Storage.Redis.Password = "hunter2"
+	cfg.Security.AdminToken = "hunter2"
+	cfg.Logging.RedactKey = "hunter2"
+
+	for _, out := range []string{fmt.Sprintf("%v", *cfg), fmt.Sprintf("%+v", *cfg), fmt.Sprintf("%#v", *cfg), cfg.String(), cfg.RedactSecrets().String()} {
+		assert.NotContains(t, out, "hunter2")
+	}
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.Contains(t, string(data), `"admin_token":"[REDACTED]"`)
+	assert.Equal(t, "hunter2", cfg.Security.AdminToken.Reveal(), "redaction does not change the config")
+}
+
 func TestValidateConfigPath(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -267,3 +793,35 @@ func TestValidateConfigPath(t *testing.T) {
 		})
 	}
 }
+
+func TestRestartRequired(t *testing.T) {
+	current := DefaultConfig()
+
+	next := DefaultConfig()
+	next.Logging.Level = "debug"
+	next.Server.CORSOrigin = "https://example.com"
+	next.Security.EnableRateLimit = true
+	next.Rules.LevelMetrics = []string{"xp", "points"}
+	assert.Empty(t, current.RestartRequired(next), "hot-reloadable changes must not require a restart")
+
+	next.Server.Address = ":9999"
+	next.Storage.Adapter = "redis"
+	assert.Equal(t, []string{"server.address", "storage"}, current.RestartRequired(next))
+}
+
+func TestWithReloadable(t *testing.T) {
+	current := DefaultConfig()
+
+	next := DefaultConfig()
+	next.Logging.Level = "warn"
+	next.Security.RateLimit.RequestsPerMinute = 120
+	next.Rules.LevelMetrics = []string{"points"}
+	next.Server.Address = ":9999"
+
+	applied := current.WithReloadable(next)
+	assert.Equal(t, "warn", applied.Logging.Level)
+	assert.Equal(t, 120, applied.Security.RateLimit.RequestsPerMinute)
+	assert.Equal(t, []string{"points"}, applied.Rules.LevelMetrics)
+	assert.Equal(t, ":8080", applied.Server.Address, "non-reloadable settings must be kept")
+	assert.Equal(t, "info", current.Logging.Level, "the original config must not be modified")
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Flags holds command-line overrides for the most common settings. Empty fields are not set on
+// the command line and leave the configuration untouched.
+type Flags struct {
+	// ConfigFile is a JSON file to load, as with LoadFromFile
+	ConfigFile string
+	// Profile starts from a named profile (see LoadProfile) instead of DefaultConfig
+	Profile  string
+	Address  string
+	Storage  string
+	LogLevel string
+}
+
+// Register defines -config, -profile, -addr, -storage and -log-level on fs, so fs.Parse fills f
+// and -h documents them.
+func (f *Flags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&f.ConfigFile, "config", "", "JSON configuration file (overrides GAMIFYKIT_CONFIG_FILE)")
+	fs.StringVar(&f.Profile, "profile", "", "configuration profile to start from, e.g. development or production-sql")
+	fs.StringVar(&f.Address, "addr", "", "HTTP listen address, e.g. :8080 (server.address)")
+	fs.StringVar(&f.Storage, "storage", "", "storage adapter: memory, redis, sql or file (storage.adapter)")
+	fs.StringVar(&f.LogLevel, "log-level", "", "log level: debug, info, warn or error (logging.level)")
+}
+
+// Apply copies the flags that are set onto c.
+func (f Flags) Apply(c *Config) {
+	if f.Profile != "" {
+		c.Profile = f.Profile
+	}
+	if f.Address != "" {
+		c.Server.Address = f.Address
+	}
+	if f.Storage != "" {
+		c.Storage.Adapter = f.Storage
+	}
+	if f.LogLevel != "" {
+		c.Logging.Level = f.LogLevel
+	}
+}
+
+// LoadWithFlags builds the configuration in layers, each overriding the previous one: the
+// profile named by f.Profile (DefaultConfig when empty), the file f.ConfigFile or fallbackFile,
+// environment variables and finally the flags themselves. The result is validated.
+func LoadWithFlags(f Flags, fallbackFile string) (*Config, error) {
+	cfg := DefaultConfig()
+	if f.Profile != "" {
+		var err error
+		if cfg, err = LoadProfile(f.Profile); err != nil {
+			return nil, err
+		}
+	}
+
+	path := f.ConfigFile
+	if path == "" {
+		path = fallbackFile
+	}
+	if path != "" {
+		if err := readFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadFromEnv(cfg); err != nil {
+		return nil, fmt.Errorf("failed to load config from environment: %w", err)
+	}
+
+	f.Apply(cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadForCommand is how the gamifykit commands load their configuration: LoadWithFlags with
+// GAMIFYKIT_CONFIG_FILE as the fallback file, then the secrets from the environment in production.
+func LoadForCommand(ctx context.Context, f Flags) (*Config, error) {
+	cfg, err := LoadWithFlags(f, os.Getenv("GAMIFYKIT_CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Environment == EnvProduction {
+		if err := cfg.LoadSecretsFromEnv(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load secrets: %w", err)
+		}
+	}
+	return cfg, nil
+}
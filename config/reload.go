@@ -0,0 +1,78 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Settings that can be applied to a running server on reload:
+//   - logging.level
+//   - server.cors_origin
+//   - security.enable_rate_limit and security.rate_limit
+//   - rules
+//
+// Everything else is fixed at startup.
+
+// RestartRequired lists the settings that differ between c and next but only take effect after a restart.
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+
+	check("environment", c.Environment, next.Environment)
+	check("profile", c.Profile, next.Profile)
+	check("debug", c.Debug, next.Debug)
+	check("server.address", c.Server.Address, next.Server.Address)
+	check("server.path_prefix", c.Server.PathPrefix, next.Server.PathPrefix)
+	check("server.ws_allowed_origins", c.Server.WSAllowedOrigins, next.Server.WSAllowedOrigins)
+	check("server.public_routes", c.Server.PublicRoutes, next.Server.PublicRoutes)
+	check("server.ws_codec", c.Server.WSCodec, next.Server.WSCodec)
+	check("server.ws_compression", c.Server.WSCompression, next.Server.WSCompression)
+	check("server.read_timeout", c.Server.ReadTimeout, next.Server.ReadTimeout)
+	check("server.write_timeout", c.Server.WriteTimeout, next.Server.WriteTimeout)
+	check("server.idle_timeout", c.Server.IdleTimeout, next.Server.IdleTimeout)
+	check("server.read_header_timeout", c.Server.ReadHeaderTimeout, next.Server.ReadHeaderTimeout)
+	check("server.shutdown_timeout", c.Server.ShutdownTimeout, next.Server.ShutdownTimeout)
+	check("server.drain_delay", c.Server.DrainDelay, next.Server.DrainDelay)
+	check("server.not_found_on_empty_user", c.Server.NotFoundOnEmptyUser, next.Server.NotFoundOnEmptyUser)
+	check("server.max_in_flight_writes", c.Server.MaxInFlightWrites, next.Server.MaxInFlightWrites)
+	check("server.max_in_flight_reads", c.Server.MaxInFlightReads, next.Server.MaxInFlightReads)
+	check("server.load_shed_wait", c.Server.LoadShedWait, next.Server.LoadShedWait)
+	check("server.default_metric", c.Server.DefaultMetric, next.Server.DefaultMetric)
+	check("server.require_metric", c.Server.RequireMetric, next.Server.RequireMetric)
+	check("server.max_clock_skew", c.Server.MaxClockSkew, next.Server.MaxClockSkew)
+	check("storage", c.Storage, next.Storage)
+	check("logging.format", c.Logging.Format, next.Logging.Format)
+	check("logging.output", c.Logging.Output, next.Logging.Output)
+	check("logging.attributes", c.Logging.Attributes, next.Logging.Attributes)
+	check("logging.redact_user_ids", c.Logging.RedactUserIDs, next.Logging.RedactUserIDs)
+	check("logging.redact_key", c.Logging.RedactKey, next.Logging.RedactKey)
+	check("logging.access_log", c.Logging.AccessLog, next.Logging.AccessLog)
+	check("logging.access_log_level", c.Logging.AccessLogLevel, next.Logging.AccessLogLevel)
+	check("metrics", c.Metrics, next.Metrics)
+	check("catalog", c.Catalog, next.Catalog)
+	check("realtime", c.Realtime, next.Realtime)
+	check("tracing", c.Tracing, next.Tracing)
+	check("security.admin_token", c.Security.AdminToken, next.Security.AdminToken)
+	check("security.max_metrics_per_user", c.Security.MaxMetricsPerUser, next.Security.MaxMetricsPerUser)
+	check("security.max_badges_per_user", c.Security.MaxBadgesPerUser, next.Security.MaxBadgesPerUser)
+	check("security.shared_state", c.Security.SharedState, next.Security.SharedState)
+	check("security.redis", c.Security.Redis, next.Security.Redis)
+	check("security.idempotency_ttl", c.Security.IdempotencyTTL, next.Security.IdempotencyTTL)
+
+	return changed
+}
+
+// WithReloadable returns a copy of c with the hot-reloadable settings taken from next.
+func (c *Config) WithReloadable(next *Config) *Config {
+	cfg := *c
+	cfg.Logging.Level = next.Logging.Level
+	cfg.Server.CORSOrigin = next.Server.CORSOrigin
+	cfg.Security.EnableRateLimit = next.Security.EnableRateLimit
+	cfg.Security.RateLimit = next.Security.RateLimit
+	cfg.Rules = next.Rules
+	cfg.Rules.LevelMetrics = append([]string(nil), next.Rules.LevelMetrics...)
+	return &cfg
+}
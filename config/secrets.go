@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
+	"time"
+
+	"gamifykit/secret"
 )
 
 // SecretStore defines the interface for external secret management
@@ -40,12 +44,90 @@ func (e *EnvironmentSecretStore) GetWithDefault(ctx context.Context, key, defaul
 	return defaultValue
 }
 
+// CachedSecretStore caches the values of another SecretStore, typically a remote one such as
+// Vault or AWS Secrets Manager, for a TTL. Cached values are kept sealed (see secret.Sealed) and
+// decrypted on each access, so the cache itself holds no plaintext between lookups. That covers
+// only the cached copies: Get returns a plain string, and the SecureString fields LoadSecrets
+// fills are plain strings too, which live until garbage collected. Failed lookups are not cached.
+//
+// It is a library type for applications that look secrets up repeatedly; gamifykit-server reads
+// its secrets from the environment once per configuration load and does not use it.
+type CachedSecretStore struct {
+	store SecretStore
+	ttl   time.Duration
+	now   func() time.Time
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   secret.Sealed
+	expires time.Time
+}
+
+// NewCachedSecretStore wraps store, caching values for ttl; a ttl of zero caches them until
+// Forget or Clear.
+func NewCachedSecretStore(store SecretStore, ttl time.Duration) *CachedSecretStore {
+	return &CachedSecretStore{store: store, ttl: ttl, now: time.Now, cache: map[string]cachedSecret{}}
+}
+
+// Get returns the cached value of key, fetching and sealing it when it is missing or expired.
+func (c *CachedSecretStore) Get(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && (c.ttl <= 0 || c.now().Before(cached.expires)) {
+		value, err := cached.value.Reveal()
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", key, err)
+		}
+		return value.Reveal(), nil
+	}
+
+	value, err := c.store.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	plaintext := []byte(value)
+	defer secret.Zero(plaintext)
+	sealed, err := secret.Seal(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", key, err)
+	}
+	c.mu.Lock()
+	c.cache[key] = cachedSecret{value: sealed, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// GetWithDefault returns the value of key, or defaultValue if it cannot be fetched.
+func (c *CachedSecretStore) GetWithDefault(ctx context.Context, key, defaultValue string) string {
+	if value, err := c.Get(ctx, key); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// Forget drops key from the cache, e.g. after the secret was rotated.
+func (c *CachedSecretStore) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, key)
+}
+
+// Clear drops every cached value.
+func (c *CachedSecretStore) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = map[string]cachedSecret{}
+}
+
 // LoadSecrets loads sensitive configuration values from a secret store
 func (c *Config) LoadSecrets(ctx context.Context, store SecretStore) error {
 	// Load database credentials
 	if c.Storage.Adapter == "sql" {
 		if dsn, err := store.Get(ctx, "GAMIFYKIT_DATABASE_DSN"); err == nil {
-			c.Storage.SQL.DSN = dsn
+			c.Storage.SQL.DSN = secret.SecureString(dsn)
 		} else if c.Environment == EnvProduction {
 			return fmt.Errorf("database DSN secret required in production: %w", err)
 		}
@@ -54,7 +136,19 @@ func (c *Config) LoadSecrets(ctx context.Context, store SecretStore) error {
 	// Load Redis credentials
 	if c.Storage.Adapter == "redis" {
 		if password, err := store.Get(ctx, "GAMIFYKIT_REDIS_PASSWORD"); err == nil {
-			c.Storage.Redis.Password = password
+			c.Storage.Redis.Password = secret.SecureString(password)
+		}
+	}
+
+	// Load the admin API token
+	if token, err := store.Get(ctx, "GAMIFYKIT_ADMIN_TOKEN"); err == nil {
+		c.Security.AdminToken = secret.SecureString(token)
+	}
+
+	// Load the key used to pseudonymize user IDs in logs
+	if c.Logging.RedactUserIDs {
+		if key, err := store.Get(ctx, "GAMIFYKIT_LOG_REDACT_KEY"); err == nil {
+			c.Logging.RedactKey = secret.SecureString(key)
 		}
 	}
 
@@ -104,6 +198,20 @@ func (c *Config) RedactSecrets() *Config {
 		cfg.Storage.Redis.Password = "[REDACTED]"
 	}
 
+	if cfg.Security.Redis.Password != "" {
+		cfg.Security.Redis.Password = "[REDACTED]"
+	}
+
+	// Redact admin token
+	if cfg.Security.AdminToken != "" {
+		cfg.Security.AdminToken = "[REDACTED]"
+	}
+
+	// Redact the log redaction key
+	if cfg.Logging.RedactKey != "" {
+		cfg.Logging.RedactKey = "[REDACTED]"
+	}
+
 	// Add more redactions as needed for future sensitive fields
 
 	return &cfg
@@ -3,7 +3,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
+
+	"gamifykit/adapters/jsonfile"
+	"gamifykit/core"
+	"gamifykit/realtime"
 )
 
 // Validate validates server configuration
@@ -34,6 +42,38 @@ func (s *ServerConfig) Validate() error {
 		errs = append(errs, "shutdown_timeout must be positive")
 	}
 
+	if s.DrainDelay < 0 {
+		errs = append(errs, "drain_delay cannot be negative")
+	}
+
+	if s.MaxInFlightWrites < 0 || s.MaxInFlightReads < 0 {
+		errs = append(errs, "max_in_flight_writes and max_in_flight_reads cannot be negative")
+	}
+
+	if s.MaxClockSkew < 0 {
+		errs = append(errs, "max_clock_skew cannot be negative")
+	}
+
+	if s.LoadShedWait < 0 {
+		errs = append(errs, "load_shed_wait cannot be negative")
+	}
+
+	if s.WSCodec != "" {
+		if _, ok := realtime.CodecByName(s.WSCodec); !ok {
+			errs = append(errs, fmt.Sprintf("ws_codec must be one of %s", strings.Join(realtime.CodecNames(), ", ")))
+		}
+	}
+
+	for _, route := range s.PublicRoutes {
+		if !validPublicRoute(route) {
+			errs = append(errs, fmt.Sprintf("public_routes: %q must be a GET or HEAD route such as GET /catalog", route))
+		}
+	}
+
+	if s.DefaultMetric != "" && strings.TrimSpace(s.DefaultMetric) != s.DefaultMetric {
+		errs = append(errs, "default_metric cannot have surrounding whitespace")
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, "; "))
 	}
@@ -60,12 +100,45 @@ func (s *StorageConfig) Validate() error {
 
 	// Validate adapter-specific configs
 	switch s.Adapter {
+	case "redis":
+		if err := s.Redis.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("redis config: %v", err))
+		}
 	case "file":
 		if err := s.File.Validate(); err != nil {
 			errs = append(errs, fmt.Sprintf("file config: %v", err))
 		}
 	}
 
+	if s.RetryAttempts < 0 || s.RetryBackoff < 0 {
+		errs = append(errs, "retry_attempts and retry_backoff cannot be negative")
+	}
+
+	if s.MaxStaleness < 0 {
+		errs = append(errs, "max_staleness cannot be negative")
+	}
+
+	if len(s.IdentityKinds) > 0 && s.Adapter != "memory" && s.Adapter != "sql" {
+		errs = append(errs, "identity_kinds needs the memory or sql adapter")
+	}
+	for _, k := range s.IdentityKinds {
+		if !validIdentityKind(k) {
+			errs = append(errs, fmt.Sprintf("identity kind %q must be lowercase letters, digits, - and _", k))
+		}
+	}
+
+	if s.HistoryLimit < 0 || s.CompactionInterval < 0 {
+		errs = append(errs, "history_limit and compaction_interval cannot be negative")
+	}
+
+	if s.EventLogRetention < 0 {
+		errs = append(errs, "event_log_retention cannot be negative")
+	}
+
+	if s.TimestampPrecision < 0 || s.TimestampPrecision > time.Second {
+		errs = append(errs, "timestamp_precision must be between 0 and 1s")
+	}
+
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, "; "))
 	}
@@ -75,8 +148,18 @@ func (s *StorageConfig) Validate() error {
 
 // Validate validates file storage configuration
 func (f *FileConfig) Validate() error {
+	var errs []string
 	if f.Path == "" {
-		return errors.New("path cannot be empty")
+		errs = append(errs, "path cannot be empty")
+	}
+	if !jsonfile.ValidDurability(jsonfile.Durability(f.Durability)) {
+		errs = append(errs, "durability must be one of sync, interval, on_shutdown")
+	}
+	if f.FlushInterval < 0 {
+		errs = append(errs, "flush_interval cannot be negative")
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
 	return nil
 }
@@ -98,6 +181,10 @@ func (l *LoggingConfig) Validate() error {
 		errs = append(errs, fmt.Sprintf("level must be one of: %s", strings.Join(validLevels, ", ")))
 	}
 
+	if l.AccessLogLevel != "" && !slices.Contains(validLevels, l.AccessLogLevel) {
+		errs = append(errs, fmt.Sprintf("access_log_level must be one of: %s", strings.Join(validLevels, ", ")))
+	}
+
 	validFormats := []string{"json", "text"}
 	isValidFormat := false
 	for _, format := range validFormats {
@@ -152,3 +239,192 @@ func (m *MetricsConfig) Validate() error {
 	return nil
 }
 
+// Validate validates security configuration
+func (s *SecurityConfig) Validate() error {
+	var errs []string
+
+	if s.EnableRateLimit {
+		if s.RateLimit.RequestsPerMinute <= 0 {
+			errs = append(errs, "rate_limit.requests_per_minute must be positive when rate limiting is enabled")
+		}
+
+		if s.RateLimit.BurstSize <= 0 {
+			errs = append(errs, "rate_limit.burst_size must be positive when rate limiting is enabled")
+		}
+	}
+
+	if s.MaxMetricsPerUser < 0 {
+		errs = append(errs, "max_metrics_per_user cannot be negative")
+	}
+
+	if s.MaxBadgesPerUser < 0 {
+		errs = append(errs, "max_badges_per_user cannot be negative")
+	}
+
+	switch s.SharedState {
+	case "", "memory":
+	case "redis":
+		if err := s.Redis.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("redis config: %v", err))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("shared_state must be memory or redis, got %q", s.SharedState))
+	}
+
+	if s.IdempotencyTTL < 0 {
+		errs = append(errs, "idempotency_ttl cannot be negative")
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Validate validates rules configuration
+func (r *RulesConfig) Validate() error {
+	for _, m := range r.LevelMetrics {
+		if strings.TrimSpace(m) == "" {
+			return errors.New("level_metrics cannot contain empty metric names")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates catalog configuration
+func (c *CatalogConfig) Validate() error {
+	var errs []string
+
+	metrics := map[string]bool{}
+	for _, m := range c.Metrics {
+		switch {
+		case strings.TrimSpace(m.ID) == "":
+			errs = append(errs, "metrics cannot contain empty ids")
+		case metrics[m.ID]:
+			errs = append(errs, fmt.Sprintf("duplicate metric %q", m.ID))
+		case m.Scale < 0:
+			errs = append(errs, fmt.Sprintf("metric %q: scale cannot be negative", m.ID))
+		case m.Decimals < 0 || m.Decimals > 9:
+			errs = append(errs, fmt.Sprintf("metric %q: decimals must be between 0 and 9", m.ID))
+		case m.Rounding != "" && m.Rounding != "nearest" && m.Rounding != "down" && m.Rounding != "up":
+			errs = append(errs, fmt.Sprintf("metric %q: rounding must be nearest, down or up", m.ID))
+		}
+		metrics[m.ID] = true
+	}
+
+	badges := map[string]bool{}
+	for _, b := range c.Badges {
+		switch {
+		case core.ValidateBadgeID(core.Badge(b.ID)) != nil:
+			errs = append(errs, fmt.Sprintf("invalid badge id %q", b.ID))
+		case badges[b.ID]:
+			errs = append(errs, fmt.Sprintf("duplicate badge %q", b.ID))
+		case b.Cooldown < 0:
+			errs = append(errs, fmt.Sprintf("badge %q: cooldown cannot be negative", b.ID))
+		case b.Cooldown > 0 && !b.Repeatable:
+			errs = append(errs, fmt.Sprintf("badge %q: cooldown requires repeatable", b.ID))
+		}
+		badges[b.ID] = true
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Validate validates realtime configuration
+func (r *RealtimeConfig) Validate() error {
+	if r.MaxEventBytes < 0 {
+		return errors.New("max_event_bytes cannot be negative")
+	}
+	if r.OversizeAction != "" && r.OversizeAction != "truncate" && r.OversizeAction != "drop" {
+		return fmt.Errorf("oversize_action must be truncate or drop, got %q", r.OversizeAction)
+	}
+	for _, typ := range r.AckEvents {
+		if strings.TrimSpace(typ) == "" {
+			return errors.New("ack_events cannot contain empty event types")
+		}
+	}
+	if r.AckTimeout < 0 {
+		return errors.New("ack_timeout cannot be negative")
+	}
+	if r.AckRedeliveries < 0 {
+		return errors.New("ack_redeliveries cannot be negative")
+	}
+	switch r.Backplane {
+	case "":
+		return nil
+	case "redis":
+		if r.Channel == "" {
+			return errors.New("channel cannot be empty")
+		}
+		if err := r.Redis.Validate(); err != nil {
+			return fmt.Errorf("redis config: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("backplane must be empty or redis, got %q", r.Backplane)
+	}
+}
+
+// Validate validates tracing configuration
+func (t *TracingConfig) Validate() error {
+	var errs []string
+
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		errs = append(errs, fmt.Sprintf("sample_rate must be between 0 and 1, got %g", t.SampleRate))
+	}
+
+	if t.Enabled {
+		if u, err := url.Parse(t.Endpoint); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			errs = append(errs, "endpoint must be an http(s) URL when tracing is enabled")
+		}
+
+		if t.ServiceName == "" {
+			errs = append(errs, "service_name cannot be empty when tracing is enabled")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// validIdentityKind mirrors the kinds engine.WithIdentityKinds accepts
+func validIdentityKind(kind string) bool {
+	if kind == "" {
+		return false
+	}
+	for _, r := range kind {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// validPublicRoute reports whether route is a pattern httpapi.Options.PublicRoutes accepts: GET or
+// HEAD followed by a path starting with /, that http.ServeMux can register
+func validPublicRoute(route string) (ok bool) {
+	method, path, found := strings.Cut(strings.TrimSpace(route), " ")
+	if !found || (method != http.MethodGet && method != http.MethodHead) {
+		return false
+	}
+	if path = strings.TrimSpace(path); !strings.HasPrefix(path, "/") {
+		return false
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	http.NewServeMux().Handle(method+" "+path, http.NotFoundHandler())
+	return true
+}
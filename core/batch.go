@@ -0,0 +1,49 @@
+package core
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// BatchError reports the users a batch operation failed for. The operation succeeded for every
+// other user, and its results for them are returned alongside the error.
+type BatchError struct {
+    // Errors holds the failure of each user the operation did not complete for.
+    Errors map[UserID]error
+}
+
+// Error summarizes the failures, naming at most three users.
+func (e *BatchError) Error() string {
+    users := e.Users()
+    var b strings.Builder
+    fmt.Fprintf(&b, "%d users failed", len(users))
+    for i, u := range users {
+        if i == 3 { fmt.Fprintf(&b, "; and %d more", len(users)-3); break }
+        fmt.Fprintf(&b, "; %s: %v", u, e.Errors[u])
+    }
+    return b.String()
+}
+
+// Unwrap returns the per-user errors in user order, so errors.Is and errors.As see each of them.
+func (e *BatchError) Unwrap() []error {
+    users := e.Users()
+    out := make([]error, len(users))
+    for i, u := range users { out[i] = e.Errors[u] }
+    return out
+}
+
+// Users returns the failed users sorted by ID.
+func (e *BatchError) Users() []UserID {
+    users := make([]UserID, 0, len(e.Errors))
+    for u := range e.Errors { users = append(users, u) }
+    sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+    return users
+}
+
+// NewBatchError returns a *BatchError for errs, or nil when errs is empty, so a batch can return
+// its result unconditionally.
+func NewBatchError(errs map[UserID]error) error {
+    if len(errs) == 0 { return nil }
+    return &BatchError{Errors: errs}
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+var clock atomic.Pointer[func() time.Time]
+
+// SetClock replaces the clock event constructors, the engine, the memory adapter and leaderboards
+// read the current time from, e.g. with a FakeClock in tests, and returns the previous one. nil
+// restores time.Now. Components given their own clock (such as memory.Store.SetClock) keep it.
+func SetClock(now func() time.Time) func() time.Time {
+    var next *func() time.Time
+    if now != nil { next = &now }
+    if prev := clock.Swap(next); prev != nil { return *prev }
+    return time.Now
+}
+
+// CurrentTime returns the time of the configured clock (time.Now unless replaced with SetClock).
+func CurrentTime() time.Time {
+    if now := clock.Load(); now != nil { return (*now)() }
+    return time.Now()
+}
+
+// FakeClock is a manually advanced clock for deterministic tests. Install it with
+// SetClock(c.Now); it only moves when Advance or Set is called.
+type FakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+// NewFakeClock returns a clock standing at start.
+func NewFakeClock(start time.Time) *FakeClock { return &FakeClock{now: start} }
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+    c.mu.Lock(); defer c.mu.Unlock()
+    return c.now
+}
+
+// Advance moves the clock forward by d and returns the new time.
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+    c.mu.Lock(); defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+    return c.now
+}
+
+// Set moves the clock to t, which may be in the past.
+func (c *FakeClock) Set(t time.Time) {
+    c.mu.Lock(); defer c.mu.Unlock()
+    c.now = t
+}
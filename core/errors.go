@@ -0,0 +1,40 @@
+package core
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+var (
+    // ErrBackendBusy reports a storage backend that could not take a request right now, e.g. an
+    // exhausted connection pool or a server still loading; the write was not applied.
+    ErrBackendBusy = errors.New("storage backend busy")
+    // ErrConflict reports a write aborted by a concurrent one (deadlock, serialization failure,
+    // optimistic lock); the write was not applied.
+    ErrConflict = errors.New("write conflict")
+)
+
+// IsTransient reports whether err is worth retrying: it wraps ErrBackendBusy or ErrConflict, or an
+// error whose Transient method returns true. Adapters only classify errors as transient when the
+// failed write is known not to have been applied, so a retry cannot apply it twice.
+func IsTransient(err error) bool {
+    if errors.Is(err, ErrBackendBusy) || errors.Is(err, ErrConflict) { return true }
+    var t interface{ Transient() bool }
+    return errors.As(err, &t) && t.Transient()
+}
+
+// ContextError returns err wrapped with ctx's error when ctx ended and err does not already wrap it,
+// so a timed-out or cancelled operation reads as errors.Is(err, context.DeadlineExceeded) or
+// context.Canceled whatever the driver reported, e.g. a cancelled statement or an i/o timeout.
+func ContextError(ctx context.Context, err error) error {
+    if err == nil { return nil }
+    cerr := ctx.Err()
+    if cerr == nil || errors.Is(err, cerr) { return err }
+    return fmt.Errorf("%w: %w", cerr, err)
+}
+
+// IsContextDone reports whether err wraps context.DeadlineExceeded or context.Canceled.
+func IsContextDone(err error) bool {
+    return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
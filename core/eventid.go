@@ -0,0 +1,99 @@
+package core
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ErrInvalidEventID is returned for event IDs that are empty, longer than 128 bytes or contain
+// characters other than printable ASCII without spaces.
+var ErrInvalidEventID = errors.New("invalid event ID")
+
+// EventIDGenerator returns a new event ID. IDs must be unique for the lifetime of the data they
+// end up in (event logs, client acknowledgements), so generators should not repeat across restarts.
+type EventIDGenerator func() string
+
+var eventIDs atomic.Pointer[EventIDGenerator]
+
+// SetEventIDGenerator replaces the generator event constructors use, e.g. with
+// SequentialEventIDs in tests, and returns the previous one. nil restores NewUUIDv7.
+func SetEventIDGenerator(gen EventIDGenerator) EventIDGenerator {
+    var next *EventIDGenerator
+    if gen != nil { next = &gen }
+    if prev := eventIDs.Swap(next); prev != nil { return *prev }
+    return NewUUIDv7
+}
+
+// NewEventID returns an ID from the configured generator (NewUUIDv7 unless replaced).
+func NewEventID() string {
+    if gen := eventIDs.Load(); gen != nil { return (*gen)() }
+    return NewUUIDv7()
+}
+
+// SequentialEventIDs returns a generator handing out prefix-1, prefix-2, ... for deterministic tests.
+func SequentialEventIDs(prefix string) EventIDGenerator {
+    var n atomic.Uint64
+    return func() string { return prefix + "-" + strconv.FormatUint(n.Add(1), 10) }
+}
+
+// ValidateEventID checks an event ID, e.g. one supplied by a client.
+func ValidateEventID(id string) error {
+    if id == "" || len(id) > 128 { return ErrInvalidEventID }
+    for i := 0; i < len(id); i++ {
+        if id[i] <= ' ' || id[i] > '~' { return ErrInvalidEventID }
+    }
+    return nil
+}
+
+// uuidClock keeps UUIDv7s of one process increasing within a millisecond
+var uuidClock struct {
+    sync.Mutex
+    ms  int64
+    seq uint16
+}
+
+// NewUUIDv7 returns an RFC 9562 version 7 UUID: a millisecond timestamp followed by random bits,
+// so IDs sort by creation time. IDs created in the same millisecond by this process are ordered
+// by a 12-bit counter in place of the first random bits.
+func NewUUIDv7() string {
+    var b [16]byte
+    _, _ = rand.Read(b[:])
+
+    uuidClock.Lock()
+    ms := time.Now().UnixMilli()
+    if ms <= uuidClock.ms {
+        uuidClock.seq++
+        if uuidClock.seq > 0xfff {
+            // counter exhausted: borrow the next millisecond
+            uuidClock.ms++
+            uuidClock.seq = 0
+        }
+        ms = uuidClock.ms
+    } else {
+        uuidClock.ms, uuidClock.seq = ms, 0
+    }
+    seq := uuidClock.seq
+    uuidClock.Unlock()
+
+    b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+    b[6] = 0x70 | byte(seq>>8)
+    b[7] = byte(seq)
+    b[8] = 0x80 | b[8]&0x3f
+
+    var out [36]byte
+    hex.Encode(out[0:8], b[0:4])
+    out[8] = '-'
+    hex.Encode(out[9:13], b[4:6])
+    out[13] = '-'
+    hex.Encode(out[14:18], b[6:8])
+    out[18] = '-'
+    hex.Encode(out[19:23], b[8:10])
+    out[23] = '-'
+    hex.Encode(out[24:], b[10:])
+    return string(out[:])
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+    "regexp"
+    "testing"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+    format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+    prev := ""
+    for i := 0; i < 10000; i++ {
+        id := NewUUIDv7()
+        if !format.MatchString(id) { t.Fatalf("not a UUIDv7: %s", id) }
+        if id <= prev { t.Fatalf("IDs must increase: %s after %s", id, prev) }
+        prev = id
+    }
+}
+
+func TestEventIDGenerator(t *testing.T) {
+    prev := SetEventIDGenerator(SequentialEventIDs("ev"))
+    defer SetEventIDGenerator(prev)
+    a, b := NewPointsAdded("u", MetricXP, 1, 1), NewBadgeAwarded("u", "b")
+    if a.ID != "ev-1" || b.ID != "ev-2" { t.Fatalf("got %q %q", a.ID, b.ID) }
+    if typed, _ := a.AsPointsAdded(); typed.ID != a.ID { t.Fatalf("typed event lost its ID: %q", typed.ID) }
+
+    SetEventIDGenerator(nil)
+    if err := ValidateEventID(NewEventID()); err != nil { t.Fatalf("default IDs must validate: %v", err) }
+    for _, id := range []string{"", "a b", "tab\t", string(make([]byte, 129))} {
+        if ValidateEventID(id) == nil { t.Fatalf("%q should be rejected", id) }
+    }
+}
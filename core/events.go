@@ -1,6 +1,9 @@
 package core
 
-import "time"
+import (
+    "encoding/json"
+    "time"
+)
 
 // EventType enumerates domain events.
 type EventType string
@@ -10,10 +13,24 @@ const (
     EventBadgeAwarded         EventType = "badge_awarded"
     EventAchievementUnlocked  EventType = "achievement_unlocked"
     EventLevelUp              EventType = "level_up"
+    EventLevelDown            EventType = "level_down"
+    EventStateReplaced        EventType = "state_replaced"
+    EventPointsTransferred    EventType = "points_transferred"
+    EventBadgeRevoked         EventType = "badge_revoked"
+    EventEnteredTopN          EventType = "entered_top_n"
+    EventLeftTopN             EventType = "left_top_n"
+    EventQuestProgress        EventType = "quest_progress"
+    EventQuestCompleted       EventType = "quest_completed"
+    EventUsersMerged          EventType = "users_merged"
 )
 
-// Event represents an immutable domain event.
+// Event represents an immutable domain event. Time is taken when the event is created, right
+// after the write it reports, and never goes backwards within one user's events.
 type Event struct {
+    // ID identifies the event uniquely, e.g. for deduplication and client acknowledgements. It is
+    // set by the constructors below (see SetEventIDGenerator) and by the engine's event bus for
+    // events published without one.
+    ID        string           `json:"id,omitempty"`
     Type      EventType        `json:"type"`
     Time      time.Time        `json:"time"`
     UserID    UserID           `json:"user_id"`
@@ -23,18 +40,237 @@ type Event struct {
     Badge     Badge            `json:"badge,omitempty"`
     Level     int64            `json:"level,omitempty"`
     Metadata  map[string]any   `json:"metadata,omitempty"`
+    // Seq numbers a user's events from 1 in publish order, so consumers can detect gaps. It is
+    // assigned by the engine's event bus when the event is published.
+    Seq       uint64           `json:"seq,omitempty"`
 }
 
 func NewPointsAdded(user UserID, metric Metric, delta int64, total int64) Event {
-    return Event{Type: EventPointsAdded, Time: time.Now().UTC(), UserID: user, Metric: metric, Delta: delta, Total: total}
+    return Event{ID: NewEventID(), Type: EventPointsAdded, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Delta: delta, Total: total}
 }
 
 func NewBadgeAwarded(user UserID, badge Badge) Event {
-    return Event{Type: EventBadgeAwarded, Time: time.Now().UTC(), UserID: user, Badge: badge}
+    return Event{ID: NewEventID(), Type: EventBadgeAwarded, Time: CurrentTime().UTC(), UserID: user, Badge: badge}
 }
 
 func NewLevelUp(user UserID, metric Metric, level int64) Event {
-    return Event{Type: EventLevelUp, Time: time.Now().UTC(), UserID: user, Metric: metric, Level: level}
+    return Event{ID: NewEventID(), Type: EventLevelUp, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Level: level}
+}
+
+// NewLevelDown reports a level lost because the metric's total fell below the level's threshold.
+func NewLevelDown(user UserID, metric Metric, level int64) Event {
+    return Event{ID: NewEventID(), Type: EventLevelDown, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Level: level}
+}
+
+// NewPointsTransferred reports one side of a transfer of points from one user to another: the sender's
+// event has a negative Delta, the receiver's a positive one, and both carry "from" and "to" in Metadata.
+func NewPointsTransferred(user, from, to UserID, metric Metric, delta, total int64) Event {
+    return Event{ID: NewEventID(), Type: EventPointsTransferred, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Delta: delta, Total: total,
+        Metadata: map[string]any{"from": string(from), "to": string(to)}}
+}
+
+// NewBadgeRevoked reports a badge taken away again, e.g. a maintained badge whose condition no longer holds.
+func NewBadgeRevoked(user UserID, badge Badge) Event {
+    return Event{ID: NewEventID(), Type: EventBadgeRevoked, Time: CurrentTime().UTC(), UserID: user, Badge: badge}
+}
+
+// NewAchievementUnlocked reports an achievement reached for the first time; badge records the unlock
+// and the achievement's name is carried in Metadata["achievement"].
+func NewAchievementUnlocked(user UserID, achievement string, badge Badge) Event {
+    return Event{ID: NewEventID(), Type: EventAchievementUnlocked, Time: CurrentTime().UTC(), UserID: user, Badge: badge,
+        Metadata: map[string]any{"achievement": achievement}}
+}
+
+// NewStateReplaced reports an administrative overwrite of a user's whole state. The state written
+// is carried in Metadata["state"], so an event log can reconstruct it; see ReplacedState.
+func NewStateReplaced(user UserID, state UserState) Event {
+    state = state.Clone()
+    state.UserID = user
+    return Event{ID: NewEventID(), Type: EventStateReplaced, Time: CurrentTime().UTC(), UserID: user,
+        Metadata: map[string]any{"state": state}}
+}
+
+// ReplacedState returns the state a state_replaced event wrote, whether Metadata["state"] still
+// holds the UserState or was decoded from JSON, e.g. when read back from an event log. It reports
+// false for other events and for state_replaced events that carry no state.
+func (e Event) ReplacedState() (UserState, bool) {
+    if e.Type != EventStateReplaced { return UserState{}, false }
+    switch v := e.Metadata["state"].(type) {
+    case nil:
+        return UserState{}, false
+    case UserState:
+        return v.Clone(), true
+    default:
+        b, err := json.Marshal(v)
+        if err != nil { return UserState{}, false }
+        var state UserState
+        if err := json.Unmarshal(b, &state); err != nil { return UserState{}, false }
+        return state.Clone(), true
+    }
 }
 
+// NewUsersMerged reports that source's state was merged into user's and source deleted; source is
+// carried in Metadata["source"]. It follows the events reporting the target's resulting changes.
+func NewUsersMerged(user, source UserID) Event {
+    return Event{ID: NewEventID(), Type: EventUsersMerged, Time: CurrentTime().UTC(), UserID: user,
+        Metadata: map[string]any{"source": string(source)}}
+}
+
+// NewEnteredTopN reports a user moving into the top n of a leaderboard. The board's name is carried in
+// Metadata["board"], n in Metadata["n"] and, when the move pushed someone out, that user in
+// Metadata["displaced"].
+func NewEnteredTopN(user UserID, board string, n int, displaced UserID) Event {
+    meta := map[string]any{"board": board, "n": n}
+    if displaced != "" { meta["displaced"] = string(displaced) }
+    return Event{ID: NewEventID(), Type: EventEnteredTopN, Time: CurrentTime().UTC(), UserID: user, Metadata: meta}
+}
+
+// NewLeftTopN reports a user dropping out of the top n of a leaderboard, with the user who took
+// their place, if any, in Metadata["displaced_by"]; see NewEnteredTopN.
+func NewLeftTopN(user UserID, board string, n int, by UserID) Event {
+    meta := map[string]any{"board": board, "n": n}
+    if by != "" { meta["displaced_by"] = string(by) }
+    return Event{ID: NewEventID(), Type: EventLeftTopN, Time: CurrentTime().UTC(), UserID: user, Metadata: meta}
+}
+
+// MetaSynthetic is the Metadata key flagging synthetic events; see MarkSynthetic.
+const MetaSynthetic = "synthetic"
+
+// MarkSynthetic returns e flagged as synthetic: made up for testing, e.g. by the debug emitter, rather
+// than reporting a write. Event logs and analytics hooks skip synthetic events, so only realtime
+// clients see them.
+func MarkSynthetic(e Event) Event {
+    meta := make(map[string]any, len(e.Metadata)+1)
+    for k, v := range e.Metadata { meta[k] = v }
+    meta[MetaSynthetic] = true
+    e.Metadata = meta
+    return e
+}
+
+// Synthetic reports whether e was flagged with MarkSynthetic.
+func (e Event) Synthetic() bool {
+    v, _ := e.Metadata[MetaSynthetic].(bool)
+    return v
+}
+
+// MetaAck is the Metadata key flagging events that need an acknowledgement; see RequireAck.
+const MetaAck = "ack"
+
+// RequireAck returns e flagged as needing an acknowledgement: realtime clients that opted into
+// acknowledged delivery must confirm they received it by its ID, or it is sent again. Flag the
+// events a user must not miss, such as a rare badge or a prize.
+func RequireAck(e Event) Event {
+    meta := make(map[string]any, len(e.Metadata)+1)
+    for k, v := range e.Metadata { meta[k] = v }
+    meta[MetaAck] = true
+    e.Metadata = meta
+    return e
+}
 
+// AckRequired reports whether e was flagged with RequireAck.
+func (e Event) AckRequired() bool {
+    v, _ := e.Metadata[MetaAck].(bool)
+    return v
+}
+
+// PointsAddedEvent is the typed form of a points_added event.
+type PointsAddedEvent struct {
+    ID     string
+    UserID UserID
+    Metric Metric
+    Delta  int64
+    Total  int64
+    Time   time.Time
+    Seq    uint64
+}
+
+// BadgeAwardedEvent is the typed form of a badge_awarded event.
+type BadgeAwardedEvent struct {
+    ID     string
+    UserID UserID
+    Badge  Badge
+    Time   time.Time
+    Seq    uint64
+}
+
+// BadgeRevokedEvent is the typed form of a badge_revoked event.
+type BadgeRevokedEvent struct {
+    ID     string
+    UserID UserID
+    Badge  Badge
+    Time   time.Time
+    Seq    uint64
+}
+
+// LevelUpEvent is the typed form of a level_up event.
+type LevelUpEvent struct {
+    ID     string
+    UserID UserID
+    Metric Metric
+    Level  int64
+    Time   time.Time
+    Seq    uint64
+}
+
+// LevelDownEvent is the typed form of a level_down event; Level is the new, lower level.
+type LevelDownEvent struct {
+    ID     string
+    UserID UserID
+    Metric Metric
+    Level  int64
+    Time   time.Time
+    Seq    uint64
+}
+
+// PointsTransferredEvent is the typed form of one side of a points_transferred event; From and To
+// are decoded from Metadata.
+type PointsTransferredEvent struct {
+    ID     string
+    UserID UserID
+    From   UserID
+    To     UserID
+    Metric Metric
+    Delta  int64
+    Total  int64
+    Time   time.Time
+    Seq    uint64
+}
+
+// AsPointsAdded returns the typed event, or false if e is not a points_added event.
+func (e Event) AsPointsAdded() (PointsAddedEvent, bool) {
+    if e.Type != EventPointsAdded { return PointsAddedEvent{}, false }
+    return PointsAddedEvent{ID: e.ID, UserID: e.UserID, Metric: e.Metric, Delta: e.Delta, Total: e.Total, Time: e.Time, Seq: e.Seq}, true
+}
+
+// AsBadgeAwarded returns the typed event, or false if e is not a badge_awarded event.
+func (e Event) AsBadgeAwarded() (BadgeAwardedEvent, bool) {
+    if e.Type != EventBadgeAwarded { return BadgeAwardedEvent{}, false }
+    return BadgeAwardedEvent{ID: e.ID, UserID: e.UserID, Badge: e.Badge, Time: e.Time, Seq: e.Seq}, true
+}
+
+// AsBadgeRevoked returns the typed event, or false if e is not a badge_revoked event.
+func (e Event) AsBadgeRevoked() (BadgeRevokedEvent, bool) {
+    if e.Type != EventBadgeRevoked { return BadgeRevokedEvent{}, false }
+    return BadgeRevokedEvent{ID: e.ID, UserID: e.UserID, Badge: e.Badge, Time: e.Time, Seq: e.Seq}, true
+}
+
+// AsLevelUp returns the typed event, or false if e is not a level_up event.
+func (e Event) AsLevelUp() (LevelUpEvent, bool) {
+    if e.Type != EventLevelUp { return LevelUpEvent{}, false }
+    return LevelUpEvent{ID: e.ID, UserID: e.UserID, Metric: e.Metric, Level: e.Level, Time: e.Time, Seq: e.Seq}, true
+}
+
+// AsLevelDown returns the typed event, or false if e is not a level_down event.
+func (e Event) AsLevelDown() (LevelDownEvent, bool) {
+    if e.Type != EventLevelDown { return LevelDownEvent{}, false }
+    return LevelDownEvent{ID: e.ID, UserID: e.UserID, Metric: e.Metric, Level: e.Level, Time: e.Time, Seq: e.Seq}, true
+}
+
+// AsPointsTransferred returns the typed event, or false if e is not a points_transferred event.
+func (e Event) AsPointsTransferred() (PointsTransferredEvent, bool) {
+    if e.Type != EventPointsTransferred { return PointsTransferredEvent{}, false }
+    from, _ := e.Metadata["from"].(string)
+    to, _ := e.Metadata["to"].(string)
+    return PointsTransferredEvent{ID: e.ID, UserID: e.UserID, From: UserID(from), To: UserID(to), Metric: e.Metric,
+        Delta: e.Delta, Total: e.Total, Time: e.Time, Seq: e.Seq}, true
+}
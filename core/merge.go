@@ -0,0 +1,83 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrSelfMerge is returned when the source and target of a merge are the same user.
+var ErrSelfMerge = errors.New("cannot merge a user into itself")
+
+// PointsMerge is how a merge combines the source's and the target's totals of a metric that both
+// users have.
+type PointsMerge string
+
+const (
+    // MergeSum adds the source's total to the target's. It is the default.
+    MergeSum PointsMerge = "sum"
+    // MergeMax keeps the higher of the two totals, e.g. for metrics that record a best score.
+    MergeMax PointsMerge = "max"
+)
+
+// Validate rejects unknown modes; empty means MergeSum.
+func (m PointsMerge) Validate() error {
+    switch m {
+    case "", MergeSum, MergeMax:
+        return nil
+    }
+    return fmt.Errorf("unknown points merge %q (want sum or max)", string(m))
+}
+
+// MergePolicy says how a source user's state is merged into a target's, e.g. when a guest account
+// is linked to a registered one. Points of metrics only one of them has are kept as they are; for
+// metrics both have, Metrics gives the mode per metric and Points the mode of the others. Badges
+// are always united and each metric's stored level is the higher of the two. The zero policy sums
+// every metric.
+type MergePolicy struct {
+    Points  PointsMerge            `json:"points,omitempty"`
+    Metrics map[Metric]PointsMerge `json:"metrics,omitempty"`
+}
+
+// Validate rejects unknown modes.
+func (p MergePolicy) Validate() error {
+    if err := p.Points.Validate(); err != nil { return err }
+    for metric, m := range p.Metrics {
+        if err := m.Validate(); err != nil { return fmt.Errorf("metric %s: %w", metric, err) }
+    }
+    return nil
+}
+
+// For returns the mode used for metric. Season ledgers use the mode of their base metric.
+func (p MergePolicy) For(metric Metric) PointsMerge {
+    base, _, _ := SplitSeasonMetric(metric)
+    if m, ok := p.Metrics[base]; ok && m != "" { return m }
+    if p.Points == "" { return MergeSum }
+    return p.Points
+}
+
+// Merge returns the target's state with the source's merged in, without modifying either. It fails
+// if a sum overflows.
+func (p MergePolicy) Merge(source, target UserState) (UserState, error) {
+    merged := target.Clone()
+    if merged.Points == nil { merged.Points = map[Metric]int64{} }
+    if merged.Badges == nil { merged.Badges = map[Badge]struct{}{} }
+    if merged.Levels == nil { merged.Levels = map[Metric]int64{} }
+    for metric, v := range source.Points {
+        have, ok := merged.Points[metric]
+        switch {
+        case !ok:
+            merged.Points[metric] = v
+        case p.For(metric) == MergeMax:
+            merged.Points[metric] = max(have, v)
+        default:
+            sum, err := AddSafe(have, v)
+            if err != nil { return UserState{}, fmt.Errorf("merging %s: %w", metric, err) }
+            merged.Points[metric] = sum
+        }
+    }
+    for badge := range source.Badges { merged.Badges[badge] = struct{}{} }
+    for metric, level := range source.Levels {
+        if have, ok := merged.Levels[metric]; !ok || level > have { merged.Levels[metric] = level }
+    }
+    return merged, nil
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+    "math"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestMergePolicy(t *testing.T) {
+    t0 := time.Unix(100, 0).UTC()
+    source := state(map[Metric]int64{MetricXP: 40, "best": 90, "gems": 3, SeasonMetric("best", "s1"): 50}, []Badge{"guest", "shared"}, map[Metric]int64{MetricXP: 4, "gems": 1}, t0)
+    target := state(map[Metric]int64{MetricXP: 30, "best": 70, SeasonMetric("best", "s1"): 60}, []Badge{"shared", "member"}, map[Metric]int64{MetricXP: 2}, t0)
+    target.UserID = "target"
+
+    policy := MergePolicy{Metrics: map[Metric]PointsMerge{"best": MergeMax}}
+    merged, err := policy.Merge(source, target)
+    if err != nil { t.Fatal(err) }
+    want := state(map[Metric]int64{MetricXP: 70, "best": 90, "gems": 3, SeasonMetric("best", "s1"): 60}, []Badge{"guest", "shared", "member"}, map[Metric]int64{MetricXP: 4, "gems": 1}, t0)
+    want.UserID = "target"
+    if !reflect.DeepEqual(merged, want) { t.Fatalf("merged = %+v, want %+v", merged, want) }
+    if target.Points[MetricXP] != 30 || len(target.Badges) != 2 { t.Fatal("Merge modified the target") }
+
+    if m, _ := (MergePolicy{Points: MergeMax}).Merge(source, target); m.Points[MetricXP] != 40 { t.Errorf("max xp = %d, want 40", m.Points[MetricXP]) }
+    huge := state(map[Metric]int64{MetricXP: math.MaxInt64}, nil, nil, t0)
+    if _, err := (MergePolicy{}).Merge(huge, target); err == nil { t.Error("overflowing sum accepted") }
+    if err := (MergePolicy{Metrics: map[Metric]PointsMerge{"best": "min"}}).Validate(); err == nil { t.Error("unknown mode accepted") }
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+    "context"
+    "errors"
+)
+
+// Namespace identifies a tenant, e.g. one game, whose users are kept apart from other namespaces.
+type Namespace string
+
+var (
+    // ErrNoNamespace is returned by namespace-scoped operations called without a namespace.
+    ErrNoNamespace = errors.New("no namespace in context")
+    // ErrInvalidNamespace is returned for namespaces failing ValidateNamespace.
+    ErrInvalidNamespace = errors.New("invalid namespace")
+)
+
+// ValidateNamespace accepts 1 to 64 letters, digits, dashes and underscores.
+func ValidateNamespace(ns Namespace) error {
+    if ns == "" || len(ns) > 64 { return ErrInvalidNamespace }
+    for _, r := range ns {
+        if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+            continue
+        }
+        return ErrInvalidNamespace
+    }
+    return nil
+}
+
+type namespaceKey struct{}
+
+// WithNamespace returns a context carrying ns, for storage scoped by namespace to pick up.
+func WithNamespace(ctx context.Context, ns Namespace) context.Context {
+    return context.WithValue(ctx, namespaceKey{}, ns)
+}
+
+// NamespaceFromContext returns the namespace set by WithNamespace.
+func NamespaceFromContext(ctx context.Context) (Namespace, bool) {
+    ns, ok := ctx.Value(namespaceKey{}).(Namespace)
+    return ns, ok && ns != ""
+}
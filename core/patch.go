@@ -0,0 +1,80 @@
+package core
+
+import (
+    "encoding/json"
+    "sort"
+    "time"
+)
+
+// StatePatch is the minimal set of changes that turns one UserState into another.
+// Maps hold new or changed values; Removed* lists keys that no longer exist.
+type StatePatch struct {
+    UserID        UserID           `json:"user_id"`
+    Points        map[Metric]int64 `json:"points,omitempty"`
+    RemovedPoints []Metric         `json:"removed_points,omitempty"`
+    AddedBadges   []Badge          `json:"added_badges,omitempty"`
+    RemovedBadges []Badge          `json:"removed_badges,omitempty"`
+    Levels        map[Metric]int64 `json:"levels,omitempty"`
+    RemovedLevels []Metric         `json:"removed_levels,omitempty"`
+    Updated       time.Time        `json:"updated"`
+}
+
+// Empty reports whether the patch changes nothing besides the Updated timestamp.
+func (p StatePatch) Empty() bool {
+    return len(p.Points) == 0 && len(p.RemovedPoints) == 0 && len(p.AddedBadges) == 0 &&
+        len(p.RemovedBadges) == 0 && len(p.Levels) == 0 && len(p.RemovedLevels) == 0
+}
+
+// MarshalJSON encodes Updated with FormatTimestamp, like UserState.
+func (p StatePatch) MarshalJSON() ([]byte, error) {
+    type patch StatePatch
+    return json.Marshal(struct {
+        patch
+        Updated string `json:"updated"`
+    }{patch(p), FormatTimestamp(p.Updated)})
+}
+
+// DiffState returns the patch that ApplyPatch needs to turn old into new.
+// Slices are sorted so equal inputs always produce identical patches.
+func DiffState(old, new UserState) StatePatch {
+    p := StatePatch{UserID: new.UserID, Updated: new.Updated}
+    p.Points, p.RemovedPoints = diffCounters(old.Points, new.Points)
+    p.Levels, p.RemovedLevels = diffCounters(old.Levels, new.Levels)
+    for b := range new.Badges {
+        if _, ok := old.Badges[b]; !ok { p.AddedBadges = append(p.AddedBadges, b) }
+    }
+    for b := range old.Badges {
+        if _, ok := new.Badges[b]; !ok { p.RemovedBadges = append(p.RemovedBadges, b) }
+    }
+    sort.Slice(p.AddedBadges, func(i, j int) bool { return p.AddedBadges[i] < p.AddedBadges[j] })
+    sort.Slice(p.RemovedBadges, func(i, j int) bool { return p.RemovedBadges[i] < p.RemovedBadges[j] })
+    return p
+}
+
+// ApplyPatch returns a copy of old with p applied; old itself is not modified.
+func ApplyPatch(old UserState, p StatePatch) UserState {
+    st := old.Clone()
+    st.UserID = p.UserID
+    st.Updated = p.Updated
+    for m, v := range p.Points { st.Points[m] = v }
+    for _, m := range p.RemovedPoints { delete(st.Points, m) }
+    for m, v := range p.Levels { st.Levels[m] = v }
+    for _, m := range p.RemovedLevels { delete(st.Levels, m) }
+    for _, b := range p.AddedBadges { st.Badges[b] = struct{}{} }
+    for _, b := range p.RemovedBadges { delete(st.Badges, b) }
+    return st
+}
+
+func diffCounters(old, new map[Metric]int64) (changed map[Metric]int64, removed []Metric) {
+    for m, v := range new {
+        if ov, ok := old[m]; !ok || ov != v {
+            if changed == nil { changed = map[Metric]int64{} }
+            changed[m] = v
+        }
+    }
+    for m := range old {
+        if _, ok := new[m]; !ok { removed = append(removed, m) }
+    }
+    sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+    return changed, removed
+}
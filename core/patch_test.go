@@ -0,0 +1,52 @@
+package core
+
+import (
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func state(points map[Metric]int64, badges []Badge, levels map[Metric]int64, updated time.Time) UserState {
+    st := UserState{UserID: "u", Points: points, Badges: map[Badge]struct{}{}, Levels: levels, Updated: updated}
+    for _, b := range badges { st.Badges[b] = struct{}{} }
+    return st.Clone()
+}
+
+func TestDiffApplyRoundTrip(t *testing.T) {
+    t0, t1 := time.Unix(100, 0).UTC(), time.Unix(200, 0).UTC()
+    empty := state(nil, nil, nil, t0)
+    cases := []struct {
+        name     string
+        old, new UserState
+    }{
+        {"identical", state(map[Metric]int64{MetricXP: 5}, []Badge{"a"}, map[Metric]int64{MetricXP: 1}, t0), state(map[Metric]int64{MetricXP: 5}, []Badge{"a"}, map[Metric]int64{MetricXP: 1}, t0)},
+        {"from empty", empty, state(map[Metric]int64{MetricXP: 5, "coins": 2}, []Badge{"a", "b"}, map[Metric]int64{MetricXP: 2}, t1)},
+        {"to empty", state(map[Metric]int64{MetricXP: 5}, []Badge{"a"}, map[Metric]int64{MetricXP: 2}, t0), state(nil, nil, nil, t1)},
+        {"mixed", state(map[Metric]int64{MetricXP: 5, "coins": 2}, []Badge{"a", "b"}, map[Metric]int64{MetricXP: 1}, t0),
+            state(map[Metric]int64{MetricXP: 9, "gems": 1}, []Badge{"b", "c"}, map[Metric]int64{MetricXP: 2, "gems": 1}, t1)},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            p := DiffState(tc.old, tc.new)
+            if got := ApplyPatch(tc.old, p); !reflect.DeepEqual(got, tc.new) { t.Fatalf("round trip mismatch\n got %#v\nwant %#v", got, tc.new) }
+
+            // the patch must survive the wire too
+            b, err := json.Marshal(p)
+            if err != nil { t.Fatal(err) }
+            var decoded StatePatch
+            if err := json.Unmarshal(b, &decoded); err != nil { t.Fatal(err) }
+            if got := ApplyPatch(tc.old, decoded); !reflect.DeepEqual(got, tc.new) { t.Fatalf("round trip via JSON mismatch: %s", b) }
+        })
+    }
+}
+
+func TestDiffStateIsMinimal(t *testing.T) {
+    old := state(map[Metric]int64{MetricXP: 5, "coins": 2}, []Badge{"a"}, map[Metric]int64{MetricXP: 1}, time.Time{})
+    next := old.Clone()
+    next.Points["coins"] = 3
+    p := DiffState(old, next)
+    if len(p.Points) != 1 || p.Points["coins"] != 3 || len(p.Levels) != 0 || len(p.AddedBadges) != 0 { t.Fatalf("patch not minimal: %#v", p) }
+    if DiffState(old, old).Empty() != true { t.Fatal("diff of equal states must be empty") }
+    if old.Points["coins"] != 2 { t.Fatal("ApplyPatch/DiffState must not mutate inputs") }
+}
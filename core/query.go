@@ -0,0 +1,119 @@
+package core
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "time"
+)
+
+var (
+    // ErrInvalidFilter is returned for user filters that cannot be evaluated.
+    ErrInvalidFilter = errors.New("invalid user filter")
+    // ErrInvalidBadgeFilter is returned for badge filters that cannot be evaluated.
+    ErrInvalidBadgeFilter = errors.New("invalid badge filter")
+)
+
+// UserFilter selects users by what they hold; all set conditions must match. Users are returned
+// in user ID order, Limit at a time: pass the last ID of a page as After to get the next one.
+type UserFilter struct {
+    // Badge, if set, selects holders of the badge.
+    Badge Badge `json:"badge,omitempty"`
+    // Metric is the metric MinPoints, MaxPoints and Level refer to.
+    Metric Metric `json:"metric,omitempty"`
+    // MinPoints and MaxPoints, if set, bound the user's total of Metric (inclusive). Users
+    // without points of Metric never match them.
+    MinPoints *int64 `json:"min_points,omitempty"`
+    MaxPoints *int64 `json:"max_points,omitempty"`
+    // Level, if set, selects users whose stored level of Metric equals it.
+    Level *int64 `json:"level,omitempty"`
+    // After, if set, skips users up to and including this ID.
+    After UserID `json:"after,omitempty"`
+    // Limit caps the number of users returned; storages treat zero as no limit.
+    Limit int `json:"limit,omitempty"`
+}
+
+// Validate checks that metric conditions name a metric and the points range is not empty.
+func (f UserFilter) Validate() error {
+    if f.Badge != "" {
+        if err := ValidateBadgeID(f.Badge); err != nil { return fmt.Errorf("%w: %v", ErrInvalidFilter, err) }
+    }
+    if (f.MinPoints != nil || f.MaxPoints != nil || f.Level != nil) && f.Metric == "" {
+        return fmt.Errorf("%w: points and level conditions need a metric", ErrInvalidFilter)
+    }
+    if f.MinPoints != nil && f.MaxPoints != nil && *f.MinPoints > *f.MaxPoints {
+        return fmt.Errorf("%w: min points %d above max points %d", ErrInvalidFilter, *f.MinPoints, *f.MaxPoints)
+    }
+    if f.Limit < 0 { return fmt.Errorf("%w: negative limit", ErrInvalidFilter) }
+    return nil
+}
+
+// Matches reports whether state meets the filter's conditions; After and Limit are not applied.
+func (f UserFilter) Matches(state UserState) bool {
+    if f.Badge != "" {
+        if _, ok := state.Badges[f.Badge]; !ok { return false }
+    }
+    if f.MinPoints != nil || f.MaxPoints != nil {
+        points, ok := state.Points[f.Metric]
+        if !ok { return false }
+        if f.MinPoints != nil && points < *f.MinPoints { return false }
+        if f.MaxPoints != nil && points > *f.MaxPoints { return false }
+    }
+    if f.Level != nil {
+        level, ok := state.Levels[f.Metric]
+        if !ok || level != *f.Level { return false }
+    }
+    return true
+}
+
+// BadgeAward is a badge a user holds and when it was awarded (last awarded, for repeatable badges).
+type BadgeAward struct {
+    Badge     Badge     `json:"badge"`
+    AwardedAt time.Time `json:"awarded_at"`
+}
+
+// MarshalJSON encodes AwardedAt with FormatTimestamp, like UserState.Updated.
+func (a BadgeAward) MarshalJSON() ([]byte, error) {
+    return json.Marshal(struct {
+        Badge     Badge  `json:"badge"`
+        AwardedAt string `json:"awarded_at"`
+    }{a.Badge, FormatTimestamp(a.AwardedAt)})
+}
+
+// Precedes reports whether a is listed before b: newer awards first, equal times by badge, descending.
+func (a BadgeAward) Precedes(b BadgeAward) bool {
+    if !a.AwardedAt.Equal(b.AwardedAt) { return a.AwardedAt.After(b.AwardedAt) }
+    return a.Badge > b.Badge
+}
+
+// BadgeFilter pages through the badges of one user, newest award first. Pass the last award of a
+// page as After to get the next one.
+type BadgeFilter struct {
+    // Since, if set, selects badges awarded at or after it.
+    Since time.Time
+    // After, if set, skips awards up to and including this one in listing order.
+    After *BadgeAward
+    // Limit caps the number of awards returned; storages treat zero as no limit.
+    Limit int
+}
+
+// Validate checks the limit.
+func (f BadgeFilter) Validate() error {
+    if f.Limit < 0 { return fmt.Errorf("%w: negative limit", ErrInvalidBadgeFilter) }
+    return nil
+}
+
+// Apply sorts awards into listing order and returns the page the filter selects, for storages
+// that hold every award of a user in memory. awards is sorted in place.
+func (f BadgeFilter) Apply(awards []BadgeAward) []BadgeAward {
+    sort.Slice(awards, func(i, j int) bool { return awards[i].Precedes(awards[j]) })
+    page := awards[:0:0]
+    for _, a := range awards {
+        if !f.Since.IsZero() && a.AwardedAt.Before(f.Since) { continue }
+        if f.After != nil && !f.After.Precedes(a) { continue }
+        page = append(page, a)
+        if f.Limit > 0 && len(page) == f.Limit { break }
+    }
+    return page
+}
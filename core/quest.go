@@ -0,0 +1,89 @@
+package core
+
+import (
+    "fmt"
+    "time"
+)
+
+// QuestReset is how often a quest starts afresh: never (QuestOnce), or every UTC day, ISO week or
+// UTC month.
+type QuestReset string
+
+const (
+    QuestOnce    QuestReset = ""
+    QuestDaily   QuestReset = "daily"
+    QuestWeekly  QuestReset = "weekly"
+    QuestMonthly QuestReset = "monthly"
+)
+
+// Validate rejects unknown resets.
+func (r QuestReset) Validate() error {
+    switch r {
+    case QuestOnce, QuestDaily, QuestWeekly, QuestMonthly:
+        return nil
+    }
+    return fmt.Errorf("unknown quest reset %q (want daily, weekly, monthly or none)", string(r))
+}
+
+// Period names the period t falls in, e.g. "2024-03-09", "2024-W10" or "2024-03"; progress made in
+// one period does not count in the next. Quests that never reset have a single period, "".
+func (r QuestReset) Period(t time.Time) string {
+    t = t.UTC()
+    switch r {
+    case QuestDaily:
+        return t.Format("2006-01-02")
+    case QuestWeekly:
+        year, week := t.ISOWeek()
+        return fmt.Sprintf("%d-W%02d", year, week)
+    case QuestMonthly:
+        return t.Format("2006-01")
+    }
+    return ""
+}
+
+// Next returns when the period t falls in ends, or the zero time for quests that never reset.
+func (r QuestReset) Next(t time.Time) time.Time {
+    t = t.UTC()
+    day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+    switch r {
+    case QuestDaily:
+        return day.AddDate(0, 0, 1)
+    case QuestWeekly:
+        // ISO weeks start on Monday
+        return day.AddDate(0, 0, 7-(int(t.Weekday())+6)%7)
+    case QuestMonthly:
+        return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+    }
+    return time.Time{}
+}
+
+// QuestProgress is a user's progress on a quest in one period.
+type QuestProgress struct {
+    Quest       string     `json:"quest"`
+    Period      string     `json:"period,omitempty"`
+    Progress    int64      `json:"progress"`
+    Target      int64      `json:"target"`
+    Completed   bool       `json:"completed"`
+    CompletedAt *time.Time `json:"completed_at,omitempty"`
+    // ResetsAt is when the period ends, for quests that reset.
+    ResetsAt    *time.Time `json:"resets_at,omitempty"`
+}
+
+// NewQuestProgress reports points counted towards a quest; Delta is what was counted, Total the
+// progress so far and Metadata holds "quest", "target" and, for quests that reset, "period".
+func NewQuestProgress(user UserID, metric Metric, p QuestProgress, delta int64) Event {
+    return Event{ID: NewEventID(), Type: EventQuestProgress, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Delta: delta, Total: p.Progress,
+        Metadata: questMetadata(p)}
+}
+
+// NewQuestCompleted reports a quest whose target was reached, with the same Metadata as NewQuestProgress.
+func NewQuestCompleted(user UserID, metric Metric, p QuestProgress) Event {
+    return Event{ID: NewEventID(), Type: EventQuestCompleted, Time: CurrentTime().UTC(), UserID: user, Metric: metric, Total: p.Progress,
+        Metadata: questMetadata(p)}
+}
+
+func questMetadata(p QuestProgress) map[string]any {
+    meta := map[string]any{"quest": p.Quest, "target": p.Target}
+    if p.Period != "" { meta["period"] = p.Period }
+    return meta
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// SeasonSeparator joins a metric and a season into the metric key of the season's ledger, e.g.
+// "xp@2024-spring". Storages keep season ledgers as ordinary metrics, so the separator is reserved:
+// metric names containing it are rejected (see ErrReservedMetric).
+const SeasonSeparator = "@"
+
+// ErrReservedMetric is returned for writes to metrics whose name contains SeasonSeparator. Such
+// keys belong to season ledgers, which only the engine writes.
+var ErrReservedMetric = errors.New("metric name contains the reserved season separator")
+
+// CheckMetricName rejects metric names containing SeasonSeparator with ErrReservedMetric.
+func CheckMetricName(m Metric) error {
+    if strings.Contains(string(m), SeasonSeparator) { return fmt.Errorf("%w %q: %q", ErrReservedMetric, SeasonSeparator, m) }
+    return nil
+}
+
+// ErrInvalidSeason is returned for season names that are empty, longer than 64 characters or
+// contain characters other than letters, digits, '.', '_' and '-'.
+var ErrInvalidSeason = errors.New("invalid season")
+
+// ValidateSeason checks a season name.
+func ValidateSeason(season string) error {
+    if season == "" || len(season) > 64 { return ErrInvalidSeason }
+    for _, r := range season {
+        switch {
+        case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+        default:
+            return ErrInvalidSeason
+        }
+    }
+    return nil
+}
+
+// SeasonMetric returns the metric key of metric's ledger in season.
+func SeasonMetric(metric Metric, season string) Metric {
+    return metric + Metric(SeasonSeparator+season)
+}
+
+// SplitSeasonMetric reverses SeasonMetric; ok is false for all-time metrics.
+func SplitSeasonMetric(m Metric) (metric Metric, season string, ok bool) {
+    i := strings.LastIndex(string(m), SeasonSeparator)
+    if i < 0 { return m, "", false }
+    return m[:i], string(m[i+1:]), true
+}
+
+// AllTime returns a copy of the state without season ledgers.
+func (s UserState) AllTime() UserState {
+    return s.filterMetrics(func(m Metric) (Metric, bool) {
+        _, _, seasonal := SplitSeasonMetric(m)
+        return m, !seasonal
+    })
+}
+
+// Season returns a copy of the state holding only season's ledgers, under their base metric
+// names. Badges are not seasonal and are kept.
+func (s UserState) Season(season string) UserState {
+    return s.filterMetrics(func(m Metric) (Metric, bool) {
+        base, in, ok := SplitSeasonMetric(m)
+        return base, ok && in == season
+    })
+}
+
+func (s UserState) filterMetrics(keep func(Metric) (Metric, bool)) UserState {
+    cp := s.Clone()
+    cp.Points = make(map[Metric]int64, len(s.Points))
+    cp.Levels = make(map[Metric]int64, len(s.Levels))
+    for m, v := range s.Points {
+        if name, ok := keep(m); ok { cp.Points[name] = v }
+    }
+    for m, v := range s.Levels {
+        if name, ok := keep(m); ok { cp.Levels[name] = v }
+    }
+    return cp
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// DefaultTimestampPrecision is the precision of UserState.Updated unless SetTimestampPrecision
+// changes it.
+const DefaultTimestampPrecision = time.Millisecond
+
+var timestampPrecision atomic.Int64
+
+func init() { timestampPrecision.Store(int64(DefaultTimestampPrecision)) }
+
+// SetTimestampPrecision sets the precision every storage adapter truncates UserState.Updated to,
+// and returns the previous one. Values that are not positive restore DefaultTimestampPrecision.
+// Set it once at startup, before any state is written.
+func SetTimestampPrecision(d time.Duration) time.Duration {
+    if d <= 0 { d = DefaultTimestampPrecision }
+    return time.Duration(timestampPrecision.Swap(int64(d)))
+}
+
+// TimestampPrecision returns the precision set with SetTimestampPrecision.
+func TimestampPrecision() time.Duration { return time.Duration(timestampPrecision.Load()) }
+
+// Timestamp returns t in UTC, truncated to TimestampPrecision. Adapters pass every state timestamp
+// through it, so backends that store nanoseconds and backends that store milliseconds return the
+// same value and an unchanged state compares equal.
+func Timestamp(t time.Time) time.Time { return t.UTC().Truncate(TimestampPrecision()) }
+
+// Now returns the current time of the configured clock (see SetClock) as a Timestamp.
+func Now() time.Time { return Timestamp(CurrentTime()) }
+
+// FormatTimestamp formats t as RFC 3339 in UTC with a fixed number of fraction digits, as many as
+// TimestampPrecision resolves (three for milliseconds, none for whole seconds).
+func FormatTimestamp(t time.Time) string {
+    digits := 9
+    for p := TimestampPrecision(); p >= 10 && digits > 0; p /= 10 { digits-- }
+    layout := "2006-01-02T15:04:05Z07:00"
+    if digits > 0 { layout = "2006-01-02T15:04:05." + strings.Repeat("0", digits) + "Z07:00" }
+    return Timestamp(t).Format(layout)
+}
@@ -0,0 +1,14 @@
+package core
+
+import "errors"
+
+var (
+    // ErrSelfTransfer is returned when the sender and receiver of a transfer are the same user.
+    ErrSelfTransfer = errors.New("cannot transfer points to the same user")
+    // ErrInvalidAmount is returned for transfers of zero or negative amounts.
+    ErrInvalidAmount = errors.New("transfer amount must be positive")
+    // ErrInsufficientPoints is returned when a transfer would take the sender below their floor.
+    ErrInsufficientPoints = errors.New("insufficient points")
+    // ErrReceiverLimit is returned when a transfer would take the receiver above their maximum.
+    ErrReceiverLimit = errors.New("transfer exceeds the receiver's limit")
+)
@@ -1,6 +1,7 @@
 package core
 
 import (
+    "encoding/json"
     "errors"
     "math"
     "strings"
@@ -21,6 +22,12 @@ const (
 // Badge represents a named badge identifier.
 type Badge string
 
+// BadgeRecord is how often and when a user last earned a repeatable badge.
+type BadgeRecord struct {
+    Count       int64     `json:"count"`
+    LastAwarded time.Time `json:"last_awarded"`
+}
+
 // UserState is an immutable snapshot of a user's gamification state.
 // Implementations should return deep copies to maintain immutability guarantees.
 type UserState struct {
@@ -52,6 +59,15 @@ func (s UserState) Clone() UserState {
     return cp
 }
 
+// MarshalJSON encodes Updated with FormatTimestamp, so equal states always serialize identically.
+func (s UserState) MarshalJSON() ([]byte, error) {
+    type state UserState
+    return json.Marshal(struct {
+        state
+        Updated string `json:"updated"`
+    }{state(s), FormatTimestamp(s.Updated)})
+}
+
 // AddSafe adds delta to base ensuring no signed overflow occurs.
 func AddSafe(base int64, delta int64) (int64, error) {
     if (delta > 0 && base > math.MaxInt64-delta) || (delta < 0 && base < math.MinInt64-delta) {
@@ -60,6 +76,14 @@ func AddSafe(base int64, delta int64) (int64, error) {
     return base + delta, nil
 }
 
+// SubSafe subtracts b from a ensuring no signed overflow occurs.
+func SubSafe(a int64, b int64) (int64, error) {
+    if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+        return 0, errors.New("integer overflow in SubSafe")
+    }
+    return a - b, nil
+}
+
 // NormalizeUserID trims and lowercases user identifiers.
 func NormalizeUserID(id UserID) (UserID, error) {
     s := strings.TrimSpace(string(id))
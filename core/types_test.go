@@ -1,6 +1,11 @@
 package core
 
-import "testing"
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+)
 
 func TestAddSafe(t *testing.T) {
     if v, err := AddSafe(10, 5); err != nil || v != 15 { t.Fatalf("got %v %v", v, err) }
@@ -16,3 +21,21 @@ func TestDefaultLevel(t *testing.T) {
 }
 
 
+
+func TestUpdatedTimestampPrecision(t *testing.T) {
+    at := time.Date(2026, 3, 1, 12, 0, 0, 120_456_789, time.FixedZone("CET", 3600))
+    st := UserState{UserID: "alice", Updated: at}
+    b, err := json.Marshal(st)
+    if err != nil || !strings.Contains(string(b), `"updated":"2026-03-01T11:00:00.120Z"`) { t.Fatalf("got %s %v", b, err) }
+    var back UserState
+    if err := json.Unmarshal(b, &back); err != nil || !back.Updated.Equal(Timestamp(at)) { t.Fatalf("round trip: %v %v", back.Updated, err) }
+
+    // the fraction is fixed, so whole seconds keep their zeros
+    if got := FormatTimestamp(at.Truncate(time.Second)); got != "2026-03-01T11:00:00.000Z" { t.Fatalf("whole second = %s", got) }
+
+    prev := SetTimestampPrecision(time.Second)
+    defer SetTimestampPrecision(prev)
+    if got := FormatTimestamp(at); got != "2026-03-01T11:00:00Z" { t.Fatalf("second precision = %s", got) }
+    SetTimestampPrecision(time.Microsecond)
+    if got := Timestamp(at); got.Nanosecond() != 120_456_000 || got.Location() != time.UTC { t.Fatalf("microsecond precision = %v", got) }
+}
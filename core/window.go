@@ -0,0 +1,12 @@
+package core
+
+import (
+    "errors"
+    "time"
+)
+
+// DefaultPointsRetention is how long storages keep timestamped point increments for windowed queries.
+const DefaultPointsRetention = 7 * 24 * time.Hour
+
+// ErrWindowTooLong is returned when a window reaches further back than a storage retains increments.
+var ErrWindowTooLong = errors.New("window exceeds points retention")
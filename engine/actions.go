@@ -0,0 +1,217 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "maps"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// ErrInvalidAction is returned by Apply for actions without operations or with malformed ones.
+var ErrInvalidAction = errors.New("invalid action")
+
+// Operation is one step of an Action: adding Delta points of Metric, or awarding Badge.
+type Operation struct {
+    Metric core.Metric
+    Delta  int64
+    Badge  core.Badge
+}
+
+// Action is a set of operations applied to one user together, e.g. the rewards of a completed
+// quest: +100 XP, +50 coins and the "quest-done" badge, but only if the user does not hold it yet.
+type Action struct {
+    Operations []Operation
+    // Conditions must all accept the user's current state (levels of derived metrics already
+    // computed) for anything to be written; see WithCondition.
+    Conditions []func(core.UserState) bool
+}
+
+// OperationResult reports what one operation of an action did.
+type OperationResult struct {
+    Operation
+    // Applied is false for badges the user already held and for points the metric's value
+    // policy left unchanged.
+    Applied bool
+    // Total is the metric's all-time total after the operation, for point operations.
+    Total int64
+}
+
+// ActionResult is the outcome of Apply.
+type ActionResult struct {
+    // Applied is false when a condition rejected the action; nothing was written then.
+    Applied    bool
+    Operations []OperationResult
+    // State is the user's state after the action.
+    State core.UserState
+}
+
+// Apply runs the operations of action for user, in order, as one unit: the conditions are checked
+// and every operation validated (catalog, per-user limits, value policies) before anything is
+// written, and the writes share one transaction with the user locked on storages that support it
+// (see Txner and UserLocker). On other storages the writes already made are undone if a later one
+// fails, and conditions are checked on a best-effort basis. Points go through the same rule
+// multipliers and season ledgers as AddPointsIf, and the usual events are published once all
+// writes succeeded. Repeatable badges cannot be part of an action.
+func (g *GamifyService) Apply(ctx context.Context, user core.UserID, action Action) (res ActionResult, err error) {
+    ctx, span := tracing.Start(ctx, "engine.Apply")
+    span.SetUser(user)
+    span.SetAttr("operations", len(action.Operations))
+    defer func(){ span.End(err) }()
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return ActionResult{}, err }
+    if err := g.validateAction(action); err != nil { return ActionResult{}, err }
+    season, err := g.currentSeason(ctx, false)
+    if err != nil { return ActionResult{}, err }
+    var written []int64
+    var seasonTotals map[core.Metric]int64
+    unlock, err := g.lockUsers(ctx, normalized)
+    if err != nil { return ActionResult{}, err }
+    err = g.withRetry(ctx, "apply_action", func() error {
+        res = ActionResult{Operations: make([]OperationResult, len(action.Operations))}
+        written = make([]int64, len(action.Operations))
+        seasonTotals = map[core.Metric]int64{}
+        return RunInTx(ctx, g.storage, func(tx Storage) error {
+            if l, ok := tx.(UserLocker); ok {
+                if err := l.LockUser(ctx, normalized); err != nil { return err }
+            }
+            current, err := tx.GetState(ctx, normalized)
+            if err != nil { return err }
+            view := g.view(current.AllTime())
+            for _, cond := range action.Conditions {
+                if !cond(view) { return nil }
+            }
+            if err := g.planAction(ctx, current, view, normalized, action, &res, written); err != nil { return err }
+            if err := g.writeAction(ctx, tx, normalized, season, current, &res, written, seasonTotals); err != nil { return err }
+            res.Applied = true
+            return nil
+        })
+    })
+    unlock()
+    if err != nil { return ActionResult{}, err }
+    if res.Applied {
+        for i, op := range res.Operations {
+            if !op.Applied { continue }
+            if op.Badge != "" {
+                g.publish(ctx, core.NewBadgeAwarded(normalized, op.Badge))
+                continue
+            }
+            if total, ok := seasonTotals[op.Metric]; ok { g.syncSeasonBoards(normalized, op.Metric, season, total) }
+            g.afterAddPoints(ctx, normalized, op.Metric, written[i], op.Total)
+        }
+    }
+    res.State, err = g.GetState(ctx, normalized)
+    return res, err
+}
+
+// validateAction checks an action's shape and its metrics and badges against the catalog
+func (g *GamifyService) validateAction(action Action) error {
+    if len(action.Operations) == 0 { return fmt.Errorf("%w: no operations", ErrInvalidAction) }
+    for i, op := range action.Operations {
+        switch {
+        case op.Badge != "" && (op.Metric != "" || op.Delta != 0):
+            return fmt.Errorf("%w: operation %d both adds points and awards a badge", ErrInvalidAction, i)
+        case op.Badge != "":
+            if err := core.ValidateBadgeID(op.Badge); err != nil { return fmt.Errorf("%w: operation %d: %v", ErrInvalidAction, i, err) }
+            if err := g.checkBadge(op.Badge); err != nil { return err }
+            if _, ok := g.repeatable[op.Badge]; ok {
+                return fmt.Errorf("%w: repeatable badge %q cannot be awarded by an action", ErrInvalidAction, op.Badge)
+            }
+        case op.Metric == "":
+            return fmt.Errorf("%w: operation %d names neither a metric nor a badge", ErrInvalidAction, i)
+        case op.Delta == 0:
+            return fmt.Errorf("%w: operation %d has a zero delta", ErrInvalidAction, i)
+        default:
+            if err := g.checkMetric(op.Metric); err != nil { return err }
+        }
+    }
+    return nil
+}
+
+// planAction decides what each operation writes against current, without writing anything:
+// written gets the points delta of each point operation after multipliers and value policies
+func (g *GamifyService) planAction(ctx context.Context, current, view core.UserState, user core.UserID, action Action, res *ActionResult, written []int64) error {
+    // the state as it will be after the operations so far, for limits and policies
+    next := core.UserState{UserID: user, Points: maps.Clone(current.Points), Badges: maps.Clone(current.Badges)}
+    if next.Points == nil { next.Points = map[core.Metric]int64{} }
+    if next.Badges == nil { next.Badges = map[core.Badge]struct{}{} }
+    multiplier, multiplied := g.rules.(PointsMultiplier)
+    for i, op := range action.Operations {
+        res.Operations[i] = OperationResult{Operation: op}
+        if op.Badge != "" {
+            if _, held := next.Badges[op.Badge]; held { continue }
+            if max := g.limits.MaxBadges; max > 0 && len(next.Badges) >= max {
+                return g.limitExceeded("badges", user, fmt.Sprintf("user already has %d badges", len(next.Badges)))
+            }
+            next.Badges[op.Badge] = struct{}{}
+            res.Operations[i].Applied = true
+            continue
+        }
+        if err := g.checkMetricLimit(next, user, op.Metric); err != nil { return err }
+        scaled := op.Delta
+        if multiplied && scaled > 0 { scaled = multiplier.MultiplyPoints(ctx, view, op.Metric, scaled) }
+        previous := next.Points[op.Metric]
+        total, err := g.valuePolicy(op.Metric).Apply(previous, scaled)
+        if err != nil { return err }
+        next.Points[op.Metric] = total
+        res.Operations[i].Total = total
+        if total != previous {
+            written[i] = total - previous
+            res.Operations[i].Applied = true
+        }
+    }
+    return nil
+}
+
+// writeAction performs the planned writes through tx. Without a transaction the writes made so far
+// are undone when one fails.
+func (g *GamifyService) writeAction(ctx context.Context, tx Storage, user core.UserID, season string, current core.UserState, res *ActionResult, written []int64, seasonTotals map[core.Metric]int64) (err error) {
+    inTx := transactional(g.storage)
+    var undo []func() error
+    defer func() {
+        if err == nil || inTx { return }
+        for i := len(undo) - 1; i >= 0; i-- {
+            if uerr := undo[i](); uerr != nil {
+                // part of the action stays applied, so it must not be retried
+                err = noRetry{fmt.Errorf("%w (undoing the action also failed: %v)", err, uerr)}
+                return
+            }
+        }
+    }()
+    for i := range res.Operations {
+        op := &res.Operations[i]
+        if !op.Applied { continue }
+        if op.Badge != "" {
+            awarded, err := tryAwardBadge(ctx, tx, user, op.Badge)
+            if err != nil { return err }
+            // a concurrent award got there first
+            op.Applied = awarded
+            if awarded {
+                badge := op.Badge
+                undo = append(undo, func() error {
+                    r, ok := tx.(BadgeRemover)
+                    if !ok { return errors.New("storage cannot remove badges") }
+                    _, err := r.RemoveBadge(ctx, user, badge)
+                    return err
+                })
+            }
+            continue
+        }
+        metric, delta := op.Metric, written[i]
+        if op.Total, err = tx.AddPoints(ctx, user, metric, delta); err != nil { return err }
+        undo = append(undo, func() error { _, err := tx.AddPoints(ctx, user, metric, -delta); return err })
+        if season == "" { continue }
+        key := core.SeasonMetric(metric, season)
+        previous, ok := seasonTotals[metric]
+        if !ok { previous = current.Points[key] }
+        next, err := g.valuePolicy(metric).Apply(previous, delta)
+        if err != nil { return err }
+        seasonTotals[metric] = previous
+        if next == previous { continue }
+        if seasonTotals[metric], err = tx.AddPoints(ctx, user, key, next-previous); err != nil { return err }
+        undo = append(undo, func() error { _, err := tx.AddPoints(ctx, user, key, previous-next); return err })
+    }
+    return nil
+}
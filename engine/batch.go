@@ -0,0 +1,67 @@
+package engine
+
+import (
+    "context"
+    "errors"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// GetStateMany loads the states of users like GetState, e.g. to render a leaderboard page. It does
+// not fail as a whole: the map holds every user whose state loaded, and a non-nil error is a
+// *core.BatchError with the error of each user that did not, so callers can render what loaded and
+// flag the rest. Duplicate IDs are loaded once. Storages without StateBatchGetter are read one
+// user at a time; once ctx is done the remaining users fail with its error.
+func (g *GamifyService) GetStateMany(ctx context.Context, users []core.UserID) (map[core.UserID]core.UserState, error) {
+    ctx, span := tracing.Start(ctx, "engine.GetStateMany")
+    span.SetAttr("users", len(users))
+    unique := make([]core.UserID, 0, len(users))
+    seen := make(map[core.UserID]struct{}, len(users))
+    for _, u := range users {
+        if _, ok := seen[u]; ok { continue }
+        seen[u] = struct{}{}
+        unique = append(unique, u)
+    }
+
+    states, err := getStateMany(ctx, g.storage, unique)
+    if states == nil { states = map[core.UserID]core.UserState{} }
+    failed := batchFailures(unique, states, err)
+    for u, state := range states {
+        if _, bad := failed[u]; bad { delete(states, u); continue }
+        states[u] = g.view(state.AllTime())
+    }
+    err = core.NewBatchError(failed)
+    span.End(err)
+    return states, err
+}
+
+// getStateMany is StateBatchGetter.GetStateMany on any storage: others are read one user at a time
+func getStateMany(ctx context.Context, s Storage, users []core.UserID) (map[core.UserID]core.UserState, error) {
+    if b, ok := s.(StateBatchGetter); ok { return b.GetStateMany(ctx, users) }
+    states := make(map[core.UserID]core.UserState, len(users))
+    failed := map[core.UserID]error{}
+    for _, u := range users {
+        if err := ctx.Err(); err != nil { failed[u] = err; continue }
+        state, err := s.GetState(ctx, u)
+        if err != nil { failed[u] = err; continue }
+        states[u] = state
+    }
+    return states, core.NewBatchError(failed)
+}
+
+// batchFailures returns the error of each of users that a GetStateMany returning states and err failed
+func batchFailures(users []core.UserID, states map[core.UserID]core.UserState, err error) map[core.UserID]error {
+    failed := map[core.UserID]error{}
+    var batch *core.BatchError
+    switch {
+    case errors.As(err, &batch):
+        for u, e := range batch.Errors { failed[u] = e }
+    case err != nil:
+        // a storage failing as a whole failed every user it returned no state for
+        for _, u := range users {
+            if _, ok := states[u]; !ok { failed[u] = err }
+        }
+    }
+    return failed
+}
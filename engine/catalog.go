@@ -0,0 +1,149 @@
+package engine
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+
+    "gamifykit/core"
+)
+
+var (
+    // ErrUnknownMetric is returned in strict mode for metrics missing from the catalog.
+    ErrUnknownMetric = errors.New("unknown metric")
+    // ErrUnknownBadge is returned in strict mode for badges missing from the catalog.
+    ErrUnknownBadge = errors.New("unknown badge")
+)
+
+// MetricInfo describes a metric for clients, e.g. to render a dropdown. Unit is the unit values are
+// stored in; the display fields tell clients how to present them (see Format).
+type MetricInfo struct {
+    ID          core.Metric `json:"id"`
+    Name        string      `json:"name,omitempty"`
+    Unit        string      `json:"unit,omitempty"`
+    Description string      `json:"description,omitempty"`
+    // DisplayUnit is the unit values are shown in, e.g. "km" for a metric stored in meters.
+    DisplayUnit string      `json:"display_unit,omitempty"`
+    // Scale is the number of stored units per display unit, e.g. 1000 for meters shown in km
+    // (1 when zero).
+    Scale       int64       `json:"scale,omitempty"`
+    // Decimals is the number of digits after the point displayed values are rounded to.
+    Decimals    int         `json:"decimals,omitempty"`
+    // Rounding is how displayed values are rounded (RoundNearest when empty).
+    Rounding    Rounding    `json:"rounding,omitempty"`
+    // Computed is set for derived metrics, which are computed on read and cannot be written; see
+    // WithDerivedMetric.
+    Computed    bool        `json:"computed,omitempty"`
+}
+
+// BadgeInfo describes a badge for clients.
+type BadgeInfo struct {
+    ID          core.Badge     `json:"id"`
+    Name        string         `json:"name,omitempty"`
+    Description string         `json:"description,omitempty"`
+    Icon        string         `json:"icon,omitempty"`
+    Metadata    map[string]any `json:"metadata,omitempty"`
+}
+
+// Catalog lists the metrics and badges a service knows, sorted by ID.
+type Catalog struct {
+    Metrics []MetricInfo `json:"metrics"`
+    Badges  []BadgeInfo  `json:"badges"`
+    // Strict reports whether the service rejects metrics and badges missing from the catalog.
+    Strict bool `json:"strict"`
+}
+
+// catalog is the service's registry of known metrics and badges
+type catalog struct {
+    metrics map[core.Metric]MetricInfo
+    badges  map[core.Badge]BadgeInfo
+    strict  bool
+}
+
+// WithMetric registers a metric in the service's catalog. Metrics with a value policy, derived
+// levels or a leaderboard and derived metrics are registered automatically, without display
+// information. It panics
+// on invalid display settings (see MetricInfo.Validate).
+func WithMetric(info MetricInfo) ServiceOption {
+    if err := info.Validate(); err != nil { panic(fmt.Sprintf("WithMetric: %v", err)) }
+    return func(g *GamifyService){ g.catalog.metrics[info.ID] = info }
+}
+
+// WithBadge registers a badge in the service's catalog. Maintained and repeatable badges are
+// registered automatically, without display information.
+func WithBadge(info BadgeInfo) ServiceOption {
+    if err := core.ValidateBadgeID(info.ID); err != nil { panic(fmt.Sprintf("WithBadge: %v", err)) }
+    return func(g *GamifyService){ g.catalog.badges[info.ID] = info }
+}
+
+// WithStrictCatalog makes the catalog the single source of truth: AddPoints, Transfer and
+// AwardBadge reject metrics and badges it does not list with ErrUnknownMetric and ErrUnknownBadge.
+func WithStrictCatalog() ServiceOption {
+    return func(g *GamifyService){ g.catalog.strict = true }
+}
+
+// registerImplied adds the metrics and badges other options refer to
+func (c *catalog) registerImplied(g *GamifyService) {
+    add := func(m core.Metric) {
+        if _, ok := c.metrics[m]; !ok { c.metrics[m] = MetricInfo{ID: m} }
+    }
+    for m := range g.policies { add(m) }
+    for m := range g.derived { add(m) }
+    for m := range g.boards { add(m) }
+    for m := range g.computed {
+        info := c.metrics[m]
+        info.ID, info.Computed = m, true
+        c.metrics[m] = info
+    }
+    for _, c := range g.composites {
+        for m := range c.cfg.Weights { add(m) }
+    }
+    for _, sb := range g.seasons.boards { add(sb.metric) }
+    for _, mb := range g.maintained {
+        if _, ok := c.badges[mb.badge]; !ok { c.badges[mb.badge] = BadgeInfo{ID: mb.badge} }
+    }
+    for b := range g.repeatable {
+        if _, ok := c.badges[b]; !ok { c.badges[b] = BadgeInfo{ID: b} }
+    }
+}
+
+// Catalog returns the registered metrics and badges.
+func (g *GamifyService) Catalog() Catalog {
+    out := Catalog{Metrics: make([]MetricInfo, 0, len(g.catalog.metrics)), Badges: make([]BadgeInfo, 0, len(g.catalog.badges)), Strict: g.catalog.strict}
+    for _, m := range g.catalog.metrics { out.Metrics = append(out.Metrics, m) }
+    for _, b := range g.catalog.badges { out.Badges = append(out.Badges, b) }
+    sort.Slice(out.Metrics, func(i, j int) bool { return out.Metrics[i].ID < out.Metrics[j].ID })
+    sort.Slice(out.Badges, func(i, j int) bool { return out.Badges[i].ID < out.Badges[j].ID })
+    return out
+}
+
+// MetricInfo returns the catalog entry of metric.
+func (g *GamifyService) MetricInfo(metric core.Metric) (MetricInfo, bool) {
+    info, ok := g.catalog.metrics[metric]
+    return info, ok
+}
+
+// BadgeInfo returns the catalog entry of badge.
+func (g *GamifyService) BadgeInfo(badge core.Badge) (BadgeInfo, bool) {
+    info, ok := g.catalog.badges[badge]
+    return info, ok
+}
+
+// StrictCatalog reports whether unknown metrics and badges are rejected; see WithStrictCatalog.
+func (g *GamifyService) StrictCatalog() bool { return g.catalog.strict }
+
+// checkMetric rejects writes to season ledgers and derived metrics and enforces strict mode for metric
+func (g *GamifyService) checkMetric(metric core.Metric) error {
+    if err := core.CheckMetricName(metric); err != nil { return err }
+    if _, ok := g.computed[metric]; ok { return fmt.Errorf("%w: %q", ErrDerivedMetric, metric) }
+    if !g.catalog.strict { return nil }
+    if _, ok := g.catalog.metrics[metric]; !ok { return fmt.Errorf("%w: %q", ErrUnknownMetric, metric) }
+    return nil
+}
+
+// checkBadge enforces strict mode for badge
+func (g *GamifyService) checkBadge(badge core.Badge) error {
+    if !g.catalog.strict { return nil }
+    if _, ok := g.catalog.badges[badge]; !ok { return fmt.Errorf("%w: %q", ErrUnknownBadge, badge) }
+    return nil
+}
@@ -0,0 +1,68 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestCatalog(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithMetric(MetricInfo{ID: "coins", Name: "Coins"}),
+        WithDerivedLevels(core.MetricXP, MustLinearCurve(100)),
+        WithBadge(BadgeInfo{ID: "early_bird", Icon: "sunrise.svg"}),
+        WithMaintainedBadge("top_ten", func(core.UserState) bool { return true }),
+        WithStrictCatalog())
+
+    cat := svc.Catalog()
+    if len(cat.Metrics) != 2 || cat.Metrics[0].ID != "coins" || cat.Metrics[1].ID != core.MetricXP { t.Fatalf("want coins and the derived xp metric, got %+v", cat.Metrics) }
+    if len(cat.Badges) != 2 || cat.Badges[0].ID != "early_bird" || cat.Badges[1].ID != "top_ten" { t.Fatalf("want early_bird and the maintained top_ten, got %+v", cat.Badges) }
+    if info, ok := svc.MetricInfo("coins"); !ok || info.Name != "Coins" { t.Fatalf("coins should be described, got %+v %v", info, ok) }
+
+    if _, err := svc.AddPoints(ctx, "alice", "coins", 5); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", "gems", 5); !errors.Is(err, ErrUnknownMetric) { t.Fatalf("want ErrUnknownMetric, got %v", err) }
+    if err := svc.Transfer(ctx, "alice", "bob", "gems", 1); !errors.Is(err, ErrUnknownMetric) { t.Fatalf("want ErrUnknownMetric for transfers, got %v", err) }
+    if _, err := svc.AwardBadgeResult(ctx, "alice", "early_bird"); err != nil { t.Fatal(err) }
+    if _, err := svc.AwardBadgeResult(ctx, "alice", "night_owl"); !errors.Is(err, ErrUnknownBadge) { t.Fatalf("want ErrUnknownBadge, got %v", err) }
+
+    // without strict mode the catalog is informational only
+    open := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine())
+    if _, err := open.AddPoints(ctx, "alice", "gems", 5); err != nil { t.Fatal(err) }
+}
+
+func TestFormatPoints(t *testing.T) {
+    km := MetricInfo{ID: "distance", Unit: "m", DisplayUnit: "km", Scale: 1000, Decimals: 1}
+    cases := []struct {
+        info MetricInfo
+        v    int64
+        want string
+    }{
+        {km, 12500, "12.5 km"},
+        {km, 12549, "12.5 km"},
+        {km, 12550, "12.6 km"},
+        {km, -12550, "-12.6 km"},
+        {MetricInfo{ID: "distance", DisplayUnit: "km", Scale: 1000, Decimals: 1, Rounding: RoundDown}, 999, "0.9 km"},
+        {MetricInfo{ID: "time", Unit: "s", DisplayUnit: "min", Scale: 60, Rounding: RoundUp}, 61, "2 min"},
+        {MetricInfo{ID: "xp"}, 1234, "1234"},
+    }
+    for _, c := range cases {
+        if got := c.info.Format(c.v).Text; got != c.want { t.Fatalf("%+v formats %d as %q, want %q", c.info, c.v, got, c.want) }
+    }
+
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithMetric(km))
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "alice", "distance", 12500); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 7); err != nil { t.Fatal(err) }
+    st, _ := svc.GetState(ctx, "alice")
+    formatted := svc.FormatPoints(st)
+    if f := formatted["distance"]; f.Value != 12.5 || f.Unit != "km" { t.Fatalf("unexpected distance %+v", f) }
+    if f := formatted[core.MetricXP]; f.Text != "7" { t.Fatalf("unregistered metrics are plain counts, got %+v", f) }
+    if st.Points["distance"] != 12500 { t.Fatalf("stored value must stay an integer count, got %d", st.Points["distance"]) }
+
+    if err := (MetricInfo{ID: "x", Decimals: MaxDecimals + 1}).Validate(); err == nil { t.Fatal("too many decimals should be rejected") }
+    if err := (MetricInfo{ID: "x", Rounding: "sideways"}).Validate(); err == nil { t.Fatal("unknown rounding should be rejected") }
+}
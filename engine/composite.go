@@ -0,0 +1,124 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "sort"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// CompositeBoard weights the metrics a composite leaderboard combines, e.g. {"xp": 1, "wins": 10}
+// for a power ranking. A user's score is the sum of each metric's all-time total times its weight,
+// rounded to the nearest integer; metrics the user has no points in count as zero.
+type CompositeBoard map[core.Metric]float64
+
+// Score returns the weighted score of state.
+func (c CompositeBoard) Score(state core.UserState) int64 {
+    var score float64
+    for metric, weight := range c { score += weight * float64(state.Points[metric]) }
+    return int64(math.Round(score))
+}
+
+// CompositeBoardConfig registers a composite leaderboard.
+type CompositeBoardConfig struct {
+    Board   Leaderboard
+    Weights CompositeBoard
+    // MinScore is the inclusion threshold, as in BoardConfig.
+    MinScore int64
+}
+
+// compositeBoard is a registered composite leaderboard
+type compositeBoard struct {
+    name string
+    cfg  CompositeBoardConfig
+    // anyMetric is set for boards weighting a derived metric, whose inputs are unknown
+    anyMetric bool
+}
+
+// WithCompositeLeaderboard keeps cfg.Board updated with the weighted score of cfg.Weights' metrics
+// under name, e.g. to serve it with the per-metric boards as httpapi.Options.Leaderboards[name].
+// Whenever one of the metrics changes for a user, the user's state is read back and the score
+// recomputed from all of them; recomputations for one user run one at a time and read after the
+// write that triggered them, so the board ends on the score of the latest totals however the
+// metrics are updated concurrently. It costs a state read per write of a weighted metric, or of any
+// metric when a derived metric is weighted (see WithDerivedMetric). With
+// WithEventDrivenLeaderboards composite boards follow the events like the others. Use
+// RebuildCompositeBoard to fill a board registered on an existing deployment.
+func WithCompositeLeaderboard(name string, cfg CompositeBoardConfig) ServiceOption {
+    return func(g *GamifyService){
+        if name == "" { panic("WithCompositeLeaderboard: empty name") }
+        if cfg.Board == nil { panic(fmt.Sprintf("WithCompositeLeaderboard: nil leaderboard for %q", name)) }
+        if len(cfg.Weights) == 0 { panic(fmt.Sprintf("WithCompositeLeaderboard: %q weights no metrics", name)) }
+        for metric, w := range cfg.Weights {
+            if math.IsNaN(w) || math.IsInf(w, 0) { panic(fmt.Sprintf("WithCompositeLeaderboard: %q: invalid weight for %q", name, metric)) }
+        }
+        for _, c := range g.composites {
+            if c.name == name { panic(fmt.Sprintf("WithCompositeLeaderboard: %q registered twice", name)) }
+        }
+        weights := make(CompositeBoard, len(cfg.Weights))
+        for metric, w := range cfg.Weights { weights[metric] = w }
+        cfg.Weights = weights
+        g.composites = append(g.composites, compositeBoard{name: name, cfg: cfg})
+        if g.compLocks == nil { g.compLocks = &userLocks{held: map[core.UserID]*userLock{}} }
+    }
+}
+
+// CompositeBoards returns the names of the composite leaderboards, sorted.
+func (g *GamifyService) CompositeBoards() []string {
+    names := make([]string, 0, len(g.composites))
+    for _, c := range g.composites { names = append(names, c.name) }
+    sort.Strings(names)
+    return names
+}
+
+// RebuildCompositeBoard recomputes every user's score on the named composite leaderboard, e.g.
+// once after registering it on a deployment with existing users. The storage must implement
+// UserLister.
+func (g *GamifyService) RebuildCompositeBoard(ctx context.Context, name string) error {
+    var board *compositeBoard
+    for i := range g.composites {
+        if g.composites[i].name == name { board = &g.composites[i] }
+    }
+    if board == nil { return fmt.Errorf("unknown composite leaderboard %q", name) }
+    lister, ok := g.storage.(UserLister)
+    if !ok { return ErrUserListingUnsupported }
+    return lister.EachUser(ctx, func(user core.UserID) error {
+        return g.updateComposite(ctx, user, []compositeBoard{*board})
+    })
+}
+
+// updateComposites recomputes the user's score on the composite boards weighting metric or a
+// derived metric, or on all of them when metric is empty
+func (g *GamifyService) updateComposites(ctx context.Context, user core.UserID, metric core.Metric) error {
+    var boards []compositeBoard
+    for _, c := range g.composites {
+        if _, ok := c.cfg.Weights[metric]; ok || metric == "" || c.anyMetric { boards = append(boards, c) }
+    }
+    if len(boards) == 0 { return nil }
+    return g.updateComposite(ctx, user, boards)
+}
+
+// updateComposite reads the user's totals and applies them to boards, holding the user's
+// composite lock so a recomputation from older totals cannot overwrite a newer one
+func (g *GamifyService) updateComposite(ctx context.Context, user core.UserID, boards []compositeBoard) (err error) {
+    ctx, span := tracing.Start(ctx, "engine.syncCompositeBoards")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    unlock, err := g.compLocks.lock(ctx, user)
+    if err != nil { return err }
+    defer unlock()
+    state, err := g.storage.GetState(ctx, user)
+    if err != nil { return fmt.Errorf("failed to load user %s: %w", user, err) }
+    state = g.applyDerivedMetrics(state.AllTime())
+    for _, c := range boards {
+        if score := c.cfg.Weights.Score(state); score >= c.cfg.MinScore {
+            c.cfg.Board.Update(user, score)
+        } else {
+            c.cfg.Board.Remove(user)
+        }
+    }
+    return nil
+}
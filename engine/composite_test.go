@@ -0,0 +1,65 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func TestCompositeLeaderboard(t *testing.T) {
+    board := leaderboard.NewSkipList()
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithCompositeLeaderboard("power", CompositeBoardConfig{Board: board, Weights: CompositeBoard{core.MetricXP: 1, "wins": 10, "achievements": 2.5}, MinScore: 1}))
+    ctx := context.Background()
+
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 120); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", "wins", 3); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", "achievements", 1); err != nil { t.Fatal(err) }
+    if e, ok := board.Get("alice"); !ok || e.Score != 153 { t.Fatalf("alice = %+v, %v; want 120 + 30 + 2.5 rounded to 153", e, ok) }
+    if _, err := svc.AddPoints(ctx, "bob", "coins", 500); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("bob"); ok { t.Fatal("unweighted metric put bob on the board") }
+
+    if err := svc.Transfer(ctx, "alice", "bob", "wins", 3); err != nil { t.Fatal(err) }
+    if e, _ := board.Get("alice"); e.Score != 123 { t.Fatalf("alice after transfer = %d, want 123", e.Score) }
+    if e, _ := board.Get("bob"); e.Score != 30 { t.Fatalf("bob after transfer = %d, want 30", e.Score) }
+    if err := svc.ReplaceState(ctx, "bob", core.UserState{Points: map[core.Metric]int64{"coins": 1}}); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("bob"); ok { t.Fatal("bob kept on the board below the threshold after ReplaceState") }
+
+    // concurrent writes of different metrics leave the score of the final totals
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            metric := core.MetricXP
+            if i%2 == 0 { metric = "wins" }
+            if _, err := svc.AddPoints(ctx, "carol", metric, 1); err != nil { t.Error(err) }
+        }(i)
+    }
+    wg.Wait()
+    if e, _ := board.Get("carol"); e.Score != 10+100 { t.Fatalf("carol = %d, want 110", e.Score) }
+
+    fresh := leaderboard.NewSkipList()
+    rebuilt := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithCompositeLeaderboard("power", CompositeBoardConfig{Board: fresh, Weights: CompositeBoard{core.MetricXP: 1, "wins": 10}}))
+    if err := rebuilt.RebuildCompositeBoard(ctx, "power"); err != nil { t.Fatal(err) }
+    if e, ok := fresh.Get("alice"); !ok || e.Score != 120 { t.Fatalf("rebuilt alice = %+v, %v", e, ok) }
+    if err := rebuilt.RebuildCompositeBoard(ctx, "nope"); err == nil { t.Fatal("rebuilt an unknown board") }
+    if names := svc.CompositeBoards(); fmt.Sprint(names) != "[power]" { t.Fatalf("CompositeBoards = %v", names) }
+}
+
+func TestCompositeLeaderboardEventDriven(t *testing.T) {
+    board := leaderboard.NewSkipList()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithEventDrivenLeaderboards(),
+        WithCompositeLeaderboard("power", CompositeBoardConfig{Board: board, Weights: CompositeBoard{core.MetricXP: 1, "wins": 10}}))
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "alice", "wins", 2); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 5); err != nil { t.Fatal(err) }
+    if e, ok := board.Get("alice"); !ok || e.Score != 25 { t.Fatalf("alice = %+v, %v; want 25", e, ok) }
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+)
+
+// ErrInvalidCurve is returned by the curve constructors for parameters that do not describe
+// a strictly increasing curve.
+var ErrInvalidCurve = errors.New("invalid level curve")
+
+// LevelCurve maps metric totals to levels and back. Levels start at 1 and
+// PointsForLevel must be strictly increasing from PointsForLevel(1) == 0, so that
+// LevelFor(PointsForLevel(n)) == n and UIs can show the points needed for the next level.
+type LevelCurve interface {
+    LevelFor(points int64) int64
+    PointsForLevel(level int64) int64
+}
+
+// DefaultCurve is the curve behind core.DefaultLevel: level n starts at 100·(n-1)² points.
+var DefaultCurve LevelCurve = MustPolynomialCurve(100, 0, 0)
+
+// LinearCurve requires step points per level: level n starts at step·(n-1).
+func LinearCurve(step int64) (LevelCurve, error) {
+    if step <= 0 { return nil, fmt.Errorf("%w: linear curves need a positive step, got %d", ErrInvalidCurve, step) }
+    return linearCurve{step: step}, nil
+}
+
+// MustLinearCurve is LinearCurve for fixed parameters; it panics if they are invalid.
+func MustLinearCurve(step int64) LevelCurve { return must(LinearCurve(step)) }
+
+type linearCurve struct{ step int64 }
+
+func (c linearCurve) LevelFor(points int64) int64 {
+    if points <= 0 { return 1 }
+    return points/c.step + 1
+}
+
+func (c linearCurve) PointsForLevel(level int64) int64 {
+    if level <= 1 { return 0 }
+    return saturate(float64(c.step) * float64(level-1))
+}
+
+// ExponentialCurve makes each level cost factor times the previous one, starting at base
+// points for level 2: level n starts at ⌈base·(factorⁿ⁻¹ − 1)/(factor − 1)⌉.
+func ExponentialCurve(base int64, factor float64) (LevelCurve, error) {
+    if base <= 0 || !(factor > 1) || math.IsInf(factor, 0) {
+        return nil, fmt.Errorf("%w: exponential curves need a positive base and a finite factor above 1, got base=%d factor=%v", ErrInvalidCurve, base, factor)
+    }
+    return exponentialCurve{base: float64(base), factor: factor}, nil
+}
+
+// MustExponentialCurve is ExponentialCurve for fixed parameters; it panics if they are invalid.
+func MustExponentialCurve(base int64, factor float64) LevelCurve { return must(ExponentialCurve(base, factor)) }
+
+type exponentialCurve struct{ base, factor float64 }
+
+func (c exponentialCurve) LevelFor(points int64) int64 {
+    if points <= 0 { return 1 }
+    // invert the closed form, then settle any rounding against PointsForLevel
+    est := math.Log(float64(points)*(c.factor-1)/c.base+1)/math.Log(c.factor) + 1
+    return settleLevel(c, points, est)
+}
+
+func (c exponentialCurve) PointsForLevel(level int64) int64 {
+    if level <= 1 { return 0 }
+    return saturate(math.Ceil(c.base * (math.Pow(c.factor, float64(level-1)) - 1) / (c.factor - 1)))
+}
+
+// PolynomialCurve starts level n (n ≥ 2) at a·x² + b·x + c points, where x = n−1.
+// a and b must be non-negative and not both zero, and a+b+c must be positive.
+func PolynomialCurve(a, b, c int64) (LevelCurve, error) {
+    if a < 0 || b < 0 || a+b == 0 || a+b+c <= 0 {
+        return nil, fmt.Errorf("%w: polynomial curves need non-negative a and b, not both zero, and a positive a+b+c, got a=%d b=%d c=%d", ErrInvalidCurve, a, b, c)
+    }
+    return polynomialCurve{a: a, b: b, c: c}, nil
+}
+
+// MustPolynomialCurve is PolynomialCurve for fixed parameters; it panics if they are invalid.
+func MustPolynomialCurve(a, b, c int64) LevelCurve { return must(PolynomialCurve(a, b, c)) }
+
+type polynomialCurve struct{ a, b, c int64 }
+
+func (p polynomialCurve) LevelFor(points int64) int64 {
+    if points <= 0 { return 1 }
+    a, b, c := float64(p.a), float64(p.b), float64(p.c)
+    var x float64
+    if p.a == 0 {
+        x = (float64(points) - c) / b
+    } else {
+        x = (-b + math.Sqrt(b*b+4*a*(float64(points)-c))) / (2 * a)
+    }
+    return settleLevel(p, points, x+1)
+}
+
+func (p polynomialCurve) PointsForLevel(level int64) int64 {
+    if level <= 1 { return 0 }
+    x := float64(level - 1)
+    return saturate(float64(p.a)*x*x + float64(p.b)*x + float64(p.c))
+}
+
+// LogarithmicCurve makes the level grow with the logarithm of the total: level 2 starts at
+// base points and each further level at factor times the previous threshold, so level n
+// (n ≥ 2) starts at ⌈base·factorⁿ⁻²⌉. base·(factor − 1) must be at least 1 so that every
+// level costs at least one point more than the last.
+func LogarithmicCurve(base int64, factor float64) (LevelCurve, error) {
+    if base <= 0 || !(factor > 1) || math.IsInf(factor, 0) || float64(base)*(factor-1) < 1 {
+        return nil, fmt.Errorf("%w: logarithmic curves need a positive base and a finite factor above 1 with base·(factor−1) of at least 1, got base=%d factor=%v", ErrInvalidCurve, base, factor)
+    }
+    return logarithmicCurve{base: float64(base), factor: factor}, nil
+}
+
+// MustLogarithmicCurve is LogarithmicCurve for fixed parameters; it panics if they are invalid.
+func MustLogarithmicCurve(base int64, factor float64) LevelCurve { return must(LogarithmicCurve(base, factor)) }
+
+type logarithmicCurve struct{ base, factor float64 }
+
+func (c logarithmicCurve) LevelFor(points int64) int64 {
+    if points <= 0 { return 1 }
+    est := math.Log(float64(points)/c.base)/math.Log(c.factor) + 2
+    return settleLevel(c, points, est)
+}
+
+func (c logarithmicCurve) PointsForLevel(level int64) int64 {
+    if level <= 1 { return 0 }
+    return saturate(math.Ceil(c.base * math.Pow(c.factor, float64(level-2))))
+}
+
+// TableCurve lists the thresholds of levels 2, 3, … explicitly, e.g. TableCurve(100, 250, 500).
+// Thresholds must be positive and strictly increasing; the last one starts the highest level.
+func TableCurve(thresholds ...int64) (LevelCurve, error) {
+    if len(thresholds) == 0 { return nil, fmt.Errorf("%w: table curves need thresholds", ErrInvalidCurve) }
+    prev := int64(0)
+    for i, t := range thresholds {
+        if t <= prev { return nil, fmt.Errorf("%w: thresholds must be positive and increase, got %d at index %d", ErrInvalidCurve, t, i) }
+        prev = t
+    }
+    return tableCurve(append([]int64(nil), thresholds...)), nil
+}
+
+// MustTableCurve is TableCurve for fixed thresholds; it panics if they are invalid.
+func MustTableCurve(thresholds ...int64) LevelCurve { return must(TableCurve(thresholds...)) }
+
+type tableCurve []int64
+
+func (c tableCurve) LevelFor(points int64) int64 {
+    return int64(sort.Search(len(c), func(i int) bool { return c[i] > points })) + 1
+}
+
+func (c tableCurve) PointsForLevel(level int64) int64 {
+    switch {
+    case level <= 1: return 0
+    case level-2 < int64(len(c)): return c[level-2]
+    default: return math.MaxInt64
+    }
+}
+
+func must(c LevelCurve, err error) LevelCurve {
+    if err != nil { panic(err) }
+    return c
+}
+
+// settleLevel corrects an estimated level so that the result is the highest level whose
+// threshold does not exceed points.
+func settleLevel(c LevelCurve, points int64, estimate float64) int64 {
+    level := int64(1)
+    if estimate > 1 && !math.IsNaN(estimate) {
+        level = int64(math.Min(estimate, math.MaxInt64/2))
+    }
+    for level > 1 && c.PointsForLevel(level) > points { level-- }
+    for {
+        next := c.PointsForLevel(level + 1)
+        if next > points || next <= c.PointsForLevel(level) { return level }
+        level++
+    }
+}
+
+// saturate converts a threshold to int64, pinning values past the int64 range at math.MaxInt64
+func saturate(v float64) int64 {
+    if v >= math.MaxInt64 { return math.MaxInt64 }
+    return int64(v)
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+    "errors"
+    "math"
+    "testing"
+
+    "gamifykit/core"
+)
+
+func TestLevelCurves(t *testing.T) {
+    curves := map[string]LevelCurve{
+        "linear":      MustLinearCurve(250),
+        "exponential": MustExponentialCurve(100, 1.5),
+        "steep":       MustExponentialCurve(1, 10),
+        "logarithmic": MustLogarithmicCurve(100, 1.5),
+        "log gentle":  MustLogarithmicCurve(10, 1.1),
+        "polynomial":  MustPolynomialCurve(50, 25, 10),
+        "offset":      MustPolynomialCurve(0, 30, -5),
+        "table":       MustTableCurve(100, 250, 600, 1000),
+        "default":     DefaultCurve,
+    }
+    for name, c := range curves {
+        t.Run(name, func(t *testing.T) {
+            if c.PointsForLevel(1) != 0 || c.LevelFor(0) != 1 || c.LevelFor(-10) != 1 { t.Fatal("level 1 must start at 0 points") }
+            prev := int64(0)
+            for n := int64(2); n <= 60; n++ {
+                p := c.PointsForLevel(n)
+                if p == math.MaxInt64 { break }
+                if p <= prev { t.Fatalf("PointsForLevel not strictly increasing at %d: %d <= %d", n, p, prev) }
+                if got := c.LevelFor(p); got != n { t.Fatalf("LevelFor(PointsForLevel(%d)) = %d", n, got) }
+                if got := c.LevelFor(p - 1); got != n-1 { t.Fatalf("LevelFor(%d) = %d, want %d", p-1, got, n-1) }
+                prev = p
+            }
+            last := int64(0)
+            for pts := int64(0); pts < 100000; pts += 37 {
+                l := c.LevelFor(pts)
+                if l < last { t.Fatalf("LevelFor not monotonic at %d", pts) }
+                last = l
+            }
+            if c.LevelFor(math.MaxInt64) < 1 { t.Fatal("LevelFor must handle the largest totals") }
+        })
+    }
+}
+
+func TestDefaultCurveMatchesDefaultLevel(t *testing.T) {
+    for pts := int64(0); pts < 50000; pts += 7 {
+        if DefaultCurve.LevelFor(pts) != core.DefaultLevel(pts) { t.Fatalf("mismatch at %d", pts) }
+    }
+}
+
+func TestExponentialCurveThresholds(t *testing.T) {
+    c := MustExponentialCurve(100, 2)
+    want := []int64{0, 100, 300, 700, 1500}
+    for i, w := range want {
+        if got := c.PointsForLevel(int64(i + 1)); got != w { t.Fatalf("level %d: want %d, got %d", i+1, w, got) }
+    }
+}
+
+func TestLogarithmicCurveThresholds(t *testing.T) {
+    c := MustLogarithmicCurve(100, 2)
+    want := []int64{0, 100, 200, 400, 800}
+    for i, w := range want {
+        if got := c.PointsForLevel(int64(i + 1)); got != w { t.Fatalf("level %d: want %d, got %d", i+1, w, got) }
+    }
+    if got := c.LevelFor(799); got != 4 { t.Fatalf("LevelFor(799) = %d, want 4", got) }
+}
+
+func TestCurveConstructorsRejectInvalidParameters(t *testing.T) {
+    for name, f := range map[string]func() (LevelCurve, error){
+        "zero step":     func() (LevelCurve, error) { return LinearCurve(0) },
+        "flat factor":   func() (LevelCurve, error) { return ExponentialCurve(10, 1) },
+        "nan factor":    func() (LevelCurve, error) { return ExponentialCurve(10, math.NaN()) },
+        "log base":      func() (LevelCurve, error) { return LogarithmicCurve(0, 2) },
+        "log flat":      func() (LevelCurve, error) { return LogarithmicCurve(100, 1) },
+        "log too close": func() (LevelCurve, error) { return LogarithmicCurve(5, 1.1) },
+        "flat poly":     func() (LevelCurve, error) { return PolynomialCurve(0, 0, 10) },
+        "negative":      func() (LevelCurve, error) { return PolynomialCurve(0, 5, -5) },
+        "empty table":   func() (LevelCurve, error) { return TableCurve() },
+        "flat table":    func() (LevelCurve, error) { return TableCurve(100, 100) },
+    } {
+        if c, err := f(); !errors.Is(err, ErrInvalidCurve) || c != nil { t.Errorf("%s: want ErrInvalidCurve, got %v, %v", name, c, err) }
+    }
+}
+
+func TestMustCurvePanicsOnInvalidParameters(t *testing.T) {
+    defer func() { if recover() == nil { t.Fatal("expected panic") } }()
+    MustLinearCurve(0)
+}
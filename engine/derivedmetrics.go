@@ -0,0 +1,79 @@
+package engine
+
+import (
+    "errors"
+    "fmt"
+    "maps"
+
+    "gamifykit/core"
+)
+
+// ErrDerivedMetric is returned by writes to a metric registered with WithDerivedMetric.
+var ErrDerivedMetric = errors.New("metric is derived and cannot be written")
+
+// WithDerivedMetric registers metric as a read-only function of the user's stored metrics, e.g. a
+// total score of xp + 2*coins that can never disagree with its inputs. It is never stored: GetState,
+// GetStateMany, GetSeasonState and GetPoints compute it on read from the stored points (the season's
+// ledgers for season states), rules and conditions see it, and zero values are left out like those
+// of metrics a user has no points in. AddPoints, Transfer and actions on it fail with
+// ErrDerivedMetric, and ReplaceState drops it. fn gets only stored points, so derived metrics cannot
+// build on each other.
+//
+// Composite leaderboards may weight derived metrics; such boards are recomputed after a change to
+// any metric, since the inputs of fn are unknown. A derived metric may have a level curve
+// (WithDerivedLevels): its level and GetProgress are computed on read like its points, but no level
+// events are published for it and it cannot be monotonic. Per-metric and season leaderboards and
+// value policies are not supported for derived metrics. The catalog lists them as Computed.
+func WithDerivedMetric(metric core.Metric, fn func(core.UserState) int64) ServiceOption {
+    if metric == "" { panic("WithDerivedMetric: empty metric") }
+    if fn == nil { panic(fmt.Sprintf("WithDerivedMetric: nil function for %q", metric)) }
+    if _, _, seasonal := core.SplitSeasonMetric(metric); seasonal { panic(fmt.Sprintf("WithDerivedMetric: %q is a season ledger", metric)) }
+    return func(g *GamifyService){
+        if g.computed == nil { g.computed = map[core.Metric]func(core.UserState) int64{} }
+        g.computed[metric] = fn
+    }
+}
+
+// checkDerivedMetrics rejects options that would store or rank a derived metric and marks the
+// composite boards weighting one
+func (g *GamifyService) checkDerivedMetrics() {
+    for metric := range g.computed {
+        if len(g.boards[metric]) > 0 { panic(fmt.Sprintf("NewGamifyService: derived metric %q cannot have a leaderboard; weight it in a composite leaderboard instead", metric)) }
+        if g.monotonic[metric] { panic(fmt.Sprintf("NewGamifyService: derived metric %q cannot have a monotonic level", metric)) }
+        if _, ok := g.policies[metric]; ok { panic(fmt.Sprintf("NewGamifyService: derived metric %q cannot have a value policy", metric)) }
+        for _, sb := range g.seasons.boards {
+            if sb.metric == metric { panic(fmt.Sprintf("NewGamifyService: derived metric %q cannot have a season leaderboard", metric)) }
+        }
+    }
+    for i, c := range g.composites {
+        for metric := range c.cfg.Weights {
+            if _, ok := g.computed[metric]; ok { g.composites[i].anyMetric = true }
+        }
+    }
+}
+
+// DerivedMetric reports whether metric is computed on read; see WithDerivedMetric.
+func (g *GamifyService) DerivedMetric(metric core.Metric) bool {
+    _, ok := g.computed[metric]
+    return ok
+}
+
+// applyDerivedMetrics sets the derived metrics of state to their values computed from its stored points
+func (g *GamifyService) applyDerivedMetrics(state core.UserState) core.UserState {
+    if len(g.computed) == 0 { return state }
+    stored := state
+    stored.Points = maps.Clone(state.Points)
+    for metric := range g.computed { delete(stored.Points, metric) }
+    points := maps.Clone(stored.Points)
+    if points == nil { points = map[core.Metric]int64{} }
+    for metric, fn := range g.computed {
+        if v := fn(stored); v != 0 { points[metric] = v }
+    }
+    state.Points = points
+    return state
+}
+
+// view is state as reads and rules see it: derived metrics computed and levels of derived metrics applied
+func (g *GamifyService) view(state core.UserState) core.UserState {
+    return g.applyDerivedLevels(g.applyDerivedMetrics(state))
+}
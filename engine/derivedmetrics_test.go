@@ -0,0 +1,74 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func totalScore(s core.UserState) int64 { return s.Points[core.MetricXP] + 2*s.Points[coins] }
+
+func TestDerivedMetric(t *testing.T) {
+    ctx := context.Background()
+    for _, projected := range []bool{false, true} {
+        board := leaderboard.NewSkipList()
+        opts := []ServiceOption{WithDerivedMetric("total", totalScore), WithStrictCatalog(), WithMetric(MetricInfo{ID: coins}), WithMetric(MetricInfo{ID: core.MetricXP}),
+            WithCompositeLeaderboard("ranking", CompositeBoardConfig{Board: board, Weights: CompositeBoard{"total": 1, "wins": 5}})}
+        if projected { opts = append(opts, WithEventDrivenLeaderboards()) }
+        store := mem.New()
+        svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), opts...)
+
+        if st, _ := svc.GetState(ctx, "alice"); len(st.Points) != 0 { t.Fatalf("projected=%v: empty user has points %v", projected, st.Points) }
+        if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10); err != nil { t.Fatal(err) }
+        if _, err := svc.AddPoints(ctx, "alice", coins, 5); err != nil { t.Fatal(err) }
+        if st, _ := svc.GetState(ctx, "alice"); st.Points["total"] != 20 || st.Points[coins] != 5 { t.Fatalf("projected=%v: state points = %v", projected, st.Points) }
+        if v, err := svc.GetPoints(ctx, "alice", "total"); err != nil || v != 20 { t.Fatalf("projected=%v: GetPoints = %d, %v", projected, v, err) }
+        if stored, _ := store.GetState(ctx, "alice"); stored.Points["total"] != 0 { t.Fatalf("projected=%v: derived metric stored", projected) }
+        // coins is not weighted by the board, but total depends on it
+        if e, ok := board.Get("alice"); !ok || e.Score != 20 { t.Fatalf("projected=%v: board entry = %+v, %v", projected, e, ok) }
+
+        if _, err := svc.AddPoints(ctx, "alice", "total", 1); !errors.Is(err, ErrDerivedMetric) { t.Fatalf("projected=%v: AddPoints: %v", projected, err) }
+        if err := svc.Transfer(ctx, "alice", "bob", "total", 1); !errors.Is(err, ErrDerivedMetric) { t.Fatalf("projected=%v: Transfer: %v", projected, err) }
+        if _, err := svc.Apply(ctx, "alice", Action{Operations: []Operation{{Metric: "total", Delta: 1}}}); !errors.Is(err, ErrDerivedMetric) { t.Fatalf("projected=%v: Apply: %v", projected, err) }
+
+        // a snapshot read with GetState can be restored as is
+        snapshot, _ := svc.GetState(ctx, "alice")
+        snapshot.Points[coins] = 1
+        if err := svc.ReplaceState(ctx, "alice", snapshot); err != nil { t.Fatal(err) }
+        if stored, _ := store.GetState(ctx, "alice"); len(stored.Points) != 2 { t.Fatalf("projected=%v: replaced state stored %v", projected, stored.Points) }
+        if v, _ := svc.GetPoints(ctx, "alice", "total"); v != 12 { t.Fatalf("projected=%v: total after replace = %d, want 12", projected, v) }
+        if e, _ := board.Get("alice"); e.Score != 12 { t.Fatalf("projected=%v: board after replace = %d, want 12", projected, e.Score) }
+
+        if info, ok := svc.MetricInfo("total"); !ok || !info.Computed || !svc.DerivedMetric("total") { t.Fatalf("projected=%v: catalog entry %+v, %v", projected, info, ok) }
+        if info, _ := svc.MetricInfo(coins); info.Computed { t.Fatalf("projected=%v: stored metric flagged as computed", projected) }
+    }
+}
+
+func TestDerivedMetricRejectsBoards(t *testing.T) {
+    defer func() {
+        if recover() == nil { t.Fatal("want a panic for a leaderboard on a derived metric") }
+    }()
+    NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithDerivedMetric("total", totalScore), WithLeaderboard("total", BoardConfig{Board: leaderboard.NewSkipList()}))
+}
+
+func TestDerivedMetricProgress(t *testing.T) {
+    ctx := context.Background()
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithDerivedMetric("total", totalScore), WithDerivedLevels("total", MustLinearCurve(100)))
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 50); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", coins, 50); err != nil { t.Fatal(err) }
+
+    p, ok, err := svc.GetProgress(ctx, "alice", "total")
+    if err != nil || !ok { t.Fatalf("expected progress, got ok=%v err=%v", ok, err) }
+    want := Progress{Metric: "total", Points: 150, Level: 2, LevelStart: 100, NextLevelAt: 200, ToNext: 50, Fraction: 0.5}
+    if p != want { t.Fatalf("got %+v, want %+v", p, want) }
+    st, _ := svc.GetState(ctx, "alice")
+    if st.Levels["total"] != 2 || svc.ProgressFor(st)["total"] != want { t.Fatalf("state levels %v, progress %+v", st.Levels, svc.ProgressFor(st)) }
+    if stored, _ := store.GetState(ctx, "alice"); stored.Levels["total"] != 0 { t.Fatalf("derived level stored: %v", stored.Levels) }
+}
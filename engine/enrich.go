@@ -0,0 +1,31 @@
+package engine
+
+import (
+    "context"
+    "maps"
+
+    "gamifykit/core"
+)
+
+// EventEnricher sets values carried by the request context, such as a request ID, tenant or client
+// version, on an event about to be published.
+type EventEnricher func(ctx context.Context, e *core.Event)
+
+// WithEventEnricher runs fn on every event the service publishes, of every type and including
+// events passed to Publish. Enrichers run in registration order on the publishing goroutine, with
+// the context of the call that caused the event, before the bus's interceptors, sampling, Seq
+// stamping and subscribers, so all of them see the enriched event. fn gets its own copy of the
+// Metadata map and may write to it freely; Metadata is nil for events created without any. It runs
+// on the write path, so it should only read the context and set fields.
+func WithEventEnricher(fn EventEnricher) ServiceOption {
+    return func(g *GamifyService){ if fn != nil { g.enrichers = append(g.enrichers, fn) } }
+}
+
+// publish hands ev to the bus after running the enrichers
+func (g *GamifyService) publish(ctx context.Context, ev core.Event) {
+    if len(g.enrichers) > 0 {
+        ev.Metadata = maps.Clone(ev.Metadata)
+        for _, fn := range g.enrichers { fn(ctx, &ev) }
+    }
+    g.bus.Publish(ctx, ev)
+}
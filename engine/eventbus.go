@@ -1,8 +1,13 @@
 package engine
 
 import (
+    "container/list"
     "context"
+    "fmt"
+    "hash/fnv"
+    "log/slog"
     "sync"
+    "sync/atomic"
     "time"
 
     "gamifykit/core"
@@ -15,22 +20,86 @@ const (
     DispatchAsync
 )
 
+// Ordering selects how a subscriber's events are delivered under DispatchAsync.
+type Ordering int
+
+const (
+    // OrderingFIFO delivers each user's events to the subscriber one at a time, in Seq order, on
+    // the user's serial queue. A slow FIFO subscriber delays the user's later events for every
+    // FIFO subscriber. This is the default.
+    OrderingFIFO Ordering = iota
+    // OrderingBestEffort hands each event to a shared worker pool, so the subscriber may see a
+    // user's events concurrently and out of order, but never holds up the serial queues. Events
+    // are dropped when the pool is saturated.
+    OrderingBestEffort
+)
+
+// UnnamedSubscriber labels handlers registered with Subscribe rather than SubscribeNamed.
+const UnnamedSubscriber = "unnamed"
+
 type subscription struct {
-    id   int64
-    typ  core.EventType
-    fn   func(context.Context, core.Event)
+    id       int64
+    name     string
+    typ      core.EventType
+    fn       func(context.Context, core.Event)
+    ordering Ordering
+}
+
+// poolTask is one best-effort delivery
+type poolTask struct {
+    ev  core.Event
+    sub subscription
 }
 
+// DispatchObserver receives how long each subscriber took to handle an event.
+type DispatchObserver func(subscriber string, typ core.EventType, took time.Duration)
+
 // EventBus provides thread-safe pub/sub with sync and async dispatch.
+//
+// Publish stamps each event with the user's next Seq and a Time no earlier than the user's previous
+// event. Under DispatchAsync a user's events are delivered in Seq order: they always go to the same
+// serial queue, where OrderingFIFO subscribers (the default) run. OrderingBestEffort subscribers
+// are instead served by a shared worker pool and carry no ordering guarantee. Under DispatchSync
+// handlers run on the publishing goroutine, so events published concurrently for the same user may
+// reach them out of Seq order. Events of different users carry no ordering guarantee. Sequences
+// are per process and start from 1 again after a restart or once the user's clock was evicted (see
+// SetMaxSeqUsers); a gap means events were dropped (async queue full) or coalesced (see SetSampling).
 type EventBus struct {
     mode         DispatchMode
     mu           sync.RWMutex
     subs         map[core.EventType]map[int64]subscription
+    all          map[int64]subscription
     nextID       int64
-    asyncQueue   chan core.Event
+    queues       []chan core.Event
+    pool         chan poolTask
     asyncWorkers int
     ctx          context.Context
     cancel       context.CancelFunc
+    observer     DispatchObserver
+    slowAfter    time.Duration
+    maxSkew      time.Duration
+    maxAge       time.Duration
+    onSkew       ClockSkewObserver
+    samplers     map[core.EventType]*sampler
+    interceptors []Interceptor
+    closed       atomic.Bool
+    pending      atomic.Int64 // events queued or being handled by async workers
+
+    // seqMu orders stamping and enqueueing, so a user's events queue in Seq order
+    seqMu       sync.Mutex
+    seqs        map[core.UserID]*list.Element // of *userClock
+    seqLRU      *list.List                    // most recently published first
+    maxSeqUsers int
+}
+
+// DefaultMaxSeqUsers is how many users' sequences the bus keeps unless changed; see SetMaxSeqUsers.
+const DefaultMaxSeqUsers = 100000
+
+// userClock is the last Seq and Time handed out for a user
+type userClock struct {
+    user core.UserID
+    seq  uint64
+    last time.Time
 }
 
 func NewEventBus(mode DispatchMode) *EventBus {
@@ -38,10 +107,14 @@ func NewEventBus(mode DispatchMode) *EventBus {
     eb := &EventBus{
         mode:         mode,
         subs:         make(map[core.EventType]map[int64]subscription),
-        asyncQueue:   make(chan core.Event, 2048),
+        all:          make(map[int64]subscription),
         asyncWorkers: 4,
         ctx:          ctx,
         cancel:       cancel,
+        seqs:         make(map[core.UserID]*list.Element),
+        seqLRU:       list.New(),
+        maxSeqUsers:  DefaultMaxSeqUsers,
+        maxSkew:      DefaultMaxClockSkew,
     }
     if mode == DispatchAsync {
         eb.startWorkers()
@@ -49,13 +122,33 @@ func NewEventBus(mode DispatchMode) *EventBus {
     return eb
 }
 
+// startWorkers starts one worker per queue, 2048 events buffered in total, and as many pool
+// workers for best-effort subscribers with as much buffer again
 func (e *EventBus) startWorkers() {
+    e.pool = make(chan poolTask, 2048)
     for i := 0; i < e.asyncWorkers; i++ {
         go func() {
             for {
                 select {
-                case ev := <-e.asyncQueue:
+                case t := <-e.pool:
+                    e.run(context.Background(), t.sub, t.ev)
+                    e.pending.Add(-1)
+                case <-e.ctx.Done():
+                    return
+                }
+            }
+        }()
+    }
+    e.queues = make([]chan core.Event, e.asyncWorkers)
+    for i := range e.queues {
+        queue := make(chan core.Event, 2048/e.asyncWorkers)
+        e.queues[i] = queue
+        go func() {
+            for {
+                select {
+                case ev := <-queue:
                     e.dispatchSync(context.Background(), ev)
+                    e.pending.Add(-1)
                 case <-e.ctx.Done():
                     return
                 }
@@ -64,15 +157,152 @@ func (e *EventBus) startWorkers() {
     }
 }
 
-// Close stops async workers.
+// queueFor picks the worker queue for a user, always the same one so the user's events stay in order
+func (e *EventBus) queueFor(user core.UserID) chan core.Event {
+    h := fnv.New32a()
+    _, _ = h.Write([]byte(user))
+    return e.queues[h.Sum32()%uint32(len(e.queues))]
+}
+
+// stamp assigns the user's next Seq, an ID to events created without one and keeps Time from
+// going backwards. Callers hold seqMu.
+func (e *EventBus) stamp(ev core.Event) core.Event {
+    var c *userClock
+    if el, ok := e.seqs[ev.UserID]; ok {
+        e.seqLRU.MoveToFront(el)
+        c = el.Value.(*userClock)
+    } else {
+        c = &userClock{user: ev.UserID}
+        e.seqs[ev.UserID] = e.seqLRU.PushFront(c)
+        e.evictClocks()
+    }
+    c.seq++
+    ev.Seq = c.seq
+    if ev.ID == "" { ev.ID = core.NewEventID() }
+    if ev.Time.IsZero() { ev.Time = core.CurrentTime().UTC() }
+    if ev.Time.Before(c.last) { ev.Time = c.last }
+    c.last = ev.Time
+    return ev
+}
+
+// evictClocks drops the clocks of the least recently published users beyond maxSeqUsers. Callers
+// hold seqMu.
+func (e *EventBus) evictClocks() {
+    for e.seqLRU.Len() > e.maxSeqUsers {
+        oldest := e.seqLRU.Back()
+        e.seqLRU.Remove(oldest)
+        delete(e.seqs, oldest.Value.(*userClock).user)
+    }
+}
+
+// SetMaxSeqUsers caps how many users' Seq and Time the bus remembers, DefaultMaxSeqUsers unless
+// changed, so the bus does not grow with every user ever seen. The user published to least recently
+// is forgotten first; their next event starts again from Seq 1, as after a restart. Zero or less
+// restores the default.
+func (e *EventBus) SetMaxSeqUsers(n int) {
+    if n <= 0 { n = DefaultMaxSeqUsers }
+    e.seqMu.Lock(); defer e.seqMu.Unlock()
+    e.maxSeqUsers = n
+    e.evictClocks()
+}
+
+// Close is Shutdown with a brief grace period for queued events.
 func (e *EventBus) Close() {
-    e.cancel()
-    // allow workers to drain briefly
-    time.Sleep(10 * time.Millisecond)
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    _ = e.Shutdown(ctx)
+}
+
+// Shutdown stops accepting events, delivers the events held for coalescing, waits until the async
+// queues and the best-effort pool are drained and then stops the workers. Events published once
+// Shutdown has started are dropped. If ctx ends first the workers are stopped anyway, and the
+// error reports how many events were left undelivered.
+func (e *EventBus) Shutdown(ctx context.Context) error {
+    e.closed.Store(true)
+    e.flushAllPending()
+    defer e.cancel()
+    return e.drain(ctx)
+}
+
+// Flush delivers the events held for coalescing right away and, in async mode, waits until every
+// queued event has been handled or ctx ends. Unlike Shutdown it leaves the bus running, so tests
+// can publish, flush and assert on what subscribers saw.
+func (e *EventBus) Flush(ctx context.Context) error {
+    e.flushAllPending()
+    return e.drain(ctx)
+}
+
+// drain waits until the async queues and the best-effort pool are empty
+func (e *EventBus) drain(ctx context.Context) error {
+    if e.mode != DispatchAsync { return nil }
+    ticker := time.NewTicker(time.Millisecond)
+    defer ticker.Stop()
+    for e.pending.Load() > 0 {
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("event bus has %d events undelivered: %w", e.pending.Load(), ctx.Err())
+        case <-ticker.C:
+        }
+    }
+    return nil
+}
+
+// OnDispatch registers fn to receive every handler's dispatch duration, e.g. to export histograms.
+func (e *EventBus) OnDispatch(fn DispatchObserver) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.observer = fn
+}
+
+// SetSlowThreshold logs a warning (log/slog) whenever a subscriber takes longer than d to handle
+// an event, naming the subscriber. Zero disables the warning.
+func (e *EventBus) SetSlowThreshold(d time.Duration) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.slowAfter = d
+}
+
+// DefaultMaxClockSkew is how far ahead of the server clock an event's Time may be before Publish
+// replaces it; see SetMaxClockSkew.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// ClockSkewObserver receives events whose Time Publish replaced, with how far it was off the server
+// clock (positive for times in the future).
+type ClockSkewObserver func(ev core.Event, skew time.Duration)
+
+// SetMaxClockSkew makes Publish replace the Time of events more than d after the server clock with
+// the server time, so a wrong or spoofed timestamp on a published event cannot land in a far-off
+// analytics bucket or, as a user's clock never goes backwards, drag all of the user's later events
+// into the future. Engine events are stamped with the server clock when created, so this only
+// catches events passed to Publish. DefaultMaxClockSkew applies unless changed; zero disables the
+// check. Past times are left alone unless SetMaxEventAge bounds them.
+func (e *EventBus) SetMaxClockSkew(d time.Duration) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.maxSkew = d
+}
+
+// SetMaxEventAge makes Publish replace the Time of events more than d before the server clock with
+// the server time, like SetMaxClockSkew does for future times. Zero, the default, allows any age,
+// since events may legitimately arrive late, e.g. relayed from an outbox after retries.
+func (e *EventBus) SetMaxEventAge(d time.Duration) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.maxAge = d
+}
+
+// OnClockSkew registers fn to receive every event whose Time was replaced, e.g. to log it.
+func (e *EventBus) OnClockSkew(fn ClockSkewObserver) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.onSkew = fn
 }
 
-// Subscribe registers a handler for an event type. Returns unsubscribe func.
-func (e *EventBus) Subscribe(typ core.EventType, handler func(context.Context, core.Event)) func() {
+// Subscribe registers an unnamed handler for an event type, optionally with an Ordering
+// (OrderingFIFO by default). Returns unsubscribe func.
+func (e *EventBus) Subscribe(typ core.EventType, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    return e.SubscribeNamed(UnnamedSubscriber, typ, handler, ordering...)
+}
+
+// SubscribeNamed registers a handler under a name (e.g. "webhooks") used to label its dispatch
+// timings and slow-subscriber warnings. Returns unsubscribe func.
+func (e *EventBus) SubscribeNamed(name string, typ core.EventType, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    if name == "" { name = UnnamedSubscriber }
     e.mu.Lock()
     defer e.mu.Unlock()
     e.nextID++
@@ -80,7 +310,7 @@ func (e *EventBus) Subscribe(typ core.EventType, handler func(context.Context, c
     if e.subs[typ] == nil {
         e.subs[typ] = make(map[int64]subscription)
     }
-    e.subs[typ][id] = subscription{id: id, typ: typ, fn: handler}
+    e.subs[typ][id] = subscription{id: id, name: name, typ: typ, fn: handler, ordering: orderingOf(ordering)}
     return func() {
         e.mu.Lock()
         defer e.mu.Unlock()
@@ -90,31 +320,164 @@ func (e *EventBus) Subscribe(typ core.EventType, handler func(context.Context, c
     }
 }
 
-// Publish sends an event to subscribers.
+// SubscribeAll registers an unnamed handler for every event type, including types added later.
+// Returns unsubscribe func.
+func (e *EventBus) SubscribeAll(handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    return e.SubscribeAllNamed(UnnamedSubscriber, handler, ordering...)
+}
+
+// SubscribeAllNamed is SubscribeAll under a name used in dispatch timings; see SubscribeNamed.
+func (e *EventBus) SubscribeAllNamed(name string, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    if name == "" { name = UnnamedSubscriber }
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.nextID++
+    id := e.nextID
+    e.all[id] = subscription{id: id, name: name, fn: handler, ordering: orderingOf(ordering)}
+    return func() {
+        e.mu.Lock()
+        defer e.mu.Unlock()
+        delete(e.all, id)
+    }
+}
+
+// orderingOf returns the last ordering given, OrderingFIFO if none
+func orderingOf(ordering []Ordering) Ordering {
+    if len(ordering) == 0 { return OrderingFIFO }
+    return ordering[len(ordering)-1]
+}
+
+// OnPointsAdded registers a handler for points_added events. Returns unsubscribe func.
+func (e *EventBus) OnPointsAdded(handler func(context.Context, core.PointsAddedEvent)) func() {
+    return e.Subscribe(core.EventPointsAdded, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsPointsAdded(); ok { handler(ctx, typed) }
+    })
+}
+
+// OnBadgeAwarded registers a handler for badge_awarded events. Returns unsubscribe func.
+func (e *EventBus) OnBadgeAwarded(handler func(context.Context, core.BadgeAwardedEvent)) func() {
+    return e.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsBadgeAwarded(); ok { handler(ctx, typed) }
+    })
+}
+
+// OnBadgeRevoked registers a handler for badge_revoked events. Returns unsubscribe func.
+func (e *EventBus) OnBadgeRevoked(handler func(context.Context, core.BadgeRevokedEvent)) func() {
+    return e.Subscribe(core.EventBadgeRevoked, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsBadgeRevoked(); ok { handler(ctx, typed) }
+    })
+}
+
+// OnLevelUp registers a handler for level_up events. Returns unsubscribe func.
+func (e *EventBus) OnLevelUp(handler func(context.Context, core.LevelUpEvent)) func() {
+    return e.Subscribe(core.EventLevelUp, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsLevelUp(); ok { handler(ctx, typed) }
+    })
+}
+
+// OnLevelDown registers a handler for level_down events. Returns unsubscribe func.
+func (e *EventBus) OnLevelDown(handler func(context.Context, core.LevelDownEvent)) func() {
+    return e.Subscribe(core.EventLevelDown, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsLevelDown(); ok { handler(ctx, typed) }
+    })
+}
+
+// OnPointsTransferred registers a handler for points_transferred events; it runs once per side of
+// a transfer. Returns unsubscribe func.
+func (e *EventBus) OnPointsTransferred(handler func(context.Context, core.PointsTransferredEvent)) func() {
+    return e.Subscribe(core.EventPointsTransferred, func(ctx context.Context, ev core.Event) {
+        if typed, ok := ev.AsPointsTransferred(); ok { handler(ctx, typed) }
+    })
+}
+
+// Publish sends an event to subscribers, after the interceptors registered with Use and subject to
+// the sampling policy of its type (see SetSampling). An event timed too far ahead of the server
+// clock, or too far behind it when SetMaxEventAge is set, gets the server time first (see SetMaxClockSkew).
 func (e *EventBus) Publish(ctx context.Context, ev core.Event) {
+    if e.closed.Load() { return }
+    e.mu.RLock()
+    sampled := len(e.samplers) > 0
+    interceptors := e.interceptors
+    maxSkew, maxAge, onSkew := e.maxSkew, e.maxAge, e.onSkew
+    e.mu.RUnlock()
+    if (maxSkew > 0 || maxAge > 0) && !ev.Time.IsZero() {
+        now := core.CurrentTime().UTC()
+        if skew := ev.Time.Sub(now); (maxSkew > 0 && skew > maxSkew) || (maxAge > 0 && skew < -maxAge) {
+            if onSkew != nil { onSkew(ev, skew) }
+            ev.Time = now
+        }
+    }
+    for _, in := range interceptors {
+        var ok bool
+        if ev, ok = in(ctx, ev); !ok { return }
+    }
+    if sampled {
+        var ok bool
+        if ev, ok = e.sample(ctx, ev); !ok { return }
+    }
+    e.deliver(ctx, ev)
+}
+
+func (e *EventBus) deliver(ctx context.Context, ev core.Event) {
+    e.seqMu.Lock()
+    ev = e.stamp(ev)
     if e.mode == DispatchAsync {
+        e.pending.Add(1)
         select {
-        case e.asyncQueue <- ev:
+        case e.queueFor(ev.UserID) <- ev:
         default:
             // Drop if queue full to preserve latency; alternative is blocking
+            e.pending.Add(-1)
         }
+        e.seqMu.Unlock()
         return
     }
+    e.seqMu.Unlock()
     e.dispatchSync(ctx, ev)
 }
 
+// dispatchSync runs the event's handlers. On an async worker, best-effort handlers are handed to
+// the pool instead (dropped if it is full); everything else runs inline.
 func (e *EventBus) dispatchSync(ctx context.Context, ev core.Event) {
     e.mu.RLock()
     subs := e.subs[ev.Type]
     // copy to avoid holding lock during callbacks
-    handlers := make([]func(context.Context, core.Event), 0, len(subs))
+    handlers := make([]subscription, 0, len(subs)+len(e.all))
     for _, s := range subs {
-        handlers = append(handlers, s.fn)
+        handlers = append(handlers, s)
+    }
+    for _, s := range e.all {
+        handlers = append(handlers, s)
     }
     e.mu.RUnlock()
     for _, h := range handlers {
-        h(ctx, ev)
+        if h.ordering == OrderingBestEffort && e.pool != nil {
+            e.pending.Add(1)
+            select {
+            case e.pool <- poolTask{ev: ev, sub: h}:
+            default:
+                e.pending.Add(-1)
+            }
+            continue
+        }
+        e.run(ctx, h, ev)
     }
 }
 
-
+// run calls one handler, timing it when an observer or slow threshold is set
+func (e *EventBus) run(ctx context.Context, h subscription, ev core.Event) {
+    e.mu.RLock()
+    observer, slowAfter := e.observer, e.slowAfter
+    e.mu.RUnlock()
+    if observer == nil && slowAfter <= 0 {
+        h.fn(ctx, ev)
+        return
+    }
+    start := time.Now()
+    h.fn(ctx, ev)
+    took := time.Since(start)
+    if observer != nil { observer(h.name, ev.Type, took) }
+    if slowAfter > 0 && took > slowAfter {
+        slog.Warn("slow event subscriber", "subscriber", h.name, "event", string(ev.Type), "took", took, "threshold", slowAfter)
+    }
+}
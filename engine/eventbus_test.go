@@ -2,6 +2,8 @@ package engine
 
 import (
     "context"
+    "sync"
+    "sync/atomic"
     "testing"
     "time"
 
@@ -25,4 +27,276 @@ func TestEventBusAsync(t *testing.T) {
     select { case <-ch: case <-time.After(time.Second): t.Fatal("timeout") }
 }
 
+func TestEventBusPerUserOrder(t *testing.T) {
+    bus := NewEventBus(DispatchAsync)
+    defer bus.Close()
+    users := []core.UserID{"a", "b", "c", "d", "e", "f"}
+    const perUser = 200
+    var mu sync.Mutex
+    got := map[core.UserID][]core.Event{}
+    done := make(chan struct{})
+    total := 0
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){
+        mu.Lock(); defer mu.Unlock()
+        got[e.UserID] = append(got[e.UserID], e)
+        if total++; total == len(users)*perUser { close(done) }
+    })
+    var wg sync.WaitGroup
+    for _, u := range users {
+        wg.Add(1)
+        go func(u core.UserID) {
+            defer wg.Done()
+            for i := 1; i <= perUser; i++ {
+                ev := core.NewPointsAdded(u, core.MetricXP, 1, int64(i))
+                if i%2 == 0 { ev.Time = ev.Time.Add(-time.Hour) } // clock went backwards
+                bus.Publish(context.Background(), ev)
+            }
+        }(u)
+    }
+    wg.Wait()
+    select { case <-done: case <-time.After(2 * time.Second): t.Fatalf("timeout, got %d events", total) }
+    for _, u := range users {
+        evs := got[u]
+        for i, e := range evs {
+            if e.Seq != uint64(i+1) || e.Total != int64(i+1) { t.Fatalf("user %s: event %d has seq %d total %d", u, i, e.Seq, e.Total) }
+            if i > 0 && e.Time.Before(evs[i-1].Time) { t.Fatalf("user %s: time went backwards at seq %d", u, e.Seq) }
+        }
+    }
+}
+
+func TestEventBusSeqSync(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var seqs []uint64
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ seqs = append(seqs, e.Seq) })
+    bus.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event){ seqs = append(seqs, e.Seq) })
+    bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, 1))
+    bus.Publish(context.Background(), core.NewBadgeAwarded("u", "first"))
+    bus.Publish(context.Background(), core.NewPointsAdded("v", core.MetricXP, 1, 1))
+    bus.Publish(context.Background(), core.Event{Type: core.EventPointsAdded, UserID: "u"})
+    if len(seqs) != 4 || seqs[0] != 1 || seqs[1] != 2 || seqs[2] != 1 || seqs[3] != 3 { t.Fatalf("unexpected seqs %v", seqs) }
+}
+
+
+
+func TestEventBusDispatchTimings(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var mu sync.Mutex
+    timings := map[string]time.Duration{}
+    bus.OnDispatch(func(sub string, typ core.EventType, took time.Duration) {
+        mu.Lock(); defer mu.Unlock()
+        if typ != core.EventPointsAdded { t.Errorf("unexpected event type %s", typ) }
+        timings[sub] += took
+    })
+    bus.SetSlowThreshold(5 * time.Millisecond)
+    bus.SubscribeNamed("webhooks", core.EventPointsAdded, func(ctx context.Context, e core.Event){ time.Sleep(10 * time.Millisecond) })
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){})
+    bus.Publish(context.Background(), core.NewPointsAdded(core.UserID("u"), core.MetricXP, 1, 1))
+
+    if timings["webhooks"] < 10*time.Millisecond { t.Fatalf("webhooks timing not recorded: %v", timings) }
+    if _, ok := timings[UnnamedSubscriber]; !ok { t.Fatalf("unnamed subscriber not recorded: %v", timings) }
+}
+
+func TestEventBusCoalescing(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    bus.SetSampling(core.EventPointsAdded, SamplingPolicy{Coalesce: time.Hour})
+    var mu sync.Mutex
+    var got []core.Event
+    record := func(ctx context.Context, e core.Event){ mu.Lock(); defer mu.Unlock(); got = append(got, e) }
+    bus.Subscribe(core.EventPointsAdded, record)
+    bus.Subscribe(core.EventLevelUp, record)
+    ctx := context.Background()
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 5, 5))
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 7, 12))
+    bus.Publish(ctx, core.NewPointsAdded("v", core.MetricXP, 1, 1))
+    if len(got) != 0 { t.Fatalf("points events should be held, got %d", len(got)) }
+
+    // a milestone flushes the user's held events first, then is delivered in full
+    bus.Publish(ctx, core.NewLevelUp("u", core.MetricXP, 2))
+    if len(got) != 2 { t.Fatalf("want coalesced points + level up, got %d", len(got)) }
+    if got[0].Type != core.EventPointsAdded || got[0].Delta != 12 || got[0].Total != 12 || got[0].Metadata["count"] != 2 {
+        t.Fatalf("unexpected coalesced event %+v", got[0])
+    }
+    if got[1].Type != core.EventLevelUp { t.Fatalf("want level up second, got %s", got[1].Type) }
+
+    bus.Close()
+    if len(got) != 3 || got[2].UserID != "v" { t.Fatalf("close should flush held events, got %+v", got) }
+}
+
+func TestEventBusCoalescingInterval(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    bus.SetSampling(core.EventPointsAdded, SamplingPolicy{Coalesce: 20 * time.Millisecond})
+    ch := make(chan core.Event, 4)
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ ch <- e })
+    for i := int64(1); i <= 3; i++ { bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, i)) }
+    select {
+    case e := <-ch:
+        if e.Delta != 3 || e.Total != 3 || e.Metadata["count"] != 3 { t.Fatalf("unexpected event %+v", e) }
+    case <-time.After(time.Second):
+        t.Fatal("timeout")
+    }
+}
+
+func TestEventBusRateSampling(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    bus.SetSampling(core.EventPointsAdded, SamplingPolicy{Rate: 0.25})
+    var got []core.Event
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ got = append(got, e) })
+    for i := 0; i < 100; i++ { bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, 1)) }
+    if len(got) != 25 { t.Fatalf("want 25 sampled events got %d", len(got)) }
+    if got[0].Metadata["sample_rate"] != 0.25 { t.Fatalf("missing sample rate: %+v", got[0].Metadata) }
+
+    bus.SetSampling(core.EventPointsAdded, SamplingPolicy{})
+    bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, 1))
+    if len(got) != 26 { t.Fatal("zero policy should disable sampling") }
+}
+
+func TestEventBusMilestonesNotSampled(t *testing.T) {
+    defer func() { if recover() == nil { t.Fatal("sampling level_up should panic") } }()
+    NewEventBus(DispatchSync).SetSampling(core.EventLevelUp, SamplingPolicy{Rate: 0.5})
+}
+
+func TestEventBusSubscribeAll(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var got []core.EventType
+    unsub := bus.SubscribeAll(func(ctx context.Context, e core.Event){ got = append(got, e.Type) })
+    ctx := context.Background()
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 1, 1))
+    bus.Publish(ctx, core.NewBadgeRevoked("u", "b"))
+    bus.Publish(ctx, core.Event{Type: "custom", UserID: "u"})
+    unsub()
+    bus.Publish(ctx, core.NewLevelUp("u", core.MetricXP, 2))
+    if len(got) != 3 || got[0] != core.EventPointsAdded || got[1] != core.EventBadgeRevoked || got[2] != "custom" {
+        t.Fatalf("catch-all got %v", got)
+    }
+}
+
+func TestEventBusTypedHandlers(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    ctx := context.Background()
+    var level core.LevelUpEvent
+    var transfer core.PointsTransferredEvent
+    levels := 0
+    bus.OnLevelUp(func(ctx context.Context, e core.LevelUpEvent){ level = e; levels++ })
+    bus.OnPointsTransferred(func(ctx context.Context, e core.PointsTransferredEvent){ transfer = e })
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 1, 1))
+    bus.Publish(ctx, core.NewLevelUp("u", core.MetricXP, 3))
+    bus.Publish(ctx, core.NewPointsTransferred("bob", "alice", "bob", core.MetricPoints, 5, 5))
+    if levels != 1 || level.UserID != "u" || level.Metric != core.MetricXP || level.Level != 3 || level.Seq != 2 {
+        t.Fatalf("level up handler got %+v (%d calls)", level, levels)
+    }
+    if transfer.From != "alice" || transfer.To != "bob" || transfer.Delta != 5 {
+        t.Fatalf("transfer handler got %+v", transfer)
+    }
+}
+
+func TestEventBusInterceptors(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var order []string
+    bus.Use(
+        func(ctx context.Context, e core.Event) (core.Event, bool) { order = append(order, "first"); return e, true },
+        DropNoOpPoints,
+        EnrichMetadata(func(ctx context.Context, e core.Event) map[string]any {
+            order = append(order, "enrich")
+            if e.Type == core.EventBadgeAwarded { return map[string]any{"title": "Starter"} }
+            return nil
+        }),
+    )
+    var got []core.Event
+    bus.SubscribeAll(func(ctx context.Context, e core.Event){ got = append(got, e) })
+    var badgesOnly []core.Event
+    bus.SubscribeAll(Intercept(func(ctx context.Context, e core.Event){ badgesOnly = append(badgesOnly, e) }, OnlyTypes(core.EventBadgeAwarded)))
+
+    ctx := context.Background()
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 0, 10))
+    if len(got) != 0 || len(order) != 1 { t.Fatalf("a dropped event must stop the chain and reach no one, got %v %v", got, order) }
+    bus.Publish(ctx, core.NewPointsAdded("u", core.MetricXP, 5, 15))
+    bus.Publish(ctx, core.NewBadgeAwarded("u", "starter"))
+    if len(got) != 2 || got[1].Metadata["title"] != "Starter" || got[0].Metadata != nil { t.Fatalf("unexpected delivered events %+v", got) }
+    if got[0].Seq != 1 { t.Fatalf("dropped events take no sequence number, got seq %d", got[0].Seq) }
+    if len(badgesOnly) != 1 || badgesOnly[0].Type != core.EventBadgeAwarded { t.Fatalf("per-subscriber filter got %+v", badgesOnly) }
+}
+
+func TestEventBusOrderingModes(t *testing.T) {
+    bus := NewEventBus(DispatchAsync)
+    defer bus.Close()
+    const n = 50
+    var mu sync.Mutex
+    var fifo []uint64
+    fifoDone, release := make(chan struct{}), make(chan struct{})
+    var inflight, delivered atomic.Int64
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){
+        mu.Lock(); defer mu.Unlock()
+        if fifo = append(fifo, e.Seq); len(fifo) == n { close(fifoDone) }
+    }, OrderingFIFO)
+    bus.SubscribeNamed("analytics", core.EventPointsAdded, func(ctx context.Context, e core.Event){
+        inflight.Add(1)
+        <-release
+        delivered.Add(1)
+    }, OrderingBestEffort)
+
+    for i := 1; i <= n; i++ { bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, int64(i))) }
+
+    // blocked best-effort handlers do not hold up the user's serial queue
+    select { case <-fifoDone: case <-time.After(time.Second): t.Fatal("FIFO subscriber stalled behind a best-effort one") }
+    for i, seq := range fifo {
+        if seq != uint64(i+1) { t.Fatalf("FIFO subscriber saw Seq %d at position %d", seq, i) }
+    }
+    // and one user's events reach a best-effort subscriber concurrently
+    deadline := time.Now().Add(time.Second)
+    for inflight.Load() < 2 {
+        if time.Now().After(deadline) { t.Fatalf("want concurrent best-effort deliveries, got %d in flight", inflight.Load()) }
+        time.Sleep(time.Millisecond)
+    }
+    close(release)
+    for delivered.Load() < n {
+        if time.Now().After(deadline.Add(time.Second)) { t.Fatalf("best-effort subscriber got %d of %d events", delivered.Load(), n) }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestEventBusClampsSkewedTimes(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var got []core.Event
+    var skews []time.Duration
+    bus.Subscribe(core.EventPointsAdded, func(_ context.Context, e core.Event){ got = append(got, e) })
+    bus.OnClockSkew(func(_ core.Event, skew time.Duration){ skews = append(skews, skew) })
+
+    future := core.NewPointsAdded("u", core.MetricXP, 1, 1)
+    future.Time = time.Now().Add(30 * 24 * time.Hour)
+    past := core.NewPointsAdded("late", core.MetricXP, 1, 2)
+    past.Time = time.Now().Add(-time.Hour)
+    slight := core.NewPointsAdded("u", core.MetricXP, 1, 3)
+    slight.Time = time.Now().Add(time.Second)
+    for _, e := range []core.Event{future, past, slight} { bus.Publish(context.Background(), e) }
 
+    if len(skews) != 1 || skews[0] < 29*24*time.Hour { t.Fatalf("skews = %v", skews) }
+    if d := time.Since(got[0].Time); d < 0 || d > time.Minute { t.Fatalf("future event kept %v", got[0].Time) }
+    // late events keep their time by default
+    if !got[1].Time.Equal(past.Time) { t.Fatalf("late event changed time to %v", got[1].Time) }
+    // the clamped event does not drag the user's later events into the future
+    if got[2].Time.After(time.Now().Add(2 * time.Second)) { t.Fatalf("later event at %v", got[2].Time) }
+
+    bus.SetMaxEventAge(30 * time.Minute)
+    past.UserID = "late2"
+    bus.Publish(context.Background(), past)
+    if len(skews) != 2 || skews[1] > -59*time.Minute { t.Fatalf("skews = %v", skews) }
+    if d := time.Since(got[3].Time); d < 0 || d > time.Minute { t.Fatalf("old event kept %v", got[3].Time) }
+
+    bus.SetMaxClockSkew(0)
+    bus.Publish(context.Background(), future)
+    if !got[4].Time.Equal(future.Time) { t.Fatalf("disabled check changed time to %v", got[4].Time) }
+}
+
+func TestEventBusMaxSeqUsers(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    bus.SetMaxSeqUsers(2)
+    seqs := map[core.UserID][]uint64{}
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ seqs[e.UserID] = append(seqs[e.UserID], e.Seq) })
+    for _, u := range []core.UserID{"a", "b", "a", "c", "a", "b"} {
+        bus.Publish(context.Background(), core.NewPointsAdded(u, core.MetricXP, 1, 1))
+    }
+    // c evicted b, the least recently published user, so b starts over; a was kept throughout
+    if got := seqs["a"]; len(got) != 3 || got[2] != 3 { t.Fatalf("a seqs %v, want 1 2 3", got) }
+    if got := seqs["b"]; len(got) != 2 || got[1] != 1 { t.Fatalf("b seqs %v, want 1 1", got) }
+    if len(bus.seqs) != 2 || bus.seqLRU.Len() != 2 { t.Fatalf("bus remembers %d users, want 2", len(bus.seqs)) }
+}
@@ -0,0 +1,81 @@
+package engine
+
+import (
+    "context"
+    "encoding/json"
+
+    "gamifykit/core"
+)
+
+// OversizeAction is what LimitEventSize does with an event over the limit.
+type OversizeAction int
+
+const (
+    // OversizeTruncate passes the event on with a smaller Metadata, which holds what grows with the
+    // user (badge details, patches, replaced states): nested values are removed and, if that is not
+    // enough, every entry, and "truncated": true and the original "size" in bytes are added.
+    // Events still over the limit are dropped.
+    OversizeTruncate OversizeAction = iota
+    // OversizeDrop keeps the event from the sink altogether.
+    OversizeDrop
+)
+
+// EventSizeLimit configures LimitEventSize.
+type EventSizeLimit struct {
+    // MaxBytes is the largest JSON encoding of an event the sink receives; zero or less disables
+    // the limit.
+    MaxBytes int
+    Action   OversizeAction
+    // OnOversize, if set, receives every event over the limit, before truncation, with its size
+    // and whether it was dropped, e.g. to log and count it.
+    OnOversize func(ev core.Event, size int, dropped bool)
+}
+
+// LimitEventSize returns an interceptor that measures each event's JSON encoding and truncates or
+// drops those larger than limit.MaxBytes, so a single pathological event, such as a state_replaced
+// event of a user with thousands of badges, cannot flood a sink. Apply it per sink with Intercept
+// (or gamify.WithRealtimeEventLimit for realtime), e.g. truncating for WebSocket clients while
+// dropping for a webhook, and leave the event log subscriber unlimited so every event is still
+// persisted. Measuring costs an encoding per event and sink.
+func LimitEventSize(limit EventSizeLimit) Interceptor {
+    return func(_ context.Context, ev core.Event) (core.Event, bool) {
+        if limit.MaxBytes <= 0 { return ev, true }
+        size := encodedSize(ev)
+        if size <= limit.MaxBytes { return ev, true }
+        if limit.Action == OversizeTruncate {
+            for _, keepScalars := range []bool{true, false} {
+                cut := ev
+                cut.Metadata = map[string]any{}
+                if keepScalars {
+                    for k, v := range ev.Metadata {
+                        if scalar(v) { cut.Metadata[k] = v }
+                    }
+                }
+                cut.Metadata["truncated"], cut.Metadata["size"] = true, size
+                if encodedSize(cut) <= limit.MaxBytes {
+                    if limit.OnOversize != nil { limit.OnOversize(ev, size, false) }
+                    return cut, true
+                }
+            }
+        }
+        if limit.OnOversize != nil { limit.OnOversize(ev, size, true) }
+        return ev, false
+    }
+}
+
+// scalar reports whether v is a string, number, bool or nil rather than a nested value
+func scalar(v any) bool {
+    switch v.(type) {
+    case nil, string, bool, int, int32, int64, uint, uint32, uint64, float32, float64, json.Number:
+        return true
+    }
+    return false
+}
+
+// encodedSize returns the length of ev's JSON encoding; events that cannot be encoded count as
+// unlimited, since no sink could send them either
+func encodedSize(ev core.Event) int {
+    b, err := json.Marshal(ev)
+    if err != nil { return int(^uint(0) >> 1) }
+    return len(b)
+}
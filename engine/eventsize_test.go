@@ -0,0 +1,36 @@
+package engine
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "gamifykit/core"
+)
+
+func TestLimitEventSize(t *testing.T) {
+    small := core.NewPointsAdded("alice", core.MetricXP, 5, 5)
+    big := core.NewStateReplaced("alice", core.UserState{})
+    big.Metadata = map[string]any{"request_id": "req-1", "badges": strings.Repeat("x", 10), "state": map[string]any{"badges": strings.Repeat("b", 2000)}}
+
+    var reports []bool
+    limit := EventSizeLimit{MaxBytes: 400, OnOversize: func(ev core.Event, size int, dropped bool) {
+        if ev.Metadata["state"] == nil || size <= 400 { t.Errorf("OnOversize got %+v of %d bytes, want the original event", ev, size) }
+        reports = append(reports, dropped)
+    }}
+    truncate := LimitEventSize(limit)
+    if ev, ok := truncate(context.Background(), small); !ok || ev.Metadata != nil { t.Fatalf("small event = %+v, %v; want it untouched", ev, ok) }
+    ev, ok := truncate(context.Background(), big)
+    if !ok || ev.Metadata["truncated"] != true || ev.Metadata["request_id"] != "req-1" || ev.Metadata["state"] != nil || ev.ID != big.ID {
+        t.Fatalf("truncated event = %+v, %v; want scalar metadata kept and the state removed", ev, ok)
+    }
+    if big.Metadata["state"] == nil { t.Fatal("truncation modified the original event's metadata") }
+
+    limit.Action = OversizeDrop
+    if _, ok := LimitEventSize(limit)(context.Background(), big); ok { t.Fatal("oversized event not dropped") }
+    limit.Action, limit.MaxBytes = OversizeTruncate, 20
+    if _, ok := LimitEventSize(limit)(context.Background(), big); ok { t.Fatal("event over the limit even when truncated not dropped") }
+    if len(reports) != 3 || reports[0] || !reports[1] || !reports[2] { t.Fatalf("reports = %v, want truncated, dropped, dropped", reports) }
+
+    if _, ok := LimitEventSize(EventSizeLimit{})(context.Background(), big); !ok { t.Fatal("zero limit dropped an event") }
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+
+    "gamifykit/core"
+)
+
+// Rounding decides how a scaled metric value is rounded to MetricInfo.Decimals for display.
+type Rounding string
+
+const (
+    // RoundNearest rounds half away from zero (the default).
+    RoundNearest Rounding = "nearest"
+    // RoundDown rounds toward negative infinity, e.g. so "1.0 km" is only shown once reached.
+    RoundDown Rounding = "down"
+    // RoundUp rounds toward positive infinity.
+    RoundUp Rounding = "up"
+)
+
+// MaxDecimals is the largest MetricInfo.Decimals accepted.
+const MaxDecimals = 9
+
+// FormattedValue is a stored metric value converted for display.
+type FormattedValue struct {
+    // Value is the scaled and rounded value, e.g. 12.5 for 12500 meters shown in km.
+    Value float64 `json:"value"`
+    // Unit is the display unit, if any.
+    Unit string `json:"unit,omitempty"`
+    // Text is Value with exactly Decimals digits after the point, followed by Unit.
+    Text string `json:"text"`
+}
+
+// Validate reports whether the name and display settings of the metric are usable.
+func (m MetricInfo) Validate() error {
+    if err := core.CheckMetricName(m.ID); err != nil { return err }
+    if m.Scale < 0 { return fmt.Errorf("metric %q: scale must not be negative", m.ID) }
+    if m.Decimals < 0 || m.Decimals > MaxDecimals { return fmt.Errorf("metric %q: decimals must be between 0 and %d", m.ID, MaxDecimals) }
+    switch m.Rounding {
+    case "", RoundNearest, RoundDown, RoundUp:
+        return nil
+    }
+    return fmt.Errorf("metric %q: unknown rounding %q", m.ID, m.Rounding)
+}
+
+// Format converts a stored value for display: it is divided by Scale, rounded to Decimals per
+// Rounding and labelled with DisplayUnit (Unit when DisplayUnit is empty). Stored values are
+// never changed; this is presentation only.
+func (m MetricInfo) Format(v int64) FormattedValue {
+    scale := m.Scale
+    if scale <= 0 { scale = 1 }
+    unit := m.DisplayUnit
+    if unit == "" { unit = m.Unit }
+    pow := math.Pow10(m.Decimals)
+    x := float64(v) * pow / float64(scale)
+    switch m.Rounding {
+    case RoundDown:
+        x = math.Floor(x)
+    case RoundUp:
+        x = math.Ceil(x)
+    default:
+        x = math.Round(x)
+    }
+    value := x / pow
+    text := strconv.FormatFloat(value, 'f', m.Decimals, 64)
+    if unit != "" { text += " " + unit }
+    return FormattedValue{Value: value, Unit: unit, Text: text}
+}
+
+// FormatPoints formats every point total of state with its catalog entry, e.g. for a response
+// next to the raw totals. Season ledgers use the entry of their base metric; metrics missing
+// from the catalog are formatted as plain counts.
+func (g *GamifyService) FormatPoints(state core.UserState) map[core.Metric]FormattedValue {
+    if len(state.Points) == 0 { return nil }
+    out := make(map[core.Metric]FormattedValue, len(state.Points))
+    for metric, total := range state.Points {
+        base, _, _ := core.SplitSeasonMetric(metric)
+        out[metric] = g.catalog.metrics[base].Format(total)
+    }
+    return out
+}
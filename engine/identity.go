@@ -0,0 +1,163 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+var (
+    // ErrIdentityUnsupported is returned by the identity methods on storages without IdentityMapper.
+    ErrIdentityUnsupported = errors.New("storage does not support identity mapping")
+    // ErrInvalidIdentity reports an external ID that is not "kind:value" with a kind configured by
+    // WithIdentityKinds, or a canonical user ID that looks like one.
+    ErrInvalidIdentity = errors.New("invalid identity")
+    // ErrUnknownIdentity is returned by ResolveUser for external IDs not linked to any user.
+    ErrUnknownIdentity = errors.New("unknown identity")
+    // ErrIdentityTaken is returned by LinkIdentity for external IDs already linked to another user.
+    ErrIdentityTaken = errors.New("identity is linked to another user")
+)
+
+// IdentityMapper is implemented by storages that can map external IDs, such as
+// "email:alice@example.com", to canonical user IDs. An external ID maps to at most one user; a user
+// may have any number of them.
+type IdentityMapper interface {
+    // LinkIdentity links identity to user unless it is already linked, and returns the user it is
+    // linked to afterwards, which is not user when another user holds it.
+    LinkIdentity(ctx context.Context, identity string, user core.UserID) (core.UserID, error)
+    // UnlinkIdentity removes the identity's link and reports whether there was one.
+    UnlinkIdentity(ctx context.Context, identity string) (bool, error)
+    // ResolveIdentity returns the user identity is linked to, if any.
+    ResolveIdentity(ctx context.Context, identity string) (core.UserID, bool, error)
+    // Identities returns the identities linked to user, sorted.
+    Identities(ctx context.Context, user core.UserID) ([]string, error)
+}
+
+// WithIdentityKinds lets the service accept external IDs of the given kinds, e.g. "email" or
+// "auth0", wherever ResolveUser is used: "email:alice@example.com" then names the user that
+// identity is linked to. IDs of other forms are canonical user IDs, as before. Kinds are lowercase
+// letters, digits, '-' and '_'.
+func WithIdentityKinds(kinds ...string) ServiceOption {
+    return func(g *GamifyService){
+        if g.idKinds == nil { g.idKinds = map[string]bool{} }
+        for _, k := range kinds {
+            if !validIdentityKind(k) { panic(fmt.Sprintf("WithIdentityKinds: invalid kind %q", k)) }
+            g.idKinds[k] = true
+        }
+    }
+}
+
+// IdentityKinds returns the external ID kinds configured by WithIdentityKinds, sorted.
+func (g *GamifyService) IdentityKinds() []string {
+    kinds := make([]string, 0, len(g.idKinds))
+    for k := range g.idKinds { kinds = append(kinds, k) }
+    sort.Strings(kinds)
+    return kinds
+}
+
+func validIdentityKind(kind string) bool {
+    if kind == "" { return false }
+    for _, r := range kind {
+        if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' { continue }
+        return false
+    }
+    return true
+}
+
+// identity normalizes id and reports whether it is an external ID of a configured kind
+func (g *GamifyService) identity(id string) (string, bool, error) {
+    s := strings.ToLower(strings.TrimSpace(id))
+    kind, value, ok := strings.Cut(s, ":")
+    if !ok || !g.idKinds[kind] { return s, false, nil }
+    if strings.TrimSpace(value) == "" { return "", false, fmt.Errorf("%w: %q has no value", ErrInvalidIdentity, id) }
+    return s, true, nil
+}
+
+func (g *GamifyService) identityMapper() (IdentityMapper, error) {
+    m, ok := g.storage.(IdentityMapper)
+    if !ok { return nil, ErrIdentityUnsupported }
+    return m, nil
+}
+
+// ResolveUser returns the canonical user ID for id: the user an external ID of a kind configured by
+// WithIdentityKinds is linked to, or id itself, normalized, otherwise. External IDs not linked to
+// anyone fail with ErrUnknownIdentity rather than naming a new user.
+func (g *GamifyService) ResolveUser(ctx context.Context, id string) (_ core.UserID, err error) {
+    ctx, span := tracing.Start(ctx, "engine.ResolveUser")
+    defer func(){ span.End(err) }()
+    identity, external, err := g.identity(id)
+    if err != nil { return "", err }
+    if !external { return core.NormalizeUserID(core.UserID(identity)) }
+    m, err := g.identityMapper()
+    if err != nil { return "", err }
+    user, ok, err := m.ResolveIdentity(ctx, identity)
+    if err != nil { return "", err }
+    if !ok { return "", fmt.Errorf("%w: %s", ErrUnknownIdentity, identity) }
+    span.SetUser(user)
+    return user, nil
+}
+
+// LinkIdentity links the external ID identity, of a kind configured by WithIdentityKinds, to the
+// canonical user. Linking an identity the user already has is a no-op; one linked to another user
+// fails with ErrIdentityTaken. The user need not exist yet.
+func (g *GamifyService) LinkIdentity(ctx context.Context, user core.UserID, identity string) (err error) {
+    ctx, span := tracing.Start(ctx, "engine.LinkIdentity")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    normalized, err := g.canonicalUser(user)
+    if err != nil { return err }
+    identity, err = g.externalIdentity(identity)
+    if err != nil { return err }
+    m, err := g.identityMapper()
+    if err != nil { return err }
+    holder, err := m.LinkIdentity(ctx, identity, normalized)
+    if err != nil { return err }
+    if holder != normalized { return fmt.Errorf("%w: %s", ErrIdentityTaken, identity) }
+    return nil
+}
+
+// UnlinkIdentity removes the external ID's link and reports whether it had one.
+func (g *GamifyService) UnlinkIdentity(ctx context.Context, identity string) (_ bool, err error) {
+    ctx, span := tracing.Start(ctx, "engine.UnlinkIdentity")
+    defer func(){ span.End(err) }()
+    identity, err = g.externalIdentity(identity)
+    if err != nil { return false, err }
+    m, err := g.identityMapper()
+    if err != nil { return false, err }
+    return m.UnlinkIdentity(ctx, identity)
+}
+
+// Identities returns the external IDs linked to the canonical user, sorted.
+func (g *GamifyService) Identities(ctx context.Context, user core.UserID) (_ []string, err error) {
+    ctx, span := tracing.Start(ctx, "engine.Identities")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    normalized, err := g.canonicalUser(user)
+    if err != nil { return nil, err }
+    m, err := g.identityMapper()
+    if err != nil { return nil, err }
+    return m.Identities(ctx, normalized)
+}
+
+// canonicalUser normalizes user, rejecting IDs that would be read as external IDs
+func (g *GamifyService) canonicalUser(user core.UserID) (core.UserID, error) {
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return "", err }
+    if _, external, _ := g.identity(string(normalized)); external {
+        return "", fmt.Errorf("%w: user id %s has the form of an external id", ErrInvalidIdentity, normalized)
+    }
+    return normalized, nil
+}
+
+// externalIdentity normalizes identity, which must be an external ID of a configured kind
+func (g *GamifyService) externalIdentity(identity string) (string, error) {
+    s, external, err := g.identity(identity)
+    if err != nil { return "", err }
+    if !external { return "", fmt.Errorf("%w: %q is not kind:value with a configured kind", ErrInvalidIdentity, identity) }
+    return s, nil
+}
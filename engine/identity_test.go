@@ -0,0 +1,47 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestIdentities(t *testing.T) {
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithIdentityKinds("email", "sso"))
+    ctx := context.Background()
+
+    if err := svc.LinkIdentity(ctx, "u-1", " Email:Alice@Example.com "); err != nil { t.Fatal(err) }
+    if err := svc.LinkIdentity(ctx, "U-1", "sso:abc"); err != nil { t.Fatal(err) }
+    if err := svc.LinkIdentity(ctx, "u-1", "email:alice@example.com"); err != nil { t.Fatalf("relinking to the same user = %v", err) }
+    if err := svc.LinkIdentity(ctx, "u-2", "email:alice@example.com"); !errors.Is(err, ErrIdentityTaken) { t.Fatalf("linking a taken identity = %v", err) }
+    for _, bad := range []string{"alice", "phone:123", "email:"} {
+        if err := svc.LinkIdentity(ctx, "u-2", bad); !errors.Is(err, ErrInvalidIdentity) { t.Errorf("LinkIdentity(%q) = %v, want ErrInvalidIdentity", bad, err) }
+    }
+    if err := svc.LinkIdentity(ctx, "sso:xyz", "email:bob@example.com"); !errors.Is(err, ErrInvalidIdentity) { t.Fatalf("linking to an external-looking user = %v", err) }
+
+    for id, want := range map[string]core.UserID{"EMAIL:alice@example.com": "u-1", "sso:abc": "u-1", " U-7 ": "u-7", "phone:123": "phone:123"} {
+        if got, err := svc.ResolveUser(ctx, id); err != nil || got != want { t.Errorf("ResolveUser(%q) = %q, %v; want %q", id, got, err, want) }
+    }
+    if _, err := svc.ResolveUser(ctx, "email:nobody@example.com"); !errors.Is(err, ErrUnknownIdentity) { t.Fatalf("unlinked identity = %v", err) }
+
+    ids, err := svc.Identities(ctx, "u-1")
+    if err != nil || len(ids) != 2 || ids[0] != "email:alice@example.com" || ids[1] != "sso:abc" { t.Fatalf("Identities = %v, %v", ids, err) }
+    if ok, err := svc.UnlinkIdentity(ctx, "email:alice@example.com"); err != nil || !ok { t.Fatalf("UnlinkIdentity = %v, %v", ok, err) }
+    if err := svc.LinkIdentity(ctx, "u-2", "email:alice@example.com"); err != nil { t.Fatalf("linking a freed identity = %v", err) }
+}
+
+func TestIdentitiesNamespaced(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(NamespacedStorage(store), NewEventBus(DispatchSync), DefaultRuleEngine(), WithIdentityKinds("email"))
+    g1 := core.WithNamespace(context.Background(), "g1")
+    g2 := core.WithNamespace(context.Background(), "g2")
+    if err := svc.LinkIdentity(g1, "alice", "email:a@example.com"); err != nil { t.Fatal(err) }
+    if err := svc.LinkIdentity(g2, "bob", "email:a@example.com"); err != nil { t.Fatalf("same identity in another namespace = %v", err) }
+    if got, err := svc.ResolveUser(g1, "email:a@example.com"); err != nil || got != "alice" { t.Fatalf("g1 resolves to %q, %v", got, err) }
+    if got, err := svc.ResolveUser(g2, "email:a@example.com"); err != nil || got != "bob" { t.Fatalf("g2 resolves to %q, %v", got, err) }
+    if ids, err := svc.Identities(g2, "bob"); err != nil || len(ids) != 1 || ids[0] != "email:a@example.com" { t.Fatalf("g2 identities = %v, %v", ids, err) }
+    if raw, ok, _ := store.ResolveIdentity(context.Background(), "g1:email:a@example.com"); !ok || raw != "g1:alice" { t.Fatalf("unexpected storage key layout: %q", raw) }
+}
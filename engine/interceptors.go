@@ -0,0 +1,70 @@
+package engine
+
+import (
+    "context"
+
+    "gamifykit/core"
+)
+
+// Interceptor inspects an event on its way to subscribers and returns it, possibly enriched or
+// reshaped, or false to drop it. Events are shared between subscribers, so an interceptor that
+// changes Metadata must copy the map first (see EnrichMetadata).
+type Interceptor func(ctx context.Context, ev core.Event) (core.Event, bool)
+
+// ChainInterceptors composes interceptors into one that runs them in order and stops at the first drop.
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+    return func(ctx context.Context, ev core.Event) (core.Event, bool) {
+        for _, in := range interceptors {
+            var ok bool
+            if ev, ok = in(ctx, ev); !ok { return ev, false }
+        }
+        return ev, true
+    }
+}
+
+// Use appends interceptors that every published event passes through, in registration order,
+// before sampling and delivery. An event any of them drops reaches no subscriber and takes no
+// sequence number.
+func (e *EventBus) Use(interceptors ...Interceptor) {
+    e.mu.Lock(); defer e.mu.Unlock()
+    e.interceptors = append(e.interceptors, interceptors...)
+}
+
+// Intercept wraps a single subscriber's handler so interceptors shape the events it alone sees,
+// e.g. trimming events for analytics while webhooks get them enriched.
+func Intercept(handler func(context.Context, core.Event), interceptors ...Interceptor) func(context.Context, core.Event) {
+    chain := ChainInterceptors(interceptors...)
+    return func(ctx context.Context, ev core.Event) {
+        if ev, ok := chain(ctx, ev); ok { handler(ctx, ev) }
+    }
+}
+
+// EnrichMetadata returns an interceptor that merges the fields fn returns into a copy of the
+// event's Metadata, e.g. a badge's title and icon for badge_awarded events.
+func EnrichMetadata(fn func(ctx context.Context, ev core.Event) map[string]any) Interceptor {
+    return func(ctx context.Context, ev core.Event) (core.Event, bool) {
+        extra := fn(ctx, ev)
+        if len(extra) == 0 { return ev, true }
+        md := make(map[string]any, len(ev.Metadata)+len(extra))
+        for k, v := range ev.Metadata { md[k] = v }
+        for k, v := range extra { md[k] = v }
+        ev.Metadata = md
+        return ev, true
+    }
+}
+
+// OnlyTypes returns an interceptor that drops every event not of the given types.
+func OnlyTypes(types ...core.EventType) Interceptor {
+    return func(_ context.Context, ev core.Event) (core.Event, bool) {
+        for _, t := range types {
+            if ev.Type == t { return ev, true }
+        }
+        return ev, false
+    }
+}
+
+// DropNoOpPoints drops points events whose Delta is zero.
+func DropNoOpPoints(_ context.Context, ev core.Event) (core.Event, bool) {
+    if (ev.Type == core.EventPointsAdded || ev.Type == core.EventPointsTransferred) && ev.Delta == 0 { return ev, false }
+    return ev, true
+}
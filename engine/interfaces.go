@@ -2,10 +2,16 @@ package engine
 
 import (
     "context"
+    "errors"
+    "time"
+
     "gamifykit/core"
 )
 
 // Storage abstracts persistence for gamification state.
+//
+// GetState returns an independent copy: callers may modify the returned maps freely without
+// affecting storage, and later writes do not show through a state already returned.
 type Storage interface {
     AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (newTotal int64, err error)
     AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error
@@ -18,4 +24,158 @@ type RuleEngine interface {
     Evaluate(ctx context.Context, state core.UserState, trigger core.Event) []core.Event
 }
 
+// PointsMultiplier is implemented by rule engines that scale point awards, e.g. double XP on
+// weekends. AddPoints passes positive deltas through it before applying the metric's value policy.
+type PointsMultiplier interface {
+    MultiplyPoints(ctx context.Context, state core.UserState, metric core.Metric, delta int64) int64
+}
+
+// BadgeCooldowns is implemented by rule engines whose badges may be earned again once a cooldown
+// has passed. Badge events emitted by such a rule engine are awarded like WithRepeatableBadge
+// badges, which needs a storage implementing BadgeRepeater.
+type BadgeCooldowns interface {
+    BadgeCooldown(badge core.Badge) (time.Duration, bool)
+}
+
+// WindowedRuleEngine is implemented by rule engines with rules over recent activity, such as
+// streaks. The service calls EvaluateWindowed instead of Evaluate when its storage implements
+// WindowedPoints.
+type WindowedRuleEngine interface {
+    EvaluateWindowed(ctx context.Context, state core.UserState, trigger core.Event, w WindowedPoints) []core.Event
+}
+
+// BadgeStoringRules is implemented by rule engines whose badge and achievement events the service
+// should store, publishing them only for new awards and honouring BadgeCooldowns. Badge events of
+// other rule engines are published as emitted and not stored.
+type BadgeStoringRules interface {
+    StoresBadges() bool
+}
+
+
+
+// Txner is implemented by storages that can run several operations atomically.
+// Only the sqlx adapter provides real transactions; see RunInTx for the fallback.
+type Txner interface {
+    WithTx(ctx context.Context, fn func(tx Storage) error) error
+}
+
+// UserLocker is implemented by transactional storages that can lock a user's rows for the rest of
+// the current transaction, so a read-check-write sequence cannot race with concurrent writers.
+type UserLocker interface {
+    LockUser(ctx context.Context, user core.UserID) error
+}
+
+// UserExister is implemented by storages that can tell whether a user has any stored data.
+// GetState returns an empty state for unknown users, so this is the only way to tell them apart
+// from users who exist but have nothing yet.
+type UserExister interface {
+    Exists(ctx context.Context, user core.UserID) (bool, error)
+}
+
+// StateBatchGetter is implemented by storages that load the states of many users in fewer round
+// trips than one GetState per user. Failures are reported per user: the map holds every user whose
+// state loaded, and a non-nil error is a *core.BatchError naming each of the others.
+type StateBatchGetter interface {
+    GetStateMany(ctx context.Context, users []core.UserID) (map[core.UserID]core.UserState, error)
+}
+
+// StateReplacer is implemented by storages that can atomically overwrite a user's whole state:
+// points, badges and levels not present in the new state are removed.
+type StateReplacer interface {
+    ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error
+}
+
+// PointsTransferer is implemented by storages that move points between two users atomically.
+// The sender's new total must stay at or above floor and the receiver's at or below ceiling,
+// otherwise nothing is written and core.ErrInsufficientPoints or core.ErrReceiverLimit is returned.
+type PointsTransferer interface {
+    TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (fromTotal, toTotal int64, err error)
+}
+
+// PointsUpdater is implemented by storages that can compute a user's new total from the stored
+// one atomically: fn gets the current total and returns the one to write, and no other write to the
+// same total can slip in between. Nothing is written when fn fails or returns the current total.
+// AddPointsIf applies value policies through it, so concurrent adds cannot push a total past its
+// bounds on storages without transactions.
+type PointsUpdater interface {
+    UpdatePoints(ctx context.Context, user core.UserID, metric core.Metric, fn func(current int64) (int64, error)) (previous, total int64, err error)
+}
+
+// updatePoints is PointsUpdater.UpdatePoints on any storage: others read the total and add the
+// difference, which is only atomic with the user locked
+func updatePoints(ctx context.Context, s Storage, user core.UserID, metric core.Metric, fn func(int64) (int64, error)) (int64, int64, error) {
+    if u, ok := s.(PointsUpdater); ok { return u.UpdatePoints(ctx, user, metric, fn) }
+    state, err := s.GetState(ctx, user)
+    if err != nil { return 0, 0, err }
+    previous := state.Points[metric]
+    next, err := fn(previous)
+    if err != nil || next == previous { return previous, previous, err }
+    delta, err := core.SubSafe(next, previous)
+    if err != nil { return 0, 0, err }
+    total, err := s.AddPoints(ctx, user, metric, delta)
+    if err != nil { return 0, 0, err }
+    return total - delta, total, nil
+}
+
+// LevelRaiser is implemented by storages that raise a stored level in one conditional write: level
+// is stored only while it is above the current one (a missing level counting as 0), so concurrent
+// writers cannot lower a level another one raised. raised reports whether it was stored.
+// Monotonic levels (see WithLevelMonotonic) are raised through it.
+type LevelRaiser interface {
+    RaiseLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) (raised bool, err error)
+}
+
+// raiseLevel is LevelRaiser.RaiseLevel on any storage: others read the level and set it, which is
+// only atomic with the user locked
+func raiseLevel(ctx context.Context, s Storage, user core.UserID, metric core.Metric, level int64) (bool, error) {
+    if r, ok := s.(LevelRaiser); ok { return r.RaiseLevel(ctx, user, metric, level) }
+    state, err := s.GetState(ctx, user)
+    if err != nil { return false, err }
+    if state.Levels[metric] >= level { return false, nil }
+    return true, s.SetLevel(ctx, user, metric, level)
+}
+
+// BadgeAwarder is implemented by storages that report whether AwardBadge actually awarded the
+// badge. awarded is true for exactly one of any number of concurrent awards of the same badge.
+type BadgeAwarder interface {
+    TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (awarded bool, err error)
+}
 
+// ErrBadgeRemovalUnsupported is returned when removing badges from a storage without BadgeRemover.
+var ErrBadgeRemovalUnsupported = errors.New("storage does not support removing badges")
+
+// BadgeRemover is implemented by storages that can take a badge away again. removed reports
+// whether the user held the badge, so concurrent removals of the same badge are reported once.
+type BadgeRemover interface {
+    RemoveBadge(ctx context.Context, user core.UserID, badge core.Badge) (removed bool, err error)
+}
+
+// storageWrapper is implemented by the storages wrapping another one, such as NamespacedStorage
+type storageWrapper interface {
+    unwrapStorage() Storage
+}
+
+// innermost looks through the wrappers around storage to the storage they wrap
+func innermost(storage Storage) Storage {
+    for {
+        w, ok := storage.(storageWrapper)
+        if !ok { return storage }
+        storage = w.unwrapStorage()
+    }
+}
+
+// transactional reports whether storage has real transactions. Wrappers implement Txner either way,
+// so they are looked through to the storage they wrap.
+func transactional(storage Storage) bool {
+    _, ok := innermost(storage).(Txner)
+    return ok
+}
+
+// RunInTx runs fn inside a transaction when storage implements Txner.
+// Other storages run fn directly on a best-effort basis: writes already made are not undone if fn fails.
+func RunInTx(ctx context.Context, storage Storage, fn func(tx Storage) error) error {
+    if t, ok := storage.(Txner); ok {
+        return t.WithTx(ctx, fn)
+    }
+    return fn(storage)
+}
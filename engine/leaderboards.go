@@ -0,0 +1,108 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// Leaderboard is the part of leaderboard.Board the service needs to keep a board in sync.
+type Leaderboard interface {
+    Update(user core.UserID, score int64)
+    Remove(user core.UserID)
+}
+
+// BoardConfig registers a leaderboard for a metric.
+type BoardConfig struct {
+    Board Leaderboard
+    // MinScore is the inclusion threshold: a user is on the board only while their total is >= MinScore,
+    // and is removed as soon as a negative delta or decay drops them below it.
+    MinScore int64
+}
+
+// WithLeaderboard keeps cfg.Board updated with metric totals after every AddPoints.
+// Several boards may be registered for the same metric.
+func WithLeaderboard(metric core.Metric, cfg BoardConfig) ServiceOption {
+    return func(g *GamifyService){ g.boards[metric] = append(g.boards[metric], cfg) }
+}
+
+// WithEventDrivenLeaderboards makes the boards registered with WithLeaderboard and
+// WithCompositeLeaderboard a projection of the event stream instead of part of the write path: a
+// subscriber named "leaderboards" applies the post-change totals carried by core.EventPointsAdded
+// and core.EventPointsTransferred, reloads the user on core.EventStateReplaced and removes the
+// source of a core.EventUsersMerged. Any publisher of these events moves the boards, including
+// Publish calls for changes made outside the service (e.g. by a relay reading the outbox), and
+// events for metrics without a board are ignored.
+// Applying a total is idempotent, so events may be replayed with ApplyToBoards. Under
+// DispatchAsync boards lag the writes slightly. Season boards are still updated by the write path.
+func WithEventDrivenLeaderboards() ServiceOption {
+    return func(g *GamifyService){ g.projected = true }
+}
+
+// subscribeBoards registers the leaderboard projection; see WithEventDrivenLeaderboards
+func (g *GamifyService) subscribeBoards() {
+    handle := func(ctx context.Context, e core.Event){ _ = g.ApplyToBoards(ctx, e) }
+    for _, typ := range []core.EventType{core.EventPointsAdded, core.EventPointsTransferred, core.EventStateReplaced, core.EventUsersMerged} {
+        g.bus.SubscribeNamed("leaderboards", typ, handle)
+    }
+}
+
+// ApplyToBoards brings the registered leaderboards up to date with events, e.g. to replay an
+// event log into fresh boards. Points events set the user's entry to the event's total; state
+// replacements, and points events for metrics weighted by a composite board (any metric when it
+// weights a derived metric), reload the user's
+// totals from storage, and merges take the merged-away user off every board. Other events and
+// metrics without a board are skipped. Replaying an event is harmless as long as events are applied in their original
+// order per user. It returns the first storage error and keeps applying the rest.
+func (g *GamifyService) ApplyToBoards(ctx context.Context, events ...core.Event) error {
+    var first error
+    for _, e := range events {
+        switch e.Type {
+        case core.EventPointsAdded, core.EventPointsTransferred:
+            g.updateBoards(ctx, e.UserID, e.Metric, e.Total)
+            if err := g.updateComposites(ctx, e.UserID, e.Metric); err != nil && first == nil { first = err }
+        case core.EventStateReplaced:
+            if err := g.updateComposites(ctx, e.UserID, ""); err != nil && first == nil { first = err }
+            state, err := g.storage.GetState(ctx, e.UserID)
+            if err != nil {
+                if first == nil { first = fmt.Errorf("failed to load user %s: %w", e.UserID, err) }
+                continue
+            }
+            for metric, boards := range g.boards {
+                if total, ok := state.Points[metric]; ok {
+                    g.updateBoards(ctx, e.UserID, metric, total)
+                    continue
+                }
+                for _, b := range boards { b.Board.Remove(e.UserID) }
+            }
+        case core.EventUsersMerged:
+            if source, ok := e.Metadata["source"].(string); ok && source != "" { g.removeFromBoards(core.UserID(source)) }
+        }
+    }
+    return first
+}
+
+// syncBoards updates the boards of metric from the write path, unless events drive them
+func (g *GamifyService) syncBoards(ctx context.Context, user core.UserID, metric core.Metric, total int64) {
+    if g.projected { return }
+    g.updateBoards(ctx, user, metric, total)
+    _ = g.updateComposites(ctx, user, metric)
+}
+
+// updateBoards applies the inclusion threshold of every board registered for metric
+func (g *GamifyService) updateBoards(ctx context.Context, user core.UserID, metric core.Metric, total int64) {
+    if len(g.boards[metric]) == 0 { return }
+    _, span := tracing.Start(ctx, "engine.syncBoards")
+    span.SetUser(user)
+    span.SetAttr("metric", string(metric))
+    defer span.End(nil)
+    for _, b := range g.boards[metric] {
+        if total >= b.MinScore {
+            b.Board.Update(user, total)
+        } else {
+            b.Board.Remove(user)
+        }
+    }
+}
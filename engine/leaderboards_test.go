@@ -0,0 +1,56 @@
+package engine
+
+import (
+    "context"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func TestLeaderboardInclusionThreshold(t *testing.T) {
+    board := leaderboard.NewSkipList()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithLeaderboard(core.MetricPoints, BoardConfig{Board: board, MinScore: 100}))
+    ctx := context.Background()
+
+    if _, err := svc.AddPoints(ctx, "low", core.MetricPoints, 50); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("low"); ok { t.Fatal("user below threshold must not be added") }
+
+    if _, err := svc.AddPoints(ctx, "low", core.MetricPoints, 60); err != nil { t.Fatal(err) }
+    if e, ok := board.Get("low"); !ok || e.Score != 110 { t.Fatalf("user crossing threshold must be added, got %v %v", e, ok) }
+
+    if _, err := svc.AddPoints(ctx, "low", core.MetricPoints, -20); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("low"); ok { t.Fatal("user dropping below threshold must be pruned") }
+
+    if _, err := svc.AddPoints(ctx, "other", core.MetricXP, 500); err != nil { t.Fatal(err) }
+    if board.Count() != 0 { t.Fatalf("other metrics must not touch the board, count %d", board.Count()) }
+}
+
+func TestEventDrivenLeaderboards(t *testing.T) {
+    board := leaderboard.NewSkipList()
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithEventDrivenLeaderboards(),
+        WithLeaderboard(core.MetricPoints, BoardConfig{Board: board, MinScore: 10}))
+    ctx := context.Background()
+
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricPoints, 50); err != nil { t.Fatal(err) }
+    if e, ok := board.Get("alice"); !ok || e.Score != 50 { t.Fatalf("points event not projected: %v %v", e, ok) }
+    if err := svc.Transfer(ctx, "alice", "bob", core.MetricPoints, 45); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("alice"); ok { t.Fatal("sender below the threshold must be removed") }
+    if e, _ := board.Get("bob"); e.Score != 45 { t.Fatalf("receiver score = %d, want 45", e.Score) }
+
+    // a change made outside the service moves the board once its event is published
+    if _, err := store.AddPoints(ctx, "carol", core.MetricPoints, 70); err != nil { t.Fatal(err) }
+    svc.Publish(ctx, core.NewPointsAdded("carol", core.MetricPoints, 70, 70))
+    if e, _ := board.Get("carol"); e.Score != 70 { t.Fatalf("published event not projected, score %d", e.Score) }
+
+    // replays are idempotent, and metrics without a board are ignored
+    replay := []core.Event{core.NewPointsAdded("carol", core.MetricPoints, 70, 70), core.NewPointsAdded("carol", core.MetricXP, 5, 5)}
+    if err := svc.ApplyToBoards(ctx, replay...); err != nil { t.Fatal(err) }
+    if e, _ := board.Get("carol"); e.Score != 70 || board.Count() != 2 { t.Fatalf("replay changed the board: %v, %d entries", e, board.Count()) }
+
+    if err := svc.ReplaceState(ctx, "bob", core.UserState{Points: map[core.Metric]int64{core.MetricXP: 1}}); err != nil { t.Fatal(err) }
+    if _, ok := board.Get("bob"); ok { t.Fatal("replaced state without the metric must leave the board") }
+}
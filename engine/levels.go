@@ -0,0 +1,169 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "gamifykit/core"
+)
+
+// ErrUserListingUnsupported is returned by operations that walk all users on storages without UserLister.
+var ErrUserListingUnsupported = errors.New("storage does not support listing users")
+
+// UserLister is implemented by storages that can enumerate the users they hold.
+type UserLister interface {
+    // EachUser calls fn for every stored user, stopping at the first error.
+    EachUser(ctx context.Context, fn func(core.UserID) error) error
+}
+
+// WithDerivedLevels makes metric's level a pure function of its points total.
+// GetState computes the level with curve at read time instead of trusting the stored value,
+// and AddPoints emits level-up events without writing levels. A nil curve uses DefaultCurve.
+// Metrics without this option keep the stored, SetLevel-driven levels.
+func WithDerivedLevels(metric core.Metric, curve LevelCurve) ServiceOption {
+    if curve == nil { curve = DefaultCurve }
+    return func(g *GamifyService){ g.derived[metric] = curve }
+}
+
+// WithLevelMonotonic sets whether the derived level of metric may only rise. By default a derived
+// level tracks the curve both ways, so losing points (negative deltas, transfers, resets) lowers it
+// and core.EventLevelDown is published. A monotonic level keeps its highest value even when points
+// fall: it is stored on every level-up, through a conditional write on storages implementing
+// LevelRaiser so concurrent writes cannot lower it, and GetState reports the higher of the stored
+// and computed levels. Stored, rule-driven levels of metrics without WithDerivedLevels only ever rise.
+func WithLevelMonotonic(metric core.Metric, monotonic bool) ServiceOption {
+    return func(g *GamifyService){ g.monotonic[metric] = monotonic }
+}
+
+// applyDerivedLevels overwrites levels of derived metrics in state with their computed values
+func (g *GamifyService) applyDerivedLevels(state core.UserState) core.UserState {
+    if len(g.derived) == 0 { return state }
+    if state.Levels == nil { state.Levels = map[core.Metric]int64{} }
+    for metric, curve := range g.derived {
+        if total, ok := state.Points[metric]; ok {
+            state.Levels[metric] = g.levelFor(metric, curve, total, state.Levels[metric])
+        }
+    }
+    return state
+}
+
+// levelFor is the level of a derived metric at total; monotonic metrics never report less than stored
+func (g *GamifyService) levelFor(metric core.Metric, curve LevelCurve, total, stored int64) int64 {
+    level := curve.LevelFor(total)
+    if g.monotonic[metric] { level = max(level, stored) }
+    return level
+}
+
+// levelChange publishes the level event of a derived metric whose total moved from before to after.
+// Monotonic metrics compare against the stored high-water level and store each new one.
+func (g *GamifyService) levelChange(ctx context.Context, user core.UserID, metric core.Metric, before, after int64) {
+    curve, ok := g.derived[metric]
+    if !ok { return }
+    prev, next := curve.LevelFor(before), curve.LevelFor(after)
+    if !g.monotonic[metric] {
+        switch {
+        case next > prev: g.publish(ctx, core.NewLevelUp(user, metric, next))
+        case next < prev: g.publish(ctx, core.NewLevelDown(user, metric, next))
+        }
+        return
+    }
+    if next <= prev { return }
+    raised := false
+    err := g.withRetry(ctx, "set_level", func() (err error) {
+        raised, err = raiseLevel(ctx, g.storage, user, metric, next)
+        return err
+    })
+    if err == nil && raised { g.publish(ctx, core.NewLevelUp(user, metric, next)) }
+}
+
+// RecomputeAllLevels rewrites the stored level of every derived metric for every user so stored data
+// matches the curve, e.g. once when switching a deployment to WithDerivedLevels. Stored levels of
+// monotonic metrics (see WithLevelMonotonic) are only raised. It is idempotent and returns how many
+// levels were changed. The storage must implement UserLister.
+func (g *GamifyService) RecomputeAllLevels(ctx context.Context) (int, error) {
+    lister, ok := g.storage.(UserLister)
+    if !ok { return 0, ErrUserListingUnsupported }
+    fixed := 0
+    err := lister.EachUser(ctx, func(user core.UserID) error {
+        state, err := g.storage.GetState(ctx, user)
+        if err != nil { return fmt.Errorf("failed to load user %s: %w", user, err) }
+        for metric, curve := range g.derived {
+            total, ok := state.Points[metric]
+            if !ok { continue }
+            want := g.levelFor(metric, curve, total, state.Levels[metric])
+            if state.Levels[metric] == want { continue }
+            changed := true
+            err := g.withRetry(ctx, "set_level", func() (err error) {
+                // a monotonic level raised concurrently must not be lowered back
+                if g.monotonic[metric] {
+                    changed, err = raiseLevel(ctx, g.storage, user, metric, want)
+                    return err
+                }
+                return g.storage.SetLevel(ctx, user, metric, want)
+            })
+            if err != nil { return fmt.Errorf("failed to set level for user %s: %w", user, err) }
+            if changed { fixed++ }
+        }
+        return nil
+    })
+    return fixed, err
+}
+
+// Progress is a user's position between two levels of a metric's curve, e.g. for a progress bar.
+// Level is the level GetState reports, so a monotonic level kept after losing points (see
+// WithLevelMonotonic) shows its own band with Fraction 0 and ToNext counting up to the next level.
+type Progress struct {
+    Metric      core.Metric `json:"metric"`
+    Points      int64       `json:"points"`
+    Level       int64       `json:"level"`
+    // LevelStart is the total at which the current level began.
+    LevelStart  int64       `json:"level_start"`
+    // NextLevelAt is the total at which the next level begins.
+    NextLevelAt int64       `json:"next_level_at"`
+    // ToNext is the number of points still needed for the next level.
+    ToNext      int64       `json:"points_to_next"`
+    // Fraction is how far through the current level the user is, from 0 to 1.
+    Fraction    float64     `json:"progress"`
+}
+
+// GetProgress reports the user's progress toward the next level of metric, from the same view
+// of the user as GetState.
+// ok is false when metric has no level curve (see WithDerivedLevels).
+func (g *GamifyService) GetProgress(ctx context.Context, user core.UserID, metric core.Metric) (p Progress, ok bool, err error) {
+    curve, ok := g.derived[metric]
+    if !ok { return Progress{}, false, nil }
+    state, err := g.storage.GetState(ctx, user)
+    if err != nil { return Progress{}, false, err }
+    view := g.view(state.AllTime())
+    return progressOn(curve, metric, view.Points[metric], view.Levels[metric]), true, nil
+}
+
+// ProgressFor computes progress for every metric of state that has a level curve, applying the
+// same view as GetState first, so stored states and GetState results give the same answer.
+// It returns nil when none do.
+func (g *GamifyService) ProgressFor(state core.UserState) map[core.Metric]Progress {
+    view := g.view(state)
+    var out map[core.Metric]Progress
+    for metric, curve := range g.derived {
+        total, ok := view.Points[metric]
+        if !ok { continue }
+        if out == nil { out = map[core.Metric]Progress{} }
+        out[metric] = progressOn(curve, metric, total, view.Levels[metric])
+    }
+    return out
+}
+
+// progressOn is the progress of total at level, the level GetState reports for the metric
+func progressOn(curve LevelCurve, metric core.Metric, total, level int64) Progress {
+    p := Progress{Metric: metric, Points: total, Level: level, LevelStart: curve.PointsForLevel(level), NextLevelAt: curve.PointsForLevel(level + 1)}
+    if p.NextLevelAt <= p.LevelStart {
+        // the curve saturated at the int64 limit; there is no next level
+        p.NextLevelAt, p.Fraction = p.LevelStart, 1
+        return p
+    }
+    p.ToNext = p.NextLevelAt - total
+    p.Fraction = float64(total-p.LevelStart) / float64(p.NextLevelAt-p.LevelStart)
+    if p.Fraction < 0 { p.Fraction = 0 }
+    return p
+}
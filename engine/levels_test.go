@@ -0,0 +1,126 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestDerivedLevels(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithDerivedLevels("coins", MustLinearCurve(100)))
+
+    var ups []int64
+    svc.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event){ if e.Metric == "coins" { ups = append(ups, e.Level) } })
+
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "u", "coins", 250); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "u", "coins", 10); err != nil { t.Fatal(err) }
+    if len(ups) != 1 || ups[0] != 3 { t.Fatalf("expected a single level up to 3, got %v", ups) }
+
+    // a manual edit to the stored level is ignored on read
+    _ = store.SetLevel(ctx, "u", "coins", 42)
+    st, _ := svc.GetState(ctx, "u")
+    if st.Levels["coins"] != 3 { t.Fatalf("derived level should be 3, got %d", st.Levels["coins"]) }
+
+    // the explicit SetLevel path keeps working for other metrics
+    if _, err := svc.AddPoints(ctx, "u", core.MetricXP, 10000); err != nil { t.Fatal(err) }
+    raw, _ := store.GetState(ctx, "u")
+    if raw.Levels[core.MetricXP] != 11 { t.Fatalf("stored xp level should be 11, got %d", raw.Levels[core.MetricXP]) }
+}
+
+func TestRecomputeAllLevels(t *testing.T) {
+    store := mem.New()
+    ctx := context.Background()
+    _, _ = store.AddPoints(ctx, "a", core.MetricXP, 10000)
+    _ = store.SetLevel(ctx, "a", core.MetricXP, 1)
+    _, _ = store.AddPoints(ctx, "b", core.MetricXP, 400)
+    _ = store.SetLevel(ctx, "b", core.MetricXP, 3)
+
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithDerivedLevels(core.MetricXP, nil))
+    fixed, err := svc.RecomputeAllLevels(ctx)
+    if err != nil || fixed != 1 { t.Fatalf("expected 1 fix, got %d %v", fixed, err) }
+    a, _ := store.GetState(ctx, "a")
+    if a.Levels[core.MetricXP] != 11 { t.Fatalf("stored level should be fixed to 11, got %d", a.Levels[core.MetricXP]) }
+
+    if fixed, _ := svc.RecomputeAllLevels(ctx); fixed != 0 { t.Fatalf("recompute must be idempotent, fixed %d", fixed) }
+
+    noList := NewGamifyService(struct{ Storage }{store}, NewEventBus(DispatchSync), DefaultRuleEngine())
+    if _, err := noList.RecomputeAllLevels(ctx); !errors.Is(err, ErrUserListingUnsupported) { t.Fatalf("want ErrUserListingUnsupported, got %v", err) }
+}
+
+func TestGetProgress(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithDerivedLevels("coins", MustLinearCurve(100)))
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "u", "coins", 250); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "u", core.MetricXP, 5); err != nil { t.Fatal(err) }
+
+    p, ok, err := svc.GetProgress(ctx, "u", "coins")
+    if err != nil || !ok { t.Fatalf("expected progress, got ok=%v err=%v", ok, err) }
+    want := Progress{Metric: "coins", Points: 250, Level: 3, LevelStart: 200, NextLevelAt: 300, ToNext: 50, Fraction: 0.5}
+    if p != want { t.Fatalf("got %+v, want %+v", p, want) }
+
+    if _, ok, _ := svc.GetProgress(ctx, "u", core.MetricXP); ok { t.Fatal("metrics without a curve have no progress") }
+
+    st, _ := svc.GetState(ctx, "u")
+    all := svc.ProgressFor(st)
+    if len(all) != 1 || all["coins"] != want { t.Fatalf("unexpected progress map %+v", all) }
+}
+
+func TestGetProgressMatchesGetState(t *testing.T) {
+    store := mem.New()
+    ctx := context.Background()
+    // a level stored by an earlier, steeper curve is kept by the monotonic policy
+    if err := store.SetLevel(ctx, "u", "coins", 5); err != nil { t.Fatal(err) }
+    if _, err := store.AddPoints(ctx, "u", "coins", 250); err != nil { t.Fatal(err) }
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithDerivedLevels("coins", MustLinearCurve(100)), WithLevelMonotonic("coins", true))
+
+    st, _ := svc.GetState(ctx, "u")
+    p, ok, err := svc.GetProgress(ctx, "u", "coins")
+    if err != nil || !ok { t.Fatalf("expected progress, got ok=%v err=%v", ok, err) }
+    if p.Level != st.Levels["coins"] || p.Level != 5 { t.Fatalf("progress level %d, GetState level %d", p.Level, st.Levels["coins"]) }
+    raw, _ := store.GetState(ctx, "u")
+    if got := svc.ProgressFor(raw)["coins"]; got != p { t.Fatalf("ProgressFor(stored) = %+v, want %+v", got, p) }
+    if got := svc.ProgressFor(st)["coins"]; got != p { t.Fatalf("ProgressFor(GetState) = %+v, want %+v", got, p) }
+}
+
+func TestLevelPolicyOnPointLoss(t *testing.T) {
+    ctx := context.Background()
+    for _, monotonic := range []bool{false, true} {
+        store := mem.New()
+        svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+            WithDerivedLevels("coins", MustLinearCurve(100)), WithLevelMonotonic("coins", monotonic))
+        var events []string
+        record := func(ctx context.Context, e core.Event){ if e.UserID == "u" { events = append(events, fmt.Sprintf("%s %d", e.Type, e.Level)) } }
+        svc.Subscribe(core.EventLevelUp, record)
+        svc.Subscribe(core.EventLevelDown, record)
+
+        if _, err := svc.AddPoints(ctx, "u", "coins", 350); err != nil { t.Fatal(err) }
+        if _, err := svc.AddPoints(ctx, "u", "coins", -200); err != nil { t.Fatal(err) }
+        if err := svc.Transfer(ctx, "u", "v", "coins", 100); err != nil { t.Fatal(err) }
+        st, _ := svc.GetState(ctx, "u")
+        // climbing back above a lost level's threshold only levels up past the high-water mark
+        if _, err := svc.AddPoints(ctx, "u", "coins", 200); err != nil { t.Fatal(err) }
+        again, _ := svc.GetState(ctx, "u")
+
+        want, wantLevel, wantAgain := "[level_up 4 level_down 2 level_down 1 level_up 3]", int64(1), int64(3)
+        if monotonic { want, wantLevel, wantAgain = "[level_up 4]", 4, 4 }
+        if got := fmt.Sprint(events); got != want { t.Fatalf("monotonic=%v: events %s, want %s", monotonic, got, want) }
+        if st.Levels["coins"] != wantLevel { t.Fatalf("monotonic=%v: level after losses %d, want %d", monotonic, st.Levels["coins"], wantLevel) }
+        if again.Levels["coins"] != wantAgain { t.Fatalf("monotonic=%v: level after regaining %d, want %d", monotonic, again.Levels["coins"], wantAgain) }
+
+        // progress follows the reported level, so a kept level never shows negative progress
+        if _, err := svc.AddPoints(ctx, "u", "coins", -200); err != nil { t.Fatal(err) }
+        p, _, err := svc.GetProgress(ctx, "u", "coins")
+        if err != nil { t.Fatal(err) }
+        wantProgress := Progress{Metric: "coins", Points: 50, Level: 1, LevelStart: 0, NextLevelAt: 100, ToNext: 50, Fraction: 0.5}
+        if monotonic { wantProgress = Progress{Metric: "coins", Points: 50, Level: 4, LevelStart: 300, NextLevelAt: 400, ToNext: 350, Fraction: 0} }
+        if p != wantProgress { t.Fatalf("monotonic=%v: progress %+v, want %+v", monotonic, p, wantProgress) }
+    }
+}
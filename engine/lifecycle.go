@@ -0,0 +1,63 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+// Lifecycle runs named shutdown steps in the order they were registered, so components that feed
+// each other (subscribers, their queues, the exporters behind them) stop front to back.
+type Lifecycle struct {
+    mu    sync.Mutex
+    steps []shutdownStep
+}
+
+type shutdownStep struct {
+    name string
+    fn   func(context.Context) error
+}
+
+// OnShutdown registers fn as the next shutdown step.
+func (l *Lifecycle) OnShutdown(name string, fn func(context.Context) error) {
+    l.mu.Lock(); defer l.mu.Unlock()
+    l.steps = append(l.steps, shutdownStep{name: name, fn: fn})
+}
+
+// Shutdown runs every step once, in order, with ctx bounding the whole sequence. A failing step
+// does not stop later ones; the errors are joined, each prefixed with its step's name. Steps
+// still run once ctx is done so they can release what they hold, but should not block.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+    l.mu.Lock()
+    steps := l.steps
+    l.steps = nil
+    l.mu.Unlock()
+    var errs []error
+    for _, s := range steps {
+        if err := s.fn(ctx); err != nil { errs = append(errs, fmt.Errorf("%s: %w", s.name, err)) }
+    }
+    return errors.Join(errs...)
+}
+
+// OnShutdown registers a step for Shutdown to run after the event bus has drained, e.g. persisting
+// analytics snapshots or flushing the webhook queue and exporters fed by subscribers.
+func (g *GamifyService) OnShutdown(name string, fn func(context.Context) error) {
+    g.lifecycle.OnShutdown(name, fn)
+}
+
+// Shutdown stops the service without losing accepted events, bounded by ctx: the bus stops taking
+// new events, delivers the events held for coalescing and drains its queues and worker pool (see
+// EventBus.Shutdown), then the OnShutdown steps run in registration order. Stop the transports that
+// call the service first, e.g. with http.Server.Shutdown; their writes still reach storage
+// afterwards, but their events are dropped.
+func (g *GamifyService) Shutdown(ctx context.Context) error {
+    var errs []error
+    if err := g.bus.Shutdown(ctx); err != nil { errs = append(errs, fmt.Errorf("events: %w", err)) }
+    if err := g.lifecycle.Shutdown(ctx); err != nil { errs = append(errs, err) }
+    return errors.Join(errs...)
+}
+
+// Flush delivers the events held for coalescing and waits for the queued ones without stopping the
+// service; see EventBus.Flush.
+func (g *GamifyService) Flush(ctx context.Context) error { return g.bus.Flush(ctx) }
@@ -0,0 +1,62 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestServiceShutdown(t *testing.T) {
+    ctx := context.Background()
+    bus := NewEventBus(DispatchAsync)
+    bus.SetSampling(core.EventPointsAdded, SamplingPolicy{Coalesce: time.Hour})
+    svc := NewGamifyService(mem.New(), bus, LevelUpRuleEngine())
+    var handled, best atomic.Int64
+    svc.Subscribe(core.EventBadgeAwarded, func(context.Context, core.Event){ time.Sleep(time.Millisecond); handled.Add(1) })
+    svc.Subscribe(core.EventBadgeAwarded, func(context.Context, core.Event){ best.Add(1) }, OrderingBestEffort)
+    var coalesced atomic.Int64
+    svc.Subscribe(core.EventPointsAdded, func(_ context.Context, e core.Event){ coalesced.Add(e.Delta) })
+
+    for i := 0; i < 50; i++ {
+        if err := svc.AwardBadge(ctx, core.UserID(fmt.Sprintf("user-%d", i)), "starter"); err != nil { t.Fatal(err) }
+    }
+    _, _ = svc.AddPoints(ctx, "alice", core.MetricXP, 5)
+    _, _ = svc.AddPoints(ctx, "alice", core.MetricXP, 7)
+
+    var steps []string
+    svc.OnShutdown("analytics", func(context.Context) error {
+        steps = append(steps, "analytics")
+        if handled.Load() != 50 { t.Errorf("steps must run after the bus drained, %d of 50 handled", handled.Load()) }
+        return nil
+    })
+    svc.OnShutdown("webhooks", func(context.Context) error { steps = append(steps, "webhooks"); return errors.New("queue stuck") })
+
+    err := svc.Shutdown(ctx)
+    if err == nil || err.Error() != "webhooks: queue stuck" { t.Fatalf("want the failing step's error, got %v", err) }
+    if len(steps) != 2 || steps[0] != "analytics" { t.Fatalf("steps should run in order, got %v", steps) }
+    if handled.Load() != 50 || best.Load() != 50 { t.Fatalf("want all events delivered, got %d FIFO and %d best-effort", handled.Load(), best.Load()) }
+    if coalesced.Load() != 12 { t.Fatalf("coalesced points should be flushed on shutdown, got %d", coalesced.Load()) }
+
+    // the bus no longer accepts events, and steps only run once
+    svc.Publish(ctx, core.NewBadgeAwarded("zed", "late"))
+    time.Sleep(5 * time.Millisecond)
+    if handled.Load() != 50 { t.Fatal("events published after shutdown should be dropped") }
+    if err := svc.Shutdown(ctx); err != nil { t.Fatalf("second shutdown should be a no-op, got %v", err) }
+}
+
+func TestEventBusShutdownDeadline(t *testing.T) {
+    bus := NewEventBus(DispatchAsync)
+    release := make(chan struct{})
+    defer close(release)
+    bus.Subscribe(core.EventPointsAdded, func(context.Context, core.Event){ <-release })
+    for i := 0; i < 3; i++ { bus.Publish(context.Background(), core.NewPointsAdded("u", core.MetricXP, 1, 1)) }
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if err := bus.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("want a deadline error for undelivered events, got %v", err) }
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "gamifykit/core"
+)
+
+// ErrLimitExceeded is returned when a write would give a user more distinct metrics or badges than
+// WithUserLimits allows.
+var ErrLimitExceeded = errors.New("per-user limit exceeded")
+
+// UserLimits caps how many distinct metrics and badges one user can accumulate, so a buggy or
+// malicious client cannot bloat storage with free-form names. Zero means unlimited.
+type UserLimits struct {
+    // MaxMetrics is the number of distinct metrics a user may hold points in. Season ledgers
+    // count with their metric.
+    MaxMetrics int
+    // MaxBadges is the number of distinct badges a user may hold.
+    MaxBadges int
+    // OnExceeded, if set, is called for every rejected write with the limit hit ("metrics" or
+    // "badges"), e.g. to count rejections.
+    OnExceeded func(limit string, user core.UserID)
+}
+
+// WithUserLimits enforces l in AddPoints, Transfer (for the receiver), AwardBadge and the badges
+// rules award: writes that would add a metric or badge past the cap fail with ErrLimitExceeded
+// (rule awards are skipped), while writes to metrics and badges the user already has still
+// succeed. The check reads the user's state first, so concurrent writes adding different new
+// keys may overshoot a cap slightly. ReplaceState, MergeUsers and imports are not limited.
+func WithUserLimits(l UserLimits) ServiceOption {
+    return func(g *GamifyService){ g.limits = l }
+}
+
+// checkMetricLimit fails if adding metric to state would exceed MaxMetrics
+func (g *GamifyService) checkMetricLimit(state core.UserState, user core.UserID, metric core.Metric) error {
+    max := g.limits.MaxMetrics
+    if max <= 0 { return nil }
+    metric, _, _ = core.SplitSeasonMetric(metric)
+    metrics := map[core.Metric]struct{}{}
+    for m := range state.Points {
+        base, _, _ := core.SplitSeasonMetric(m)
+        metrics[base] = struct{}{}
+    }
+    if _, held := metrics[metric]; held || len(metrics) < max { return nil }
+    return g.limitExceeded("metrics", user, fmt.Sprintf("user already has %d metrics", len(metrics)))
+}
+
+// checkBadgeLimit fails if awarding badge to user would exceed MaxBadges
+func (g *GamifyService) checkBadgeLimit(ctx context.Context, user core.UserID, badge core.Badge) error {
+    max := g.limits.MaxBadges
+    if max <= 0 { return nil }
+    state, err := g.storage.GetState(ctx, user)
+    if err != nil { return err }
+    if _, held := state.Badges[badge]; held || len(state.Badges) < max { return nil }
+    return g.limitExceeded("badges", user, fmt.Sprintf("user already has %d badges", len(state.Badges)))
+}
+
+func (g *GamifyService) limitExceeded(limit string, user core.UserID, detail string) error {
+    if g.limits.OnExceeded != nil { g.limits.OnExceeded(limit, user) }
+    return fmt.Errorf("%w: %s", ErrLimitExceeded, detail)
+}
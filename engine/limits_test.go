@@ -0,0 +1,64 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestUserLimits(t *testing.T) {
+    ctx := context.Background()
+    rejected := map[string]int{}
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s1"),
+        WithUserLimits(UserLimits{MaxMetrics: 3, MaxBadges: 2, OnExceeded: func(limit string, _ core.UserID){ rejected[limit]++ }}))
+
+    for i := 0; i < 3; i++ {
+        if _, err := svc.AddPoints(ctx, "alice", core.Metric(fmt.Sprintf("m%d", i)), 1); err != nil { t.Fatalf("metric %d: %v", i, err) }
+    }
+    // season ledgers count with their metric, so three metrics fit although six keys are stored
+    if _, err := svc.AddPoints(ctx, "alice", "m3", 1); !errors.Is(err, ErrLimitExceeded) { t.Fatalf("fourth metric: got %v, want ErrLimitExceeded", err) }
+    if _, err := svc.AddPoints(ctx, "alice", "m0", 5); err != nil { t.Fatalf("existing metric past the cap: %v", err) }
+    st, _ := svc.GetState(ctx, "alice")
+    if _, ok := st.Points["m3"]; ok { t.Fatal("rejected metric was stored") }
+
+    if _, err := svc.AddPoints(ctx, "bob", "m9", 5); err != nil { t.Fatal(err) }
+    if err := svc.Transfer(ctx, "bob", "alice", "m9", 1); !errors.Is(err, ErrLimitExceeded) { t.Fatalf("transfer creating a fourth metric: got %v", err) }
+
+    for _, b := range []core.Badge{"b1", "b2"} {
+        if err := svc.AwardBadge(ctx, "alice", b); err != nil { t.Fatalf("badge %s: %v", b, err) }
+    }
+    if err := svc.AwardBadge(ctx, "alice", "b3"); !errors.Is(err, ErrLimitExceeded) { t.Fatalf("third badge: got %v, want ErrLimitExceeded", err) }
+    if awarded, err := svc.AwardBadgeResult(ctx, "alice", "b1"); err != nil || awarded { t.Fatalf("held badge: %v, %v", awarded, err) }
+
+    if rejected["metrics"] != 2 || rejected["badges"] != 1 { t.Fatalf("rejections = %v, want 2 metrics and 1 badge", rejected) }
+}
+
+// badgePerTotal awards a badge named after every new total, stored by the service
+type badgePerTotal struct{}
+
+func (badgePerTotal) StoresBadges() bool { return true }
+
+func (badgePerTotal) Evaluate(_ context.Context, state core.UserState, ev core.Event) []core.Event {
+    return []core.Event{core.NewBadgeAwarded(state.UserID, core.Badge(fmt.Sprintf("rule-%d", ev.Total)))}
+}
+
+func TestUserLimitsSkipRuleAwards(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), badgePerTotal{}, WithUserLimits(UserLimits{MaxBadges: 1}))
+    if _, err := svc.AddPoints(ctx, "carol", core.MetricXP, 1); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "carol", core.MetricXP, 1); err != nil { t.Fatalf("a capped rule award must not fail the write: %v", err) }
+    if st, _ := svc.GetState(ctx, "carol"); len(st.Badges) != 1 { t.Fatalf("badges = %v, want only the first rule award", st.Badges) }
+}
+
+func TestUserLimitsUnlimitedByDefault(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine())
+    for i := 0; i < 50; i++ {
+        if _, err := svc.AddPoints(ctx, "dave", core.Metric(fmt.Sprintf("m%d", i)), 1); err != nil { t.Fatal(err) }
+        if err := svc.AwardBadge(ctx, "dave", core.Badge(fmt.Sprintf("b%d", i))); err != nil { t.Fatal(err) }
+    }
+}
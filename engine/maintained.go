@@ -0,0 +1,81 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "gamifykit/core"
+)
+
+// maintainedBadge is a badge that is only kept while holds accepts the user's state
+type maintainedBadge struct {
+    badge core.Badge
+    holds func(core.UserState) bool
+}
+
+// WithMaintainedBadge revokes badge as soon as pred stops accepting a holder's state (levels of
+// derived metrics already computed), e.g. a "top-10" badge after a rank drop. The predicate is
+// checked after every points change and transfer; call CheckMaintainedBadges after changes the
+// service does not see, such as other users overtaking this one. Revocation removes the badge
+// and publishes a core.EventBadgeRevoked once per true→false transition: users who do not hold
+// the badge are skipped, so awarding it again (by rules or AwardBadge) re-arms the check. The
+// check runs on the state after the rules' awards of the same write, and rules cannot award the
+// badge to a state pred rejects. The storage must implement BadgeRemover.
+func WithMaintainedBadge(badge core.Badge, pred func(core.UserState) bool) ServiceOption {
+    if pred == nil { panic("WithMaintainedBadge requires a predicate") }
+    return func(g *GamifyService){ g.maintained = append(g.maintained, maintainedBadge{badge: badge, holds: pred}) }
+}
+
+// CheckMaintainedBadges revokes the user's maintained badges whose predicate no longer holds.
+func (g *GamifyService) CheckMaintainedBadges(ctx context.Context, user core.UserID) error {
+    if len(g.maintained) == 0 { return nil }
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return err }
+    state, err := g.storage.GetState(ctx, normalized)
+    if err != nil { return err }
+    return g.revokeLapsed(ctx, normalized, g.view(state.AllTime()))
+}
+
+// applyRules publishes the rule output for trigger on the user's stored state and then revokes the
+// lapsed maintained badges of the state that output left behind. Awards of maintained badges the
+// state does not qualify for are dropped, so one write never both awards and revokes a badge.
+func (g *GamifyService) applyRules(ctx context.Context, user core.UserID, state core.UserState, trigger core.Event) error {
+    view := g.view(state.AllTime())
+    events := g.evaluateRules(ctx, view, trigger)
+    if len(g.maintained) == 0 {
+        g.publishDerived(ctx, events)
+        return nil
+    }
+    kept := events[:0:0]
+    for _, d := range events {
+        if (d.Type == core.EventBadgeAwarded || d.Type == core.EventAchievementUnlocked) && !g.qualifies(d.Badge, view) { continue }
+        kept = append(kept, d)
+    }
+    g.publishDerived(ctx, kept)
+    if len(kept) > 0 {
+        state, err := g.storage.GetState(ctx, user)
+        if err != nil { return err }
+        view = g.view(state.AllTime())
+    }
+    return g.revokeLapsed(ctx, user, view)
+}
+
+// qualifies reports whether state meets every maintained predicate of badge; other badges always qualify
+func (g *GamifyService) qualifies(badge core.Badge, state core.UserState) bool {
+    for _, m := range g.maintained {
+        if m.badge == badge && !m.holds(state) { return false }
+    }
+    return true
+}
+
+// revokeLapsed removes maintained badges that the user's state holds but no longer qualifies for
+func (g *GamifyService) revokeLapsed(ctx context.Context, user core.UserID, state core.UserState) error {
+    for _, m := range g.maintained {
+        if _, held := state.Badges[m.badge]; !held || m.holds(state) { continue }
+        removed, err := g.storage.(BadgeRemover).RemoveBadge(ctx, user, m.badge)
+        if err != nil { return fmt.Errorf("failed to revoke badge %s: %w", m.badge, err) }
+        // a concurrent check may have revoked it first
+        if removed { g.publish(ctx, core.NewBadgeRevoked(user, m.badge)) }
+    }
+    return nil
+}
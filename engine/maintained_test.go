@@ -0,0 +1,86 @@
+package engine
+
+import (
+    "context"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestMaintainedBadgeRevokedOnce(t *testing.T) {
+    ctx := context.Background()
+    rich := func(st core.UserState) bool { return st.Points[coins] >= 100 }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithMaintainedBadge("rich", rich))
+    var revoked []core.Event
+    svc.Subscribe(core.EventBadgeRevoked, func(ctx context.Context, e core.Event){ revoked = append(revoked, e) })
+
+    if _, err := svc.AddPoints(ctx, "alice", coins, 150); err != nil { t.Fatal(err) }
+    if err := svc.AwardBadge(ctx, "alice", "rich"); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", coins, -20); err != nil { t.Fatal(err) }
+    if len(revoked) != 0 { t.Fatalf("badge still holds, got %v", revoked) }
+
+    if _, err := svc.AddPoints(ctx, "alice", coins, -50); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", coins, -10); err != nil { t.Fatal(err) }
+    if len(revoked) != 1 || revoked[0].UserID != "alice" || revoked[0].Badge != "rich" { t.Fatalf("want one revocation, got %v", revoked) }
+    if st, _ := svc.GetState(ctx, "alice"); len(st.Badges) != 0 { t.Fatalf("badge should be removed, have %v", st.Badges) }
+
+    // earning it again re-arms the check
+    if _, err := svc.AddPoints(ctx, "alice", coins, 100); err != nil { t.Fatal(err) }
+    if err := svc.AwardBadge(ctx, "alice", "rich"); err != nil { t.Fatal(err) }
+    if err := svc.Transfer(ctx, "alice", "bob", coins, 80); err != nil { t.Fatal(err) }
+    if len(revoked) != 2 { t.Fatalf("want a second revocation after the transfer, got %v", revoked) }
+}
+
+func TestCheckMaintainedBadges(t *testing.T) {
+    ctx := context.Background()
+    rank := map[core.UserID]int{"alice": 3}
+    topTen := func(st core.UserState) bool { return rank[st.UserID] <= 10 }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithMaintainedBadge("top-10", topTen))
+    var revoked int
+    svc.Subscribe(core.EventBadgeRevoked, func(ctx context.Context, e core.Event){ revoked++ })
+    if err := svc.AwardBadge(ctx, "alice", "top-10"); err != nil { t.Fatal(err) }
+
+    if err := svc.CheckMaintainedBadges(ctx, "alice"); err != nil || revoked != 0 { t.Fatalf("rank 3 keeps the badge: %v %d", err, revoked) }
+    rank["alice"] = 11
+    for i := 0; i < 2; i++ {
+        if err := svc.CheckMaintainedBadges(ctx, "alice"); err != nil { t.Fatal(err) }
+    }
+    if revoked != 1 { t.Fatalf("want exactly one revocation, got %d", revoked) }
+}
+
+func TestMaintainedBadgeRequiresRemover(t *testing.T) {
+    defer func() {
+        if recover() == nil { t.Fatal("expected a panic for a storage without BadgeRemover") }
+    }()
+    NewGamifyService(plainStorage{mem.New()}, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithMaintainedBadge("rich", func(core.UserState) bool { return true }))
+}
+
+// richRule awards "rich" from 50 coins on, while the maintained predicate asks for 100
+type richRule struct{}
+
+func (richRule) StoresBadges() bool { return true }
+
+func (richRule) Evaluate(_ context.Context, state core.UserState, _ core.Event) []core.Event {
+    if _, held := state.Badges["rich"]; held || state.Points[coins] < 50 { return nil }
+    return []core.Event{core.NewBadgeAwarded(state.UserID, "rich")}
+}
+
+func TestMaintainedBadgeNotAwardedAndRevokedInOneWrite(t *testing.T) {
+    ctx := context.Background()
+    rich := func(st core.UserState) bool { return st.Points[coins] >= 100 }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), richRule{}, WithMaintainedBadge("rich", rich))
+    var events []core.EventType
+    svc.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event){ events = append(events, e.Type) })
+    svc.Subscribe(core.EventBadgeRevoked, func(ctx context.Context, e core.Event){ events = append(events, e.Type) })
+
+    // the rule fires, but the state does not qualify for the maintained badge: no award, no revocation
+    if _, err := svc.AddPoints(ctx, "alice", coins, 60); err != nil { t.Fatal(err) }
+    if len(events) != 0 { t.Fatalf("want no badge events, got %v", events) }
+
+    // once it qualifies, the award is checked against the state after it and kept
+    if _, err := svc.AddPoints(ctx, "alice", coins, 60); err != nil { t.Fatal(err) }
+    if len(events) != 1 || events[0] != core.EventBadgeAwarded { t.Fatalf("want one award, got %v", events) }
+    if st, _ := svc.GetState(ctx, "alice"); len(st.Badges) != 1 { t.Fatalf("badge should be kept, have %v", st.Badges) }
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// ErrMergeUnsupported is returned by MergeUsers on storages without UserMerger.
+var ErrMergeUnsupported = errors.New("storage does not support merging users")
+
+// UserMerger is implemented by storages that can merge one user into another atomically.
+type UserMerger interface {
+    // MergeUsers reads both users' states, overwrites the target's points, badges and levels with
+    // merge(source, target) like ReplaceState and deletes the source, all in one transaction; if
+    // merge fails nothing is written. The source's identity links move to the target. It returns
+    // the target's state before and after.
+    MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (before, after core.UserState, err error)
+}
+
+// MergeUsers merges the source user's state into the target's according to policy and deletes the
+// source, e.g. when a guest account is linked to a registered one; see core.MergePolicy. Merged
+// totals are checked against the metrics' value policies and nothing is written if one falls
+// outside (ErrValueOutOfRange). Self-merges fail with core.ErrSelfMerge, and storages without
+// UserMerger with ErrMergeUnsupported. User limits do not apply.
+//
+// The target gets the events of its resulting changes, each with the source in
+// Metadata["merged_from"]: core.EventPointsAdded per changed metric, core.EventBadgeAwarded per new
+// badge and core.EventLevelUp per raised level, followed by a core.EventUsersMerged. Rules are then
+// evaluated for the target, leaderboards are synced and the source is removed from them. Retrying
+// a merge that succeeded finds an empty source and changes nothing.
+func (g *GamifyService) MergeUsers(ctx context.Context, source, target core.UserID, policy core.MergePolicy) (err error) {
+    ctx, span := tracing.Start(ctx, "engine.MergeUsers")
+    span.SetUser(target)
+    span.SetAttr("source", string(source))
+    defer func(){ span.End(err) }()
+    source, err = core.NormalizeUserID(source)
+    if err != nil { return err }
+    target, err = core.NormalizeUserID(target)
+    if err != nil { return err }
+    if source == target { return core.ErrSelfMerge }
+    if err := policy.Validate(); err != nil { return err }
+    m, ok := g.storage.(UserMerger)
+    if !ok { return ErrMergeUnsupported }
+
+    var merged core.UserState
+    merge := func(src, dst core.UserState) (core.UserState, error) {
+        merged = src
+        next, err := policy.Merge(src, dst)
+        if err != nil { return core.UserState{}, err }
+        for metric, v := range next.Points {
+            if v == dst.Points[metric] { continue }
+            base, _, _ := core.SplitSeasonMetric(metric)
+            if p := g.valuePolicy(base); v < p.Min || v > p.Max {
+                return core.UserState{}, fmt.Errorf("%w: merged %s points %d not within [%d, %d]", ErrValueOutOfRange, metric, v, p.Min, p.Max)
+            }
+        }
+        return next, nil
+    }
+    unlock, err := g.lockUsers(ctx, source, target)
+    if err != nil { return err }
+    before, after, err := m.MergeUsers(ctx, source, target, merge)
+    unlock()
+    if err != nil { return err }
+    // an empty source, e.g. on a retry, leaves nothing to report
+    if len(merged.Points) == 0 && len(merged.Badges) == 0 && len(merged.Levels) == 0 { return nil }
+
+    if !g.projected { g.removeFromBoards(source) }
+    mergedFrom := func(ev core.Event) core.Event {
+        ev.Metadata = map[string]any{"merged_from": string(source)}
+        return ev
+    }
+    for metric, total := range after.Points {
+        previous := before.Points[metric]
+        if total == previous { continue }
+        base, season, seasonal := core.SplitSeasonMetric(metric)
+        if seasonal {
+            g.syncSeasonBoards(target, base, season, total)
+        } else {
+            g.syncBoards(ctx, target, metric, total)
+        }
+        g.publish(ctx, mergedFrom(core.NewPointsAdded(target, metric, total-previous, total)))
+        if !seasonal { g.levelChange(ctx, target, metric, previous, total) }
+    }
+    for badge := range after.Badges {
+        if _, held := before.Badges[badge]; held { continue }
+        g.publish(ctx, mergedFrom(core.NewBadgeAwarded(target, badge)))
+    }
+    for metric, level := range after.Levels {
+        if _, derived := g.derived[metric]; derived || level <= before.Levels[metric] { continue }
+        g.publish(ctx, mergedFrom(core.NewLevelUp(target, metric, level)))
+    }
+    ev := core.NewUsersMerged(target, source)
+    g.publish(ctx, ev)
+    if state, err := g.storage.GetState(ctx, target); err == nil {
+        _ = g.applyRules(ctx, target, state, ev)
+    }
+    return nil
+}
+
+// removeFromBoards takes a deleted user off every leaderboard, composite and season board
+func (g *GamifyService) removeFromBoards(user core.UserID) {
+    for _, boards := range g.boards {
+        for _, b := range boards { b.Board.Remove(user) }
+    }
+    for _, c := range g.composites { c.cfg.Board.Remove(user) }
+    g.seasons.mu.Lock(); defer g.seasons.mu.Unlock()
+    for _, sb := range g.seasons.boards {
+        for _, board := range sb.byName { board.Remove(user) }
+    }
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func TestMergeUsers(t *testing.T) {
+    ctx := context.Background()
+    for _, projected := range []bool{false, true} {
+        board := leaderboard.NewSkipList()
+        opts := []ServiceOption{WithLeaderboard(core.MetricXP, BoardConfig{Board: board})}
+        if projected { opts = append(opts, WithEventDrivenLeaderboards()) }
+        svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), opts...)
+        if _, err := svc.AddPoints(ctx, "guest", core.MetricXP, 40); err != nil { t.Fatal(err) }
+        if _, err := svc.AddPoints(ctx, "guest", coins, 7); err != nil { t.Fatal(err) }
+        if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 30); err != nil { t.Fatal(err) }
+        if _, err := svc.AddPoints(ctx, "alice", coins, 9); err != nil { t.Fatal(err) }
+        if err := svc.AwardBadge(ctx, "guest", "explorer"); err != nil { t.Fatal(err) }
+        var events []core.Event
+        svc.SubscribeAllNamed("test", func(ctx context.Context, e core.Event){ events = append(events, e) })
+
+        policy := core.MergePolicy{Metrics: map[core.Metric]core.PointsMerge{coins: core.MergeMax}}
+        if err := svc.MergeUsers(ctx, " Guest ", "alice", policy); err != nil { t.Fatalf("projected=%v: %v", projected, err) }
+        alice, _ := svc.GetState(ctx, "alice")
+        if alice.Points[core.MetricXP] != 70 || alice.Points[coins] != 9 {
+            t.Fatalf("projected=%v: merged points = %v, want xp summed to 70 and coins the max 9", projected, alice.Points)
+        }
+        if _, ok := alice.Badges["explorer"]; !ok { t.Fatalf("projected=%v: badges not united: %v", projected, alice.Badges) }
+        if exists, _ := svc.UserExists(ctx, "guest"); exists { t.Fatalf("projected=%v: source still exists", projected) }
+        if e, ok := board.Get("alice"); !ok || e.Score != 70 { t.Fatalf("projected=%v: target board entry = %v, %v", projected, e, ok) }
+        if _, ok := board.Get("guest"); ok { t.Fatalf("projected=%v: source left on the board", projected) }
+
+        var types []core.EventType
+        for _, e := range events {
+            types = append(types, e.Type)
+            if e.UserID != "alice" { t.Fatalf("projected=%v: event for %s, want only the target's", projected, e.UserID) }
+            if e.Type != core.EventUsersMerged && e.Metadata["merged_from"] != "guest" { t.Errorf("projected=%v: %s lacks merged_from", projected, e.Type) }
+        }
+        want := []core.EventType{core.EventPointsAdded, core.EventBadgeAwarded, core.EventUsersMerged}
+        if len(types) != len(want) || types[0] != want[0] || types[1] != want[1] || types[2] != want[2] {
+            t.Fatalf("projected=%v: events = %v, want %v", projected, types, want)
+        }
+        if events[0].Delta != 40 || events[0].Total != 70 || events[2].Metadata["source"] != "guest" {
+            t.Errorf("projected=%v: events = %+v", projected, events)
+        }
+
+        // a retry finds nothing to merge
+        if err := svc.MergeUsers(ctx, "guest", "alice", policy); err != nil { t.Fatal(err) }
+        if alice, _ := svc.GetState(ctx, "alice"); alice.Points[core.MetricXP] != 70 || len(events) != 3 {
+            t.Fatalf("projected=%v: retry changed xp to %d or published %d events", projected, alice.Points[core.MetricXP], len(events)-3)
+        }
+    }
+}
+
+func TestMergeUsersRejects(t *testing.T) {
+    ctx := context.Background()
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithValuePolicy(coins, NewValuePolicy(0, 10, OverflowClamp)))
+    if _, err := svc.AddPoints(ctx, "guest", coins, 6); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", coins, 6); err != nil { t.Fatal(err) }
+
+    if err := svc.MergeUsers(ctx, "guest", "alice", core.MergePolicy{}); !errors.Is(err, ErrValueOutOfRange) { t.Fatalf("sum above the policy max: %v", err) }
+    if guest, _ := svc.GetState(ctx, "guest"); guest.Points[coins] != 6 { t.Fatal("a rejected merge must not write") }
+    if err := svc.MergeUsers(ctx, "alice", "ALICE", core.MergePolicy{}); !errors.Is(err, core.ErrSelfMerge) { t.Fatalf("self-merge: %v", err) }
+    if err := svc.MergeUsers(ctx, "guest", "alice", core.MergePolicy{Points: "avg"}); err == nil { t.Fatal("unknown mode accepted") }
+    if err := svc.MergeUsers(ctx, "guest", "alice", core.MergePolicy{Points: core.MergeMax}); err != nil { t.Fatal(err) }
+
+    plain := NewGamifyService(plainStorage{store}, NewEventBus(DispatchSync), DefaultRuleEngine())
+    if err := plain.MergeUsers(ctx, "bob", "alice", core.MergePolicy{}); !errors.Is(err, ErrMergeUnsupported) { t.Fatalf("want ErrMergeUnsupported got %v", err) }
+}
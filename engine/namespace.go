@@ -0,0 +1,323 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+
+    "gamifykit/core"
+)
+
+// NamespacedStorage scopes s to the namespace carried by each call's context (see
+// core.WithNamespace): user "alice" in namespace "game1" is stored as "game1:alice", so tenants
+// sharing a storage never see each other's users. Calls without a valid namespace fail with
+// core.ErrNoNamespace or core.ErrInvalidNamespace instead of touching unscoped data.
+//
+// Transactions, user locks, existence checks, batch reads, atomic point updates and transfers, state
+// replacement, user merges, badge removal, repeatable badges, windowed points, user listing, badge
+// listing, identity mapping and quest progress are passed through when s supports them; identities
+// are scoped like users. User queries scan the namespace's users, since a query of s cannot be
+// limited to one namespace. Leaderboards and events are not
+// scoped; events carry the unscoped user ID. Wrapping a storage that is already namespaced returns
+// it as is.
+func NamespacedStorage(s Storage) Storage {
+    if _, ok := s.(*namespacedStorage); ok { return s }
+    return &namespacedStorage{inner: s}
+}
+
+type namespacedStorage struct{ inner Storage }
+
+// scope returns the storage key of user in the context's namespace
+func scope(ctx context.Context, user core.UserID) (core.UserID, error) {
+    ns, ok := core.NamespaceFromContext(ctx)
+    if !ok { return "", core.ErrNoNamespace }
+    if err := core.ValidateNamespace(ns); err != nil { return "", err }
+    return core.UserID(string(ns) + ":" + string(user)), nil
+}
+
+func (n *namespacedStorage) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return 0, err }
+    return n.inner.AddPoints(ctx, key, metric, delta)
+}
+
+func (n *namespacedStorage) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+    key, err := scope(ctx, user)
+    if err != nil { return err }
+    return n.inner.AwardBadge(ctx, key, badge)
+}
+
+// TryAwardBadge reports whether the badge was newly awarded, falling back to a state check.
+func (n *namespacedStorage) TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return false, err }
+    return tryAwardBadge(ctx, n.inner, key, badge)
+}
+
+func (n *namespacedStorage) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return core.UserState{}, err }
+    state, err := n.inner.GetState(ctx, key)
+    if err != nil { return state, err }
+    state.UserID = user
+    return state, nil
+}
+
+// GetStateMany loads the users in one batch when the inner storage is a StateBatchGetter.
+func (n *namespacedStorage) GetStateMany(ctx context.Context, users []core.UserID) (map[core.UserID]core.UserState, error) {
+    keys := make([]core.UserID, len(users))
+    for i, user := range users {
+        key, err := scope(ctx, user)
+        if err != nil { return nil, err }
+        keys[i] = key
+    }
+    scoped, err := getStateMany(ctx, n.inner, keys)
+    states := make(map[core.UserID]core.UserState, len(scoped))
+    for key, state := range scoped {
+        state.UserID = unscope(ctx, key)
+        states[state.UserID] = state
+    }
+    var batch *core.BatchError
+    if errors.As(err, &batch) {
+        failed := make(map[core.UserID]error, len(batch.Errors))
+        for key, uerr := range batch.Errors { failed[unscope(ctx, key)] = uerr }
+        return states, core.NewBatchError(failed)
+    }
+    return states, err
+}
+
+func (n *namespacedStorage) SetLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) error {
+    key, err := scope(ctx, user)
+    if err != nil { return err }
+    return n.inner.SetLevel(ctx, key, metric, level)
+}
+
+// RaiseLevel is atomic when the inner storage is a LevelRaiser; see raiseLevel.
+func (n *namespacedStorage) RaiseLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) (bool, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return false, err }
+    return raiseLevel(ctx, n.inner, key, metric, level)
+}
+
+// UpdatePoints is atomic when the inner storage is a PointsUpdater; see updatePoints.
+func (n *namespacedStorage) UpdatePoints(ctx context.Context, user core.UserID, metric core.Metric, fn func(int64) (int64, error)) (int64, int64, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return 0, 0, err }
+    return updatePoints(ctx, n.inner, key, metric, fn)
+}
+
+// TransferPoints is atomic when the inner storage is a PointsTransferer; see transferPoints.
+func (n *namespacedStorage) TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (int64, int64, error) {
+    fromKey, err := scope(ctx, from)
+    if err != nil { return 0, 0, err }
+    toKey, err := scope(ctx, to)
+    if err != nil { return 0, 0, err }
+    if t, ok := n.inner.(PointsTransferer); ok { return t.TransferPoints(ctx, fromKey, toKey, metric, amount, floor, ceiling) }
+    // through n, so errors name the users unscoped
+    return transferInTx(ctx, n, from, to, metric, amount, floor, ceiling)
+}
+
+// WithTx runs fn in a transaction of the inner storage when it has them; see RunInTx.
+func (n *namespacedStorage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+    return RunInTx(ctx, n.inner, func(tx Storage) error { return fn(&namespacedStorage{inner: tx}) })
+}
+
+// LockUser locks the user when the inner storage supports it and does nothing otherwise.
+func (n *namespacedStorage) LockUser(ctx context.Context, user core.UserID) error {
+    key, err := scope(ctx, user)
+    if err != nil { return err }
+    if l, ok := n.inner.(UserLocker); ok { return l.LockUser(ctx, key) }
+    return nil
+}
+
+// Exists falls back to checking for an empty state, like GamifyService.UserExists.
+func (n *namespacedStorage) Exists(ctx context.Context, user core.UserID) (bool, error) {
+    key, err := scope(ctx, user)
+    if err != nil { return false, err }
+    if e, ok := n.inner.(UserExister); ok { return e.Exists(ctx, key) }
+    state, err := n.inner.GetState(ctx, key)
+    if err != nil { return false, err }
+    return len(state.Points) > 0 || len(state.Badges) > 0 || len(state.Levels) > 0, nil
+}
+
+func (n *namespacedStorage) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error {
+    r, ok := n.inner.(StateReplacer)
+    if !ok { return ErrReplaceUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return err }
+    state.UserID = key
+    return r.ReplaceState(ctx, key, state)
+}
+
+func (n *namespacedStorage) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (core.UserState, core.UserState, error) {
+    m, ok := n.inner.(UserMerger)
+    if !ok { return core.UserState{}, core.UserState{}, ErrMergeUnsupported }
+    sourceKey, err := scope(ctx, source)
+    if err != nil { return core.UserState{}, core.UserState{}, err }
+    targetKey, err := scope(ctx, target)
+    if err != nil { return core.UserState{}, core.UserState{}, err }
+    before, after, err := m.MergeUsers(ctx, sourceKey, targetKey, merge)
+    before.UserID, after.UserID = target, target
+    return before, after, err
+}
+
+func (n *namespacedStorage) RemoveBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    r, ok := n.inner.(BadgeRemover)
+    if !ok { return false, ErrBadgeRemovalUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return false, err }
+    return r.RemoveBadge(ctx, key, badge)
+}
+
+func (n *namespacedStorage) PointsInWindow(ctx context.Context, user core.UserID, metric core.Metric, window time.Duration) (int64, error) {
+    w, ok := n.inner.(WindowedPoints)
+    if !ok { return 0, ErrWindowUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return 0, err }
+    return w.PointsInWindow(ctx, key, metric, window)
+}
+
+func (n *namespacedStorage) PointsPerPeriod(ctx context.Context, user core.UserID, metric core.Metric, period time.Duration, count int) ([]int64, error) {
+    p, ok := n.inner.(PeriodPoints)
+    if !ok { return nil, ErrWindowUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return nil, err }
+    return p.PointsPerPeriod(ctx, key, metric, period, count)
+}
+
+func (n *namespacedStorage) RepeatBadge(ctx context.Context, user core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (core.BadgeRecord, bool, error) {
+    r, ok := n.inner.(BadgeRepeater)
+    if !ok { return core.BadgeRecord{}, false, ErrRepeatUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return core.BadgeRecord{}, false, err }
+    return r.RepeatBadge(ctx, key, badge, now, cooldown)
+}
+
+func (n *namespacedStorage) BadgeRecord(ctx context.Context, user core.UserID, badge core.Badge) (core.BadgeRecord, error) {
+    r, ok := n.inner.(BadgeRepeater)
+    if !ok { return core.BadgeRecord{}, ErrRepeatUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return core.BadgeRecord{}, err }
+    return r.BadgeRecord(ctx, key, badge)
+}
+
+func (n *namespacedStorage) ListBadges(ctx context.Context, user core.UserID, filter core.BadgeFilter) ([]core.BadgeAward, error) {
+    l, ok := n.inner.(BadgeLister)
+    if !ok { return nil, ErrBadgeListingUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return nil, err }
+    return l.ListBadges(ctx, key, filter)
+}
+
+// EachUser lists the users of the context's namespace only.
+func (n *namespacedStorage) EachUser(ctx context.Context, fn func(core.UserID) error) error {
+    l, ok := n.inner.(UserLister)
+    if !ok { return ErrUserListingUnsupported }
+    prefix, err := scope(ctx, "")
+    if err != nil { return err }
+    return l.EachUser(ctx, func(key core.UserID) error {
+        if user, ok := strings.CutPrefix(string(key), string(prefix)); ok { return fn(core.UserID(user)) }
+        return nil
+    })
+}
+
+// LinkIdentity links the identity within the context's namespace, so tenants can use the same
+// external IDs for different users.
+func (n *namespacedStorage) LinkIdentity(ctx context.Context, identity string, user core.UserID) (core.UserID, error) {
+    m, ok := n.inner.(IdentityMapper)
+    if !ok { return "", ErrIdentityUnsupported }
+    key, err := scope(ctx, core.UserID(identity))
+    if err != nil { return "", err }
+    userKey, err := scope(ctx, user)
+    if err != nil { return "", err }
+    holder, err := m.LinkIdentity(ctx, string(key), userKey)
+    if err != nil { return "", err }
+    return unscope(ctx, holder), nil
+}
+
+func (n *namespacedStorage) UnlinkIdentity(ctx context.Context, identity string) (bool, error) {
+    m, ok := n.inner.(IdentityMapper)
+    if !ok { return false, ErrIdentityUnsupported }
+    key, err := scope(ctx, core.UserID(identity))
+    if err != nil { return false, err }
+    return m.UnlinkIdentity(ctx, string(key))
+}
+
+func (n *namespacedStorage) ResolveIdentity(ctx context.Context, identity string) (core.UserID, bool, error) {
+    m, ok := n.inner.(IdentityMapper)
+    if !ok { return "", false, ErrIdentityUnsupported }
+    key, err := scope(ctx, core.UserID(identity))
+    if err != nil { return "", false, err }
+    user, found, err := m.ResolveIdentity(ctx, string(key))
+    if err != nil || !found { return "", found, err }
+    return unscope(ctx, user), true, nil
+}
+
+func (n *namespacedStorage) Identities(ctx context.Context, user core.UserID) ([]string, error) {
+    m, ok := n.inner.(IdentityMapper)
+    if !ok { return nil, ErrIdentityUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return nil, err }
+    identities, err := m.Identities(ctx, key)
+    if err != nil { return nil, err }
+    for i, id := range identities { identities[i] = string(unscope(ctx, core.UserID(id))) }
+    return identities, nil
+}
+
+func (n *namespacedStorage) AdvanceQuest(ctx context.Context, user core.UserID, quest, period string, delta, target int64) (core.QuestProgress, int64, error) {
+    q, ok := n.inner.(QuestStore)
+    if !ok { return core.QuestProgress{}, 0, ErrQuestsUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return core.QuestProgress{}, 0, err }
+    return q.AdvanceQuest(ctx, key, quest, period, delta, target)
+}
+
+func (n *namespacedStorage) QuestProgress(ctx context.Context, user core.UserID) ([]core.QuestProgress, error) {
+    q, ok := n.inner.(QuestStore)
+    if !ok { return nil, ErrQuestsUnsupported }
+    key, err := scope(ctx, user)
+    if err != nil { return nil, err }
+    return q.QuestProgress(ctx, key)
+}
+
+// QueryUsers scans the namespace's users through EachUser; see namespaceScan.
+func (n *namespacedStorage) QueryUsers(ctx context.Context, filter core.UserFilter) ([]core.UserID, error) {
+    return queryUsers(ctx, namespaceScan{Storage: n, UserLister: n}, filter)
+}
+
+// namespaceScan hides the UserQuerier of a namespacedStorage, so queryUsers scans it
+type namespaceScan struct {
+    Storage
+    UserLister
+}
+
+// unscope strips the context's namespace from a storage key; the namespace was validated by scope
+func unscope(ctx context.Context, key core.UserID) core.UserID {
+    ns, _ := core.NamespaceFromContext(ctx)
+    return core.UserID(strings.TrimPrefix(string(key), string(ns)+":"))
+}
+
+func (n *namespacedStorage) unwrapStorage() Storage { return n.inner }
+
+var (
+    _ Txner            = (*namespacedStorage)(nil)
+    _ UserLocker       = (*namespacedStorage)(nil)
+    _ PointsUpdater    = (*namespacedStorage)(nil)
+    _ LevelRaiser      = (*namespacedStorage)(nil)
+    _ PointsTransferer = (*namespacedStorage)(nil)
+    _ UserExister      = (*namespacedStorage)(nil)
+    _ StateBatchGetter = (*namespacedStorage)(nil)
+    _ StateReplacer    = (*namespacedStorage)(nil)
+    _ UserMerger       = (*namespacedStorage)(nil)
+    _ BadgeRemover     = (*namespacedStorage)(nil)
+    _ BadgeAwarder     = (*namespacedStorage)(nil)
+    _ BadgeRepeater    = (*namespacedStorage)(nil)
+    _ WindowedPoints   = (*namespacedStorage)(nil)
+    _ PeriodPoints     = (*namespacedStorage)(nil)
+    _ UserLister       = (*namespacedStorage)(nil)
+    _ UserQuerier      = (*namespacedStorage)(nil)
+    _ BadgeLister      = (*namespacedStorage)(nil)
+    _ IdentityMapper   = (*namespacedStorage)(nil)
+    _ QuestStore       = (*namespacedStorage)(nil)
+)
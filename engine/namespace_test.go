@@ -0,0 +1,32 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestNamespacedStorage(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(NamespacedStorage(store), NewEventBus(DispatchSync), DefaultRuleEngine())
+    g1 := core.WithNamespace(context.Background(), "g1")
+    g2 := core.WithNamespace(context.Background(), "g2")
+
+    if _, err := svc.AddPoints(context.Background(), "alice", core.MetricXP, 5); !errors.Is(err, core.ErrNoNamespace) { t.Fatalf("want ErrNoNamespace, got %v", err) }
+    if _, err := svc.GetState(core.WithNamespace(context.Background(), "no:colons"), "alice"); !errors.Is(err, core.ErrInvalidNamespace) { t.Fatalf("want ErrInvalidNamespace, got %v", err) }
+
+    if _, err := svc.AddPoints(g1, "alice", core.MetricXP, 5); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(g2, "bob", core.MetricXP, 7); err != nil { t.Fatal(err) }
+    st, err := svc.GetState(g1, "alice")
+    if err != nil || st.UserID != "alice" || st.Points[core.MetricXP] != 5 { t.Fatalf("g1 alice: %+v %v", st, err) }
+    var users []core.UserID
+    err = NamespacedStorage(store).(UserLister).EachUser(g2, func(u core.UserID) error { users = append(users, u); return nil })
+    if err != nil || len(users) != 1 || users[0] != "bob" { t.Fatalf("g2 users: %v %v", users, err) }
+    if st, _ := svc.GetState(g2, "alice"); len(st.Points) != 0 { t.Fatalf("alice leaked into g2: %+v", st) }
+    if raw, _ := store.GetState(context.Background(), "g1:alice"); raw.Points[core.MetricXP] != 5 { t.Fatalf("unexpected storage key layout: %+v", raw) }
+    // wrapping twice scopes once
+    if st, _ := NamespacedStorage(NamespacedStorage(store)).GetState(g1, "alice"); st.Points[core.MetricXP] != 5 { t.Fatalf("double wrap: %+v", st) }
+}
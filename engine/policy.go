@@ -0,0 +1,80 @@
+package engine
+
+import (
+    "errors"
+    "fmt"
+    "math"
+
+    "gamifykit/core"
+)
+
+// ErrValueOutOfRange is returned when a points update violates a metric's ValuePolicy.
+var ErrValueOutOfRange = errors.New("value out of range")
+
+// OverflowMode selects how a ValuePolicy reacts to results outside its bounds.
+type OverflowMode int
+
+const (
+    // OverflowError rejects any update whose result falls outside [Min, Max].
+    OverflowError OverflowMode = iota
+    // OverflowClamp pins out-of-range results to the nearest bound.
+    OverflowClamp
+    // OverflowSaturate pins results at the int64 limits when the arithmetic overflows,
+    // but still rejects results that merely fall outside [Min, Max].
+    OverflowSaturate
+)
+
+// ValuePolicy constrains the totals a metric may hold. Min and Max are inclusive.
+// Build policies with NewValuePolicy or DefaultValuePolicy; the zero value only admits 0.
+type ValuePolicy struct {
+    Min  int64
+    Max  int64
+    Mode OverflowMode
+}
+
+// NewValuePolicy returns a policy bounded to [min, max] using the given mode.
+func NewValuePolicy(min, max int64, mode OverflowMode) ValuePolicy {
+    return ValuePolicy{Min: min, Max: max, Mode: mode}
+}
+
+// DefaultValuePolicy spans the full int64 range and errors on overflow.
+func DefaultValuePolicy() ValuePolicy {
+    return ValuePolicy{Min: math.MinInt64, Max: math.MaxInt64, Mode: OverflowError}
+}
+
+// Validate reports whether the policy is internally consistent.
+func (p ValuePolicy) Validate() error {
+    if p.Min > p.Max {
+        return fmt.Errorf("value policy min %d exceeds max %d", p.Min, p.Max)
+    }
+    switch p.Mode {
+    case OverflowError, OverflowClamp, OverflowSaturate:
+        return nil
+    default:
+        return fmt.Errorf("unknown overflow mode %d", p.Mode)
+    }
+}
+
+// Apply computes the total resulting from adding delta to current under the policy.
+func (p ValuePolicy) Apply(current, delta int64) (int64, error) {
+    next, err := core.AddSafe(current, delta)
+    if err != nil {
+        switch p.Mode {
+        case OverflowClamp:
+            if delta > 0 { return p.Max, nil }
+            return p.Min, nil
+        case OverflowSaturate:
+            if delta > 0 { next = math.MaxInt64 } else { next = math.MinInt64 }
+        default:
+            return 0, fmt.Errorf("%w: %v", ErrValueOutOfRange, err)
+        }
+    }
+    if next >= p.Min && next <= p.Max {
+        return next, nil
+    }
+    if p.Mode == OverflowClamp {
+        if next > p.Max { return p.Max, nil }
+        return p.Min, nil
+    }
+    return 0, fmt.Errorf("%w: %d not within [%d, %d]", ErrValueOutOfRange, next, p.Min, p.Max)
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "math"
+    "path/filepath"
+    "sync"
+    "testing"
+
+    "gamifykit/adapters/jsonfile"
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestValuePolicyApply(t *testing.T) {
+    cases := []struct {
+        name    string
+        policy  ValuePolicy
+        current int64
+        delta   int64
+        want    int64
+        wantErr bool
+    }{
+        {"default within range", DefaultValuePolicy(), 10, 5, 15, false},
+        {"default overflow", DefaultValuePolicy(), math.MaxInt64, 1, 0, true},
+        {"error above max", NewValuePolicy(0, 100, OverflowError), 90, 20, 0, true},
+        {"error below min", NewValuePolicy(0, 100, OverflowError), 10, -20, 0, true},
+        {"clamp above max", NewValuePolicy(0, 100, OverflowClamp), 90, 20, 100, false},
+        {"clamp below min", NewValuePolicy(0, 100, OverflowClamp), 10, -20, 0, false},
+        {"clamp overflow", NewValuePolicy(math.MinInt64, math.MaxInt64, OverflowClamp), math.MaxInt64, 1, math.MaxInt64, false},
+        {"saturate overflow", NewValuePolicy(math.MinInt64, math.MaxInt64, OverflowSaturate), math.MaxInt64, 1, math.MaxInt64, false},
+        {"saturate underflow", NewValuePolicy(math.MinInt64, math.MaxInt64, OverflowSaturate), math.MinInt64, -1, math.MinInt64, false},
+        {"saturate respects bounds", NewValuePolicy(0, 100, OverflowSaturate), 90, 20, 0, true},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got, err := tc.policy.Apply(tc.current, tc.delta)
+            if tc.wantErr {
+                if !errors.Is(err, ErrValueOutOfRange) { t.Fatalf("want ErrValueOutOfRange, got %v", err) }
+                return
+            }
+            if err != nil || got != tc.want { t.Fatalf("got %v %v, want %v", got, err, tc.want) }
+        })
+    }
+}
+
+func TestValuePolicyValidate(t *testing.T) {
+    if err := NewValuePolicy(10, 0, OverflowError).Validate(); err == nil { t.Fatal("expected error for min > max") }
+    if err := NewValuePolicy(0, 10, OverflowMode(42)).Validate(); err == nil { t.Fatal("expected error for unknown mode") }
+    if err := DefaultValuePolicy().Validate(); err != nil { t.Fatal(err) }
+}
+
+// The same policy must produce the same totals regardless of the storage adapter.
+func TestValuePolicyAcrossAdapters(t *testing.T) {
+    fileStore, err := jsonfile.New(filepath.Join(t.TempDir(), "state.json"))
+    if err != nil { t.Fatal(err) }
+    stores := map[string]Storage{"memory": mem.New(), "jsonfile": fileStore}
+
+    for name, store := range stores {
+        t.Run(name, func(t *testing.T) {
+            ctx := context.Background()
+            svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(),
+                WithValuePolicy(core.MetricPoints, NewValuePolicy(0, 100, OverflowClamp)),
+                WithValuePolicy("coins", NewValuePolicy(0, 50, OverflowError)),
+            )
+
+            var deltas []int64
+            svc.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ deltas = append(deltas, e.Delta) })
+
+            if total, err := svc.AddPoints(ctx, "u", core.MetricPoints, 150); err != nil || total != 100 { t.Fatalf("clamp up: %v %v", total, err) }
+            if total, err := svc.AddPoints(ctx, "u", core.MetricPoints, 10); err != nil || total != 100 { t.Fatalf("clamp at max: %v %v", total, err) }
+            if total, err := svc.AddPoints(ctx, "u", core.MetricPoints, -500); err != nil || total != 0 { t.Fatalf("clamp down: %v %v", total, err) }
+            if len(deltas) != 2 || deltas[0] != 100 || deltas[1] != -100 { t.Fatalf("unexpected event deltas %v", deltas) }
+
+            if _, err := svc.AddPoints(ctx, "u", "coins", 60); !errors.Is(err, ErrValueOutOfRange) { t.Fatalf("want range error, got %v", err) }
+            st, _ := svc.GetState(ctx, "u")
+            if st.Points["coins"] != 0 { t.Fatalf("rejected update must not be stored, got %d", st.Points["coins"]) }
+        })
+    }
+}
+
+// Concurrent adds must not overshoot a bound checked against a stale snapshot.
+func TestValuePolicyConcurrentAdds(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithValuePolicy(core.MetricPoints, NewValuePolicy(0, 100, OverflowClamp)))
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func(){
+            defer wg.Done()
+            for j := 0; j < 10; j++ { _, _ = svc.AddPoints(ctx, "u", core.MetricPoints, 7) }
+        }()
+    }
+    wg.Wait()
+    st, _ := svc.GetState(ctx, "u")
+    if st.Points[core.MetricPoints] != 100 { t.Fatalf("total %d, want the cap of 100", st.Points[core.MetricPoints]) }
+}
+
+func TestNewGamifyServiceRejectsInvalidPolicy(t *testing.T) {
+    defer func() {
+        if recover() == nil { t.Fatal("expected panic for invalid policy") }
+    }()
+    NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithValuePolicy(core.MetricXP, NewValuePolicy(1, 0, OverflowError)))
+}
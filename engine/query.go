@@ -0,0 +1,94 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+const (
+    // DefaultQueryLimit is the page size of QueryUsers for filters without a limit.
+    DefaultQueryLimit = 100
+    // MaxQueryLimit caps the page size of QueryUsers.
+    MaxQueryLimit = 1000
+    // DefaultBadgeListLimit is the page size of ListBadges for filters without a limit.
+    DefaultBadgeListLimit = 50
+    // MaxBadgeListLimit caps the page size of ListBadges.
+    MaxBadgeListLimit = 500
+)
+
+var (
+    // ErrQueryUnsupported is returned by QueryUsers on storages implementing neither UserQuerier nor UserLister.
+    ErrQueryUnsupported = errors.New("storage does not support querying users")
+    // ErrBadgeListingUnsupported is returned by ListBadges on storages without BadgeLister.
+    ErrBadgeListingUnsupported = errors.New("storage does not support listing badge awards")
+)
+
+// UserQuerier is implemented by storages that can select users by a core.UserFilter without
+// loading every user, e.g. with indexed SQL queries. Results are sorted by user ID, start after
+// filter.After and hold at most filter.Limit users (all when zero).
+type UserQuerier interface {
+    QueryUsers(ctx context.Context, filter core.UserFilter) ([]core.UserID, error)
+}
+
+// BadgeLister is implemented by storages that keep when each badge was awarded. Results are in
+// core.BadgeAward.Precedes order (newest first), start after filter.After, include only awards at or
+// after filter.Since and hold at most filter.Limit awards (all when zero).
+type BadgeLister interface {
+    ListBadges(ctx context.Context, user core.UserID, filter core.BadgeFilter) ([]core.BadgeAward, error)
+}
+
+// QueryUsers returns the users matching filter, e.g. the holders of a badge or everyone above
+// 1000 XP, sorted by user ID. A page holds filter.Limit users (DefaultQueryLimit when zero, at most
+// MaxQueryLimit); pass the last ID as filter.After for the next page. Level conditions compare
+// stored levels, so derived levels never match. Storages without UserQuerier are scanned through
+// UserLister, which loads every user.
+func (g *GamifyService) QueryUsers(ctx context.Context, filter core.UserFilter) (_ []core.UserID, err error) {
+    ctx, span := tracing.Start(ctx, "engine.QueryUsers")
+    defer func(){ span.End(err) }()
+    if err := filter.Validate(); err != nil { return nil, err }
+    if filter.Limit == 0 { filter.Limit = DefaultQueryLimit }
+    if filter.Limit > MaxQueryLimit { return nil, fmt.Errorf("%w: limit %d above %d", core.ErrInvalidFilter, filter.Limit, MaxQueryLimit) }
+    return queryUsers(ctx, g.storage, filter)
+}
+
+// queryUsers is UserQuerier.QueryUsers on any storage: others are scanned through UserLister
+func queryUsers(ctx context.Context, s Storage, filter core.UserFilter) ([]core.UserID, error) {
+    if q, ok := s.(UserQuerier); ok { return q.QueryUsers(ctx, filter) }
+    lister, ok := s.(UserLister)
+    if !ok { return nil, ErrQueryUnsupported }
+    var users []core.UserID
+    err := lister.EachUser(ctx, func(user core.UserID) error {
+        if filter.After != "" && user <= filter.After { return nil }
+        state, err := s.GetState(ctx, user)
+        if err != nil { return fmt.Errorf("failed to load user %s: %w", user, err) }
+        if filter.Matches(state) { users = append(users, user) }
+        return nil
+    })
+    if err != nil { return nil, err }
+    sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+    if len(users) > filter.Limit { users = users[:filter.Limit] }
+    return users, nil
+}
+
+// ListBadges returns a page of the badges user holds with their award times, newest first, for
+// users with too many badges to send in every GetState. A page holds filter.Limit awards
+// (DefaultBadgeListLimit when zero, at most MaxBadgeListLimit); pass the last award as filter.After
+// for the next page.
+func (g *GamifyService) ListBadges(ctx context.Context, user core.UserID, filter core.BadgeFilter) (_ []core.BadgeAward, err error) {
+    ctx, span := tracing.Start(ctx, "engine.ListBadges")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return nil, err }
+    if err := filter.Validate(); err != nil { return nil, err }
+    if filter.Limit == 0 { filter.Limit = DefaultBadgeListLimit }
+    if filter.Limit > MaxBadgeListLimit { return nil, fmt.Errorf("%w: limit %d above %d", core.ErrInvalidBadgeFilter, filter.Limit, MaxBadgeListLimit) }
+    l, ok := g.storage.(BadgeLister)
+    if !ok { return nil, ErrBadgeListingUnsupported }
+    return l.ListBadges(ctx, normalized, filter)
+}
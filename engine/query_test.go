@@ -0,0 +1,54 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestQueryUsersScansWithoutQuerier(t *testing.T) {
+    // namespaced storage has no UserQuerier, so the service scans the namespace's users
+    svc := NewGamifyService(NamespacedStorage(mem.New()), NewEventBus(DispatchSync), DefaultRuleEngine())
+    game1, game2 := core.WithNamespace(context.Background(), "game1"), core.WithNamespace(context.Background(), "game2")
+    for i, user := range []core.UserID{"dave", "alice", "carol", "bob"} {
+        if _, err := svc.AddPoints(game1, user, core.MetricXP, int64(i+1)*500); err != nil { t.Fatal(err) }
+    }
+    if _, err := svc.AddPoints(game2, "erin", core.MetricXP, 5000); err != nil { t.Fatal(err) }
+
+    floor := int64(1000)
+    users, err := svc.QueryUsers(game1, core.UserFilter{Metric: core.MetricXP, MinPoints: &floor, Limit: 2})
+    if err != nil || fmt.Sprint(users) != "[alice bob]" { t.Fatalf("first page = %v, %v; want [alice bob]", users, err) }
+    users, err = svc.QueryUsers(game1, core.UserFilter{Metric: core.MetricXP, MinPoints: &floor, Limit: 2, After: "bob"})
+    if err != nil || fmt.Sprint(users) != "[carol]" { t.Fatalf("second page = %v, %v; want [carol]", users, err) }
+
+    if _, err := svc.QueryUsers(game1, core.UserFilter{MinPoints: &floor}); !errors.Is(err, core.ErrInvalidFilter) { t.Fatalf("points without metric: %v", err) }
+    if _, err := svc.QueryUsers(game1, core.UserFilter{Limit: MaxQueryLimit + 1}); !errors.Is(err, core.ErrInvalidFilter) { t.Fatalf("oversized page: %v", err) }
+}
+
+func TestListBadgesNewestFirst(t *testing.T) {
+    clock := core.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+    store := mem.New()
+    store.SetClock(clock.Now)
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine())
+    ctx := context.Background()
+    for _, b := range []core.Badge{"early", "middle", "late"} {
+        if err := svc.AwardBadge(ctx, "alice", b); err != nil { t.Fatal(err) }
+        clock.Advance(time.Hour)
+    }
+
+    page, err := svc.ListBadges(ctx, "Alice", core.BadgeFilter{Limit: 2})
+    if err != nil || len(page) != 2 || page[0].Badge != "late" || page[1].Badge != "middle" { t.Fatalf("first page = %v, %v; want late, middle", page, err) }
+    if want := clock.Now().Add(-time.Hour); !page[0].AwardedAt.Equal(want) { t.Errorf("late awarded at %s, want %s", page[0].AwardedAt, want) }
+    page, err = svc.ListBadges(ctx, "alice", core.BadgeFilter{Limit: 2, After: &page[1]})
+    if err != nil || len(page) != 1 || page[0].Badge != "early" { t.Fatalf("second page = %v, %v; want early", page, err) }
+    page, err = svc.ListBadges(ctx, "alice", core.BadgeFilter{Since: clock.Now().Add(-2 * time.Hour)})
+    if err != nil || len(page) != 2 { t.Fatalf("since two hours ago = %v, %v; want 2 awards", page, err) }
+
+    if _, err := svc.ListBadges(ctx, "alice", core.BadgeFilter{Limit: MaxBadgeListLimit + 1}); !errors.Is(err, core.ErrInvalidBadgeFilter) { t.Fatalf("oversized page: %v", err) }
+    if _, err := svc.ListBadges(ctx, "alice", core.BadgeFilter{Limit: -1}); !errors.Is(err, core.ErrInvalidBadgeFilter) { t.Fatalf("negative limit: %v", err) }
+}
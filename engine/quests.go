@@ -0,0 +1,156 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// ErrQuestsUnsupported is returned by GetQuests on storages without QuestStore.
+var ErrQuestsUnsupported = errors.New("storage does not support quests")
+
+// Quest is a structured goal: earn Target points of Metric, e.g. 500 XP this week or 3 won matches,
+// counted from the moment the quest is registered. Once the target is reached the quest is
+// completed, publishing core.EventQuestCompleted and granting the reward, until Reset starts it
+// afresh.
+type Quest struct {
+    ID     string
+    Name   string
+    Metric core.Metric
+    Target int64
+    Reset  core.QuestReset
+    // RewardBadge and RewardPoints of RewardMetric (the quest's metric when empty) are awarded on
+    // completion through AwardBadge and AddPoints, so limits, rules and leaderboards apply.
+    RewardBadge  core.Badge
+    RewardMetric core.Metric
+    RewardPoints int64
+}
+
+// Validate checks the quest's ID, target, reset and reward.
+func (q Quest) Validate() error {
+    switch {
+    case q.ID == "" || strings.TrimSpace(q.ID) != q.ID:
+        return errors.New("quest id must be non-empty without surrounding whitespace")
+    case q.Metric == "":
+        return fmt.Errorf("quest %s: metric is required", q.ID)
+    case q.Target <= 0:
+        return fmt.Errorf("quest %s: target must be positive, got %d", q.ID, q.Target)
+    case q.RewardPoints < 0:
+        return fmt.Errorf("quest %s: reward points cannot be negative", q.ID)
+    }
+    if q.RewardBadge != "" {
+        if err := core.ValidateBadgeID(q.RewardBadge); err != nil { return fmt.Errorf("quest %s: reward badge: %w", q.ID, err) }
+    }
+    if err := q.Reset.Validate(); err != nil { return fmt.Errorf("quest %s: %w", q.ID, err) }
+    return nil
+}
+
+// QuestStore is implemented by storages that keep per-user quest progress, one record per user and
+// quest holding the progress of the latest period.
+type QuestStore interface {
+    // AdvanceQuest adds delta to the user's progress on quest in period, starting from zero when the
+    // stored progress belongs to another period, and caps it at target, setting CompletedAt when
+    // it gets there. It returns the new progress and the progress before, atomically, so exactly
+    // one of several concurrent calls sees the target reached.
+    AdvanceQuest(ctx context.Context, user core.UserID, quest, period string, delta, target int64) (p core.QuestProgress, before int64, err error)
+    // QuestProgress returns the user's stored progress records, of any period.
+    QuestProgress(ctx context.Context, user core.UserID) ([]core.QuestProgress, error)
+}
+
+// QuestSource is implemented by rule engines that define quests, such as a RuleSet compiled from a
+// configuration with "quests". They are consulted on every write, so a swapped-in rule engine's
+// quests apply at once.
+type QuestSource interface {
+    Quests() []Quest
+}
+
+// WithQuest registers a quest. Points written for its metric count towards it on storages
+// implementing QuestStore. It panics on invalid quests.
+func WithQuest(q Quest) ServiceOption {
+    if err := q.Validate(); err != nil { panic("WithQuest: " + err.Error()) }
+    return func(g *GamifyService){ g.quests = append(g.quests, q) }
+}
+
+// Quests returns the registered quests and those of the rule engine, sorted by ID. Quests
+// registered with WithQuest take precedence over rule engine quests of the same ID.
+func (g *GamifyService) Quests() []Quest {
+    byID := map[string]Quest{}
+    if s, ok := g.rules.(QuestSource); ok {
+        for _, q := range s.Quests() { byID[q.ID] = q }
+    }
+    for _, q := range g.quests { byID[q.ID] = q }
+    quests := make([]Quest, 0, len(byID))
+    for _, q := range byID { quests = append(quests, q) }
+    sort.Slice(quests, func(i, j int) bool { return quests[i].ID < quests[j].ID })
+    return quests
+}
+
+// GetQuests returns the user's progress on every quest in its current period, sorted by quest ID.
+// Quests the user has not started show zero progress.
+func (g *GamifyService) GetQuests(ctx context.Context, user core.UserID) (_ []core.QuestProgress, err error) {
+    ctx, span := tracing.Start(ctx, "engine.GetQuests")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return nil, err }
+    store, ok := g.storage.(QuestStore)
+    if !ok { return nil, ErrQuestsUnsupported }
+    stored, err := store.QuestProgress(ctx, normalized)
+    if err != nil { return nil, err }
+    byQuest := make(map[string]core.QuestProgress, len(stored))
+    for _, p := range stored { byQuest[p.Quest] = p }
+
+    now := core.CurrentTime()
+    quests := g.Quests()
+    progress := make([]core.QuestProgress, 0, len(quests))
+    for _, q := range quests {
+        period := q.Reset.Period(now)
+        p, ok := byQuest[q.ID]
+        if !ok || p.Period != period { p = core.QuestProgress{Quest: q.ID, Period: period} }
+        p.Target = q.Target
+        p.Completed = p.Progress >= q.Target
+        if !p.Completed { p.CompletedAt = nil }
+        if next := q.Reset.Next(now); !next.IsZero() { p.ResetsAt = &next }
+        progress = append(progress, p)
+    }
+    return progress, nil
+}
+
+// advanceQuests counts delta points of metric towards the user's quests on that metric, publishing
+// progress and completions and granting rewards. Failures to record progress are skipped, like
+// other side effects of a write that already succeeded.
+func (g *GamifyService) advanceQuests(ctx context.Context, user core.UserID, metric core.Metric, delta int64) {
+    if delta <= 0 { return }
+    store, ok := g.storage.(QuestStore)
+    if !ok { return }
+    now := core.CurrentTime()
+    for _, q := range g.Quests() {
+        if q.Metric != metric { continue }
+        p, before, err := store.AdvanceQuest(ctx, user, q.ID, q.Reset.Period(now), delta, q.Target)
+        // quests completed in this period already are left alone
+        if err != nil || p.Progress == before { continue }
+        p.Target = q.Target
+        g.publish(ctx, core.NewQuestProgress(user, metric, p, p.Progress-before))
+        if p.Progress >= q.Target { g.completeQuest(ctx, user, q, p) }
+    }
+}
+
+// completeQuest publishes a completion, grants the quest's reward and lets rules react to it
+func (g *GamifyService) completeQuest(ctx context.Context, user core.UserID, q Quest, p core.QuestProgress) {
+    ev := core.NewQuestCompleted(user, q.Metric, p)
+    g.publish(ctx, ev)
+    if q.RewardBadge != "" { _, _ = g.AwardBadgeResult(ctx, user, q.RewardBadge) }
+    if q.RewardPoints > 0 {
+        metric := q.RewardMetric
+        if metric == "" { metric = q.Metric }
+        _, _ = g.AddPoints(ctx, user, metric, q.RewardPoints)
+    }
+    if state, err := g.storage.GetState(ctx, user); err == nil {
+        g.publishDerived(ctx, g.evaluateRules(ctx, g.view(state.AllTime()), ev))
+    }
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestQuests(t *testing.T) {
+    clock := core.NewFakeClock(time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC)) // a Wednesday
+    defer core.SetClock(core.SetClock(clock.Now))
+    bus := NewEventBus(DispatchSync)
+    var progress []core.Event
+    completed := 0
+    bus.Subscribe(core.EventQuestProgress, func(ctx context.Context, e core.Event){ progress = append(progress, e) })
+    bus.Subscribe(core.EventQuestCompleted, func(ctx context.Context, e core.Event){ completed++ })
+    svc := NewGamifyService(mem.New(), bus, DefaultRuleEngine(),
+        WithQuest(Quest{ID: "weekly-xp", Metric: core.MetricXP, Target: 100, Reset: core.QuestWeekly, RewardBadge: "grinder", RewardMetric: "coins", RewardPoints: 10}))
+    ctx := context.Background()
+
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 60); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 60); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 60); err != nil { t.Fatal(err) }
+    if len(progress) != 2 || progress[0].Delta != 60 || progress[1].Delta != 40 || progress[1].Total != 100 {
+        t.Fatalf("progress events = %+v; want +60 then +40 up to 100", progress)
+    }
+    if progress[1].Metadata["quest"] != "weekly-xp" || progress[1].Metadata["period"] != "2024-W10" { t.Fatalf("progress metadata = %v", progress[1].Metadata) }
+    if completed != 1 { t.Fatalf("%d completions, want 1", completed) }
+    state, _ := svc.GetState(ctx, "alice")
+    if _, ok := state.Badges["grinder"]; !ok || state.Points["coins"] != 10 { t.Fatalf("reward not granted: %+v", state) }
+
+    quests, err := svc.GetQuests(ctx, "alice")
+    if err != nil || len(quests) != 1 { t.Fatalf("GetQuests = %+v, %v", quests, err) }
+    q := quests[0]
+    if q.Progress != 100 || !q.Completed || q.CompletedAt == nil || q.ResetsAt == nil || !q.ResetsAt.Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("quest = %+v; want completed, resetting on Monday", q)
+    }
+
+    clock.Advance(7 * 24 * time.Hour)
+    if quests, _ := svc.GetQuests(ctx, "alice"); quests[0].Progress != 0 || quests[0].Completed || quests[0].Period != "2024-W11" {
+        t.Fatalf("quest in the next week = %+v; want reset", quests[0])
+    }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 25); err != nil { t.Fatal(err) }
+    if quests, _ := svc.GetQuests(ctx, "alice"); quests[0].Progress != 25 { t.Fatalf("progress after reset = %d, want 25", quests[0].Progress) }
+}
+
+func TestQuestsFromRuleSet(t *testing.T) {
+    rs, err := CompileRules([]byte(`{
+        "metrics": ["xp"],
+        "quests": [{"id": "first-steps", "metric": "xp", "target": 10, "reset": "none", "reward_badge": "rookie"}],
+        "achievements": [{"name": "questing", "badge": "adventurer", "when": {"badge": "rookie"}}]
+    }`))
+    if err != nil { t.Fatal(err) }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), rs, WithQuest(Quest{ID: "daily-xp", Metric: core.MetricXP, Target: 5, Reset: core.QuestDaily}))
+    if quests := svc.Quests(); len(quests) != 2 || quests[0].ID != "daily-xp" || quests[1].ID != "first-steps" { t.Fatalf("Quests = %+v", quests) }
+
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "bob", core.MetricXP, 10); err != nil { t.Fatal(err) }
+    state, _ := svc.GetState(ctx, "bob")
+    for _, b := range []core.Badge{"rookie", "adventurer"} {
+        if _, ok := state.Badges[b]; !ok { t.Errorf("badge %s missing: %+v", b, state.Badges) }
+    }
+    quests, err := svc.GetQuests(ctx, "bob")
+    if err != nil || len(quests) != 2 || !quests[0].Completed || !quests[1].Completed || quests[1].ResetsAt != nil { t.Fatalf("GetQuests = %+v, %v", quests, err) }
+
+    if _, err := CompileRules([]byte(`{"metrics": ["xp"], "quests": [{"id": "q", "metric": "xp", "target": 0, "reset": "yearly"}]}`)); err == nil { t.Fatal("invalid quest compiled") }
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "gamifykit/core"
+)
+
+var (
+    // ErrCooldownActive is returned by AwardBadge for a repeatable badge awarded again before its
+    // cooldown has passed.
+    ErrCooldownActive = errors.New("badge cooldown active")
+    // ErrRepeatUnsupported is returned for repeatable badges on storages without BadgeRepeater.
+    ErrRepeatUnsupported = errors.New("storage does not support repeatable badges")
+)
+
+// BadgeRepeater is implemented by storages that keep a per-user award count and last-award time
+// of badges, so a badge can be earned more than once.
+type BadgeRepeater interface {
+    // RepeatBadge awards badge at now unless its last award lies within cooldown, counting every
+    // award. It returns the record after the call and whether the badge was awarded; of several
+    // concurrent calls within the cooldown at most one awards.
+    RepeatBadge(ctx context.Context, user core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (rec core.BadgeRecord, awarded bool, err error)
+    // BadgeRecord returns the user's record of badge; the zero record if it was never repeated.
+    BadgeRecord(ctx context.Context, user core.UserID, badge core.Badge) (core.BadgeRecord, error)
+}
+
+// WithRepeatableBadge lets AwardBadge award badge again once cooldown has passed since the user's
+// last award, counting the awards (see BadgeRecord) and publishing core.EventBadgeAwarded every
+// time, with the count in the event's "count" metadata. Awards within the cooldown fail with
+// ErrCooldownActive. Other badges keep their one-time semantics. Only AwardBadge repeats badges;
+// rules award them once like any other. The storage must implement BadgeRepeater.
+func WithRepeatableBadge(badge core.Badge, cooldown time.Duration) ServiceOption {
+    if err := core.ValidateBadgeID(badge); err != nil { panic(fmt.Sprintf("WithRepeatableBadge: %v", err)) }
+    if cooldown < 0 { panic(fmt.Sprintf("WithRepeatableBadge: negative cooldown %s", cooldown)) }
+    return func(g *GamifyService){ g.repeatable[badge] = cooldown }
+}
+
+// RepeatCooldown returns the cooldown of badge and whether it is repeatable.
+func (g *GamifyService) RepeatCooldown(badge core.Badge) (time.Duration, bool) {
+    cooldown, ok := g.repeatable[badge]
+    return cooldown, ok
+}
+
+// BadgeRecord returns how often the user earned a repeatable badge and when they last did.
+func (g *GamifyService) BadgeRecord(ctx context.Context, user core.UserID, badge core.Badge) (core.BadgeRecord, error) {
+    r, ok := g.storage.(BadgeRepeater)
+    if !ok { return core.BadgeRecord{}, ErrRepeatUnsupported }
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return core.BadgeRecord{}, err }
+    return r.BadgeRecord(ctx, normalized, badge)
+}
+
+// repeatBadge awards a repeatable badge, publishing the award with its count
+func (g *GamifyService) repeatBadge(ctx context.Context, user core.UserID, badge core.Badge, cooldown time.Duration) (bool, error) {
+    r, ok := g.storage.(BadgeRepeater)
+    if !ok { return false, ErrRepeatUnsupported }
+    var rec core.BadgeRecord
+    var awarded bool
+    unlock, err := g.lockUsers(ctx, user)
+    if err != nil { return false, err }
+    err = g.withRetry(ctx, "repeat_badge", func() (err error) {
+        rec, awarded, err = r.RepeatBadge(ctx, user, badge, core.CurrentTime().UTC(), cooldown)
+        return err
+    })
+    unlock()
+    if err != nil { return false, err }
+    if !awarded {
+        return false, fmt.Errorf("%w: %s can be awarded again at %s", ErrCooldownActive, badge, rec.LastAwarded.Add(cooldown).Format(time.RFC3339))
+    }
+    ev := core.NewBadgeAwarded(user, badge)
+    ev.Metadata = map[string]any{"count": rec.Count}
+    g.publish(ctx, ev)
+    return true, nil
+}
@@ -0,0 +1,34 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+func TestRepeatableBadgeCooldown(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithRepeatableBadge("daily", time.Hour), WithRepeatableBadge("streak", 20*time.Millisecond))
+    var counts []any
+    svc.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event){ counts = append(counts, e.Metadata["count"]) })
+
+    if awarded, err := svc.AwardBadgeResult(ctx, "alice", "daily"); err != nil || !awarded { t.Fatalf("first award = %t, %v", awarded, err) }
+    if awarded, err := svc.AwardBadgeResult(ctx, "alice", "daily"); !errors.Is(err, ErrCooldownActive) || awarded { t.Fatalf("award within cooldown = %t, %v; want ErrCooldownActive", awarded, err) }
+    if rec, err := svc.BadgeRecord(ctx, "alice", "daily"); err != nil || rec.Count != 1 { t.Fatalf("record = %+v, %v; want count 1", rec, err) }
+
+    if err := svc.AwardBadge(ctx, "alice", "streak"); err != nil { t.Fatal(err) }
+    time.Sleep(30 * time.Millisecond)
+    if err := svc.AwardBadge(ctx, "alice", "streak"); err != nil { t.Fatalf("award after cooldown: %v", err) }
+    if rec, _ := svc.BadgeRecord(ctx, "alice", "streak"); rec.Count != 2 { t.Fatalf("streak count = %d, want 2", rec.Count) }
+    if len(counts) != 3 || counts[2] != int64(2) { t.Fatalf("award events carry counts %v, want [1 1 2]", counts) }
+
+    // other badges keep their one-time semantics
+    if awarded, err := svc.AwardBadgeResult(ctx, "alice", "once"); err != nil || !awarded { t.Fatal(awarded, err) }
+    if awarded, err := svc.AwardBadgeResult(ctx, "alice", "once"); err != nil || awarded { t.Fatalf("second one-time award = %t, %v; want false, nil", awarded, err) }
+    if _, ok := svc.BadgeInfo("daily"); !ok { t.Error("repeatable badge missing from the catalog") }
+}
@@ -0,0 +1,59 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "gamifykit/core"
+)
+
+// RetryPolicy retries storage writes that fail with transient errors (see core.IsTransient).
+// Validation errors and every other error are returned at once.
+type RetryPolicy struct {
+    // MaxAttempts is the total number of attempts, including the first; 1 or less disables retries.
+    MaxAttempts int
+    // Backoff is the wait before the first retry (10ms when zero); it doubles with every retry.
+    Backoff time.Duration
+    // MaxBackoff caps the wait between attempts (one second when zero).
+    MaxBackoff time.Duration
+    // OnRetry, if set, is called before each retry with the operation ("add_points",
+    // "award_badge", "set_level"), the attempt that failed and its error, e.g. to count retries.
+    OnRetry func(op string, attempt int, err error)
+}
+
+// WithRetry retries the storage writes behind AddPoints, AwardBadge and level updates under p.
+// Retries never outlast the caller's context: when its deadline would pass before the next
+// attempt, the last error is returned instead.
+func WithRetry(p RetryPolicy) ServiceOption {
+    if p.Backoff <= 0 { p.Backoff = 10 * time.Millisecond }
+    if p.MaxBackoff <= 0 { p.MaxBackoff = time.Second }
+    return func(g *GamifyService){ g.retry = p }
+}
+
+// withRetry runs fn until it succeeds, fails with a non-transient error or runs out of attempts
+func (g *GamifyService) withRetry(ctx context.Context, op string, fn func() error) error {
+    backoff := g.retry.Backoff
+    for attempt := 1; ; attempt++ {
+        err := fn()
+        var final noRetry
+        if errors.As(err, &final) { return final.error }
+        if err == nil || attempt >= g.retry.MaxAttempts || !core.IsTransient(err) { return err }
+        if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff { return err }
+        if g.retry.OnRetry != nil { g.retry.OnRetry(op, attempt, err) }
+        timer := time.NewTimer(backoff)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return err
+        case <-timer.C:
+        }
+        if backoff *= 2; backoff > g.retry.MaxBackoff { backoff = g.retry.MaxBackoff }
+    }
+}
+
+// noRetry marks an error that must not be retried although it may be transient, e.g. because part
+// of the operation was already applied
+type noRetry struct{ error }
+
+func (e noRetry) Unwrap() error { return e.error }
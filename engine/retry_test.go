@@ -0,0 +1,71 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+// flakyStore fails the first failures writes with err, then lets them through
+type flakyStore struct {
+    Storage
+    failures int
+    err      error
+    calls    int
+}
+
+func (f *flakyStore) fail() error {
+    f.calls++
+    if f.calls <= f.failures { return f.err }
+    return nil
+}
+
+func (f *flakyStore) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    if err := f.fail(); err != nil { return 0, err }
+    return f.Storage.AddPoints(ctx, user, metric, delta)
+}
+
+func (f *flakyStore) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+    if err := f.fail(); err != nil { return err }
+    return f.Storage.AwardBadge(ctx, user, badge)
+}
+
+func TestRetryTransientWrites(t *testing.T) {
+    ctx := context.Background()
+    store := &flakyStore{Storage: mem.New(), failures: 2, err: fmt.Errorf("deadlock detected: %w", core.ErrConflict)}
+    var retried []int
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithRetry(RetryPolicy{
+        MaxAttempts: 3,
+        Backoff:     time.Millisecond,
+        OnRetry:     func(op string, attempt int, err error) { retried = append(retried, attempt) },
+    }))
+
+    total, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10)
+    if err != nil || total != 10 { t.Fatalf("want 10 after two retries, got %d %v", total, err) }
+    if len(retried) != 2 || store.calls != 3 { t.Fatalf("want 2 retries and 3 calls, got %v %d", retried, store.calls) }
+
+    // out of attempts: the last error is returned
+    store.calls, store.failures, retried = 0, 5, nil
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10); !errors.Is(err, core.ErrConflict) { t.Fatalf("want the conflict after 3 attempts, got %v", err) }
+    if store.calls != 3 { t.Fatalf("want 3 attempts, got %d", store.calls) }
+
+    // non-transient errors are not retried
+    store.calls, store.failures, store.err = 0, 1, errors.New("disk full")
+    if err := svc.AwardBadge(ctx, "alice", "starter"); err == nil || store.calls != 1 { t.Fatalf("want one failed attempt, got %v after %d", err, store.calls) }
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+    store := &flakyStore{Storage: mem.New(), failures: 10, err: core.ErrBackendBusy}
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithRetry(RetryPolicy{MaxAttempts: 10, Backoff: 50 * time.Millisecond}))
+    ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 1); !errors.Is(err, core.ErrBackendBusy) { t.Fatalf("want the storage error, got %v", err) }
+    if took := time.Since(start); took > 80*time.Millisecond { t.Fatalf("retries outlasted the deadline: %s", took) }
+    if store.calls != 2 { t.Fatalf("want 2 attempts before the deadline, got %d", store.calls) }
+}
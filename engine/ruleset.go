@@ -0,0 +1,472 @@
+package engine
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+    "time"
+
+    "gamifykit/core"
+)
+
+// ErrInvalidRules is returned by CompileRules for configurations that fail validation.
+var ErrInvalidRules = errors.New("invalid rules")
+
+// maxStreakDays bounds streak lengths; longer streaks also need a storage retaining that much history
+const maxStreakDays = 366
+
+// RuleSet is a rule engine compiled from a declarative configuration; see CompileRules. Besides
+// Evaluate it implements PointsMultiplier, BadgeCooldowns, WindowedRuleEngine, BadgeStoringRules and QuestSource, so
+// it can be passed to NewGamifyService or swapped into a SwappableRuleEngine as a whole.
+type RuleSet struct {
+    levels       []levelRule
+    tiers        []tierRule
+    streaks      []streakRule
+    achievements []achievementRule
+    multipliers  []multiplierRule
+    quests       []Quest
+    cooldowns    map[core.Badge]time.Duration
+    now          func() time.Time
+}
+
+type levelRule struct {
+    metric core.Metric
+    curve  LevelCurve
+}
+
+type tierRule struct {
+    metric core.Metric
+    badge  core.Badge
+    points int64
+}
+
+type streakRule struct {
+    metric core.Metric
+    badge  core.Badge
+    days   int
+}
+
+type achievementRule struct {
+    name  string
+    badge core.Badge
+    when  func(core.UserState) bool
+}
+
+type multiplierRule struct {
+    metric      core.Metric
+    factor      float64
+    from, until time.Time
+    when        func(core.UserState) bool
+}
+
+// ruleSpec is the JSON schema of a rules configuration; see CompileRules.
+type ruleSpec struct {
+    Metrics      []core.Metric     `json:"metrics"`
+    Levels       []levelSpec       `json:"levels"`
+    Badges       []badgeSpec       `json:"badges"`
+    Streaks      []streakSpec      `json:"streaks"`
+    Achievements []achievementSpec `json:"achievements"`
+    Multipliers  []multiplierSpec  `json:"multipliers"`
+    Quests       []questSpec       `json:"quests"`
+}
+
+type levelSpec struct {
+    Metric core.Metric `json:"metric"`
+    // Curve is "default", "linear" (Step), "exponential" (Base, Factor), "logarithmic" (Base,
+    // Factor), "polynomial" (A, B, C) or "table" (Thresholds)
+    Curve      string  `json:"curve"`
+    Step       int64   `json:"step"`
+    Base       int64   `json:"base"`
+    Factor     float64 `json:"factor"`
+    A          int64   `json:"a"`
+    B          int64   `json:"b"`
+    C          int64   `json:"c"`
+    Thresholds []int64 `json:"thresholds"`
+}
+
+type badgeSpec struct {
+    Metric core.Metric `json:"metric"`
+    Tiers  []tierSpec  `json:"tiers"`
+}
+
+type tierSpec struct {
+    Badge    core.Badge   `json:"badge"`
+    Points   int64        `json:"points"`
+    Cooldown ruleDuration `json:"cooldown"`
+}
+
+type streakSpec struct {
+    Badge    core.Badge   `json:"badge"`
+    Metric   core.Metric  `json:"metric"`
+    Days     int          `json:"days"`
+    Cooldown ruleDuration `json:"cooldown"`
+}
+
+type achievementSpec struct {
+    Name  string         `json:"name"`
+    Badge core.Badge     `json:"badge"`
+    When  *conditionSpec `json:"when"`
+}
+
+type multiplierSpec struct {
+    Metric core.Metric    `json:"metric"`
+    Factor float64        `json:"factor"`
+    From   time.Time      `json:"from"`
+    Until  time.Time      `json:"until"`
+    When   *conditionSpec `json:"when"`
+}
+
+type questSpec struct {
+    ID           string      `json:"id"`
+    Name         string      `json:"name"`
+    Metric       core.Metric `json:"metric"`
+    Target       int64       `json:"target"`
+    Reset        string      `json:"reset"`
+    RewardBadge  core.Badge  `json:"reward_badge"`
+    RewardMetric core.Metric `json:"reward_metric"`
+    RewardPoints int64       `json:"reward_points"`
+}
+
+// conditionSpec is a predicate on a user's state: exactly one of All, Any, Badge or Metric (with
+// MinPoints, MaxPoints and MinLevel) is set.
+type conditionSpec struct {
+    All       []conditionSpec `json:"all"`
+    Any       []conditionSpec `json:"any"`
+    Badge     core.Badge      `json:"badge"`
+    Metric    core.Metric     `json:"metric"`
+    MinPoints *int64          `json:"min_points"`
+    MaxPoints *int64          `json:"max_points"`
+    MinLevel  *int64          `json:"min_level"`
+}
+
+// ruleDuration is a duration written like "24h" or "90m"
+type ruleDuration time.Duration
+
+func (d *ruleDuration) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil { return fmt.Errorf("durations must be strings like \"24h\": %w", err) }
+    v, err := time.ParseDuration(s)
+    if err != nil { return err }
+    *d = ruleDuration(v)
+    return nil
+}
+
+// CompileRules parses a JSON rules configuration and compiles it into a RuleSet:
+//
+//	{
+//	  "metrics": ["xp", "coins"],
+//	  "levels": [{"metric": "xp", "curve": "table", "thresholds": [100, 250, 500]}],
+//	  "badges": [{"metric": "xp", "tiers": [{"badge": "xp-bronze", "points": 100}, {"badge": "xp-silver", "points": 1000}]}],
+//	  "streaks": [{"badge": "streak-7", "metric": "xp", "days": 7}],
+//	  "achievements": [{"name": "collector", "when": {"all": [{"badge": "xp-silver"}, {"metric": "coins", "min_points": 500}]}}],
+//	  "multipliers": [{"metric": "xp", "factor": 2, "from": "2026-06-01T00:00:00Z", "until": "2026-06-03T00:00:00Z"}],
+//	  "quests": [{"id": "weekly-xp", "metric": "xp", "target": 500, "reset": "weekly", "reward_badge": "weekly-grinder", "reward_metric": "coins", "reward_points": 50}]
+//	}
+//
+// Every metric referenced must be listed in "metrics". The whole configuration is validated up
+// front and all problems are reported together, wrapped in ErrInvalidRules: unknown fields and
+// metrics, invalid badge IDs, badges defined twice, curves with invalid parameters, tier
+// thresholds that do not increase, empty or contradictory conditions, non-positive factors and
+// invalid quests. The quests are served to the service through QuestSource.
+func CompileRules(data []byte) (*RuleSet, error) {
+    var spec ruleSpec
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&spec); err != nil { return nil, fmt.Errorf("%w: %v", ErrInvalidRules, err) }
+    if dec.More() { return nil, fmt.Errorf("%w: trailing data after the rules object", ErrInvalidRules) }
+
+    c := ruleCompiler{metrics: map[core.Metric]bool{}, badges: map[core.Badge]string{}}
+    rs := &RuleSet{cooldowns: map[core.Badge]time.Duration{}, now: time.Now}
+    for i, m := range spec.Metrics {
+        switch {
+        case m == "" || strings.TrimSpace(string(m)) != string(m):
+            c.errorf("metrics[%d]: metric names must be non-empty without surrounding whitespace", i)
+        case c.metrics[m]:
+            c.errorf("metrics[%d]: duplicate metric %q", i, m)
+        }
+        c.metrics[m] = true
+    }
+
+    leveled := map[core.Metric]bool{}
+    for i, l := range spec.Levels {
+        at := fmt.Sprintf("levels[%d]", i)
+        c.metric(at, l.Metric)
+        if leveled[l.Metric] { c.errorf("%s: metric %q already has a level curve", at, l.Metric) }
+        leveled[l.Metric] = true
+        if curve := c.curve(at, l); curve != nil { rs.levels = append(rs.levels, levelRule{metric: l.Metric, curve: curve}) }
+    }
+
+    for i, b := range spec.Badges {
+        at := fmt.Sprintf("badges[%d]", i)
+        c.metric(at, b.Metric)
+        if len(b.Tiers) == 0 { c.errorf("%s: at least one tier is required", at) }
+        prev := int64(0)
+        for j, t := range b.Tiers {
+            at := fmt.Sprintf("%s.tiers[%d]", at, j)
+            c.badge(at, t.Badge)
+            switch {
+            case t.Points <= 0:
+                c.errorf("%s: points must be positive, got %d", at, t.Points)
+            case j > 0 && t.Points <= prev:
+                c.errorf("%s: thresholds must increase, got %d after %d", at, t.Points, prev)
+            }
+            prev = t.Points
+            c.cooldown(at, rs, t.Badge, t.Cooldown)
+            rs.tiers = append(rs.tiers, tierRule{metric: b.Metric, badge: t.Badge, points: t.Points})
+        }
+    }
+
+    for i, s := range spec.Streaks {
+        at := fmt.Sprintf("streaks[%d]", i)
+        c.metric(at, s.Metric)
+        c.badge(at, s.Badge)
+        if s.Days < 2 || s.Days > maxStreakDays { c.errorf("%s: days must be between 2 and %d, got %d", at, maxStreakDays, s.Days) }
+        c.cooldown(at, rs, s.Badge, s.Cooldown)
+        rs.streaks = append(rs.streaks, streakRule{metric: s.Metric, badge: s.Badge, days: s.Days})
+    }
+
+    names := map[string]bool{}
+    for i, a := range spec.Achievements {
+        at := fmt.Sprintf("achievements[%d]", i)
+        switch {
+        case strings.TrimSpace(a.Name) == "":
+            c.errorf("%s: name is required", at)
+        case names[a.Name]:
+            c.errorf("%s: duplicate achievement %q", at, a.Name)
+        }
+        names[a.Name] = true
+        badge := a.Badge
+        if badge == "" { badge = core.Badge(a.Name) }
+        c.badge(at, badge)
+        if a.When == nil { c.errorf("%s: when is required", at); continue }
+        rs.achievements = append(rs.achievements, achievementRule{name: a.Name, badge: badge, when: c.condition(at+".when", *a.When)})
+    }
+
+    for i, m := range spec.Multipliers {
+        at := fmt.Sprintf("multipliers[%d]", i)
+        c.metric(at, m.Metric)
+        if !(m.Factor > 0) || math.IsInf(m.Factor, 0) { c.errorf("%s: factor must be positive and finite, got %v", at, m.Factor) }
+        if !m.From.IsZero() && !m.Until.IsZero() && !m.Until.After(m.From) { c.errorf("%s: until must be after from", at) }
+        rule := multiplierRule{metric: m.Metric, factor: m.Factor, from: m.From, until: m.Until}
+        if m.When != nil { rule.when = c.condition(at+".when", *m.When) }
+        rs.multipliers = append(rs.multipliers, rule)
+    }
+
+    quests := map[string]bool{}
+    for i, q := range spec.Quests {
+        at := fmt.Sprintf("quests[%d]", i)
+        c.metric(at, q.Metric)
+        if q.RewardBadge != "" { c.badge(at, q.RewardBadge) }
+        if q.RewardMetric != "" { c.metric(at+".reward_metric", q.RewardMetric) }
+        if quests[q.ID] { c.errorf("%s: duplicate quest %q", at, q.ID) }
+        quests[q.ID] = true
+        quest := Quest{ID: q.ID, Name: q.Name, Metric: q.Metric, Target: q.Target, Reset: core.QuestReset(q.Reset),
+            RewardBadge: q.RewardBadge, RewardMetric: q.RewardMetric, RewardPoints: q.RewardPoints}
+        if q.Reset == "none" { quest.Reset = core.QuestOnce }
+        if err := quest.Validate(); err != nil { c.errorf("%s: %v", at, err); continue }
+        rs.quests = append(rs.quests, quest)
+    }
+
+    if len(c.errs) > 0 { return nil, fmt.Errorf("%w: %s", ErrInvalidRules, strings.Join(c.errs, "; ")) }
+    return rs, nil
+}
+
+// Quests returns the configuration's quests.
+func (rs *RuleSet) Quests() []Quest { return append([]Quest(nil), rs.quests...) }
+
+// ruleCompiler collects validation errors while compiling a ruleSpec
+type ruleCompiler struct {
+    metrics map[core.Metric]bool
+    badges  map[core.Badge]string
+    errs    []string
+}
+
+func (c *ruleCompiler) errorf(format string, args ...any) { c.errs = append(c.errs, fmt.Sprintf(format, args...)) }
+
+func (c *ruleCompiler) metric(at string, m core.Metric) {
+    switch {
+    case m == "":
+        c.errorf("%s: metric is required", at)
+    case !c.metrics[m]:
+        c.errorf("%s: unknown metric %q", at, m)
+    }
+}
+
+// badge validates a badge defined at at; each badge may be defined once
+func (c *ruleCompiler) badge(at string, b core.Badge) {
+    if err := core.ValidateBadgeID(b); err != nil { c.errorf("%s: badge %q: %v", at, b, err); return }
+    if first, ok := c.badges[b]; ok { c.errorf("%s: badge %q already defined at %s", at, b, first); return }
+    c.badges[b] = at
+}
+
+func (c *ruleCompiler) cooldown(at string, rs *RuleSet, b core.Badge, d ruleDuration) {
+    switch {
+    case d < 0:
+        c.errorf("%s: cooldown cannot be negative", at)
+    case d > 0:
+        rs.cooldowns[b] = time.Duration(d)
+    }
+}
+
+func (c *ruleCompiler) curve(at string, l levelSpec) LevelCurve {
+    var curve LevelCurve
+    var err error
+    switch l.Curve {
+    case "", "default":
+        return DefaultCurve
+    case "linear":
+        curve, err = LinearCurve(l.Step)
+    case "exponential":
+        curve, err = ExponentialCurve(l.Base, l.Factor)
+    case "logarithmic":
+        curve, err = LogarithmicCurve(l.Base, l.Factor)
+    case "polynomial":
+        curve, err = PolynomialCurve(l.A, l.B, l.C)
+    case "table":
+        curve, err = TableCurve(l.Thresholds...)
+    default:
+        c.errorf("%s: unknown curve %q", at, l.Curve)
+        return nil
+    }
+    if err != nil { c.errorf("%s: %v", at, err); return nil }
+    return curve
+}
+
+func (c *ruleCompiler) condition(at string, s conditionSpec) func(core.UserState) bool {
+    set := 0
+    if s.All != nil { set++ }
+    if s.Any != nil { set++ }
+    if s.Badge != "" { set++ }
+    if s.Metric != "" || s.MinPoints != nil || s.MaxPoints != nil || s.MinLevel != nil { set++ }
+    if set != 1 { c.errorf("%s: exactly one of all, any, badge or metric must be set", at); return nil }
+
+    switch {
+    case s.All != nil || s.Any != nil:
+        parts, all := s.Any, false
+        if s.All != nil { parts, all = s.All, true }
+        if len(parts) == 0 { c.errorf("%s: empty condition list", at) }
+        preds := make([]func(core.UserState) bool, len(parts))
+        for i, p := range parts { preds[i] = c.condition(fmt.Sprintf("%s[%d]", at, i), p) }
+        return func(st core.UserState) bool {
+            for _, p := range preds {
+                if p(st) != all { return !all }
+            }
+            return all
+        }
+    case s.Badge != "":
+        if err := core.ValidateBadgeID(s.Badge); err != nil { c.errorf("%s: badge %q: %v", at, s.Badge, err) }
+        badge := s.Badge
+        return func(st core.UserState) bool { _, ok := st.Badges[badge]; return ok }
+    default:
+        c.metric(at, s.Metric)
+        if s.MinPoints == nil && s.MaxPoints == nil && s.MinLevel == nil { c.errorf("%s: min_points, max_points or min_level is required", at) }
+        if s.MinPoints != nil && s.MaxPoints != nil && *s.MinPoints > *s.MaxPoints { c.errorf("%s: min_points %d above max_points %d", at, *s.MinPoints, *s.MaxPoints) }
+        metric, lo, hi, level := s.Metric, s.MinPoints, s.MaxPoints, s.MinLevel
+        return func(st core.UserState) bool {
+            points := st.Points[metric]
+            if lo != nil && points < *lo { return false }
+            if hi != nil && points > *hi { return false }
+            return level == nil || st.Levels[metric] >= *level
+        }
+    }
+}
+
+// Evaluate emits level-ups, badges of reached tiers and newly met achievements. Rules of a metric run
+// on its points events; a trigger without a type, as from EvaluateRules, runs every rule. Streaks need
+// the storage's point history and only run through EvaluateWindowed.
+func (r *RuleSet) Evaluate(_ context.Context, state core.UserState, trigger core.Event) []core.Event {
+    var out []core.Event
+    for _, l := range r.levels {
+        points, ok := state.Points[l.metric]
+        if !ok || !triggers(trigger, l.metric) { continue }
+        if level := l.curve.LevelFor(points); level > state.Levels[l.metric] {
+            out = append(out, core.NewLevelUp(state.UserID, l.metric, level))
+        }
+    }
+    for _, t := range r.tiers {
+        if !triggers(trigger, t.metric) || state.Points[t.metric] < t.points || !r.earnable(state, t.badge) { continue }
+        out = append(out, core.NewBadgeAwarded(state.UserID, t.badge))
+    }
+    for _, a := range r.achievements {
+        if !r.earnable(state, a.badge) || !a.when(state) { continue }
+        out = append(out, core.NewAchievementUnlocked(state.UserID, a.name, a.badge))
+    }
+    return out
+}
+
+// EvaluateWindowed is Evaluate plus streaks: a streak of n days is complete once the user earned
+// points of its metric in each of the last n 24-hour periods. The periods must lie within the
+// storage's point retention (core.DefaultPointsRetention unless configured); longer streaks never
+// complete.
+func (r *RuleSet) EvaluateWindowed(ctx context.Context, state core.UserState, trigger core.Event, w WindowedPoints) []core.Event {
+    out := r.Evaluate(ctx, state, trigger)
+    for _, s := range r.streaks {
+        if !triggers(trigger, s.metric) || !r.earnable(state, s.badge) { continue }
+        if active, err := streakActive(ctx, w, state.UserID, s.metric, s.days); err == nil && active {
+            out = append(out, core.NewBadgeAwarded(state.UserID, s.badge))
+        }
+    }
+    return out
+}
+
+// streakActive reports whether user earned points of metric in each of the last days 24-hour periods,
+// in one query when the storage implements PeriodPoints
+func streakActive(ctx context.Context, w WindowedPoints, user core.UserID, metric core.Metric, days int) (bool, error) {
+    if p, ok := w.(PeriodPoints); ok {
+        sums, err := p.PointsPerPeriod(ctx, user, metric, 24*time.Hour, days)
+        if err != nil { return false, err }
+        for _, sum := range sums {
+            if sum <= 0 { return false, nil }
+        }
+        return len(sums) == days, nil
+    }
+    prev := int64(0)
+    for d := 1; d <= days; d++ {
+        sum, err := w.PointsInWindow(ctx, user, metric, time.Duration(d)*24*time.Hour)
+        if err != nil { return false, err }
+        if sum-prev <= 0 { return false, nil }
+        prev = sum
+    }
+    return true, nil
+}
+
+// MultiplyPoints scales a positive delta by the factors of every multiplier of metric that is
+// active now and whose condition state meets, rounding to the nearest point.
+func (r *RuleSet) MultiplyPoints(_ context.Context, state core.UserState, metric core.Metric, delta int64) int64 {
+    if delta <= 0 { return delta }
+    now, factor := r.now(), 1.0
+    for _, m := range r.multipliers {
+        if m.metric != metric || (!m.from.IsZero() && now.Before(m.from)) || (!m.until.IsZero() && !now.Before(m.until)) { continue }
+        if m.when != nil && !m.when(state) { continue }
+        factor *= m.factor
+    }
+    if factor == 1 { return delta }
+    return saturate(math.Round(float64(delta) * factor))
+}
+
+// StoresBadges reports true: the service stores the badges of the set's tiers, streaks and
+// achievements, so each is published once (or once per cooldown).
+func (r *RuleSet) StoresBadges() bool { return true }
+
+// BadgeCooldown returns the cooldown of a badge defined with one.
+func (r *RuleSet) BadgeCooldown(badge core.Badge) (time.Duration, bool) {
+    d, ok := r.cooldowns[badge]
+    return d, ok
+}
+
+// earnable reports whether badge may be emitted: the user lacks it or it repeats after a cooldown,
+// which the service enforces when awarding
+func (r *RuleSet) earnable(state core.UserState, badge core.Badge) bool {
+    if _, held := state.Badges[badge]; !held { return true }
+    _, repeats := r.cooldowns[badge]
+    return repeats
+}
+
+// triggers reports whether trigger concerns metric, or is untyped and concerns everything
+func triggers(trigger core.Event, metric core.Metric) bool {
+    return trigger.Type == "" || trigger.Metric == metric
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+const testRules = `{
+  "metrics": ["xp", "coins"],
+  "levels": [{"metric": "xp", "curve": "table", "thresholds": [100, 250, 500]}],
+  "badges": [{"metric": "xp", "tiers": [{"badge": "xp-bronze", "points": 100}, {"badge": "xp-silver", "points": 300}]},
+             {"metric": "coins", "tiers": [{"badge": "big-spender", "points": 50, "cooldown": "1h"}]}],
+  "streaks": [{"badge": "streak-3", "metric": "xp", "days": 3}],
+  "achievements": [{"name": "all-rounder", "when": {"all": [{"badge": "xp-bronze"}, {"metric": "coins", "min_points": 10}]}}],
+  "multipliers": [{"metric": "xp", "factor": 2, "from": "2026-06-01T00:00:00Z", "until": "2026-06-02T00:00:00Z"},
+                  {"metric": "xp", "factor": 1.5, "when": {"metric": "xp", "min_level": 3}}]
+}`
+
+func TestCompiledRulesDriveTheService(t *testing.T) {
+    ctx := context.Background()
+    rs, err := CompileRules([]byte(testRules))
+    if err != nil { t.Fatal(err) }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), rs)
+    var events []core.Event
+    svc.bus.SubscribeAll(func(_ context.Context, e core.Event){ events = append(events, e) })
+
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 120); err != nil { t.Fatal(err) }
+    st, _ := svc.GetState(ctx, "alice")
+    if st.Levels[core.MetricXP] != 2 { t.Fatalf("level = %d, want 2 from the table curve", st.Levels[core.MetricXP]) }
+    if _, ok := st.Badges["xp-bronze"]; !ok { t.Fatal("tier badge not stored") }
+
+    if _, err := svc.AddPoints(ctx, "alice", "coins", 10); err != nil { t.Fatal(err) }
+    st, _ = svc.GetState(ctx, "alice")
+    if _, ok := st.Badges["all-rounder"]; !ok { t.Fatal("achievement badge not stored") }
+    var unlocked, bronze int
+    for _, e := range events {
+        if e.Type == core.EventAchievementUnlocked && e.Metadata["achievement"] == "all-rounder" { unlocked++ }
+        if e.Type == core.EventBadgeAwarded && e.Badge == "xp-bronze" { bronze++ }
+    }
+    if unlocked != 1 || bronze != 1 { t.Fatalf("achievement events = %d, bronze awards = %d; want 1 each", unlocked, bronze) }
+
+    // badges already held are not awarded or published again
+    if _, err := svc.AddPoints(ctx, "alice", "coins", 10); err != nil { t.Fatal(err) }
+    unlocked = 0
+    for _, e := range events {
+        if e.Type == core.EventAchievementUnlocked { unlocked++ }
+    }
+    if unlocked != 1 { t.Fatalf("achievement published %d times, want once", unlocked) }
+
+    // tiers with a cooldown repeat through the storage's repeat records
+    if _, err := svc.AddPoints(ctx, "alice", "coins", 40); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", "coins", 1); err != nil { t.Fatal(err) }
+    if rec, _ := svc.BadgeRecord(ctx, "alice", "big-spender"); rec.Count != 1 { t.Fatalf("big-spender count = %d, want 1 within the cooldown", rec.Count) }
+}
+
+func TestRuleSetMultipliers(t *testing.T) {
+    ctx := context.Background()
+    rs, err := CompileRules([]byte(testRules))
+    if err != nil { t.Fatal(err) }
+    rs.now = func() time.Time { return time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC) }
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), NewSwappableRuleEngine(rs))
+
+    if total, err := svc.AddPoints(ctx, "bob", core.MetricXP, 150); err != nil || total != 300 { t.Fatalf("doubled total = %d, %v; want 300", total, err) }
+    // level 3 now: the conditional multiplier applies on top
+    if total, err := svc.AddPoints(ctx, "bob", core.MetricXP, 10); err != nil || total != 330 { t.Fatalf("total = %d, %v; want 330", total, err) }
+    // deductions are never scaled
+    if total, err := svc.AddPoints(ctx, "bob", core.MetricXP, -30); err != nil || total != 300 { t.Fatalf("total after deduction = %d, %v; want 300", total, err) }
+    if total, err := svc.AddPoints(ctx, "bob", "coins", 7); err != nil || total != 7 { t.Fatalf("coins = %d, %v; want 7 unscaled", total, err) }
+
+    rs.now = func() time.Time { return time.Date(2026, 6, 2, 0, 0, 0, 0, time.UTC) }
+    if got := rs.MultiplyPoints(ctx, core.UserState{}, core.MetricXP, 10); got != 10 { t.Fatalf("multiplied outside window = %d, want 10", got) }
+}
+
+// dailyPoints answers PointsInWindow from points earned per day ago (index 0 is the last 24 hours)
+type dailyPoints []int64
+
+func (d dailyPoints) PointsInWindow(_ context.Context, _ core.UserID, _ core.Metric, window time.Duration) (int64, error) {
+    var sum int64
+    for i := 0; i < int(window/(24*time.Hour)) && i < len(d); i++ { sum += d[i] }
+    return sum, nil
+}
+
+func TestRuleSetStreaks(t *testing.T) {
+    rs, err := CompileRules([]byte(testRules))
+    if err != nil { t.Fatal(err) }
+    state := core.UserState{UserID: "carol", Points: map[core.Metric]int64{core.MetricXP: 30}, Badges: map[core.Badge]struct{}{}, Levels: map[core.Metric]int64{core.MetricXP: 1}}
+    trigger := core.NewPointsAdded("carol", core.MetricXP, 10, 30)
+    streak := func(events []core.Event) bool {
+        for _, e := range events {
+            if e.Badge == "streak-3" { return true }
+        }
+        return false
+    }
+    if streak(rs.Evaluate(context.Background(), state, trigger)) { t.Fatal("Evaluate must not run streaks") }
+    if !streak(rs.EvaluateWindowed(context.Background(), state, trigger, dailyPoints{10, 10, 10})) { t.Fatal("three active days must complete the streak") }
+    if streak(rs.EvaluateWindowed(context.Background(), state, trigger, dailyPoints{20, 0, 10})) { t.Fatal("a gap must break the streak") }
+
+    periods := &periodPoints{days: dailyPoints{10, 10, 10}}
+    if !streak(rs.EvaluateWindowed(context.Background(), state, trigger, periods)) { t.Fatal("three active days must complete the streak") }
+    if periods.calls != 1 { t.Fatalf("streak made %d period queries, want 1", periods.calls) }
+}
+
+// periodPoints answers a streak in one PointsPerPeriod call and fails per-window queries
+type periodPoints struct {
+    days  dailyPoints
+    calls int
+}
+
+func (p *periodPoints) PointsInWindow(context.Context, core.UserID, core.Metric, time.Duration) (int64, error) {
+    return 0, errors.New("streaks must use PointsPerPeriod")
+}
+
+func (p *periodPoints) PointsPerPeriod(_ context.Context, _ core.UserID, _ core.Metric, _ time.Duration, n int) ([]int64, error) {
+    p.calls++
+    sums := make([]int64, n)
+    copy(sums, p.days)
+    return sums, nil
+}
+
+// repeatCounter counts the writes of repeatable badges
+type repeatCounter struct {
+    *mem.Store
+    repeats int
+}
+
+func (r *repeatCounter) RepeatBadge(ctx context.Context, user core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (core.BadgeRecord, bool, error) {
+    r.repeats++
+    return r.Store.RepeatBadge(ctx, user, badge, now, cooldown)
+}
+
+func TestRuleCooldownsReadTheRecordFirst(t *testing.T) {
+    ctx := context.Background()
+    rs, err := CompileRules([]byte(testRules))
+    if err != nil { t.Fatal(err) }
+    store := &repeatCounter{Store: mem.New()}
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), rs)
+    for i := 0; i < 5; i++ {
+        if _, err := svc.AddPoints(ctx, "dave", "coins", 60); err != nil { t.Fatal(err) }
+    }
+    if store.repeats != 1 { t.Fatalf("RepeatBadge called %d times, want once within the cooldown", store.repeats) }
+}
+
+// badgeEmitter emits a badge event on every trigger without asking the service to store it
+type badgeEmitter struct{}
+
+func (badgeEmitter) Evaluate(_ context.Context, state core.UserState, _ core.Event) []core.Event {
+    return []core.Event{core.NewBadgeAwarded(state.UserID, "emitted")}
+}
+
+func TestOtherRuleEnginesKeepBadgesUnstored(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), badgeEmitter{})
+    var awarded int
+    svc.bus.Subscribe(core.EventBadgeAwarded, func(context.Context, core.Event){ awarded++ })
+    for i := 0; i < 2; i++ {
+        if _, err := svc.AddPoints(ctx, "erin", core.MetricXP, 1); err != nil { t.Fatal(err) }
+    }
+    if st, _ := svc.GetState(ctx, "erin"); len(st.Badges) != 0 { t.Fatalf("badges = %v, want none stored", st.Badges) }
+    if awarded != 2 { t.Fatalf("badge events = %d, want every emitted event published", awarded) }
+}
+
+func TestCompileRulesRejectsInvalidConfigs(t *testing.T) {
+    for name, tc := range map[string]struct{ rules, want string }{
+        "unknown field":         {`{"metrics": ["xp"], "level": []}`, `unknown field "level"`},
+        "unknown metric":        {`{"metrics": ["xp"], "badges": [{"metric": "gems", "tiers": [{"badge": "g", "points": 1}]}]}`, `badges[0]: unknown metric "gems"`},
+        "non-monotonic tiers":   {`{"metrics": ["xp"], "badges": [{"metric": "xp", "tiers": [{"badge": "a", "points": 500}, {"badge": "b", "points": 100}]}]}`, "thresholds must increase, got 100 after 500"},
+        "non-monotonic table":   {`{"metrics": ["xp"], "levels": [{"metric": "xp", "curve": "table", "thresholds": [100, 100]}]}`, "thresholds must be positive and increase"},
+        "bad curve parameters":  {`{"metrics": ["xp"], "levels": [{"metric": "xp", "curve": "exponential", "base": 10, "factor": 1}]}`, "factor above 1"},
+        "bad logarithmic curve": {`{"metrics": ["xp"], "levels": [{"metric": "xp", "curve": "logarithmic", "base": 5, "factor": 1.1}]}`, "base·(factor−1) of at least 1"},
+        "duplicate badge":       {`{"metrics": ["xp"], "badges": [{"metric": "xp", "tiers": [{"badge": "a", "points": 1}]}], "streaks": [{"badge": "a", "metric": "xp", "days": 3}]}`, `badge "a" already defined at badges[0].tiers[0]`},
+        "ambiguous condition":   {`{"metrics": ["xp"], "achievements": [{"name": "x", "when": {"badge": "a", "metric": "xp", "min_points": 1}}]}`, "exactly one of all, any, badge or metric"},
+        "empty condition list":  {`{"metrics": ["xp"], "achievements": [{"name": "x", "when": {"any": []}}]}`, "empty condition list"},
+        "bad factor":            {`{"metrics": ["xp"], "multipliers": [{"metric": "xp", "factor": 0}]}`, "factor must be positive"},
+        "bad duration":          {`{"metrics": ["xp"], "streaks": [{"badge": "s", "metric": "xp", "days": 3, "cooldown": "daily"}]}`, "invalid duration"},
+    } {
+        _, err := CompileRules([]byte(tc.rules))
+        if !errors.Is(err, ErrInvalidRules) || !strings.Contains(err.Error(), tc.want) { t.Errorf("%s: got %v, want ErrInvalidRules mentioning %q", name, err, tc.want) }
+    }
+
+    // every problem is reported at once
+    _, err := CompileRules([]byte(`{"metrics": ["xp"], "levels": [{"metric": "gems"}], "streaks": [{"badge": "s", "metric": "xp", "days": 1}]}`))
+    if err == nil || !strings.Contains(err.Error(), `unknown metric "gems"`) || !strings.Contains(err.Error(), "days must be between") { t.Fatalf("want both problems reported, got %v", err) }
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "gamifykit/core"
+)
+
+// SamplingPolicy thins out a high-frequency event type before it reaches subscribers.
+// The zero value delivers every event.
+type SamplingPolicy struct {
+    // Coalesce merges events for the same user and metric published within this interval into one
+    // event carrying the summed Delta, the latest Total and Metadata["count"] (events merged).
+    Coalesce time.Duration
+    // Rate delivers roughly this fraction of events (0 < Rate < 1), e.g. 0.1 delivers every tenth.
+    // Delivered events carry Metadata["sample_rate"] so consumers can scale counts back up.
+    // It applies after coalescing.
+    Rate float64
+}
+
+// milestone events are always delivered in full
+func isMilestone(typ core.EventType) bool {
+    switch typ {
+    case core.EventLevelUp, core.EventLevelDown, core.EventBadgeAwarded, core.EventBadgeRevoked, core.EventAchievementUnlocked, core.EventStateReplaced,
+        core.EventEnteredTopN, core.EventLeftTopN, core.EventQuestCompleted, core.EventUsersMerged:
+        return true
+    }
+    return false
+}
+
+// sampler applies the sampling policy of one event type
+type sampler struct {
+    policy SamplingPolicy
+    seen   uint64
+    // pending coalesced events by user and metric
+    pending map[core.UserID]map[core.Metric]*pendingEvent
+}
+
+type pendingEvent struct {
+    ev    core.Event
+    count int
+    timer *time.Timer
+}
+
+// SetSampling sets the sampling policy of an event type. Milestone events (level-ups, badges,
+// achievements, state replacements, merges, top-N changes) cannot be sampled and panic, as do invalid rates.
+func (e *EventBus) SetSampling(typ core.EventType, p SamplingPolicy) {
+    if isMilestone(typ) { panic(fmt.Sprintf("event type %s is a milestone and cannot be sampled", typ)) }
+    if p.Rate < 0 || p.Coalesce < 0 { panic("sampling rate and coalesce interval must not be negative") }
+    e.mu.Lock(); defer e.mu.Unlock()
+    if e.samplers == nil { e.samplers = map[core.EventType]*sampler{} }
+    if p == (SamplingPolicy{}) {
+        delete(e.samplers, typ)
+        return
+    }
+    e.samplers[typ] = &sampler{policy: p, pending: map[core.UserID]map[core.Metric]*pendingEvent{}}
+}
+
+// sample returns ev as it should be delivered now, or false if it is dropped or held. Coalesced events are held and delivered by a
+// timer; before any other event of a user is delivered, that user's held events are flushed so
+// subscribers still see them in order.
+func (e *EventBus) sample(ctx context.Context, ev core.Event) (core.Event, bool) {
+    e.mu.Lock()
+    s := e.samplers[ev.Type]
+    var flush []core.Event
+    if s == nil || s.policy.Coalesce == 0 {
+        flush = e.takePendingLocked(ev.UserID)
+    }
+    e.mu.Unlock()
+    for _, held := range flush { e.deliver(ctx, held) }
+    if s == nil { return ev, true }
+
+    if s.policy.Coalesce > 0 {
+        e.mu.Lock(); defer e.mu.Unlock()
+        byMetric := s.pending[ev.UserID]
+        if byMetric == nil {
+            byMetric = map[core.Metric]*pendingEvent{}
+            s.pending[ev.UserID] = byMetric
+        }
+        if p, ok := byMetric[ev.Metric]; ok {
+            p.ev.Delta += ev.Delta
+            p.ev.Total, p.ev.Time = ev.Total, ev.Time
+            p.count++
+            return ev, false
+        }
+        p := &pendingEvent{ev: ev, count: 1}
+        user, metric := ev.UserID, ev.Metric
+        p.timer = time.AfterFunc(s.policy.Coalesce, func() { e.flushCoalesced(s, user, metric) })
+        byMetric[ev.Metric] = p
+        return ev, false
+    }
+    if !e.keepSample(s) { return ev, false }
+    return s.annotate(&pendingEvent{ev: ev, count: 1}), true
+}
+
+// keepSample counts an event against the policy rate and reports whether to deliver it
+func (e *EventBus) keepSample(s *sampler) bool {
+    if s.policy.Rate == 0 || s.policy.Rate >= 1 { return true }
+    e.mu.Lock(); defer e.mu.Unlock()
+    s.seen++
+    return uint64(float64(s.seen)*s.policy.Rate) != uint64(float64(s.seen-1)*s.policy.Rate)
+}
+
+// flushCoalesced delivers the held event for user and metric once its interval ends
+func (e *EventBus) flushCoalesced(s *sampler, user core.UserID, metric core.Metric) {
+    e.mu.Lock()
+    p, ok := s.pending[user][metric]
+    if ok {
+        delete(s.pending[user], metric)
+        if len(s.pending[user]) == 0 { delete(s.pending, user) }
+    }
+    e.mu.Unlock()
+    if ok && e.keepSample(s) { e.deliver(context.Background(), s.annotate(p)) }
+}
+
+// takePendingLocked removes and returns the user's held events of every sampled type
+func (e *EventBus) takePendingLocked(user core.UserID) []core.Event {
+    var out []core.Event
+    for _, s := range e.samplers {
+        for _, p := range s.pending[user] {
+            p.timer.Stop()
+            out = append(out, s.annotate(p))
+        }
+        delete(s.pending, user)
+    }
+    return out
+}
+
+// annotate finalizes a held event with its merge count and the sample rate
+func (s *sampler) annotate(p *pendingEvent) core.Event {
+    ev := p.ev
+    meta := make(map[string]any, len(ev.Metadata)+2)
+    for k, v := range ev.Metadata { meta[k] = v }
+    meta["count"] = p.count
+    if s.policy.Rate > 0 && s.policy.Rate < 1 { meta["sample_rate"] = s.policy.Rate }
+    ev.Metadata = meta
+    return ev
+}
+
+// flushAllPending delivers every held event, e.g. on Shutdown
+func (e *EventBus) flushAllPending() {
+    e.mu.Lock()
+    var out []core.Event
+    for _, s := range e.samplers {
+        for user := range s.pending {
+            for _, p := range s.pending[user] {
+                p.timer.Stop()
+                out = append(out, s.annotate(p))
+            }
+        }
+        s.pending = map[core.UserID]map[core.Metric]*pendingEvent{}
+    }
+    e.mu.Unlock()
+    for _, ev := range out { e.deliver(context.Background(), ev) }
+}
@@ -0,0 +1,252 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "gamifykit/core"
+)
+
+var (
+    // ErrNoActiveSeason is returned by season queries that name no season while none is active.
+    ErrNoActiveSeason = errors.New("no active season")
+    // ErrSeasonChanged is returned by StartNewSeason when another instance switched the stored
+    // season first.
+    ErrSeasonChanged = errors.New("active season changed concurrently")
+    // ErrNoSeasonArchive is returned by ArchiveSeason on services without WithSeasonArchive.
+    ErrNoSeasonArchive = errors.New("no season archive configured")
+)
+
+// SeasonRefresh is how long the service trusts its copy of the active season before reading it
+// from a SeasonKeeper storage again, so instances pick up a switch made by another one.
+const SeasonRefresh = 10 * time.Second
+
+// SeasonKeeper is implemented by storages that persist the active season, so it survives restarts
+// and every instance sharing the storage records into the same season. It is looked up through
+// storage wrappers such as NamespacedStorage, since the active season is one for the whole service.
+type SeasonKeeper interface {
+    // ActiveSeason returns the stored active season, "" if none was stored yet.
+    ActiveSeason(ctx context.Context) (string, error)
+    // SwitchSeason stores next as the active season if previous still is, and reports whether it did.
+    SwitchSeason(ctx context.Context, previous, next string) (bool, error)
+}
+
+// SeasonArchiver stores the final standings of metric in an ended season, e.g. in a
+// leaderboard.ArchiveStore (see gamify.WithSeasonArchive). Archiving a season twice should keep the
+// first snapshot.
+type SeasonArchiver func(ctx context.Context, season string, metric core.Metric, standings []SeasonStanding) error
+
+// seasons holds the active season and the per-season boards handed out so far
+type seasons struct {
+    mu      sync.RWMutex
+    active  string
+    checked time.Time // when active was last read from a SeasonKeeper; zero before the first read
+    boards  []seasonBoards
+    archive SeasonArchiver
+    metrics []core.Metric // archived metrics, see WithSeasonArchive
+}
+
+type seasonBoards struct {
+    metric core.Metric
+    cfg    SeasonBoardConfig
+    byName map[string]Leaderboard
+}
+
+// SeasonBoardConfig registers a per-season leaderboard for a metric.
+type SeasonBoardConfig struct {
+    // Board returns the board of a season, e.g. a Redis board keyed "game:xp:"+season. It is called
+    // once per season; boards of ended seasons are left as they are and keep the final standings.
+    Board func(season string) Leaderboard
+    // MinScore is the inclusion threshold, as in BoardConfig.
+    MinScore int64
+}
+
+// WithActiveSeason sets the season AddPoints records into until StartNewSeason moves on. On a
+// SeasonKeeper storage it only applies until a season is stored: it is stored on first use, and a
+// stored season wins over it. Other storages do not persist the active season, so pass the current
+// one at every start.
+func WithActiveSeason(season string) ServiceOption {
+    return func(g *GamifyService){ g.seasons.active = season }
+}
+
+// WithSeasonLeaderboard keeps a board per season updated with the active season's totals of metric.
+func WithSeasonLeaderboard(metric core.Metric, cfg SeasonBoardConfig) ServiceOption {
+    return func(g *GamifyService){ g.seasons.boards = append(g.seasons.boards, seasonBoards{metric: metric, cfg: cfg, byName: map[string]Leaderboard{}}) }
+}
+
+// WithSeasonArchive makes StartNewSeason archive the final standings of the ended season with fn,
+// for each of metrics or, when none are given, each metric with a season leaderboard. See ArchiveSeason.
+func WithSeasonArchive(fn SeasonArchiver, metrics ...core.Metric) ServiceOption {
+    if fn == nil { panic("WithSeasonArchive: nil archiver") }
+    return func(g *GamifyService){
+        g.seasons.archive = fn
+        g.seasons.metrics = append(g.seasons.metrics, metrics...)
+    }
+}
+
+// WithSeason records the points in season's ledger of the metric as well as the all-time total,
+// overriding the active season. Seasons are named by ValidateSeason's rules.
+func WithSeason(season string) AddOption {
+    return func(o *addOptions){ o.season = season }
+}
+
+// ActiveSeason returns the season AddPoints currently records into, or "" if none is active. On a
+// SeasonKeeper storage it is the season as last read from the storage.
+func (g *GamifyService) ActiveSeason() string {
+    g.seasons.mu.RLock(); defer g.seasons.mu.RUnlock()
+    return g.seasons.active
+}
+
+// currentSeason returns the active season, reading it from a SeasonKeeper storage first when the
+// copy held is older than SeasonRefresh or force is set. A season set with WithActiveSeason is
+// stored when the storage has none yet. When the read fails, a copy read before is used unless force is set.
+func (g *GamifyService) currentSeason(ctx context.Context, force bool) (string, error) {
+    keeper, ok := innermost(g.storage).(SeasonKeeper)
+    g.seasons.mu.RLock()
+    active, checked := g.seasons.active, g.seasons.checked
+    g.seasons.mu.RUnlock()
+    if !ok || (!force && !checked.IsZero() && core.CurrentTime().Sub(checked) < SeasonRefresh) { return active, nil }
+    stored, err := keeper.ActiveSeason(ctx)
+    if err != nil && !force && !checked.IsZero() { return active, nil }
+    if err != nil { return "", fmt.Errorf("failed to read the active season: %w", err) }
+    if stored == "" && active != "" && checked.IsZero() {
+        // another instance may store its season first; then that one holds
+        if _, err := keeper.SwitchSeason(ctx, "", active); err != nil { return "", fmt.Errorf("failed to store the active season: %w", err) }
+        if stored, err = keeper.ActiveSeason(ctx); err != nil { return "", fmt.Errorf("failed to read the active season: %w", err) }
+    }
+    g.seasons.mu.Lock(); defer g.seasons.mu.Unlock()
+    g.seasons.active, g.seasons.checked = stored, core.CurrentTime()
+    return stored, nil
+}
+
+// StartNewSeason makes next the active season and returns the season it replaces ("" if none was
+// active). The ended season's ledgers and boards are kept, so its final standings stay available
+// through GetSeasonState, SeasonStandings and its season boards, and they are archived with
+// WithSeasonArchive. All-time totals are unaffected.
+//
+// On a SeasonKeeper storage the switch is stored, and fails with ErrSeasonChanged if another
+// instance switched first; other instances follow within SeasonRefresh. Points added concurrently
+// with the switch may land in either season. If archiving fails, the new season is active anyway
+// and the error is returned with the ended season; retry with ArchiveSeason.
+func (g *GamifyService) StartNewSeason(ctx context.Context, next string) (string, error) {
+    if err := core.ValidateSeason(next); err != nil { return "", err }
+    previous, err := g.currentSeason(ctx, true)
+    if err != nil { return "", err }
+    if previous == next { return "", fmt.Errorf("season %q is already active", next) }
+    if keeper, ok := innermost(g.storage).(SeasonKeeper); ok {
+        switched, err := keeper.SwitchSeason(ctx, previous, next)
+        if err != nil { return "", fmt.Errorf("failed to store the active season: %w", err) }
+        if !switched { return "", fmt.Errorf("%w: %q is no longer active", ErrSeasonChanged, previous) }
+    }
+    g.seasons.mu.Lock()
+    if g.seasons.active != previous {
+        g.seasons.mu.Unlock()
+        return "", fmt.Errorf("%w: %q is no longer active", ErrSeasonChanged, previous)
+    }
+    g.seasons.active, g.seasons.checked = next, core.CurrentTime()
+    g.seasons.mu.Unlock()
+    if previous != "" && g.seasons.archive != nil {
+        if err := g.ArchiveSeason(ctx, previous); err != nil { return previous, fmt.Errorf("season %q ended, but archiving it failed: %w", previous, err) }
+    }
+    return previous, nil
+}
+
+// ArchiveSeason hands the standings of season to the archiver set with WithSeasonArchive, one call
+// per archived metric. StartNewSeason does so for the season it ends; call it directly to retry a
+// failed archive or to archive a season ended before the archive was configured.
+func (g *GamifyService) ArchiveSeason(ctx context.Context, season string) error {
+    if g.seasons.archive == nil { return ErrNoSeasonArchive }
+    if err := core.ValidateSeason(season); err != nil { return err }
+    for _, metric := range g.archivedMetrics() {
+        standings, err := g.SeasonStandings(ctx, season, metric)
+        if err != nil { return err }
+        if err := g.seasons.archive(ctx, season, metric, standings); err != nil { return fmt.Errorf("failed to archive %s: %w", metric, err) }
+    }
+    return nil
+}
+
+// archivedMetrics returns the metrics passed to WithSeasonArchive, or those with season boards
+func (g *GamifyService) archivedMetrics() []core.Metric {
+    if len(g.seasons.metrics) > 0 { return g.seasons.metrics }
+    var metrics []core.Metric
+    seen := map[core.Metric]bool{}
+    for _, sb := range g.seasons.boards {
+        if !seen[sb.metric] { metrics = append(metrics, sb.metric) }
+        seen[sb.metric] = true
+    }
+    return metrics
+}
+
+// GetSeasonState returns the user's points and levels in one season under their base metric
+// names, with derived levels computed from the season's totals; badges are not seasonal and are
+// included as they are. An empty season selects the active one. GetState returns all-time totals.
+func (g *GamifyService) GetSeasonState(ctx context.Context, user core.UserID, season string) (core.UserState, error) {
+    season, err := g.resolveSeason(ctx, season)
+    if err != nil { return core.UserState{}, err }
+    state, err := g.storage.GetState(ctx, user)
+    if err != nil { return state, err }
+    return g.view(state.Season(season)), nil
+}
+
+// SeasonStanding is a user's total of a metric in a season.
+type SeasonStanding struct {
+    User   core.UserID `json:"user"`
+    Points int64       `json:"points"`
+}
+
+// SeasonStandings walks all users and returns everyone with points of metric in season, highest
+// first (ties by user ID), e.g. to archive the results of a season StartNewSeason ended. An empty
+// season selects the active one. The storage must implement UserLister.
+func (g *GamifyService) SeasonStandings(ctx context.Context, season string, metric core.Metric) ([]SeasonStanding, error) {
+    season, err := g.resolveSeason(ctx, season)
+    if err != nil { return nil, err }
+    lister, ok := g.storage.(UserLister)
+    if !ok { return nil, ErrUserListingUnsupported }
+    key := core.SeasonMetric(metric, season)
+    var standings []SeasonStanding
+    err = lister.EachUser(ctx, func(user core.UserID) error {
+        state, err := g.storage.GetState(ctx, user)
+        if err != nil { return fmt.Errorf("failed to load user %s: %w", user, err) }
+        if points, ok := state.Points[key]; ok { standings = append(standings, SeasonStanding{User: user, Points: points}) }
+        return nil
+    })
+    if err != nil { return nil, err }
+    sort.Slice(standings, func(i, j int) bool {
+        if standings[i].Points != standings[j].Points { return standings[i].Points > standings[j].Points }
+        return standings[i].User < standings[j].User
+    })
+    return standings, nil
+}
+
+// resolveSeason validates season, substituting the active season for ""
+func (g *GamifyService) resolveSeason(ctx context.Context, season string) (string, error) {
+    if season == "" {
+        active, err := g.currentSeason(ctx, false)
+        if err != nil { return "", err }
+        if season = active; season == "" { return "", ErrNoActiveSeason }
+    }
+    return season, core.ValidateSeason(season)
+}
+
+// syncSeasonBoards updates the season boards of metric when season is the active one
+func (g *GamifyService) syncSeasonBoards(user core.UserID, metric core.Metric, season string, total int64) {
+    g.seasons.mu.Lock(); defer g.seasons.mu.Unlock()
+    if season != g.seasons.active { return }
+    for _, sb := range g.seasons.boards {
+        if sb.metric != metric { continue }
+        board, ok := sb.byName[season]
+        if !ok {
+            board = sb.cfg.Board(season)
+            sb.byName[season] = board
+        }
+        if total >= sb.cfg.MinScore {
+            board.Update(user, total)
+        } else {
+            board.Remove(user)
+        }
+    }
+}
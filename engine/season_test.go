@@ -0,0 +1,128 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func TestSeasons(t *testing.T) {
+    ctx := context.Background()
+    boards := map[string]*leaderboard.SkipList{}
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithActiveSeason("2024-spring"),
+        WithSeasonLeaderboard(core.MetricXP, SeasonBoardConfig{Board: func(season string) Leaderboard {
+            boards[season] = leaderboard.NewSkipList()
+            return boards[season]
+        }}))
+
+    // season-less calls record into the active season and the all-time total
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 100); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "bob", core.MetricXP, 40); err != nil { t.Fatal(err) }
+    if prev, err := svc.StartNewSeason(ctx, "2024-summer"); err != nil || prev != "2024-spring" { t.Fatalf("want 2024-spring ended, got %q %v", prev, err) }
+    total, err := svc.AddPoints(ctx, "alice", core.MetricXP, 30)
+    if err != nil || total != 130 { t.Fatalf("all-time total should be 130, got %d %v", total, err) }
+    // an explicit season wins over the active one
+    if _, err := svc.AddPoints(ctx, "bob", core.MetricXP, 5, WithSeason("2024-spring")); err != nil { t.Fatal(err) }
+
+    all, _ := svc.GetState(ctx, "alice")
+    if len(all.Points) != 1 || all.Points[core.MetricXP] != 130 { t.Fatalf("GetState should hold only all-time totals, got %v", all.Points) }
+    summer, err := svc.GetSeasonState(ctx, "alice", "")
+    if err != nil || summer.Points[core.MetricXP] != 30 { t.Fatalf("active season should hold 30, got %v %v", summer.Points, err) }
+    spring, _ := svc.GetSeasonState(ctx, "alice", "2024-spring")
+    if spring.Points[core.MetricXP] != 100 { t.Fatalf("spring should hold 100, got %v", spring.Points) }
+
+    standings, err := svc.SeasonStandings(ctx, "2024-spring", core.MetricXP)
+    if err != nil { t.Fatal(err) }
+    if len(standings) != 2 || standings[0] != (SeasonStanding{"alice", 100}) || standings[1] != (SeasonStanding{"bob", 45}) {
+        t.Fatalf("unexpected spring standings %v", standings)
+    }
+
+    // the ended season's board keeps its standings; only writes to the active season reach boards
+    if e, _ := boards["2024-spring"].Get("bob"); e.Score != 40 { t.Fatalf("spring board should keep bob at 40, got %d", e.Score) }
+    if e, _ := boards["2024-summer"].Get("alice"); e.Score != 30 { t.Fatalf("summer board should have alice at 30, got %d", e.Score) }
+}
+
+func TestSeasonValidation(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine())
+    if _, err := svc.GetSeasonState(ctx, "alice", ""); !errors.Is(err, ErrNoActiveSeason) { t.Fatalf("want ErrNoActiveSeason, got %v", err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 1, WithSeason("spring@2024")); !errors.Is(err, core.ErrInvalidSeason) { t.Fatalf("want ErrInvalidSeason, got %v", err) }
+    if _, err := svc.StartNewSeason(ctx, ""); !errors.Is(err, core.ErrInvalidSeason) { t.Fatalf("want ErrInvalidSeason, got %v", err) }
+    if _, err := svc.StartNewSeason(ctx, "s1"); err != nil { t.Fatal(err) }
+    if _, err := svc.StartNewSeason(ctx, "s1"); err == nil { t.Fatal("restarting the active season should fail") }
+}
+
+func TestSeasonMetricsReserved(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s1"))
+    if _, err := svc.AddPoints(ctx, "alice", core.SeasonMetric(core.MetricXP, "s1"), 5); !errors.Is(err, core.ErrReservedMetric) { t.Fatalf("writing a season ledger = %v, want ErrReservedMetric", err) }
+    if _, err := svc.AddPoints(ctx, "alice", "a@b", 5); !errors.Is(err, core.ErrReservedMetric) { t.Fatalf("metric with the separator = %v, want ErrReservedMetric", err) }
+    if err := svc.Transfer(ctx, "alice", "bob", "xp@s1", 1); !errors.Is(err, core.ErrReservedMetric) { t.Fatalf("transfer of a season ledger = %v, want ErrReservedMetric", err) }
+    if st, _ := svc.GetSeasonState(ctx, "alice", ""); len(st.Points) != 0 { t.Fatalf("rejected writes reached the ledger: %v", st.Points) }
+}
+
+func TestSeasonPersisted(t *testing.T) {
+    ctx := context.Background()
+    clock := core.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+    defer core.SetClock(core.SetClock(clock.Now))
+    store := mem.New()
+    first := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s1"))
+    if _, err := first.AddPoints(ctx, "alice", core.MetricXP, 10); err != nil { t.Fatal(err) }
+    if stored, _ := store.ActiveSeason(ctx); stored != "s1" { t.Fatalf("stored season = %q, want s1", stored) }
+
+    // a restart without the option, or with a stale one, records into the stored season
+    second := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s0"))
+    if _, err := second.AddPoints(ctx, "bob", core.MetricXP, 5); err != nil { t.Fatal(err) }
+    if st, _ := second.GetSeasonState(ctx, "bob", "s1"); st.Points[core.MetricXP] != 5 { t.Fatalf("bob's s1 ledger = %v, want 5", st.Points) }
+
+    if prev, err := first.StartNewSeason(ctx, "s2"); err != nil || prev != "s1" { t.Fatalf("StartNewSeason = %q, %v", prev, err) }
+    if got := second.ActiveSeason(); got != "s1" { t.Fatalf("second instance switched before refreshing: %q", got) }
+    clock.Advance(SeasonRefresh)
+    if _, err := second.AddPoints(ctx, "bob", core.MetricXP, 1); err != nil { t.Fatal(err) }
+    if got := second.ActiveSeason(); got != "s2" { t.Fatalf("second instance season after refresh = %q, want s2", got) }
+    if _, err := second.StartNewSeason(ctx, "s2"); err == nil { t.Fatal("restarting the stored season should fail") }
+}
+
+func TestSeasonArchive(t *testing.T) {
+    ctx := context.Background()
+    archived := map[string][]SeasonStanding{}
+    archive := func(_ context.Context, season string, metric core.Metric, standings []SeasonStanding) error {
+        archived[season+"/"+string(metric)] = standings
+        return nil
+    }
+    func() {
+        defer func() { if recover() == nil { t.Fatal("an archive without metrics or season boards should panic") } }()
+        NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithSeasonArchive(archive))
+    }()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s1"), WithSeasonArchive(archive, core.MetricXP))
+    if err := svc.ArchiveSeason(ctx, "s1"); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "bob", core.MetricXP, 20); err != nil { t.Fatal(err) }
+    if _, err := svc.StartNewSeason(ctx, "s2"); err != nil { t.Fatal(err) }
+    got := archived["s1/xp"]
+    if len(got) != 2 || got[0] != (SeasonStanding{"bob", 20}) || got[1] != (SeasonStanding{"alice", 10}) { t.Fatalf("archived s1 standings %v", got) }
+    if err := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine()).ArchiveSeason(ctx, "s1"); !errors.Is(err, ErrNoSeasonArchive) { t.Fatalf("want ErrNoSeasonArchive, got %v", err) }
+}
+
+func TestSeasonTransfer(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithActiveSeason("s1"))
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10); err != nil { t.Fatal(err) }
+    if err := svc.Transfer(ctx, "alice", "bob", core.MetricXP, 4); err != nil { t.Fatal(err) }
+    alice, _ := svc.GetSeasonState(ctx, "alice", "")
+    bob, _ := svc.GetSeasonState(ctx, "bob", "")
+    if alice.Points[core.MetricXP] != 6 || bob.Points[core.MetricXP] != 4 { t.Fatalf("s1 ledgers alice %v bob %v, want 6 and 4", alice.Points, bob.Points) }
+
+    // points earned in an earlier season do not take the sender's ledger of this one below zero
+    if _, err := svc.StartNewSeason(ctx, "s2"); err != nil { t.Fatal(err) }
+    if err := svc.Transfer(ctx, "alice", "bob", core.MetricXP, 5); err != nil { t.Fatal(err) }
+    alice, _ = svc.GetSeasonState(ctx, "alice", "")
+    bob, _ = svc.GetSeasonState(ctx, "bob", "")
+    if alice.Points[core.MetricXP] != 0 || bob.Points[core.MetricXP] != 5 { t.Fatalf("s2 ledgers alice %v bob %v, want 0 and 5", alice.Points, bob.Points) }
+}
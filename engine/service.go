@@ -3,8 +3,12 @@ package engine
 import (
     "context"
     "errors"
+    "fmt"
+    "sync/atomic"
+    "time"
 
     "gamifykit/core"
+    "gamifykit/tracing"
 )
 
 // GamifyService wires storage, event bus, and rules into a cohesive API.
@@ -12,68 +16,371 @@ type GamifyService struct {
     storage    Storage
     bus        *EventBus
     rules      RuleEngine
+    policies   map[core.Metric]ValuePolicy
+    derived    map[core.Metric]LevelCurve
+    computed   map[core.Metric]func(core.UserState) int64 // see WithDerivedMetric
+    monotonic  map[core.Metric]bool
+    boards     map[core.Metric][]BoardConfig
+    composites []compositeBoard
+    compLocks  *userLocks // serialize composite board recomputations per user
+    projected  bool
+    maintained []maintainedBadge
+    repeatable map[core.Badge]time.Duration
+    seasons    seasons
+    retry      RetryPolicy
+    limits     UserLimits
+    catalog    catalog
+    lifecycle  Lifecycle
+    enrichers  []EventEnricher
+    idKinds    map[string]bool // external ID kinds, see WithIdentityKinds
+    userLocks  *userLocks      // see WithUserSerialization
+    quests     []Quest
 }
 
-func NewGamifyService(storage Storage, bus *EventBus, rules RuleEngine) *GamifyService {
+// ServiceOption customizes a GamifyService at construction time.
+type ServiceOption func(*GamifyService)
+
+// WithValuePolicy constrains the totals of metric. Metrics without a policy use DefaultValuePolicy.
+func WithValuePolicy(metric core.Metric, p ValuePolicy) ServiceOption {
+    return func(g *GamifyService){ g.policies[metric] = p }
+}
+
+func NewGamifyService(storage Storage, bus *EventBus, rules RuleEngine, opts ...ServiceOption) *GamifyService {
     if storage == nil || bus == nil || rules == nil {
         panic("NewGamifyService requires non-nil storage, bus, and rules")
     }
-    return &GamifyService{storage: storage, bus: bus, rules: rules}
+    g := &GamifyService{storage: storage, bus: bus, rules: rules, policies: map[core.Metric]ValuePolicy{}, derived: map[core.Metric]LevelCurve{}, monotonic: map[core.Metric]bool{}, boards: map[core.Metric][]BoardConfig{}, repeatable: map[core.Badge]time.Duration{}, catalog: catalog{metrics: map[core.Metric]MetricInfo{}, badges: map[core.Badge]BadgeInfo{}}}
+    for _, o := range opts { o(g) }
+    g.checkDerivedMetrics()
+    g.catalog.registerImplied(g)
+    for metric, boards := range g.boards {
+        for _, b := range boards {
+            if b.Board == nil { panic(fmt.Sprintf("NewGamifyService: nil leaderboard for %q", metric)) }
+        }
+    }
+    for _, sb := range g.seasons.boards {
+        if sb.cfg.Board == nil { panic(fmt.Sprintf("NewGamifyService: nil season board factory for %q", sb.metric)) }
+    }
+    if g.projected && (len(g.boards) > 0 || len(g.composites) > 0) { g.subscribeBoards() }
+    if g.seasons.active != "" {
+        if err := core.ValidateSeason(g.seasons.active); err != nil {
+            panic(fmt.Sprintf("NewGamifyService: invalid active season %q", g.seasons.active))
+        }
+    }
+    if g.seasons.archive != nil && len(g.archivedMetrics()) == 0 {
+        panic("NewGamifyService: WithSeasonArchive needs metrics or a season leaderboard")
+    }
+    if _, ok := storage.(BadgeRemover); len(g.maintained) > 0 && !ok {
+        panic("NewGamifyService: maintained badges require a storage implementing BadgeRemover")
+    }
+    if _, ok := storage.(BadgeRepeater); len(g.repeatable) > 0 && !ok {
+        panic("NewGamifyService: repeatable badges require a storage implementing BadgeRepeater")
+    }
+    for metric, p := range g.policies {
+        if err := p.Validate(); err != nil {
+            panic(fmt.Sprintf("NewGamifyService: invalid value policy for %q: %v", metric, err))
+        }
+    }
+    return g
 }
 
 func DefaultRuleEngine() RuleEngine {
-    return &simpleRuleEngine{rules: []core.Rule{core.LevelUpRule{Metric: core.MetricXP}}}
+    return LevelUpRuleEngine(core.MetricXP)
+}
+
+// LevelUpRuleEngine levels up each of the given metrics with core.DefaultLevel.
+func LevelUpRuleEngine(metrics ...core.Metric) RuleEngine {
+    rules := make([]core.Rule, 0, len(metrics))
+    for _, m := range metrics { rules = append(rules, core.LevelUpRule{Metric: m}) }
+    return &simpleRuleEngine{rules: rules}
 }
 
 // Subscribe convenience method.
-func (g *GamifyService) Subscribe(typ core.EventType, handler func(context.Context, core.Event)) func() {
-    return g.bus.Subscribe(typ, handler)
+func (g *GamifyService) Subscribe(typ core.EventType, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    return g.bus.Subscribe(typ, handler, ordering...)
 }
 
+// SubscribeNamed subscribes a handler under a name used in dispatch metrics; see EventBus.SubscribeNamed.
+func (g *GamifyService) SubscribeNamed(name string, typ core.EventType, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    return g.bus.SubscribeNamed(name, typ, handler, ordering...)
+}
+
+// SubscribeAllNamed subscribes a handler to every event type; see EventBus.SubscribeAllNamed.
+func (g *GamifyService) SubscribeAllNamed(name string, handler func(context.Context, core.Event), ordering ...Ordering) func() {
+    return g.bus.SubscribeAllNamed(name, handler, ordering...)
+}
+
+// Publish publishes ev through the service's enrichers (see WithEventEnricher) to the bus.
 func (g *GamifyService) Publish(ctx context.Context, ev core.Event) {
-    g.bus.Publish(ctx, ev)
+    g.publish(ctx, ev)
+}
+
+// AddPoints adds delta to the user's all-time total of metric and, with an active season or
+// WithSeason, to the season's ledger; see AddPointsIf.
+func (g *GamifyService) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64, opts ...AddOption) (int64, error) {
+    _, total, err := g.AddPointsIf(ctx, user, metric, delta, opts...)
+    return total, err
+}
+
+// AddOption customizes a single AddPointsIf call.
+type AddOption func(*addOptions)
+
+type addOptions struct{
+    conditions []func(core.UserState) bool
+    season     string
+}
+
+// WithCondition applies the points only if pred accepts the user's current state
+// (levels of derived metrics already computed), e.g. bonus XP below level 10.
+// Several conditions must all pass.
+func WithCondition(pred func(core.UserState) bool) AddOption {
+    return func(o *addOptions){ o.conditions = append(o.conditions, pred) }
 }
 
-func (g *GamifyService) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+// AddPointsIf is AddPoints with per-call options. applied reports whether the points were written;
+// total is always the all-time total. The season ledger gets the same change as the all-time total,
+// bounded by the metric's value policy on its own.
+// when a condition rejects the award, total is the unchanged current total and no events are published.
+// The state check and the write run in one transaction with the user locked on storages that support
+// it (see Txner and UserLocker), so concurrent level changes cannot slip in between; other storages
+// check on a best-effort basis. Value policies hold under concurrent writes either way: storages
+// implementing PointsUpdater apply them in one atomic step, and transactional ones lock the user.
+func (g *GamifyService) AddPointsIf(ctx context.Context, user core.UserID, metric core.Metric, delta int64, opts ...AddOption) (applied bool, total int64, err error) {
+    ctx, span := tracing.Start(ctx, "engine.AddPoints")
+    span.SetUser(user)
+    span.SetAttr("metric", string(metric))
+    defer func(){ span.End(err) }()
     if delta == 0 {
-        return 0, errors.New("delta cannot be zero")
+        return false, 0, errors.New("delta cannot be zero")
     }
     normalized, err := core.NormalizeUserID(user)
     if err != nil {
-        return 0, err
+        return false, 0, err
+    }
+    if err := g.checkMetric(metric); err != nil {
+        return false, 0, err
+    }
+    var o addOptions
+    for _, opt := range opts { opt(&o) }
+    season := o.season
+    if season == "" {
+        if season, err = g.currentSeason(ctx, false); err != nil { return false, 0, err }
+    }
+    if season != "" {
+        if err := core.ValidateSeason(season); err != nil { return false, 0, err }
     }
-    total, err := g.storage.AddPoints(ctx, normalized, metric, delta)
+
+    policy := g.valuePolicy(metric)
+    bounded := policy != DefaultValuePolicy()
+    unlock, err := g.lockUsers(ctx, normalized)
     if err != nil {
-        return 0, err
+        return false, 0, err
     }
-    ev := core.NewPointsAdded(normalized, metric, delta, total)
-    g.bus.Publish(ctx, ev)
-    state, err := g.storage.GetState(ctx, normalized)
-    if err == nil {
-        derived := g.rules.Evaluate(ctx, state, ev)
-        for _, d := range derived {
-            // allow rules to update storage when needed
-            if d.Type == core.EventLevelUp {
-                _ = g.storage.SetLevel(ctx, d.UserID, d.Metric, d.Level)
+    var previous, written, seasonTotal int64
+    err = g.withRetry(ctx, "add_points", func() error {
+        applied = false
+        return RunInTx(ctx, g.storage, func(tx Storage) error {
+            if l, ok := tx.(UserLocker); ok && (len(o.conditions) > 0 || bounded) {
+                if err := l.LockUser(ctx, normalized); err != nil { return err }
             }
-            g.bus.Publish(ctx, d)
-        }
+            current, err := tx.GetState(ctx, normalized)
+            if err != nil {
+                return err
+            }
+            previous, total = current.Points[metric], current.Points[metric]
+            if err := g.checkMetricLimit(current, normalized, metric); err != nil { return err }
+            multiplier, multiplied := g.rules.(PointsMultiplier)
+            scaled := delta
+            if len(o.conditions) > 0 || (multiplied && delta > 0) {
+                view := g.view(current)
+                for _, cond := range o.conditions {
+                    if !cond(view) { return nil }
+                }
+                if multiplied && delta > 0 { scaled = multiplier.MultiplyPoints(ctx, view, metric, delta) }
+            }
+            // apply the metric's value policy to the stored total so every adapter enforces the same
+            // bounds: in one atomic step where the storage can (see PointsUpdater), otherwise on the
+            // snapshot, which the user lock above protects on transactional storages
+            updater, atomic := tx.(PointsUpdater)
+            atomic = atomic && bounded
+            apply := func(delta int64) func(int64) (int64, error) {
+                return func(current int64) (int64, error) { return policy.Apply(current, delta) }
+            }
+            if atomic {
+                previous, total, err = updater.UpdatePoints(ctx, normalized, metric, apply(scaled))
+            } else {
+                var next int64
+                if next, err = policy.Apply(previous, scaled); err == nil && next != previous {
+                    total, err = tx.AddPoints(ctx, normalized, metric, next-previous)
+                }
+            }
+            if err != nil {
+                return err
+            }
+            if total == previous {
+                return nil
+            }
+            written = total - previous
+            if season != "" {
+                // without a transaction the all-time write is kept, so a retry would repeat it
+                txn := transactional(g.storage)
+                key := core.SeasonMetric(metric, season)
+                seasonTotal = current.Points[key]
+                if atomic {
+                    _, seasonTotal, err = updater.UpdatePoints(ctx, normalized, key, apply(written))
+                } else {
+                    var next int64
+                    if next, err = policy.Apply(seasonTotal, written); err == nil && next != seasonTotal {
+                        seasonTotal, err = tx.AddPoints(ctx, normalized, key, next-seasonTotal)
+                    }
+                }
+                if err != nil {
+                    if !txn { return noRetry{err} }
+                    return err
+                }
+            }
+            applied = true
+            return nil
+        })
+    })
+    unlock()
+    if err != nil {
+        return false, 0, err
+    }
+    if applied {
+        if season != "" { g.syncSeasonBoards(normalized, metric, season, seasonTotal) }
+        g.afterAddPoints(ctx, normalized, metric, written, total)
+    }
+    return applied, total, nil
+}
+
+// afterAddPoints syncs leaderboards and publishes the points event, derived level changes, quest
+// progress and rule output
+func (g *GamifyService) afterAddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta, total int64) {
+    g.syncBoards(ctx, user, metric, total)
+    ev := core.NewPointsAdded(user, metric, delta, total)
+    g.publish(ctx, ev)
+    g.levelChange(ctx, user, metric, total-delta, total)
+    g.advanceQuests(ctx, user, metric, delta)
+    state, err := g.storage.GetState(ctx, user)
+    if err == nil {
+        _ = g.applyRules(ctx, user, state, ev)
     }
-    return total, nil
 }
 
 func (g *GamifyService) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+    _, err := g.AwardBadgeResult(ctx, user, badge)
+    return err
+}
+
+// AwardBadgeResult awards badge and reports whether the user earned it just now rather than
+// already holding it, e.g. to decide whether to celebrate. core.EventBadgeAwarded is published
+// only for new awards. Storages implementing BadgeAwarder decide atomically; for others the
+// check is best-effort and concurrent awards may both report true. Repeatable badges are awarded
+// again once their cooldown has passed and fail with ErrCooldownActive before; see WithRepeatableBadge.
+func (g *GamifyService) AwardBadgeResult(ctx context.Context, user core.UserID, badge core.Badge) (awarded bool, err error) {
+    ctx, span := tracing.Start(ctx, "engine.AwardBadge")
+    span.SetUser(user)
+    span.SetAttr("badge", string(badge))
+    defer func(){ span.End(err) }()
     normalized, err := core.NormalizeUserID(user)
     if err != nil {
-        return err
+        return false, err
     }
     if err := core.ValidateBadgeID(badge); err != nil {
-        return err
+        return false, err
     }
-    if err := g.storage.AwardBadge(ctx, normalized, badge); err != nil {
+    if err := g.checkBadge(badge); err != nil {
+        return false, err
+    }
+    if err := g.checkBadgeLimit(ctx, normalized, badge); err != nil {
+        return false, err
+    }
+    if cooldown, ok := g.repeatable[badge]; ok {
+        return g.repeatBadge(ctx, normalized, badge, cooldown)
+    }
+    unlock, err := g.lockUsers(ctx, normalized)
+    if err != nil {
+        return false, err
+    }
+    err = g.withRetry(ctx, "award_badge", func() (err error) {
+        awarded, err = tryAwardBadge(ctx, g.storage, normalized, badge)
         return err
+    })
+    unlock()
+    if err != nil || !awarded {
+        return false, err
+    }
+    g.publish(ctx, core.NewBadgeAwarded(normalized, badge))
+    return true, nil
+}
+
+// tryAwardBadge awards badge through BadgeAwarder, or checks the user's state first on other storages
+func tryAwardBadge(ctx context.Context, storage Storage, user core.UserID, badge core.Badge) (bool, error) {
+    if a, ok := storage.(BadgeAwarder); ok {
+        return a.TryAwardBadge(ctx, user, badge)
+    }
+    state, err := storage.GetState(ctx, user)
+    if err != nil {
+        return false, err
+    }
+    if _, held := state.Badges[badge]; held {
+        return false, nil
     }
-    g.bus.Publish(ctx, core.NewBadgeAwarded(normalized, badge))
+    return true, storage.AwardBadge(ctx, user, badge)
+}
+
+var (
+    // ErrReplaceUnsupported is returned by ReplaceState on storages without StateReplacer.
+    ErrReplaceUnsupported = errors.New("storage does not support replacing state")
+    // ErrInvalidState is returned by ReplaceState for states that fail validation.
+    ErrInvalidState = errors.New("invalid user state")
+)
+
+// ReplaceState overwrites the user's points, badges and levels with state, e.g. to restore a
+// snapshot. Data missing from state is removed. Points are checked against the metrics' value
+// policies and badge IDs are validated before anything is written. Derived metrics and their
+// levels in state, e.g. from a snapshot taken with GetState, are dropped (see WithDerivedMetric).
+// Leaderboards are resynced and a core.EventStateReplaced is published; no per-metric events or
+// rules fire.
+func (g *GamifyService) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) (err error) {
+    ctx, span := tracing.Start(ctx, "engine.ReplaceState")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return err }
+    r, ok := g.storage.(StateReplacer)
+    if !ok { return ErrReplaceUnsupported }
+    state = state.Clone()
+    for metric := range g.computed {
+        delete(state.Points, metric)
+        delete(state.Levels, metric)
+    }
+    for metric, v := range state.Points {
+        if p := g.valuePolicy(metric); v < p.Min || v > p.Max {
+            return fmt.Errorf("%w: %w: %s points %d not within [%d, %d]", ErrInvalidState, ErrValueOutOfRange, metric, v, p.Min, p.Max)
+        }
+    }
+    for b := range state.Badges {
+        if err := core.ValidateBadgeID(b); err != nil { return fmt.Errorf("%w: badge %q: %w", ErrInvalidState, b, err) }
+    }
+
+    next := state.Clone()
+    next.UserID = normalized
+    unlock, err := g.lockUsers(ctx, normalized)
+    if err != nil { return err }
+    previous, err := g.storage.GetState(ctx, normalized)
+    if err == nil { err = r.ReplaceState(ctx, normalized, next) }
+    unlock()
+    if err != nil { return err }
+
+    for metric := range g.boards {
+        if _, had := previous.Points[metric]; had || next.Points[metric] != 0 {
+            g.syncBoards(ctx, normalized, metric, next.Points[metric])
+        }
+    }
+    if !g.projected { _ = g.updateComposites(ctx, normalized, "") }
+    g.publish(ctx, core.NewStateReplaced(normalized, next))
     return nil
 }
 
@@ -83,22 +390,107 @@ func (g *GamifyService) EvaluateRules(ctx context.Context, user core.UserID) err
         return err
     }
     // no specific trigger; allow engines to infer
-    derived := g.rules.Evaluate(ctx, state, core.Event{UserID: user})
-    for _, d := range derived {
-        if d.Type == core.EventLevelUp {
-            _ = g.storage.SetLevel(ctx, d.UserID, d.Metric, d.Level)
+    return g.applyRules(ctx, user, state, core.Event{UserID: user})
+}
+
+// evaluateRules runs the rule engine, handing windowed rules the storage's point history when it keeps one
+func (g *GamifyService) evaluateRules(ctx context.Context, view core.UserState, trigger core.Event) []core.Event {
+    if wr, ok := g.rules.(WindowedRuleEngine); ok {
+        if w, ok := g.storage.(WindowedPoints); ok { return wr.EvaluateWindowed(ctx, view, trigger, w) }
+    }
+    return g.rules.Evaluate(ctx, view, trigger)
+}
+
+// publishDerived publishes rule output, storing level changes for metrics whose levels are not derived
+// and, for rule engines implementing BadgeStoringRules, the badges of badge and achievement events,
+// which are then published only for new awards.
+func (g *GamifyService) publishDerived(ctx context.Context, events []core.Event) {
+    storing, _ := g.rules.(BadgeStoringRules)
+    storesBadges := storing != nil && storing.StoresBadges()
+    for _, d := range events {
+        // allow rules to update storage when needed
+        switch {
+        case d.Type == core.EventLevelUp:
+            if _, derived := g.derived[d.Metric]; !derived {
+                _ = g.withRetry(ctx, "set_level", func() error { return g.storage.SetLevel(ctx, d.UserID, d.Metric, d.Level) })
+            }
+        case storesBadges && d.Badge != "" && (d.Type == core.EventBadgeAwarded || d.Type == core.EventAchievementUnlocked):
+            if !g.awardDerived(ctx, d) { continue }
         }
-        g.bus.Publish(ctx, d)
+        g.publish(ctx, d)
     }
-    return nil
 }
 
-func (g *GamifyService) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
-    return g.storage.GetState(ctx, user)
+// awardDerived stores the badge of a rule's badge or achievement event and reports whether the event
+// should be published. Repeatable badges publish their own award event; achievements are preceded by
+// the award of the badge that records them.
+func (g *GamifyService) awardDerived(ctx context.Context, d core.Event) bool {
+    if g.checkBadgeLimit(ctx, d.UserID, d.Badge) != nil { return false }
+    cooldown, repeatable := g.repeatable[d.Badge]
+    if c, ok := g.rules.(BadgeCooldowns); ok && !repeatable { cooldown, repeatable = c.BadgeCooldown(d.Badge) }
+    if repeatable {
+        // most qualifying events fall within the cooldown, so check it before taking the lock and writing
+        if r, ok := g.storage.(BadgeRepeater); ok {
+            rec, err := r.BadgeRecord(ctx, d.UserID, d.Badge)
+            if err != nil || (rec.Count > 0 && core.CurrentTime().UTC().Before(rec.LastAwarded.Add(cooldown))) { return false }
+        }
+        awarded, err := g.repeatBadge(ctx, d.UserID, d.Badge, cooldown)
+        return err == nil && awarded && d.Type == core.EventAchievementUnlocked
+    }
+    var awarded bool
+    err := g.withRetry(ctx, "award_badge", func() (err error) {
+        awarded, err = tryAwardBadge(ctx, g.storage, d.UserID, d.Badge)
+        return err
+    })
+    if err != nil || !awarded { return false }
+    if d.Type == core.EventAchievementUnlocked { g.publish(ctx, core.NewBadgeAwarded(d.UserID, d.Badge)) }
+    return true
+}
+
+// WithTx runs fn against the underlying storage atomically when the adapter supports it.
+// fn receives raw storage, so writes made through it bypass rules and do not publish events.
+func (g *GamifyService) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+    return RunInTx(ctx, g.storage, fn)
+}
+
+// GetState returns the user's all-time state; derived metrics (see WithDerivedMetric) and levels of
+// derived metrics are computed from the stored totals. Season ledgers are left out, see GetSeasonState.
+func (g *GamifyService) GetState(ctx context.Context, user core.UserID) (state core.UserState, err error) {
+    ctx, span := tracing.Start(ctx, "engine.GetState")
+    span.SetUser(user)
+    defer func(){ span.End(err) }()
+    state, err = g.storage.GetState(ctx, user)
+    if err != nil {
+        return state, err
+    }
+    return g.view(state.AllTime()), nil
+}
+
+// GetPoints returns the user's all-time total of metric, computed on read for derived metrics.
+func (g *GamifyService) GetPoints(ctx context.Context, user core.UserID, metric core.Metric) (int64, error) {
+    state, err := g.GetState(ctx, user)
+    if err != nil { return 0, err }
+    return state.Points[metric], nil
+}
+
+// UserExists reports whether the user has any stored points, badges or levels. Storages without
+// UserExister fall back to loading the state and checking whether it is empty.
+func (g *GamifyService) UserExists(ctx context.Context, user core.UserID) (bool, error) {
+    if e, ok := g.storage.(UserExister); ok { return e.Exists(ctx, user) }
+    state, err := g.storage.GetState(ctx, user)
+    if err != nil { return false, err }
+    return len(state.Points) > 0 || len(state.Badges) > 0 || len(state.Levels) > 0, nil
 }
 
 func (g *GamifyService) Close() { g.bus.Close() }
 
+func (g *GamifyService) valuePolicy(metric core.Metric) ValuePolicy {
+    if p, ok := g.policies[metric]; ok {
+        return p
+    }
+    return DefaultValuePolicy()
+}
+
 type simpleRuleEngine struct{ rules []core.Rule }
 
 func (s *simpleRuleEngine) Evaluate(ctx context.Context, state core.UserState, trigger core.Event) []core.Event {
@@ -109,4 +501,53 @@ func (s *simpleRuleEngine) Evaluate(ctx context.Context, state core.UserState, t
     return out
 }
 
+// SwappableRuleEngine delegates to a rule engine that can be replaced at runtime.
+// Evaluations already in progress finish with the engine they started with.
+type SwappableRuleEngine struct{ current atomic.Pointer[RuleEngine] }
+
+func NewSwappableRuleEngine(initial RuleEngine) *SwappableRuleEngine {
+    s := &SwappableRuleEngine{}
+    s.Swap(initial)
+    return s
+}
+
+// Swap installs next for subsequent evaluations.
+func (s *SwappableRuleEngine) Swap(next RuleEngine) {
+    if next == nil { panic("SwappableRuleEngine requires a non-nil rule engine") }
+    s.current.Store(&next)
+}
+
+func (s *SwappableRuleEngine) Evaluate(ctx context.Context, state core.UserState, trigger core.Event) []core.Event {
+    return (*s.current.Load()).Evaluate(ctx, state, trigger)
+}
+
+// MultiplyPoints forwards to the current engine if it is a PointsMultiplier and returns delta otherwise.
+func (s *SwappableRuleEngine) MultiplyPoints(ctx context.Context, state core.UserState, metric core.Metric, delta int64) int64 {
+    if m, ok := (*s.current.Load()).(PointsMultiplier); ok { return m.MultiplyPoints(ctx, state, metric, delta) }
+    return delta
+}
+
+// BadgeCooldown forwards to the current engine if it implements BadgeCooldowns.
+func (s *SwappableRuleEngine) BadgeCooldown(badge core.Badge) (time.Duration, bool) {
+    if c, ok := (*s.current.Load()).(BadgeCooldowns); ok { return c.BadgeCooldown(badge) }
+    return 0, false
+}
+
+// Quests forwards to the current engine if it is a QuestSource.
+func (s *SwappableRuleEngine) Quests() []Quest {
+    if q, ok := (*s.current.Load()).(QuestSource); ok { return q.Quests() }
+    return nil
+}
 
+// StoresBadges forwards to the current engine if it implements BadgeStoringRules.
+func (s *SwappableRuleEngine) StoresBadges() bool {
+    if b, ok := (*s.current.Load()).(BadgeStoringRules); ok { return b.StoresBadges() }
+    return false
+}
+
+// EvaluateWindowed forwards to the current engine if it is a WindowedRuleEngine and calls Evaluate otherwise.
+func (s *SwappableRuleEngine) EvaluateWindowed(ctx context.Context, state core.UserState, trigger core.Event, w WindowedPoints) []core.Event {
+    current := *s.current.Load()
+    if wr, ok := current.(WindowedRuleEngine); ok { return wr.EvaluateWindowed(ctx, state, trigger, w) }
+    return current.Evaluate(ctx, state, trigger)
+}
@@ -2,10 +2,12 @@ package engine
 
 import (
     "context"
+    "errors"
     "testing"
 
     mem "gamifykit/adapters/memory"
     "gamifykit/core"
+    "gamifykit/leaderboard"
 )
 
 func TestAddPointsAndLevelUp(t *testing.T) {
@@ -23,3 +25,183 @@ func TestAddPointsAndLevelUp(t *testing.T) {
 }
 
 
+
+func TestWithTxBestEffort(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine())
+    ctx := context.Background()
+
+    errAbort := errors.New("abort")
+    err := svc.WithTx(ctx, func(tx Storage) error {
+        if _, err := tx.AddPoints(ctx, "u", core.MetricXP, 5); err != nil { return err }
+        return errAbort
+    })
+    if !errors.Is(err, errAbort) { t.Fatalf("want abort error, got %v", err) }
+
+    // memory storage has no transactions, so the write made before the failure remains
+    st, _ := svc.GetState(ctx, "u")
+    if st.Points[core.MetricXP] != 5 { t.Fatalf("want 5 got %d", st.Points[core.MetricXP]) }
+}
+
+func TestSwappableRuleEngine(t *testing.T) {
+    rules := NewSwappableRuleEngine(LevelUpRuleEngine(core.MetricXP))
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), rules)
+
+    var leveled []core.Metric
+    svc.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event){ leveled = append(leveled, e.Metric) })
+
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "u", core.MetricPoints, 10000); err != nil { t.Fatal(err) }
+    if len(leveled) != 0 { t.Fatalf("points should not level before swap, got %v", leveled) }
+
+    rules.Swap(LevelUpRuleEngine(core.MetricPoints))
+    if _, err := svc.AddPoints(ctx, "u", core.MetricPoints, 1); err != nil { t.Fatal(err) }
+    if len(leveled) != 1 || leveled[0] != core.MetricPoints { t.Fatalf("expected points level up after swap, got %v", leveled) }
+}
+
+func TestAddPointsIfCondition(t *testing.T) {
+    store := mem.New()
+    bus := NewEventBus(DispatchSync)
+    svc := NewGamifyService(store, bus, DefaultRuleEngine())
+    var events int
+    bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ events++ })
+    ctx := context.Background()
+    belowTwo := WithCondition(func(st core.UserState) bool { return st.Levels[core.MetricXP] < 2 })
+
+    applied, total, err := svc.AddPointsIf(ctx, "u", core.MetricXP, 150, belowTwo)
+    if err != nil || !applied || total != 150 { t.Fatalf("expected award below level 2, got %v %d %v", applied, total, err) }
+
+    // 150 XP reached level 2, so the condition now rejects awards
+    _, _ = svc.AddPoints(ctx, "u", core.MetricXP, 50)
+    applied, total, err = svc.AddPointsIf(ctx, "u", core.MetricXP, 100, belowTwo)
+    if err != nil || applied || total != 200 { t.Fatalf("expected rejected award with unchanged total, got %v %d %v", applied, total, err) }
+    if events != 2 { t.Fatalf("rejected awards must not publish, got %d events", events) }
+
+    pass := WithCondition(func(core.UserState) bool { return true })
+    fail := WithCondition(func(core.UserState) bool { return false })
+    if applied, _, _ := svc.AddPointsIf(ctx, "u", core.MetricXP, 1, pass, fail); applied { t.Fatal("all conditions must pass") }
+}
+
+// plainStorage hides optional interfaces of the wrapped storage
+type plainStorage struct{ Storage }
+
+func TestUserExists(t *testing.T) {
+    ctx := context.Background()
+    for name, store := range map[string]Storage{"exister": mem.New(), "fallback": plainStorage{mem.New()}} {
+        svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine())
+        if _, err := svc.GetState(ctx, "ghost"); err != nil { t.Fatal(err) }
+        if ok, err := svc.UserExists(ctx, "ghost"); err != nil || ok { t.Fatalf("%s: unknown user exists=%v err=%v", name, ok, err) }
+        if err := svc.AwardBadge(ctx, "alice", "b"); err != nil { t.Fatal(err) }
+        if ok, err := svc.UserExists(ctx, "alice"); err != nil || !ok { t.Fatalf("%s: stored user exists=%v err=%v", name, ok, err) }
+    }
+}
+
+func TestReplaceState(t *testing.T) {
+    store := mem.New()
+    bus := NewEventBus(DispatchSync)
+    board := leaderboard.NewSkipList()
+    svc := NewGamifyService(store, bus, DefaultRuleEngine(),
+        WithValuePolicy("coins", NewValuePolicy(0, 1000, OverflowError)),
+        WithLeaderboard(core.MetricXP, BoardConfig{Board: board, MinScore: 1}))
+    var replaced []core.UserID
+    bus.Subscribe(core.EventStateReplaced, func(ctx context.Context, e core.Event){ replaced = append(replaced, e.UserID) })
+    ctx := context.Background()
+    _, _ = svc.AddPoints(ctx, "u", core.MetricXP, 50)
+    _ = svc.AwardBadge(ctx, "u", "old")
+
+    bad := core.UserState{Points: map[core.Metric]int64{"coins": 5000}}
+    if err := svc.ReplaceState(ctx, "u", bad); !errors.Is(err, ErrInvalidState) || !errors.Is(err, ErrValueOutOfRange) { t.Fatalf("want policy violation, got %v", err) }
+    bad = core.UserState{Badges: map[core.Badge]struct{}{"no spaces": {}}}
+    if err := svc.ReplaceState(ctx, "u", bad); !errors.Is(err, ErrInvalidState) { t.Fatalf("want invalid badge, got %v", err) }
+    if st, _ := svc.GetState(ctx, "u"); st.Points[core.MetricXP] != 50 { t.Fatalf("rejected replace must not write: %v", st.Points) }
+
+    if err := svc.ReplaceState(ctx, "u", core.UserState{Points: map[core.Metric]int64{"coins": 10}}); err != nil { t.Fatal(err) }
+    st, _ := svc.GetState(ctx, "u")
+    if len(st.Points) != 1 || st.Points["coins"] != 10 || len(st.Badges) != 0 { t.Fatalf("state not replaced: %+v", st) }
+    if _, ok := board.Get("u"); ok { t.Fatal("user without xp should leave the board") }
+    if len(replaced) != 1 || replaced[0] != "u" { t.Fatalf("expected one state_replaced event, got %v", replaced) }
+
+    plain := NewGamifyService(plainStorage{mem.New()}, NewEventBus(DispatchSync), DefaultRuleEngine())
+    if err := plain.ReplaceState(ctx, "u", core.UserState{}); !errors.Is(err, ErrReplaceUnsupported) { t.Fatalf("want ErrReplaceUnsupported, got %v", err) }
+}
+
+func TestAwardBadgeResult(t *testing.T) {
+    ctx := context.Background()
+    for name, store := range map[string]Storage{"awarder": mem.New(), "fallback": plainStorage{mem.New()}} {
+        t.Run(name, func(t *testing.T) {
+            bus := NewEventBus(DispatchSync)
+            svc := NewGamifyService(store, bus, DefaultRuleEngine())
+            events := 0
+            bus.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event){ events++ })
+
+            if awarded, err := svc.AwardBadgeResult(ctx, "alice", "first"); err != nil || !awarded { t.Fatalf("first award = %t, %v", awarded, err) }
+            if awarded, err := svc.AwardBadgeResult(ctx, "alice", "first"); err != nil || awarded { t.Fatalf("repeat award = %t, %v", awarded, err) }
+            if err := svc.AwardBadge(ctx, "alice", "first"); err != nil { t.Fatal(err) }
+            if events != 1 { t.Fatalf("expected one badge_awarded event, got %d", events) }
+        })
+    }
+}
+
+type tenantKey struct{}
+
+func TestEventEnricher(t *testing.T) {
+    bus := NewEventBus(DispatchSync)
+    var seen []string
+    bus.Use(func(ctx context.Context, e core.Event) (core.Event, bool) {
+        seen = append(seen, e.Metadata["tenant"].(string))
+        return e, true
+    })
+    svc := NewGamifyService(mem.New(), bus, DefaultRuleEngine(), WithEventEnricher(func(ctx context.Context, e *core.Event) {
+        if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+            if e.Metadata == nil { e.Metadata = map[string]any{} }
+            e.Metadata["tenant"] = tenant
+        }
+    }))
+    var got []core.Event
+    svc.SubscribeAllNamed("test", func(ctx context.Context, e core.Event){ got = append(got, e) })
+
+    ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 500); err != nil { t.Fatal(err) }
+    shared := map[string]any{"source": "import"}
+    svc.Publish(ctx, core.Event{Type: core.EventBadgeAwarded, UserID: "alice", Badge: "b", Metadata: shared})
+
+    // points_added, level_up and the published badge all carry the tenant, interceptors included
+    if len(got) != 3 || len(seen) != 3 { t.Fatalf("want 3 events, got %+v", got) }
+    for _, e := range got {
+        if e.Metadata["tenant"] != "acme" { t.Fatalf("event not enriched: %+v", e) }
+    }
+    if _, ok := shared["tenant"]; ok { t.Fatal("enricher wrote to the caller's metadata map") }
+}
+
+func TestApplyAction(t *testing.T) {
+    ctx := context.Background()
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithUserLimits(UserLimits{MaxMetrics: 2}))
+    var events []core.EventType
+    svc.SubscribeAllNamed("test", func(_ context.Context, e core.Event){ events = append(events, e.Type) })
+
+    quest := Action{
+        Operations: []Operation{{Metric: core.MetricXP, Delta: 100}, {Metric: "coins", Delta: 50}, {Badge: "quest-done"}},
+        Conditions: []func(core.UserState) bool{func(s core.UserState) bool { _, done := s.Badges["quest-done"]; return !done }},
+    }
+    res, err := svc.Apply(ctx, "Alice", quest)
+    if err != nil { t.Fatal(err) }
+    if !res.Applied || res.Operations[0].Total != 100 || !res.Operations[2].Applied || res.State.Points["coins"] != 50 {
+        t.Fatalf("first completion = %+v", res)
+    }
+    if len(events) != 4 { t.Fatalf("want points, level, points and badge events, got %v", events) }
+
+    // the condition rejects a second completion and nothing is written
+    events = nil
+    res, err = svc.Apply(ctx, "alice", quest)
+    if err != nil || res.Applied || res.State.Points[core.MetricXP] != 100 || len(events) != 0 { t.Fatalf("repeat = %+v, %v, events %v", res, err, events) }
+
+    // a failing operation leaves the earlier ones unwritten
+    _, err = svc.Apply(ctx, "alice", Action{Operations: []Operation{{Metric: core.MetricXP, Delta: 5}, {Metric: "gems", Delta: 1}}})
+    if !errors.Is(err, ErrLimitExceeded) { t.Fatalf("want ErrLimitExceeded, got %v", err) }
+    if st, _ := svc.GetState(ctx, "alice"); st.Points[core.MetricXP] != 100 { t.Fatalf("xp = %d after a failed action", st.Points[core.MetricXP]) }
+
+    for _, bad := range []Action{{}, {Operations: []Operation{{Metric: core.MetricXP}}}, {Operations: []Operation{{Badge: "b", Delta: 1}}}, {Operations: []Operation{{Badge: "has space"}}}} {
+        if _, err := svc.Apply(ctx, "alice", bad); !errors.Is(err, ErrInvalidAction) { t.Fatalf("%+v: want ErrInvalidAction, got %v", bad, err) }
+    }
+}
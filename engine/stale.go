@@ -0,0 +1,316 @@
+package engine
+
+import (
+    "container/list"
+    "context"
+    "sync"
+    "time"
+
+    "gamifykit/core"
+)
+
+const (
+    // DefaultMaxStaleness is how old a state StaleOnErrorStorage serves may be unless configured.
+    DefaultMaxStaleness = 5 * time.Minute
+    // DefaultStaleCacheUsers is how many users' last states StaleOnErrorStorage keeps unless configured.
+    DefaultStaleCacheUsers = 10000
+)
+
+// StaleReadOptions configures StaleOnErrorStorage.
+type StaleReadOptions struct {
+    // MaxStaleness is the oldest a cached state may be to stand in for a failed read
+    // (DefaultMaxStaleness when zero). Older ones are not served; the read fails as usual.
+    MaxStaleness time.Duration
+    // MaxUsers caps the users whose last state is kept, least recently read first out
+    // (DefaultStaleCacheUsers when zero).
+    MaxUsers int
+    // OnStale, if not nil, is called for every read answered from the cache with the age of the
+    // state served and the storage error it stood in for, e.g. to count them.
+    OnStale func(user core.UserID, age time.Duration, err error)
+}
+
+type staleReadsKey struct{}
+
+// AllowStaleReads marks ctx as belonging to a plain read, such as an API GET, that a
+// StaleOnErrorStorage may answer from its cache when storage fails. onStale, if not nil, is called
+// with the time each state served that way was read from storage, e.g. to flag the response. Write
+// paths never carry the mark, so they cannot act on a stale state.
+func AllowStaleReads(ctx context.Context, onStale func(asOf time.Time)) context.Context {
+    if onStale == nil { onStale = func(time.Time) {} }
+    return context.WithValue(ctx, staleReadsKey{}, onStale)
+}
+
+// StaleOnErrorStorage keeps the last state read from s for each user and, when a later read of the
+// user fails, serves that state instead of the error if it is at most MaxStaleness old and the
+// read's context allows it (see AllowStaleReads). A storage blip then degrades reads to slightly
+// stale ones rather than taking them down. Reads always go to s first; the cache is only a
+// fallback, and states served from it may predate writes made since. Writes are passed through and
+// fail as usual.
+//
+// Batch reads fall back to the cache per user. Transactions, user locks, existence checks, atomic
+// point updates and transfers, state replacement, user merges, badge removal, repeatable badges,
+// windowed points, user listing and queries, badge listing, identity mapping and quest progress are
+// passed through when s supports them; reads inside transactions are never served from the cache.
+func StaleOnErrorStorage(s Storage, opts StaleReadOptions) Storage {
+    if opts.MaxStaleness <= 0 { opts.MaxStaleness = DefaultMaxStaleness }
+    if opts.MaxUsers <= 0 { opts.MaxUsers = DefaultStaleCacheUsers }
+    return &staleStorage{inner: s, opts: opts, entries: map[core.UserID]*list.Element{}, lru: list.New()}
+}
+
+type staleStorage struct {
+    inner   Storage
+    opts    StaleReadOptions
+    mu      sync.Mutex
+    entries map[core.UserID]*list.Element // of *staleEntry
+    lru     *list.List                    // most recently read first
+}
+
+type staleEntry struct {
+    user  core.UserID
+    state core.UserState
+    asOf  time.Time
+}
+
+// GetState reads from the inner storage, falling back to the user's cached state on failure.
+func (s *staleStorage) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+    state, err := s.inner.GetState(ctx, user)
+    if err == nil {
+        s.remember(user, state)
+        return state, nil
+    }
+    if cached, ok := s.stale(ctx, user, err); ok { return cached, nil }
+    return state, err
+}
+
+// GetStateMany reads from the inner storage, falling back to the cached state of each user it failed.
+func (s *staleStorage) GetStateMany(ctx context.Context, users []core.UserID) (map[core.UserID]core.UserState, error) {
+    states, err := getStateMany(ctx, s.inner, users)
+    if states == nil { states = map[core.UserID]core.UserState{} }
+    failed := batchFailures(users, states, err)
+    for u, state := range states {
+        if _, bad := failed[u]; bad { delete(states, u); continue }
+        s.remember(u, state)
+    }
+    for u, uerr := range failed {
+        if cached, ok := s.stale(ctx, u, uerr); ok {
+            states[u] = cached
+            delete(failed, u)
+        }
+    }
+    return states, core.NewBatchError(failed)
+}
+
+// Exists answers from the cached state when the inner storage fails and a stale read is allowed.
+func (s *staleStorage) Exists(ctx context.Context, user core.UserID) (bool, error) {
+    var exists bool
+    var err error
+    if e, ok := s.inner.(UserExister); ok {
+        exists, err = e.Exists(ctx, user)
+    } else {
+        var state core.UserState
+        if state, err = s.inner.GetState(ctx, user); err == nil { s.remember(user, state) }
+        exists = len(state.Points) > 0 || len(state.Badges) > 0 || len(state.Levels) > 0
+    }
+    if err == nil { return exists, nil }
+    if cached, ok := s.stale(ctx, user, err); ok {
+        return len(cached.Points) > 0 || len(cached.Badges) > 0 || len(cached.Levels) > 0, nil
+    }
+    return false, err
+}
+
+// remember caches a state just read
+func (s *staleStorage) remember(user core.UserID, state core.UserState) {
+    entry := &staleEntry{user: user, state: state.Clone(), asOf: core.CurrentTime()}
+    s.mu.Lock(); defer s.mu.Unlock()
+    if el, ok := s.entries[user]; ok {
+        el.Value = entry
+        s.lru.MoveToFront(el)
+        return
+    }
+    s.entries[user] = s.lru.PushFront(entry)
+    for s.lru.Len() > s.opts.MaxUsers {
+        oldest := s.lru.Back()
+        s.lru.Remove(oldest)
+        delete(s.entries, oldest.Value.(*staleEntry).user)
+    }
+}
+
+// stale returns the user's cached state in place of err when ctx allows it and it is fresh enough
+func (s *staleStorage) stale(ctx context.Context, user core.UserID, err error) (core.UserState, bool) {
+    onStale, ok := ctx.Value(staleReadsKey{}).(func(time.Time))
+    // a caller that gave up gets its own error, not a stale answer
+    if !ok || ctx.Err() != nil { return core.UserState{}, false }
+    s.mu.Lock()
+    el, ok := s.entries[user]
+    var entry *staleEntry
+    if ok { entry = el.Value.(*staleEntry) }
+    s.mu.Unlock()
+    if !ok { return core.UserState{}, false }
+    age := core.CurrentTime().Sub(entry.asOf)
+    if age > s.opts.MaxStaleness { return core.UserState{}, false }
+    onStale(entry.asOf)
+    if s.opts.OnStale != nil { s.opts.OnStale(user, age, err) }
+    return entry.state.Clone(), true
+}
+
+func (s *staleStorage) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    return s.inner.AddPoints(ctx, user, metric, delta)
+}
+
+// UpdatePoints is atomic when the inner storage is a PointsUpdater; see updatePoints.
+func (s *staleStorage) UpdatePoints(ctx context.Context, user core.UserID, metric core.Metric, fn func(int64) (int64, error)) (int64, int64, error) {
+    return updatePoints(ctx, s.inner, user, metric, fn)
+}
+
+// TransferPoints is atomic when the inner storage is a PointsTransferer; see transferPoints.
+func (s *staleStorage) TransferPoints(ctx context.Context, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (int64, int64, error) {
+    return transferPoints(ctx, s.inner, from, to, metric, amount, floor, ceiling)
+}
+
+func (s *staleStorage) AwardBadge(ctx context.Context, user core.UserID, badge core.Badge) error {
+    return s.inner.AwardBadge(ctx, user, badge)
+}
+
+func (s *staleStorage) TryAwardBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    return tryAwardBadge(ctx, s.inner, user, badge)
+}
+
+func (s *staleStorage) SetLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) error {
+    return s.inner.SetLevel(ctx, user, metric, level)
+}
+
+// RaiseLevel is atomic when the inner storage is a LevelRaiser; see raiseLevel.
+func (s *staleStorage) RaiseLevel(ctx context.Context, user core.UserID, metric core.Metric, level int64) (bool, error) {
+    return raiseLevel(ctx, s.inner, user, metric, level)
+}
+
+// WithTx runs fn in a transaction of the inner storage when it has them, on the inner storage
+// itself, so reads in it are never stale.
+func (s *staleStorage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+    return RunInTx(ctx, s.inner, fn)
+}
+
+// LockUser locks the user when the inner storage supports it and does nothing otherwise.
+func (s *staleStorage) LockUser(ctx context.Context, user core.UserID) error {
+    if l, ok := s.inner.(UserLocker); ok { return l.LockUser(ctx, user) }
+    return nil
+}
+
+func (s *staleStorage) ReplaceState(ctx context.Context, user core.UserID, state core.UserState) error {
+    r, ok := s.inner.(StateReplacer)
+    if !ok { return ErrReplaceUnsupported }
+    return r.ReplaceState(ctx, user, state)
+}
+
+func (s *staleStorage) MergeUsers(ctx context.Context, source, target core.UserID, merge func(source, target core.UserState) (core.UserState, error)) (core.UserState, core.UserState, error) {
+    m, ok := s.inner.(UserMerger)
+    if !ok { return core.UserState{}, core.UserState{}, ErrMergeUnsupported }
+    return m.MergeUsers(ctx, source, target, merge)
+}
+
+func (s *staleStorage) RemoveBadge(ctx context.Context, user core.UserID, badge core.Badge) (bool, error) {
+    r, ok := s.inner.(BadgeRemover)
+    if !ok { return false, ErrBadgeRemovalUnsupported }
+    return r.RemoveBadge(ctx, user, badge)
+}
+
+func (s *staleStorage) PointsInWindow(ctx context.Context, user core.UserID, metric core.Metric, window time.Duration) (int64, error) {
+    w, ok := s.inner.(WindowedPoints)
+    if !ok { return 0, ErrWindowUnsupported }
+    return w.PointsInWindow(ctx, user, metric, window)
+}
+
+func (s *staleStorage) PointsPerPeriod(ctx context.Context, user core.UserID, metric core.Metric, period time.Duration, count int) ([]int64, error) {
+    p, ok := s.inner.(PeriodPoints)
+    if !ok { return nil, ErrWindowUnsupported }
+    return p.PointsPerPeriod(ctx, user, metric, period, count)
+}
+
+func (s *staleStorage) RepeatBadge(ctx context.Context, user core.UserID, badge core.Badge, now time.Time, cooldown time.Duration) (core.BadgeRecord, bool, error) {
+    r, ok := s.inner.(BadgeRepeater)
+    if !ok { return core.BadgeRecord{}, false, ErrRepeatUnsupported }
+    return r.RepeatBadge(ctx, user, badge, now, cooldown)
+}
+
+func (s *staleStorage) BadgeRecord(ctx context.Context, user core.UserID, badge core.Badge) (core.BadgeRecord, error) {
+    r, ok := s.inner.(BadgeRepeater)
+    if !ok { return core.BadgeRecord{}, ErrRepeatUnsupported }
+    return r.BadgeRecord(ctx, user, badge)
+}
+
+func (s *staleStorage) EachUser(ctx context.Context, fn func(core.UserID) error) error {
+    l, ok := s.inner.(UserLister)
+    if !ok { return ErrUserListingUnsupported }
+    return l.EachUser(ctx, fn)
+}
+
+// QueryUsers scans users through EachUser when the inner storage is no UserQuerier.
+func (s *staleStorage) QueryUsers(ctx context.Context, filter core.UserFilter) ([]core.UserID, error) {
+    return queryUsers(ctx, s.inner, filter)
+}
+
+func (s *staleStorage) ListBadges(ctx context.Context, user core.UserID, filter core.BadgeFilter) ([]core.BadgeAward, error) {
+    l, ok := s.inner.(BadgeLister)
+    if !ok { return nil, ErrBadgeListingUnsupported }
+    return l.ListBadges(ctx, user, filter)
+}
+
+func (s *staleStorage) LinkIdentity(ctx context.Context, identity string, user core.UserID) (core.UserID, error) {
+    m, ok := s.inner.(IdentityMapper)
+    if !ok { return "", ErrIdentityUnsupported }
+    return m.LinkIdentity(ctx, identity, user)
+}
+
+func (s *staleStorage) UnlinkIdentity(ctx context.Context, identity string) (bool, error) {
+    m, ok := s.inner.(IdentityMapper)
+    if !ok { return false, ErrIdentityUnsupported }
+    return m.UnlinkIdentity(ctx, identity)
+}
+
+func (s *staleStorage) ResolveIdentity(ctx context.Context, identity string) (core.UserID, bool, error) {
+    m, ok := s.inner.(IdentityMapper)
+    if !ok { return "", false, ErrIdentityUnsupported }
+    return m.ResolveIdentity(ctx, identity)
+}
+
+func (s *staleStorage) Identities(ctx context.Context, user core.UserID) ([]string, error) {
+    m, ok := s.inner.(IdentityMapper)
+    if !ok { return nil, ErrIdentityUnsupported }
+    return m.Identities(ctx, user)
+}
+
+func (s *staleStorage) AdvanceQuest(ctx context.Context, user core.UserID, quest, period string, delta, target int64) (core.QuestProgress, int64, error) {
+    q, ok := s.inner.(QuestStore)
+    if !ok { return core.QuestProgress{}, 0, ErrQuestsUnsupported }
+    return q.AdvanceQuest(ctx, user, quest, period, delta, target)
+}
+
+func (s *staleStorage) QuestProgress(ctx context.Context, user core.UserID) ([]core.QuestProgress, error) {
+    q, ok := s.inner.(QuestStore)
+    if !ok { return nil, ErrQuestsUnsupported }
+    return q.QuestProgress(ctx, user)
+}
+
+func (s *staleStorage) unwrapStorage() Storage { return s.inner }
+
+var (
+    _ Txner            = (*staleStorage)(nil)
+    _ UserLocker       = (*staleStorage)(nil)
+    _ PointsUpdater    = (*staleStorage)(nil)
+    _ LevelRaiser      = (*staleStorage)(nil)
+    _ PointsTransferer = (*staleStorage)(nil)
+    _ UserExister      = (*staleStorage)(nil)
+    _ StateBatchGetter = (*staleStorage)(nil)
+    _ StateReplacer    = (*staleStorage)(nil)
+    _ UserMerger       = (*staleStorage)(nil)
+    _ BadgeRemover     = (*staleStorage)(nil)
+    _ BadgeAwarder     = (*staleStorage)(nil)
+    _ BadgeRepeater    = (*staleStorage)(nil)
+    _ WindowedPoints   = (*staleStorage)(nil)
+    _ PeriodPoints     = (*staleStorage)(nil)
+    _ UserLister       = (*staleStorage)(nil)
+    _ UserQuerier      = (*staleStorage)(nil)
+    _ BadgeLister      = (*staleStorage)(nil)
+    _ IdentityMapper   = (*staleStorage)(nil)
+    _ QuestStore       = (*staleStorage)(nil)
+)
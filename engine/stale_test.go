@@ -0,0 +1,125 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+// downStore fails every call while down is set
+type downStore struct {
+    Storage
+    down bool
+}
+
+var errDown = errors.New("connection refused")
+
+func (d *downStore) GetState(ctx context.Context, user core.UserID) (core.UserState, error) {
+    if d.down { return core.UserState{}, errDown }
+    return d.Storage.GetState(ctx, user)
+}
+
+func (d *downStore) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    if d.down { return 0, errDown }
+    return d.Storage.AddPoints(ctx, user, metric, delta)
+}
+
+func TestStaleOnErrorStorage(t *testing.T) {
+    clock := core.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+    defer core.SetClock(core.SetClock(clock.Now))
+    inner := &downStore{Storage: mem.New()}
+    var served []time.Duration
+    svc := NewGamifyService(StaleOnErrorStorage(inner, StaleReadOptions{
+        MaxStaleness: time.Minute,
+        OnStale:      func(_ core.UserID, age time.Duration, err error) { served = append(served, age) },
+    }), NewEventBus(DispatchSync), DefaultRuleEngine())
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 50); err != nil { t.Fatal(err) }
+    if _, err := svc.GetState(ctx, "alice"); err != nil { t.Fatal(err) }
+
+    inner.down = true
+    clock.Advance(30 * time.Second)
+    var asOf time.Time
+    reads := AllowStaleReads(ctx, func(t time.Time) { asOf = t })
+    st, err := svc.GetState(reads, "alice")
+    if err != nil || st.Points[core.MetricXP] != 50 { t.Fatalf("stale read = %v, %v; want 50 xp", st.Points, err) }
+    if want := clock.Now().Add(-30 * time.Second); !asOf.Equal(want) || len(served) != 1 || served[0] != 30*time.Second {
+        t.Fatalf("stale read reported as of %s (%v served), want %s", asOf, served, want)
+    }
+
+    if _, err := svc.GetState(ctx, "alice"); !errors.Is(err, errDown) { t.Fatalf("read without AllowStaleReads = %v, want the storage error", err) }
+    if _, err := svc.GetState(reads, "bob"); !errors.Is(err, errDown) { t.Fatalf("read of an uncached user = %v, want the storage error", err) }
+    if _, err := svc.AddPoints(reads, "alice", core.MetricXP, 5); !errors.Is(err, errDown) { t.Fatalf("write = %v, want the storage error", err) }
+    clock.Advance(time.Minute)
+    if _, err := svc.GetState(reads, "alice"); !errors.Is(err, errDown) { t.Fatalf("read past MaxStaleness = %v, want the storage error", err) }
+
+    inner.down = false
+    if st, err := svc.GetState(reads, "alice"); err != nil || st.Points[core.MetricXP] != 50 || len(served) != 1 {
+        t.Fatalf("read after recovery = %v, %v (%d stale)", st.Points, err, len(served))
+    }
+}
+
+func TestStaleOnErrorStorageBatch(t *testing.T) {
+    inner := &downStore{Storage: mem.New()}
+    svc := NewGamifyService(StaleOnErrorStorage(inner, StaleReadOptions{}), NewEventBus(DispatchSync), DefaultRuleEngine())
+    ctx := context.Background()
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 50); err != nil { t.Fatal(err) }
+    if _, err := svc.GetStateMany(ctx, []core.UserID{"alice"}); err != nil { t.Fatal(err) }
+
+    inner.down = true
+    states, err := svc.GetStateMany(AllowStaleReads(ctx, nil), []core.UserID{"alice", "bob"})
+    var batch *core.BatchError
+    if !errors.As(err, &batch) || len(batch.Errors) != 1 || !errors.Is(batch.Errors["bob"], errDown) { t.Fatalf("batch error = %v, want only bob failed", err) }
+    if states["alice"].Points[core.MetricXP] != 50 { t.Fatalf("alice = %+v, want her cached 50 xp", states["alice"]) }
+}
+
+// creditFails fails every AddPoints crediting user
+type creditFails struct {
+    Storage
+    user core.UserID
+}
+
+func (c creditFails) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    if user == c.user && delta > 0 { return 0, errDown }
+    return c.Storage.AddPoints(ctx, user, metric, delta)
+}
+
+func TestStorageWrappersForwardOptionalInterfaces(t *testing.T) {
+    optional := []reflect.Type{
+        reflect.TypeFor[Txner](), reflect.TypeFor[UserLocker](), reflect.TypeFor[UserExister](),
+        reflect.TypeFor[StateBatchGetter](), reflect.TypeFor[StateReplacer](), reflect.TypeFor[PointsTransferer](),
+        reflect.TypeFor[PointsUpdater](), reflect.TypeFor[LevelRaiser](), reflect.TypeFor[BadgeAwarder](), reflect.TypeFor[BadgeRemover](),
+        reflect.TypeFor[BadgeRepeater](), reflect.TypeFor[WindowedPoints](), reflect.TypeFor[PeriodPoints](), reflect.TypeFor[UserLister](),
+        reflect.TypeFor[UserQuerier](), reflect.TypeFor[BadgeLister](), reflect.TypeFor[UserMerger](),
+        reflect.TypeFor[IdentityMapper](), reflect.TypeFor[QuestStore](),
+    }
+    inner := mem.New()
+    wrappers := map[string]Storage{
+        "namespaced": NamespacedStorage(inner),
+        "stale":      StaleOnErrorStorage(inner, StaleReadOptions{}),
+    }
+    for name, w := range wrappers {
+        for _, iface := range optional {
+            if reflect.TypeOf(inner).Implements(iface) && !reflect.TypeOf(w).Implements(iface) {
+                t.Errorf("%s storage hides %s of the storage it wraps", name, iface.Name())
+            }
+        }
+    }
+
+    // wrappers implement Txner either way, so a failed credit must still be refunded without one
+    ctx := core.WithNamespace(context.Background(), "g1")
+    for name, w := range map[string]Storage{
+        "namespaced": NamespacedStorage(creditFails{plainStorage{mem.New()}, "g1:bob"}),
+        "stale":      StaleOnErrorStorage(creditFails{plainStorage{mem.New()}, "bob"}, StaleReadOptions{}),
+    } {
+        svc := NewGamifyService(w, NewEventBus(DispatchSync), DefaultRuleEngine())
+        if _, err := svc.AddPoints(ctx, "alice", core.MetricPoints, 10); err != nil { t.Fatal(err) }
+        if err := svc.Transfer(ctx, "alice", "bob", core.MetricPoints, 4); !errors.Is(err, errDown) { t.Fatalf("%s: transfer = %v, want the credit error", name, err) }
+        if st, _ := svc.GetState(ctx, "alice"); st.Points[core.MetricPoints] != 10 { t.Fatalf("%s: sender kept %d coins, want a refund to 10", name, st.Points[core.MetricPoints]) }
+    }
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+    "context"
+    "fmt"
+
+    "gamifykit/core"
+    "gamifykit/tracing"
+)
+
+// Transfer moves amount points of metric from one user to another, e.g. to gift currency. The
+// sender may not drop below zero (or the metric's policy minimum, if higher) and the receiver may
+// not exceed the policy maximum; either way nothing is written and core.ErrInsufficientPoints or
+// core.ErrReceiverLimit is returned. Self-transfers fail with
+// core.ErrSelfTransfer and non-positive amounts with core.ErrInvalidAmount.
+//
+// Storages implementing PointsTransferer move the points in one step. Otherwise both users are
+// locked in a consistent order and both writes share one transaction on storages that support it
+// (see Txner and UserLocker); elsewhere the sender is refunded if crediting the receiver fails.
+// On success a core.EventPointsTransferred is published for each side.
+//
+// With an active season the points also move between the users' ledgers of it, right after the
+// all-time totals: the sender's ledger drops by amount but not below the floor above (unless it
+// already was), and the receiver's grows by amount within the metric's value policy. A failure at
+// that step leaves the all-time transfer in place and is returned, so the transfer must not be retried.
+func (g *GamifyService) Transfer(ctx context.Context, from, to core.UserID, metric core.Metric, amount int64) (err error) {
+    ctx, span := tracing.Start(ctx, "engine.Transfer")
+    span.SetUser(from)
+    span.SetAttr("metric", string(metric))
+    defer func(){ span.End(err) }()
+    if amount <= 0 { return core.ErrInvalidAmount }
+    from, err = core.NormalizeUserID(from)
+    if err != nil { return err }
+    to, err = core.NormalizeUserID(to)
+    if err != nil { return err }
+    if from == to { return core.ErrSelfTransfer }
+    if err := g.checkMetric(metric); err != nil { return err }
+    if g.limits.MaxMetrics > 0 {
+        receiver, err := g.storage.GetState(ctx, to)
+        if err != nil { return err }
+        if err := g.checkMetricLimit(receiver, to, metric); err != nil { return err }
+    }
+
+    season, err := g.currentSeason(ctx, false)
+    if err != nil { return err }
+    policy := g.valuePolicy(metric)
+    floor := max(policy.Min, 0)
+    var fromTotal, toTotal, fromSeason, toSeason int64
+    unlock, err := g.lockUsers(ctx, from, to)
+    if err != nil { return err }
+    fromTotal, toTotal, err = transferPoints(ctx, g.storage, from, to, metric, amount, floor, policy.Max)
+    if err == nil && season != "" {
+        if fromSeason, toSeason, err = g.transferSeason(ctx, from, to, core.SeasonMetric(metric, season), amount, floor, policy); err != nil {
+            err = fmt.Errorf("points transferred, but moving them between the %s ledgers failed: %w", season, err)
+        }
+    }
+    unlock()
+    if err != nil { return err }
+    if season != "" {
+        g.syncSeasonBoards(from, metric, season, fromSeason)
+        g.syncSeasonBoards(to, metric, season, toSeason)
+    }
+
+    g.syncBoards(ctx, from, metric, fromTotal)
+    g.syncBoards(ctx, to, metric, toTotal)
+    sent := core.NewPointsTransferred(from, from, to, metric, -amount, fromTotal)
+    received := core.NewPointsTransferred(to, from, to, metric, amount, toTotal)
+    g.publish(ctx, sent)
+    g.publish(ctx, received)
+    g.levelChange(ctx, from, metric, fromTotal+amount, fromTotal)
+    g.levelChange(ctx, to, metric, toTotal-amount, toTotal)
+    for _, ev := range []core.Event{sent, received} {
+        if state, err := g.storage.GetState(ctx, ev.UserID); err == nil {
+            _ = g.applyRules(ctx, ev.UserID, state, ev)
+        }
+    }
+    return nil
+}
+
+// transferSeason moves amount between the users' season ledger key; see Transfer
+func (g *GamifyService) transferSeason(ctx context.Context, from, to core.UserID, key core.Metric, amount, floor int64, policy ValuePolicy) (fromTotal, toTotal int64, err error) {
+    _, fromTotal, err = updatePoints(ctx, g.storage, from, key, func(current int64) (int64, error) {
+        next, err := core.SubSafe(current, amount)
+        if err != nil || next < floor { return min(current, floor), nil }
+        return next, nil
+    })
+    if err != nil { return 0, 0, err }
+    _, toTotal, err = updatePoints(ctx, g.storage, to, key, func(current int64) (int64, error) { return policy.Apply(current, amount) })
+    return fromTotal, toTotal, err
+}
+
+// transferPoints is PointsTransferer.TransferPoints on any storage; see transferInTx for the others
+func transferPoints(ctx context.Context, s Storage, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (int64, int64, error) {
+    if t, ok := s.(PointsTransferer); ok { return t.TransferPoints(ctx, from, to, metric, amount, floor, ceiling) }
+    return transferInTx(ctx, s, from, to, metric, amount, floor, ceiling)
+}
+
+// transferInTx checks both balances and writes both sides through the generic Storage methods
+func transferInTx(ctx context.Context, s Storage, from, to core.UserID, metric core.Metric, amount, floor, ceiling int64) (fromTotal, toTotal int64, err error) {
+    inTx := transactional(s)
+    err = RunInTx(ctx, s, func(tx Storage) error {
+        if l, ok := tx.(UserLocker); ok {
+            // lock in a fixed order so opposite transfers cannot deadlock
+            first, second := from, to
+            if second < first { first, second = second, first }
+            if err := l.LockUser(ctx, first); err != nil { return err }
+            if err := l.LockUser(ctx, second); err != nil { return err }
+        }
+        sender, err := tx.GetState(ctx, from)
+        if err != nil { return err }
+        receiver, err := tx.GetState(ctx, to)
+        if err != nil { return err }
+        if have := sender.Points[metric]; have < floor || have-floor < amount {
+            return fmt.Errorf("%w: %s has %d %s, cannot send %d", core.ErrInsufficientPoints, from, have, metric, amount)
+        }
+        if next, err := core.AddSafe(receiver.Points[metric], amount); err != nil || next > ceiling {
+            return fmt.Errorf("%w: %s cannot receive %d %s", core.ErrReceiverLimit, to, amount, metric)
+        }
+
+        if fromTotal, err = tx.AddPoints(ctx, from, metric, -amount); err != nil { return err }
+        if toTotal, err = tx.AddPoints(ctx, to, metric, amount); err != nil {
+            if !inTx {
+                // no transaction to roll back: refund the sender
+                if _, rerr := tx.AddPoints(ctx, from, metric, amount); rerr != nil {
+                    return fmt.Errorf("failed to credit %s: %w (refunding %s also failed: %v)", to, err, from, rerr)
+                }
+            }
+            return err
+        }
+        return nil
+    })
+    return fromTotal, toTotal, err
+}
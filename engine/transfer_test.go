@@ -0,0 +1,89 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "math"
+    "sync"
+    "testing"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+const coins core.Metric = "coins"
+
+func TestTransfer(t *testing.T) {
+    ctx := context.Background()
+    for name, store := range map[string]Storage{"transferer": mem.New(), "fallback": plainStorage{mem.New()}} {
+        svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine())
+        var events []core.Event
+        svc.Subscribe(core.EventPointsTransferred, func(ctx context.Context, e core.Event){ events = append(events, e) })
+        if _, err := svc.AddPoints(ctx, "alice", coins, 10); err != nil { t.Fatal(err) }
+
+        if err := svc.Transfer(ctx, "alice", "bob", coins, 4); err != nil { t.Fatalf("%s: %v", name, err) }
+        alice, _ := svc.GetState(ctx, "alice")
+        bob, _ := svc.GetState(ctx, "bob")
+        if alice.Points[coins] != 6 || bob.Points[coins] != 4 { t.Fatalf("%s: want 6/4 got %d/%d", name, alice.Points[coins], bob.Points[coins]) }
+        if len(events) != 2 || events[0].UserID != "alice" || events[0].Delta != -4 || events[0].Total != 6 ||
+            events[1].UserID != "bob" || events[1].Delta != 4 || events[1].Metadata["from"] != "alice" {
+            t.Fatalf("%s: unexpected events %+v", name, events)
+        }
+
+        if err := svc.Transfer(ctx, "alice", "bob", coins, 7); !errors.Is(err, core.ErrInsufficientPoints) { t.Fatalf("%s: overdraft: %v", name, err) }
+        if err := svc.Transfer(ctx, "alice", "alice", coins, 1); !errors.Is(err, core.ErrSelfTransfer) { t.Fatalf("%s: self: %v", name, err) }
+        if err := svc.Transfer(ctx, "alice", "bob", coins, 0); !errors.Is(err, core.ErrInvalidAmount) { t.Fatalf("%s: zero: %v", name, err) }
+        if err := svc.Transfer(ctx, "alice", "bob", coins, -3); !errors.Is(err, core.ErrInvalidAmount) { t.Fatalf("%s: negative: %v", name, err) }
+        alice, _ = svc.GetState(ctx, "alice")
+        if alice.Points[coins] != 6 || len(events) != 2 { t.Fatalf("%s: failed transfers must not write or publish", name) }
+    }
+}
+
+func TestTransferReceiverLimit(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(),
+        WithValuePolicy(coins, NewValuePolicy(0, 10, OverflowClamp)))
+    if _, err := svc.AddPoints(ctx, "alice", coins, 5); err != nil { t.Fatal(err) }
+    if _, err := svc.AddPoints(ctx, "bob", coins, 8); err != nil { t.Fatal(err) }
+    if err := svc.Transfer(ctx, "alice", "bob", coins, 3); !errors.Is(err, core.ErrReceiverLimit) { t.Fatalf("want receiver limit got %v", err) }
+    if err := svc.Transfer(ctx, "alice", "bob", coins, math.MaxInt64); !errors.Is(err, core.ErrInsufficientPoints) { t.Fatalf("want insufficient got %v", err) }
+}
+
+// failingCredit fails AddPoints for one user
+type failingCredit struct {
+    Storage
+    user core.UserID
+}
+
+func (f failingCredit) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    if user == f.user && delta > 0 { return 0, errors.New("credit failed") }
+    return f.Storage.AddPoints(ctx, user, metric, delta)
+}
+
+func TestTransferRefundsWithoutTransactions(t *testing.T) {
+    ctx := context.Background()
+    store := mem.New()
+    if _, err := store.AddPoints(ctx, "alice", coins, 10); err != nil { t.Fatal(err) }
+    svc := NewGamifyService(failingCredit{Storage: store, user: "bob"}, NewEventBus(DispatchSync), DefaultRuleEngine())
+    if err := svc.Transfer(ctx, "alice", "bob", coins, 4); err == nil { t.Fatal("want credit error") }
+    if st, _ := store.GetState(ctx, "alice"); st.Points[coins] != 10 { t.Fatalf("sender should be refunded, has %d", st.Points[coins]) }
+}
+
+func TestTransferConcurrentOpposite(t *testing.T) {
+    ctx := context.Background()
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine())
+    for _, u := range []core.UserID{"alice", "bob"} {
+        if _, err := svc.AddPoints(ctx, u, coins, 1000); err != nil { t.Fatal(err) }
+    }
+    var wg sync.WaitGroup
+    for i := 0; i < 200; i++ {
+        wg.Add(2)
+        go func() { defer wg.Done(); _ = svc.Transfer(ctx, "alice", "bob", coins, 3) }()
+        go func() { defer wg.Done(); _ = svc.Transfer(ctx, "bob", "alice", coins, 2) }()
+    }
+    wg.Wait()
+    alice, _ := svc.GetState(ctx, "alice")
+    bob, _ := svc.GetState(ctx, "bob")
+    if alice.Points[coins]+bob.Points[coins] != 2000 { t.Fatalf("points not conserved: %d + %d", alice.Points[coins], bob.Points[coins]) }
+    if alice.Points[coins] != 800 || bob.Points[coins] != 1200 { t.Fatalf("want 800/1200 got %d/%d", alice.Points[coins], bob.Points[coins]) }
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+)
+
+// overlapStore records the most AddPoints calls in flight at once, per user and overall
+type overlapStore struct {
+    Storage
+    mu       sync.Mutex
+    inFlight map[core.UserID]int
+    maxUser  int
+    cur, max atomic.Int32
+}
+
+func (s *overlapStore) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+    s.mu.Lock()
+    s.inFlight[user]++
+    if s.inFlight[user] > s.maxUser { s.maxUser = s.inFlight[user] }
+    s.mu.Unlock()
+    if n := s.cur.Add(1); n > s.max.Load() { s.max.Store(n) }
+    time.Sleep(time.Millisecond)
+    s.cur.Add(-1)
+    s.mu.Lock(); s.inFlight[user]--; s.mu.Unlock()
+    return s.Storage.AddPoints(ctx, user, metric, delta)
+}
+
+func TestUserSerialization(t *testing.T) {
+    store := &overlapStore{Storage: mem.New(), inFlight: map[core.UserID]int{}}
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine(), WithUserSerialization())
+    ctx := context.Background()
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        for _, user := range []core.UserID{"alice", "bob"} {
+            wg.Add(1)
+            go func(user core.UserID) {
+                defer wg.Done()
+                if _, err := svc.AddPoints(ctx, user, core.MetricXP, 1); err != nil { t.Error(err) }
+            }(user)
+        }
+    }
+    wg.Wait()
+    if store.maxUser != 1 { t.Fatalf("%d writes of one user overlapped, want 1", store.maxUser) }
+    if store.max.Load() < 2 { t.Fatalf("writes of different users never overlapped") }
+    if n := svc.userLocks.size(); n != 0 { t.Fatalf("%d user locks left after the writes finished", n) }
+    st, _ := svc.GetState(ctx, "alice")
+    if st.Points[core.MetricXP] != 20 { t.Fatalf("alice has %d xp, want 20", st.Points[core.MetricXP]) }
+}
+
+func TestUserSerializationWaitHonoursContext(t *testing.T) {
+    svc := NewGamifyService(mem.New(), NewEventBus(DispatchSync), DefaultRuleEngine(), WithUserSerialization())
+    unlock, err := svc.lockUsers(context.Background(), "bob", "alice")
+    if err != nil { t.Fatal(err) }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 1); !errors.Is(err, context.DeadlineExceeded) { t.Fatalf("write while locked = %v, want the context error", err) }
+    if err := svc.Transfer(context.Background(), "carol", "dave", core.MetricXP, 1); errors.Is(err, context.DeadlineExceeded) { t.Fatalf("other users blocked: %v", err) }
+    unlock()
+    if _, err := svc.AddPoints(context.Background(), "alice", core.MetricXP, 1); err != nil { t.Fatal(err) }
+    if n := svc.userLocks.size(); n != 0 { t.Fatalf("%d user locks left", n) }
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "gamifykit/core"
+)
+
+// ErrWindowUnsupported is returned for windowed queries on storages without WindowedPoints.
+var ErrWindowUnsupported = errors.New("storage does not support windowed points")
+
+// WindowedPoints is implemented by storages that keep timestamped point increments, so activity
+// over a rolling window ("points earned in the last 24h") can be queried. Increments older than
+// the storage's retention (core.DefaultPointsRetention unless configured) are pruned, and longer
+// windows fail with core.ErrWindowTooLong.
+type WindowedPoints interface {
+    // PointsInWindow sums the increments of metric recorded within the last window.
+    PointsInWindow(ctx context.Context, user core.UserID, metric core.Metric, window time.Duration) (int64, error)
+}
+
+// PeriodPoints is implemented by windowed storages that sum a user's increments per period in a
+// single query, which streak rules use instead of one PointsInWindow call per day.
+type PeriodPoints interface {
+    // PointsPerPeriod sums the increments of metric in each of the last n periods, most recent
+    // first; it fails with core.ErrWindowTooLong when n periods exceed the retention.
+    PointsPerPeriod(ctx context.Context, user core.UserID, metric core.Metric, period time.Duration, n int) ([]int64, error)
+}
+
+// PointsInWindow returns the points of metric the user earned (net of deductions) within the last window.
+func (g *GamifyService) PointsInWindow(ctx context.Context, user core.UserID, metric core.Metric, window time.Duration) (int64, error) {
+    w, ok := g.storage.(WindowedPoints)
+    if !ok { return 0, ErrWindowUnsupported }
+    if window <= 0 { return 0, fmt.Errorf("window must be positive, got %s", window) }
+    normalized, err := core.NormalizeUserID(user)
+    if err != nil { return 0, err }
+    return w.PointsInWindow(ctx, normalized, metric, window)
+}
+
+// RefreshRollingBoard rebuilds a rolling leaderboard from every user's points in the last window:
+// users with a positive sum are ranked by it, every other stored user is removed. Run it periodically (e.g. every
+// minute) against a board dedicated to the window. It returns how many users were ranked. The storage must
+// implement both WindowedPoints and UserLister.
+func (g *GamifyService) RefreshRollingBoard(ctx context.Context, board Leaderboard, metric core.Metric, window time.Duration) (int, error) {
+    w, ok := g.storage.(WindowedPoints)
+    if !ok { return 0, ErrWindowUnsupported }
+    lister, ok := g.storage.(UserLister)
+    if !ok { return 0, ErrUserListingUnsupported }
+    ranked := 0
+    err := lister.EachUser(ctx, func(user core.UserID) error {
+        sum, err := w.PointsInWindow(ctx, user, metric, window)
+        if err != nil { return fmt.Errorf("failed to sum window for user %s: %w", user, err) }
+        if sum > 0 {
+            board.Update(user, sum)
+            ranked++
+        } else {
+            board.Remove(user)
+        }
+        return nil
+    })
+    return ranked, err
+}
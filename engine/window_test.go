@@ -0,0 +1,41 @@
+package engine
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/leaderboard"
+)
+
+func TestRefreshRollingBoard(t *testing.T) {
+    store := mem.New()
+    svc := NewGamifyService(store, NewEventBus(DispatchSync), DefaultRuleEngine())
+    ctx := context.Background()
+    _, _ = svc.AddPoints(ctx, "alice", core.MetricXP, 30)
+    _, _ = svc.AddPoints(ctx, "bob", core.MetricXP, 50)
+    _, _ = svc.AddPoints(ctx, "carol", core.MetricPoints, 10)
+
+    if got, err := svc.PointsInWindow(ctx, "bob", core.MetricXP, time.Hour); err != nil || got != 50 { t.Fatalf("got %d %v", got, err) }
+
+    board := leaderboard.NewSkipList()
+    n, err := svc.RefreshRollingBoard(ctx, board, core.MetricXP, 24*time.Hour)
+    if err != nil || n != 2 { t.Fatalf("expected 2 ranked users, got %d %v", n, err) }
+    top := board.TopN(3)
+    if len(top) != 2 || top[0].User != "bob" || top[1].User != "alice" { t.Fatalf("unexpected board %v", top) }
+
+    // a user whose window nets out to zero drops off on the next refresh
+    _, _ = svc.AddPoints(ctx, "alice", core.MetricXP, -30)
+    if n, _ := svc.RefreshRollingBoard(ctx, board, core.MetricXP, 24*time.Hour); n != 1 || board.Count() != 1 { t.Fatalf("expected only bob ranked, got %d users", board.Count()) }
+}
+
+func TestPointsInWindowUnsupported(t *testing.T) {
+    svc := NewGamifyService(noWindowStorage{mem.New()}, NewEventBus(DispatchSync), DefaultRuleEngine())
+    if _, err := svc.PointsInWindow(context.Background(), "u", core.MetricXP, time.Hour); !errors.Is(err, ErrWindowUnsupported) { t.Fatalf("want ErrWindowUnsupported, got %v", err) }
+}
+
+// noWindowStorage hides the memory store's optional capabilities
+type noWindowStorage struct{ Storage }
@@ -2,9 +2,11 @@ package gamify
 
 import (
     "context"
+    "time"
 
     "gamifykit/core"
     "gamifykit/engine"
+    "gamifykit/leaderboard"
     "gamifykit/realtime"
 )
 
@@ -16,6 +18,16 @@ type config struct {
     mode    engine.DispatchMode
     rules   engine.RuleEngine
     hub     *realtime.Hub
+    relay   *realtime.Relay
+    svcOpts []engine.ServiceOption
+    onDispatch engine.DispatchObserver
+    slowAfter  time.Duration
+    maxSkew    *time.Duration
+    onSkew     engine.ClockSkewObserver
+    maxAge     time.Duration
+    sampling   map[core.EventType]engine.SamplingPolicy
+    interceptors []engine.Interceptor
+    realtimeLimit *engine.EventSizeLimit
 }
 
 // WithStorage sets the persistence adapter.
@@ -30,6 +42,170 @@ func WithDispatchMode(m engine.DispatchMode) Option { return func(c *config){ c.
 // WithRealtime wires a realtime hub to receive all engine events.
 func WithRealtime(h *realtime.Hub) Option { return func(c *config){ c.hub = h } }
 
+// WithRealtimeRelay wires a relay's hub to receive all engine events and shares them with the
+// hubs of other instances through the relay's backplane; use it instead of WithRealtime. Run the
+// relay to receive the other instances' events.
+func WithRealtimeRelay(r *realtime.Relay) Option { return func(c *config){ c.relay = r } }
+
+// WithRealtimeEventLimit truncates or drops events whose JSON encoding exceeds limit.MaxBytes
+// before they are broadcast to realtime clients and, with WithRealtimeRelay, other instances; see
+// engine.LimitEventSize. Other subscribers, such as the event log, still receive them whole.
+func WithRealtimeEventLimit(limit engine.EventSizeLimit) Option { return func(c *config){ c.realtimeLimit = &limit } }
+
+// WithDispatchObserver receives every subscriber's dispatch duration; see engine.EventBus.OnDispatch.
+func WithDispatchObserver(fn engine.DispatchObserver) Option { return func(c *config){ c.onDispatch = fn } }
+
+// WithSlowSubscriberThreshold logs a warning naming any subscriber slower than d; see engine.EventBus.SetSlowThreshold.
+func WithSlowSubscriberThreshold(d time.Duration) Option { return func(c *config){ c.slowAfter = d } }
+
+// WithClockSkew replaces the time of published events more than max ahead of the server clock (zero
+// disables the check) and passes each such event to onSkew, if set; see engine.EventBus.SetMaxClockSkew.
+func WithClockSkew(max time.Duration, onSkew engine.ClockSkewObserver) Option {
+    return func(c *config){ c.maxSkew, c.onSkew = &max, onSkew }
+}
+
+// WithMaxEventAge also replaces the time of published events more than max behind the server clock;
+// by default late events keep their time. See engine.EventBus.SetMaxEventAge.
+func WithMaxEventAge(max time.Duration) Option { return func(c *config){ c.maxAge = max } }
+
+// WithEventSampling coalesces or samples events of one type before they reach subscribers (realtime,
+// analytics, ...); see engine.EventBus.SetSampling. Events are not sampled by default, and milestone
+// events (level-ups, badges, achievements) are always delivered in full.
+func WithEventSampling(typ core.EventType, p engine.SamplingPolicy) Option {
+    return func(c *config){
+        if c.sampling == nil { c.sampling = map[core.EventType]engine.SamplingPolicy{} }
+        c.sampling[typ] = p
+    }
+}
+
+// WithEventInterceptors runs interceptors, in order, on every event before it reaches subscribers;
+// see engine.EventBus.Use.
+func WithEventInterceptors(interceptors ...engine.Interceptor) Option {
+    return func(c *config){ c.interceptors = append(c.interceptors, interceptors...) }
+}
+
+// WithEventEnricher runs fn on every event the service publishes, before interceptors and
+// subscribers; see engine.WithEventEnricher.
+func WithEventEnricher(fn engine.EventEnricher) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithEventEnricher(fn)) }
+}
+
+// WithValuePolicy constrains the totals of a metric (bounds and overflow handling).
+func WithValuePolicy(metric core.Metric, p engine.ValuePolicy) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithValuePolicy(metric, p)) }
+}
+
+// WithDerivedLevels computes a metric's level from its total at read time along curve, e.g.
+// engine.MustLinearCurve(100); a nil curve uses engine.DefaultCurve. See also WithLevelCurve.
+func WithDerivedLevels(metric core.Metric, curve engine.LevelCurve) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithDerivedLevels(metric, curve)) }
+}
+
+// WithLevelMonotonic keeps a derived level from falling when points do; see engine.WithLevelMonotonic.
+func WithLevelMonotonic(metric core.Metric, monotonic bool) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithLevelMonotonic(metric, monotonic)) }
+}
+
+// WithActiveSeason records points into season's ledgers as well as the all-time totals; see engine.WithActiveSeason.
+func WithActiveSeason(season string) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithActiveSeason(season)) }
+}
+
+// WithSeasonArchive archives the final standings of every season StartNewSeason ends in store, as
+// the board "season:"+metric with the season as period; see engine.WithSeasonArchive.
+func WithSeasonArchive(store leaderboard.ArchiveStore, metrics ...core.Metric) Option {
+    archive := func(ctx context.Context, season string, metric core.Metric, standings []engine.SeasonStanding) error {
+        out := make([]leaderboard.Standing, len(standings))
+        for i, st := range standings { out[i] = leaderboard.Standing{Rank: i + 1, User: st.User, Score: st.Points} }
+        return store.SaveArchive(ctx, "season:"+string(metric), season, out)
+    }
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithSeasonArchive(archive, metrics...)) }
+}
+
+// WithRetry retries storage writes that fail with transient errors; see engine.WithRetry.
+func WithRetry(p engine.RetryPolicy) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithRetry(p)) }
+}
+
+// WithUserLimits caps the distinct metrics and badges per user; see engine.WithUserLimits.
+func WithUserLimits(l engine.UserLimits) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithUserLimits(l)) }
+}
+
+// WithMetric registers a metric in the service's catalog; see engine.WithMetric.
+func WithMetric(info engine.MetricInfo) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithMetric(info)) }
+}
+
+// WithBadge registers a badge in the service's catalog; see engine.WithBadge.
+func WithBadge(info engine.BadgeInfo) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithBadge(info)) }
+}
+
+// WithStrictCatalog rejects metrics and badges missing from the catalog; see engine.WithStrictCatalog.
+func WithStrictCatalog() Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithStrictCatalog()) }
+}
+
+// WithLevelCurve levels a metric along curve, e.g. engine.MustExponentialCurve(100, 1.5).
+// Levels follow the curve as derived levels; see WithDerivedLevels.
+func WithLevelCurve(metric core.Metric, curve engine.LevelCurve) Option {
+    if curve == nil { panic("WithLevelCurve requires a curve") }
+    return WithDerivedLevels(metric, curve)
+}
+
+// WithLeaderboard keeps a leaderboard in sync with a metric; users below cfg.MinScore are kept off it.
+func WithLeaderboard(metric core.Metric, cfg engine.BoardConfig) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithLeaderboard(metric, cfg)) }
+}
+
+// WithEventDrivenLeaderboards updates the registered leaderboards from point events instead of the
+// write path; see engine.WithEventDrivenLeaderboards.
+func WithEventDrivenLeaderboards() Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithEventDrivenLeaderboards()) }
+}
+
+// WithMaintainedBadge revokes badge (emitting core.EventBadgeRevoked) once pred no longer holds for
+// a user who has it; see engine.WithMaintainedBadge.
+func WithMaintainedBadge(badge core.Badge, pred func(core.UserState) bool) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithMaintainedBadge(badge, pred)) }
+}
+
+// WithRepeatableBadge lets AwardBadge award badge again once cooldown has passed since the user's
+// last award; see engine.WithRepeatableBadge.
+func WithRepeatableBadge(badge core.Badge, cooldown time.Duration) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithRepeatableBadge(badge, cooldown)) }
+}
+
+// WithDerivedMetric registers metric as a read-only function of the user's stored metrics, computed
+// on read; see engine.WithDerivedMetric.
+func WithDerivedMetric(metric core.Metric, fn func(core.UserState) int64) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithDerivedMetric(metric, fn)) }
+}
+
+// WithCompositeLeaderboard keeps a leaderboard ranked by a weighted sum of several metrics; see
+// engine.WithCompositeLeaderboard.
+func WithCompositeLeaderboard(name string, cfg engine.CompositeBoardConfig) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithCompositeLeaderboard(name, cfg)) }
+}
+
+// WithUserSerialization runs the writes for one user one at a time within the service; see
+// engine.WithUserSerialization.
+func WithUserSerialization() Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithUserSerialization()) }
+}
+
+// WithQuest registers a quest counting points of its metric towards a target; see engine.Quest.
+func WithQuest(q engine.Quest) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithQuest(q)) }
+}
+
+// WithIdentityKinds lets the service resolve external IDs of the given kinds, such as
+// "email:alice@example.com", to linked users; see engine.WithIdentityKinds.
+func WithIdentityKinds(kinds ...string) Option {
+    return func(c *config){ c.svcOpts = append(c.svcOpts, engine.WithIdentityKinds(kinds...)) }
+}
+
 // New builds a configured GamifyService. If not provided, defaults are used:
 //  - storage: in-memory
 //  - rules: DefaultRuleEngine
@@ -42,13 +218,24 @@ func New(opts ...Option) *engine.GamifyService {
         cfg.storage = &inMemoryFallback{}
     }
     bus := engine.NewEventBus(cfg.mode)
-    svc := engine.NewGamifyService(cfg.storage, bus, cfg.rules)
+    if cfg.onDispatch != nil { bus.OnDispatch(cfg.onDispatch) }
+    bus.SetSlowThreshold(cfg.slowAfter)
+    if cfg.maxSkew != nil { bus.SetMaxClockSkew(*cfg.maxSkew) }
+    if cfg.maxAge > 0 { bus.SetMaxEventAge(cfg.maxAge) }
+    if cfg.onSkew != nil { bus.OnClockSkew(cfg.onSkew) }
+    for typ, p := range cfg.sampling { bus.SetSampling(typ, p) }
+    bus.Use(cfg.interceptors...)
+    svc := engine.NewGamifyService(cfg.storage, bus, cfg.rules, cfg.svcOpts...)
+    realtimeHandler := func(broadcast func(context.Context, core.Event)) func(context.Context, core.Event) {
+        if cfg.realtimeLimit == nil { return broadcast }
+        return engine.Intercept(broadcast, engine.LimitEventSize(*cfg.realtimeLimit))
+    }
     if cfg.hub != nil {
-        // Bridge all primary events to realtime
-        bus.Subscribe(core.EventPointsAdded, func(ctx context.Context, e core.Event){ cfg.hub.Broadcast(ctx, e) })
-        bus.Subscribe(core.EventLevelUp, func(ctx context.Context, e core.Event){ cfg.hub.Broadcast(ctx, e) })
-        bus.Subscribe(core.EventBadgeAwarded, func(ctx context.Context, e core.Event){ cfg.hub.Broadcast(ctx, e) })
-        bus.Subscribe(core.EventAchievementUnlocked, func(ctx context.Context, e core.Event){ cfg.hub.Broadcast(ctx, e) })
+        // Bridge every event to realtime, including types added later
+        bus.SubscribeAllNamed("realtime", realtimeHandler(cfg.hub.Broadcast), engine.OrderingFIFO)
+    }
+    if cfg.relay != nil {
+        bus.SubscribeAllNamed("realtime-relay", realtimeHandler(cfg.relay.Broadcast), engine.OrderingFIFO)
     }
     return svc
 }
@@ -63,6 +250,8 @@ func (m *inMemoryFallback) ensure() engine.Storage {
 
 func (m *inMemoryFallback) AddPoints(ctx context.Context, u core.UserID, metric core.Metric, d int64) (int64, error) { return m.ensure().AddPoints(ctx, u, metric, d) }
 func (m *inMemoryFallback) AwardBadge(ctx context.Context, u core.UserID, b core.Badge) error { return m.ensure().AwardBadge(ctx, u, b) }
+func (m *inMemoryFallback) TryAwardBadge(ctx context.Context, u core.UserID, b core.Badge) (bool, error) { return m.ensure().(engine.BadgeAwarder).TryAwardBadge(ctx, u, b) }
+func (m *inMemoryFallback) RemoveBadge(ctx context.Context, u core.UserID, b core.Badge) (bool, error) { return m.ensure().(engine.BadgeRemover).RemoveBadge(ctx, u, b) }
 func (m *inMemoryFallback) GetState(ctx context.Context, u core.UserID) (core.UserState, error) { return m.ensure().GetState(ctx, u) }
 func (m *inMemoryFallback) SetLevel(ctx context.Context, u core.UserID, metric core.Metric, lvl int64) error { return m.ensure().SetLevel(ctx, u, metric, lvl) }
 
@@ -85,8 +274,18 @@ func (s *memStore) AddPoints(_ context.Context, u core.UserID, metric core.Metri
     s.data[u] = st
     return next, nil
 }
-func (s *memStore) AwardBadge(_ context.Context, u core.UserID, b core.Badge) error {
-    st := s.ensure(u); st.Badges[b] = struct{}{}; s.data[u] = st; return nil
+func (s *memStore) AwardBadge(ctx context.Context, u core.UserID, b core.Badge) error {
+    _, err := s.TryAwardBadge(ctx, u, b); return err
+}
+func (s *memStore) TryAwardBadge(_ context.Context, u core.UserID, b core.Badge) (bool, error) {
+    st := s.ensure(u)
+    if _, ok := st.Badges[b]; ok { return false, nil }
+    st.Badges[b] = struct{}{}; s.data[u] = st; return true, nil
+}
+func (s *memStore) RemoveBadge(_ context.Context, u core.UserID, b core.Badge) (bool, error) {
+    st := s.ensure(u)
+    if _, ok := st.Badges[b]; !ok { return false, nil }
+    delete(st.Badges, b); return true, nil
 }
 func (s *memStore) GetState(_ context.Context, u core.UserID) (core.UserState, error) { return s.ensure(u).Clone(), nil }
 func (s *memStore) SetLevel(_ context.Context, u core.UserID, metric core.Metric, lvl int64) error { st := s.ensure(u); st.Levels[metric] = lvl; s.data[u] = st; return nil }
@@ -0,0 +1,60 @@
+// Package gamifytest builds gamify services for deterministic tests. It lives apart from package
+// gamify so that importing gamify does not link package testing into production binaries.
+package gamifytest
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    mem "gamifykit/adapters/memory"
+    "gamifykit/core"
+    "gamifykit/engine"
+    "gamifykit/gamify"
+)
+
+// Epoch is the time a New clock starts at.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Service is a GamifyService wired for deterministic tests; see New.
+type Service struct {
+    *engine.GamifyService
+    // Clock is the fake clock installed with core.SetClock; it only moves when told to.
+    Clock *core.FakeClock
+    // Store is the in-memory storage behind the service.
+    Store *mem.Store
+}
+
+// New builds a service whose behaviour depends on nothing but the test:
+//  - storage: a fresh in-memory store
+//  - dispatch: sync, so subscribers have run when a call returns
+//  - time: a core.FakeClock standing at Epoch, installed as the process clock, so event times,
+//    state timestamps, cooldowns, point windows and leaderboard periods follow it
+//  - event IDs: test-1, test-2, ... (see core.SequentialEventIDs)
+//
+// opts are applied as for gamify.New, e.g. rules, leaderboards (with leaderboard.NewSkipList boards) or
+// policies; storage and dispatch options are overridden. The clock and ID generator are
+// process-wide, so tests using New must not run in parallel. When the test ends the service
+// is shut down and the previous clock and ID generator are restored.
+func New(t testing.TB, opts ...gamify.Option) *Service {
+    t.Helper()
+    clock := core.NewFakeClock(Epoch)
+    prevClock := core.SetClock(clock.Now)
+    prevIDs := core.SetEventIDGenerator(core.SequentialEventIDs("test"))
+    store := mem.New()
+    svc := gamify.New(append(opts, gamify.WithStorage(store), gamify.WithDispatchMode(engine.DispatchSync))...)
+    t.Cleanup(func() {
+        _ = svc.Shutdown(context.Background())
+        core.SetClock(prevClock)
+        core.SetEventIDGenerator(prevIDs)
+    })
+    return &Service{GamifyService: svc, Clock: clock, Store: store}
+}
+
+// Advance moves the clock forward by d and delivers the events held back until then, such as
+// coalesced point events.
+func (s *Service) Advance(d time.Duration) time.Time {
+    now := s.Clock.Advance(d)
+    _ = s.Flush(context.Background())
+    return now
+}
@@ -0,0 +1,31 @@
+package gamifytest
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gamifykit/core"
+)
+
+func TestNewIsDeterministic(t *testing.T) {
+    ctx := context.Background()
+    svc := New(t)
+    var events []core.Event
+    svc.SubscribeAllNamed("test", func(_ context.Context, e core.Event){ events = append(events, e) })
+
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 10); err != nil { t.Fatal(err) }
+    svc.Advance(25 * time.Hour)
+    if _, err := svc.AddPoints(ctx, "alice", core.MetricXP, 5); err != nil { t.Fatal(err) }
+
+    // points_added and level_up, then points_added, in order and without waiting
+    if len(events) != 3 { t.Fatalf("want 3 events, got %+v", events) }
+    if events[0].ID != "test-1" || !events[0].Time.Equal(Epoch) { t.Fatalf("first event = %+v", events[0]) }
+    if last := events[2]; last.ID != "test-3" || !last.Time.Equal(Epoch.Add(25*time.Hour)) { t.Fatalf("last event = %+v", last) }
+
+    st, err := svc.GetState(ctx, "alice")
+    if err != nil { t.Fatal(err) }
+    if !st.Updated.Equal(Epoch.Add(25 * time.Hour)) { t.Fatalf("updated = %v", st.Updated) }
+    recent, err := svc.PointsInWindow(ctx, "alice", core.MetricXP, 24*time.Hour)
+    if err != nil || recent != 5 { t.Fatalf("points in the last day = %d, %v", recent, err) }
+}
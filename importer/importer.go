@@ -0,0 +1,311 @@
+// Package importer seeds storage with users exported from another system.
+//
+// Records are streamed from CSV or JSON and written with upsert semantics: points and levels
+// are set to the imported values and badges are awarded if missing, so re-running an import
+// is safe. Writes go straight to storage and do not publish events or evaluate rules. Imported
+// balances replace the stored ones rather than being added as increments, so they do not count
+// towards PointsInWindow or rolling leaderboards.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+// Record is one user to import.
+type Record struct {
+	UserID core.UserID           `json:"id"`
+	Points map[core.Metric]int64 `json:"points,omitempty"`
+	Badges []core.Badge          `json:"badges,omitempty"`
+	Levels map[core.Metric]int64 `json:"levels,omitempty"`
+}
+
+// Source yields records one at a time; Next returns io.EOF when exhausted.
+// Errors wrapped in RowError skip a single malformed row, any other error stops the import.
+type Source interface {
+	Next() (Record, error)
+}
+
+// RowError reports a row that could not be parsed.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string { return fmt.Sprintf("row %d: %v", e.Row, e.Err) }
+func (e *RowError) Unwrap() error { return e.Err }
+
+// Result is the outcome of importing one row.
+type Result struct {
+	// Row is the 1-based record number, not counting a CSV header
+	Row    int         `json:"row"`
+	UserID core.UserID `json:"user_id,omitempty"`
+	// Changed reports whether the row differed from stored state (or would have, in a dry run)
+	Changed bool   `json:"changed"`
+	Err     string `json:"error,omitempty"`
+}
+
+// Summary totals an import.
+type Summary struct {
+	Rows      int  `json:"rows"`
+	Succeeded int  `json:"succeeded"`
+	Failed    int  `json:"failed"`
+	Changed   int  `json:"changed"`
+	DryRun    bool `json:"dry_run"`
+	// Committed is the last row whose batch is committed: every row up to it is done, failed rows
+	// included. An import that stopped early resumes with Options.Skip set to it.
+	Committed int `json:"committed"`
+}
+
+// DefaultBatchSize is how many records Run writes per transaction when Options.BatchSize is zero.
+const DefaultBatchSize = 100
+
+// Options configures Run.
+type Options struct {
+	// DryRun validates rows and compares them with stored state without writing.
+	DryRun bool
+	// Report, if set, receives the result of every row once its batch is committed.
+	Report func(Result)
+	// BatchSize is how many records are written per transaction (DefaultBatchSize when zero) on
+	// storages that support transactions; other storages write one record at a time.
+	BatchSize int
+	// Skip passes over the first Skip rows without importing them, to resume an import from the
+	// Summary.Committed of an earlier run.
+	Skip int
+}
+
+// pending is a row waiting for its batch to be written; rows that failed to parse carry their error
+type pending struct {
+	row int
+	rec Record
+	err error
+}
+
+// Run imports every record from src into storage, continuing past rows that fail.
+// Records are written in batches of Options.BatchSize, each in one transaction on storages that
+// support it (see engine.Txner). A batch with a failing row is rolled back and written again one
+// row at a time, so only the failing rows are skipped. Results are reported, and
+// Summary.Committed advanced, as batches commit.
+// It returns an error only when src itself fails or ctx is cancelled; the rows of the batch
+// being written are then not committed.
+func Run(ctx context.Context, storage engine.Storage, src Source, opts Options) (Summary, error) {
+	sum := Summary{DryRun: opts.DryRun, Committed: max(opts.Skip, 0)}
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if _, ok := storage.(engine.Txner); !ok {
+		size = 1
+	}
+	report := func(r Result) {
+		sum.Rows++
+		switch {
+		case r.Err != "":
+			sum.Failed++
+		default:
+			sum.Succeeded++
+			if r.Changed {
+				sum.Changed++
+			}
+		}
+		if opts.Report != nil {
+			opts.Report(r)
+		}
+	}
+	var batch []pending
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := writeBatch(ctx, storage, batch, opts.DryRun)
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			report(r)
+		}
+		sum.Committed = batch[len(batch)-1].row
+		batch = batch[:0]
+		return nil
+	}
+
+	for row := 1; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return sum, err
+		}
+		rec, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return sum, flush()
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			if row > opts.Skip {
+				batch = append(batch, pending{row: row, err: rowErr.Err})
+			}
+		} else if err != nil {
+			return sum, err
+		} else if row > opts.Skip {
+			batch = append(batch, pending{row: row, rec: rec})
+		}
+		if len(batch) >= size {
+			if err := flush(); err != nil {
+				return sum, err
+			}
+		}
+	}
+}
+
+// writeBatch writes the rows of a batch in one transaction, falling back to a transaction per row
+// if any of them fails. It returns an error only if ctx ended before the batch was written.
+func writeBatch(ctx context.Context, storage engine.Storage, batch []pending, dryRun bool) ([]Result, error) {
+	results := make([]Result, len(batch))
+	users := make([]core.UserID, len(batch))
+	valid := 0
+	for i, p := range batch {
+		results[i] = Result{Row: p.row, UserID: p.rec.UserID}
+		if p.err == nil {
+			users[i], p.err = validate(p.rec)
+		}
+		if p.err != nil {
+			results[i].Err = p.err.Error()
+			continue
+		}
+		valid++
+	}
+	if valid == 0 {
+		return results, nil
+	}
+
+	if valid > 1 {
+		changed := make([]bool, len(batch))
+		err := engine.RunInTx(ctx, storage, func(tx engine.Storage) error {
+			for i, p := range batch {
+				if results[i].Err != "" {
+					continue
+				}
+				var err error
+				if changed[i], err = write(ctx, tx, users[i], p.rec, dryRun); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			for i := range batch {
+				results[i].Changed = changed[i]
+			}
+			return results, nil
+		}
+	}
+	for i, p := range batch {
+		if results[i].Err != "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var changed bool
+		err := engine.RunInTx(ctx, storage, func(tx engine.Storage) error {
+			var err error
+			changed, err = write(ctx, tx, users[i], p.rec, dryRun)
+			return err
+		})
+		results[i].Changed = changed
+		if err != nil {
+			results[i].Err = err.Error()
+		}
+	}
+	return results, nil
+}
+
+// validate checks a record before anything is written, returning its normalized user ID
+func validate(rec Record) (core.UserID, error) {
+	user, err := core.NormalizeUserID(rec.UserID)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range rec.Badges {
+		if err := core.ValidateBadgeID(b); err != nil {
+			return "", fmt.Errorf("badge %q: %w", b, err)
+		}
+	}
+	return user, nil
+}
+
+// write upserts one record, reporting whether anything differed from stored state. Storages
+// implementing engine.StateReplacer get the resulting state written as a whole, so imported
+// balances are not recorded as increments that rolling windows would count as just earned.
+func write(ctx context.Context, tx engine.Storage, user core.UserID, rec Record, dryRun bool) (bool, error) {
+	state, err := tx.GetState(ctx, user)
+	if err != nil {
+		return false, fmt.Errorf("failed to load user: %w", err)
+	}
+	next := state.Clone()
+	if next.Points == nil {
+		next.Points = map[core.Metric]int64{}
+	}
+	if next.Badges == nil {
+		next.Badges = map[core.Badge]struct{}{}
+	}
+	if next.Levels == nil {
+		next.Levels = map[core.Metric]int64{}
+	}
+	changed := false
+	for metric, target := range rec.Points {
+		if state.Points[metric] != target {
+			changed = true
+			next.Points[metric] = target
+		}
+	}
+	for _, b := range rec.Badges {
+		if _, ok := state.Badges[b]; !ok {
+			changed = true
+			next.Badges[b] = struct{}{}
+		}
+	}
+	for metric, level := range rec.Levels {
+		if cur, ok := state.Levels[metric]; !ok || cur != level {
+			changed = true
+			next.Levels[metric] = level
+		}
+	}
+	if dryRun || !changed {
+		return changed, nil
+	}
+
+	if r, ok := tx.(engine.StateReplacer); ok {
+		if err := r.ReplaceState(ctx, user, next); err != nil {
+			return false, fmt.Errorf("failed to write user: %w", err)
+		}
+		return true, nil
+	}
+	for metric, target := range rec.Points {
+		if delta := target - state.Points[metric]; delta != 0 {
+			if _, err := tx.AddPoints(ctx, user, metric, delta); err != nil {
+				return false, fmt.Errorf("failed to set %s points: %w", metric, err)
+			}
+		}
+	}
+	for _, b := range rec.Badges {
+		if _, ok := state.Badges[b]; ok {
+			continue
+		}
+		if err := tx.AwardBadge(ctx, user, b); err != nil {
+			return false, fmt.Errorf("failed to award badge %s: %w", b, err)
+		}
+	}
+	for metric, level := range rec.Levels {
+		if cur, ok := state.Levels[metric]; ok && cur == level {
+			continue
+		}
+		if err := tx.SetLevel(ctx, user, metric, level); err != nil {
+			return false, fmt.Errorf("failed to set %s level: %w", metric, err)
+		}
+	}
+	return true, nil
+}
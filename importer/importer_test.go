@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	mem "gamifykit/adapters/memory"
+	"gamifykit/core"
+	"gamifykit/engine"
+)
+
+const csvInput = `id,points.xp,points.coins,levels.xp,badges
+alice,1200,50,4,onboarded;veteran
+bob,300,,,
+carol,not-a-number,,,
+,10,,,
+`
+
+func TestRun_CSVUpsertIsIdempotent(t *testing.T) {
+	store := mem.New()
+	ctx := context.Background()
+	_, _ = store.AddPoints(ctx, "bob", core.MetricXP, 1000)
+
+	run := func(dryRun bool) (Summary, []Result) {
+		src, err := NewCSVSource(strings.NewReader(csvInput))
+		require.NoError(t, err)
+		var results []Result
+		sum, err := Run(ctx, store, src, Options{DryRun: dryRun, Report: func(r Result) { results = append(results, r) }})
+		require.NoError(t, err)
+		return sum, results
+	}
+
+	sum, results := run(true)
+	assert.Equal(t, Summary{Rows: 4, Succeeded: 2, Failed: 2, Changed: 2, DryRun: true, Committed: 4}, sum)
+	st, _ := store.GetState(ctx, "alice")
+	assert.Empty(t, st.Points, "dry run must not write")
+	assert.Equal(t, 3, results[2].Row)
+	assert.Contains(t, results[2].Err, "points.xp")
+
+	sum, _ = run(false)
+	assert.Equal(t, 2, sum.Changed)
+	st, _ = store.GetState(ctx, "alice")
+	assert.Equal(t, int64(1200), st.Points[core.MetricXP])
+	assert.Equal(t, int64(50), st.Points["coins"])
+	assert.Equal(t, int64(4), st.Levels[core.MetricXP])
+	assert.Len(t, st.Badges, 2)
+	st, _ = store.GetState(ctx, "bob")
+	assert.Equal(t, int64(300), st.Points[core.MetricXP], "points are set, not added")
+
+	sum, _ = run(false)
+	assert.Equal(t, 0, sum.Changed, "re-running an import changes nothing")
+	assert.Equal(t, 2, sum.Succeeded)
+}
+
+func TestRun_ImportedBalancesAreNotRecentIncrements(t *testing.T) {
+	ctx := context.Background()
+	store := mem.New()
+	_, _ = store.AddPoints(ctx, "bob", core.MetricXP, 5)
+	src, err := NewJSONSource(strings.NewReader(`[{"id": "alice", "points": {"xp": 1200}}, {"id": "bob", "points": {"xp": 300}}]`))
+	require.NoError(t, err)
+	sum, err := Run(ctx, store, src, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sum.Changed)
+
+	recent, err := store.PointsInWindow(ctx, "alice", core.MetricXP, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, recent, "an imported balance was not earned just now")
+	recent, err = store.PointsInWindow(ctx, "bob", core.MetricXP, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), recent, "only the earlier increment counts")
+	st, _ := store.GetState(ctx, "bob")
+	assert.Equal(t, int64(300), st.Points[core.MetricXP])
+}
+
+func TestRun_JSONFormats(t *testing.T) {
+	inputs := map[string]string{
+		"array": `[{"id": "alice", "points": {"xp": 10}}, {"id": "bob", "points": {"xp": "x"}}, {"id": "carol", "badges": ["b"]}]`,
+		"lines": "{\"id\": \"alice\", \"points\": {\"xp\": 10}}\n{broken\n\n{\"id\": \"carol\", \"badges\": [\"b\"]}\n",
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			store := mem.New()
+			src, err := NewJSONSource(strings.NewReader(input))
+			require.NoError(t, err)
+			sum, err := Run(context.Background(), store, src, Options{})
+			require.NoError(t, err)
+			assert.Equal(t, 3, sum.Rows)
+			assert.Equal(t, 2, sum.Succeeded)
+			assert.Equal(t, 1, sum.Failed)
+			st, _ := store.GetState(context.Background(), "carol")
+			assert.Contains(t, st.Badges, core.Badge("b"))
+		})
+	}
+}
+
+func TestRun_SourceFailureStops(t *testing.T) {
+	boom := errors.New("disk gone")
+	_, err := Run(context.Background(), mem.New(), failingSource{boom}, Options{})
+	assert.ErrorIs(t, err, boom)
+}
+
+func TestRun_BatchesRollBackToFailingRows(t *testing.T) {
+	ctx := context.Background()
+	store := &txStore{Storage: mem.New(), fail: "bob"}
+	input := `[{"id": "alice", "points": {"xp": 1}}, {"id": "bob", "points": {"xp": 2}}, {"id": "carol", "points": {"xp": 3}}]`
+	src, err := NewJSONSource(strings.NewReader(input))
+	require.NoError(t, err)
+	sum, err := Run(ctx, store, src, Options{BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Rows: 3, Succeeded: 2, Failed: 1, Changed: 2, Committed: 3}, sum)
+	// the first batch fails on bob and is written again row by row; carol's batch has one row
+	assert.Equal(t, 4, store.txs)
+	var committed []int
+	for _, user := range []core.UserID{"alice", "bob", "carol"} {
+		st, _ := store.GetState(ctx, user)
+		committed = append(committed, int(st.Points[core.MetricXP]))
+	}
+	assert.Equal(t, []int{1, 0, 3}, committed)
+
+	// resuming after the first two rows only imports carol
+	store = &txStore{Storage: mem.New()}
+	src, err = NewJSONSource(strings.NewReader(input))
+	require.NoError(t, err)
+	sum, err = Run(ctx, store, src, Options{Skip: 2})
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Rows: 1, Succeeded: 1, Changed: 1, Committed: 3}, sum)
+	st, _ := store.GetState(ctx, "alice")
+	assert.Empty(t, st.Points)
+}
+
+func TestNewCSVSource_RejectsUnknownColumns(t *testing.T) {
+	_, err := NewCSVSource(strings.NewReader("id,score\n"))
+	assert.Error(t, err)
+	_, err = NewCSVSource(strings.NewReader("points.xp\n"))
+	assert.Error(t, err)
+}
+
+type failingSource struct{ err error }
+
+func (f failingSource) Next() (Record, error) { return Record{}, f.err }
+
+// txStore is a transactional storage whose transactions buffer writes until they commit; writes
+// for the fail user fail
+type txStore struct {
+	engine.Storage
+	fail core.UserID
+	txs  int
+}
+
+func (s *txStore) WithTx(ctx context.Context, fn func(tx engine.Storage) error) error {
+	s.txs++
+	tx := &bufferedTx{Storage: s.Storage, fail: s.fail}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, w := range tx.writes {
+		if err := w(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bufferedTx struct {
+	engine.Storage
+	fail   core.UserID
+	writes []func() error
+}
+
+func (t *bufferedTx) AddPoints(ctx context.Context, user core.UserID, metric core.Metric, delta int64) (int64, error) {
+	if user == t.fail {
+		return 0, errors.New("write failed")
+	}
+	t.writes = append(t.writes, func() error { _, err := t.Storage.AddPoints(ctx, user, metric, delta); return err })
+	return 0, nil
+}
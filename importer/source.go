@@ -0,0 +1,182 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gamifykit/core"
+)
+
+// NewCSVSource streams records from CSV with a header row. Columns:
+//   - id: the user ID (required)
+//   - points.<metric>: points total for metric, e.g. points.xp
+//   - levels.<metric>: level for metric
+//   - badges: badges separated by ';'
+//
+// Empty cells are ignored, so sparse exports need no placeholder values.
+func NewCSVSource(r io.Reader) (Source, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	src := &csvSource{r: cr, id: -1, cols: make([]string, len(header))}
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		src.cols[i] = h
+		switch {
+		case h == "id":
+			src.id = i
+		case h == "badges", strings.HasPrefix(h, "points."), strings.HasPrefix(h, "levels."):
+		default:
+			return nil, fmt.Errorf("unknown CSV column %q", h)
+		}
+	}
+	if src.id < 0 {
+		return nil, errors.New(`CSV header must include an "id" column`)
+	}
+	return src, nil
+}
+
+type csvSource struct {
+	r    *csv.Reader
+	id   int
+	cols []string
+	row  int
+}
+
+func (s *csvSource) Next() (Record, error) {
+	fields, err := s.r.Read()
+	s.row++
+	if err == io.EOF {
+		return Record{}, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Record{}, &RowError{Row: s.row, Err: err}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	rec := Record{Points: map[core.Metric]int64{}, Levels: map[core.Metric]int64{}}
+	for i, v := range fields {
+		v = strings.TrimSpace(v)
+		if i >= len(s.cols) {
+			return Record{}, &RowError{Row: s.row, Err: fmt.Errorf("row has %d fields, header has %d", len(fields), len(s.cols))}
+		}
+		if v == "" {
+			continue
+		}
+		col := s.cols[i]
+		switch {
+		case i == s.id:
+			rec.UserID = core.UserID(v)
+		case col == "badges":
+			for _, b := range strings.Split(v, ";") {
+				if b = strings.TrimSpace(b); b != "" {
+					rec.Badges = append(rec.Badges, core.Badge(b))
+				}
+			}
+		default:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return Record{}, &RowError{Row: s.row, Err: fmt.Errorf("column %s: %w", col, err)}
+			}
+			kind, metric, _ := strings.Cut(col, ".")
+			if kind == "points" {
+				rec.Points[core.Metric(metric)] = n
+			} else {
+				rec.Levels[core.Metric(metric)] = n
+			}
+		}
+	}
+	return rec, nil
+}
+
+// NewJSONSource streams records from either a JSON array of objects or JSON lines
+// (one object per line). Objects look like
+//
+//	{"id": "alice", "points": {"xp": 1200}, "badges": ["onboarded"], "levels": {"xp": 4}}
+func NewJSONSource(r io.Reader) (Source, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return &jsonLinesSource{sc: bufio.NewScanner(br)}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.ContainsAny(b, " \t\r\n") {
+			_, _ = br.ReadByte()
+			continue
+		}
+		if b[0] != '[' {
+			sc := bufio.NewScanner(br)
+			sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+			return &jsonLinesSource{sc: sc}, nil
+		}
+		dec := json.NewDecoder(br)
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return &jsonArraySource{dec: dec}, nil
+	}
+}
+
+type jsonArraySource struct {
+	dec *json.Decoder
+	row int
+}
+
+func (s *jsonArraySource) Next() (Record, error) {
+	if !s.dec.More() {
+		return Record{}, io.EOF
+	}
+	s.row++
+	var rec Record
+	err := s.dec.Decode(&rec)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// the decoder has consumed the value, so the rest of the array is still readable
+		return Record{}, &RowError{Row: s.row, Err: err}
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid JSON at record %d: %w", s.row, err)
+	}
+	return rec, nil
+}
+
+type jsonLinesSource struct {
+	sc  *bufio.Scanner
+	row int
+}
+
+func (s *jsonLinesSource) Next() (Record, error) {
+	for s.sc.Scan() {
+		s.row++
+		line := bytes.TrimSpace(s.sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return Record{}, &RowError{Row: s.row, Err: err}
+		}
+		return rec, nil
+	}
+	if err := s.sc.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
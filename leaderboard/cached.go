@@ -0,0 +1,224 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+)
+
+const (
+	// DefaultSnapshotSize is how many top entries a CachedBoard materializes by default.
+	DefaultSnapshotSize = 100
+	// DefaultSnapshotInterval is how long a CachedBoard snapshot is served before it is refreshed.
+	DefaultSnapshotInterval = 10 * time.Second
+)
+
+// Snapshot is a page of top entries together with where it came from.
+type Snapshot struct {
+	Entries []Entry
+	// AsOf is when the entries were read from the underlying board.
+	AsOf time.Time
+	// Cached reports whether the entries came from the materialized snapshot rather than a live query.
+	Cached bool
+}
+
+// CacheOption configures a CachedBoard.
+type CacheOption func(*CachedBoard)
+
+// WithSnapshotSize sets how many top entries are materialized (DefaultSnapshotSize when not
+// positive, at most MaxPageSize). TopN calls for more entries are answered live.
+func WithSnapshotSize(n int) CacheOption {
+	return func(c *CachedBoard) {
+		if n > 0 {
+			c.size = pageSize(n)
+		}
+	}
+}
+
+// WithSnapshotInterval sets how long a snapshot is served before it is refreshed
+// (DefaultSnapshotInterval when not positive).
+func WithSnapshotInterval(d time.Duration) CacheOption {
+	return func(c *CachedBoard) {
+		if d > 0 {
+			c.interval = d
+		}
+	}
+}
+
+// WithSnapshotClock sets the clock snapshot ages are measured with, e.g. for tests.
+func WithSnapshotClock(now func() time.Time) CacheOption {
+	return func(c *CachedBoard) { c.now = now }
+}
+
+// CachedBoard serves the top of a board from an in-memory snapshot, e.g. in front of a RedisBoard
+// behind a busy public leaderboard page, so page loads do not each query the backend. The snapshot
+// holds the top WithSnapshotSize entries and is refreshed once it is older than
+// WithSnapshotInterval, either by the first read after that or by Run in the background.
+//
+// Writes go straight to the underlying board. A write through the CachedBoard that changes the
+// snapshotted top (a user on it, or a score that would enter it) marks the snapshot stale, so the
+// next read sees it. On AggregateSum boards only the submitted score is compared, so a user
+// climbing into the top through many small results may take an interval to appear, like writes
+// made elsewhere, e.g. by other server instances. Get, Rank, Around and the Distribution methods
+// always query the board live, as do TopN calls for more entries than the snapshot holds.
+type CachedBoard struct {
+	board    Board
+	size     int
+	interval time.Duration
+	now      func() time.Time
+
+	mu         sync.RWMutex
+	entries    []Entry
+	asOf       time.Time
+	stale      bool
+	refreshing sync.Mutex
+}
+
+// NewCachedBoard wraps board with a snapshot cache.
+func NewCachedBoard(board Board, opts ...CacheOption) *CachedBoard {
+	c := &CachedBoard{board: board, size: DefaultSnapshotSize, interval: DefaultSnapshotInterval, now: core.CurrentTime, stale: true}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// TopNSnapshot returns the best n entries (at most MaxPageSize) and whether they were served from
+// the snapshot.
+func (c *CachedBoard) TopNSnapshot(n int) Snapshot {
+	n = pageSize(n)
+	if n > c.size {
+		return Snapshot{Entries: c.board.TopN(n), AsOf: c.now()}
+	}
+	entries, asOf := c.current()
+	return Snapshot{Entries: entries[:min(n, len(entries))], AsOf: asOf, Cached: true}
+}
+
+// TopN returns the best n entries like TopNSnapshot, without the freshness details.
+func (c *CachedBoard) TopN(n int) []Entry { return c.TopNSnapshot(n).Entries }
+
+// Refresh materializes the snapshot from the board now.
+func (c *CachedBoard) Refresh() {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+	c.refresh(true)
+}
+
+// Run refreshes the snapshot every interval until ctx is done, so reads never wait for the board.
+func (c *CachedBoard) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	c.Refresh()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Refresh()
+		}
+	}
+}
+
+// current returns the snapshot, refreshing it first when it is stale or expired. While one reader
+// refreshes, the others keep serving the previous snapshot instead of querying the board too.
+func (c *CachedBoard) current() ([]Entry, time.Time) {
+	c.mu.RLock()
+	entries, asOf, fresh := c.entries, c.asOf, !c.stale && c.now().Sub(c.asOf) < c.interval
+	c.mu.RUnlock()
+	if fresh {
+		return entries, asOf
+	}
+	if entries != nil && !c.refreshing.TryLock() {
+		return entries, asOf
+	}
+	if entries == nil {
+		c.refreshing.Lock()
+	}
+	defer c.refreshing.Unlock()
+	return c.refresh(false)
+}
+
+// refresh reads the top of the board, unless force is false and a concurrent refresh just did;
+// the caller holds c.refreshing
+func (c *CachedBoard) refresh(force bool) ([]Entry, time.Time) {
+	c.mu.Lock()
+	if !force && c.entries != nil && !c.stale && c.now().Sub(c.asOf) < c.interval {
+		defer c.mu.Unlock()
+		return c.entries, c.asOf
+	}
+	// writes landing while the board is read mark the new snapshot stale again
+	c.stale = false
+	c.mu.Unlock()
+	entries, asOf := c.board.TopN(c.size), c.now()
+	if entries == nil {
+		entries = []Entry{}
+	}
+	c.mu.Lock()
+	c.entries, c.asOf = entries, asOf
+	c.mu.Unlock()
+	return entries, asOf
+}
+
+// Update submits the score to the board and marks the snapshot stale if the user is on it or
+// the score would enter it.
+func (c *CachedBoard) Update(user core.UserID, score int64) {
+	c.board.Update(user, score)
+	c.invalidate(user, score, true)
+}
+
+// Remove removes the user from the board and marks the snapshot stale if they were on it.
+func (c *CachedBoard) Remove(user core.UserID) {
+	c.board.Remove(user)
+	c.invalidate(user, 0, false)
+}
+
+// invalidate marks the snapshot stale when a write to user can change it
+func (c *CachedBoard) invalidate(user core.UserID, score int64, updated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stale {
+		return
+	}
+	for _, e := range c.entries {
+		if e.User == user {
+			c.stale = true
+			return
+		}
+	}
+	if updated && (len(c.entries) < c.size || score >= c.entries[len(c.entries)-1].Score) {
+		c.stale = true
+	}
+}
+
+func (c *CachedBoard) Get(user core.UserID) (Entry, bool) { return c.board.Get(user) }
+func (c *CachedBoard) Rank(user core.UserID) (int, bool)  { return c.board.Rank(user) }
+func (c *CachedBoard) Around(user core.UserID, radius int) []Entry {
+	return c.board.Around(user, radius)
+}
+
+// Percentile queries the board live. It returns errors.ErrUnsupported if the board does not
+// implement Distribution.
+func (c *CachedBoard) Percentile(user core.UserID) (float64, error) {
+	d, ok := c.board.(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.Percentile(user)
+}
+
+// ScoreAtPercentile queries the board live; see Percentile.
+func (c *CachedBoard) ScoreAtPercentile(p float64) (int64, error) {
+	d, ok := c.board.(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.ScoreAtPercentile(p)
+}
+
+var (
+	_ Board        = (*CachedBoard)(nil)
+	_ Distribution = (*CachedBoard)(nil)
+)
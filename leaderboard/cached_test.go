@@ -0,0 +1,70 @@
+package leaderboard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gamifykit/core"
+)
+
+// countingBoard counts the TopN queries that reach the board
+type countingBoard struct {
+	*SkipList
+	topN int
+}
+
+func (c *countingBoard) TopN(n int) []Entry {
+	c.topN++
+	return c.SkipList.TopN(n)
+}
+
+func TestCachedBoardServesSnapshot(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	board := &countingBoard{SkipList: NewSkipList()}
+	for i := 1; i <= 5; i++ {
+		board.Update(userID(i), int64(i*10))
+	}
+	c := NewCachedBoard(board, WithSnapshotSize(3), WithSnapshotInterval(time.Minute), WithSnapshotClock(func() time.Time { return now }))
+
+	first := c.TopNSnapshot(3)
+	if !first.Cached || !first.AsOf.Equal(now) || len(first.Entries) != 3 || first.Entries[0].Score != 50 {
+		t.Fatalf("first read = %+v", first)
+	}
+	now = now.Add(30 * time.Second)
+	if s := c.TopNSnapshot(2); !s.Cached || !s.AsOf.Equal(first.AsOf) || len(s.Entries) != 2 {
+		t.Fatalf("second read = %+v, want the cached snapshot", s)
+	}
+	if board.topN != 1 {
+		t.Fatalf("board queried %d times, want once", board.topN)
+	}
+
+	// more entries than the snapshot holds are read live
+	if s := c.TopNSnapshot(5); s.Cached || len(s.Entries) != 5 {
+		t.Fatalf("large read = %+v, want live", s)
+	}
+
+	// writes below the snapshot leave it alone; writes entering it invalidate it
+	c.Update("low", 1)
+	if c.TopNSnapshot(3); board.topN != 2 {
+		t.Fatalf("board queried %d times after an insignificant write, want 2", board.topN)
+	}
+	c.Update("high", 100)
+	if s := c.TopNSnapshot(3); s.Entries[0].User != "high" || board.topN != 3 {
+		t.Fatalf("after a significant write got %+v (%d queries)", s.Entries, board.topN)
+	}
+
+	// snapshots expire after the interval
+	board.Update(userID(1), 200)
+	now = now.Add(time.Minute)
+	if s := c.TopNSnapshot(1); s.Entries[0].User != userID(1) || !s.AsOf.Equal(now) {
+		t.Fatalf("expired snapshot not refreshed: %+v", s)
+	}
+
+	// precise lookups are always live
+	if r, ok := c.Rank("low"); !ok || r != 7 {
+		t.Fatalf("rank = %d, %v; want 7", r, ok)
+	}
+}
+
+func userID(i int) core.UserID { return core.UserID(fmt.Sprintf("user-%d", i)) }
@@ -1,6 +1,30 @@
 package leaderboard
 
-import "gamifykit/core"
+import (
+    "errors"
+    "math"
+
+    "gamifykit/core"
+)
+
+var (
+    // ErrEmptyBoard is returned by distribution queries on a board without users.
+    ErrEmptyBoard = errors.New("leaderboard is empty")
+    // ErrNotOnBoard is returned by Percentile for users without an entry.
+    ErrNotOnBoard = errors.New("user is not on the leaderboard")
+    // ErrInvalidPercentile is returned by ScoreAtPercentile for p outside [0, 1].
+    ErrInvalidPercentile = errors.New("percentile must be between 0 and 1")
+)
+
+// MaxPageSize bounds the entries one TopN or Around call returns, so a caller passing a huge n
+// or radius cannot pull a whole board into memory. Larger requests are clamped to it.
+const MaxPageSize = 1000
+
+// pageSize clamps a requested number of entries to MaxPageSize
+func pageSize(n int) int { return min(n, MaxPageSize) }
+
+// aroundRadius clamps a requested radius so Around returns at most MaxPageSize entries
+func aroundRadius(radius int) int { return min(radius, (MaxPageSize-1)/2) }
 
 // Entry represents a score entry.
 type Entry struct {
@@ -8,12 +32,72 @@ type Entry struct {
     Score int64
 }
 
+// Aggregation decides how Update combines a new score with the user's current one. It is chosen
+// when a board is constructed, so a "best run" and a "lifetime total" board can be fed the same
+// game results.
+type Aggregation int
+
+const (
+    // AggregateSet replaces the user's score (the default).
+    AggregateSet Aggregation = iota
+    // AggregateMax keeps the highest score ever submitted.
+    AggregateMax
+    // AggregateSum adds every submitted score to the user's total. Feed it individual results,
+    // not running totals such as those engine.WithLeaderboard submits.
+    AggregateSum
+)
+
+// aggregate returns the score Update stores for a user who had current (if ok) and submits score
+func (a Aggregation) aggregate(current int64, ok bool, score int64) int64 {
+    if !ok { return score }
+    switch a {
+    case AggregateMax:
+        return max(current, score)
+    case AggregateSum:
+        sum, err := core.AddSafe(current, score)
+        if err != nil { return current }
+        return sum
+    }
+    return score
+}
+
 // Board abstracts leaderboard operations.
 type Board interface {
+    // Update submits a score for user, combined with their current one per the board's Aggregation.
     Update(user core.UserID, score int64)
     Remove(user core.UserID)
+    // TopN returns the best n entries, at most MaxPageSize.
     TopN(n int) []Entry
     Get(user core.UserID) (Entry, bool)
+    // Rank returns the user's 1-based position.
+    Rank(user core.UserID) (int, bool)
+    // Around returns up to radius entries on each side of user, including user; radius is
+    // clamped so at most MaxPageSize entries are returned.
+    Around(user core.UserID, radius int) []Entry
+}
+
+// Distribution places users within the whole board, e.g. to tell a user they are in the top 5%.
+// SkipList, RedisBoard and WindowedBoard implement it.
+type Distribution interface {
+    // Percentile returns 1 - rank/count for the user's 0-based rank: 1 for the leader, 1/count
+    // for the last user. It fails with ErrNotOnBoard or ErrEmptyBoard.
+    Percentile(user core.UserID) (float64, error)
+    // ScoreAtPercentile returns the lowest score that still reaches percentile p (0 to 1), the
+    // cutoff for a tier such as "top 10%" (p = 0.9). It fails with ErrEmptyBoard on an empty board.
+    ScoreAtPercentile(p float64) (int64, error)
 }
 
+// percentile computes 1 - rank/count for a 0-based rank
+func percentile(rank, count int64) float64 {
+    return 1 - float64(rank)/float64(count)
+}
 
+// percentileIndex is the 0-based rank of the lowest entry reaching percentile p, the largest rank r
+// with 1 - r/count >= p. A small epsilon keeps e.g. (1-0.9)*10 from rounding down to 0.
+func percentileIndex(p float64, count int64) (int64, error) {
+    if math.IsNaN(p) || p < 0 || p > 1 { return 0, ErrInvalidPercentile }
+    if count == 0 { return 0, ErrEmptyBoard }
+    idx := int64(math.Floor((1-p)*float64(count) + 1e-9))
+    if idx > count-1 { idx = count - 1 }
+    return idx, nil
+}
@@ -1,3 +1,389 @@
 package leaderboard
 
-// Placeholder for a Redis-backed leaderboard using sorted sets.
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gamifykit/core"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBoard is a Board backed by a Redis sorted set.
+// Board methods do not return errors; Redis failures behave like an empty or unchanged board.
+// Sorted-set scores are float64, so scores are exact only up to 2^53 in magnitude and rounded
+// beyond that.
+// On Redis Cluster every operation touches only the board's own slot, so per-period boards of a
+// WindowedBoard can live on different nodes.
+type RedisBoard struct {
+	client   redis.UniversalClient
+	cluster  bool
+	key      string
+	prefix   string
+	timeout  time.Duration
+	tiebreak bool
+	agg      Aggregation
+	now      func() time.Time
+}
+
+// RedisOption configures a RedisBoard.
+type RedisOption func(*RedisBoard)
+
+// WithTimeTiebreak ranks users with equal scores by who reached that score first.
+// The time a user reached their current score is kept in a hash next to the sorted set
+// rather than packed into the low bits of the score, which would cost the score precision.
+func WithTimeTiebreak() RedisOption {
+	return func(b *RedisBoard) { b.tiebreak = true }
+}
+
+// WithClock overrides the clock used to stamp when a score was reached (core.CurrentTime by
+// default; useful for tests).
+func WithClock(now func() time.Time) RedisOption {
+	return func(b *RedisBoard) { b.now = now }
+}
+
+// WithAggregation sets how Update combines scores (AggregateSet by default): AggregateMax uses
+// ZADD GT (Redis 6.2 or later) and AggregateSum uses ZINCRBY.
+func WithAggregation(agg Aggregation) RedisOption {
+	return func(b *RedisBoard) { b.agg = agg }
+}
+
+// WithTimeout sets the per-operation Redis timeout (default 3s).
+func WithTimeout(d time.Duration) RedisOption {
+	return func(b *RedisBoard) { b.timeout = d }
+}
+
+// WithKeyPrefix prepends prefix to the board's keys, e.g. "gamifykit:prod:" to store the board
+// "leaderboard:xp" at "gamifykit:prod:leaderboard:xp". Use the storage's prefix (the Redis
+// adapter's Config.KeyPrefix) so all of an app's keys share it.
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(b *RedisBoard) { b.prefix = prefix }
+}
+
+// NewRedisBoard creates a leaderboard stored in the sorted set at key.
+func NewRedisBoard(client redis.UniversalClient, key string, opts ...RedisOption) *RedisBoard {
+	_, cluster := client.(*redis.ClusterClient)
+	b := &RedisBoard{client: client, cluster: cluster, timeout: 3 * time.Second, now: core.CurrentTime}
+	for _, o := range opts {
+		o(b)
+	}
+	b.key = b.prefix + key
+	return b
+}
+
+// reachedKey holds user -> unix nanos at which the current score was reached. On Redis Cluster
+// it is hash-tagged with the board key (unless the key has a tag already) so the tiebreak script's
+// two keys share a slot.
+func (b *RedisBoard) reachedKey() string {
+	if b.cluster && !hasHashTag(b.key) {
+		return "{" + b.key + "}:reached"
+	}
+	return b.key + ":reached"
+}
+
+// hasHashTag reports whether key contains a non-empty {...} section, which Redis Cluster hashes
+// instead of the whole key
+func hasHashTag(key string) bool {
+	open := strings.IndexByte(key, '{')
+	if open < 0 {
+		return false
+	}
+	end := strings.IndexByte(key[open+1:], '}')
+	return end > 0
+}
+
+func (b *RedisBoard) ctx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), b.timeout)
+}
+
+// updateTiebreakScript aggregates the score (ARGV[4]: set, max or sum) and stamps the reach time
+// only when the score changes, so resubmitting the same score does not lose the earlier position.
+var updateTiebreakScript = redis.NewScript(`
+	local current = redis.call('ZSCORE', KEYS[1], ARGV[1])
+	local score = tonumber(ARGV[2])
+	if current then
+		current = tonumber(current)
+		if ARGV[4] == 'max' and current > score then
+			score = current
+		elseif ARGV[4] == 'sum' then
+			score = current + score
+		end
+		if current == score then
+			return 0
+		end
+	end
+	redis.call('ZADD', KEYS[1], score, ARGV[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+	return 1
+`)
+
+// Update inserts user or moves them to the score combined per the board's Aggregation.
+func (b *RedisBoard) Update(user core.UserID, score int64) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	if b.tiebreak {
+		updateTiebreakScript.Run(ctx, b.client, []string{b.key, b.reachedKey()},
+			string(user), score, b.now().UnixNano(), b.agg.scriptMode())
+		return
+	}
+	switch b.agg {
+	case AggregateMax:
+		b.client.ZAddGT(ctx, b.key, redis.Z{Score: float64(score), Member: string(user)})
+	case AggregateSum:
+		b.client.ZIncrBy(ctx, b.key, float64(score), string(user))
+	default:
+		b.client.ZAdd(ctx, b.key, redis.Z{Score: float64(score), Member: string(user)})
+	}
+}
+
+// scriptMode names the aggregation for updateTiebreakScript
+func (a Aggregation) scriptMode() string {
+	switch a {
+	case AggregateMax:
+		return "max"
+	case AggregateSum:
+		return "sum"
+	}
+	return "set"
+}
+
+// Remove deletes user from the board.
+func (b *RedisBoard) Remove(user core.UserID) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	b.client.ZRem(ctx, b.key, string(user))
+	if b.tiebreak {
+		b.client.HDel(ctx, b.reachedKey(), string(user))
+	}
+}
+
+// Get returns the user's entry.
+func (b *RedisBoard) Get(user core.UserID) (Entry, bool) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	score, err := b.client.ZScore(ctx, b.key, string(user)).Result()
+	if err != nil {
+		return Entry{}, false
+	}
+	return Entry{User: user, Score: int64(score)}, true
+}
+
+// TopN returns the n highest entries, at most MaxPageSize.
+func (b *RedisBoard) TopN(n int) []Entry {
+	if n = pageSize(n); n <= 0 {
+		return nil
+	}
+	return b.rangeByRank(0, int64(n-1))
+}
+
+// Rank returns the user's 1-based position.
+func (b *RedisBoard) Rank(user core.UserID) (int, bool) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	r, err := b.rank(ctx, user)
+	if err != nil {
+		return 0, false
+	}
+	return int(r) + 1, true
+}
+
+// rank returns the user's 0-based position, or redis.Nil if the user is not on the board
+func (b *RedisBoard) rank(ctx context.Context, user core.UserID) (int64, error) {
+	if !b.tiebreak {
+		return b.client.ZRevRank(ctx, b.key, string(user)).Result()
+	}
+
+	score, err := b.client.ZScore(ctx, b.key, string(user)).Result()
+	if err != nil {
+		return 0, err
+	}
+	higher, err := b.client.ZCount(ctx, b.key, "("+formatScore(score), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+	ties, err := b.tieGroup(ctx, score)
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range ties {
+		if e.User == user {
+			return higher + int64(i), nil
+		}
+	}
+	return 0, redis.Nil
+}
+
+// Around returns up to radius entries on each side of user, including user.
+func (b *RedisBoard) Around(user core.UserID, radius int) []Entry {
+	rank, ok := b.Rank(user)
+	if !ok || radius < 0 {
+		return nil
+	}
+	radius = aroundRadius(radius)
+	start := rank - 1 - radius
+	if start < 0 {
+		start = 0
+	}
+	return b.rangeByRank(int64(start), int64(rank-1+radius))
+}
+
+// Count returns the number of users on the board.
+func (b *RedisBoard) Count() int {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	n, err := b.client.ZCard(ctx, b.key).Result()
+	if err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// Percentile returns 1 - rank/count from ZREVRANK and ZCARD; see Distribution. Unlike the Board
+// methods it reports Redis failures.
+func (b *RedisBoard) Percentile(user core.UserID) (float64, error) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	count, err := b.client.ZCard(ctx, b.key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, ErrEmptyBoard
+	}
+	rank, err := b.rank(ctx, user)
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrNotOnBoard
+	}
+	if err != nil {
+		return 0, err
+	}
+	return percentile(rank, count), nil
+}
+
+// ScoreAtPercentile reads the score at the cutoff rank with ZREVRANGE; see Distribution.
+func (b *RedisBoard) ScoreAtPercentile(p float64) (int64, error) {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	count, err := b.client.ZCard(ctx, b.key).Result()
+	if err != nil {
+		return 0, err
+	}
+	idx, err := percentileIndex(p, count)
+	if err != nil {
+		return 0, err
+	}
+	zs, err := b.client.ZRevRangeWithScores(ctx, b.key, idx, idx).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(zs) == 0 {
+		// the board shrank between ZCARD and ZREVRANGE
+		return 0, ErrEmptyBoard
+	}
+	return int64(zs[0].Score), nil
+}
+
+// rangeByRank returns entries for 0-based ranks [start, stop].
+// With the tiebreak enabled, the score groups cut by either boundary are loaded in full
+// and re-sorted so ties are ordered by reach time before slicing.
+func (b *RedisBoard) rangeByRank(start, stop int64) []Entry {
+	ctx, cancel := b.ctx()
+	defer cancel()
+	zs, err := b.client.ZRevRangeWithScores(ctx, b.key, start, stop).Result()
+	if err != nil || len(zs) == 0 {
+		return nil
+	}
+	if !b.tiebreak {
+		return toEntries(zs)
+	}
+
+	top, bottom := zs[0].Score, zs[len(zs)-1].Score
+	offset, err := b.client.ZCount(ctx, b.key, "("+formatScore(top), "+inf").Result()
+	if err != nil {
+		return nil
+	}
+	members, err := b.client.ZRevRangeByScoreWithScores(ctx, b.key, &redis.ZRangeBy{
+		Min: formatScore(bottom), Max: formatScore(top),
+	}).Result()
+	if err != nil {
+		return nil
+	}
+	sorted, err := b.sortByReached(ctx, members)
+	if err != nil {
+		return nil
+	}
+
+	from, to := start-offset, stop-offset+1
+	if to > int64(len(sorted)) {
+		to = int64(len(sorted))
+	}
+	if from < 0 || from >= to {
+		return nil
+	}
+	return sorted[from:to]
+}
+
+// tieGroup returns all users holding score, ordered by reach time
+func (b *RedisBoard) tieGroup(ctx context.Context, score float64) ([]Entry, error) {
+	s := formatScore(score)
+	members, err := b.client.ZRevRangeByScoreWithScores(ctx, b.key, &redis.ZRangeBy{Min: s, Max: s}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return b.sortByReached(ctx, members)
+}
+
+// sortByReached orders entries by score desc, reach time asc, then user asc
+func (b *RedisBoard) sortByReached(ctx context.Context, zs []redis.Z) ([]Entry, error) {
+	entries := toEntries(zs)
+	if len(entries) == 0 {
+		return entries, nil
+	}
+	users := make([]string, len(entries))
+	for i, e := range entries {
+		users[i] = string(e.User)
+	}
+	vals, err := b.client.HMGet(ctx, b.reachedKey(), users...).Result()
+	if err != nil {
+		return nil, err
+	}
+	reached := make(map[core.UserID]int64, len(entries))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			reached[entries[i].User], _ = strconv.ParseInt(s, 10, 64)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		ri, rj := reached[entries[i].User], reached[entries[j].User]
+		if ri != rj {
+			return ri < rj
+		}
+		return entries[i].User < entries[j].User
+	})
+	return entries, nil
+}
+
+func toEntries(zs []redis.Z) []Entry {
+	out := make([]Entry, 0, len(zs))
+	for _, z := range zs {
+		member, _ := z.Member.(string)
+		out = append(out, Entry{User: core.UserID(member), Score: int64(z.Score)})
+	}
+	return out
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+var (
+	_ Board        = (*RedisBoard)(nil)
+	_ Distribution = (*RedisBoard)(nil)
+)
@@ -1,37 +1,246 @@
 package leaderboard
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
+
+	"gamifykit/core"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// skipIfNoRedis returns a client on the test DB, or skips the test if Redis is not available
+func skipIfNoRedis(t *testing.T) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 15})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skip("Redis not available, skipping test:", err)
+		return nil
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestBoard creates a board on a fresh key that is removed after the test
+func newTestBoard(t *testing.T, client *redis.Client, opts ...RedisOption) *RedisBoard {
+	key := "test:leaderboard:" + t.Name()
+	client.Del(context.Background(), key, key+":reached")
+	t.Cleanup(func() { client.Del(context.Background(), key, key+":reached") })
+	return NewRedisBoard(client, key, opts...)
+}
+
+// steppedClock returns a clock that advances one second per call
+func steppedClock() func() time.Time {
+	now := time.Unix(1700000000, 0)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func users(entries []Entry) []core.UserID {
+	out := make([]core.UserID, len(entries))
+	for i, e := range entries {
+		out[i] = e.User
+	}
+	return out
+}
+
 func TestRedisBoard_Update(t *testing.T) {
-	// Skip - Redis leaderboard implementation is not yet complete (Phase 2.2)
-	t.Skip("Redis leaderboard implementation is not yet complete")
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client)
+
+	board.Update("alice", 100)
+	board.Update("alice", 150)
 
-	// TODO: Implement when Phase 2.2 is reached
-	// This test will be completed when the Redis leaderboard is implemented
+	entry, ok := board.Get("alice")
+	require.True(t, ok)
+	assert.Equal(t, int64(150), entry.Score)
+	assert.Equal(t, 1, board.Count())
 }
 
 func TestRedisBoard_TopN(t *testing.T) {
-	// Skip - Redis leaderboard implementation is not yet complete (Phase 2.2)
-	t.Skip("Redis leaderboard implementation is not yet complete")
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client)
 
-	// TODO: Implement when Phase 2.2 is reached
-	// This test will be completed when the Redis leaderboard is implemented
+	board.Update("a", 10)
+	board.Update("b", 30)
+	board.Update("c", 20)
+
+	assert.Equal(t, []core.UserID{"b", "c"}, users(board.TopN(2)))
+	assert.Len(t, board.TopN(10), 3)
+	assert.Nil(t, board.TopN(0))
 }
 
 func TestRedisBoard_Remove(t *testing.T) {
-	// Skip - Redis leaderboard implementation is not yet complete (Phase 2.2)
-	t.Skip("Redis leaderboard implementation is not yet complete")
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client, WithTimeTiebreak())
+
+	board.Update("alice", 100)
+	board.Remove("alice")
 
-	// TODO: Implement when Phase 2.2 is reached
-	// This test will be completed when the Redis leaderboard is implemented
+	_, ok := board.Get("alice")
+	assert.False(t, ok)
+	_, ok = board.Rank("alice")
+	assert.False(t, ok)
 }
 
 func TestRedisBoard_Get(t *testing.T) {
-	// Skip - Redis leaderboard implementation is not yet complete (Phase 2.2)
-	t.Skip("Redis leaderboard implementation is not yet complete")
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client)
+
+	_, ok := board.Get("nobody")
+	assert.False(t, ok)
+
+	board.Update("alice", -5)
+	entry, ok := board.Get("alice")
+	require.True(t, ok)
+	assert.Equal(t, Entry{User: "alice", Score: -5}, entry)
+}
+
+func TestRedisBoard_RankAround(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client)
+
+	for i, u := range []core.UserID{"a", "b", "c", "d", "e"} {
+		board.Update(u, int64(50-i*10))
+	}
+
+	rank, ok := board.Rank("c")
+	require.True(t, ok)
+	assert.Equal(t, 3, rank)
+	assert.Equal(t, []core.UserID{"b", "c", "d"}, users(board.Around("c", 1)))
+	assert.Equal(t, []core.UserID{"a", "b", "c"}, users(board.Around("a", 2)))
+}
+
+func TestRedisBoard_TimeTiebreak(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	board := newTestBoard(t, client, WithTimeTiebreak(), WithClock(steppedClock()))
+
+	// Lexicographic order would put "zed" first among the ties; reach time must win instead
+	board.Update("top", 200)
+	board.Update("amy", 100)
+	board.Update("zed", 100)
+	board.Update("bob", 100)
+	board.Update("low", 50)
+
+	// Resubmitting the same score keeps the original reach time
+	board.Update("amy", 100)
+
+	assert.Equal(t, []core.UserID{"top", "amy", "zed", "bob", "low"}, users(board.TopN(5)))
+	assert.Equal(t, []core.UserID{"top", "amy", "zed"}, users(board.TopN(3)))
+
+	for want, u := range []core.UserID{"top", "amy", "zed", "bob", "low"} {
+		rank, ok := board.Rank(u)
+		require.True(t, ok)
+		assert.Equal(t, want+1, rank, "rank of %s", u)
+	}
+
+	assert.Equal(t, []core.UserID{"amy", "zed", "bob"}, users(board.Around("zed", 1)))
+	assert.Equal(t, []core.UserID{"bob", "low"}, users(board.Around("low", 1)))
+
+	// Reaching a new score moves the user to the back of that score's group
+	board.Update("amy", 99)
+	board.Update("amy", 100)
+	assert.Equal(t, []core.UserID{"top", "zed", "bob", "amy"}, users(board.TopN(4)))
+}
+
+func TestRedisBoard_ClusterReachedKey(t *testing.T) {
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:7000"}})
+	defer cluster.Close()
+
+	// the tag makes Redis hash "game:xp", the same slot as the board key itself
+	assert.Equal(t, "{game:xp}:reached", NewRedisBoard(cluster, "game:xp").reachedKey())
+	assert.Equal(t, "game:{xp}:2024-W10:reached", NewRedisBoard(cluster, "game:{xp}:2024-W10").reachedKey())
+
+	standalone := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer standalone.Close()
+	assert.Equal(t, "game:xp:reached", NewRedisBoard(standalone, "game:xp").reachedKey())
+}
+
+func TestRedisBoard_KeyPrefix(t *testing.T) {
+	standalone := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer standalone.Close()
+	b := NewRedisBoard(standalone, "leaderboard:xp", WithKeyPrefix("gamifykit:prod:"))
+	assert.Equal(t, "gamifykit:prod:leaderboard:xp", b.key)
+	assert.Equal(t, "gamifykit:prod:leaderboard:xp:reached", b.reachedKey())
+}
+
+func TestRedisBoard_Percentiles(t *testing.T) {
+	for name, opts := range map[string][]RedisOption{"plain": nil, "tiebreak": {WithTimeTiebreak(), WithClock(steppedClock())}} {
+		t.Run(name, func(t *testing.T) {
+			board := newTestBoard(t, skipIfNoRedis(t), opts...)
+			_, err := board.Percentile("u1")
+			assert.ErrorIs(t, err, ErrEmptyBoard)
+			_, err = board.ScoreAtPercentile(0.5)
+			assert.ErrorIs(t, err, ErrEmptyBoard)
+
+			for i := 1; i <= 10; i++ {
+				board.Update(core.UserID(fmt.Sprintf("u%d", i)), int64(i*10))
+			}
+			for user, want := range map[core.UserID]float64{"u10": 1, "u6": 0.6, "u1": 0.1} {
+				got, err := board.Percentile(user)
+				require.NoError(t, err)
+				assert.InDelta(t, want, got, 1e-9, "percentile of %s", user)
+			}
+			for p, want := range map[float64]int64{1: 100, 0.9: 90, 0.55: 60, 0: 10} {
+				got, err := board.ScoreAtPercentile(p)
+				require.NoError(t, err)
+				assert.Equal(t, want, got, "score at %v", p)
+			}
+			_, err = board.Percentile("missing")
+			assert.ErrorIs(t, err, ErrNotOnBoard)
+			_, err = board.ScoreAtPercentile(-0.1)
+			assert.ErrorIs(t, err, ErrInvalidPercentile)
+		})
+	}
+}
 
-	// TODO: Implement when Phase 2.2 is reached
-	// This test will be completed when the Redis leaderboard is implemented
+func TestRedisBoard_Aggregation(t *testing.T) {
+	client := skipIfNoRedis(t)
+	if client == nil {
+		return
+	}
+	for _, tiebreak := range []bool{false, true} {
+		for agg, want := range map[Aggregation]int64{AggregateSet: 20, AggregateMax: 50, AggregateSum: 100} {
+			t.Run(fmt.Sprintf("%s/tiebreak=%t", agg.scriptMode(), tiebreak), func(t *testing.T) {
+				opts := []RedisOption{WithAggregation(agg)}
+				if tiebreak {
+					opts = append(opts, WithTimeTiebreak(), WithClock(steppedClock()))
+				}
+				board := newTestBoard(t, client, opts...)
+				for _, score := range []int64{30, 50, 20} {
+					board.Update("alice", score)
+				}
+				entry, ok := board.Get("alice")
+				require.True(t, ok)
+				assert.Equal(t, want, entry.Score)
+			})
+		}
+	}
 }
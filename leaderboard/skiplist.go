@@ -23,13 +23,20 @@ type SkipList struct {
 	head   *node
 	lvl    int
 	byUser map[core.UserID]*node
+	agg    Aggregation
 }
 
 func NewSkipList() *SkipList {
+	return NewSkipListWithAggregation(AggregateSet)
+}
+
+// NewSkipListWithAggregation creates an in-memory board whose Update combines scores per agg.
+func NewSkipListWithAggregation(agg Aggregation) *SkipList {
 	return &SkipList{
 		head:   &node{},
 		lvl:    1,
 		byUser: map[core.UserID]*node{},
+		agg:    agg,
 	}
 }
 
@@ -58,11 +65,16 @@ func less(a, b Entry) bool {
 	return a.Score > b.Score // higher score first
 }
 
-// Update inserts or moves user to new score.
+// Update inserts user or moves them to the score combined per the board's Aggregation.
 func (s *SkipList) Update(user core.UserID, score int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if old, ok := s.byUser[user]; ok {
+	old, had := s.byUser[user]
+	if had {
+		score = s.agg.aggregate(old.e.Score, true, score)
+		if score == old.e.Score {
+			return
+		}
 		// remove old node
 		s.removeLocked(user, old.e)
 	}
@@ -125,7 +137,7 @@ func (s *SkipList) Remove(user core.UserID) {
 func (s *SkipList) TopN(n int) []Entry {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	if n <= 0 {
+	if n = min(pageSize(n), len(s.byUser)); n <= 0 {
 		return nil
 	}
 	out := make([]Entry, 0, n)
@@ -146,4 +158,85 @@ func (s *SkipList) Get(user core.UserID) (Entry, bool) {
 	return Entry{}, false
 }
 
-var _ Board = (*SkipList)(nil)
+// Count returns the number of users on the board.
+func (s *SkipList) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byUser)
+}
+
+// Rank returns the user's 1-based position. It walks the bottom level, so it is O(n).
+func (s *SkipList) Rank(user core.UserID) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rankLocked(user)
+}
+
+func (s *SkipList) rankLocked(user core.UserID) (int, bool) {
+	if _, ok := s.byUser[user]; !ok {
+		return 0, false
+	}
+	rank := 1
+	for cur := s.head.next[0]; cur != nil; cur = cur.next[0] {
+		if cur.e.User == user {
+			return rank, true
+		}
+		rank++
+	}
+	return 0, false
+}
+
+// Around returns up to radius entries on each side of user, including user.
+func (s *SkipList) Around(user core.UserID, radius int) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rank, ok := s.rankLocked(user)
+	if !ok || radius < 0 {
+		return nil
+	}
+	radius = aroundRadius(radius)
+	first, last := rank-radius, rank+radius
+	var out []Entry
+	pos := 1
+	for cur := s.head.next[0]; cur != nil && pos <= last; cur = cur.next[0] {
+		if pos >= first {
+			out = append(out, cur.e)
+		}
+		pos++
+	}
+	return out
+}
+
+// Percentile returns 1 - rank/count for the user's 0-based rank; see Distribution.
+func (s *SkipList) Percentile(user core.UserID) (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.byUser) == 0 {
+		return 0, ErrEmptyBoard
+	}
+	rank, ok := s.rankLocked(user)
+	if !ok {
+		return 0, ErrNotOnBoard
+	}
+	return percentile(int64(rank-1), int64(len(s.byUser))), nil
+}
+
+// ScoreAtPercentile returns the lowest score reaching percentile p; see Distribution.
+func (s *SkipList) ScoreAtPercentile(p float64) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, err := percentileIndex(p, int64(len(s.byUser)))
+	if err != nil {
+		return 0, err
+	}
+	cur := s.head.next[0]
+	for i := int64(0); i < idx; i++ {
+		cur = cur.next[0]
+	}
+	return cur.e.Score, nil
+}
+
+var (
+	_ Board        = (*SkipList)(nil)
+	_ Distribution = (*SkipList)(nil)
+)
@@ -1,6 +1,8 @@
 package leaderboard
 
 import (
+    "fmt"
+    "math"
     "testing"
     "gamifykit/core"
 )
@@ -20,3 +22,66 @@ func TestSkipListBasic(t *testing.T) {
 }
 
 
+
+func TestSkipListRankAround(t *testing.T) {
+    s := NewSkipList()
+    for i, u := range []core.UserID{"a", "b", "c", "d", "e"} { s.Update(u, int64(50-i*10)) }
+    if r, ok := s.Rank("c"); !ok || r != 3 { t.Fatalf("rank of c: %d %v", r, ok) }
+    if _, ok := s.Rank("missing"); ok { t.Fatal("missing user should not be ranked") }
+    around := s.Around("b", 1)
+    if len(around) != 3 || around[0].User != "a" || around[2].User != "c" { t.Fatalf("unexpected around: %#v", around) }
+    if around = s.Around("a", 2); len(around) != 3 || around[0].User != "a" { t.Fatalf("around at top: %#v", around) }
+}
+
+func TestSkipListPercentiles(t *testing.T) {
+    s := NewSkipList()
+    if _, err := s.Percentile("a"); err != ErrEmptyBoard { t.Fatalf("empty board: %v", err) }
+    if _, err := s.ScoreAtPercentile(0.5); err != ErrEmptyBoard { t.Fatalf("empty board: %v", err) }
+    // u1..u10 score 10..100
+    for i := 1; i <= 10; i++ { s.Update(core.UserID(fmt.Sprintf("u%d", i)), int64(i*10)) }
+
+    for user, want := range map[core.UserID]float64{"u10": 1, "u6": 0.6, "u1": 0.1} {
+        got, err := s.Percentile(user)
+        if err != nil || math.Abs(got-want) > 1e-9 { t.Fatalf("percentile of %s: want %v got %v %v", user, want, got, err) }
+        score, _ := s.ScoreAtPercentile(got)
+        if e, _ := s.Get(user); score != e.Score { t.Fatalf("cutoff at %s's percentile should be their score %d, got %d", user, e.Score, score) }
+    }
+    for p, want := range map[float64]int64{1: 100, 0.95: 100, 0.9: 90, 0.55: 60, 0.5: 50, 0: 10} {
+        if got, err := s.ScoreAtPercentile(p); err != nil || got != want { t.Fatalf("score at %v: want %d got %d %v", p, want, got, err) }
+    }
+    if _, err := s.Percentile("missing"); err != ErrNotOnBoard { t.Fatalf("missing user: %v", err) }
+    if _, err := s.ScoreAtPercentile(1.5); err != ErrInvalidPercentile { t.Fatalf("p > 1: %v", err) }
+}
+
+func TestSkipListAggregation(t *testing.T) {
+    for _, tc := range []struct {
+        agg  Aggregation
+        want int64
+    }{{AggregateSet, 20}, {AggregateMax, 50}, {AggregateSum, 100}} {
+        s := NewSkipListWithAggregation(tc.agg)
+        for _, score := range []int64{30, 50, 20} { s.Update("a", score) }
+        s.Update("b", 40)
+        if e, _ := s.Get("a"); e.Score != tc.want { t.Fatalf("aggregation %d: want %d, got %d", tc.agg, tc.want, e.Score) }
+        wantTop := core.UserID("a")
+        if tc.agg == AggregateSet { wantTop = "b" }
+        if top := s.TopN(1); top[0].User != wantTop { t.Fatalf("aggregation %d: want %s on top, got %#v", tc.agg, wantTop, top) }
+    }
+    s := NewSkipListWithAggregation(AggregateSum)
+    s.Update("a", math.MaxInt64)
+    s.Update("a", 1)
+    if e, _ := s.Get("a"); e.Score != math.MaxInt64 { t.Fatalf("overflowing sums should keep the total, got %d", e.Score) }
+}
+
+func TestSkipListPageBounds(t *testing.T) {
+    s := NewSkipList()
+    for i := 0; i < MaxPageSize+10; i++ { s.Update(core.UserID(fmt.Sprintf("u%d", i)), int64(i)) }
+    if got := len(s.TopN(MaxPageSize)); got != MaxPageSize { t.Fatalf("TopN at the cap: got %d entries", got) }
+    if got := len(s.TopN(math.MaxInt)); got != MaxPageSize { t.Fatalf("TopN over the cap: got %d entries", got) }
+    if got := s.TopN(0); got != nil { t.Fatalf("TopN(0) should be empty, got %d", len(got)) }
+    if got := s.TopN(-5); got != nil { t.Fatalf("TopN(-5) should be empty, got %d", len(got)) }
+    if got := len(s.Around("u500", math.MaxInt)); got > MaxPageSize || got == 0 { t.Fatalf("Around with a huge radius: got %d entries", got) }
+
+    small := NewSkipList()
+    small.Update("a", 1)
+    if got := len(small.TopN(math.MaxInt)); got != 1 { t.Fatalf("TopN on a small board: got %d entries", got) }
+}
@@ -0,0 +1,213 @@
+package leaderboard
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+)
+
+// TopNOption configures a TopNWatcher.
+type TopNOption func(*TopNWatcher)
+
+// WithTopNName sets the board name carried by the watcher's events, e.g. "xp".
+func WithTopNName(name string) TopNOption {
+	return func(w *TopNWatcher) { w.name = name }
+}
+
+// WithTopNDebounce holds every membership change for d before notifying it. A user who enters and
+// leaves again (or leaves and comes back) within d is not notified at all, so two users trading
+// places at the boundary do not flood each other with notifications.
+func WithTopNDebounce(d time.Duration) TopNOption {
+	return func(w *TopNWatcher) {
+		if d > 0 {
+			w.debounce = d
+		}
+	}
+}
+
+// TopNWatcher wraps a board and reports users crossing into or out of its top n, e.g. to send
+// "you entered the top 10!" notifications. After every Update or Remove through the watcher it
+// reads the top n and compares it with the previous members: each newcomer gets a
+// core.EventEnteredTopN and each user pushed out a core.EventLeftTopN, paired with each other in
+// rank order when both happen at once (users removed from the board displace nobody). The events
+// go to the function set with Notify, usually the service's Publish, so they reach WebSocket
+// clients through the realtime bridge like any event.
+//
+// Writes through the watcher are serialized and each one costs a TopN read, which matters for
+// boards behind a network such as RedisBoard. Writes made to the board directly, e.g. by other
+// server instances, are noticed with the next write through the watcher.
+type TopNWatcher struct {
+	board    Board
+	n        int
+	name     string
+	debounce time.Duration
+
+	mu      sync.Mutex
+	members []core.UserID // in rank order
+	pending map[core.UserID]*pendingChange
+	notify  func(core.Event)
+}
+
+// pendingChange is a membership change held back for the debounce interval
+type pendingChange struct {
+	event core.Event
+	timer *time.Timer
+}
+
+// NewTopNWatcher watches the top n (at least 1, at most MaxPageSize) of board. Its current
+// members are taken as the starting point and are not notified.
+func NewTopNWatcher(board Board, n int, opts ...TopNOption) *TopNWatcher {
+	w := &TopNWatcher{board: board, n: pageSize(max(n, 1)), pending: map[core.UserID]*pendingChange{}}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.members = w.top()
+	return w
+}
+
+// Notify sets the function receiving membership events, e.g.
+// func(e core.Event) { svc.Publish(context.Background(), e) }. It is called outside the watcher's
+// lock, so it may write to the board again.
+func (w *TopNWatcher) Notify(fn func(core.Event)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.notify = fn
+}
+
+// top reads the current members of the top n in rank order
+func (w *TopNWatcher) top() []core.UserID {
+	entries := w.board.TopN(w.n)
+	members := make([]core.UserID, len(entries))
+	for i, e := range entries {
+		members[i] = e.User
+	}
+	return members
+}
+
+// Update submits the score to the board and reports the membership changes it caused.
+func (w *TopNWatcher) Update(user core.UserID, score int64) {
+	w.write(func() { w.board.Update(user, score) })
+}
+
+// Remove removes the user from the board and reports the membership changes it caused.
+func (w *TopNWatcher) Remove(user core.UserID) {
+	w.write(func() { w.board.Remove(user) })
+}
+
+// write applies fn to the board and notifies the resulting membership changes
+func (w *TopNWatcher) write(fn func()) {
+	w.mu.Lock()
+	fn()
+	after := w.top()
+	var entered, left, displaced []core.UserID
+	for _, user := range after {
+		if !slices.Contains(w.members, user) {
+			entered = append(entered, user)
+		}
+	}
+	// compared with the previous read, so writes made to the board directly count too
+	for _, user := range w.members {
+		if !slices.Contains(after, user) {
+			left = append(left, user)
+			// users removed from the board left on their own
+			if _, ok := w.board.Get(user); ok {
+				displaced = append(displaced, user)
+			}
+		}
+	}
+	w.members = after
+	var events []core.Event
+	for i, user := range entered {
+		events = append(events, core.NewEnteredTopN(user, w.name, w.n, userAt(displaced, i)))
+	}
+	for _, user := range left {
+		var by core.UserID
+		if i := slices.Index(displaced, user); i >= 0 {
+			by = userAt(entered, i)
+		}
+		events = append(events, core.NewLeftTopN(user, w.name, w.n, by))
+	}
+	events = w.hold(events)
+	notify := w.notify
+	w.mu.Unlock()
+	if notify == nil {
+		return
+	}
+	for _, e := range events {
+		notify(e)
+	}
+}
+
+// hold debounces events, returning those to notify right away; the caller holds w.mu
+func (w *TopNWatcher) hold(events []core.Event) []core.Event {
+	if w.debounce <= 0 {
+		return events
+	}
+	for _, e := range events {
+		if p, ok := w.pending[e.UserID]; ok {
+			// the user is back where they were before the pending change
+			p.timer.Stop()
+			delete(w.pending, e.UserID)
+			continue
+		}
+		p := &pendingChange{event: e}
+		p.timer = time.AfterFunc(w.debounce, func() { w.release(p) })
+		w.pending[e.UserID] = p
+	}
+	return nil
+}
+
+// release notifies a change once it has held for the debounce interval
+func (w *TopNWatcher) release(p *pendingChange) {
+	w.mu.Lock()
+	current, ok := w.pending[p.event.UserID]
+	if ok && current == p {
+		delete(w.pending, p.event.UserID)
+	}
+	notify := w.notify
+	w.mu.Unlock()
+	if ok && current == p && notify != nil {
+		notify(p.event)
+	}
+}
+
+// userAt returns users[i], or "" when there are fewer users
+func userAt(users []core.UserID, i int) core.UserID {
+	if i < len(users) {
+		return users[i]
+	}
+	return ""
+}
+
+func (w *TopNWatcher) TopN(n int) []Entry                 { return w.board.TopN(n) }
+func (w *TopNWatcher) Get(user core.UserID) (Entry, bool) { return w.board.Get(user) }
+func (w *TopNWatcher) Rank(user core.UserID) (int, bool)  { return w.board.Rank(user) }
+func (w *TopNWatcher) Around(user core.UserID, radius int) []Entry {
+	return w.board.Around(user, radius)
+}
+
+// Percentile returns errors.ErrUnsupported if the board does not implement Distribution.
+func (w *TopNWatcher) Percentile(user core.UserID) (float64, error) {
+	d, ok := w.board.(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.Percentile(user)
+}
+
+// ScoreAtPercentile returns errors.ErrUnsupported if the board does not implement Distribution.
+func (w *TopNWatcher) ScoreAtPercentile(p float64) (int64, error) {
+	d, ok := w.board.(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.ScoreAtPercentile(p)
+}
+
+var (
+	_ Board        = (*TopNWatcher)(nil)
+	_ Distribution = (*TopNWatcher)(nil)
+)
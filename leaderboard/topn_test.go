@@ -0,0 +1,104 @@
+package leaderboard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gamifykit/core"
+)
+
+// eventRecorder collects the events a TopNWatcher notifies
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []core.Event
+}
+
+func (r *eventRecorder) notify(e core.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) take() []core.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.events
+	r.events = nil
+	return out
+}
+
+func TestTopNWatcherReportsMembershipChanges(t *testing.T) {
+	board := NewSkipList()
+	for i := 1; i <= 3; i++ {
+		board.Update(userID(i), int64(i*10))
+	}
+	w := NewTopNWatcher(board, 2, WithTopNName("xp"))
+	var rec eventRecorder
+	w.Notify(rec.notify)
+
+	// moves within the top and below it are not membership changes
+	w.Update(userID(3), 100)
+	w.Update(userID(4), 5)
+	if events := rec.take(); len(events) != 0 {
+		t.Fatalf("events without a membership change: %+v", events)
+	}
+
+	w.Update(userID(1), 50)
+	events := rec.take()
+	if len(events) != 2 {
+		t.Fatalf("want an entered and a left event, got %+v", events)
+	}
+	entered, left := events[0], events[1]
+	if entered.Type != core.EventEnteredTopN || entered.UserID != userID(1) || entered.Metadata["displaced"] != string(userID(2)) ||
+		entered.Metadata["n"] != 2 || entered.Metadata["board"] != "xp" {
+		t.Fatalf("entered = %+v", entered)
+	}
+	if left.Type != core.EventLeftTopN || left.UserID != userID(2) || left.Metadata["displaced_by"] != string(userID(1)) {
+		t.Fatalf("left = %+v", left)
+	}
+
+	// removing a member lets the next user in without displacing anyone
+	w.Remove(userID(3))
+	events = rec.take()
+	if len(events) != 2 || events[0].UserID != userID(2) || events[0].Metadata["displaced"] != nil || events[1].UserID != userID(3) {
+		t.Fatalf("after removal: %+v", events)
+	}
+}
+
+func TestTopNWatcherDebouncesChurn(t *testing.T) {
+	board := NewSkipList()
+	board.Update(userID(1), 20)
+	board.Update(userID(2), 10)
+	w := NewTopNWatcher(board, 1, WithTopNDebounce(50*time.Millisecond))
+	var rec eventRecorder
+	w.Notify(rec.notify)
+
+	// user-2 overtakes and falls back right away: nobody hears about it
+	w.Update(userID(2), 30)
+	w.Update(userID(1), 40)
+	// this time user-2 stays ahead
+	w.Update(userID(2), 50)
+	if events := rec.take(); len(events) != 0 {
+		t.Fatalf("events before the debounce interval: %+v", events)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var events []core.Event
+	for len(events) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		events = append(events, rec.take()...)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want the lasting change only, got %+v", events)
+	}
+	for _, e := range events {
+		if (e.Type == core.EventEnteredTopN) != (e.UserID == userID(2)) {
+			t.Fatalf("unexpected event %+v", e)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if events := rec.take(); len(events) != 0 {
+		t.Fatalf("cancelled changes notified later: %+v", events)
+	}
+}
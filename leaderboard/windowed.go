@@ -0,0 +1,289 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gamifykit/core"
+)
+
+var (
+	// ErrArchiveNotFound is returned for periods that were never archived.
+	ErrArchiveNotFound = errors.New("leaderboard archive not found")
+	// ErrPeriodOpen is returned when archiving the period that is still running.
+	ErrPeriodOpen = errors.New("leaderboard period has not ended")
+	// ErrPeriodUnknown is returned when archiving an ended period whose board is no longer held.
+	ErrPeriodUnknown = errors.New("leaderboard period is not held by this board")
+	// ErrNoArchive is returned by archive operations on a board created without WithArchive.
+	ErrNoArchive = errors.New("leaderboard has no archive store")
+)
+
+// Standing is one position in archived standings.
+type Standing struct {
+	Rank  int         `json:"rank"`
+	User  core.UserID `json:"user"`
+	Score int64       `json:"score"`
+}
+
+// ArchiveStore keeps the final standings of ended leaderboard periods.
+type ArchiveStore interface {
+	// SaveArchive stores the standings of board for period. Saving a period that is already
+	// archived is a no-op, so the first (final) snapshot wins.
+	SaveArchive(ctx context.Context, board, period string, standings []Standing) error
+	// LoadArchive returns the archived standings, best first, or ErrArchiveNotFound. A period that
+	// was archived with no standings returns an empty list, not ErrArchiveNotFound.
+	LoadArchive(ctx context.Context, board, period string) ([]Standing, error)
+}
+
+// MemoryArchive is an in-process ArchiveStore, for tests and single-instance deployments.
+type MemoryArchive struct {
+	mu       sync.Mutex
+	archives map[string][]Standing
+}
+
+// NewMemoryArchive returns an empty MemoryArchive.
+func NewMemoryArchive() *MemoryArchive {
+	return &MemoryArchive{archives: map[string][]Standing{}}
+}
+
+func (m *MemoryArchive) SaveArchive(_ context.Context, board, period string, standings []Standing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := board + "\x00" + period
+	if _, ok := m.archives[key]; !ok {
+		m.archives[key] = append([]Standing{}, standings...)
+	}
+	return nil
+}
+
+func (m *MemoryArchive) LoadArchive(_ context.Context, board, period string) ([]Standing, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.archives[board+"\x00"+period]
+	if !ok {
+		return nil, ErrArchiveNotFound
+	}
+	return append([]Standing{}, s...), nil
+}
+
+// PeriodFunc names the period a time falls in; a change of name is a rollover.
+type PeriodFunc func(time.Time) string
+
+// Daily names UTC days, e.g. "2024-03-09".
+func Daily(t time.Time) string { return t.UTC().Format("2006-01-02") }
+
+// Weekly names ISO weeks, e.g. "2024-W10".
+func Weekly(t time.Time) string {
+	year, week := t.UTC().ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// Monthly names UTC months, e.g. "2024-03".
+func Monthly(t time.Time) string { return t.UTC().Format("2006-01") }
+
+// WindowedBoard is a Board that starts afresh every period (day, week, ...). When a period ends,
+// its final TopN is archived (see WithArchive) before the board is dropped, and past standings
+// can be read back with GetArchive.
+//
+// Rollover happens on the first operation in a new period, or when Rotate is called; run Rotate
+// on a timer so quiet boards are archived on time too. A rollover seen by an operation archives
+// the ended period in the background; the operation already uses the new period. Update sets the score for the current
+// period, so feed it period scores rather than all-time totals.
+type WindowedBoard struct {
+	name     string
+	period   PeriodFunc
+	newBoard func(period string) Board
+	archive  ArchiveStore
+	depth    int
+	now      func() time.Time
+	onError  func(period string, err error)
+
+	mu      sync.Mutex
+	current string
+	board   Board
+	// pending holds ended periods whose archival failed, so Archive can retry them
+	pending map[string]Board
+}
+
+// WindowOption configures a WindowedBoard.
+type WindowOption func(*WindowedBoard)
+
+// WithArchive archives the top depth entries (100 if depth <= 0) of every ended period to store.
+func WithArchive(store ArchiveStore, depth int) WindowOption {
+	return func(w *WindowedBoard) {
+		if depth <= 0 {
+			depth = 100
+		}
+		w.archive, w.depth = store, depth
+	}
+}
+
+// WithPeriodClock overrides the clock used to detect rollovers (core.CurrentTime by default;
+// useful for tests).
+func WithPeriodClock(now func() time.Time) WindowOption {
+	return func(w *WindowedBoard) { w.now = now }
+}
+
+// WithArchiveErrorHandler is called when archiving at rollover fails, possibly from a background
+// goroutine. The ended period is kept so Rotate or Archive can retry it.
+func WithArchiveErrorHandler(fn func(period string, err error)) WindowOption {
+	return func(w *WindowedBoard) { w.onError = fn }
+}
+
+// NewWindowedBoard creates a board named name (the archive key) whose periods are named by period.
+// newBoard creates the board for each period, e.g. a Redis sorted set per period:
+//
+//	func(p string) leaderboard.Board { return leaderboard.NewRedisBoard(client, "game:xp:"+p) }
+func NewWindowedBoard(name string, period PeriodFunc, newBoard func(period string) Board, opts ...WindowOption) *WindowedBoard {
+	w := &WindowedBoard{name: name, period: period, newBoard: newBoard, now: core.CurrentTime, onError: func(string, error) {}, pending: map[string]Board{}}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.current = period(w.now())
+	w.board = newBoard(w.current)
+	return w
+}
+
+// Period returns the name of the running period.
+func (w *WindowedBoard) Period() string {
+	w.active()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// active returns the board for the running period, rolling over first if the period changed.
+// The ended period is archived in the background so readers and writers never wait on the archive.
+func (w *WindowedBoard) active() Board {
+	b, ended, rolled := w.roll()
+	if rolled && w.archive != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := w.Archive(ctx, ended); err != nil {
+				w.onError(ended, err)
+			}
+		}()
+	}
+	return b
+}
+
+// roll swaps in the board of the running period and queues the ended one for archival
+func (w *WindowedBoard) roll() (b Board, ended string, rolled bool) {
+	p := w.period(w.now())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p == w.current {
+		return w.board, "", false
+	}
+	ended, endedBoard := w.current, w.board
+	w.current, w.board = p, w.newBoard(p)
+	if w.archive != nil {
+		w.pending[ended] = endedBoard
+	}
+	return w.board, ended, true
+}
+
+// Rotate rolls over if the period has changed and archives every ended period still pending,
+// including ones that failed before. Unlike a rollover seen by Update or TopN, it waits for the
+// archive, so run it on a timer for quiet boards and retries.
+func (w *WindowedBoard) Rotate(ctx context.Context) error {
+	_, ended, rolled := w.roll()
+	w.mu.Lock()
+	periods := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		periods = append(periods, p)
+	}
+	w.mu.Unlock()
+	var errs []error
+	for _, p := range periods {
+		if err := w.Archive(ctx, p); err != nil {
+			if rolled && p == ended {
+				w.onError(p, err)
+			}
+			errs = append(errs, fmt.Errorf("period %s: %w", p, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Archive snapshots the final standings of an ended period. Archiving a period twice is a no-op,
+// and a period that ended with no entries is archived as an empty list.
+// The running period fails with ErrPeriodOpen; an ended period that is neither archived nor held
+// fails with ErrPeriodUnknown.
+func (w *WindowedBoard) Archive(ctx context.Context, period string) error {
+	if w.archive == nil {
+		return ErrNoArchive
+	}
+	w.mu.Lock()
+	if period == w.current {
+		w.mu.Unlock()
+		return ErrPeriodOpen
+	}
+	b, ok := w.pending[period]
+	w.mu.Unlock()
+	if !ok {
+		if _, err := w.archive.LoadArchive(ctx, w.name, period); err == nil {
+			return nil
+		}
+		return ErrPeriodUnknown
+	}
+
+	top := b.TopN(w.depth)
+	standings := make([]Standing, len(top))
+	for i, e := range top {
+		standings[i] = Standing{Rank: i + 1, User: e.User, Score: e.Score}
+	}
+	if err := w.archive.SaveArchive(ctx, w.name, period, standings); err != nil {
+		return fmt.Errorf("failed to archive leaderboard %s period %s: %w", w.name, period, err)
+	}
+	w.mu.Lock()
+	delete(w.pending, period)
+	w.mu.Unlock()
+	return nil
+}
+
+// GetArchive returns the archived standings of period, or ErrArchiveNotFound.
+func (w *WindowedBoard) GetArchive(ctx context.Context, period string) ([]Standing, error) {
+	if w.archive == nil {
+		return nil, ErrNoArchive
+	}
+	return w.archive.LoadArchive(ctx, w.name, period)
+}
+
+func (w *WindowedBoard) Update(user core.UserID, score int64) { w.active().Update(user, score) }
+func (w *WindowedBoard) Remove(user core.UserID)              { w.active().Remove(user) }
+func (w *WindowedBoard) TopN(n int) []Entry                   { return w.active().TopN(n) }
+func (w *WindowedBoard) Get(user core.UserID) (Entry, bool)   { return w.active().Get(user) }
+func (w *WindowedBoard) Rank(user core.UserID) (int, bool)    { return w.active().Rank(user) }
+func (w *WindowedBoard) Around(user core.UserID, radius int) []Entry {
+	return w.active().Around(user, radius)
+}
+
+// Percentile answers for the current period. It returns errors.ErrUnsupported if the period
+// boards do not implement Distribution.
+func (w *WindowedBoard) Percentile(user core.UserID) (float64, error) {
+	d, ok := w.active().(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.Percentile(user)
+}
+
+// ScoreAtPercentile answers for the current period; see Percentile.
+func (w *WindowedBoard) ScoreAtPercentile(p float64) (int64, error) {
+	d, ok := w.active().(Distribution)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	return d.ScoreAtPercentile(p)
+}
+
+var (
+	_ Board        = (*WindowedBoard)(nil)
+	_ Distribution = (*WindowedBoard)(nil)
+	_ ArchiveStore = (*MemoryArchive)(nil)
+)
@@ -0,0 +1,159 @@
+package leaderboard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingArchive fails saves until ok is set
+type failingArchive struct {
+	*MemoryArchive
+	ok bool
+}
+
+func (f *failingArchive) SaveArchive(ctx context.Context, board, period string, s []Standing) error {
+	if !f.ok {
+		return errors.New("database down")
+	}
+	return f.MemoryArchive.SaveArchive(ctx, board, period, s)
+}
+
+func TestWindowedBoardArchivesAtRollover(t *testing.T) {
+	now := time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC)
+	archive := NewMemoryArchive()
+	b := NewWindowedBoard("xp-daily", Daily, func(string) Board { return NewSkipList() },
+		WithArchive(archive, 2), WithPeriodClock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	b.Update("alice", 30)
+	b.Update("bob", 50)
+	b.Update("carol", 10)
+	if err := b.Archive(ctx, "2024-03-09"); !errors.Is(err, ErrPeriodOpen) {
+		t.Fatalf("running period must not be archived, got %v", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if top := b.TopN(10); len(top) != 0 {
+		t.Fatalf("new period should start empty, got %v", top)
+	}
+	if p := b.Period(); p != "2024-03-10" {
+		t.Fatalf("period = %s", p)
+	}
+	// the rollover archives in the background; Rotate waits for it
+	if err := b.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetArchive(ctx, "2024-03-09")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Standing{{Rank: 1, User: "bob", Score: 50}, {Rank: 2, User: "alice", Score: 30}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("archive = %v, want %v", got, want)
+	}
+	if err := b.Archive(ctx, "2024-03-09"); err != nil {
+		t.Fatalf("archiving twice should be a no-op, got %v", err)
+	}
+	if _, err := b.GetArchive(ctx, "2024-01-01"); !errors.Is(err, ErrArchiveNotFound) {
+		t.Fatalf("want ErrArchiveNotFound, got %v", err)
+	}
+	if err := b.Archive(ctx, "2024-01-01"); !errors.Is(err, ErrPeriodUnknown) {
+		t.Fatalf("want ErrPeriodUnknown, got %v", err)
+	}
+}
+
+func TestWindowedBoardRetriesFailedArchive(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	archive := &failingArchive{MemoryArchive: NewMemoryArchive()}
+	var failed []string
+	b := NewWindowedBoard("xp-weekly", Weekly, func(string) Board { return NewSkipList() },
+		WithArchive(archive, 0), WithPeriodClock(func() time.Time { return now }),
+		WithArchiveErrorHandler(func(p string, err error) { failed = append(failed, p) }))
+	ctx := context.Background()
+
+	b.Update("alice", 5)
+	now = now.AddDate(0, 0, 7)
+	if err := b.Rotate(ctx); err == nil {
+		t.Fatal("rotate should report the failed archive")
+	}
+	if len(failed) != 1 || failed[0] != "2024-W10" {
+		t.Fatalf("error handler calls = %v", failed)
+	}
+	archive.ok = true
+	if err := b.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.GetArchive(ctx, "2024-W10"); err != nil || len(got) != 1 || got[0].User != "alice" {
+		t.Fatalf("retried archive = %v, %v", got, err)
+	}
+}
+
+// blockingArchive holds saves until release is closed
+type blockingArchive struct {
+	*MemoryArchive
+	release chan struct{}
+}
+
+func (b *blockingArchive) SaveArchive(ctx context.Context, board, period string, s []Standing) error {
+	<-b.release
+	return b.MemoryArchive.SaveArchive(ctx, board, period, s)
+}
+
+func TestWindowedBoardRolloverDoesNotWaitForArchive(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC)
+	clock := func() time.Time { mu.Lock(); defer mu.Unlock(); return now }
+	archive := &blockingArchive{MemoryArchive: NewMemoryArchive(), release: make(chan struct{})}
+	b := NewWindowedBoard("xp-daily", Daily, func(string) Board { return NewSkipList() },
+		WithArchive(archive, 0), WithPeriodClock(clock))
+	ctx := context.Background()
+
+	b.Update("alice", 30)
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.Update("bob", 5)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Update waited for the archive of the ended period")
+	}
+	if top := b.TopN(10); len(top) != 1 || top[0].User != "bob" {
+		t.Fatalf("new period = %v", top)
+	}
+
+	close(archive.release)
+	if err := b.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.GetArchive(ctx, "2024-03-09"); err != nil || len(got) != 1 || got[0].User != "alice" {
+		t.Fatalf("archive = %v, %v", got, err)
+	}
+}
+
+func TestWindowedBoardArchivesEmptyPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+	b := NewWindowedBoard("xp-daily", Daily, func(string) Board { return NewSkipList() },
+		WithArchive(NewMemoryArchive(), 0), WithPeriodClock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	now = now.AddDate(0, 0, 1)
+	if err := b.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.GetArchive(ctx, "2024-03-09")
+	if err != nil {
+		t.Fatalf("an ended period with no entries should be archived, got %v", err)
+	}
+	if got == nil || len(got) != 0 {
+		t.Fatalf("archive = %#v, want an empty list", got)
+	}
+}
@@ -0,0 +1,142 @@
+// Package logging provides slog helpers shared by the server and adapters, such as redacting
+// user IDs (which may be personal data) before log records are written.
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+
+	"gamifykit/core"
+)
+
+// DefaultUserKeys are the attribute keys treated as user IDs when RedactOptions.Keys is empty.
+var DefaultUserKeys = []string{"user", "user_id", "userID", "users"}
+
+// RedactOptions configures NewRedactingHandler.
+type RedactOptions struct {
+	// Key is the HMAC-SHA256 key used to pseudonymize user IDs. When empty, IDs are truncated
+	// to their first TruncateTo characters instead.
+	Key []byte
+	// TruncateTo is how many leading characters survive truncation (default 2).
+	TruncateTo int
+	// Keys are the attribute keys holding user IDs (DefaultUserKeys when empty). Values of type
+	// core.UserID or []core.UserID are redacted under any key.
+	Keys []string
+}
+
+// NewRedactingHandler wraps next so user IDs never reach it in plaintext. Attributes are rewritten
+// wherever they appear: on the record, in groups, and in attributes added with Logger.With.
+//
+// With a Key, the same ID always maps to the same token, so an operator holding the key can find
+// a user's records with RedactUserID without the logs revealing anyone's ID.
+func NewRedactingHandler(next slog.Handler, opts RedactOptions) slog.Handler {
+	if len(opts.Keys) == 0 {
+		opts.Keys = DefaultUserKeys
+	}
+	if opts.TruncateTo <= 0 {
+		opts.TruncateTo = 2
+	}
+	return &redactingHandler{next: next, opts: opts}
+}
+
+// RedactUserID returns the token NewRedactingHandler logs for id under key, e.g. to search logs
+// for a given user during authorized debugging.
+func RedactUserID(key []byte, id core.UserID) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return "uid:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+type redactingHandler struct {
+	next slog.Handler
+	opts RedactOptions
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.attr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), opts: h.opts}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), opts: h.opts}
+}
+
+// attr redacts a if it holds user IDs, descending into groups
+func (h *redactingHandler) attr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, g := range group {
+			redacted[i] = h.attr(g)
+		}
+		a.Value = slog.GroupValue(redacted...)
+	case slog.KindString:
+		if slices.Contains(h.opts.Keys, a.Key) {
+			a.Value = slog.StringValue(h.redact(a.Value.String()))
+		}
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case core.UserID:
+			a.Value = slog.StringValue(h.redact(string(v)))
+		case []core.UserID:
+			ids := make([]string, len(v))
+			for i, id := range v {
+				ids[i] = h.redact(string(id))
+			}
+			a.Value = slog.AnyValue(ids)
+		case []string:
+			if slices.Contains(h.opts.Keys, a.Key) {
+				ids := make([]string, len(v))
+				for i, id := range v {
+					ids[i] = h.redact(id)
+				}
+				a.Value = slog.AnyValue(ids)
+			}
+		}
+	}
+	return a
+}
+
+// redact pseudonymizes or truncates one user ID
+func (h *redactingHandler) redact(id string) string {
+	return h.opts.Redact(core.UserID(id))
+}
+
+// Redact returns id the way NewRedactingHandler logs it under o, e.g. to redact user IDs recorded
+// elsewhere, such as on tracing spans.
+func (o RedactOptions) Redact(id core.UserID) string {
+	if len(o.Key) > 0 {
+		return RedactUserID(o.Key, id)
+	}
+	truncate := o.TruncateTo
+	if truncate <= 0 {
+		truncate = 2
+	}
+	runes := []rune(string(id))
+	if len(runes) <= truncate {
+		return "***"
+	}
+	return string(runes[:truncate]) + "***"
+}
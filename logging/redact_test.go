@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gamifykit/core"
+)
+
+// logAll emits a user ID through every attribute shape the handler has to cover
+func logAll(logger *slog.Logger, id string) {
+	logger.Info("by key", "user", id, "user_id", id)
+	logger.Info("by type", "subject", core.UserID(id))
+	logger.Info("list", "members", []core.UserID{core.UserID(id), "other-user"})
+	logger.Info("grouped", slog.Group("req", slog.String("userID", id)))
+	logger.With("user", id).Info("with attrs")
+	logger.WithGroup("g").Info("in group", "user", id)
+	logger.Info("lazy", "user", slog.AnyValue(core.UserID(id)))
+}
+
+func TestRedactingHandlerHidesUserIDs(t *testing.T) {
+	const id = "alice.secret@example.com"
+	key := []byte("debug-key")
+	var buf bytes.Buffer
+	logAll(slog.New(NewRedactingHandler(slog.NewJSONHandler(&buf, nil), RedactOptions{Key: key})), id)
+
+	out := buf.String()
+	if strings.Contains(out, id) || strings.Contains(out, "other-user") {
+		t.Fatalf("raw user ID in logs:\n%s", out)
+	}
+	token := RedactUserID(key, id)
+	if got := strings.Count(out, token); got != 8 {
+		t.Fatalf("want token %s 8 times, got %d:\n%s", token, got, out)
+	}
+	if RedactUserID([]byte("other-key"), id) == token {
+		t.Fatal("tokens should depend on the key")
+	}
+}
+
+func TestRedactingHandlerTruncates(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewRedactingHandler(slog.NewTextHandler(&buf, nil), RedactOptions{TruncateTo: 3}))
+	logger.Info("points added", "user", "alice", "metric", "xp")
+	logger.Info("short", "user", "bo")
+
+	out := buf.String()
+	if strings.Contains(out, "alice") || strings.Contains(out, "user=bo ") {
+		t.Fatalf("raw user ID in logs:\n%s", out)
+	}
+	for _, want := range []string{"user=ali***", "metric=xp", "user=***"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestRedactOptionsRedact(t *testing.T) {
+	key := []byte("debug-key")
+	if got := (RedactOptions{Key: key}).Redact("alice"); got != RedactUserID(key, "alice") {
+		t.Fatalf("keyed redaction = %q, want the HMAC token", got)
+	}
+	if got := (RedactOptions{}).Redact("alice"); got != "al***" {
+		t.Fatalf("default truncation = %q, want al***", got)
+	}
+}
@@ -0,0 +1,235 @@
+// Package metrics is a small, dependency-free metrics registry that serves the
+// Prometheus text exposition format, so any Prometheus-compatible scraper can collect it.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the process-wide registry used by the server binary.
+var Default = NewRegistry()
+
+// Registry holds named gauges, counters and histograms.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	help() string
+	kind() string
+	// write appends the metric's sample lines
+	write(w *strings.Builder, name string)
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// Gauge returns the gauge registered under name, creating it on first use.
+// It panics if name is already registered as a different metric type.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	return register(r, name, func() *Gauge { return &Gauge{helpText: help} })
+}
+
+// Counter returns the counter registered under name, creating it on first use.
+// It panics if name is already registered as a different metric type.
+func (r *Registry) Counter(name, help string) *Counter {
+	return register(r, name, func() *Counter { return &Counter{helpText: help} })
+}
+
+// HistogramVec returns the histogram registered under name, creating it on first use. Samples are
+// labelled with labels (in order); buckets are upper bounds in ascending order (DefBuckets if nil).
+// It panics if name is already registered as a different metric type.
+func (r *Registry) HistogramVec(name, help string, labels []string, buckets []float64) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	return register(r, name, func() *HistogramVec {
+		return &HistogramVec{helpText: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+	})
+}
+
+func register[M metric](r *Registry, name string, create func() M) M {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		m, ok := existing.(M)
+		if !ok {
+			panic(fmt.Sprintf("metrics: %q already registered as a %s", name, existing.kind()))
+		}
+		return m
+	}
+	m := create()
+	r.metrics[name] = m
+	return m
+}
+
+// WriteText writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) WriteText(w *strings.Builder) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := r.metrics[name]
+		if h := m.help(); h != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", name, h)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, m.kind())
+		m.write(w, name)
+	}
+	r.mu.RUnlock()
+}
+
+// Handler serves the registry for scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var b strings.Builder
+		r.WriteText(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	helpText string
+	bits     atomic.Uint64
+}
+
+// Set replaces the gauge value.
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Add adjusts the gauge by delta.
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+func (g *Gauge) help() string { return g.helpText }
+func (g *Gauge) kind() string { return "gauge" }
+func (g *Gauge) write(w *strings.Builder, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	helpText string
+	n        atomic.Uint64
+}
+
+// Inc adds one.
+func (c *Counter) Inc() { c.n.Add(1) }
+
+// Add adds n.
+func (c *Counter) Add(n uint64) { c.n.Add(n) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return c.n.Load() }
+
+func (c *Counter) help() string { return c.helpText }
+func (c *Counter) kind() string { return "counter" }
+func (c *Counter) write(w *strings.Builder, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(float64(c.Value())))
+}
+
+// DefBuckets are latency buckets in seconds, from 1ms to 10s.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct {
+	helpText string
+	labels   []string
+	buckets  []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram // by label values joined with \xff
+}
+
+type histogram struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe records v for the given label values, which must match the labels in number.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(labelValues), len(h.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{values: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns how many values were observed for the label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) help() string { return h.helpText }
+func (h *HistogramVec) kind() string { return "histogram" }
+func (h *HistogramVec) write(w *strings.Builder, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		pairs := make([]string, len(h.labels))
+		for i, l := range h.labels {
+			pairs[i] = fmt.Sprintf("%s=%q", l, s.values[i])
+		}
+		labels := strings.Join(pairs, ",")
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(upper), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, s.count)
+	}
+}
+
+func formatFloat(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
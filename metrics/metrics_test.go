@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_TextExposition(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("b_gauge", "A gauge").Set(2.5)
+	r.Counter("a_total", "").Add(3)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "# TYPE a_total counter\na_total 3\n# HELP b_gauge A gauge\n# TYPE b_gauge gauge\nb_gauge 2.5\n", string(body))
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+}
+
+func TestRegistry_SameNameReturnsSameMetric(t *testing.T) {
+	r := NewRegistry()
+	assert.Same(t, r.Gauge("g", ""), r.Gauge("g", ""))
+	assert.Panics(t, func() { r.Counter("g", "") })
+}
+
+func TestGauge_ConcurrentAdd(t *testing.T) {
+	g := NewRegistry().Gauge("g", "")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Add(1)
+			g.Add(-0.5)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 25.0, g.Value())
+}
+
+func TestHistogramVec_TextExposition(t *testing.T) {
+	r := NewRegistry()
+	h := r.HistogramVec("dispatch_seconds", "Dispatch time", []string{"subscriber", "event"}, []float64{0.1, 1})
+	h.Observe(0.05, "webhooks", "points_added")
+	h.Observe(0.5, "webhooks", "points_added")
+	h.Observe(3, "webhooks", "points_added")
+	assert.Same(t, h, r.HistogramVec("dispatch_seconds", "", nil, nil))
+	assert.Equal(t, uint64(3), h.Count("webhooks", "points_added"))
+	assert.Panics(t, func() { h.Observe(1, "webhooks") })
+
+	var b strings.Builder
+	r.WriteText(&b)
+	assert.Equal(t, `# HELP dispatch_seconds Dispatch time
+# TYPE dispatch_seconds histogram
+dispatch_seconds_bucket{subscriber="webhooks",event="points_added",le="0.1"} 1
+dispatch_seconds_bucket{subscriber="webhooks",event="points_added",le="1"} 2
+dispatch_seconds_bucket{subscriber="webhooks",event="points_added",le="+Inf"} 3
+dispatch_seconds_sum{subscriber="webhooks",event="points_added"} 3.55
+dispatch_seconds_count{subscriber="webhooks",event="points_added"} 3
+`, b.String())
+}
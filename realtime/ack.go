@@ -0,0 +1,153 @@
+package realtime
+
+import (
+    "log/slog"
+    "sort"
+    "sync"
+    "time"
+
+    "gamifykit/core"
+)
+
+const (
+    // DefaultAckTimeout is how long a client has to acknowledge an event when AckPolicy.Timeout is zero.
+    DefaultAckTimeout = 10 * time.Second
+    // DefaultAckRedeliveries is how often an unacknowledged event is sent again when
+    // AckPolicy.MaxRedeliveries is zero.
+    DefaultAckRedeliveries = 3
+)
+
+// AckPolicy configures acknowledged delivery. Connections that opt in (ConnOptions.Acks) must
+// acknowledge every event flagged with core.RequireAck by its ID; an event not acknowledged within
+// Timeout is sent again, up to MaxRedeliveries times, and then given up on.
+type AckPolicy struct {
+    Timeout         time.Duration
+    MaxRedeliveries int
+    // OnUnacked is called for every event given up on, including those still pending when the
+    // connection closes; by default they are logged. Use it to dead-letter or count them. It runs
+    // without hub locks held.
+    OnUnacked func(conn ConnInfo, ev core.Event)
+}
+
+// ackState tracks a connection's events awaiting acknowledgement, keyed by event ID
+type ackState struct {
+    mu          sync.Mutex
+    pending     map[string]*pendingAck
+    acked       uint64
+    redelivered uint64
+    failed      uint64
+}
+
+type pendingAck struct {
+    ev           core.Event
+    deadline     time.Time
+    redeliveries int
+}
+
+func newAckState() *ackState { return &ackState{pending: map[string]*pendingAck{}} }
+
+// track starts waiting for ev's acknowledgement until deadline; an event already pending keeps its
+// redelivery count
+func (a *ackState) track(ev core.Event, deadline time.Time) {
+    a.mu.Lock(); defer a.mu.Unlock()
+    if p, ok := a.pending[ev.ID]; ok {
+        p.deadline = deadline
+        return
+    }
+    a.pending[ev.ID] = &pendingAck{ev: ev, deadline: deadline}
+}
+
+func logUnacked(conn ConnInfo, ev core.Event) {
+    slog.Warn("websocket client did not acknowledge event", "conn", conn.ID, "remote", conn.RemoteAddr, "id", ev.ID, "type", ev.Type, "user", ev.UserID)
+}
+
+// SetAckPolicy sets the policy for connections with acknowledged delivery; zero fields take the defaults.
+func (h *Hub) SetAckPolicy(p AckPolicy) {
+    if p.Timeout <= 0 { p.Timeout = DefaultAckTimeout }
+    if p.MaxRedeliveries <= 0 { p.MaxRedeliveries = DefaultAckRedeliveries }
+    if p.OnUnacked == nil { p.OnUnacked = logUnacked }
+    h.mu.Lock(); defer h.mu.Unlock()
+    h.ack = p
+}
+
+// AckPolicy returns the policy for connections with acknowledged delivery.
+func (h *Hub) AckPolicy() AckPolicy {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    return h.ack
+}
+
+// AwaitAck records that a subscriber sent ev to its client and now waits for the acknowledgement.
+// It does nothing unless the subscriber opted into acknowledged delivery and ev requires one.
+func (h *Hub) AwaitAck(id int, ev core.Event) {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    s, ok := h.subs[id]
+    if !ok || s.acks == nil || !ev.AckRequired() || ev.ID == "" { return }
+    s.acks.track(ev, h.now().Add(h.ack.Timeout))
+}
+
+// Ack records a client's acknowledgement of the event with the given ID. It reports false if the
+// event was not awaiting one, e.g. after it was given up on or acknowledged twice.
+func (h *Hub) Ack(id int, eventID string) bool {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    s, ok := h.subs[id]
+    if !ok || s.acks == nil { return false }
+    s.acks.mu.Lock(); defer s.acks.mu.Unlock()
+    if _, ok := s.acks.pending[eventID]; !ok { return false }
+    delete(s.acks.pending, eventID)
+    s.acks.acked++
+    return true
+}
+
+// DueRedeliveries returns a subscriber's events whose acknowledgement is overdue, oldest first, for
+// it to send again with the same ID; each restarts its timeout. Events that reached the policy's
+// MaxRedeliveries are given up on and passed to OnUnacked instead.
+func (h *Hub) DueRedeliveries(id int) []core.Event {
+    h.mu.RLock()
+    s, ok := h.subs[id]
+    if !ok || s.acks == nil {
+        h.mu.RUnlock()
+        return nil
+    }
+    policy, now := h.ack, h.now()
+    var due, expired []core.Event
+    s.acks.mu.Lock()
+    for eventID, p := range s.acks.pending {
+        if now.Before(p.deadline) { continue }
+        if p.redeliveries >= policy.MaxRedeliveries {
+            delete(s.acks.pending, eventID)
+            s.acks.failed++
+            expired = append(expired, p.ev)
+            continue
+        }
+        p.redeliveries++
+        p.deadline = now.Add(policy.Timeout)
+        s.acks.redelivered++
+        due = append(due, p.ev)
+    }
+    s.acks.mu.Unlock()
+    info := s.info(id)
+    h.mu.RUnlock()
+
+    sortEvents(due)
+    sortEvents(expired)
+    for _, ev := range expired { policy.OnUnacked(info, ev) }
+    return due
+}
+
+// giveUp empties a closing subscriber's pending acknowledgements, returning the events given up on
+func (a *ackState) giveUp() []core.Event {
+    a.mu.Lock(); defer a.mu.Unlock()
+    out := make([]core.Event, 0, len(a.pending))
+    for _, p := range a.pending { out = append(out, p.ev) }
+    a.failed += uint64(len(out))
+    a.pending = map[string]*pendingAck{}
+    sortEvents(out)
+    return out
+}
+
+func sortEvents(evs []core.Event) {
+    sort.Slice(evs, func(i, j int) bool {
+        if !evs[i].Time.Equal(evs[j].Time) { return evs[i].Time.Before(evs[j].Time) }
+        return evs[i].ID < evs[j].ID
+    })
+}
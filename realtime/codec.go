@@ -0,0 +1,275 @@
+package realtime
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "math"
+    "sort"
+    "time"
+
+    "gamifykit/core"
+)
+
+// Frame opcodes as defined by RFC 6455; they match gorilla/websocket's TextMessage and BinaryMessage.
+const (
+    FrameText   = 1
+    FrameBinary = 2
+)
+
+// Codec serializes events for a realtime connection.
+// Marshal returns the encoded payload and the frame opcode it must be sent with.
+type Codec interface {
+    Name() string
+    Marshal(ev core.Event) ([]byte, int)
+}
+
+// Built-in codecs. JSON is the default and is sent as text frames; compact JSON targets clients on
+// metered connections and the binary codecs target native clients.
+var (
+    JSONCodec     Codec = jsonCodec{}
+    CompactCodec  Codec = compactCodec{}
+    MsgpackCodec  Codec = msgpackCodec{}
+    ProtobufCodec Codec = protobufCodec{}
+)
+
+var codecs = map[string]Codec{
+    JSONCodec.Name():     JSONCodec,
+    CompactCodec.Name():  CompactCodec,
+    MsgpackCodec.Name():  MsgpackCodec,
+    ProtobufCodec.Name(): ProtobufCodec,
+}
+
+// CodecByName looks up a built-in codec by its name ("json", "compact", "msgpack" or "protobuf").
+func CodecByName(name string) (Codec, bool) {
+    c, ok := codecs[name]
+    return c, ok
+}
+
+// CodecNames lists the built-in codec names, JSON first so it wins subprotocol negotiation ties.
+func CodecNames() []string {
+    names := make([]string, 0, len(codecs))
+    for n := range codecs {
+        if n != JSONCodec.Name() { names = append(names, n) }
+    }
+    sort.Strings(names)
+    return append([]string{JSONCodec.Name()}, names...)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(ev core.Event) ([]byte, int) { return MarshalJSON(ev), FrameText }
+
+// compactEvent is the compact JSON form of an event: one-letter keys, the time as Unix
+// milliseconds and, like JSON, no zero-valued fields other than the type, time and user.
+type compactEvent struct {
+    ID       string         `json:"i,omitempty"`
+    Type     core.EventType `json:"e"`
+    Time     int64          `json:"t"`
+    UserID   core.UserID    `json:"u"`
+    Metric   core.Metric    `json:"m,omitempty"`
+    Delta    int64          `json:"d,omitempty"`
+    Total    int64          `json:"n,omitempty"`
+    Badge    core.Badge     `json:"b,omitempty"`
+    Level    int64          `json:"l,omitempty"`
+    Metadata map[string]any `json:"x,omitempty"`
+    Seq      uint64         `json:"s,omitempty"`
+}
+
+// compactCodec encodes events as compactEvent text frames. It trades readability for size, e.g.
+// for mobile clients on metered connections; sub-millisecond precision of the time is dropped.
+type compactCodec struct{}
+
+func (compactCodec) Name() string { return "compact" }
+
+func (compactCodec) Marshal(ev core.Event) ([]byte, int) {
+    b, _ := json.Marshal(compactEvent{ID: ev.ID, Type: ev.Type, Time: ev.Time.UnixMilli(), UserID: ev.UserID, Metric: ev.Metric,
+        Delta: ev.Delta, Total: ev.Total, Badge: ev.Badge, Level: ev.Level, Metadata: ev.Metadata, Seq: ev.Seq})
+    return b, FrameText
+}
+
+// msgpackCodec encodes events as a MessagePack map using the same keys and omission rules as JSON.
+// Time is encoded as an RFC 3339 string so both encodings carry identical values.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(ev core.Event) ([]byte, int) {
+    type kv struct {
+        k string
+        v any
+    }
+    var fields []kv
+    if ev.ID != "" { fields = append(fields, kv{"id", ev.ID}) }
+    fields = append(fields, kv{"type", string(ev.Type)}, kv{"time", ev.Time.Format(time.RFC3339Nano)}, kv{"user_id", string(ev.UserID)})
+    if ev.Metric != "" { fields = append(fields, kv{"metric", string(ev.Metric)}) }
+    if ev.Delta != 0 { fields = append(fields, kv{"delta", ev.Delta}) }
+    if ev.Total != 0 { fields = append(fields, kv{"total", ev.Total}) }
+    if ev.Badge != "" { fields = append(fields, kv{"badge", string(ev.Badge)}) }
+    if ev.Level != 0 { fields = append(fields, kv{"level", ev.Level}) }
+    if len(ev.Metadata) > 0 { fields = append(fields, kv{"metadata", ev.Metadata}) }
+
+    b := appendMsgpackMapHeader(nil, len(fields))
+    for _, f := range fields {
+        b = appendMsgpackString(b, f.k)
+        b = appendMsgpack(b, f.v)
+    }
+    return b, FrameBinary
+}
+
+func appendMsgpack(b []byte, v any) []byte {
+    switch x := v.(type) {
+    case nil:
+        return append(b, 0xc0)
+    case bool:
+        if x { return append(b, 0xc3) }
+        return append(b, 0xc2)
+    case string:
+        return appendMsgpackString(b, x)
+    case int:
+        return appendMsgpackInt(b, int64(x))
+    case int32:
+        return appendMsgpackInt(b, int64(x))
+    case int64:
+        return appendMsgpackInt(b, x)
+    case float32:
+        return appendMsgpackFloat(b, float64(x))
+    case float64:
+        return appendMsgpackFloat(b, x)
+    case []any:
+        b = appendMsgpackArrayHeader(b, len(x))
+        for _, e := range x { b = appendMsgpack(b, e) }
+        return b
+    case map[string]any:
+        keys := make([]string, 0, len(x))
+        for k := range x { keys = append(keys, k) }
+        sort.Strings(keys)
+        b = appendMsgpackMapHeader(b, len(x))
+        for _, k := range keys {
+            b = appendMsgpackString(b, k)
+            b = appendMsgpack(b, x[k])
+        }
+        return b
+    default:
+        // Normalize anything else through JSON so the value survives in a generic shape
+        raw, err := json.Marshal(x)
+        if err != nil { return append(b, 0xc0) }
+        var generic any
+        if err := json.Unmarshal(raw, &generic); err != nil { return append(b, 0xc0) }
+        return appendMsgpack(b, generic)
+    }
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+    switch {
+    case v >= 0 && v <= 0x7f:
+        return append(b, byte(v))
+    case v < 0 && v >= -32:
+        return append(b, byte(v))
+    case v >= math.MinInt8 && v <= math.MaxInt8:
+        return append(b, 0xd0, byte(v))
+    case v >= math.MinInt16 && v <= math.MaxInt16:
+        return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+    case v >= math.MinInt32 && v <= math.MaxInt32:
+        return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+    default:
+        return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+    }
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+    return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+    n := len(s)
+    switch {
+    case n < 32:
+        b = append(b, 0xa0|byte(n))
+    case n <= math.MaxUint8:
+        b = append(b, 0xd9, byte(n))
+    case n <= math.MaxUint16:
+        b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+    default:
+        b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+    }
+    return append(b, s...)
+}
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+    switch {
+    case n < 16:
+        return append(b, 0x90|byte(n))
+    case n <= math.MaxUint16:
+        return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+    default:
+        return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+    }
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+    switch {
+    case n < 16:
+        return append(b, 0x80|byte(n))
+    case n <= math.MaxUint16:
+        return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+    default:
+        return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+    }
+}
+
+// protobufCodec encodes events with the protobuf wire format for this schema:
+//
+//  message Event {
+//    string type           = 1;
+//    int64  time_unix_nano = 2;
+//    string user_id        = 3;
+//    string metric         = 4;
+//    int64  delta          = 5;
+//    int64  total          = 6;
+//    string badge          = 7;
+//    int64  level          = 8;
+//    bytes  metadata_json  = 9; // JSON object, free-form metadata has no fixed schema
+//    string id             = 10;
+//  }
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+func (protobufCodec) Marshal(ev core.Event) ([]byte, int) {
+    var b []byte
+    b = appendProtoString(b, 1, string(ev.Type))
+    b = appendProtoInt(b, 2, ev.Time.UnixNano())
+    b = appendProtoString(b, 3, string(ev.UserID))
+    b = appendProtoString(b, 4, string(ev.Metric))
+    b = appendProtoInt(b, 5, ev.Delta)
+    b = appendProtoInt(b, 6, ev.Total)
+    b = appendProtoString(b, 7, string(ev.Badge))
+    b = appendProtoInt(b, 8, ev.Level)
+    if len(ev.Metadata) > 0 {
+        if raw, err := json.Marshal(ev.Metadata); err == nil {
+            b = appendProtoBytes(b, 9, raw)
+        }
+    }
+    b = appendProtoString(b, 10, ev.ID)
+    return b, FrameBinary
+}
+
+// proto3 omits default values, so zero ints and empty strings are skipped.
+func appendProtoInt(b []byte, field int, v int64) []byte {
+    if v == 0 { return b }
+    b = binary.AppendUvarint(b, uint64(field)<<3)
+    return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+    if s == "" { return b }
+    return appendProtoBytes(b, field, []byte(s))
+}
+
+func appendProtoBytes(b []byte, field int, v []byte) []byte {
+    b = binary.AppendUvarint(b, uint64(field)<<3|2)
+    b = binary.AppendUvarint(b, uint64(len(v)))
+    return append(b, v...)
+}
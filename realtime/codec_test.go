@@ -0,0 +1,83 @@
+package realtime
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+    "time"
+
+    "gamifykit/core"
+)
+
+func TestMsgpackCodec(t *testing.T) {
+    ev := core.Event{Type: core.EventLevelUp, Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), UserID: "u", Level: 300}
+    b, op := MsgpackCodec.Marshal(ev)
+    if op != FrameBinary { t.Fatalf("want binary frame, got %d", op) }
+    want := []byte{0x84, 0xa4, 't', 'y', 'p', 'e', 0xa8}
+    want = append(want, "level_up"...)
+    want = append(want, 0xa4, 't', 'i', 'm', 'e', 0xb4)
+    want = append(want, "2024-01-01T00:00:00Z"...)
+    want = append(want, 0xa7)
+    want = append(want, "user_id"...)
+    want = append(want, 0xa1, 'u', 0xa5)
+    want = append(want, "level"...)
+    want = append(want, 0xd1, 0x01, 0x2c)
+    if !bytes.Equal(b, want) { t.Fatalf("unexpected encoding\n got % x\nwant % x", b, want) }
+}
+
+func TestProtobufCodec(t *testing.T) {
+    ev := core.Event{Type: core.EventPointsAdded, UserID: "alice", Delta: -5, Metadata: map[string]any{"k": "v"}}
+    b, op := ProtobufCodec.Marshal(ev)
+    if op != FrameBinary { t.Fatalf("want binary frame, got %d", op) }
+
+    fields := map[uint64][]byte{}
+    ints := map[uint64]int64{}
+    for len(b) > 0 {
+        key, n := binary.Uvarint(b); b = b[n:]
+        switch key & 7 {
+        case 0:
+            v, n := binary.Uvarint(b); b = b[n:]
+            ints[key>>3] = int64(v)
+        case 2:
+            l, n := binary.Uvarint(b); b = b[n:]
+            fields[key>>3] = b[:l]; b = b[l:]
+        default:
+            t.Fatalf("unexpected wire type %d", key&7)
+        }
+    }
+    if string(fields[1]) != "points_added" || string(fields[3]) != "alice" || string(fields[9]) != `{"k":"v"}` { t.Fatalf("unexpected fields %q", fields) }
+    if ints[5] != -5 { t.Fatalf("want delta -5, got %d", ints[5]) }
+    if _, ok := fields[7]; ok { t.Fatal("empty badge must be omitted") }
+}
+
+func TestCompactCodec(t *testing.T) {
+    ev := core.Event{ID: "e1", Type: core.EventPointsAdded, Time: time.Date(2024, 1, 1, 0, 0, 0, 5e6, time.UTC), UserID: "u", Metric: "xp", Delta: 5, Seq: 2}
+    b, op := CompactCodec.Marshal(ev)
+    if op != FrameText { t.Fatalf("want text frame, got %d", op) }
+    want := `{"i":"e1","e":"points_added","t":1704067200005,"u":"u","m":"xp","d":5,"s":2}`
+    if string(b) != want { t.Fatalf("unexpected encoding\n got %s\nwant %s", b, want) }
+
+    // a representative event mix must shrink noticeably against plain JSON
+    var plain, compact int
+    for _, ev := range []core.Event{
+        core.NewPointsAdded("user-1042", core.MetricXP, 25, 1025),
+        core.NewPointsAdded("user-1042", "coins", 5, 205),
+        core.NewBadgeAwarded("user-1042", "streak-7"),
+        core.NewLevelUp("user-1042", core.MetricXP, 3),
+    } {
+        j, _ := JSONCodec.Marshal(ev)
+        c, _ := CompactCodec.Marshal(ev)
+        plain += len(j); compact += len(c)
+    }
+    if compact*100 > plain*80 { t.Fatalf("compact %d bytes, json %d: want at least 20%% smaller", compact, plain) }
+}
+
+func TestCodecByName(t *testing.T) {
+    for _, name := range CodecNames() {
+        c, ok := CodecByName(name)
+        if !ok || c.Name() != name { t.Fatalf("codec %q not registered", name) }
+    }
+    if CodecNames()[0] != "json" { t.Fatal("json must be preferred") }
+    if _, ok := CodecByName("xml"); ok { t.Fatal("unexpected codec") }
+    if _, op := NewHub().Codec().Marshal(core.Event{}); op != FrameText { t.Fatal("hub must default to JSON text frames") }
+}
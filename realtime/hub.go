@@ -3,45 +3,177 @@ package realtime
 import (
     "context"
     "encoding/json"
+    "sort"
     "sync"
+    "sync/atomic"
+    "time"
 
     "gamifykit/core"
 )
 
 // Hub is a simple pub/sub for broadcasting events to channels.
 type Hub struct {
-    mu    sync.RWMutex
-    subs  map[int]chan core.Event
-    next  int
+    mu      sync.RWMutex
+    subs    map[int]*subscriber
+    next    int
+    codec   Codec
+    onCount func(int)
+    ack     AckPolicy
+    now     func() time.Time
 }
 
-func NewHub() *Hub { return &Hub{subs: map[int]chan core.Event{}} }
+// ConnOptions describes a subscriber for filtering and introspection.
+type ConnOptions struct {
+    // User, if set, limits delivery to events for that user.
+    User       core.UserID
+    // Namespace, if set, limits delivery to events published in that namespace, i.e. carrying it
+    // as Metadata["namespace"] (see httpapi.EnrichEvent); events without one are not delivered.
+    Namespace  core.Namespace
+    Codec      string
+    RemoteAddr string
+    // Acks opts into acknowledged delivery of events flagged with core.RequireAck; see AckPolicy.
+    Acks bool
+}
+
+// ConnInfo is a snapshot of a subscriber's metadata and delivery counters.
+type ConnInfo struct {
+    ID          int         `json:"id"`
+    ConnectedAt time.Time   `json:"connected_at"`
+    User        core.UserID `json:"user_filter,omitempty"`
+    Namespace   core.Namespace `json:"namespace,omitempty"`
+    Codec       string      `json:"codec,omitempty"`
+    RemoteAddr  string      `json:"remote_addr,omitempty"`
+    Sent        uint64      `json:"events_sent"`
+    Dropped     uint64      `json:"events_dropped"`
+    // Acks and the counters below are set for connections with acknowledged delivery: events
+    // awaiting acknowledgement, acknowledged, sent again and given up on.
+    Acks        bool        `json:"acks,omitempty"`
+    Unacked     int         `json:"events_unacked,omitempty"`
+    Acked       uint64      `json:"events_acked,omitempty"`
+    Redelivered uint64      `json:"events_redelivered,omitempty"`
+    AckFailed   uint64      `json:"events_ack_failed,omitempty"`
+}
+
+type subscriber struct {
+    ch          chan core.Event
+    opts        ConnOptions
+    connectedAt time.Time
+    sent        atomic.Uint64
+    dropped     atomic.Uint64
+    acks        *ackState
+}
+
+func (s *subscriber) info(id int) ConnInfo {
+    c := ConnInfo{ID: id, ConnectedAt: s.connectedAt, User: s.opts.User, Namespace: s.opts.Namespace, Codec: s.opts.Codec,
+        RemoteAddr: s.opts.RemoteAddr, Sent: s.sent.Load(), Dropped: s.dropped.Load(), Acks: s.acks != nil}
+    if s.acks != nil {
+        s.acks.mu.Lock()
+        c.Unacked, c.Acked, c.Redelivered, c.AckFailed = len(s.acks.pending), s.acks.acked, s.acks.redelivered, s.acks.failed
+        s.acks.mu.Unlock()
+    }
+    return c
+}
+
+func NewHub() *Hub {
+    h := &Hub{subs: map[int]*subscriber{}, codec: JSONCodec, now: time.Now}
+    h.SetAckPolicy(AckPolicy{})
+    return h
+}
+
+// OnClientCount registers fn to receive the subscriber count after every subscribe/unsubscribe,
+// e.g. to drive a gauge. fn runs under the hub lock, so calls arrive in order; keep it fast.
+func (h *Hub) OnClientCount(fn func(n int)) {
+    h.mu.Lock(); defer h.mu.Unlock()
+    h.onCount = fn
+    if fn != nil { fn(len(h.subs)) }
+}
+
+// ClientCount returns the number of current subscribers.
+func (h *Hub) ClientCount() int {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    return len(h.subs)
+}
+
+// Connections returns a snapshot of all subscribers, oldest first.
+func (h *Hub) Connections() []ConnInfo {
+    h.mu.RLock()
+    out := make([]ConnInfo, 0, len(h.subs))
+    for id, s := range h.subs { out = append(out, s.info(id)) }
+    h.mu.RUnlock()
+    sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+    return out
+}
+
+// MarkSent records that a subscriber delivered an event to its client.
+func (h *Hub) MarkSent(id int) {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    if s, ok := h.subs[id]; ok { s.sent.Add(1) }
+}
+
+// SetCodec sets the codec used by connections that do not negotiate one themselves.
+func (h *Hub) SetCodec(c Codec) {
+    h.mu.Lock(); defer h.mu.Unlock()
+    h.codec = c
+}
+
+// Codec returns the default codec for new connections.
+func (h *Hub) Codec() Codec {
+    h.mu.RLock(); defer h.mu.RUnlock()
+    return h.codec
+}
 
 func (h *Hub) Subscribe(buffer int) (int, <-chan core.Event) {
+    return h.SubscribeConn(buffer, ConnOptions{})
+}
+
+// SubscribeConn subscribes with connection metadata; see ConnOptions.
+func (h *Hub) SubscribeConn(buffer int, opts ConnOptions) (int, <-chan core.Event) {
     h.mu.Lock(); defer h.mu.Unlock()
     h.next++
     id := h.next
-    ch := make(chan core.Event, buffer)
-    h.subs[id] = ch
-    return id, ch
+    s := &subscriber{ch: make(chan core.Event, buffer), opts: opts, connectedAt: time.Now().UTC()}
+    if opts.Acks { s.acks = newAckState() }
+    h.subs[id] = s
+    if h.onCount != nil { h.onCount(len(h.subs)) }
+    return id, s.ch
 }
 
+// Unsubscribe removes a subscriber and closes its channel. Events it still awaited an
+// acknowledgement for are given up on; see AckPolicy.OnUnacked.
 func (h *Hub) Unsubscribe(id int) {
-    h.mu.Lock(); defer h.mu.Unlock()
-    if ch, ok := h.subs[id]; ok {
-        delete(h.subs, id)
-        close(ch)
+    h.mu.Lock()
+    s, ok := h.subs[id]
+    if !ok {
+        h.mu.Unlock()
+        return
     }
+    delete(h.subs, id)
+    close(s.ch)
+    if h.onCount != nil { h.onCount(len(h.subs)) }
+    onUnacked := h.ack.OnUnacked
+    h.mu.Unlock()
+    if s.acks == nil { return }
+    unacked := s.acks.giveUp()
+    info := s.info(id)
+    for _, ev := range unacked { onUnacked(info, ev) }
 }
 
 func (h *Hub) Broadcast(_ context.Context, ev core.Event) {
     h.mu.RLock()
-    // copy to avoid holding lock during send
-    receivers := make([]chan core.Event, 0, len(h.subs))
-    for _, ch := range h.subs { receivers = append(receivers, ch) }
-    h.mu.RUnlock()
-    for _, ch := range receivers {
-        select { case ch <- ev: default: /* drop if full */ }
+    defer h.mu.RUnlock()
+    // sends never block, so holding the read lock keeps Unsubscribe from closing a channel mid-send
+    for _, s := range h.subs {
+        if s.opts.User != "" && s.opts.User != ev.UserID { continue }
+        if s.opts.Namespace != "" {
+            if ns, _ := ev.Metadata["namespace"].(string); core.Namespace(ns) != s.opts.Namespace { continue }
+        }
+        select {
+        case s.ch <- ev:
+        default:
+            s.dropped.Add(1)
+            // an event awaiting acknowledgement is not lost to a full buffer: it falls due for redelivery at once
+            if s.acks != nil && ev.AckRequired() && ev.ID != "" { s.acks.track(ev, h.now()) }
+        }
     }
 }
 
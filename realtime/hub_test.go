@@ -0,0 +1,102 @@
+package realtime
+
+import (
+    "context"
+    "sync"
+    "testing"
+    "time"
+
+    "gamifykit/core"
+)
+
+func TestHubClientCountConcurrent(t *testing.T) {
+    h := NewHub()
+    var mu sync.Mutex
+    var last int
+    h.OnClientCount(func(n int){ mu.Lock(); last = n; mu.Unlock() })
+
+    var wg sync.WaitGroup
+    ids := make(chan int, 100)
+    for i := 0; i < 100; i++ {
+        wg.Add(1)
+        go func(){ defer wg.Done(); id, _ := h.Subscribe(1); ids <- id }()
+    }
+    wg.Wait()
+    close(ids)
+    if h.ClientCount() != 100 || last != 100 { t.Fatalf("want 100 clients, got %d (gauge %d)", h.ClientCount(), last) }
+
+    for id := range ids {
+        wg.Add(1)
+        go func(id int){ defer wg.Done(); h.Unsubscribe(id) }(id)
+    }
+    wg.Wait()
+    if h.ClientCount() != 0 || last != 0 { t.Fatalf("want 0 clients, got %d (gauge %d)", h.ClientCount(), last) }
+}
+
+func TestHubConnectionsFilterAndDrops(t *testing.T) {
+    h := NewHub()
+    allID, all := h.SubscribeConn(1, ConnOptions{Codec: "json"})
+    aliceID, alice := h.SubscribeConn(4, ConnOptions{User: "alice"})
+
+    ctx := context.Background()
+    h.Broadcast(ctx, core.NewBadgeAwarded("alice", "b1"))
+    h.Broadcast(ctx, core.NewBadgeAwarded("bob", "b2"))
+    if len(alice) != 1 { t.Fatalf("user filter should deliver only alice's events, got %d", len(alice)) }
+    <-all
+    h.MarkSent(allID)
+
+    conns := h.Connections()
+    if len(conns) != 2 || conns[0].ID != allID || conns[1].ID != aliceID { t.Fatalf("unexpected connections %#v", conns) }
+    if conns[0].Sent != 1 || conns[0].Dropped != 1 || conns[0].Codec != "json" { t.Fatalf("unexpected counters for unfiltered conn %#v", conns[0]) }
+    if conns[1].User != "alice" || conns[1].Dropped != 0 || conns[1].ConnectedAt.IsZero() { t.Fatalf("unexpected alice conn %#v", conns[1]) }
+}
+
+func TestHubAcks(t *testing.T) {
+    h := NewHub()
+    now := time.Now()
+    h.now = func() time.Time { return now }
+    var unacked []core.Event
+    h.SetAckPolicy(AckPolicy{Timeout: time.Second, MaxRedeliveries: 2, OnUnacked: func(_ ConnInfo, ev core.Event){ unacked = append(unacked, ev) }})
+    id, ch := h.SubscribeConn(1, ConnOptions{Acks: true})
+    plainID, _ := h.SubscribeConn(4, ConnOptions{})
+
+    ctx := context.Background()
+    first, second := core.RequireAck(core.NewBadgeAwarded("alice", "b1")), core.RequireAck(core.NewBadgeAwarded("alice", "b2"))
+    h.Broadcast(ctx, first)
+    h.Broadcast(ctx, second) // the full buffer drops it, so it is due at once
+    h.AwaitAck(id, <-ch)
+    h.AwaitAck(plainID, first)
+    h.AwaitAck(id, core.NewBadgeAwarded("alice", "b3")) // not flagged
+    if due := h.DueRedeliveries(id); len(due) != 1 || due[0].ID != second.ID { t.Fatalf("want the dropped event due, got %v", due) }
+    if h.DueRedeliveries(plainID) != nil { t.Fatal("connection without acks has redeliveries") }
+    if !h.Ack(id, second.ID) || h.Ack(id, second.ID) || h.Ack(plainID, first.ID) { t.Fatal("unexpected Ack results") }
+
+    for i := 0; i < 2; i++ {
+        now = now.Add(time.Second)
+        if due := h.DueRedeliveries(id); len(due) != 1 || due[0].ID != first.ID { t.Fatalf("redelivery %d: %v", i, due) }
+    }
+    now = now.Add(time.Second)
+    if due := h.DueRedeliveries(id); len(due) != 0 || len(unacked) != 1 || unacked[0].ID != first.ID { t.Fatalf("want first given up on, got due %v unacked %v", due, unacked) }
+    c := h.Connections()[0]
+    if !c.Acks || c.Unacked != 0 || c.Acked != 1 || c.Redelivered != 3 || c.AckFailed != 1 || h.Connections()[1].Acks { t.Fatalf("unexpected counters %+v", c) }
+
+    // events still pending at disconnect are given up on too
+    third := core.RequireAck(core.NewBadgeAwarded("alice", "b3"))
+    h.AwaitAck(id, third)
+    h.Unsubscribe(id)
+    if len(unacked) != 2 || unacked[1].ID != third.ID { t.Fatalf("pending event not reported on unsubscribe: %v", unacked) }
+}
+
+func TestHubNamespaceFilter(t *testing.T) {
+    h := NewHub()
+    _, g1 := h.SubscribeConn(4, ConnOptions{Namespace: "g1"})
+    _, all := h.SubscribeConn(4, ConnOptions{})
+    ev := core.NewBadgeAwarded("alice", "b1")
+    ev.Metadata = map[string]any{"namespace": "g2"}
+    h.Broadcast(context.Background(), ev)
+    h.Broadcast(context.Background(), core.NewBadgeAwarded("alice", "b2"))
+    ev.Metadata = map[string]any{"namespace": "g1"}
+    h.Broadcast(context.Background(), ev)
+    if len(g1) != 1 || len(all) != 3 { t.Fatalf("namespaced subscriber got %d events, unscoped %d; want 1 and 3", len(g1), len(all)) }
+    if h.Connections()[0].Namespace != "g1" { t.Fatal("namespace missing from the connection info") }
+}
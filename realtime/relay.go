@@ -0,0 +1,140 @@
+package realtime
+
+import (
+    "context"
+    "encoding/json"
+    "sync"
+
+    "gamifykit/core"
+)
+
+// DefaultRelayDedupWindow is how many recent event IDs a Relay remembers when none is configured.
+const DefaultRelayDedupWindow = 10000
+
+// Backplane carries encoded events between the hubs of several server instances, e.g. over Redis
+// pub/sub, so a WebSocket client sees events no matter which instance produced them.
+type Backplane interface {
+    // Publish sends msg to every instance subscribed to the backplane, including this one.
+    Publish(ctx context.Context, msg []byte) error
+    // Subscribe calls fn with every message published until ctx is done, reconnecting to the
+    // backend after failures. Messages published while disconnected may be lost.
+    Subscribe(ctx context.Context, fn func(msg []byte)) error
+}
+
+// RelayOptions tunes a Relay.
+type RelayOptions struct {
+    // DedupWindow is how many recent event IDs are remembered to drop events already delivered
+    // (DefaultRelayDedupWindow when zero).
+    DedupWindow int
+    // OnError is called when publishing to or decoding from the backplane fails.
+    OnError func(error)
+}
+
+// Relay shares a hub's events with the hubs of other instances. Broadcast delivers an event to the
+// local clients at once and publishes it to the backplane; Run rebroadcasts events published by
+// other instances. Events are deduplicated by ID, so the originating instance does not send its own
+// events twice and redelivered messages are dropped. Single-instance deployments broadcast to the
+// hub directly and need no relay.
+type Relay struct {
+    hub    *Hub
+    bp     Backplane
+    opts   RelayOptions
+    origin string
+
+    mu   sync.Mutex
+    seen map[string]struct{}
+    ring []string
+    next int
+}
+
+// relayMessage is the backplane envelope of an event
+type relayMessage struct {
+    Origin string     `json:"origin"`
+    Event  core.Event `json:"event"`
+}
+
+// NewRelay creates a relay between hub and bp.
+func NewRelay(hub *Hub, bp Backplane, opts RelayOptions) *Relay {
+    if hub == nil || bp == nil { panic("NewRelay requires a hub and a backplane") }
+    if opts.DedupWindow <= 0 { opts.DedupWindow = DefaultRelayDedupWindow }
+    return &Relay{hub: hub, bp: bp, opts: opts, origin: core.NewUUIDv7(), seen: map[string]struct{}{}, ring: make([]string, opts.DedupWindow)}
+}
+
+// Hub returns the local hub.
+func (r *Relay) Hub() *Hub { return r.hub }
+
+// Broadcast delivers ev to the local clients and publishes it to the other instances.
+func (r *Relay) Broadcast(ctx context.Context, ev core.Event) {
+    if ev.ID != "" && !r.remember(ev.ID) { return }
+    r.hub.Broadcast(ctx, ev)
+    msg, err := json.Marshal(relayMessage{Origin: r.origin, Event: ev})
+    if err == nil { err = r.bp.Publish(ctx, msg) }
+    if err != nil { r.fail(err) }
+}
+
+// Run rebroadcasts events from other instances to the local clients until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+    return r.bp.Subscribe(ctx, func(msg []byte) {
+        var m relayMessage
+        if err := json.Unmarshal(msg, &m); err != nil { r.fail(err); return }
+        if m.Origin == r.origin { return }
+        if m.Event.ID != "" && !r.remember(m.Event.ID) { return }
+        r.hub.Broadcast(ctx, m.Event)
+    })
+}
+
+// remember records id and reports whether it was new
+func (r *Relay) remember(id string) bool {
+    r.mu.Lock(); defer r.mu.Unlock()
+    if _, ok := r.seen[id]; ok { return false }
+    if old := r.ring[r.next]; old != "" { delete(r.seen, old) }
+    r.ring[r.next] = id
+    r.next = (r.next + 1) % len(r.ring)
+    r.seen[id] = struct{}{}
+    return true
+}
+
+func (r *Relay) fail(err error) {
+    if r.opts.OnError != nil { r.opts.OnError(err) }
+}
+
+// MemoryBackplane is a Backplane within one process, e.g. for tests or several hubs in one binary.
+type MemoryBackplane struct {
+    mu   sync.RWMutex
+    subs map[int]chan []byte
+    next int
+}
+
+// NewMemoryBackplane creates an in-process backplane.
+func NewMemoryBackplane() *MemoryBackplane { return &MemoryBackplane{subs: map[int]chan []byte{}} }
+
+// Publish hands msg to every subscriber; subscribers whose buffer is full miss it.
+func (b *MemoryBackplane) Publish(_ context.Context, msg []byte) error {
+    b.mu.RLock(); defer b.mu.RUnlock()
+    for _, ch := range b.subs {
+        select {
+        case ch <- msg:
+        default:
+        }
+    }
+    return nil
+}
+
+// Subscribe calls fn with every message published until ctx is done.
+func (b *MemoryBackplane) Subscribe(ctx context.Context, fn func(msg []byte)) error {
+    ch := make(chan []byte, 256)
+    b.mu.Lock()
+    b.next++
+    id := b.next
+    b.subs[id] = ch
+    b.mu.Unlock()
+    defer func() { b.mu.Lock(); delete(b.subs, id); b.mu.Unlock() }()
+    for {
+        select {
+        case msg := <-ch:
+            fn(msg)
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+}
@@ -0,0 +1,39 @@
+package realtime
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "gamifykit/core"
+)
+
+func TestRelaySharesEventsOnce(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    bp := NewMemoryBackplane()
+    hubA, hubB := NewHub(), NewHub()
+    relayA, relayB := NewRelay(hubA, bp, RelayOptions{}), NewRelay(hubB, bp, RelayOptions{})
+    go relayA.Run(ctx)
+    go relayB.Run(ctx)
+    for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+        bp.mu.RLock(); n := len(bp.subs); bp.mu.RUnlock()
+        if n == 2 { break }
+        if time.Now().After(deadline) { t.Fatal("relays did not subscribe") }
+    }
+    _, clientA := hubA.Subscribe(4)
+    _, clientB := hubB.Subscribe(4)
+
+    ev := core.NewBadgeAwarded("alice", "b1")
+    relayA.Broadcast(ctx, ev)
+    select {
+    case got := <-clientB:
+        if got.ID != ev.ID { t.Fatalf("instance B got %v, want %v", got, ev) }
+    case <-time.After(time.Second):
+        t.Fatal("instance B did not receive the event")
+    }
+    // a redelivery, e.g. the same event relayed by another instance, is dropped everywhere
+    relayB.Broadcast(ctx, ev)
+    time.Sleep(20 * time.Millisecond)
+    if len(clientA) != 1 || len(clientB) != 0 { t.Fatalf("want one delivery per instance, A has %d more and B %d more", len(clientA), len(clientB)) }
+}
@@ -0,0 +1,124 @@
+// Package secret keeps credentials out of logs and printed values: SecureString never renders its
+// value. Sealed holds a value encrypted under a key that only lives in this process, which keeps
+// long-cached values out of plain sight in memory; it does not make a process's memory free of
+// credentials.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Redacted is what a non-empty SecureString renders as.
+const Redacted = "[REDACTED]"
+
+// SecureString is a string holding a credential, such as a DSN, password or token. It prints as
+// [REDACTED] with any fmt verb, logs as [REDACTED] through slog and marshals to JSON as
+// "[REDACTED]"; empty values render as empty, so it is still visible whether one is set. Reveal
+// returns the value. It unmarshals and converts from plain strings, so configuration files and
+// environment variables set it as usual.
+type SecureString string
+
+// Reveal returns the secret value.
+func (s SecureString) Reveal() string { return string(s) }
+
+// String returns [REDACTED], or "" when s is empty.
+func (s SecureString) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString keeps %#v from printing the value.
+func (s SecureString) GoString() string { return fmt.Sprintf("secret.SecureString(%q)", s.String()) }
+
+// LogValue keeps slog from logging the value.
+func (s SecureString) LogValue() slog.Value { return slog.StringValue(s.String()) }
+
+// MarshalJSON writes [REDACTED] instead of the value.
+func (s SecureString) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+// Sealed is a value encrypted with AES-256-GCM under a key generated when the process first seals
+// something. The key is never written to disk and sealed values cannot be opened by another
+// process, but its expanded key schedule lives on the heap for the life of the process: a heap
+// dump or swapped-out page holding both can still be decrypted. Sealing only shortens the time a
+// value sits in memory as plaintext, and only for the sealed copy; strings it was made from or
+// revealed to (see Reveal) are ordinary Go strings. The zero Sealed is empty.
+type Sealed struct {
+	nonce, data []byte
+}
+
+var (
+	aeadOnce sync.Once
+	aead     cipher.AEAD
+	aeadErr  error
+)
+
+// processAEAD returns the cipher under the process's ephemeral key
+func processAEAD() (cipher.AEAD, error) {
+	aeadOnce.Do(func() {
+		key := make([]byte, 32)
+		defer Zero(key)
+		if _, aeadErr = rand.Read(key); aeadErr != nil {
+			return
+		}
+		var block cipher.Block
+		if block, aeadErr = aes.NewCipher(key); aeadErr != nil {
+			return
+		}
+		aead, aeadErr = cipher.NewGCM(block)
+	})
+	return aead, aeadErr
+}
+
+// Seal encrypts plaintext. The caller may Zero plaintext afterwards.
+func Seal(plaintext []byte) (Sealed, error) {
+	a, err := processAEAD()
+	if err != nil {
+		return Sealed{}, fmt.Errorf("secret: %w", err)
+	}
+	nonce := make([]byte, a.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Sealed{}, fmt.Errorf("secret: %w", err)
+	}
+	return Sealed{nonce: nonce, data: a.Seal(nil, nonce, plaintext, nil)}, nil
+}
+
+// Open decrypts the value into a new buffer, which the caller should Zero once done with it.
+func (s Sealed) Open() ([]byte, error) {
+	if s.nonce == nil {
+		return nil, errors.New("secret: value is not sealed")
+	}
+	a, err := processAEAD()
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	plaintext, err := a.Open(nil, s.nonce, s.data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Reveal opens the value as a SecureString, zeroing the decrypted buffer. Go strings cannot be
+// wiped, so the returned copy lives until it is garbage collected; keep it no longer than needed.
+func (s Sealed) Reveal() (SecureString, error) {
+	b, err := s.Open()
+	if err != nil {
+		return "", err
+	}
+	defer Zero(b)
+	return SecureString(b), nil
+}
+
+// Zero overwrites b with zeros.
+func Zero(b []byte) {
+	clear(b)
+}
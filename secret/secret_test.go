@@ -0,0 +1,80 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSecureStringNeverRenders(t *testing.T) {
+	const value = "postgres://app:hunter2@db/app"
+	s := SecureString(value)
+	cfg := struct {
+		DSN SecureString `json:"dsn"`
+	}{s}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+		if out := fmt.Sprintf(format, cfg); strings.Contains(out, "hunter2") || strings.Contains(out, fmt.Sprintf("%x", "hunter2")) {
+			t.Errorf("Sprintf(%q) = %s, leaks the value", format, out)
+		}
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil || string(b) != `{"dsn":"[REDACTED]"}` {
+		t.Errorf("json.Marshal = %s, %v; want the value redacted", b, err)
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("connecting", "dsn", s)
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("slog wrote %s", buf.String())
+	}
+	if s.Reveal() != value {
+		t.Errorf("Reveal() = %q, want %q", s.Reveal(), value)
+	}
+	if SecureString("").String() != "" {
+		t.Errorf("empty SecureString should render empty")
+	}
+
+	var decoded struct {
+		DSN SecureString `json:"dsn"`
+	}
+	if err := json.Unmarshal([]byte(`{"dsn":"`+value+`"}`), &decoded); err != nil || decoded.DSN.Reveal() != value {
+		t.Errorf("json.Unmarshal = %q, %v; want the plain value", decoded.DSN.Reveal(), err)
+	}
+}
+
+func TestSealed(t *testing.T) {
+	plaintext := []byte("hunter2-hunter2")
+	sealed, err := Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed.data, plaintext) {
+		t.Fatal("sealed value holds the plaintext")
+	}
+	other, _ := Seal(plaintext)
+	if bytes.Equal(sealed.data, other.data) {
+		t.Error("sealing twice gave the same ciphertext")
+	}
+	opened, err := sealed.Open()
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open = %q, %v; want %q", opened, err, plaintext)
+	}
+	Zero(opened)
+	if !bytes.Equal(opened, make([]byte, len(plaintext))) {
+		t.Errorf("Zero left %q", opened)
+	}
+	if s, err := sealed.Reveal(); err != nil || s.Reveal() != string(plaintext) {
+		t.Errorf("Reveal = %q, %v", s.Reveal(), err)
+	}
+
+	sealed.data[0] ^= 1
+	if _, err := sealed.Open(); err == nil {
+		t.Error("Open accepted a tampered value")
+	}
+	if _, err := (Sealed{}).Open(); err == nil {
+		t.Error("Open accepted the zero Sealed")
+	}
+}
@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP over HTTP, JSON-encoded.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter exports to endpoint, e.g. "http://otel-collector:4318"; "/v1/traces" is
+// appended when the URL has no path. Spans are reported under the service name service.
+func NewOTLPExporter(endpoint, service string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	return &OTLPExporter{endpoint: u.String(), service: service, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Export posts one batch of spans.
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	payload, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans: collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpKeyValue and friends mirror the OTLP/JSON encoding of ExportTraceServiceRequest
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         SpanKind       `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       map[string]any `json:"status,omitempty"`
+}
+
+func (e *OTLPExporter) request(spans []SpanData) map[string]any {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{TraceID: s.Context.TraceID.String(), SpanID: s.Context.SpanID.String(), Name: s.Name, Kind: s.Kind,
+			Start: strconv.FormatInt(s.Start.UnixNano(), 10), End: strconv.FormatInt(s.End.UnixNano(), 10)}
+		if s.Parent != (SpanID{}) {
+			o.ParentSpanID = s.Parent.String()
+		}
+		for _, a := range s.Attrs {
+			o.Attributes = append(o.Attributes, otlpAttr(a))
+		}
+		if s.Error != "" {
+			o.Status = map[string]any{"code": 2, "message": s.Error}
+		}
+		out[i] = o
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource":   map[string]any{"attributes": []otlpKeyValue{otlpAttr(Attr{"service.name", e.service})}},
+		"scopeSpans": []any{map[string]any{"scope": map[string]any{"name": "gamifykit"}, "spans": out}},
+	}}}
+}
+
+// otlpAttr encodes an attribute value; integers are strings in OTLP/JSON
+func otlpAttr(a Attr) otlpKeyValue {
+	var v map[string]any
+	switch x := a.Value.(type) {
+	case string:
+		v = map[string]any{"stringValue": x}
+	case bool:
+		v = map[string]any{"boolValue": x}
+	case int:
+		v = map[string]any{"intValue": strconv.FormatInt(int64(x), 10)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": x}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(x)}
+	}
+	return otlpKeyValue{Key: a.Key, Value: v}
+}
@@ -0,0 +1,380 @@
+// Package tracing is a small, dependency-free distributed tracing layer compatible with
+// OpenTelemetry. It propagates W3C trace context (the traceparent header), records spans and hands
+// them to an Exporter, such as the OTLP exporter that sends them to an OpenTelemetry collector.
+//
+// Instrumented code calls Start, which uses the tracer installed with SetDefault. Without one,
+// Start returns a nil *Span, whose methods do nothing, so tracing costs next to nothing when disabled.
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gamifykit/core"
+)
+
+// TraceparentHeader carries the W3C trace context between services.
+const TraceparentHeader = "traceparent"
+
+// TraceID identifies a trace across services.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled reports whether the trace is recorded; downstream services follow the decision.
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool { return sc.TraceID != TraceID{} && sc.SpanID != SpanID{} }
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Only version 00 is understood, and
+// all-zero IDs are rejected.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// SpanKind is the role of a span, with OTLP's numbering.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attr is a span attribute. Values are strings, bools, integers or floats.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name    string
+	Kind    SpanKind
+	Context SpanContext
+	// Parent is the parent span's ID, zero for root spans.
+	Parent SpanID
+	Start  time.Time
+	End    time.Time
+	Attrs  []Attr
+	// Error is the message of the error the span ended with, if any.
+	Error string
+}
+
+// Span is an operation in a trace. A nil *Span is valid and does nothing, which is what Start
+// returns while tracing is disabled.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// Context returns the span's trace context, the zero value for a nil span.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttr records an attribute on a sampled span.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil || !s.data.Context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attrs = append(s.data.Attrs, Attr{Key: key, Value: value})
+	s.mu.Unlock()
+}
+
+// SetUser records the user an operation acts on as "user.id", redacted per Options.RedactUser.
+func (s *Span) SetUser(user core.UserID) {
+	if s == nil || !s.data.Context.Sampled {
+		return
+	}
+	id := string(user)
+	if s.tracer.opts.RedactUser != nil {
+		id = s.tracer.opts.RedactUser(user)
+	}
+	s.SetAttr("user.id", id)
+}
+
+// End finishes the span, marking it failed when err is not nil. Later calls do nothing.
+func (s *Span) End(err error) {
+	if s == nil || !s.data.Context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.export(data)
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Options configures a Tracer.
+type Options struct {
+	// SampleRate is the fraction of new traces recorded, from 0 to 1. Traces started by an
+	// incoming traceparent follow the caller's decision instead.
+	SampleRate float64
+	// Exporter receives finished spans in batches. Without one, spans only propagate context.
+	Exporter Exporter
+	// RedactUser, if set, transforms user IDs before they are recorded, e.g. to pseudonymize them
+	// like the logs do (see logging.RedactOptions).
+	RedactUser func(core.UserID) string
+	// BatchSize is how many spans are exported at once (512 when zero); BatchTimeout is the
+	// longest a finished span waits for its batch (5s when zero).
+	BatchSize    int
+	BatchTimeout time.Duration
+	// OnError is called when an export fails or spans are dropped because the queue is full.
+	OnError func(error)
+}
+
+// Tracer starts spans and exports the sampled ones in the background.
+type Tracer struct {
+	opts    Options
+	queue   chan SpanData
+	flush   chan chan struct{}
+	dropped atomic.Int64
+	closed  sync.Once
+}
+
+// NewTracer creates a tracer; call Shutdown to flush pending spans before exiting.
+func NewTracer(opts Options) *Tracer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = 5 * time.Second
+	}
+	t := &Tracer{opts: opts, queue: make(chan SpanData, 4*opts.BatchSize), flush: make(chan chan struct{})}
+	if opts.Exporter != nil {
+		go t.run()
+	}
+	return t
+}
+
+// Start begins a span as a child of the span in ctx, or of a remote parent attached with
+// Extract, and returns a context carrying it.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	data := SpanData{Name: name, Kind: kind, Start: time.Now()}
+	if parent, ok := parentFrom(ctx); ok {
+		data.Context.TraceID, data.Parent, data.Context.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		data.Context.TraceID = newTraceID()
+		data.Context.Sampled = rand.Float64() < t.opts.SampleRate
+	}
+	data.Context.SpanID = newSpanID()
+	s := &Span{tracer: t, data: data}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// UserRedactor returns Options.RedactUser, or nil if user IDs are recorded as they are.
+func (t *Tracer) UserRedactor() func(core.UserID) string {
+	return t.opts.RedactUser
+}
+
+// Shutdown exports the spans still queued and stops the background exporter. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.opts.Exporter == nil {
+		return nil
+	}
+	var err error
+	t.closed.Do(func() {
+		flushed := make(chan struct{})
+		select {
+		case t.flush <- flushed:
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return err
+}
+
+// export queues a finished span, dropping it if the exporter cannot keep up
+func (t *Tracer) export(data SpanData) {
+	if t.opts.Exporter == nil {
+		return
+	}
+	select {
+	case t.queue <- data:
+	default:
+		if t.dropped.Add(1) == 1 && t.opts.OnError != nil {
+			t.opts.OnError(errQueueFull)
+		}
+	}
+}
+
+var errQueueFull = errors.New("span queue full, dropping spans")
+
+// run exports queued spans in batches until Shutdown
+func (t *Tracer) run() {
+	batch := make([]SpanData, 0, t.opts.BatchSize)
+	ticker := time.NewTicker(t.opts.BatchTimeout)
+	defer ticker.Stop()
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.opts.Exporter.Export(ctx, batch); err != nil && t.opts.OnError != nil {
+			t.opts.OnError(err)
+		}
+		cancel()
+		batch = make([]SpanData, 0, t.opts.BatchSize)
+		t.dropped.Store(0)
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) >= t.opts.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			send()
+			close(flushed)
+			return
+		}
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// parentFrom returns the context of the local span or remote parent in ctx
+func parentFrom(ctx context.Context) (SpanContext, bool) {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.data.Context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract attaches the trace context of an incoming traceparent header to ctx, so the next span
+// started continues the caller's trace. Missing or malformed headers leave ctx unchanged.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := ParseTraceparent(h.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject sets the traceparent header for an outgoing request from the span in ctx, so the
+// receiving service joins the trace. Without a span, h is left unchanged.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := parentFrom(ctx); ok && sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault installs the tracer used by Start; nil disables tracing.
+func SetDefault(t *Tracer) { defaultTracer.Store(t) }
+
+// Default returns the tracer installed with SetDefault, or nil.
+func Default() *Tracer { return defaultTracer.Load() }
+
+// Start begins an internal span with the default tracer. While tracing is disabled it returns ctx
+// unchanged and a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t := defaultTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name, KindInternal)
+}
+
+func newTraceID() (id TraceID) {
+	for id == (TraceID{}) {
+		hi, lo := rand.Uint64(), rand.Uint64()
+		for i := 0; i < 8; i++ {
+			id[i], id[8+i] = byte(hi>>(56-8*i)), byte(lo>>(56-8*i))
+		}
+	}
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	for id == (SpanID{}) {
+		v := rand.Uint64()
+		for i := 0; i < 8; i++ {
+			id[i] = byte(v >> (56 - 8*i))
+		}
+	}
+	return id
+}
@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gamifykit/core"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recordingExporter) Export(_ context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTraceparent_RoundTrip(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	require.True(t, ok)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, header, sc.Traceparent())
+
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	} {
+		_, ok := ParseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestTracer_ChildSpansAndRedaction(t *testing.T) {
+	exp := &recordingExporter{}
+	tr := NewTracer(Options{SampleRate: 1, Exporter: exp, RedactUser: func(u core.UserID) string { return "h:" + string(u) }})
+
+	ctx, root := tr.Start(context.Background(), "root", KindServer)
+	_, child := tr.Start(ctx, "child", KindInternal)
+	child.SetUser("alice")
+	child.End(errors.New("boom"))
+	child.End(nil)
+	root.End(nil)
+	require.NoError(t, tr.Shutdown(context.Background()))
+
+	require.Len(t, exp.spans, 2)
+	got := exp.spans[0]
+	assert.Equal(t, "child", got.Name)
+	assert.Equal(t, root.Context().TraceID, got.Context.TraceID)
+	assert.Equal(t, root.Context().SpanID, got.Parent)
+	assert.Equal(t, "boom", got.Error)
+	assert.Equal(t, []Attr{{"user.id", "h:alice"}}, got.Attrs)
+	assert.Equal(t, SpanID{}, exp.spans[1].Parent)
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	exp := &recordingExporter{}
+	tr := NewTracer(Options{SampleRate: 0, Exporter: exp})
+	_, s := tr.Start(context.Background(), "unsampled", KindInternal)
+	s.End(nil)
+
+	// a sampled caller overrides the local rate
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, s = tr.Start(Extract(context.Background(), h), "remote", KindServer)
+	s.End(nil)
+	require.NoError(t, tr.Shutdown(context.Background()))
+
+	require.Len(t, exp.spans, 1)
+	assert.Equal(t, "remote", exp.spans[0].Name)
+	assert.Equal(t, "00f067aa0ba902b7", exp.spans[0].Parent.String())
+}
+
+func TestStart_DisabledIsNoop(t *testing.T) {
+	SetDefault(nil)
+	ctx := context.Background()
+	got, span := Start(ctx, "noop")
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, got)
+	span.SetAttr("k", "v")
+	span.SetUser("alice")
+	span.End(nil)
+
+	h := http.Header{}
+	Inject(got, h)
+	assert.Empty(t, h.Get(TraceparentHeader))
+}
+
+func TestInject_PropagatesCurrentSpan(t *testing.T) {
+	tr := NewTracer(Options{SampleRate: 1})
+	ctx, span := tr.Start(context.Background(), "outgoing", KindClient)
+	h := http.Header{}
+	Inject(ctx, h)
+	sc, ok := ParseTraceparent(h.Get(TraceparentHeader))
+	require.True(t, ok)
+	assert.Equal(t, span.Context(), sc)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]any
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		raw, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(raw, &body))
+	}))
+	defer srv.Close()
+
+	exp, err := NewOTLPExporter(srv.URL, "gamifykit-test")
+	require.NoError(t, err)
+	start := time.Unix(0, 1000)
+	sc := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true}
+	err = exp.Export(context.Background(), []SpanData{{
+		Name: "engine.AddPoints", Kind: KindInternal, Context: sc, Parent: SpanID{3}, Start: start, End: start.Add(time.Microsecond),
+		Attrs: []Attr{{"metric", "xp"}, {"delta", int64(5)}}, Error: "boom",
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/traces", path)
+
+	rs := body["resourceSpans"].([]any)[0].(map[string]any)
+	service := rs["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	assert.Equal(t, "gamifykit-test", service["value"].(map[string]any)["stringValue"])
+	span := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)[0].(map[string]any)
+	assert.Equal(t, sc.TraceID.String(), span["traceId"])
+	assert.Equal(t, SpanID{3}.String(), span["parentSpanId"])
+	assert.Equal(t, "1000", span["startTimeUnixNano"])
+	assert.Equal(t, "2000", span["endTimeUnixNano"])
+	assert.Equal(t, map[string]any{"stringValue": "xp"}, span["attributes"].([]any)[0].(map[string]any)["value"])
+	assert.Equal(t, map[string]any{"intValue": "5"}, span["attributes"].([]any)[1].(map[string]any)["value"])
+	assert.Equal(t, float64(2), span["status"].(map[string]any)["code"])
+
+	_, err = NewOTLPExporter("collector:4318", "x")
+	assert.Error(t, err)
+}